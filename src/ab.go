@@ -0,0 +1,215 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/http"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Blind A/B ======
+
+type (
+    ABSide    = client.ABSide
+    ABPick    = client.ABPick
+    ABSession = client.ABSession
+    ABBlind   = client.ABBlind
+    ABLabel   = client.ABLabel
+    ABResults = client.ABResults
+)
+
+// blindAB is what listeners see of ab before they pick.
+func blindAB(ab *ABSession) ABBlind {
+    out := ABBlind{ID: ab.ID, Creator: ab.Creator, Created: ab.Created, Sides: []ABLabel{}, Picks: len(ab.Picks)}
+    for _, sd := range ab.Sides {
+        out.Sides = append(out.Sides, ABLabel{Label: sd.Label, Stream: fmt.Sprintf("/api/ab/%s/stream/%s", ab.ID, sd.Label)})
+    }
+    return out
+}
+
+// silentLUFS stands in for the loudness of digital silence.
+const silentLUFS = -70.0
+
+// loudness measures a WAV once per revision.
+func (s *Server) loudness(ctx context.Context, f FileRef) (float64, error) {
+    key := f.Path + "@" + f.ServerModified.String()
+    s.alsMu.Lock(); v, ok := s.loudCache[key]; s.alsMu.Unlock()
+    if ok { return v, nil }
+    body, err := s.analysisDownload(ctx, f.Path)
+    if err != nil { return 0, err }
+    defer body.Close()
+    ws, err := readWAV(body)
+    if err != nil { return 0, fmt.Errorf("%s: %w", f.Name, err) }
+    v, err = ws.integratedLoudness()
+    if err != nil { return 0, fmt.Errorf("%s: %w", f.Name, err) }
+    v = max(v, silentLUFS)
+    s.alsMu.Lock(); s.loudCache[key] = v; s.alsMu.Unlock()
+    return v, nil
+}
+
+func (s *Server) abSession(id string) *ABSession {
+    var out *ABSession
+    s.store.view(func(d *storeData) {
+        if ab := d.AB[id]; ab != nil {
+            c := *ab
+            c.Picks = append([]ABPick(nil), ab.Picks...)
+            out = &c
+        }
+    })
+    return out
+}
+
+// GET  /api/ab
+// POST /api/ab {"a":{"track":"ENERGY","artifact":{...}},"b":{...}}
+// Both sides are measured and the louder one is turned down to match.
+func (s *Server) handleABSessions(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        var all []ABBlind
+        tracks := map[string][]string{} // key: session ID
+        s.store.view(func(d *storeData) {
+            for _, ab := range d.AB { all = append(all, blindAB(ab)); tracks[ab.ID] = abTracks(ab) }
+        })
+        out := []ABBlind{}
+        for _, ab := range all {
+            if !slices.ContainsFunc(tracks[ab.ID], func(t string) bool { return !s.mayAccess(r, t) }) { out = append(out, ab) }
+        }
+        sort.Slice(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+        writeJSON(w, out)
+
+    case http.MethodPost:
+        type sideReq struct {
+            Track    string      `json:"track"`
+            Artifact ArtifactRef `json:"artifact"`
+        }
+        var req struct{ A, B sideReq }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        var sides [2]ABSide
+        for i, sr := range []sideReq{req.A, req.B} {
+            s.mu.RLock(); t := s.tracks[sr.Track]; s.mu.RUnlock()
+            if t == nil || !s.mayAccess(r, t.Name) { http.Error(w, "track not found: "+sr.Track, 404); return }
+            f := sr.Artifact.File(t)
+            if f == nil { http.Error(w, sr.Artifact.String()+" has no audio file in "+t.Name, 404); return }
+            sides[i] = ABSide{Track: t.Name, Artifact: sr.Artifact, File: *f}
+        }
+        if sides[0].File.Path == sides[1].File.Path { http.Error(w, "a and b are the same file", 400); return }
+
+        var wg sync.WaitGroup
+        var errs [2]error
+        for i := range sides {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                sides[i].Loudness, errs[i] = s.loudness(r.Context(), sides[i].File)
+            }()
+        }
+        wg.Wait()
+        for _, err := range errs {
+            if err != nil { http.Error(w, "measure loudness: "+err.Error(), 422); return }
+        }
+        target := min(sides[0].Loudness, sides[1].Loudness)
+        for i := range sides { sides[i].GainDB = math.Round((target-sides[i].Loudness)*100) / 100 }
+
+        var coin [1]byte
+        rand.Read(coin[:])
+        if coin[0]&1 == 1 { sides[0], sides[1] = sides[1], sides[0] }
+        sides[0].Label, sides[1].Label = "A", "B"
+
+        ab := &ABSession{ID: newID(), Creator: actorOf(r), Created: time.Now().UTC(), Sides: sides, Picks: []ABPick{}}
+        err := s.store.update(func(d *storeData) error {
+            if d.AB == nil { d.AB = map[string]*ABSession{} }
+            d.AB[ab.ID] = ab
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        files := []string{sides[0].File.Path, sides[1].File.Path}
+        sort.Strings(files) // label order would give the mapping away
+        s.audit(r, "ab-create", sides[0].Track, nil, map[string]any{"id": ab.ID, "files": files})
+        writeJSONStatus(w, http.StatusCreated, blindAB(ab))
+
+    default:
+        http.Error(w, "GET or POST required", 405)
+    }
+}
+
+// GET    /api/ab/{id}
+// GET    /api/ab/{id}/stream/{label}
+// POST   /api/ab/{id}/pick {"pick":"A","note":"..."}
+// GET    /api/ab/{id}/results   (only after the caller has picked)
+// DELETE /api/ab/{id}
+func (s *Server) handleAB(w http.ResponseWriter, r *http.Request) {
+    parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/ab/"), "/"), "/")
+    ab := s.abSession(parts[0])
+    if ab == nil || slices.ContainsFunc(abTracks(ab), func(t string) bool { return !s.mayAccess(r, t) }) { http.Error(w, "session not found", 404); return }
+    side := func(label string) *ABSide {
+        for i := range ab.Sides {
+            if strings.EqualFold(ab.Sides[i].Label, label) { return &ab.Sides[i] }
+        }
+        return nil
+    }
+
+    switch {
+    case len(parts) == 1 && r.Method == http.MethodGet:
+        writeJSON(w, blindAB(ab))
+
+    case len(parts) == 1 && r.Method == http.MethodDelete:
+        if ab.Creator != actorOf(r) { http.Error(w, "only "+ab.Creator+" can delete this session", 403); return }
+        if err := s.store.update(func(d *storeData) error { delete(d.AB, ab.ID); return nil }); err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "ab-delete", ab.Sides[0].Track, map[string]string{"id": ab.ID}, nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    case len(parts) == 3 && parts[1] == "stream" && r.Method == http.MethodGet:
+        sd := side(parts[2])
+        if sd == nil { http.Error(w, "no such side", 404); return }
+        body, err := s.streamDownload(r.Context(), sd.File)
+        if err != nil { http.Error(w, err.Error(), 502); return }
+        defer body.Close()
+        ws, err := readWAV(body)
+        if err != nil { http.Error(w, err.Error(), 422); return }
+        s.logAccess(r, AccessEvent{Kind: accessStream, Track: sd.Track, File: sd.File.Path})
+        w.Header().Set("Content-Type", "audio/wav")
+        w.Header().Set("Content-Length", fmt.Sprint(sd.File.Size))
+        w.Header().Set("Cache-Control", "no-store")
+        ws.copyWithGain(w, math.Pow(10, sd.GainDB/20))
+
+    case len(parts) == 2 && parts[1] == "pick" && r.Method == http.MethodPost:
+        var req struct{ Pick, Note string }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        sd := side(req.Pick)
+        if sd == nil { http.Error(w, "pick must be A or B", 400); return }
+        p := ABPick{Listener: actorOf(r), Pick: sd.Label, Note: strings.TrimSpace(req.Note), At: time.Now().UTC()}
+        err := s.store.update(func(d *storeData) error {
+            cur := d.AB[ab.ID]
+            if cur == nil { return httpError{404, "session not found"} }
+            for i := range cur.Picks {
+                if cur.Picks[i].Listener == p.Listener { cur.Picks[i] = p; return nil } // changed their mind
+            }
+            cur.Picks = append(cur.Picks, p)
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "ab-pick", ab.Sides[0].Track, nil, map[string]any{"id": ab.ID, "pick": p})
+        writeJSON(w, p)
+
+    case len(parts) == 2 && parts[1] == "results" && r.Method == http.MethodGet:
+        me := actorOf(r)
+        picked := false
+        for _, p := range ab.Picks { picked = picked || p.Listener == me }
+        if !picked { http.Error(w, "pick first; results are revealed after your pick", 403); return }
+        tally := map[string]int{}
+        for _, p := range ab.Picks { tally[p.Pick]++ }
+        writeJSON(w, ABResults{ID: ab.ID, Sides: ab.Sides, Picks: ab.Picks, Tally: tally})
+
+    default:
+        http.Error(w, "not found", 404)
+    }
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "bytes"
+    "cmp"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ====== Access Log ======
+//
+// A security log of who got at what, apart from the audit log of changes, for
+// label compliance on pre-release material: failed authentication (missing or
+// bad keys, refused scopes and roles, bad signatures, wrong share passwords,
+// refused logins), share link views, plays and downloads, and every file the
+// server hands out: temporary links, A/B streams and session bundles. Events
+// are appended as JSON lines to DATA_DIR/access.jsonl, which moves to
+// access.jsonl.1 once it reaches ACCESS_LOG_MAX_MB (64), and are listed to
+// admins by GET /api/access.
+
+// Access event kinds.
+const (
+    accessAuthFailure = "auth-failure"
+    accessLink        = "link"
+    accessStream      = "stream"
+    accessBundle      = "bundle"
+    // and "share-" + the share event kind: share-view, share-play, share-download
+)
+
+// AccessEvent is one entry of the access log.
+type AccessEvent struct {
+    Time    time.Time `json:"time"`
+    Kind    string    `json:"kind"`
+    Actor   string    `json:"actor"`
+    Claimed string    `json:"claimed,omitempty"` // who X-AVCS-User named, if not the actor
+    IP      string    `json:"ip"`
+    Track   string    `json:"track,omitempty"`
+    File    string    `json:"file,omitempty"`   // path, or a shared file's name
+    Share   string    `json:"share,omitempty"`  // token of the share link used
+    Detail  string    `json:"detail,omitempty"` // why authentication failed
+    Agent   string    `json:"agent,omitempty"`
+}
+
+type accessLog struct {
+    mu       sync.Mutex
+    path     string
+    maxBytes int64
+}
+
+// append writes e, rotating the file first if it is full.
+func (l *accessLog) append(e AccessEvent) error {
+    b, err := json.Marshal(e)
+    if err != nil { return err }
+    l.mu.Lock(); defer l.mu.Unlock()
+    if st, err := os.Stat(l.path); err == nil && st.Size()+int64(len(b)) >= l.maxBytes {
+        if err := os.Rename(l.path, l.path+".1"); err != nil { return err }
+    }
+    f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+    if err != nil { return err }
+    defer f.Close()
+    _, err = f.Write(append(b, '\n'))
+    return err
+}
+
+// read returns up to limit events that match, newest first.
+func (l *accessLog) read(match func(*AccessEvent) bool, limit int) ([]AccessEvent, error) {
+    l.mu.Lock(); defer l.mu.Unlock()
+    out := []AccessEvent{}
+    for _, p := range []string{l.path, l.path + ".1"} {
+        b, err := os.ReadFile(p)
+        if os.IsNotExist(err) { continue }
+        if err != nil { return nil, err }
+        lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+        for i := len(lines) - 1; i >= 0 && len(out) < limit; i-- {
+            var e AccessEvent
+            if json.Unmarshal(lines[i], &e) != nil { continue }
+            if match(&e) { out = append(out, e) }
+        }
+    }
+    return out, nil
+}
+
+// logAccess records an access event for r. Failing to write it is logged
+// but does not refuse the request.
+func (s *Server) logAccess(r *http.Request, e AccessEvent) {
+    e.Time, e.IP, e.Agent = time.Now().UTC(), s.clientIP(r), truncate(r.UserAgent(), 200)
+    // A security log takes no one's word for who they are.
+    if e.Actor == "" { e.Actor = cmp.Or(authenticatedAs(r), "anonymous") }
+    e.Claimed = claimedActor(r, e.Actor)
+    if e.Actor == "anonymous" && r.URL.Query().Get("sig") != "" { e.Actor = "signed URL" }
+    if err := s.accessLog.append(e); err != nil { slog.ErrorContext(r.Context(), "access log write failed", "error", err) }
+}
+
+// authFailed records a refused authentication or authorization by actor
+// (by default, whoever r's key or session shows; anonymous if neither).
+func (s *Server) authFailed(r *http.Request, actor, detail string) {
+    s.logAccess(r, AccessEvent{Kind: accessAuthFailure, Actor: actor, File: r.URL.Path, Detail: detail})
+}
+
+// GET /api/access[?kind=&actor=&ip=&track=&share=&since=&until=&limit=]
+// Events are returned newest first.
+func (s *Server) handleAccess(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    q := r.URL.Query()
+    since, err := parseSince(q.Get("since"))
+    if err != nil { http.Error(w, "bad since: "+err.Error(), 400); return }
+    until, err := parseSince(q.Get("until"))
+    if err != nil { http.Error(w, "bad until: "+err.Error(), 400); return }
+    limit := 200
+    if v := q.Get("limit"); v != "" {
+        if limit, err = strconv.Atoi(v); err != nil || limit <= 0 { http.Error(w, "bad limit", 400); return }
+    }
+    kind, actor, ip, track, share := q.Get("kind"), q.Get("actor"), q.Get("ip"), q.Get("track"), q.Get("share")
+    out, err := s.accessLog.read(func(e *AccessEvent) bool {
+        return (kind == "" || e.Kind == kind) && (actor == "" || strings.EqualFold(e.Actor, actor)) && (ip == "" || e.IP == ip) &&
+            (track == "" || strings.EqualFold(e.Track, track)) && (share == "" || e.Share == share) &&
+            (since.IsZero() || !e.Time.Before(since)) && (until.IsZero() || e.Time.Before(until))
+    }, limit)
+    if err != nil { http.Error(w, err.Error(), 500); return }
+    writeJSON(w, out)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Track Aliases ======
+//
+// Songs get retitled mid-project. A declared alias makes files still named
+// after the old title ("DEMO_7-0430A.als") index into the new track
+// ("MIDNIGHT"); branches follow their parent (DEMO_7.RADIO_EDIT -> MIDNIGHT.RADIO_EDIT).
+
+type TrackAlias = client.TrackAlias
+
+var rxTrackName = regexp.MustCompile(`^[A-Z0-9_]+$`)
+
+// aliasMap is From -> To for every declared alias.
+func (s *Server) aliasMap() map[string]string {
+    m := map[string]string{}
+    s.store.view(func(d *storeData) {
+        for _, a := range d.Aliases { m[a.From] = a.To }
+    })
+    return m
+}
+
+// canonicalName follows aliases from name's base, keeping any branch suffix.
+func canonicalName(m map[string]string, name string) string {
+    base, branch, hasBranch := strings.Cut(name, ".")
+    for range len(m) {
+        to, ok := m[base]
+        if !ok { break }
+        base = to
+    }
+    if hasBranch { return base + "." + branch }
+    return base
+}
+
+// formerNames lists, per canonical base name, every alias that leads to it.
+func formerNames(m map[string]string) map[string][]string {
+    out := map[string][]string{}
+    for from := range m {
+        to := canonicalName(m, from)
+        out[to] = append(out[to], from)
+    }
+    for _, list := range out { sort.Strings(list) }
+    return out
+}
+
+// trackAliases are t's former names, with t's branch suffix where it has one.
+func trackAliases(former map[string][]string, t *Track) []string {
+    base := t.Name
+    if t.Parent != "" { base = t.Parent }
+    var out []string
+    for _, f := range former[base] {
+        if t.Branch != "" { f += "." + t.Branch }
+        out = append(out, f)
+    }
+    return out
+}
+
+// lookupTrack finds a track by its current or any former name.
+func (s *Server) lookupTrack(name string) *Track {
+    s.mu.RLock(); t := s.tracks[name]; s.mu.RUnlock()
+    if t != nil { return t }
+    c := canonicalName(s.aliasMap(), name)
+    s.mu.RLock(); defer s.mu.RUnlock()
+    return s.tracks[c]
+}
+
+// moveTrackState re-keys everything the store holds for from (and its
+// branches) to to, so notes, tags and status survive the rename.
+func moveTrackState(d *storeData, from, to string) {
+    rekey := func(name string) (string, bool) {
+        if name == from { return to, true }
+        if strings.HasPrefix(name, from+".") { return to + strings.TrimPrefix(name, from), true }
+        return "", false
+    }
+    moveKeys(d.Annotations, rekey)
+    moveKeys(d.Tags, rekey)
+    moveKeys(d.Locks, rekey)
+    moveKeys(d.Comments, rekey)
+    moveKeys(d.Ratings, rekey)
+    moveKeys(d.Deprecations, rekey)
+    moveKeys(d.Versions, rekey)
+    moveKeys(d.Published, rekey)
+    for k, v := range d.Artwork {
+        n, ok := rekey(k)
+        if !ok { continue }
+        if d.Artwork[n] == "" { d.Artwork[n] = v }
+        delete(d.Artwork, k)
+    }
+    for k, v := range d.Restrictions {
+        n, ok := rekey(k)
+        if !ok { continue }
+        if d.Restrictions[n] == nil { d.Restrictions[n] = v }
+        delete(d.Restrictions, k)
+    }
+    for k, v := range d.Status {
+        n, ok := rekey(k)
+        if !ok { continue }
+        if d.Status[n] == nil { d.Status[n] = v } // the new name's own workflow wins
+        delete(d.Status, k)
+    }
+    // Readers hold copies sharing these slices: replace, don't modify.
+    for id, rel := range d.Releases {
+        c := *rel
+        c.Tracks = append([]ReleaseTrack(nil), rel.Tracks...)
+        for i := range c.Tracks {
+            if n, ok := rekey(c.Tracks[i].Track); ok { c.Tracks[i].Track = n }
+        }
+        d.Releases[id] = &c
+    }
+    for id, dl := range d.Deadlines {
+        if n, ok := rekey(dl.Track); ok { c := *dl; c.Track = n; d.Deadlines[id] = &c }
+    }
+    for k, b := range d.Baselines {
+        if n, ok := rekey(b.Track); ok { c := *b; c.Track = n; d.Baselines[k] = &c }
+    }
+    for id, ab := range d.AB {
+        c := *ab
+        for i := range c.Sides {
+            if n, ok := rekey(c.Sides[i].Track); ok { c.Sides[i].Track = n }
+        }
+        d.AB[id] = &c
+    }
+    for tok, sh := range d.Shares {
+        if n, ok := rekey(sh.Track); ok { c := *sh; c.Track = n; d.Shares[tok] = &c }
+    }
+}
+
+func moveKeys[V any](m map[string][]V, rekey func(string) (string, bool)) {
+    for k, v := range m {
+        if n, ok := rekey(k); ok { m[n] = append(m[n], v...); delete(m, k) }
+    }
+}
+
+// GET  /api/aliases
+// POST /api/aliases {"from":"DEMO_7","to":"MIDNIGHT"}
+// The index is rebuilt so the old files land in the new track at once.
+func (s *Server) handleAliases(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        out := []TrackAlias{}
+        s.store.view(func(d *storeData) { out = append(out, d.Aliases...) })
+        sort.Slice(out, func(i, j int) bool { return out[i].From < out[j].From })
+        writeJSON(w, out)
+
+    case http.MethodPost:
+        var req struct{ From, To string }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        a := TrackAlias{From: strings.TrimSpace(req.From), To: strings.TrimSpace(req.To), By: actorOf(r), Created: time.Now().UTC()}
+        if !rxTrackName.MatchString(a.From) || !rxTrackName.MatchString(a.To) { http.Error(w, "from and to must be track names ([A-Z0-9_]+, no branch)", 400); return }
+        if a.From == a.To { http.Error(w, "from and to are the same", 400); return }
+        err := s.store.update(func(d *storeData) error {
+            m := map[string]string{}
+            for _, x := range d.Aliases {
+                if x.From == a.From { return httpError{409, a.From + " is already an alias of " + x.To} }
+                m[x.From] = x.To
+            }
+            if canonicalName(m, a.To) == a.From { return httpError{409, "alias would loop back to " + a.From} }
+            d.Aliases = append(d.Aliases, a)
+            moveTrackState(d, a.From, canonicalName(m, a.To))
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "alias", a.To, nil, a)
+        if err := s.reindex(r.Context()); err != nil { http.Error(w, "alias saved, reindex failed: "+err.Error(), 502); return }
+        writeJSONStatus(w, http.StatusCreated, a)
+
+    default:
+        http.Error(w, "GET or POST required", 405)
+    }
+}
+
+// DELETE /api/aliases/{from}
+// Files named from index as their own track again; moved notes stay where they are.
+func (s *Server) handleAlias(w http.ResponseWriter, r *http.Request) {
+    from := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/aliases/"), "/")
+    if r.Method != http.MethodDelete { http.Error(w, "DELETE required", 405); return }
+    var removed TrackAlias
+    err := s.store.update(func(d *storeData) error {
+        for i, a := range d.Aliases {
+            if a.From != from { continue }
+            removed = a
+            d.Aliases = append(d.Aliases[:i:i], d.Aliases[i+1:]...)
+            return nil
+        }
+        return httpError{404, "alias not found"}
+    })
+    if err != nil { writeError(w, err); return }
+    s.audit(r, "unalias", removed.To, removed, nil)
+    if err := s.reindex(r.Context()); err != nil { http.Error(w, "alias removed, reindex failed: "+err.Error(), 502); return }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// renamesOf are the aliases leading to t's base name, oldest first.
+func (s *Server) renamesOf(t *Track) []TrackAlias {
+    base := t.Name
+    if t.Parent != "" { base = t.Parent }
+    var out []TrackAlias
+    s.store.view(func(d *storeData) {
+        m := map[string]string{}
+        for _, a := range d.Aliases { m[a.From] = a.To }
+        for _, a := range d.Aliases {
+            if canonicalName(m, a.From) == base { out = append(out, a) }
+        }
+    })
+    sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+    return out
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+    "compress/gzip"
+    "context"
+    "encoding/xml"
+    "io"
+    "net/http"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Ableton Live Set Parsing ======
+
+type Locator = client.Locator
+
+// alsSample is a sample file referenced by a clip or instrument in the set.
+type alsSample struct {
+    RelativePath string // relative to the project folder, when collected
+    Path         string // absolute path on the machine that saved the set
+    Size         int64  // original file size recorded by Live, if any
+}
+
+type alsInfo struct {
+    Tempo    float64     `json:"tempo"`
+    Locators []Locator   `json:"locators"`
+    Samples  []alsSample `json:"-"`
+}
+
+// parseALS reads a gzip-compressed .als document. Positions are converted from
+// beats to seconds with the master tempo; tempo automation is not followed.
+func parseALS(r io.Reader) (*alsInfo, error) {
+    zr, err := gzip.NewReader(r)
+    if err != nil { return nil, err }
+    defer zr.Close()
+
+    info := &alsInfo{}
+    dec := xml.NewDecoder(zr)
+    var stack []string
+    var cur *Locator
+    inMaster := 0 // depth of MasterTrack/MainTrack (Live 12 renamed it)
+    inSample := 0 // depth of the SampleRef being read
+    var smp *alsSample
+    var relDirs []string // Live <= 10 stores RelativePath as RelativePathElement dirs
+    var smpName string
+    seen := map[string]bool{}
+    for {
+        tok, err := dec.Token()
+        if err == io.EOF { break }
+        if err != nil { return nil, err }
+        switch el := tok.(type) {
+        case xml.StartElement:
+            name := el.Name.Local
+            parent := ""
+            if len(stack) > 0 { parent = stack[len(stack)-1] }
+            stack = append(stack, name)
+            switch {
+            case (name == "MasterTrack" || name == "MainTrack") && inMaster == 0:
+                inMaster = len(stack)
+            case name == "Locator" && parent == "Locators":
+                cur = &Locator{}
+            case cur != nil && parent == "Locator" && name == "Time":
+                cur.Beat, _ = strconv.ParseFloat(attr(el, "Value"), 64)
+            case cur != nil && parent == "Locator" && name == "Name":
+                cur.Name = attr(el, "Value")
+            case inMaster > 0 && parent == "Tempo" && name == "Manual" && info.Tempo == 0:
+                info.Tempo, _ = strconv.ParseFloat(attr(el, "Value"), 64)
+            case name == "SampleRef" && inSample == 0:
+                inSample = len(stack)
+            case inSample > 0 && name == "FileRef" && parent == "SampleRef":
+                smp = &alsSample{}; relDirs = nil; smpName = ""
+            case smp != nil && parent == "FileRef":
+                switch name {
+                case "RelativePath": smp.RelativePath = attr(el, "Value")
+                case "Path": smp.Path = attr(el, "Value")
+                case "Name": smpName = attr(el, "Value")
+                case "OriginalFileSize": smp.Size, _ = strconv.ParseInt(attr(el, "Value"), 10, 64)
+                }
+            case smp != nil && name == "RelativePathElement":
+                relDirs = append(relDirs, attr(el, "Dir"))
+            case smp != nil && name == "FileSize" && smp.Size == 0:
+                smp.Size, _ = strconv.ParseInt(attr(el, "Value"), 10, 64)
+            }
+        case xml.EndElement:
+            if len(stack) == 0 { continue }
+            name := stack[len(stack)-1]
+            if name == "Locator" && cur != nil {
+                info.Locators = append(info.Locators, *cur)
+                cur = nil
+            }
+            if name == "FileRef" && smp != nil && len(stack) == inSample+1 {
+                if smp.RelativePath == "" && len(relDirs) > 0 && smpName != "" {
+                    smp.RelativePath = path.Join(append(relDirs, smpName)...)
+                }
+                if smp.Path == "" && smpName != "" && smp.RelativePath == "" { smp.Path = smpName }
+                key := smp.RelativePath
+                if key == "" { key = smp.Path }
+                if !seen[key] { seen[key] = true; info.Samples = append(info.Samples, *smp) }
+                smp = nil
+            }
+            if len(stack) == inMaster { inMaster = 0 }
+            if len(stack) == inSample { inSample = 0 }
+            stack = stack[:len(stack)-1]
+        }
+    }
+    sort.SliceStable(info.Locators, func(i, j int) bool { return info.Locators[i].Beat < info.Locators[j].Beat })
+    if info.Tempo > 0 {
+        for i := range info.Locators {
+            info.Locators[i].Seconds = info.Locators[i].Beat * 60 / info.Tempo
+        }
+    }
+    return info, nil
+}
+
+func attr(el xml.StartElement, name string) string {
+    for _, a := range el.Attr {
+        if a.Name.Local == name { return a.Value }
+    }
+    return ""
+}
+
+// alsInfo downloads and parses a Live set, caching by path and modification time.
+func (s *Server) alsInfo(ctx context.Context, ref *FileRef) (*alsInfo, error) {
+    key := ref.Path + "@" + ref.ServerModified.Format(time.RFC3339)
+    s.alsMu.Lock(); info := s.alsCache[key]; s.alsMu.Unlock()
+    if info != nil { return info, nil }
+
+    body, err := s.analysisDownload(ctx, ref.Path)
+    if err != nil { return nil, err }
+    defer body.Close()
+    info, err = parseALS(body)
+    if err != nil { return nil, err }
+
+    s.alsMu.Lock(); cachePut(s.alsCache, key, info); s.alsMu.Unlock()
+    return info, nil
+}
+
+// cachePut keeps v under key, a path@revision, dropping what is kept for the
+// path's other revisions so that re-saving a file does not grow the cache.
+func cachePut[V any](m map[string]V, key string, v V) {
+    p := key[:strings.LastIndex(key, "@")+1]
+    for k := range m {
+        if strings.HasPrefix(k, p) { delete(m, k) }
+    }
+    m[key] = v
+}
+
+func (s *Server) handleLocators(w http.ResponseWriter, r *http.Request, t *Track, t1 string) {
+    snap := findSnap(t, t1)
+    if snap == nil { http.Error(w, "snapshot not found", 404); return }
+    if snap.ALS == nil { http.Error(w, "snapshot has no .als", 404); return }
+    info, err := s.alsInfo(r.Context(), snap.ALS)
+    if err != nil { http.Error(w, err.Error(), 502); return }
+    out := map[string]any{"t1": t1, "tempo": info.Tempo, "locators": info.Locators}
+    if snap.WAV != nil { out["bounce"] = snap.WAV.Path }
+    writeJSON(w, out)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "io"
+    "log"
+    "log/slog"
+    "net/http"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// ====== Analysis Budget ======
+//
+// Analysing audio means downloading it whole: loudness for A/B sessions and
+// the catalog sync, Live sets and other sessions for tempo, locators and
+// samples, and every file of a rehashing verify. Left alone, a catalog sync
+// after a big delivery pulls gigabytes as fast as Dropbox sends them and
+// leaves the studio's line to nobody else. So those downloads share a
+// budget:
+//
+//   ANALYSIS_WORKERS      downloads at once (default 4); others wait their turn
+//   ANALYSIS_MBPS         megabits per second for all of them (0: no cap)
+//   ANALYSIS_WORKER_MBPS  megabits per second for each (0: no cap)
+//
+// Admins change them while the server runs with PUT /api/admin/analysis,
+// until it restarts; each replica keeps its own budget. Listening (temporary
+// links, streams, bundles, shared files) is not analysis and is never held
+// up. /api/metrics counts the bytes downloaded for analysis and the time
+// spent waiting for a turn or for bandwidth.
+
+const analysisChunk = 32 << 10 // bytes read, and paced, at a time
+
+// analysisBudget bounds the analysis downloads in flight and their
+// bandwidth; a nil budget bounds nothing.
+type analysisBudget struct {
+    mu      sync.Mutex
+    analysisSettings
+    busy    int
+    waiting int
+    freed   chan struct{} // closed when a turn is given back or the budget changes
+    all     pacer
+}
+
+// analysisSettings are the budget's settings, as set at /api/admin/analysis.
+type analysisSettings struct {
+    Workers    int     `json:"workers"`
+    Mbps       float64 `json:"mbps"`
+    WorkerMbps float64 `json:"worker_mbps"`
+}
+
+// analysisStatus is the budget as shown at /api/admin/analysis.
+type analysisStatus struct {
+    analysisSettings
+    Busy    int `json:"busy"`    // downloads going on
+    Waiting int `json:"waiting"` // downloads waiting for a turn
+}
+
+func init() {
+    metricHelp["avcs_analysis_bytes_total"] = "Bytes downloaded to analyse audio and sessions."
+    metricHelp["avcs_analysis_wait_seconds_total"] = "Time analysis downloads spent waiting, by reason: turn (ANALYSIS_WORKERS) or bandwidth."
+}
+
+// loadAnalysisBudget reads the budget's settings.
+func loadAnalysisBudget() *analysisBudget {
+    a := &analysisBudget{analysisSettings: analysisSettings{Workers: 4}, freed: make(chan struct{})}
+    if v := os.Getenv("ANALYSIS_WORKERS"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 { log.Fatalf("ANALYSIS_WORKERS must be a number of downloads, not %q", v) }
+        a.Workers = n
+    }
+    a.Mbps, a.WorkerMbps = envMbps("ANALYSIS_MBPS"), envMbps("ANALYSIS_WORKER_MBPS")
+    a.all.setMbps(a.Mbps)
+    return a
+}
+
+// envMbps reads a bandwidth setting in megabits per second.
+func envMbps(name string) float64 {
+    v := os.Getenv(name)
+    if v == "" { return 0 }
+    f, err := strconv.ParseFloat(v, 64)
+    if err != nil || f < 0 { log.Fatalf("%s must be megabits per second, or 0, not %q", name, v) }
+    return f
+}
+
+func (a *analysisBudget) status() analysisStatus {
+    a.mu.Lock(); defer a.mu.Unlock()
+    return analysisStatus{analysisSettings: a.analysisSettings, Busy: a.busy, Waiting: a.waiting}
+}
+
+// set changes the budget; downloads going on keep their own cap.
+func (a *analysisBudget) set(v analysisSettings) {
+    a.mu.Lock(); defer a.mu.Unlock()
+    a.analysisSettings = v
+    a.all.setMbps(v.Mbps)
+    close(a.freed); a.freed = make(chan struct{})
+}
+
+// acquire waits for a turn, and returns how to give it back with the cap
+// of the download taking it.
+func (a *analysisBudget) acquire(ctx context.Context) (release func(), mbps float64, err error) {
+    a.mu.Lock()
+    a.waiting++
+    for a.busy >= a.Workers {
+        freed := a.freed
+        a.mu.Unlock()
+        select {
+        case <-ctx.Done():
+            a.mu.Lock(); a.waiting--; a.mu.Unlock()
+            return nil, 0, ctx.Err()
+        case <-freed:
+        }
+        a.mu.Lock()
+    }
+    a.waiting--
+    a.busy++
+    mbps = a.WorkerMbps
+    a.mu.Unlock()
+    var once sync.Once
+    return func() {
+        once.Do(func() {
+            a.mu.Lock(); a.busy--
+            close(a.freed); a.freed = make(chan struct{})
+            a.mu.Unlock()
+        })
+    }, mbps, nil
+}
+
+// analysisDownload streams a file's content for analysis, within the
+// budget; the caller closes the body, which gives its turn back.
+func (s *Server) analysisDownload(ctx context.Context, p string) (io.ReadCloser, error) {
+    a := s.analysis
+    if a == nil { return s.dbxDownload(ctx, p) }
+    start := time.Now()
+    release, mbps, err := a.acquire(ctx)
+    if err != nil { return nil, err }
+    s.metrics.add("avcs_analysis_wait_seconds_total", time.Since(start).Seconds(), "reason", "turn")
+    body, err := s.dbxDownload(ctx, p)
+    if err != nil { release(); return nil, err }
+    own := &pacer{}
+    own.setMbps(mbps)
+    return &pacedBody{body: body, ctx: ctx, s: s, pacers: []*pacer{own, &a.all}, release: release}, nil
+}
+
+// pacer spaces reads so that they average a rate: each takes its place in
+// line, rate permitting, and waits for it.
+type pacer struct {
+    mu   sync.Mutex
+    rate float64   // bytes per second; 0: any
+    next time.Time // when the next byte may be read
+}
+
+func (p *pacer) setMbps(mbps float64) {
+    p.mu.Lock(); defer p.mu.Unlock()
+    p.rate = mbps * 1e6 / 8
+}
+
+// wait waits until n more bytes keep to the rate.
+func (p *pacer) wait(ctx context.Context, n int) (time.Duration, error) {
+    p.mu.Lock()
+    if p.rate <= 0 { p.mu.Unlock(); return 0, nil }
+    now := time.Now()
+    if p.next.Before(now) { p.next = now }
+    at := p.next
+    p.next = p.next.Add(time.Duration(float64(n) / p.rate * float64(time.Second)))
+    p.mu.Unlock()
+    d := time.Until(at)
+    if d <= 0 { return 0, nil }
+    t := time.NewTimer(d)
+    defer t.Stop()
+    select {
+    case <-ctx.Done(): return d, ctx.Err()
+    case <-t.C: return d, nil
+    }
+}
+
+// pacedBody is a download read no faster than its pacers allow. Not
+// reading holds the connection's window shut, which slows Dropbox down.
+type pacedBody struct {
+    body    io.ReadCloser
+    ctx     context.Context
+    s       *Server
+    pacers  []*pacer
+    release func()
+}
+
+func (b *pacedBody) Read(p []byte) (int, error) {
+    if len(p) > analysisChunk { p = p[:analysisChunk] }
+    n, err := b.body.Read(p)
+    b.s.metrics.add("avcs_analysis_bytes_total", float64(n))
+    for _, pc := range b.pacers {
+        d, werr := pc.wait(b.ctx, n)
+        if d > 0 { b.s.metrics.add("avcs_analysis_wait_seconds_total", d.Seconds(), "reason", "bandwidth") }
+        if werr != nil { return n, werr }
+    }
+    return n, err
+}
+
+func (b *pacedBody) Close() error {
+    b.release()
+    return b.body.Close()
+}
+
+// GET /api/admin/analysis
+// PUT /api/admin/analysis {"workers":2,"mbps":40,"worker_mbps":10}
+// Settings left out are kept; 0 Mbps is no cap.
+func (s *Server) handleAnalysis(w http.ResponseWriter, r *http.Request) {
+    if s.analysis == nil { http.NotFound(w, r); return }
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, s.analysis.status())
+    case http.MethodPut:
+        var req struct {
+            Workers    *int     `json:"workers"`
+            Mbps       *float64 `json:"mbps"`
+            WorkerMbps *float64 `json:"worker_mbps"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        before := s.analysis.status().analysisSettings
+        after := before
+        if req.Workers != nil { after.Workers = *req.Workers }
+        if req.Mbps != nil { after.Mbps = *req.Mbps }
+        if req.WorkerMbps != nil { after.WorkerMbps = *req.WorkerMbps }
+        if after.Workers < 1 { http.Error(w, "workers must be at least 1", 400); return }
+        if after.Mbps < 0 || after.WorkerMbps < 0 { http.Error(w, "mbps and worker_mbps must be megabits per second, or 0", 400); return }
+        s.analysis.set(after)
+        slog.InfoContext(r.Context(), "analysis budget changed", "workers", after.Workers, "mbps", after.Mbps, "worker_mbps", after.WorkerMbps)
+        s.audit(r, "analysis", "", before, after)
+        writeJSON(w, s.analysis.status())
+    default:
+        http.Error(w, "GET or PUT required", 405)
+    }
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Annotations ======
+
+type Annotation = client.Annotation
+
+// GET  /api/tracks/{name}/annotations[?kind=&t1=&t2=]
+// POST /api/tracks/{name}/annotations {"artifact":{...},"text":"..."}
+func (s *Server) handleAnnotations(w http.ResponseWriter, r *http.Request, t *Track) {
+    switch r.Method {
+    case http.MethodGet:
+        q := r.URL.Query()
+        filter := ArtifactRef{Kind: q.Get("kind"), T1: q.Get("t1"), T2: q.Get("t2")}
+        out := []Annotation{}
+        s.store.view(func(d *storeData) {
+            for _, a := range d.Annotations[t.Name] {
+                if filter.Kind != "" && !filter.MatchesLoose(a.Artifact) { continue }
+                out = append(out, a)
+            }
+        })
+        writeJSON(w, out)
+
+    case http.MethodPost:
+        var req struct {
+            Artifact ArtifactRef `json:"artifact"`
+            Text     string      `json:"text"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        req.Text = strings.TrimSpace(req.Text)
+        if req.Text == "" { http.Error(w, "text required", 400); return }
+        if err := req.Artifact.Resolve(t); err != nil { http.Error(w, err.Error(), 404); return }
+        a := Annotation{ID: newID(), Artifact: req.Artifact, Text: req.Text, Author: actorOf(r), Created: time.Now().UTC()}
+        err := s.store.update(func(d *storeData) error {
+            if d.Annotations == nil { d.Annotations = map[string][]Annotation{} }
+            d.Annotations[t.Name] = append(d.Annotations[t.Name], a)
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "annotate", t.Name, nil, a)
+        writeJSONStatus(w, http.StatusCreated, a)
+
+    default:
+        http.Error(w, "GET or POST required", 405)
+    }
+}
+
+func (s *Server) annotationsFor(track string) []Annotation {
+    var out []Annotation
+    s.store.view(func(d *storeData) { out = append(out, d.Annotations[track]...) })
+    return out
+}
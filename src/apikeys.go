@@ -0,0 +1,341 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "encoding/json"
+    "math"
+    "net/http"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ====== API Keys ======
+//
+// Once a key exists (or ADMIN_API_KEY is set, or web login is configured),
+// every /api request has to present one, as "Authorization: Bearer
+// avcs_<id>_<secret>" or X-API-Key, or come from a signed-in browser.
+// A key carries scopes:
+//
+//   read   GET anything that describes the catalog
+//   link   temporary links and streams of the files themselves
+//   write  every change (implies read)
+//   admin  everything, including managing keys and reading the audit log
+//
+// a role (see roles.go) and a rate limit in requests per minute (0: none).
+// Only a hash of each secret is stored; the key itself is shown once, when it
+// is created.
+// ADMIN_API_KEY is an admin key from the environment, for bootstrapping and
+// recovery, that is not stored or limited.
+
+const (
+    scopeRead  = "read"
+    scopeLink  = "link"
+    scopeWrite = "write"
+    scopeAdmin = "admin"
+)
+
+var apiScopes = []string{scopeRead, scopeLink, scopeWrite, scopeAdmin}
+
+const keyPrefix = "avcs_"
+
+// APIKey is a stored key, without its secret.
+type APIKey struct {
+    ID        string     `json:"id"`
+    Name      string     `json:"name"`
+    Scopes    []string   `json:"scopes"`
+    Role      string     `json:"role,omitempty"` // see roles.go; empty: producer
+    RateLimit int        `json:"rate_limit"`     // requests per minute; 0: unlimited
+    Hash      string     `json:"hash,omitempty"` // sha256 of the secret, hex
+    By        string     `json:"by"`
+    Created   time.Time  `json:"created"`
+    Expires   *time.Time `json:"expires,omitempty"`
+    LastUsed  *time.Time `json:"last_used,omitempty"` // filled in when listing
+}
+
+func (k *APIKey) has(scope string) bool {
+    return slices.Contains(k.Scopes, scopeAdmin) || slices.Contains(k.Scopes, scope) ||
+        scope == scopeRead && slices.Contains(k.Scopes, scopeWrite)
+}
+
+// public is k as shown to clients.
+func (k APIKey) public() APIKey { k.Hash = ""; return k }
+
+// keyLimiter is a token bucket per key, refilled at RateLimit per minute.
+type keyLimiter struct {
+    mu      sync.Mutex
+    buckets map[string]*keyBucket // key: key ID
+    idle    time.Duration         // drop buckets unused this long; 0: keep them
+    pruned  time.Time
+}
+
+type keyBucket struct {
+    tokens float64
+    last   time.Time
+    used   time.Time
+}
+
+// allow takes a token from id's bucket, or says how long until one is free.
+func (l *keyLimiter) allow(id string, perMinute int, now time.Time) (bool, time.Duration) {
+    l.mu.Lock(); defer l.mu.Unlock()
+    if l.idle > 0 && now.Sub(l.pruned) > l.idle {
+        for k, b := range l.buckets {
+            if now.Sub(b.used) > l.idle { delete(l.buckets, k) }
+        }
+        l.pruned = now
+    }
+    b := l.buckets[id]
+    if b == nil { b = &keyBucket{tokens: float64(perMinute), last: now}; l.buckets[id] = b }
+    b.used = now
+    if perMinute <= 0 { return true, 0 }
+    rate := float64(perMinute) / 60 // tokens per second
+    b.tokens = math.Min(float64(perMinute), b.tokens+now.Sub(b.last).Seconds()*rate)
+    b.last = now
+    if b.tokens < 1 { return false, time.Duration((1 - b.tokens) / rate * float64(time.Second)) }
+    b.tokens--
+    return true, 0
+}
+
+func (l *keyLimiter) lastUsed(id string) *time.Time {
+    l.mu.Lock(); defer l.mu.Unlock()
+    if b := l.buckets[id]; b != nil { t := b.used.UTC(); return &t }
+    return nil
+}
+
+type ctxKey string
+
+const (
+    ctxAPIKey ctxKey = "apikey"
+    ctxUser   ctxKey = "user" // signed in to the web UI
+)
+
+// requestKey is the key a request was authenticated with, if any.
+func requestKey(r *http.Request) *APIKey {
+    k, _ := r.Context().Value(ctxAPIKey).(*APIKey)
+    return k
+}
+
+// requiredScope is what a request needs.
+func requiredScope(r *http.Request) string {
+    p := r.URL.Path
+    switch {
+    case strings.HasPrefix(p, "/api/keys"), strings.HasPrefix(p, "/api/roles"), strings.HasPrefix(p, "/api/webhooks"), p == "/api/audit", p == "/api/access", p == "/api/logging", p == "/api/metrics",
+        strings.HasPrefix(p, "/api/debug/"), strings.HasPrefix(p, "/api/admin/"), strings.HasPrefix(p, "/api/tracks/") && strings.HasSuffix(strings.TrimSuffix(p, "/"), "/restriction"):
+        return scopeAdmin
+    case r.Method != http.MethodGet && r.Method != http.MethodHead:
+        return scopeWrite
+    case p == "/api/link", strings.HasPrefix(p, "/api/ab/") && strings.Contains(p, "/stream/"),
+        strings.HasPrefix(p, "/api/tracks/") && strings.HasSuffix(p, "/bundle"),
+        strings.HasPrefix(p, "/api/releases/") && (strings.HasSuffix(p, "/package") || strings.HasSuffix(p, "/ddp")):
+        return scopeLink
+    }
+    return scopeRead
+}
+
+// authEnabled reports whether requests need a key or a web session.
+func (s *Server) authEnabled() bool {
+    if s.adminKey != "" || s.oidc != nil { return true }
+    n := 0
+    s.store.view(func(d *storeData) { n = len(d.APIKeys) })
+    return n > 0
+}
+
+// authenticate finds the key presented by r; nil if there is none or it is not valid.
+func (s *Server) authenticate(r *http.Request) *APIKey {
+    secret := r.Header.Get("X-API-Key")
+    if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok { secret = strings.TrimSpace(v) }
+    if secret == "" { return nil }
+    if s.adminKey != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(s.adminKey)) == 1 {
+        return &APIKey{ID: "env", Name: "ADMIN_API_KEY", Scopes: []string{scopeAdmin}}
+    }
+    id, sec, ok := strings.Cut(strings.TrimPrefix(secret, keyPrefix), "_")
+    if !ok || !strings.HasPrefix(secret, keyPrefix) { return nil }
+    var k *APIKey
+    s.store.view(func(d *storeData) {
+        if cur := d.APIKeys[id]; cur != nil { c := *cur; k = &c }
+    })
+    if k == nil { return nil }
+    sum := sha256.Sum256([]byte(sec))
+    if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(k.Hash)) != 1 { return nil }
+    if k.Expires != nil && time.Now().After(*k.Expires) { return nil }
+    return k
+}
+
+// requireAuth guards /api with a web session or an API key once either is in
+// use, and sends browsers without a session to the login page. A signed URL
+// (see signing.go) stands in for both.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        p := r.URL.Path
+        if signedPath(p) {
+            switch err := s.checkSignature(r); {
+            case err == nil:
+                next.ServeHTTP(w, r); return
+            case err != errUnsigned:
+                s.authFailed(r, "", err.Error())
+                http.Error(w, err.Error(), 403); return
+            case s.signedURLs:
+                s.authFailed(r, "", "unsigned URL")
+                http.Error(w, "this URL must be signed; get one from /api/sign?url=...", 403); return
+            }
+        }
+        if strings.HasPrefix(p, "/auth/") || !s.authEnabled() { next.ServeHTTP(w, r); return }
+        if sess := s.sessionOf(r); sess != nil {
+            role := s.sessionRole(sess)
+            k := &APIKey{ID: "session", Name: sess.User, Scopes: roleScopes(role), Role: role}
+            if strings.HasPrefix(p, "/api/") {
+                if need := requiredScope(r); !k.has(need) { s.authFailed(r, sess.User, "no "+need+" scope"); http.Error(w, "the "+role+" role does not grant the "+need+" scope", 403); return }
+                if err := s.checkRole(r, role); err != nil { s.authFailed(r, sess.User, err.Error()); writeError(w, err); return }
+            }
+            if fetchPath(p) && !s.allowOrRefuse(w, s.limiter, "fetch:session:"+sess.User, s.fetchKeyRateLimit, "fetch_key") { return }
+            next.ServeHTTP(w, r.WithContext(context.WithValue(context.WithValue(r.Context(), ctxAPIKey, k), ctxUser, sess.User)))
+            return
+        }
+        if !strings.HasPrefix(p, "/api/") {
+            if p == "/" && s.oidc != nil { http.Redirect(w, r, "/auth/login", http.StatusFound); return }
+            next.ServeHTTP(w, r)
+            return
+        }
+        k := s.authenticate(r)
+        if k == nil {
+            detail := "no credentials"
+            if r.Header.Get("X-API-Key") != "" || r.Header.Get("Authorization") != "" { detail = "invalid API key" }
+            s.authFailed(r, "", detail)
+            w.Header().Set("WWW-Authenticate", `Bearer realm="avcs"`)
+            http.Error(w, "a valid API key is required", 401); return
+        }
+        if need := requiredScope(r); !k.has(need) { s.authFailed(r, "key:"+k.ID, "no "+need+" scope"); http.Error(w, "this key lacks the "+need+" scope", 403); return }
+        k.Role = keyRole(k)
+        if err := s.checkRole(r, k.Role); err != nil { s.authFailed(r, "key:"+k.ID, err.Error()); writeError(w, err); return }
+        if !s.allowOrRefuse(w, s.limiter, k.ID, k.RateLimit, "key") { return }
+        if fetchPath(p) && !s.allowOrRefuse(w, s.limiter, "fetch:"+k.ID, s.fetchKeyRateLimit, "fetch_key") { return }
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxAPIKey, k)))
+    })
+}
+
+// keyInput is the writable part of an APIKey; nil fields are left as they are.
+type keyInput struct {
+    Name      *string    `json:"name"`
+    Scopes    *[]string  `json:"scopes"`
+    Role      *string    `json:"role"`
+    RateLimit *int       `json:"rate_limit"`
+    Expires   *time.Time `json:"expires"`
+}
+
+func (in keyInput) apply(k *APIKey) error {
+    if in.Name != nil { k.Name = strings.TrimSpace(*in.Name) }
+    if in.Scopes != nil { k.Scopes = append([]string{}, *in.Scopes...) }
+    if in.Role != nil { k.Role = strings.ToLower(strings.TrimSpace(*in.Role)) }
+    if in.RateLimit != nil { k.RateLimit = *in.RateLimit }
+    if in.Expires != nil { e := in.Expires.UTC(); k.Expires = &e }
+    if k.Name == "" { return httpError{400, "name required"} }
+    if len(k.Scopes) == 0 { return httpError{400, "scopes required: " + strings.Join(apiScopes, ", ")} }
+    for i, sc := range k.Scopes {
+        k.Scopes[i] = strings.ToLower(strings.TrimSpace(sc))
+        if !slices.Contains(apiScopes, k.Scopes[i]) { return httpError{400, "scopes may be " + strings.Join(apiScopes, ", ")} }
+    }
+    if k.Role != "" && !slices.Contains(roleNames, k.Role) { return httpError{400, "role may be " + strings.Join(roleNames, ", ")} }
+    if k.Role != "" && k.Role != roleAdmin && slices.Contains(k.Scopes, scopeAdmin) { return httpError{400, "keys with the admin scope have the admin role"} }
+    slices.Sort(k.Scopes)
+    k.Scopes = slices.Compact(k.Scopes)
+    if k.RateLimit < 0 { return httpError{400, "rate_limit must be 0 (unlimited) or more"} }
+    return nil
+}
+
+// GET    /api/keys
+// POST   /api/keys {"name":"studio watcher","scopes":["write"],"role":"mix-engineer","rate_limit":60[,"expires":"2026-01-01T00:00:00Z"]}
+// GET    /api/keys/{id}
+// PATCH  /api/keys/{id} {"scopes":["read","link"]}
+// DELETE /api/keys/{id}   revokes the key
+// POST answers with the key, which is not shown again. rate_limit defaults
+// to API_KEY_RATE_LIMIT (or 120) requests per minute.
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+    id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/keys"), "/")
+    if id == "" {
+        switch r.Method {
+        case http.MethodGet:
+            out := []APIKey{}
+            s.store.view(func(d *storeData) {
+                for _, k := range d.APIKeys { out = append(out, k.public()) }
+            })
+            for i := range out { out[i].LastUsed = s.limiter.lastUsed(out[i].ID) }
+            sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+            writeJSON(w, out)
+
+        case http.MethodPost:
+            var in keyInput
+            if err := json.NewDecoder(r.Body).Decode(&in); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+            k := APIKey{ID: newID(), RateLimit: s.keyRateLimit, By: actorOf(r), Created: time.Now().UTC()}
+            if err := in.apply(&k); err != nil { writeError(w, err); return }
+            b := make([]byte, 24)
+            rand.Read(b)
+            secret := hex.EncodeToString(b)
+            sum := sha256.Sum256([]byte(secret))
+            k.Hash = hex.EncodeToString(sum[:])
+            err := s.store.update(func(d *storeData) error {
+                if d.APIKeys == nil { d.APIKeys = map[string]*APIKey{} }
+                if d.APIKeys[k.ID] != nil { return httpError{409, "key ID collision; try again"} }
+                d.APIKeys[k.ID] = &k
+                return nil
+            })
+            if err != nil { writeError(w, err); return }
+            s.audit(r, "key-create", "", nil, k.public())
+            writeJSONStatus(w, http.StatusCreated, map[string]any{"key": keyPrefix + k.ID + "_" + secret, "info": k.public()})
+
+        default:
+            http.Error(w, "GET or POST required", 405)
+        }
+        return
+    }
+
+    var k *APIKey
+    s.store.view(func(d *storeData) {
+        if cur := d.APIKeys[id]; cur != nil { c := *cur; k = &c }
+    })
+    if k == nil { http.Error(w, "key not found", 404); return }
+    switch r.Method {
+    case http.MethodGet:
+        out := k.public()
+        out.LastUsed = s.limiter.lastUsed(k.ID)
+        writeJSON(w, out)
+
+    case http.MethodPatch:
+        var in keyInput
+        if err := json.NewDecoder(r.Body).Decode(&in); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        before := k.public()
+        if err := in.apply(k); err != nil { writeError(w, err); return }
+        err := s.store.update(func(d *storeData) error {
+            if d.APIKeys[id] == nil { return httpError{404, "key not found"} }
+            d.APIKeys[id] = k
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "key-update", "", before, k.public())
+        writeJSON(w, k.public())
+
+    case http.MethodDelete:
+        err := s.store.update(func(d *storeData) error {
+            admins := 0
+            for _, o := range d.APIKeys {
+                if o.ID != id && o.has(scopeAdmin) { admins++ }
+            }
+            if s.adminKey == "" && k.has(scopeAdmin) && admins == 0 {
+                return httpError{409, "this is the last admin key; without it the API would be open to anyone"}
+            }
+            delete(d.APIKeys, id)
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "key-revoke", "", k.public(), nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "GET, PATCH or DELETE required", 405)
+    }
+}
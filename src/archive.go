@@ -0,0 +1,143 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "path"
+    "slices"
+    "strings"
+)
+
+// ====== Archive Root ======
+//
+// Retired artifacts, or whole tracks, move out of the working tree into a
+// separate Archive root (ARCHIVE_ROOT, default /Archive) laid out like the
+// Dropbox root. The indexer reads both; archived files carry archived=true
+// and are left out of listings unless asked for with ?archived=1.
+// (Not to be confused with a track's _archive/ folder of superseded files.)
+
+// inArchive reports whether p lies inside the Archive root.
+func (s *Server) inArchive(p string) bool {
+    return strings.HasPrefix(strings.ToLower(p), strings.ToLower(strings.TrimSuffix(s.archiveRoot, "/"))+"/")
+}
+
+// archivedPath is where p goes in the Archive root; livePath is the reverse.
+func (s *Server) archivedPath(p string) string {
+    return path.Join(s.archiveRoot, p[len(strings.TrimSuffix(s.dropboxRoot, "/")):])
+}
+
+func (s *Server) livePath(p string) string {
+    return path.Join(s.dropboxRoot, p[len(strings.TrimSuffix(s.archiveRoot, "/")):])
+}
+
+// hideArchived drops archived files from t in place, and artifacts left with
+// none; t must be a copy.
+func hideArchived(t *Track) {
+    live := func(f *FileRef) *FileRef { if f == nil || f.Archived { return nil }; return f }
+    var snaps []AbletonSnap
+    for _, a := range t.Ableton {
+        a.ALS, a.Session, a.WAV, a.MP3 = live(a.ALS), live(a.Session), live(a.WAV), live(a.MP3)
+        var backups []BackupRef
+        for _, b := range a.Backups {
+            if !b.Archived { backups = append(backups, b) }
+        }
+        a.Backups = backups
+        a.MIDI = slices.DeleteFunc(slices.Clone(a.MIDI), func(f FileRef) bool { return f.Archived })
+        if a.ALS != nil || a.Session != nil || a.WAV != nil || a.MP3 != nil || len(a.MIDI) > 0 || len(a.Backups) > 0 { snaps = append(snaps, a) }
+    }
+    var stems []StemsSet
+    for _, st := range t.Stems {
+        var files []FileRef
+        for _, f := range st.Stems {
+            if !f.Archived { files = append(files, f) }
+        }
+        if st.Stems = files; len(files) > 0 { stems = append(stems, st) }
+    }
+    var mixes []Mix
+    for _, m := range t.Mixes {
+        if !m.File.Archived { mixes = append(mixes, m) }
+    }
+    t.Prints = slices.DeleteFunc(slices.Clone(t.Prints), func(p Print) bool { return p.File.Archived })
+    var masters []MasterSet
+    for _, ms := range t.Masters {
+        var cands []FileRef
+        for _, c := range ms.Candidates {
+            if !c.Archived { cands = append(cands, c) }
+        }
+        ms.Candidates, ms.Final = cands, live(ms.Final)
+        if len(cands) > 0 || ms.Final != nil { masters = append(masters, ms) }
+    }
+    t.Ableton, t.Stems, t.Mixes, t.Masters = snaps, stems, mixes, masters
+    t.References = slices.DeleteFunc(slices.Clone(t.References), func(f FileRef) bool { return f.Archived })
+    t.Ideas = slices.DeleteFunc(slices.Clone(t.Ideas), func(i Idea) bool { return i.Archived })
+    t.Lyrics = slices.DeleteFunc(slices.Clone(t.Lyrics), func(l Lyric) bool { return l.Archived })
+}
+
+// archiveMoves plans the moves that archive (or, with restore, bring back)
+// the given artifacts of t, or the whole track folder when none are given.
+func (s *Server) archiveMoves(ctx context.Context, t *Track, refs []ArtifactRef, restore bool) ([][2]string, error) {
+    dest := s.archivedPath
+    if restore { dest = s.livePath }
+    var moves [][2]string
+    if len(refs) == 0 {
+        if t.Parent != "" { return nil, httpError{400, "a branch shares its parent's folder; name its artifacts instead"} }
+        if t.Dir == "" || !s.underRoot(t.Dir) && !s.inArchive(t.Dir) { return nil, httpError{400, t.Name + " has no track folder of its own"} }
+        if restore && !s.inArchive(t.Dir) { return nil, httpError{409, t.Name + " is not archived"} }
+        if !restore && s.inArchive(t.Dir) { return nil, httpError{409, t.Name + " is already archived"} }
+        moves = append(moves, [2]string{t.Dir, dest(t.Dir)})
+    } else {
+        files := trackFiles(t)
+        for _, ref := range refs {
+            n := 0
+            for _, f := range files {
+                if !ref.Matches(f.Artifact()) || f.Archived != restore { continue }
+                moves = append(moves, [2]string{f.Path, dest(f.Path)})
+                n++
+            }
+            if n == 0 && restore { return nil, httpError{404, "no archived files in " + ref.String()} }
+            if n == 0 { return nil, httpError{404, "no live files in " + ref.String()} }
+        }
+    }
+    for _, m := range moves {
+        if err := s.checkUploadTarget(ctx, m[1], false); err != nil { return nil, err }
+    }
+    return moves, nil
+}
+
+// POST   /api/tracks/{name}/archive {"artifacts":[{"kind":"mix","t1":"0430A","t2":"0720P"}]}
+// DELETE /api/tracks/{name}/archive {"artifacts":[...]}   restores
+// Without artifacts the whole track folder moves.
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request, t *Track) {
+    restore := r.Method == http.MethodDelete
+    if r.Method != http.MethodPost && !restore { http.Error(w, "POST or DELETE required", 405); return }
+    var req struct {
+        Artifacts []ArtifactRef `json:"artifacts"`
+    }
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+    }
+
+    s.writeMu.Lock()
+    s.mu.RLock(); t = s.tracks[t.Name]; s.mu.RUnlock()
+    if t == nil { s.writeMu.Unlock(); http.Error(w, "track not found", 404); return }
+    moves, err := s.archiveMoves(r.Context(), t, req.Artifacts, restore)
+    if err == nil { err = s.moveAll(r.Context(), moves) }
+    if err == nil {
+        err = s.store.update(func(d *storeData) error {
+            for _, m := range moves { moveBaselines(d, m[0], m[1]) }
+            return nil
+        })
+    }
+    s.writeMu.Unlock()
+    if err != nil { writeError(w, err); return }
+
+    moved := []map[string]string{}
+    for _, m := range moves { moved = append(moved, map[string]string{"from": m[0], "to": m[1]}) }
+    action := "archive"
+    if restore { action = "unarchive" }
+    s.audit(r, action, t.Name, nil, map[string]any{"artifacts": req.Artifacts, "moved": moved})
+    if err := s.reindex(r.Context()); err != nil { http.Error(w, fmt.Sprintf("%sd, but reindex failed: %v", action, err), 500); return }
+    writeJSON(w, map[string]any{"track": t.Name, "moved": moved})
+}
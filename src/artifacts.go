@@ -0,0 +1,19 @@
+package main
+
+import "avcs-browser/client"
+
+// ====== Artifact Addressing ======
+
+const kindStems = client.KindStems // a whole stems set, as opposed to one kindStem file
+
+// ArtifactRef addresses one versioned artifact of a track by its timestamps
+// (see client.ArtifactRef, with Matches, Resolve and File).
+type ArtifactRef = client.ArtifactRef
+
+// singleFile reports whether a names one audio file: a mix, a print, or a master candidate/FINAL.
+func singleFile(a ArtifactRef) bool { return a.Kind == kindMix || (a.Kind == kindMaster || a.Kind == kindPrint) && a.Idx != "" }
+
+func candidateIdx(ref FileRef) string {
+    np, _ := classifyName(ref.Name)
+    return np.Idx
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+    "bytes"
+    "cmp"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "image"
+    "image/color"
+    _ "image/gif"
+    "image/jpeg"
+    _ "image/png"
+    "io"
+    "mime"
+    "net/http"
+    "os"
+    "path"
+    "path/filepath"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ====== Artwork ======
+//
+// Cover art and other images for a track live in its artwork/ folder: every
+// JPEG, PNG or GIF there is indexed with the track. One of them is the
+// primary artwork, chosen explicitly or else the most recently changed; it is
+// what the UI shows and what a release without its own artwork uses.
+// Variants scaled to fit one of artworkSizes are made on demand as JPEG and
+// kept in DATA_DIR/artwork.
+
+const artworkDir = "artwork"
+
+const maxArtworkUpload = 32 << 20
+
+var (
+    artworkExts  = []string{"jpg", "jpeg", "png", "gif"}
+    artworkSizes = []int{64, 150, 300, 600, 1200}
+)
+
+// isArtwork reports whether e is an image directly in a track's artwork/ folder.
+func (s *Server) isArtwork(e *dbxEntry) bool {
+    if e.Tag != "file" || !slices.Contains(artworkExts, strings.TrimPrefix(path.Ext(e.lower()), ".")) { return false }
+    return strings.EqualFold(path.Dir(e.PathDisplay), s.trackDir(e.PathDisplay)+"/"+artworkDir)
+}
+
+// artworkOwner is the track whose primary artwork t shows: branches share
+// their parent's folder, and so its artwork.
+func artworkOwner(t *Track) string {
+    if t.Parent != "" { return t.Parent }
+    return t.Name
+}
+
+// primaryArtwork is the image chosen for t, or its newest.
+func (s *Server) primaryArtwork(t *Track) *FileRef {
+    var chosen string
+    s.store.view(func(d *storeData) { chosen = d.Artwork[artworkOwner(t)] })
+    var out *FileRef
+    for i := range t.Artwork {
+        a := &t.Artwork[i]
+        if chosen != "" && strings.EqualFold(a.Path, chosen) { return a }
+        if out == nil || a.ServerModified.After(out.ServerModified) { out = a }
+    }
+    return out
+}
+
+// findArtwork is the image of t with the given path or file name.
+func findArtwork(t *Track, which string) *FileRef {
+    for i := range t.Artwork {
+        if strings.EqualFold(t.Artwork[i].Path, which) || strings.EqualFold(t.Artwork[i].Name, which) { return &t.Artwork[i] }
+    }
+    return nil
+}
+
+// fitImage scales src down to fit within size x size, averaging the source
+// pixels under each output pixel, over a white background (JPEG has no alpha).
+// Images that already fit are returned as they are.
+func fitImage(src image.Image, size int) image.Image {
+    b := src.Bounds()
+    w, h := b.Dx(), b.Dy()
+    if w <= size && h <= size { return src }
+    nw, nh := size, size
+    if w > h { nh = max(1, h*size/w) } else { nw = max(1, w*size/h) }
+    dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+    for y := 0; y < nh; y++ {
+        y0, y1 := b.Min.Y+y*h/nh, b.Min.Y+(y+1)*h/nh
+        for x := 0; x < nw; x++ {
+            x0, x1 := b.Min.X+x*w/nw, b.Min.X+(x+1)*w/nw
+            var r, g, bl, a, n uint64
+            for sy := y0; sy < y1; sy++ {
+                for sx := x0; sx < x1; sx++ {
+                    cr, cg, cb, ca := src.At(sx, sy).RGBA() // alpha-premultiplied
+                    r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+                }
+            }
+            white := 0xffff - a/n
+            dst.SetRGBA(x, y, color.RGBA{uint8((r/n + white) >> 8), uint8((g/n + white) >> 8), uint8((bl/n + white) >> 8), 0xff})
+        }
+    }
+    return dst
+}
+
+// artworkVariant is the cached JPEG of f scaled to fit size, made if need be.
+func (s *Server) artworkVariant(ctx context.Context, f *FileRef, size int) (string, error) {
+    id := f.ContentHash
+    if id == "" { id = f.Path + "@" + f.ServerModified.String() }
+    sum := sha256.Sum256([]byte(id))
+    p := filepath.Join(s.dataDir, artworkDir, fmt.Sprintf("%s-%d.jpg", hex.EncodeToString(sum[:12]), size))
+    if _, err := os.Stat(p); err == nil { return p, nil }
+
+    body, err := s.dbxDownload(ctx, f.Path)
+    if err != nil { return "", httpError{502, err.Error()} }
+    defer body.Close()
+    img, _, err := image.Decode(io.LimitReader(body, maxArtworkUpload))
+    if err != nil { return "", httpError{422, f.Name + ": " + err.Error()} }
+    var buf bytes.Buffer
+    if err := jpeg.Encode(&buf, fitImage(img, size), &jpeg.Options{Quality: 85}); err != nil { return "", err }
+
+    // Written aside and renamed, so concurrent requests never serve half a file.
+    if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil { return "", err }
+    tmp := p + "." + newID()
+    if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil { return "", err }
+    return p, os.Rename(tmp, p)
+}
+
+// GET    /api/tracks/{name}/artwork                 the images and the primary one
+// POST   /api/tracks/{name}/artwork?name=cover.jpg[&primary=1][&overwrite=1]
+// PUT    /api/tracks/{name}/artwork/primary {"path":"..."} (or the file "name")
+// DELETE /api/tracks/{name}/artwork/primary         back to the newest image
+// GET    /api/tracks/{name}/artwork/image[?path=][&size=300]
+// Uploads take the image as the body, or a multipart "file" part like /api/upload.
+// image serves the primary artwork (or the one at path) as stored, or scaled
+// to fit size x size for one of artworkSizes.
+func (s *Server) handleArtwork(w http.ResponseWriter, r *http.Request, t *Track, parts []string) {
+    sub := ""
+    if len(parts) > 0 { sub = parts[0] }
+    switch {
+    case sub == "" && r.Method == http.MethodGet:
+        writeJSON(w, map[string]any{"primary": s.primaryArtwork(t), "images": append([]FileRef{}, t.Artwork...)})
+
+    case sub == "" && r.Method == http.MethodPost:
+        s.uploadArtwork(w, r, t)
+
+    case sub == "primary" && r.Method == http.MethodPut:
+        var req struct {
+            Path string `json:"path"`
+            Name string `json:"name"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        a := findArtwork(t, cmp.Or(req.Path, req.Name))
+        if a == nil { http.Error(w, "no such image in "+t.Name+"'s artwork", 404); return }
+        before := s.primaryArtwork(t)
+        owner := artworkOwner(t)
+        err := s.store.update(func(d *storeData) error {
+            if d.Artwork == nil { d.Artwork = map[string]string{} }
+            d.Artwork[owner] = a.Path
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "artwork-primary", owner, before, a)
+        writeJSON(w, a)
+
+    case sub == "primary" && r.Method == http.MethodDelete:
+        before := s.primaryArtwork(t)
+        owner := artworkOwner(t)
+        if err := s.store.update(func(d *storeData) error { delete(d.Artwork, owner); return nil }); err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "artwork-primary", owner, before, nil)
+        writeJSON(w, map[string]any{"primary": s.primaryArtwork(t)})
+
+    case sub == "image" && r.Method == http.MethodGet:
+        q := r.URL.Query()
+        a := s.primaryArtwork(t)
+        if p := q.Get("path"); p != "" { a = findArtwork(t, p) }
+        if a == nil { http.Error(w, "no artwork", 404); return }
+        if q.Get("size") == "" {
+            body, err := s.dbxDownload(r.Context(), a.Path)
+            if err != nil { http.Error(w, err.Error(), 502); return }
+            defer body.Close()
+            w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(a.Name)))
+            w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+            io.Copy(w, body)
+            return
+        }
+        size, _ := strconv.Atoi(q.Get("size"))
+        if !slices.Contains(artworkSizes, size) { http.Error(w, fmt.Sprintf("size must be one of %v", artworkSizes), 400); return }
+        p, err := s.artworkVariant(r.Context(), a, size)
+        if err != nil { writeError(w, err); return }
+        f, err := os.Open(p)
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        defer f.Close()
+        w.Header().Set("Content-Type", "image/jpeg")
+        w.Header().Set("ETag", `"`+strings.TrimSuffix(filepath.Base(p), ".jpg")+`"`)
+        w.Header().Set("Cache-Control", "no-cache")
+        http.ServeContent(w, r, "", time.Time{}, f)
+
+    default:
+        http.Error(w, "not found", 404)
+    }
+}
+
+// uploadArtwork stores an image in t's artwork/ folder.
+func (s *Server) uploadArtwork(w http.ResponseWriter, r *http.Request, t *Track) {
+    if t.Dir == "" { http.Error(w, t.Name+" has no folder", 409); return }
+    if t.Archived { http.Error(w, t.Name+" is archived", 409); return }
+    q := r.URL.Query()
+    name := q.Get("name")
+    r.Body = http.MaxBytesReader(w, r.Body, maxArtworkUpload)
+    var body io.Reader = r.Body
+    if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+        mr, err := r.MultipartReader()
+        if err != nil { http.Error(w, err.Error(), 400); return }
+        for {
+            part, err := mr.NextPart()
+            if err != nil { http.Error(w, "no file part", 400); return }
+            if part.FormName() != "file" { continue }
+            if name == "" { name = part.FileName() }
+            body = part
+            break
+        }
+    }
+    name = path.Base(strings.TrimSpace(name))
+    if name == "" || name == "." || name == "/" || strings.HasPrefix(name, ".") { http.Error(w, "name required", 400); return }
+    if !slices.Contains(artworkExts, strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))) {
+        http.Error(w, "artwork must be "+strings.Join(artworkExts, ", "), 415); return
+    }
+    data, err := io.ReadAll(body)
+    var tooBig *http.MaxBytesError
+    if errors.As(err, &tooBig) { http.Error(w, fmt.Sprintf("artwork is limited to %d MB", maxArtworkUpload>>20), 413); return }
+    if err != nil { http.Error(w, err.Error(), 400); return }
+    cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+    if err != nil { http.Error(w, "not an image: "+err.Error(), 415); return }
+
+    p := path.Join(t.Dir, artworkDir, name)
+    s.writeMu.Lock()
+    err = s.checkUploadTarget(r.Context(), p, q.Get("overwrite") != "")
+    var e *dbxEntry
+    if err == nil {
+        if e, err = s.dbxUpload(r.Context(), p, bytes.NewReader(data)); err != nil { err = httpError{502, "upload: " + err.Error()} }
+    }
+    s.writeMu.Unlock()
+    if err != nil { writeError(w, err); return }
+
+    ref := fileRefOf(e)
+    ref.ContributedBy = actorOf(r)
+    owner := artworkOwner(t)
+    out := map[string]any{"track": owner, "file": ref, "format": format, "width": cfg.Width, "height": cfg.Height}
+    if q.Get("primary") != "" {
+        err := s.store.update(func(d *storeData) error {
+            if d.Artwork == nil { d.Artwork = map[string]string{} }
+            d.Artwork[owner] = ref.Path
+            return nil
+        })
+        if err != nil { http.Error(w, "uploaded, but not made primary: "+err.Error(), 500); return }
+        out["primary"] = true
+    }
+    s.audit(r, "artwork-upload", owner, nil, out)
+    if err := s.reindex(r.Context()); err != nil { http.Error(w, "uploaded, but reindex failed: "+err.Error(), 500); return }
+    writeJSONStatus(w, http.StatusCreated, out)
+}
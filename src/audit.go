@@ -0,0 +1,130 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ====== Audit Log ======
+//
+// Every change is recorded, in the state by default. With SPILL_HISTORY the
+// log is appended to audit.jsonl beside the state instead, so years of it
+// take neither memory nor time on each change, and is only read for GET
+// /api/audit; what the state held until then moves there with the next entry.
+
+// AuditEntry records one state-changing action and what it changed.
+type AuditEntry struct {
+    ID      string    `json:"id"`
+    Time    time.Time `json:"time"`
+    Actor   string    `json:"actor"`
+    Claimed string    `json:"claimed,omitempty"` // who X-AVCS-User named, if not the actor
+    Action  string    `json:"action"`            // reindex, promote, annotate, tag, untag, status, ...
+    Track   string    `json:"track,omitempty"`
+    Before  any       `json:"before,omitempty"`
+    After   any       `json:"after,omitempty"`
+}
+
+// auditMax bounds the persisted log; the oldest entries are dropped first.
+const auditMax = 50000
+
+// auditFileMax is the size at which a spilled log moves to audit.jsonl.1,
+// replacing the one before.
+const auditFileMax = 64 << 20
+
+// audit records a state-changing action. r is nil for actions the server
+// starts itself. Failing to persist is logged but does not undo the action,
+// which has already happened.
+func (s *Server) audit(r *http.Request, action, track string, before, after any) {
+    actor, claimed, ctx := "system", "", context.Background()
+    if r != nil { actor, ctx = actorOf(r), r.Context(); claimed = claimedActor(r, actor) }
+    e := AuditEntry{ID: newID(), Time: time.Now().UTC(), Actor: actor, Claimed: claimed, Action: action, Track: track, Before: before, After: after}
+    err := s.store.update(func(d *storeData) error {
+        if s.auditFile != "" {
+            if err := appendAudit(s.auditFile, append(d.Audit, e)); err != nil { return err }
+            d.Audit = nil
+            return nil
+        }
+        d.Audit = append(d.Audit, e)
+        if n := len(d.Audit) - auditMax; n > 0 { d.Audit = append([]AuditEntry(nil), d.Audit[n:]...) }
+        return nil
+    })
+    if err != nil { slog.ErrorContext(ctx, "audit write failed", "action", action, "track", track, "error", err) }
+    data := map[string]any{"audit": e.ID}
+    if before != nil { data["before"] = before }
+    if after != nil { data["after"] = after }
+    s.fireWebhooks(ctx, webhookEvent{Event: action, Actor: actor, Track: track, Data: data})
+    if track != "" || strings.HasPrefix(action, "release-") { s.catalogSync.poke() } // statuses, releases
+}
+
+// GET /api/audit[?actor=&action=&track=&since=&until=&limit=]
+// Entries are returned newest first.
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    since, err := parseSince(q.Get("since"))
+    if err != nil { http.Error(w, "bad since: "+err.Error(), 400); return }
+    until, err := parseSince(q.Get("until"))
+    if err != nil { http.Error(w, "bad until: "+err.Error(), 400); return }
+    limit := 200
+    if v := q.Get("limit"); v != "" {
+        if limit, err = strconv.Atoi(v); err != nil || limit <= 0 { http.Error(w, "bad limit", 400); return }
+    }
+    actor, action, track := q.Get("actor"), q.Get("action"), q.Get("track")
+    match := func(e *AuditEntry) bool {
+        return (actor == "" || strings.EqualFold(e.Actor, actor)) && (action == "" || e.Action == action) && (track == "" || e.Track == track) &&
+            (since.IsZero() || !e.Time.Before(since)) && (until.IsZero() || e.Time.Before(until))
+    }
+
+    out := []AuditEntry{}
+    if s.auditFile != "" {
+        if out, err = readAudit(s.auditFile, match, limit); err != nil { http.Error(w, err.Error(), 500); return }
+    }
+    s.store.view(func(d *storeData) { // not spilled (yet), so older
+        for i := len(d.Audit) - 1; i >= 0 && len(out) < limit; i-- {
+            if match(&d.Audit[i]) { out = append(out, d.Audit[i]) }
+        }
+    })
+    writeJSON(w, out)
+}
+
+// appendAudit adds entries to the spilled log p, moving it to p.1 first if
+// it is full.
+func appendAudit(p string, entries []AuditEntry) error {
+    var buf bytes.Buffer
+    enc := json.NewEncoder(&buf)
+    for _, e := range entries {
+        if err := enc.Encode(e); err != nil { return err }
+    }
+    if st, err := os.Stat(p); err == nil && st.Size()+int64(buf.Len()) >= auditFileMax {
+        if err := os.Rename(p, p+".1"); err != nil { return err }
+    }
+    f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+    if err != nil { return err }
+    defer f.Close()
+    _, err = f.Write(buf.Bytes())
+    return err
+}
+
+// readAudit returns up to limit entries of the spilled log p that match,
+// newest first.
+func readAudit(p string, match func(*AuditEntry) bool, limit int) ([]AuditEntry, error) {
+    out := []AuditEntry{}
+    for _, p := range []string{p, p + ".1"} {
+        b, err := os.ReadFile(p)
+        if os.IsNotExist(err) { continue }
+        if err != nil { return nil, err }
+        lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+        for i := len(lines) - 1; i >= 0 && len(out) < limit; i-- {
+            var e AuditEntry
+            if json.Unmarshal(lines[i], &e) != nil { continue } // being written
+            if match(&e) { out = append(out, e) }
+        }
+    }
+    return out, nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+    "archive/tar"
+    "bytes"
+    "compress/gzip"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "log/slog"
+    "net/http"
+    "os"
+    "path/filepath"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ====== Backup & Restore ======
+//
+// POST /api/admin/backup answers with a .tar.gz of everything the server
+// keeps besides Dropbox itself:
+//
+//   backup.json          what the archive holds, with each file's SHA-256
+//   state.json           annotations, tags, statuses, releases, keys, audit, ...
+//   index.json           the catalog as last indexed
+//   audit.jsonl(.1)      the audit log, where SPILL_HISTORY keeps it apart
+//   cache/*.json         analysis results: loudness, session and track metadata
+//   config.json          the settings in effect, as config file keys; secrets
+//                        only by reference (_file, _vault)
+//   keys/*.key           the manifest and URL signing keys, unless they come
+//                        from the environment
+//
+// POST /api/admin/restore takes such an archive and replaces the state and
+// catalog with it, merges the caches and writes the signing keys, which are
+// used from the next start; it then reindexes to catch up with Dropbox.
+// config.json is not applied: it is there to set up the new host with.
+// `avcs-browser backup` and `avcs-browser restore` do the same from a shell.
+
+const (
+    backupFormat   = 1
+    maxBackupBytes = 1 << 30
+)
+
+// backupManifest is backup.json.
+type backupManifest struct {
+    Format   int          `json:"format"`
+    Created  time.Time    `json:"created"`
+    Instance string       `json:"instance,omitempty"`
+    Tracks   int          `json:"tracks"`
+    Files    []backupFile `json:"files"`
+}
+
+type backupFile struct {
+    Name   string `json:"name"`
+    Size   int    `json:"size"`
+    SHA256 string `json:"sha256"`
+}
+
+// keyFiles are the signing keys kept in the state directory, by the
+// variable that overrides each.
+var keyFiles = map[string]string{"MANIFEST_SIGNING_KEY": "manifest.key", "URL_SIGNING_KEY": "url-signing.key"}
+
+func (s *Server) stateDir() string { return filepath.Dir(s.store.path) }
+
+// backupFiles collects the archive's contents, by file name.
+func (s *Server) backupFiles() (map[string][]byte, int, error) {
+    files := map[string][]byte{}
+    var err error
+    s.store.view(func(d *storeData) { files["state.json"], err = json.Marshal(d) })
+    if err != nil { return nil, 0, err }
+    s.mu.RLock()
+    n := len(s.tracks)
+    files["index.json"], err = json.Marshal(s.tracks)
+    s.mu.RUnlock()
+    if err != nil { return nil, 0, err }
+    s.alsMu.Lock()
+    for name, cache := range map[string]any{"loudness": s.loudCache, "sessions": s.sessCache, "metadata": s.metaCache} {
+        if files["cache/"+name+".json"], err = json.Marshal(cache); err != nil { break }
+    }
+    s.alsMu.Unlock()
+    if err != nil { return nil, 0, err }
+    if s.auditFile != "" {
+        for _, p := range []string{s.auditFile, s.auditFile + ".1"} {
+            b, err := os.ReadFile(p)
+            if errors.Is(err, os.ErrNotExist) { continue }
+            if err != nil { return nil, 0, err }
+            files[filepath.Base(p)] = b
+        }
+    }
+    if files["config.json"], err = json.MarshalIndent(effectiveConfig(), "", "  "); err != nil { return nil, 0, err }
+    for env, name := range keyFiles {
+        if os.Getenv(env) != "" { continue }
+        b, err := os.ReadFile(filepath.Join(s.stateDir(), name))
+        if errors.Is(err, os.ErrNotExist) { continue }
+        if err != nil { return nil, 0, err }
+        files["keys/"+name] = b
+    }
+    return files, n, nil
+}
+
+// effectiveConfig lists the settings that are set, by config file key.
+func effectiveConfig() map[string]string {
+    out := map[string]string{}
+    for key, env := range configEnv {
+        if !slices.Contains(secretSettings, env) {
+            if v := os.Getenv(env); v != "" { out[key] = v }
+            continue
+        }
+        for _, suffix := range []string{"_file", "_vault"} {
+            if v := os.Getenv(env + strings.ToUpper(suffix)); v != "" { out[key+suffix] = v }
+        }
+    }
+    return out
+}
+
+// writeBackup writes files as a .tar.gz led by backup.json.
+func writeBackup(w io.Writer, files map[string][]byte, m backupManifest) error {
+    names := make([]string, 0, len(files))
+    for name := range files { names = append(names, name) }
+    sort.Strings(names)
+    for _, name := range names {
+        sum := sha256.Sum256(files[name])
+        m.Files = append(m.Files, backupFile{Name: name, Size: len(files[name]), SHA256: hex.EncodeToString(sum[:])})
+    }
+    mb, err := json.MarshalIndent(m, "", "  ")
+    if err != nil { return err }
+    zw := gzip.NewWriter(w)
+    tw := tar.NewWriter(zw)
+    for _, name := range append([]string{"backup.json"}, names...) {
+        b := files[name]
+        if name == "backup.json" { b = mb }
+        if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(b)), ModTime: m.Created}); err != nil { return err }
+        if _, err := tw.Write(b); err != nil { return err }
+    }
+    if err := tw.Close(); err != nil { return err }
+    return zw.Close()
+}
+
+// readBackup unpacks an archive and checks it against its backup.json.
+func readBackup(r io.Reader) (backupManifest, map[string][]byte, error) {
+    var m backupManifest
+    zr, err := gzip.NewReader(r)
+    if err != nil { return m, nil, fmt.Errorf("not a backup archive: %w", err) }
+    files := map[string][]byte{}
+    tr := tar.NewReader(zr)
+    for {
+        h, err := tr.Next()
+        if err == io.EOF { break }
+        if err != nil { return m, nil, fmt.Errorf("not a backup archive: %w", err) }
+        if files[h.Name], err = io.ReadAll(tr); err != nil { return m, nil, err }
+    }
+    mb, ok := files["backup.json"]
+    if !ok { return m, nil, errors.New("not a backup archive: no backup.json") }
+    if err := json.Unmarshal(mb, &m); err != nil { return m, nil, fmt.Errorf("backup.json: %w", err) }
+    if m.Format != backupFormat { return m, nil, fmt.Errorf("backup format %d is not supported", m.Format) }
+    delete(files, "backup.json")
+    if len(m.Files) != len(files) { return m, nil, errors.New("the archive does not hold the files backup.json lists") }
+    for _, f := range m.Files {
+        sum := sha256.Sum256(files[f.Name])
+        if b, ok := files[f.Name]; !ok || len(b) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 { return m, nil, fmt.Errorf("%s is missing or damaged", f.Name) }
+    }
+    return m, files, nil
+}
+
+// POST /api/admin/backup
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    files, n, err := s.backupFiles()
+    if err != nil { http.Error(w, err.Error(), 500); return }
+    m := backupManifest{Format: backupFormat, Created: time.Now().UTC(), Tracks: n}
+    if s.cluster != nil { m.Instance = s.cluster.instance }
+    var buf bytes.Buffer
+    if err := writeBackup(&buf, files, m); err != nil { http.Error(w, err.Error(), 500); return }
+    s.audit(r, "backup", "", nil, map[string]any{"tracks": n, "bytes": buf.Len()})
+    w.Header().Set("Content-Type", "application/gzip")
+    w.Header().Set("Content-Disposition", `attachment; filename="`+backupName(m.Created)+`"`)
+    w.Header().Set("Cache-Control", "no-store")
+    w.Write(buf.Bytes())
+}
+
+func backupName(t time.Time) string { return "avcs-backup-" + t.Format("20060102-150405") + ".tar.gz" }
+
+// POST /api/admin/restore (body: a backup archive)
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    m, files, err := readBackup(http.MaxBytesReader(w, r.Body, maxBackupBytes))
+    if err != nil { http.Error(w, err.Error(), 400); return }
+    var state storeData
+    tracks := map[string]*Track{}
+    if err := json.Unmarshal(files["state.json"], &state); err != nil { http.Error(w, "state.json: "+err.Error(), 400); return }
+    if err := json.Unmarshal(files["index.json"], &tracks); err != nil { http.Error(w, "index.json: "+err.Error(), 400); return }
+    var loud map[string]float64
+    var sess map[string]*SessionInfo
+    var meta map[string]*TrackMeta
+    for name, cache := range map[string]any{"loudness": &loud, "sessions": &sess, "metadata": &meta} {
+        if b, ok := files["cache/"+name+".json"]; ok {
+            if err := json.Unmarshal(b, cache); err != nil { http.Error(w, "cache/"+name+".json: "+err.Error(), 400); return }
+        }
+    }
+
+    s.writeMu.Lock(); defer s.writeMu.Unlock()
+    s.mu.RLock(); before := len(s.tracks); s.mu.RUnlock()
+    err = s.store.update(func(d *storeData) error {
+        *d = state
+        if s.auditFile == "" { return nil }
+        for _, p := range []string{s.auditFile, s.auditFile + ".1"} { // the archive's log, or none
+            var err error
+            if b, ok := files[filepath.Base(p)]; ok { err = writeFileAtomic(p, b) } else { err = os.Remove(p) }
+            if err != nil && !errors.Is(err, os.ErrNotExist) { return err }
+        }
+        return nil
+    })
+    if err != nil { http.Error(w, err.Error(), 500); return }
+    if tracks == nil { tracks = map[string]*Track{} }
+    compactTracks(tracks)
+    s.mu.Lock(); s.tracks = tracks; s.indexGen++; s.mu.Unlock()
+    s.publishIndex(r.Context(), tracks)
+    s.alsMu.Lock()
+    for k, v := range loud { s.loudCache[k] = v }
+    for k, v := range sess { s.sessCache[k] = v }
+    for k, v := range meta { s.metaCache[k] = v }
+    s.alsMu.Unlock()
+    keys := []string{}
+    for env, name := range keyFiles {
+        b, ok := files["keys/"+name]
+        if !ok || os.Getenv(env) != "" { continue }
+        if err := writeFileAtomic(filepath.Join(s.stateDir(), name), b); err != nil { http.Error(w, err.Error(), 500); return }
+        keys = append(keys, name)
+    }
+    sort.Strings(keys)
+    s.audit(r, "restore", "", map[string]int{"tracks": before}, map[string]any{"tracks": len(tracks), "created": m.Created, "instance": m.Instance})
+    slog.InfoContext(r.Context(), "restored a backup", "created", m.Created, "tracks", len(tracks), "keys", keys)
+    go s.reindex(context.WithoutCancel(r.Context())) // logs its own failure
+    writeJSON(w, map[string]any{"created": m.Created, "tracks": len(tracks), "keys_restored": keys, "restart_needed": len(keys) > 0})
+}
+
+// runBackup is `avcs-browser backup`.
+func runBackup(args []string) int {
+    fs := flag.NewFlagSet("backup", flag.ExitOnError)
+    server, key := backupFlags(fs)
+    out := fs.String("o", "", "file to write (default: avcs-backup-<time>.tar.gz)")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs-browser backup [flags]")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+    if fs.NArg() != 0 { fs.Usage(); return 2 }
+    b, err := adminCall(*server, *key, "/api/admin/backup", nil)
+    if err != nil { log.Print(err); return 1 }
+    if *out == "" { *out = backupName(time.Now().UTC()) }
+    if err := os.WriteFile(*out, b, 0o600); err != nil { log.Print(err); return 1 }
+    log.Printf("Wrote %s (%d bytes)", *out, len(b))
+    return 0
+}
+
+// runRestore is `avcs-browser restore`.
+func runRestore(args []string) int {
+    fs := flag.NewFlagSet("restore", flag.ExitOnError)
+    server, key := backupFlags(fs)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs-browser restore [flags] <backup.tar.gz>")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+    if fs.NArg() != 1 { fs.Usage(); return 2 }
+    f, err := os.Open(fs.Arg(0))
+    if err != nil { log.Print(err); return 1 }
+    defer f.Close()
+    b, err := adminCall(*server, *key, "/api/admin/restore", f)
+    if err != nil { log.Print(err); return 1 }
+    os.Stdout.Write(b)
+    return 0
+}
+
+func backupFlags(fs *flag.FlagSet) (server, key *string) {
+    server = fs.String("server", envOr("AVCS_SERVER", "http://localhost:8080"), "A-VCS server URL")
+    key = fs.String("key", os.Getenv("AVCS_API_KEY"), "API key with the admin scope")
+    return server, key
+}
+
+// adminCall POSTs body to uri and returns the reply.
+func adminCall(server, key, uri string, body io.Reader) ([]byte, error) {
+    req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(server, "/")+uri, body)
+    if err != nil { return nil, err }
+    if key != "" { req.Header.Set("Authorization", "Bearer "+key) }
+    res, err := http.DefaultClient.Do(req)
+    if err != nil { return nil, err }
+    defer res.Body.Close()
+    b, err := io.ReadAll(res.Body)
+    if err != nil { return nil, err }
+    if res.StatusCode != 200 { return nil, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(b))) }
+    return b, nil
+}
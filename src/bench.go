@@ -0,0 +1,429 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "log/slog"
+    "math/rand/v2"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "os/exec"
+    "runtime"
+    rtmetrics "runtime/metrics"
+    "slices"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "text/tabwriter"
+    "time"
+)
+
+// ====== Benchmarks ======
+//
+// `avcs-browser bench` measures how the server copes with a big library,
+// so that a change making it slower or hungrier is caught before users with
+// one notice. For each size it generates a synthetic catalog, serves it from
+// an emulation of Dropbox's listing endpoints (paged as Dropbox pages them,
+// from a process of its own so as not to be measured), indexes it, and
+// reports:
+//
+//   reindex     time to list and index the catalog, the fastest of -runs
+//   refresh     time to reindex it again from a listing's delta, empty
+//   peak heap   memory in use while indexing, over what was before
+//   index heap  memory the index and the listings kept for refreshes hold
+//   allocated   memory allocated by one reindex
+//   list, detail, summary
+//               latency of GET /api/tracks, of a track's detail and of its
+//               summary, at the 50th and 95th percentile; the handlers are
+//               called directly, without the network, middleware or
+//               response cache
+//
+//   avcs-browser bench                            10k, 100k and 1M entries
+//   avcs-browser bench -entries 100k -runs 3
+//   avcs-browser bench -json > bench.json         results to compare with
+//   avcs-browser bench -baseline bench.json       exits 1 on a regression
+//
+// A synthetic track has eight sessions, each with its Live set, bounce and
+// two backups, a stems set of eight stems, a mix and two master candidates,
+// every fourth with a FINAL too; one track in ten is archived. With the
+// folders holding them that is 136 entries a track.
+
+const (
+    benchPage     = 2000 // entries a list_folder page holds, Dropbox's most
+    benchVersions = 8    // sessions of each synthetic track
+    benchAccounts = 6    // contributors the files are spread over
+)
+
+var benchStems = []string{"KICK", "SNARE", "DRUMS", "BASS", "SYNTH", "PAD", "VOCALS", "FX"}
+
+// benchEnv, in the emulation's process, is the size of the catalog to serve.
+const benchEnv = "AVCS_BENCH_EMULATE"
+
+// benchResult is what was measured at one size; -json prints them and
+// -baseline reads them back.
+type benchResult struct {
+    Entries   int                     `json:"entries"`
+    Tracks    int                     `json:"tracks"`
+    ReindexMS float64                 `json:"reindex_ms"`
+    RefreshMS float64                 `json:"refresh_ms"`
+    PeakHeap  uint64                  `json:"peak_heap"`  // bytes
+    IndexHeap uint64                  `json:"index_heap"` // bytes
+    Allocated uint64                  `json:"allocated"`  // bytes
+    Latency   map[string]benchLatency `json:"latency"`    // key: list, detail, summary
+}
+
+type benchLatency struct {
+    P50 float64 `json:"p50_ms"`
+    P95 float64 `json:"p95_ms"`
+}
+
+// runBench is `avcs-browser bench`.
+func runBench(args []string) int {
+    if n, err := strconv.Atoi(os.Getenv(benchEnv)); err == nil { return serveBenchCatalog(n) }
+    fs := flag.NewFlagSet("bench", flag.ExitOnError)
+    sizes := fs.String("entries", "10k,100k,1M", "catalog sizes in Dropbox entries, comma-separated (k and M allowed)")
+    runs := fs.Int("runs", 1, "reindexes at each size; the fastest counts")
+    requests := fs.Int("requests", 200, "requests to each endpoint at each size")
+    asJSON := fs.Bool("json", false, "print the results as JSON")
+    baseline := fs.String("baseline", "", "results of an earlier -json run to compare with")
+    tolerance := fs.Float64("tolerance", 0.25, "how much worse than -baseline, as a fraction, counts as a regression")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs-browser bench [flags]")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+    if fs.NArg() != 0 || *runs < 1 || *requests < 1 { fs.Usage(); return 2 }
+    var counts []int
+    for _, v := range strings.Split(*sizes, ",") {
+        n, err := parseCount(strings.TrimSpace(v))
+        if err != nil || n < 1 { log.Printf("bad -entries %q", v); return 2 }
+        counts = append(counts, n)
+    }
+    var before []benchResult
+    if *baseline != "" {
+        b, err := os.ReadFile(*baseline)
+        if err == nil { err = json.Unmarshal(b, &before) }
+        if err != nil { log.Printf("-baseline: %v", err); return 2 }
+    }
+    slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+    var results []benchResult
+    for _, n := range counts {
+        if !*asJSON { fmt.Fprintf(os.Stderr, "Indexing %d entries...\n", n) }
+        r, err := benchSize(n, *runs, *requests)
+        if err != nil { log.Printf("%d entries: %v", n, err); return 1 }
+        results = append(results, r)
+    }
+    if *asJSON {
+        b, _ := json.MarshalIndent(results, "", "  ")
+        fmt.Println(string(b))
+    } else {
+        printBench(results)
+    }
+    if regressions := compareBench(before, results, *tolerance); len(regressions) > 0 {
+        for _, r := range regressions { fmt.Fprintln(os.Stderr, "regression:", r) }
+        return 1
+    }
+    return 0
+}
+
+// parseCount reads a count such as 5000, 10k or 1M.
+func parseCount(v string) (int, error) {
+    mult := 1
+    switch {
+    case strings.HasSuffix(v, "k"), strings.HasSuffix(v, "K"): mult, v = 1000, v[:len(v)-1]
+    case strings.HasSuffix(v, "M"), strings.HasSuffix(v, "m"): mult, v = 1000000, v[:len(v)-1]
+    }
+    n, err := strconv.Atoi(v)
+    return n * mult, err
+}
+
+// benchSize measures a catalog of n entries.
+func benchSize(n, runs, requests int) (benchResult, error) {
+    cat := newBenchCatalog(n)
+    res := benchResult{Entries: cat.tracks * len(cat.files), Tracks: cat.tracks, Latency: map[string]benchLatency{}}
+    stop, err := startBenchDropbox(n)
+    if err != nil { return res, err }
+    defer stop()
+    s, err := benchServer()
+    if err != nil { return res, err }
+
+    ctx := context.Background()
+    runtime.GC()
+    var base runtime.MemStats
+    runtime.ReadMemStats(&base)
+    for range runs {
+        var m0, m1 runtime.MemStats
+        runtime.ReadMemStats(&m0)
+        var peak atomic.Uint64
+        done := make(chan struct{})
+        go func() {
+            sample := []rtmetrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+            tick := time.NewTicker(5 * time.Millisecond)
+            defer tick.Stop()
+            for {
+                rtmetrics.Read(sample)
+                if v := sample[0].Value.Uint64(); v > peak.Load() { peak.Store(v) }
+                select {
+                case <-done: return
+                case <-tick.C:
+                }
+            }
+        }()
+        s.relistNext.Store(true) // a full listing, each run
+        start := time.Now()
+        err := s.index(ctx)
+        took := time.Since(start)
+        close(done)
+        if err != nil { return res, err }
+        runtime.ReadMemStats(&m1)
+        if ms := float64(took) / float64(time.Millisecond); res.ReindexMS == 0 || ms < res.ReindexMS { res.ReindexMS = ms }
+        res.PeakHeap = max(res.PeakHeap, peak.Load()-min(peak.Load(), base.HeapAlloc))
+        res.Allocated = m1.TotalAlloc - m0.TotalAlloc
+        runtime.GC()
+    }
+    var after runtime.MemStats
+    runtime.ReadMemStats(&after)
+    res.IndexHeap = after.HeapAlloc - min(after.HeapAlloc, base.HeapAlloc)
+    start := time.Now()
+    if err := s.index(ctx); err != nil { return res, err }
+    res.RefreshMS = float64(time.Since(start)) / float64(time.Millisecond)
+
+    s.mu.RLock()
+    names := make([]string, 0, len(s.tracks))
+    for name := range s.tracks { names = append(names, name) }
+    s.mu.RUnlock()
+    slices.Sort(names)
+    rnd := rand.New(rand.NewPCG(1, uint64(n))) // the same tracks every time
+    for _, ep := range []struct{ name, uri string }{{"list", "/api/tracks"}, {"detail", "/api/tracks/%s"}, {"summary", "/api/tracks/%s/summary"}} {
+        took := make([]float64, requests)
+        for i := range took {
+            uri := ep.uri
+            if strings.Contains(uri, "%s") { uri = fmt.Sprintf(uri, names[rnd.IntN(len(names))]) }
+            w, r := httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, uri, nil)
+            s.responses.mu.Lock(); s.responses.m = nil; s.responses.mu.Unlock() // made anew each time
+            start := time.Now()
+            if ep.name == "list" { s.handleListTracks(w, r) } else { s.handleGetTrack(w, r) }
+            took[i] = float64(time.Since(start)) / float64(time.Millisecond)
+            if w.Code != 200 { return res, fmt.Errorf("%s: %d %s", uri, w.Code, strings.TrimSpace(w.Body.String())) }
+        }
+        slices.Sort(took)
+        res.Latency[ep.name] = benchLatency{P50: took[len(took)/2], P95: took[len(took)*95/100]}
+    }
+    return res, nil
+}
+
+// benchServer is a server for the catalog, keeping nothing and running no
+// more than indexing does.
+func benchServer() (*Server, error) {
+    flow, err := parseWorkflow("", "")
+    if err != nil { return nil, err }
+    store, err := openStore("")
+    if err != nil { return nil, err }
+    return &Server{
+        dropbox:     demoAuth(),
+        dropboxRoot: "/Tracks",
+        archiveRoot: "/Archive",
+        inboxRoot:   "/_inbox",
+        readOnly:    true, // no manifests, trash purge or inbox filing
+        store:       store,
+        flow:        flow,
+        tracks:      map[string]*Track{},
+        alsCache:    map[string]*alsInfo{},
+        sessCache:   map[string]*SessionInfo{},
+        loudCache:   map[string]float64{},
+        metaCache:   map[string]*TrackMeta{},
+        projCache:   map[string]*Project{},
+        lenCache:    map[string]time.Duration{},
+        midiCache:   map[string]*MidiInfo{},
+        lyricsCache: map[string]string{},
+        accounts:    map[string]string{},
+        metrics:     newMetrics(),
+        fullRelist:  defaultFullRelist,
+    }, nil
+}
+
+// startBenchDropbox serves a catalog of n entries from a process of its own
+// and points the Dropbox calls at it.
+func startBenchDropbox(n int) (stop func(), err error) {
+    exe, err := os.Executable()
+    if err != nil { return nil, err }
+    cmd := exec.Command(exe, "bench")
+    cmd.Env = append(os.Environ(), benchEnv+"="+strconv.Itoa(n))
+    cmd.Stderr = os.Stderr
+    out, err := cmd.StdoutPipe()
+    if err != nil { return nil, err }
+    if err := cmd.Start(); err != nil { return nil, err }
+    stop = func() { cmd.Process.Kill(); cmd.Wait() }
+    addr, err := bufio.NewReader(out).ReadString('\n')
+    if err != nil { stop(); return nil, fmt.Errorf("starting the emulated Dropbox: %w", err) }
+    dbxAPIHost = "http://" + strings.TrimSpace(addr)
+    dbxContentHost = dbxAPIHost
+    return stop, nil
+}
+
+// serveBenchCatalog is the emulation's process: it serves a catalog of n
+// entries on a loopback port, which it prints, until it is killed.
+func serveBenchCatalog(n int) int {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { log.Print(err); return 1 }
+    fmt.Println(ln.Addr())
+    log.Print(http.Serve(ln, newBenchCatalog(n)))
+    return 1
+}
+
+// benchFile is an entry of every synthetic track, under its folder.
+type benchFile struct {
+    rel    string // with {T} for the track's name
+    folder bool
+    size   int64
+    at     time.Duration // after the catalog's start
+}
+
+// benchCatalog is a synthetic catalog of tracks alike but for their names.
+type benchCatalog struct {
+    files  []benchFile // of each track
+    tracks int
+    start  time.Time
+}
+
+func newBenchCatalog(entries int) *benchCatalog {
+    c := &benchCatalog{start: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)}
+    dir := func(rel string) { c.files = append(c.files, benchFile{rel: rel, folder: true}) }
+    file := func(rel string, size int64, at time.Duration) { c.files = append(c.files, benchFile{rel: rel, size: size, at: at}) }
+    for _, d := range []string{"", "/ableton", "/ableton/Backup", "/stems", "/mixes", "/masters"} { dir(d) }
+    for v := range benchVersions {
+        at := time.Duration(v) * 97 * time.Minute // so that no two share a T1
+        t1, t2 := benchStamp(c.start.Add(at)), benchStamp(c.start.Add(at+50*time.Minute))
+        file("/ableton/{T}-"+t1+".als", 400<<10, at)
+        file("/ableton/{T}-"+t1+".wav", 50<<20, at+time.Minute)
+        for b := range 2 {
+            file("/ableton/Backup/{T}-"+t1+" ["+c.start.Add(at-time.Duration(b+1)*time.Hour).Format("2006-01-02 150405")+"].als", 380<<10, at-time.Duration(b+1)*time.Hour)
+        }
+        dir("/stems/" + t1 + "-" + t2)
+        for _, stem := range benchStems { file("/stems/"+t1+"-"+t2+"/{T}-"+t1+"-"+t2+"-"+stem+".wav", 60<<20, at+50*time.Minute) }
+        file("/mixes/{T}-"+t1+"-"+t2+"-[unmastered].wav", 60<<20, at+55*time.Minute)
+        for _, idx := range []string{"1", "2"} { file("/masters/{T}-"+t1+"-"+t2+"-"+idx+".wav", 60<<20, at+70*time.Minute) }
+        if v%4 == 3 { file("/masters/{T}-"+t1+"-"+t2+"-FINAL.wav", 60<<20, at+80*time.Minute) }
+    }
+    c.tracks = max(1, (entries+len(c.files)-1)/len(c.files))
+    return c
+}
+
+// benchStamp is t as a T1 or T2: HHMM and A or P.
+func benchStamp(t time.Time) string { return t.Format("0304PM")[:5] }
+
+// root is the root that holds track i: one track in ten is archived.
+func (c *benchCatalog) root(i int) string {
+    if i%10 == 9 { return "/Archive" }
+    return "/Tracks"
+}
+
+// entry is the catalog's entry i, as Dropbox describes it.
+func (c *benchCatalog) entry(i int) dbxEntry {
+    t, f := i/len(c.files), c.files[i%len(c.files)]
+    name := fmt.Sprintf("TRACK_%06d", t)
+    p := c.root(t) + "/" + name + strings.ReplaceAll(f.rel, "{T}", name)
+    e := dbxEntry{Tag: "folder", Name: p[strings.LastIndex(p, "/")+1:], PathDisplay: p, PathLower: strings.ToLower(p), ID: fmt.Sprintf("id:%x", i)}
+    if f.folder { return e }
+    e.Tag, e.Size, e.ContentHash = "file", f.size, fmt.Sprintf("%064x", i)
+    e.ClientModified = c.start.Add(f.at + time.Duration(t)*time.Minute)
+    e.ServerModified = e.ClientModified.Add(time.Minute)
+    e.SharingInfo = &struct {
+        ModifiedBy string `json:"modified_by"`
+    }{fmt.Sprintf("dbid:bench%d", (t+i)%benchAccounts)}
+    return e
+}
+
+func (c *benchCatalog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    var arg map[string]any
+    json.NewDecoder(r.Body).Decode(&arg)
+    w.Header().Set("Content-Type", "application/json")
+    total := c.tracks * len(c.files)
+    switch r.URL.Path {
+    case "/2/files/list_folder", "/2/files/list_folder/continue":
+        root, from := fmt.Sprint(arg["path"]), 0
+        if cursor, ok := arg["cursor"].(string); ok {
+            var err error
+            root, _, _ = strings.Cut(cursor, "@")
+            if from, err = strconv.Atoi(cursor[len(root)+1:]); err != nil { demoError(w, "reset"); return }
+        }
+        if root != "/Tracks" && root != "/Archive" { demoError(w, "path/not_found"); return }
+        page := dbxListResp{Entries: []dbxEntry{}}
+        i := from
+        for ; i < total && len(page.Entries) < benchPage; i++ {
+            if t := i / len(c.files); c.root(t) != root {
+                i = (t+1)*len(c.files) - 1
+                continue
+            }
+            page.Entries = append(page.Entries, c.entry(i))
+        }
+        page.Cursor, page.HasMore = root+"@"+strconv.Itoa(i), i < total
+        json.NewEncoder(w).Encode(page)
+    case "/2/users/get_account_batch":
+        var accts []map[string]any
+        for _, id := range arg["account_ids"].([]any) {
+            accts = append(accts, map[string]any{"account_id": id, "name": map[string]any{"display_name": strings.TrimPrefix(fmt.Sprint(id), "dbid:")}})
+        }
+        json.NewEncoder(w).Encode(accts)
+    default:
+        demoError(w, "path/not_found")
+    }
+}
+
+// printBench prints results as a table.
+func printBench(results []benchResult) {
+    tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(tw, "ENTRIES\tTRACKS\tREINDEX\tREFRESH\tPEAK HEAP\tINDEX HEAP\tALLOCATED\tLIST p50/p95\tDETAIL p50/p95\tSUMMARY p50/p95")
+    for _, r := range results {
+        fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\t%s", r.Entries, r.Tracks, benchMS(r.ReindexMS), benchMS(r.RefreshMS), benchBytes(r.PeakHeap), benchBytes(r.IndexHeap), benchBytes(r.Allocated))
+        for _, ep := range []string{"list", "detail", "summary"} {
+            fmt.Fprintf(tw, "\t%s / %s", benchMS(r.Latency[ep].P50), benchMS(r.Latency[ep].P95))
+        }
+        fmt.Fprintln(tw)
+    }
+    tw.Flush()
+}
+
+func benchMS(ms float64) string {
+    d := time.Duration(ms * float64(time.Millisecond))
+    switch {
+    case d >= time.Second: d = d.Round(10 * time.Millisecond)
+    case d >= time.Millisecond: d = d.Round(10 * time.Microsecond)
+    default: d = d.Round(time.Microsecond)
+    }
+    return d.String()
+}
+
+func benchBytes(b uint64) string { return fmt.Sprintf("%.1f MB", float64(b)/(1<<20)) }
+
+// compareBench lists what got worse than the baseline by more than
+// tolerance, at the sizes both measured.
+func compareBench(baseline, results []benchResult, tolerance float64) []string {
+    var out []string
+    for _, r := range results {
+        i := slices.IndexFunc(baseline, func(b benchResult) bool { return b.Entries == r.Entries })
+        if i < 0 { continue }
+        b := baseline[i]
+        check := func(what string, was, now float64, format func(float64) string) {
+            if was > 0 && now > was*(1+tolerance) {
+                out = append(out, fmt.Sprintf("%d entries: %s %s, was %s (+%.0f%%)", r.Entries, what, format(now), format(was), (now/was-1)*100))
+            }
+        }
+        bytes := func(v float64) string { return benchBytes(uint64(v)) }
+        check("reindex", b.ReindexMS, r.ReindexMS, benchMS)
+        check("refresh", b.RefreshMS, r.RefreshMS, benchMS)
+        check("peak heap", float64(b.PeakHeap), float64(r.PeakHeap), bytes)
+        check("index heap", float64(b.IndexHeap), float64(r.IndexHeap), bytes)
+        for _, ep := range []string{"list", "detail", "summary"} {
+            check(ep+" p95", b.Latency[ep].P95, r.Latency[ep].P95, benchMS)
+        }
+    }
+    return out
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+)
+
+// ====== Branches ======
+
+// GET /api/tracks/{name}/branches
+// GET /api/tracks/{name}/branches/{branch}[/{resource}/...]
+// A branch is the track TRACK.BRANCH, so everything under
+// /api/tracks/{name}/branches/{branch}/ is the same as /api/tracks/{name}.{branch}/.
+func (s *Server) handleBranches(w http.ResponseWriter, r *http.Request, t *Track, parts []string) {
+    if t.Parent != "" { http.Error(w, "branches cannot be nested", 400); return }
+    if len(parts) == 0 || parts[0] == "" {
+        if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+        out := []trackSummary{}
+        s.mu.RLock()
+        for _, b := range t.Branches {
+            if bt := s.tracks[t.Name+"."+b]; bt != nil { out = append(out, s.summarize(bt)) }
+        }
+        s.mu.RUnlock()
+        writeJSON(w, out)
+        return
+    }
+    name := t.Name + "." + strings.ToUpper(parts[0])
+    s.mu.RLock(); bt := s.tracks[name]; s.mu.RUnlock()
+    if bt == nil { http.Error(w, "branch not found", 404); return }
+    if len(parts) > 1 && parts[1] != "" {
+        s.handleTrackSub(w, r, bt, parts[1:])
+        return
+    }
+    writeJSON(w, s.trackDetail(bt, r.URL.Query().Get("deprecated") != "", r.URL.Query().Get("archived") != ""))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "archive/zip"
+    "context"
+    "io"
+    "log/slog"
+    "net/http"
+    "os"
+    "path"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ====== Streaming Bundles ======
+//
+// A stems set can run to 5 GB, and one download after the other left the
+// client waiting on each file's round trip while Dropbox sent at a single
+// connection's pace. Bundles now download bundleFetchers members at once,
+// each into a temporary file, and stream the zip as they arrive: the member
+// being written is read as it fills, the ones after it are fetched
+// meanwhile, and each temporary file goes once written. Members are stored,
+// not deflated; audio does not compress. Memory stays flat whatever the
+// set's size, disk holds a few members at most, and the first bytes go out
+// as soon as the first member starts arriving.
+//
+//   GET /api/tracks/{name}/stems/{T1-T2}/bundle    a stems set as one zip
+//   GET /api/tracks/{name}/ableton/{T1}/bundle     a Live set with its samples
+//                                                  (see samples.go)
+
+const bundleFetchers = 4 // members downloaded at once
+
+// bundleMember is a file of a bundle.
+type bundleMember struct {
+    path     string // in Dropbox
+    name     string // in the zip
+    modified time.Time
+}
+
+// writeBundle writes members to zw in order, and returns the content hash
+// of what was written of each.
+func (s *Server) writeBundle(ctx context.Context, zw *zip.Writer, members []bundleMember) ([]string, error) {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    spools := make([]*spool, len(members))
+    defer func() {
+        for _, sp := range spools {
+            if sp != nil { sp.close() }
+        }
+    }()
+    sums := make([]string, len(members))
+    for i, m := range members {
+        for j := i; j < min(i+bundleFetchers, len(members)); j++ {
+            if spools[j] == nil { spools[j] = s.startSpool(ctx, members[j].path) }
+        }
+        f, err := zw.CreateHeader(&zip.FileHeader{Name: m.name, Method: zip.Store, Modified: m.modified})
+        if err != nil { return sums, err }
+        h := newContentHasher()
+        if _, err := io.Copy(io.MultiWriter(f, h), spools[i]); err != nil { return sums, err }
+        spools[i].close()
+        spools[i] = nil
+        sums[i] = h.Sum()
+    }
+    return sums, nil
+}
+
+// spool is a download into a temporary file, which can be read while it
+// is being written.
+type spool struct {
+    mu     sync.Mutex
+    cond   *sync.Cond
+    file   *os.File
+    n      int64 // bytes written
+    off    int64 // bytes read
+    done   bool
+    err    error
+    closed bool
+}
+
+// startSpool starts downloading p.
+func (s *Server) startSpool(ctx context.Context, p string) *spool {
+    sp := &spool{}
+    sp.cond = sync.NewCond(&sp.mu)
+    file, err := os.CreateTemp("", "avcs-bundle-*")
+    if err != nil { sp.err, sp.done = err, true; return sp }
+    sp.file = file
+    go func() {
+        err := sp.fill(ctx, s, p)
+        sp.mu.Lock(); defer sp.mu.Unlock()
+        sp.err, sp.done = err, true
+        if sp.closed { sp.remove() }
+        sp.cond.Broadcast()
+    }()
+    return sp
+}
+
+func (sp *spool) fill(ctx context.Context, s *Server, p string) error {
+    body, err := s.dbxDownload(ctx, p)
+    if err != nil { return err }
+    defer body.Close()
+    buf := make([]byte, 256<<10)
+    for {
+        n, err := body.Read(buf)
+        if n > 0 {
+            if _, werr := sp.file.Write(buf[:n]); werr != nil { return werr }
+            sp.mu.Lock(); sp.n += int64(n); closed := sp.closed; sp.cond.Broadcast(); sp.mu.Unlock()
+            if closed { return nil } // no one is reading any more
+        }
+        if err == io.EOF { return nil }
+        if err != nil { return err }
+    }
+}
+
+// Read reads what has been downloaded, waiting for more until it is all.
+func (sp *spool) Read(p []byte) (int, error) {
+    sp.mu.Lock()
+    for sp.off == sp.n && !sp.done { sp.cond.Wait() }
+    off, n, err := sp.off, sp.n, sp.err
+    sp.mu.Unlock()
+    if off == n {
+        if err != nil { return 0, err }
+        return 0, io.EOF
+    }
+    k, rerr := sp.file.ReadAt(p[:min(int64(len(p)), n-off)], off)
+    sp.mu.Lock(); sp.off += int64(k); sp.mu.Unlock()
+    if rerr == io.EOF { rerr = nil } // only so far written
+    return k, rerr
+}
+
+// close drops the download, at once or once it stops.
+func (sp *spool) close() {
+    sp.mu.Lock(); defer sp.mu.Unlock()
+    if sp.closed { return }
+    sp.closed = true
+    if sp.done { sp.remove() }
+}
+
+func (sp *spool) remove() {
+    if sp.file == nil { return }
+    sp.file.Close()
+    os.Remove(sp.file.Name())
+}
+
+// GET /api/tracks/{name}/stems/{T1-T2}/bundle
+func (s *Server) handleStemsBundle(w http.ResponseWriter, r *http.Request, t *Track, set string) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    var st *StemsSet
+    for i := range t.Stems {
+        if strings.EqualFold(t.Stems[i].T1+"-"+t.Stems[i].T2, set) { st = &t.Stems[i] }
+    }
+    if st == nil || len(st.Stems) == 0 { http.Error(w, "stems set not found", 404); return }
+    name := t.Name + "-" + st.T1 + "-" + st.T2 + " Stems"
+    var members []bundleMember
+    for _, f := range st.Stems { members = append(members, bundleMember{path: f.Path, name: name + "/" + path.Base(f.Path), modified: f.ServerModified}) }
+
+    s.logAccess(r, AccessEvent{Kind: accessBundle, Track: t.Name, File: path.Dir(st.Stems[0].Path)})
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+    w.Header().Set("Cache-Control", "no-store")
+    zw := zip.NewWriter(w)
+    sums, err := s.writeBundle(r.Context(), zw, members)
+    // Cut short, the zip lacks its directory, so that it does not pass for whole.
+    if err != nil { slog.ErrorContext(r.Context(), "bundle download failed", "track", t.Name, "set", set, "error", err); return }
+    for i, f := range st.Stems {
+        if f.ContentHash != "" && sums[i] != f.ContentHash { slog.WarnContext(r.Context(), "bundle content hash changed during download", "path", f.Path) }
+    }
+    zw.Close()
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "math"
+    "net/http"
+    "net/url"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ====== Catalog Sync ======
+//
+// Label ops keep release schedules in spreadsheets, so a summary of every
+// track can be pushed, one way, to a Google Sheet and/or an Airtable table,
+// every SYNC_INTERVAL (default 1h) from the leader and on
+// POST /api/admin/sync:
+//
+//   Track | Status | Latest session | Latest mix | Latest master |
+//   Candidates | FINAL | Missing FINAL | LUFS | Updated
+//
+// LUFS is that of the FINAL, or of the latest candidate without one; each
+// master revision is measured once. The sheet (SHEETS_SPREADSHEET_ID, tab
+// SHEETS_TAB, default "Tracks") is rewritten whole, as the service account
+// in GOOGLE_SERVICE_ACCOUNT (its JSON key, a secret) that it is shared with.
+// The Airtable table (AIRTABLE_BASE, AIRTABLE_TABLE, with AIRTABLE_TOKEN) has
+// to have fields named like the columns: records are matched by Track,
+// updated, added, and removed when their track is gone, so other fields
+// people keep there survive. Notion (notion.go) gets releases too, and is
+// also synced shortly after the index, a status or a release changes.
+// GET /api/admin/sync shows how the last syncs went. Read-only instances do
+// not sync on their own.
+
+var catalogColumns = []string{"Track", "Status", "Latest session", "Latest mix", "Latest master", "Candidates", "FINAL", "Missing FINAL", "LUFS", "Updated"}
+
+// catalogRow is one track's line.
+type catalogRow struct {
+    Track         string
+    Status        string
+    LatestSession string
+    LatestMix     string
+    LatestMaster  string
+    Candidates    int
+    Final         string // version of the latest FINAL
+    MissingFinal  bool
+    LUFS          *float64
+    Updated       time.Time
+}
+
+// fields is r by column name, with Airtable's types.
+func (r catalogRow) fields() map[string]any {
+    out := map[string]any{"Track": r.Track, "Status": r.Status, "Latest session": r.LatestSession, "Latest mix": r.LatestMix,
+        "Latest master": r.LatestMaster, "Candidates": r.Candidates, "FINAL": r.Final, "Missing FINAL": r.MissingFinal, "LUFS": nil, "Updated": nil}
+    if r.LUFS != nil { out["LUFS"] = math.Round(*r.LUFS*10) / 10 }
+    if !r.Updated.IsZero() { out["Updated"] = r.Updated.Format(time.RFC3339) }
+    return out
+}
+
+// cells is r as a spreadsheet row.
+func (r catalogRow) cells() []any {
+    f := r.fields()
+    out := make([]any, len(catalogColumns))
+    for i, c := range catalogColumns {
+        switch v := f[c].(type) {
+        case nil: out[i] = ""
+        case bool: out[i] = map[bool]string{true: "yes", false: ""}[v]
+        default: out[i] = v
+        }
+    }
+    return out
+}
+
+var releaseColumns = []string{"Release", "ID", "Type", "Artist", "Release date", "Tracks", "Ready", "Missing", "Updated"}
+
+// releaseRow is one release's line.
+type releaseRow struct {
+    Release     string
+    ID          string
+    Type        string
+    Artist      string
+    ReleaseDate string
+    Tracks      int
+    Ready       bool
+    Missing     string // the positions without a FINAL, and why
+    Updated     time.Time
+}
+
+func (r releaseRow) fields() map[string]any {
+    return map[string]any{"Release": r.Release, "ID": r.ID, "Type": r.Type, "Artist": r.Artist, "Release date": r.ReleaseDate,
+        "Tracks": r.Tracks, "Ready": r.Ready, "Missing": r.Missing, "Updated": r.Updated.Format(time.RFC3339)}
+}
+
+// catalog is what is synced.
+type catalog struct {
+    Tracks   []catalogRow
+    Releases []releaseRow
+}
+
+// catalogSink is somewhere the catalog goes.
+type catalogSink interface {
+    name() string
+    // push writes c and says how many rows or records it wrote.
+    push(ctx context.Context, c catalog) (int, error)
+    // onChange reports whether the sink follows changes as well as the schedule.
+    onChange() bool
+}
+
+// syncRun is how a sink's last sync went.
+type syncRun struct {
+    Sink  string    `json:"sink"`
+    At    time.Time `json:"at"`
+    Rows  int       `json:"rows"`
+    Error string    `json:"error,omitempty"`
+}
+
+// catalogSync is the sync setup; nil if there is none.
+type catalogSync struct {
+    sinks []catalogSink
+    every time.Duration
+
+    mu      sync.Mutex
+    last    map[string]syncRun
+    changed chan struct{}
+}
+
+// poke tells the live sinks the catalog changed; cs may be nil.
+func (cs *catalogSync) poke() {
+    if cs == nil { return }
+    select {
+    case cs.changed <- struct{}{}:
+    default:
+    }
+}
+
+// loadCatalogSync reads the sync settings.
+func loadCatalogSync() (*catalogSync, error) {
+    cs := &catalogSync{every: time.Hour, last: map[string]syncRun{}, changed: make(chan struct{}, 1)}
+    if v := os.Getenv("SYNC_INTERVAL"); v != "" {
+        d, err := time.ParseDuration(v)
+        if err != nil || d < time.Minute { return nil, fmt.Errorf("SYNC_INTERVAL must be a duration of 1m or more, like 1h, not %q", v) }
+        cs.every = d
+    }
+    sheets, err := loadSheets()
+    if err != nil { return nil, fmt.Errorf("Google Sheets: %w", err) }
+    if sheets != nil { cs.sinks = append(cs.sinks, sheets) }
+    airtable, err := loadAirtable()
+    if err != nil { return nil, fmt.Errorf("Airtable: %w", err) }
+    if airtable != nil { cs.sinks = append(cs.sinks, airtable) }
+    notion, err := loadNotion()
+    if err != nil { return nil, fmt.Errorf("Notion: %w", err) }
+    if notion != nil { cs.sinks = append(cs.sinks, notion) }
+    if len(cs.sinks) == 0 { return nil, nil }
+    return cs, nil
+}
+
+// catalogRows summarizes every track that is not archived.
+func (s *Server) catalogRows(ctx context.Context) []catalogRow {
+    s.mu.RLock()
+    tracks := make([]*Track, 0, len(s.tracks))
+    for _, t := range s.tracks {
+        if !t.Archived { tracks = append(tracks, t) }
+    }
+    s.mu.RUnlock()
+    sort.Slice(tracks, func(i, j int) bool { return tracks[i].Name < tracks[j].Name })
+    out := []catalogRow{}
+    for _, t := range tracks {
+        shown := *t
+        hideArchived(&shown)
+        row := catalogRow{Track: t.Name, Status: s.statusOf(t.Name).Status, MissingFinal: true}
+        later := func(at time.Time) { if at.After(row.Updated) { row.Updated = at } }
+        var snapAt, mixAt, masterAt, finalAt time.Time
+        for _, a := range shown.Ableton {
+            if a.Latest.After(snapAt) { snapAt, row.LatestSession = a.Latest, a.T1 }
+        }
+        for _, m := range shown.Mixes {
+            if m.Latest.After(mixAt) { mixAt, row.LatestMix = m.Latest, m.T1+"-"+m.T2 }
+        }
+        var measure *FileRef
+        for _, m := range shown.Masters {
+            if m.Latest.After(masterAt) { masterAt, row.LatestMaster, row.Candidates = m.Latest, m.T1+"-"+m.T2, len(m.Candidates) }
+            if m.Final != nil && m.Final.ServerModified.After(finalAt) {
+                f := *m.Final
+                finalAt, row.Final, row.MissingFinal, measure = f.ServerModified, m.T1+"-"+m.T2, false, &f
+            }
+        }
+        if measure == nil {
+            for _, m := range shown.Masters {
+                if m.T1+"-"+m.T2 != row.LatestMaster { continue }
+                for _, c := range m.Candidates {
+                    if measure == nil || c.ServerModified.After(measure.ServerModified) { c := c; measure = &c }
+                }
+            }
+        }
+        if measure != nil {
+            if v, err := s.loudness(ctx, *measure); err == nil {
+                row.LUFS = &v
+            } else {
+                slog.WarnContext(ctx, "measuring loudness for the catalog sync failed", "path", measure.Path, "error", err)
+            }
+        }
+        for _, at := range []time.Time{snapAt, mixAt, masterAt, finalAt} { later(at) }
+        out = append(out, row)
+    }
+    return out
+}
+
+// releaseRows summarizes every release.
+func (s *Server) releaseRows() []releaseRow {
+    var rels []*Release
+    s.store.view(func(d *storeData) {
+        for _, rel := range d.Releases { rels = append(rels, rel) }
+    })
+    sort.Slice(rels, func(i, j int) bool { return rels[i].Title < rels[j].Title })
+    out := []releaseRow{}
+    for _, rel := range rels {
+        res := s.resolveRelease(rel)
+        var missing []string
+        for _, rt := range res.Tracks {
+            if rt.Problem != "" { missing = append(missing, fmt.Sprintf("%d. %s: %s", rt.Position, rt.Track, rt.Problem)) }
+        }
+        out = append(out, releaseRow{Release: rel.Title, ID: rel.ID, Type: rel.Type, Artist: rel.Artist, ReleaseDate: rel.ReleaseDate,
+            Tracks: len(rel.Tracks), Ready: res.Ready, Missing: strings.Join(missing, "; "), Updated: rel.Updated})
+    }
+    return out
+}
+
+// syncCatalog pushes the catalog to the sinks that want wants.
+func (s *Server) syncCatalog(ctx context.Context, wants func(catalogSink) bool) []syncRun {
+    var sinks []catalogSink
+    for _, sink := range s.catalogSync.sinks {
+        if wants(sink) { sinks = append(sinks, sink) }
+    }
+    if len(sinks) == 0 { return nil }
+    c := catalog{Tracks: s.catalogRows(ctx), Releases: s.releaseRows()}
+    var runs []syncRun
+    for _, sink := range sinks {
+        run := syncRun{Sink: sink.name(), At: time.Now().UTC()}
+        n, err := sink.push(ctx, c)
+        if run.Rows = n; err != nil {
+            run.Error = err.Error()
+            slog.ErrorContext(ctx, "catalog sync failed", "sink", run.Sink, "error", err)
+        } else {
+            slog.InfoContext(ctx, "catalog synced", "sink", run.Sink, "rows", n)
+        }
+        s.catalogSync.mu.Lock(); s.catalogSync.last[run.Sink] = run; s.catalogSync.mu.Unlock()
+        runs = append(runs, run)
+    }
+    return runs
+}
+
+// catalogSettle is how long a change waits for the ones after it, so a
+// burst of them (an upload of stems, a reindex) is synced once.
+const catalogSettle = 30 * time.Second
+
+// scheduleCatalogSync syncs every interval, and the live sinks after
+// changes, on the leader.
+func (s *Server) scheduleCatalogSync() {
+    tick := time.NewTicker(s.catalogSync.every)
+    for {
+        wants := func(catalogSink) bool { return true }
+        select {
+        case <-tick.C:
+        case <-s.catalogSync.changed:
+            time.Sleep(catalogSettle)
+            select {
+            case <-s.catalogSync.changed:
+            default:
+            }
+            wants = catalogSink.onChange
+        }
+        if !s.leading() || s.readOnly { continue }
+        runJob(context.Background(), "catalog-sync", func(ctx context.Context) { s.syncCatalog(ctx, wants) })
+    }
+}
+
+// GET  /api/admin/sync   how the last syncs went
+// POST /api/admin/sync   sync now
+func (s *Server) handleCatalogSync(w http.ResponseWriter, r *http.Request) {
+    if s.catalogSync == nil { http.Error(w, "no catalog sync is set up (SHEETS_SPREADSHEET_ID, AIRTABLE_BASE, NOTION_TOKEN)", 404); return }
+    switch r.Method {
+    case http.MethodGet:
+        runs := []syncRun{}
+        s.catalogSync.mu.Lock()
+        for _, sink := range s.catalogSync.sinks {
+            if run, ok := s.catalogSync.last[sink.name()]; ok { runs = append(runs, run) }
+        }
+        s.catalogSync.mu.Unlock()
+        sinks := []string{}
+        for _, sink := range s.catalogSync.sinks { sinks = append(sinks, sink.name()) }
+        writeJSON(w, map[string]any{"sinks": sinks, "interval": s.catalogSync.every.String(), "last": runs})
+    case http.MethodPost:
+        writeJSON(w, s.syncCatalog(r.Context(), func(catalogSink) bool { return true }))
+    default:
+        http.Error(w, "GET or POST required", 405)
+    }
+}
+
+// ====== Google Sheets ======
+
+var (
+    sheetsAPI   = "https://sheets.googleapis.com"
+    sheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+)
+
+type sheetsSink struct {
+    spreadsheet, tab string
+    email, tokenURI  string
+    key              *rsa.PrivateKey
+    client           *http.Client
+
+    mu      sync.Mutex
+    access  string
+    expires time.Time
+}
+
+// loadSheets reads the Google Sheets settings; nil if there are none.
+func loadSheets() (*sheetsSink, error) {
+    id := os.Getenv("SHEETS_SPREADSHEET_ID")
+    account, err := readSecret("GOOGLE_SERVICE_ACCOUNT")
+    if err != nil { return nil, err }
+    if id == "" && account == "" { return nil, nil }
+    if id == "" || account == "" { return nil, errors.New("SHEETS_SPREADSHEET_ID and GOOGLE_SERVICE_ACCOUNT go together") }
+    var sa struct {
+        ClientEmail string `json:"client_email"`
+        PrivateKey  string `json:"private_key"`
+        TokenURI    string `json:"token_uri"`
+    }
+    if err := json.Unmarshal([]byte(account), &sa); err != nil { return nil, fmt.Errorf("GOOGLE_SERVICE_ACCOUNT is not a service account key: %w", err) }
+    block, _ := pem.Decode([]byte(sa.PrivateKey))
+    if block == nil || sa.ClientEmail == "" { return nil, errors.New("GOOGLE_SERVICE_ACCOUNT has no client_email or private_key") }
+    k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil { return nil, fmt.Errorf("GOOGLE_SERVICE_ACCOUNT private_key: %w", err) }
+    key, ok := k.(*rsa.PrivateKey)
+    if !ok { return nil, errors.New("GOOGLE_SERVICE_ACCOUNT private_key is not an RSA key") }
+    tab := os.Getenv("SHEETS_TAB")
+    if tab == "" { tab = "Tracks" }
+    if sa.TokenURI == "" { sa.TokenURI = "https://oauth2.googleapis.com/token" }
+    return &sheetsSink{spreadsheet: id, tab: tab, email: sa.ClientEmail, tokenURI: sa.TokenURI, key: key, client: &http.Client{Timeout: time.Minute}}, nil
+}
+
+func (g *sheetsSink) name() string   { return "google-sheets" }
+func (g *sheetsSink) onChange() bool { return false }
+
+// token is an access token for the service account, from a signed JWT.
+func (g *sheetsSink) token(ctx context.Context) (string, error) {
+    g.mu.Lock(); defer g.mu.Unlock()
+    if g.access != "" && time.Until(g.expires) > 5*time.Minute { return g.access, nil }
+    now := time.Now()
+    enc := func(v any) string { b, _ := json.Marshal(v); return base64.RawURLEncoding.EncodeToString(b) }
+    unsigned := enc(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." +
+        enc(map[string]any{"iss": g.email, "scope": sheetsScope, "aud": g.tokenURI, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()})
+    sum := sha256.Sum256([]byte(unsigned))
+    sig, err := rsa.SignPKCS1v15(rand.Reader, g.key, crypto.SHA256, sum[:])
+    if err != nil { return "", err }
+    form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)}}
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, g.tokenURI, strings.NewReader(form.Encode()))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    var tok struct {
+        AccessToken string `json:"access_token"`
+        ExpiresIn   int    `json:"expires_in"`
+    }
+    if err := doJSON(g.client, req, &tok); err != nil { return "", fmt.Errorf("google token: %w", err) }
+    g.access, g.expires = tok.AccessToken, now.Add(time.Duration(tok.ExpiresIn)*time.Second)
+    return g.access, nil
+}
+
+// push clears the tab and writes the header and a row per track.
+func (g *sheetsSink) push(ctx context.Context, c catalog) (int, error) {
+    tok, err := g.token(ctx)
+    if err != nil { return 0, err }
+    tab := "'" + strings.ReplaceAll(g.tab, "'", "''") + "'"
+    base := sheetsAPI + "/v4/spreadsheets/" + url.PathEscape(g.spreadsheet) + "/values/"
+    call := func(method, u string, body any) error {
+        b, _ := json.Marshal(body)
+        req, _ := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(b))
+        req.Header.Set("Authorization", "Bearer "+tok)
+        req.Header.Set("Content-Type", "application/json")
+        return doJSON(g.client, req, nil)
+    }
+    if err := call(http.MethodPost, base+url.PathEscape(tab)+":clear", map[string]any{}); err != nil { return 0, err }
+    header := make([]any, len(catalogColumns))
+    for i, c := range catalogColumns { header[i] = c }
+    values := [][]any{header}
+    for _, r := range c.Tracks { values = append(values, r.cells()) }
+    if err := call(http.MethodPut, base+url.PathEscape(tab+"!A1")+"?valueInputOption=RAW", map[string]any{"values": values}); err != nil { return 0, err }
+    return len(c.Tracks), nil
+}
+
+// doJSON makes req and decodes a JSON answer into out, if not nil.
+func doJSON(client *http.Client, req *http.Request, out any) error {
+    res, err := client.Do(req)
+    if err != nil { return err }
+    defer res.Body.Close()
+    b, _ := io.ReadAll(io.LimitReader(res.Body, 8<<20))
+    if res.StatusCode/100 != 2 { return fmt.Errorf("%s %s -> %s: %s", req.Method, req.URL.Path, res.Status, truncate(string(b), 400)) }
+    if out == nil { return nil }
+    return json.Unmarshal(b, out)
+}
+
+// ====== Airtable ======
+
+var airtableAPI = "https://api.airtable.com"
+
+// airtableBatch is how many records Airtable takes per call.
+const airtableBatch = 10
+
+type airtableSink struct {
+    base, table, token string
+    client             *http.Client
+}
+
+// loadAirtable reads the Airtable settings; nil if there are none.
+func loadAirtable() (*airtableSink, error) {
+    base, table := os.Getenv("AIRTABLE_BASE"), os.Getenv("AIRTABLE_TABLE")
+    token, err := readSecret("AIRTABLE_TOKEN")
+    if err != nil { return nil, err }
+    if base == "" && token == "" { return nil, nil }
+    if base == "" || token == "" { return nil, errors.New("AIRTABLE_BASE and AIRTABLE_TOKEN go together") }
+    if table == "" { table = "Tracks" }
+    return &airtableSink{base: base, table: table, token: token, client: &http.Client{Timeout: time.Minute}}, nil
+}
+
+func (a *airtableSink) name() string   { return "airtable" }
+func (a *airtableSink) onChange() bool { return false }
+
+// call makes one API call, keeping under Airtable's 5 requests a second.
+func (a *airtableSink) call(ctx context.Context, method, query string, body, out any) error {
+    time.Sleep(250 * time.Millisecond)
+    var rd io.Reader
+    if body != nil { b, _ := json.Marshal(body); rd = bytes.NewReader(b) }
+    req, err := http.NewRequestWithContext(ctx, method, airtableAPI+"/v0/"+url.PathEscape(a.base)+"/"+url.PathEscape(a.table)+query, rd)
+    if err != nil { return err }
+    req.Header.Set("Authorization", "Bearer "+a.token)
+    req.Header.Set("Content-Type", "application/json")
+    return doJSON(a.client, req, out)
+}
+
+// push matches records by Track, then updates, adds and removes them.
+func (a *airtableSink) push(ctx context.Context, c catalog) (int, error) {
+    type record struct {
+        ID     string         `json:"id,omitempty"`
+        Fields map[string]any `json:"fields"`
+    }
+    existing := map[string][]string{} // Track -> record IDs
+    offset := ""
+    for {
+        var page struct {
+            Records []record `json:"records"`
+            Offset  string   `json:"offset"`
+        }
+        q := "?fields%5B%5D=Track&pageSize=100"
+        if offset != "" { q += "&offset=" + url.QueryEscape(offset) }
+        if err := a.call(ctx, http.MethodGet, q, nil, &page); err != nil { return 0, err }
+        for _, rec := range page.Records {
+            name, _ := rec.Fields["Track"].(string)
+            existing[name] = append(existing[name], rec.ID)
+        }
+        if offset = page.Offset; offset == "" { break }
+    }
+    var update, create []record
+    var gone []string
+    for _, r := range c.Tracks {
+        ids := existing[r.Track]
+        delete(existing, r.Track)
+        if len(ids) == 0 { create = append(create, record{Fields: r.fields()}); continue }
+        update = append(update, record{ID: ids[0], Fields: r.fields()})
+        gone = append(gone, ids[1:]...) // duplicates
+    }
+    for _, ids := range existing { gone = append(gone, ids...) }
+    for len(update) > 0 {
+        n := min(len(update), airtableBatch)
+        if err := a.call(ctx, http.MethodPatch, "", map[string]any{"records": update[:n], "typecast": true}, nil); err != nil { return 0, err }
+        update = update[n:]
+    }
+    for len(create) > 0 {
+        n := min(len(create), airtableBatch)
+        if err := a.call(ctx, http.MethodPost, "", map[string]any{"records": create[:n], "typecast": true}, nil); err != nil { return 0, err }
+        create = create[n:]
+    }
+    for len(gone) > 0 {
+        n := min(len(gone), airtableBatch)
+        q := url.Values{"records[]": gone[:n]}
+        if err := a.call(ctx, http.MethodDelete, "?"+q.Encode(), nil, nil); err != nil { return 0, err }
+        gone = gone[n:]
+    }
+    return len(c.Tracks), nil
+}
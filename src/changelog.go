@@ -0,0 +1,140 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Changelog ======
+
+type ChangeEntry = client.ChangeEntry
+
+// changelog builds the history newest first, optionally only entries after since.
+func (s *Server) changelog(t *Track, since time.Time) []ChangeEntry {
+    out := []ChangeEntry{}
+    add := func(e ChangeEntry) {
+        if !e.Time.IsZero() && e.Time.Before(since) { return }
+        out = append(out, e)
+    }
+    for _, i := range t.Ideas {
+        add(ChangeEntry{Time: i.Recorded, Kind: "idea", Summary: "Idea recorded: " + i.Name, Author: i.ContributedBy})
+    }
+    for i, l := range t.Lyrics {
+        what := "Lyrics revised"
+        if i == 0 { what = "Lyrics written" }
+        add(ChangeEntry{Time: l.Written, Kind: "lyrics", Summary: fmt.Sprintf("%s (revision %d): %s", what, i+1, l.Name), Author: l.ContributedBy})
+    }
+    for _, a := range t.Ableton {
+        if a.ALS == nil && a.Session == nil { continue }
+        daw := a.DAW
+        if daw == "" { daw = "ableton" }
+        ref := ArtifactRef{Kind: kindSnapshot, T1: a.T1}
+        add(ChangeEntry{Time: a.Latest, Kind: "session", Artifact: &ref, Summary: fmt.Sprintf("New %s session %s", daw, a.T1)})
+    }
+    for _, st := range t.Stems {
+        names := make([]string, 0, len(st.Stems))
+        for _, f := range st.Stems { names = append(names, strings.TrimSuffix(f.Name, ".wav")) }
+        sort.Strings(names)
+        ref := ArtifactRef{Kind: kindStems, T1: st.T1, T2: st.T2}
+        add(ChangeEntry{Time: st.Latest, Kind: "stems", Artifact: &ref,
+            Summary: fmt.Sprintf("Stems delivered %s-%s (%d: %s)", st.T1, st.T2, len(names), strings.Join(names, ", "))})
+    }
+    for _, m := range t.Mixes {
+        ref := ArtifactRef{Kind: kindMix, T1: m.T1, T2: m.T2}
+        add(ChangeEntry{Time: m.Latest, Kind: "mix", Artifact: &ref, Summary: fmt.Sprintf("Unmastered mix %s-%s", m.T1, m.T2)})
+    }
+    for _, p := range t.Prints {
+        ref := ArtifactRef{Kind: kindPrint, T1: p.T1, T2: p.T2, Idx: p.Chain}
+        add(ChangeEntry{Time: p.Latest, Kind: "print", Artifact: &ref, Summary: fmt.Sprintf("Hardware print %s-%s through %s", p.T1, p.T2, p.Chain)})
+    }
+    for _, m := range t.Masters {
+        if n := len(m.Candidates); n > 0 {
+            var latest time.Time
+            for _, c := range m.Candidates {
+                if c.ServerModified.After(latest) { latest = c.ServerModified }
+            }
+            ref := ArtifactRef{Kind: kindMaster, T1: m.T1, T2: m.T2}
+            add(ChangeEntry{Time: latest, Kind: "masters", Artifact: &ref, Summary: fmt.Sprintf("%d master candidate%s for %s-%s", n, plural(n), m.T1, m.T2)})
+        }
+        if m.Final != nil {
+            ref := ArtifactRef{Kind: kindMaster, T1: m.T1, T2: m.T2, Idx: "FINAL"}
+            add(ChangeEntry{Time: m.Final.ServerModified, Kind: "final", Artifact: &ref, Summary: fmt.Sprintf("FINAL approved for %s-%s", m.T1, m.T2)})
+        }
+    }
+    for _, a := range s.annotationsFor(t.Name) {
+        ref := a.Artifact
+        add(ChangeEntry{Time: a.Created, Kind: "note", Artifact: &ref, Summary: fmt.Sprintf("Note on %s: %s", a.Artifact, a.Text), Author: a.Author})
+    }
+    for _, c := range s.commentsFor(t.Name) {
+        ref := c.Artifact
+        add(ChangeEntry{Time: c.Created, Kind: "comment", Artifact: &ref, Summary: fmt.Sprintf("Comment on %s at %s: %s", c.Artifact, c.AtLabel, c.Text), Author: c.Author})
+    }
+    for _, ch := range s.statusOf(t.Name).History {
+        add(ChangeEntry{Time: ch.At, Kind: "status", Summary: fmt.Sprintf("Status %s → %s", ch.From, ch.To), Author: ch.By})
+    }
+    for _, dep := range s.deprecationsFor(t.Name) {
+        ref := dep.Artifact
+        add(ChangeEntry{Time: dep.Created, Kind: "deprecated", Artifact: &ref, Summary: fmt.Sprintf("Deprecated %s: %s", dep.Artifact, dep.Reason), Author: dep.By})
+    }
+    for _, v := range s.versionsFor(t.Name) {
+        ref := v.Artifact
+        add(ChangeEntry{Time: v.Created, Kind: "version", Artifact: &ref, Summary: fmt.Sprintf("Tagged %s as %s", v.Artifact, v.Name), Author: v.By})
+    }
+    for _, a := range s.renamesOf(t) {
+        add(ChangeEntry{Time: a.Created, Kind: "rename", Summary: fmt.Sprintf("Renamed %s → %s", a.From, a.To), Author: a.By})
+    }
+    sort.SliceStable(out, func(i, j int) bool { return out[i].Time.After(out[j].Time) })
+    return out
+}
+
+func plural(n int) string {
+    if n == 1 { return "" }
+    return "s"
+}
+
+// changelogMarkdown renders entries grouped by day, those since since if set.
+func changelogMarkdown(track string, since time.Time, entries []ChangeEntry) string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "# %s changelog", track)
+    if !since.IsZero() { fmt.Fprintf(&b, " since %s", since.Format("2006-01-02")) }
+    b.WriteString("\n")
+    day := ""
+    for _, e := range entries {
+        if d := e.Time.Format("2006-01-02"); d != day {
+            day = d
+            fmt.Fprintf(&b, "\n## %s\n\n", d)
+        }
+        fmt.Fprintf(&b, "- %s", e.Summary)
+        if e.Author != "" { fmt.Fprintf(&b, " (%s)", e.Author) }
+        b.WriteString("\n")
+    }
+    if len(entries) == 0 { b.WriteString("\nNo changes.\n") }
+    return b.String()
+}
+
+// parseSince accepts a date (2024-06-01) or an RFC 3339 timestamp.
+func parseSince(v string) (time.Time, error) {
+    if v == "" { return time.Time{}, nil }
+    if t, err := time.Parse("2006-01-02", v); err == nil { return t, nil }
+    return time.Parse(time.RFC3339, v)
+}
+
+// GET /api/tracks/{name}/changelog[?format=md|json][&since=2024-06-01]
+func (s *Server) handleChangelog(w http.ResponseWriter, r *http.Request, t *Track) {
+    since, err := parseSince(r.URL.Query().Get("since"))
+    if err != nil { http.Error(w, "bad since: "+err.Error(), 400); return }
+    entries := s.changelog(t, since)
+    format := r.URL.Query().Get("format")
+    if format == "" && strings.Contains(r.Header.Get("Accept"), "text/markdown") { format = "md" }
+    if format == "md" || format == "markdown" {
+        w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+        w.Write([]byte(changelogMarkdown(t.Name, since, entries)))
+        return
+    }
+    writeJSON(w, entries)
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+    "cmp"
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "path"
+    "path/filepath"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "text/tabwriter"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Companion CLI ======
+//
+// For engineers who live in the terminal, the same binary installed as
+// `avcs` (go build -o avcs, or a link to avcs-browser by that name) is a
+// client of the server's API:
+//
+//   avcs tracks [--status mixdown]               the catalog and where each track is
+//   avcs latest MIDNIGHT [--kind master] [--download] [-o DIR]
+//                                                the latest master (FINAL, else
+//                                                candidate), mix, stems, session
+//                                                or bounce
+//   avcs stems MIDNIGHT --latest -o ./stems/     the newest stems set, downloaded
+//                                                in parallel, resumed and checked
+//   avcs link <path>                             a temporary link to a file, by its
+//                                                Dropbox path or its path in the
+//                                                local Dropbox folder
+//   avcs open MIDNIGHT --master final            the FINAL, or a mix, stem or
+//                                                bounce, opened in the default
+//                                                player (see open.go)
+//   avcs changelog MIDNIGHT --since 2024-06-01 --md
+//                                                what changed, as Markdown to
+//                                                paste into a chat or an email
+//   avcs name --track MIDNIGHT --kind stems --stem DRUMS
+//                                                the conventional name for a
+//                                                file exported now
+//   avcs lint ./MIDNIGHT                         what in a local export folder
+//                                                upload would reject or misfile
+//                                                (see lint.go)
+//   avcs push ./bounces/*.wav --track MIDNIGHT   name exports to the convention,
+//                                                upload them and wait for the
+//                                                index (see push.go)
+//   avcs status / avcs pull                      a local track folder against
+//                                                the server (see status.go)
+//   avcs profiles                                the servers set up
+//   avcs completion bash|zsh|fish                completion of commands, flags,
+//                                                tracks and stems sets, for the
+//                                                shell (see complete.go)
+//
+// A track typed loosely (neon, nr) or left out, and a stems set given by part
+// of its timestamps, are matched fuzzily or picked (see pick.go).
+//
+// Servers are profiles in ~/.config/avcs/config.yaml (AVCS_CONFIG), picked
+// with --profile or AVCS_PROFILE, else by `profile:`:
+//
+//   profile: studio
+//   profiles:
+//     studio:
+//       server: https://avcs.example.com
+//       key: avcs_…                # an API key, if the server needs one
+//       dropbox: ~/Dropbox         # the local Dropbox folder, for avcs link
+//     home:
+//       server: http://localhost:8080
+//
+// --server and --key (AVCS_SERVER, AVCS_API_KEY) win over the profile.
+//
+// avcs name asks the server's /api/suggest-name with this machine's clock,
+// so no time token is typed by hand. Naming a session (--kind session), or
+// giving --t1, remembers its T1 for the track for cliSessionTTL, in
+// sessions.json beside the config file; stems named meanwhile take it
+// instead of the latest snapshot's the server knows of. Mixes and masters
+// follow on from the latest stems set or mix, T1 and T2 together.
+
+// cliSessionTTL is how long a remembered T1 lasts: a working session.
+const cliSessionTTL = 12 * time.Hour
+
+// downloadAttempts is how many times a download is tried, each resuming
+// where the last stopped, before the CLI gives up on the file.
+const downloadAttempts = 3
+
+// resumeBackoff is the pause before resuming a download, times the attempt.
+const resumeBackoff = 5 * time.Second
+
+// cliProfile is a server the CLI talks to.
+type cliProfile struct {
+    name    string
+    server  string
+    key     string
+    dropbox string // local Dropbox folder
+}
+
+// cliSession is a T1 remembered for a track.
+type cliSession struct {
+    T1  string    `json:"t1"`
+    Set time.Time `json:"set"`
+}
+
+// cliClient makes API requests for the CLI, to the server of a profile.
+type cliClient struct {
+    *client.Client
+    name    string // the profile's
+    dropbox string
+}
+
+// cliConfigPath is where the CLI's profiles are kept.
+func cliConfigPath() string {
+    if p := os.Getenv("AVCS_CONFIG"); p != "" { return p }
+    dir, err := os.UserConfigDir()
+    if err != nil { dir = "." }
+    return filepath.Join(dir, "avcs", "config.yaml")
+}
+
+// cliProfiles reads the profiles and the default one's name; none if there
+// is no config file.
+func cliProfiles() (map[string]cliProfile, string, error) {
+    out := map[string]cliProfile{}
+    b, err := os.ReadFile(cliConfigPath())
+    if errors.Is(err, os.ErrNotExist) { return out, "", nil }
+    if err != nil { return nil, "", err }
+    flat := map[string]string{}
+    if err := flattenYAML(string(b), "", flat); err != nil { return nil, "", fmt.Errorf("%s: %w", cliConfigPath(), err) }
+    for k, v := range flat {
+        rest, ok := strings.CutPrefix(k, "profiles.")
+        name, field, ok2 := strings.Cut(rest, ".")
+        if !ok || !ok2 {
+            if k == "profile" { continue }
+            return nil, "", fmt.Errorf("%s: unknown setting %s", cliConfigPath(), k)
+        }
+        p := out[name]
+        p.name = name
+        switch field {
+        case "server": p.server = v
+        case "key": p.key = v
+        case "dropbox": p.dropbox = expandHome(v)
+        default: return nil, "", fmt.Errorf("%s: unknown setting %s", cliConfigPath(), k)
+        }
+        out[name] = p
+    }
+    return out, strings.ToLower(flat["profile"]), nil
+}
+
+// expandHome expands a leading ~/ in p.
+func expandHome(p string) string {
+    if rest, ok := strings.CutPrefix(p, "~/"); ok {
+        if home, err := os.UserHomeDir(); err == nil { return filepath.Join(home, rest) }
+    }
+    return p
+}
+
+// cliFlags adds the flags every command takes; connect then makes the client.
+func cliFlags(fs *flag.FlagSet) (connect func() (*cliClient, error)) {
+    profile := fs.String("profile", os.Getenv("AVCS_PROFILE"), "server profile from "+cliConfigPath())
+    server := fs.String("server", os.Getenv("AVCS_SERVER"), "A-VCS server URL, instead of the profile's")
+    key := fs.String("key", os.Getenv("AVCS_API_KEY"), "API key, instead of the profile's")
+    return func() (*cliClient, error) {
+        profiles, def, err := cliProfiles()
+        if err != nil { return nil, err }
+        name := strings.ToLower(cmp.Or(*profile, def))
+        p, ok := profiles[name]
+        if name != "" && !ok { return nil, fmt.Errorf("no profile %q in %s", name, cliConfigPath()) }
+        if name == "" && len(profiles) == 1 {
+            for _, only := range profiles { p = only }
+        }
+        api := client.New(cmp.Or(*server, p.server, "http://localhost:8080"), cmp.Or(*key, p.key))
+        api.User = os.Getenv("USER")
+        return &cliClient{Client: api, name: p.name, dropbox: p.dropbox}, nil
+    }
+}
+
+// parseCLI parses args, flags and operands mixed, as in
+// `avcs latest MIDNIGHT --kind master`, and returns the operands. While
+// completing, it hands the command's flags over instead (see complete.go).
+func parseCLI(fs *flag.FlagSet, args []string) []string {
+    if cliFlagsWanted != nil { cliFlagsWanted(fs); panic(errFlagsOnly) }
+    var operands []string
+    for {
+        fs.Parse(args)
+        if fs.NArg() == 0 { return operands }
+        operands = append(operands, fs.Arg(0))
+        args = fs.Args()[1:]
+    }
+}
+
+// download saves the Dropbox file f into dir and returns where. The bytes go
+// to name.part first, which the next attempt, or the next run, resumes; the
+// file takes its name only once its size and content hash are f's.
+func (c *cliClient) download(f FileRef, dir string) (string, error) {
+    if err := os.MkdirAll(dir, 0o755); err != nil { return "", err }
+    dst := filepath.Join(dir, path.Base(f.Path))
+    part := dst + ".part"
+    for attempt := 1; ; attempt++ {
+        err := c.fetch(f, part)
+        if err == nil {
+            ok, verr := sameContent(part, f)
+            switch {
+            case verr != nil: err = verr
+            case !ok:
+                os.Remove(part)
+                err = fmt.Errorf("%s: size or content hash differs from the server's", path.Base(f.Path))
+            default:
+                return dst, os.Rename(part, dst)
+            }
+        }
+        if attempt == downloadAttempts { return "", err }
+        time.Sleep(time.Duration(attempt) * resumeBackoff)
+    }
+}
+
+// fetch adds to the partial download part what it lacks of f, asking for
+// the rest with a Range request; a link that ignores Range starts it over.
+func (c *cliClient) fetch(f FileRef, part string) error {
+    fh, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0o644)
+    if err != nil { return err }
+    defer fh.Close()
+    off, err := fh.Seek(0, io.SeekEnd)
+    if err != nil { return err }
+    if off >= f.Size { return nil } // all there, or too much: the check decides
+    link, err := c.Link(context.Background(), f.Path)
+    if err != nil { return err }
+    req, err := http.NewRequest(http.MethodGet, link, nil)
+    if err != nil { return err }
+    if off > 0 { req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off)) }
+    res, err := c.HTTP.Do(req)
+    if err != nil { return err }
+    defer res.Body.Close()
+    switch {
+    case res.StatusCode == http.StatusPartialContent && off > 0:
+    case res.StatusCode == http.StatusOK:
+        if err := fh.Truncate(0); err != nil { return err }
+        if _, err := fh.Seek(0, io.SeekStart); err != nil { return err }
+    default:
+        return fmt.Errorf("download %s: %s", f.Path, res.Status)
+    }
+    if _, err := io.Copy(fh, res.Body); err != nil { return err }
+    return fh.Close()
+}
+
+// cliCommands are avcs's commands, by name; __complete is the shells'.
+func cliCommands() map[string]func([]string) error {
+    return map[string]func([]string) error{"tracks": cliTracks, "latest": cliLatest, "link": cliLink, "changelog": cliChangelog, "name": cliName, "lint": cliLint, "push": cliPush, "stems": cliStems, "status": cliStatus, "pull": cliPull, "profiles": cliListProfiles, "open": cliOpen, "completion": cliCompletion, "__complete": cliComplete}
+}
+
+// runCLI is the avcs command.
+func runCLI(args []string) int {
+    cmds := cliCommands()
+    if len(args) == 0 || cmds[args[0]] == nil {
+        fmt.Fprintln(os.Stderr, "usage: avcs <command> [flags]\n\ncommands:\n  tracks      list the tracks\n  latest      show or download a track's latest master, mix, stems, session or bounce\n  link        print a temporary link to a file\n  open        open a track's FINAL, or another master, mix, stem or bounce, in the player\n  changelog   print a track's changelog, as text or Markdown\n  name        print the conventional name for a file exported now\n  lint        check a local export folder before uploading it\n  push        name exports to the convention and upload them\n  stems       list a track's stems sets, or download one\n  status      compare a local track folder with the server\n  pull        download what a local track folder lacks\n  profiles    list the server profiles\n  completion  print the bash, zsh or fish completion script\n\nRun avcs <command> -h for its flags.")
+        if len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help") { return 0 }
+        return 2
+    }
+    if err := cmds[args[0]](args[1:]); err != nil {
+        fmt.Fprintln(os.Stderr, "avcs:", err)
+        return 1
+    }
+    return 0
+}
+
+// cliTracks is `avcs tracks`.
+func cliTracks(args []string) error {
+    fs := flag.NewFlagSet("tracks", flag.ExitOnError)
+    connect := cliFlags(fs)
+    status := fs.String("status", "", "only tracks at this workflow stage")
+    asJSON := fs.Bool("json", false, "print the API's JSON")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs tracks [flags]")
+        fs.PrintDefaults()
+    }
+    if len(parseCLI(fs, args)) != 0 { fs.Usage(); os.Exit(2) }
+    c, err := connect()
+    if err != nil { return err }
+    list, err := c.Tracks(context.Background(), client.TrackFilter{Status: *status})
+    if err != nil { return err }
+    if *asJSON { return printJSON(list) }
+    tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(tw, "TRACK\tSTATUS\tSESSIONS\tSTEMS\tMIXES\tMASTERS")
+    for _, t := range list {
+        fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\n", t.Name, t.Status, t.AbletonCount, t.StemSets, t.Mixes, t.MasterSets)
+    }
+    return tw.Flush()
+}
+
+// cliLatest is `avcs latest`.
+func cliLatest(args []string) error {
+    fs := flag.NewFlagSet("latest", flag.ExitOnError)
+    connect := cliFlags(fs)
+    kind := fs.String("kind", "master", "master (the FINAL, else the latest candidate), mix, stems, session or bounce")
+    download := fs.Bool("download", false, "download it (every file of a stems set)")
+    dir := fs.String("o", ".", "folder to download into")
+    asJSON := fs.Bool("json", false, "print it as JSON")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs latest [track] [flags]")
+        fs.PrintDefaults()
+    }
+    operands := parseCLI(fs, args)
+    if len(operands) > 1 { fs.Usage(); os.Exit(2) }
+    c, err := connect()
+    if err != nil { return err }
+    t, err := c.track(context.Background(), strings.Join(operands, ""))
+    if err != nil { return err }
+    a, files, err := latestArtifact(t, strings.ToLower(*kind))
+    if err != nil { return fmt.Errorf("%s: %w", t.Name, err) }
+    if *asJSON && !*download { return printJSON(map[string]any{"track": t.Name, "artifact": a, "files": files}) }
+    fmt.Printf("%s %s\n", t.Name, artifactLabel(a))
+    for _, f := range files {
+        fmt.Printf("  %s  %s", f.Path, f.ServerModified.Local().Format("2006-01-02 15:04"))
+        if f.ContributedBy != "" { fmt.Printf("  by %s", f.ContributedBy) }
+        fmt.Println()
+    }
+    if !*download { return nil }
+    to := *dir
+    if a.Kind == kindStems { to = filepath.Join(to, t.Name+"-"+a.T1+"-"+a.T2) }
+    for _, f := range files {
+        p, err := c.download(f, to)
+        if err != nil { return err }
+        fmt.Println("Saved", p)
+    }
+    return nil
+}
+
+// cliStems is `avcs stems`.
+func cliStems(args []string) error {
+    fs := flag.NewFlagSet("stems", flag.ExitOnError)
+    connect := cliFlags(fs)
+    latest := fs.Bool("latest", false, "download the newest stems set")
+    set := fs.String("set", "", "download this stems set, as T1-T2 or enough of it to tell")
+    choose := fs.Bool("i", false, "pick the set to download")
+    dir := fs.String("o", "", "folder to download into (default: TRACK-T1-T2)")
+    jobs := fs.Int("j", 4, "how many stems to download at once")
+    asJSON := fs.Bool("json", false, "list the sets as JSON")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs stems [track] [--latest | --set T1-T2 | -i] [flags]")
+        fs.PrintDefaults()
+    }
+    operands := parseCLI(fs, args)
+    if len(operands) > 1 || *latest && *set != "" || *choose && (*latest || *set != "") { fs.Usage(); os.Exit(2) }
+    c, err := connect()
+    if err != nil { return err }
+    t, err := c.track(context.Background(), strings.Join(operands, ""))
+    if err != nil { return err }
+    sets := newestSets(t)
+
+    var chosen *StemsSet
+    if *set != "" || *choose {
+        labels := versionLabels(t, kindStems)
+        label, err := pick("stems set", *set, labels)
+        if err != nil { return fmt.Errorf("%s: %w", t.Name, err) }
+        chosen = &sets[slices.Index(labels, label)]
+    }
+    for i := range sets {
+        if *latest && len(sets[i].Stems) > 0 { chosen = &sets[i]; break }
+    }
+    switch {
+    case !*latest && chosen == nil:
+        if *asJSON { return printJSON(sets) }
+        if len(sets) == 0 { fmt.Printf("%s has no stems yet\n", t.Name); return nil }
+        tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        fmt.Fprintln(tw, "SET\tLATEST\tSTEMS")
+        for _, s := range sets {
+            names := make([]string, len(s.Stems))
+            for i, f := range s.Stems { names[i] = strings.TrimSuffix(f.Name, path.Ext(f.Name)) }
+            fmt.Fprintf(tw, "%s-%s\t%s\t%s\n", s.T1, s.T2, s.Latest.Local().Format("2006-01-02 15:04"), strings.Join(names, " "))
+        }
+        return tw.Flush()
+    case chosen == nil:
+        return fmt.Errorf("%s: no stems yet", t.Name)
+    }
+
+    to := cmp.Or(*dir, t.Name+"-"+chosen.T1+"-"+chosen.T2)
+    fmt.Printf("%s stems %s-%s: %d file%s into %s\n", t.Name, chosen.T1, chosen.T2, len(chosen.Stems), plural(len(chosen.Stems)), to)
+    queue := make(chan FileRef)
+    var (
+        wg     sync.WaitGroup
+        mu     sync.Mutex
+        failed []string
+    )
+    for range max(*jobs, 1) {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for f := range queue {
+                p := filepath.Join(to, path.Base(f.Path))
+                verb := "Have"
+                var err error
+                if same, _ := sameContent(p, f); !same { verb = "Saved"; p, err = c.download(f, to) }
+                mu.Lock()
+                if err != nil {
+                    failed = append(failed, path.Base(f.Path))
+                    fmt.Fprintln(os.Stderr, "avcs:", err)
+                } else {
+                    fmt.Println(verb, p)
+                }
+                mu.Unlock()
+            }
+        }()
+    }
+    for _, f := range chosen.Stems { queue <- f }
+    close(queue)
+    wg.Wait()
+    if len(failed) > 0 {
+        return fmt.Errorf("%d of %d stems not downloaded (%s); run again to resume", len(failed), len(chosen.Stems), strings.Join(failed, ", "))
+    }
+    fmt.Printf("All %d in %s, sizes and content hashes checked\n", len(chosen.Stems), to)
+    return nil
+}
+
+// artifactLabel names a as in "master 1040P-1130P FINAL".
+func artifactLabel(a ArtifactRef) string {
+    return strings.TrimSpace(strings.Join([]string{a.Kind, strings.Trim(a.T1+"-"+a.T2, "-"), a.Idx}, " "))
+}
+
+// latestArtifact picks t's latest artifact of kind and its files.
+func latestArtifact(t *Track, kind string) (ArtifactRef, []FileRef, error) {
+    switch kind {
+    case "master", "final":
+        var a ArtifactRef
+        var best *FileRef
+        for _, m := range t.Masters {
+            if m.Final != nil && (best == nil || a.Idx != "FINAL" || m.Final.ServerModified.After(best.ServerModified)) {
+                a, best = ArtifactRef{Kind: kindMaster, T1: m.T1, T2: m.T2, Idx: "FINAL"}, m.Final
+            }
+            for i := range m.Candidates {
+                c := &m.Candidates[i]
+                if a.Idx != "FINAL" && (best == nil || c.ServerModified.After(best.ServerModified)) {
+                    a, best = ArtifactRef{Kind: kindMaster, T1: m.T1, T2: m.T2, Idx: candidateIdx(*c)}, c
+                }
+            }
+        }
+        if best == nil { return a, nil, errors.New("no masters yet") }
+        return a, []FileRef{*best}, nil
+    case "mix":
+        if len(t.Mixes) == 0 { return ArtifactRef{}, nil, errors.New("no mixes yet") }
+        m := t.Mixes[0]
+        for _, x := range t.Mixes[1:] {
+            if x.File.ServerModified.After(m.File.ServerModified) { m = x }
+        }
+        return ArtifactRef{Kind: kindMix, T1: m.T1, T2: m.T2}, []FileRef{m.File}, nil
+    case "stems":
+        var latest *StemsSet
+        for i := range t.Stems {
+            if len(t.Stems[i].Stems) > 0 && (latest == nil || t.Stems[i].Latest.After(latest.Latest)) { latest = &t.Stems[i] }
+        }
+        if latest == nil { return ArtifactRef{}, nil, errors.New("no stems yet") }
+        return ArtifactRef{Kind: kindStems, T1: latest.T1, T2: latest.T2}, latest.Stems, nil
+    case "session", "bounce":
+        snaps := append([]AbletonSnap(nil), t.Ableton...)
+        sort.Slice(snaps, func(i, j int) bool { return snaps[i].Latest.After(snaps[j].Latest) })
+        for _, snap := range snaps {
+            f := cmp.Or(snap.ALS, snap.Session)
+            if kind == "bounce" { f = cmp.Or(snap.WAV, snap.MP3) }
+            if f != nil { return ArtifactRef{Kind: kindSnapshot, T1: snap.T1}, []FileRef{*f}, nil }
+        }
+        return ArtifactRef{}, nil, fmt.Errorf("no %ss yet", kind)
+    }
+    return ArtifactRef{}, nil, fmt.Errorf("kind must be master, mix, stems, session or bounce, not %q", kind)
+}
+
+// cliLink is `avcs link`.
+func cliLink(args []string) error {
+    fs := flag.NewFlagSet("link", flag.ExitOnError)
+    connect := cliFlags(fs)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs link [flags] <Dropbox path, or a file in the local Dropbox folder>")
+        fs.PrintDefaults()
+    }
+    operands := parseCLI(fs, args)
+    if len(operands) != 1 { fs.Usage(); os.Exit(2) }
+    c, err := connect()
+    if err != nil { return err }
+    p := operands[0]
+    if c.dropbox != "" {
+        if abs, err := filepath.Abs(p); err == nil {
+            if rel, err := filepath.Rel(c.dropbox, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+                p = path.Join("/", filepath.ToSlash(rel))
+            }
+        }
+    }
+    link, err := c.Link(context.Background(), p)
+    if err != nil { return err }
+    fmt.Println(link)
+    return nil
+}
+
+// cliSessionsPath is where remembered T1s are kept.
+func cliSessionsPath() string { return filepath.Join(filepath.Dir(cliConfigPath()), "sessions.json") }
+
+// cliSessions reads the remembered T1s, by server and track.
+func cliSessions() map[string]cliSession {
+    out := map[string]cliSession{}
+    if b, err := os.ReadFile(cliSessionsPath()); err == nil { json.Unmarshal(b, &out) }
+    for k, v := range out {
+        if time.Since(v.Set) > cliSessionTTL { delete(out, k) }
+    }
+    return out
+}
+
+// cliName is `avcs name`.
+func cliName(args []string) error {
+    fs := flag.NewFlagSet("name", flag.ExitOnError)
+    connect := cliFlags(fs)
+    track := fs.String("track", "", "the track (required)")
+    kind := fs.String("kind", "", "session, stems, mix, print or master (required)")
+    stem := fs.String("stem", "", "the stem, for stems: DRUMS, BASS, ...")
+    chain := fs.String("chain", "", "what a print went through: SSL_TAPE, ...")
+    idx := fs.String("idx", "", "the master index (default: the next free one)")
+    t1 := fs.String("t1", "", "T1, to use and remember for the track (default for stems: remembered, else the latest snapshot's)")
+    t2 := fs.String("t2", "", "T2, for a mix, print or master (default: the latest stems set's or mix's)")
+    ext := fs.String("ext", "als", "the session file's extension")
+    asJSON := fs.Bool("json", false, "print the suggestion as JSON")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs name --track <track> --kind <kind> [flags]")
+        fs.PrintDefaults()
+    }
+    if len(parseCLI(fs, args)) != 0 || *track == "" || *kind == "" { fs.Usage(); os.Exit(2) }
+    k := cliKind(*kind)
+    c, err := connect()
+    if err != nil { return err }
+
+    sessions := cliSessions()
+    key := c.Server + " " + strings.ToUpper(*track)
+    nr := client.NameRequest{Filename: "export.wav", Track: *track, Kind: k, Time: timeToken(time.Now()), Stem: *stem, Chain: *chain, Idx: *idx, T1: *t1, T2: *t2}
+    if k == kindSnapshot { nr.Filename = "export." + strings.TrimPrefix(*ext, ".") }
+    if was, ok := sessions[key]; ok && *t1 == "" && k == kindStem {
+        nr.T1 = was.T1
+        fmt.Fprintf(os.Stderr, "t1 %s, remembered from %s (--t1 to change)\n", was.T1, was.Set.Local().Format("15:04"))
+    }
+    sg, err := c.SuggestName(context.Background(), nr)
+    if err != nil { return err }
+    for _, a := range sg.Assumed { fmt.Fprintln(os.Stderr, a) }
+    if np, ok := classifyName(sg.Name); ok && (k == kindSnapshot || *t1 != "") {
+        sessions[c.Server+" "+sg.Track] = cliSession{T1: np.T1, Set: time.Now()}
+        b, _ := json.MarshalIndent(sessions, "", "  ")
+        if err := os.MkdirAll(filepath.Dir(cliSessionsPath()), 0o755); err != nil { return err }
+        if err := writeFileAtomic(cliSessionsPath(), b); err != nil { return err }
+    }
+    if *asJSON { return printJSON(sg) }
+    fmt.Println(sg.Name)
+    return nil
+}
+
+// cliChangelog is `avcs changelog`.
+func cliChangelog(args []string) error {
+    fs := flag.NewFlagSet("changelog", flag.ExitOnError)
+    connect := cliFlags(fs)
+    since := fs.String("since", "", "only changes from this date (2024-06-01) or time (RFC 3339) on")
+    only := fs.String("only", "", "only these kinds of change, as stems,mix,masters,final (also session, note, comment, status, rename, deprecated, version)")
+    md := fs.Bool("md", false, "print it as Markdown, to paste into a chat or an email")
+    asJSON := fs.Bool("json", false, "print the API's JSON")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs changelog [track] [flags]")
+        fs.PrintDefaults()
+    }
+    operands := parseCLI(fs, args)
+    if len(operands) > 1 { fs.Usage(); os.Exit(2) }
+    from, err := parseSince(*since)
+    if err != nil { return fmt.Errorf("--since must be a date like 2024-06-01 or an RFC 3339 time") }
+    c, err := connect()
+    if err != nil { return err }
+    t, err := c.track(context.Background(), strings.Join(operands, ""))
+    if err != nil { return err }
+    name := t.Name
+    entries, err := c.Changelog(context.Background(), name, from)
+    if err != nil { return err }
+    if kinds := strings.Split(strings.ToLower(*only), ","); *only != "" {
+        entries = slices.DeleteFunc(entries, func(e ChangeEntry) bool { return !slices.Contains(kinds, e.Kind) })
+    }
+    for i := range entries { entries[i].Time = entries[i].Time.Local() }
+    switch {
+    case *asJSON:
+        return printJSON(entries)
+    case *md:
+        fmt.Print(changelogMarkdown(name, from, entries))
+        return nil
+    }
+    tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    for _, e := range entries {
+        fmt.Fprintf(tw, "%s\t%s", e.Time.Format("2006-01-02 15:04"), e.Summary)
+        if e.Author != "" { fmt.Fprintf(tw, "\t%s", e.Author) }
+        fmt.Fprintln(tw)
+    }
+    if len(entries) == 0 { fmt.Fprintln(tw, "No changes.") }
+    return tw.Flush()
+}
+
+// cliKind is the kind of file the CLI's --kind names, as the API has it.
+func cliKind(kind string) string {
+    k := strings.ToLower(strings.TrimSpace(kind))
+    if alias, ok := map[string]string{"session": kindSnapshot, "stems": kindStem, "mixes": kindMix, "prints": kindPrint, "masters": kindMaster}[k]; ok { return alias }
+    return k
+}
+
+// cliListProfiles is `avcs profiles`.
+func cliListProfiles(args []string) error {
+    fs := flag.NewFlagSet("profiles", flag.ExitOnError)
+    connect := cliFlags(fs)
+    if len(parseCLI(fs, args)) != 0 { fs.Usage(); os.Exit(2) }
+    profiles, _, err := cliProfiles()
+    if err != nil { return err }
+    c, err := connect()
+    if err != nil { return err }
+    if len(profiles) == 0 { fmt.Printf("No profiles in %s; using %s\n", cliConfigPath(), c.Server); return nil }
+    var names []string
+    for n := range profiles { names = append(names, n) }
+    sort.Strings(names)
+    tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    for _, n := range names {
+        mark := " "
+        if n == c.name { mark = "*" }
+        fmt.Fprintf(tw, "%s %s\t%s\n", mark, n, profiles[n].server)
+    }
+    return tw.Flush()
+}
+
+// printJSON prints v indented.
+func printJSON(v any) error {
+    enc := json.NewEncoder(os.Stdout)
+    enc.SetIndent("", "  ")
+    return enc.Encode(v)
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+    "context"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+// ====== Catalog ======
+//
+// What spans the tracks: search, tags and the workflow board, projects, who
+// worked on what, aliases of renamed tracks, the inbox and the baselines
+// files are verified against.
+
+// SearchQuery is a full-text search of the lyrics and notes. Only Q is
+// required.
+type SearchQuery struct {
+    Q        string // every word, in any case
+    In       string // lyrics or notes; both if empty
+    Current  bool   // the current lyrics only
+    Archived bool   // archived tracks and revisions too
+    Limit    int    // the server's default (100) if zero
+}
+
+// SearchResults are the lines that matched, at most the query's limit of
+// Total.
+type SearchResults struct {
+    Q     string      `json:"q"`
+    Total int         `json:"total"`
+    Hits  []SearchHit `json:"hits"`
+}
+
+// Search finds the lines holding all of q's words (GET /api/search).
+func (c *Client) Search(ctx context.Context, q SearchQuery) (*SearchResults, error) {
+    v := url.Values{"q": {q.Q}}
+    if q.In != "" { v.Set("in", q.In) }
+    if q.Current { v.Set("current", "1") }
+    if q.Archived { v.Set("archived", "1") }
+    if q.Limit > 0 { v.Set("limit", strconv.Itoa(q.Limit)) }
+    var out SearchResults
+    if err := c.get(ctx, "/api/search?"+v.Encode(), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// TagCount is a tag in use and how much carries it.
+type TagCount struct {
+    Tag       string `json:"tag"`
+    Artifacts int    `json:"artifacts"`
+    Tracks    int    `json:"tracks"`
+}
+
+// AllTags are the tags in use across the catalog.
+func (c *Client) AllTags(ctx context.Context) ([]TagCount, error) {
+    var out []TagCount
+    return out, c.get(ctx, "/api/tags", &out)
+}
+
+// Workflow is the workflow's stages and the tracks at each.
+type Workflow struct {
+    Stages      []string            `json:"stages"`      // in order
+    Transitions map[string][]string `json:"transitions"` // the stages each may move to
+    Board       map[string][]string `json:"board"`       // tracks by stage
+}
+
+// Workflow is the workflow board.
+func (c *Client) Workflow(ctx context.Context) (*Workflow, error) {
+    var out Workflow
+    if err := c.get(ctx, "/api/workflow", &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ====== Projects ======
+
+// Projects are the projects, with their tracks rolled up.
+func (c *Client) Projects(ctx context.Context) ([]ProjectSummary, error) {
+    var out []ProjectSummary
+    return out, c.get(ctx, "/api/projects", &out)
+}
+
+// Project is the project name, as NIGHT_DRIVE, with each of its tracks.
+func (c *Client) Project(ctx context.Context, name string) (*ProjectDetail, error) {
+    var out ProjectDetail
+    if err := c.get(ctx, "/api/projects/"+url.PathEscape(name), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ====== People ======
+
+// Contributors are who added files, to track if given, since since (ever
+// if zero), the most files first.
+func (c *Client) Contributors(ctx context.Context, track string, since time.Time) ([]ContributorStats, error) {
+    q := url.Values{}
+    if track != "" { q.Set("track", track) }
+    if !since.IsZero() { q.Set("since", since.Format(time.RFC3339)) }
+    var out []ContributorStats
+    return out, c.get(ctx, "/api/contributors?"+q.Encode(), &out)
+}
+
+// People are everyone credited or contributing whose ID holds query, and
+// who did as (played, mixed, mastered, credited) if given; the most tracks
+// first.
+func (c *Client) People(ctx context.Context, query, as string) ([]Person, error) {
+    q := url.Values{}
+    if query != "" { q.Set("q", query) }
+    if as != "" { q.Set("as", as) }
+    var out []Person
+    return out, c.get(ctx, "/api/people?"+q.Encode(), &out)
+}
+
+// Person is the person id, as dana-lee, with their credits by track.
+func (c *Client) Person(ctx context.Context, id string) (*PersonDetail, error) {
+    var out PersonDetail
+    if err := c.get(ctx, "/api/people/"+url.PathEscape(id), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ====== Aliases ======
+
+// Aliases are the declared track aliases.
+func (c *Client) Aliases(ctx context.Context) ([]TrackAlias, error) {
+    var out []TrackAlias
+    return out, c.get(ctx, "/api/aliases", &out)
+}
+
+// AddAlias files what is named from under the track to, as when a song is
+// retitled; the index is rebuilt.
+func (c *Client) AddAlias(ctx context.Context, from, to string) (*TrackAlias, error) {
+    var out TrackAlias
+    if err := c.send(ctx, http.MethodPost, "/api/aliases", map[string]string{"from": from, "to": to}, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// RemoveAlias makes the files named from a track of their own again.
+func (c *Client) RemoveAlias(ctx context.Context, from string) error {
+    return c.Do(ctx, http.MethodDelete, "/api/aliases/"+url.PathEscape(from), nil, nil, nil)
+}
+
+// ====== Inbox ======
+
+// Inbox is what waits in the inbox, with a name proposed for each file.
+func (c *Client) Inbox(ctx context.Context) ([]InboxItem, error) {
+    var out []InboxItem
+    return out, c.get(ctx, "/api/inbox", &out)
+}
+
+// FileInbox files the inbox file at p as name, or under the name proposed
+// for it if name is empty.
+func (c *Client) FileInbox(ctx context.Context, p, name string) (*UploadTarget, error) {
+    var out UploadTarget
+    in := map[string]string{"path": p, "name": name}
+    if err := c.send(ctx, http.MethodPost, "/api/inbox/file", in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// FileInboxAs files the inbox file at p under the name proposed from what
+// r says of it, over what the server guessed.
+func (c *Client) FileInboxAs(ctx context.Context, p string, r NameRequest) (*UploadTarget, error) {
+    var out UploadTarget
+    in := map[string]string{"path": p}
+    for k, v := range map[string]string{"track": r.Track, "kind": r.Kind, "time": r.Time, "tz": r.TZ, "t1": r.T1, "t2": r.T2, "stem": r.Stem, "idx": r.Idx} {
+        if v != "" { in[k] = v }
+    }
+    if err := c.send(ctx, http.MethodPost, "/api/inbox/file", in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// RejectInbox moves the inbox file at p to the trash.
+func (c *Client) RejectInbox(ctx context.Context, p string) (*TrashItem, error) {
+    var out TrashItem
+    if err := c.send(ctx, http.MethodPost, "/api/inbox/reject", map[string]string{"path": p}, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ====== Integrity ======
+
+// Verification is what Verify found.
+type Verification struct {
+    Checked  int            `json:"checked"`
+    OK       int            `json:"ok"`
+    Rehashed bool           `json:"rehashed"`
+    Problems []VerifyResult `json:"problems"`
+}
+
+// Baselines are the content hashes the track's files (every track's if
+// empty) are expected to keep.
+func (c *Client) Baselines(ctx context.Context, track string) ([]Baseline, error) {
+    var out []Baseline
+    return out, c.get(ctx, "/api/baselines?"+url.Values{"track": {track}}.Encode(), &out)
+}
+
+// Pin records artifact a's files as they are now as their baselines.
+func (c *Client) Pin(ctx context.Context, track string, a ArtifactRef) ([]Baseline, error) {
+    var out []Baseline
+    in := map[string]any{"track": track, "artifact": a}
+    return out, c.send(ctx, http.MethodPost, "/api/baselines", in, &out)
+}
+
+// Unpin drops the baseline of the file at p.
+func (c *Client) Unpin(ctx context.Context, p string) error {
+    return c.Do(ctx, http.MethodDelete, "/api/baselines?"+url.Values{"path": {p}}.Encode(), nil, nil, nil)
+}
+
+// Verify checks the track's files (every track's if empty) against their
+// baselines; rehash downloads them to check Dropbox's hashes too, which the
+// server only does in a maintenance window unless force.
+func (c *Client) Verify(ctx context.Context, track string, rehash, force bool) (*Verification, error) {
+    uri := "/api/verify"
+    if force { uri += "?force=1" }
+    var out Verification
+    in := map[string]any{"track": track, "rehash": rehash}
+    if err := c.send(ctx, http.MethodPost, uri, in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ManifestKey is the public ed25519 key, base64, that the server signs
+// manifests with.
+func (c *Client) ManifestKey(ctx context.Context) (string, error) {
+    var out struct {
+        PublicKey string `json:"public_key"`
+    }
+    if err := c.get(ctx, "/api/manifest-key", &out); err != nil { return "", err }
+    return out.PublicKey, nil
+}
@@ -0,0 +1,114 @@
+// Package client is a Go client of the A-VCS server's API, for tools that
+// work with the catalog (render farms, bots, scripts) without hand-rolling
+// its JSON. The types are the server's own: it serves what this package
+// declares.
+//
+//   c := client.New("https://avcs.example.com", os.Getenv("AVCS_API_KEY"))
+//   t, err := c.Track(ctx, "MIDNIGHT")
+//   ...
+//   link, err := c.Link(ctx, t.Mixes[0].File.Path)
+//
+// Every endpoint of the catalog, the tracks and their files, releases,
+// shares and A/B sessions has a typed method here. The administrative
+// endpoints (keys, roles, webhooks, restrictions, audit and access logs,
+// backups, retention, migration, maintenance, diagnostics) are reached with
+// Do.
+package client
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// ====== Client ======
+
+// Client makes requests to one server's API. Its fields may be set directly;
+// a Client is safe for concurrent use once they are.
+type Client struct {
+    Server string       // the server's URL, as https://avcs.example.com
+    Key    string       // an API key, if the server requires one
+    User   string       // attributed the changes, where the server has no keys (X-AVCS-User)
+    HTTP   *http.Client // http.DefaultClient if nil
+}
+
+// New is a client of the server at server, authenticating with key if given.
+func New(server, key string) *Client {
+    return &Client{Server: strings.TrimSuffix(server, "/"), Key: key, HTTP: &http.Client{}}
+}
+
+// Error is a reply other than success from the server.
+type Error struct {
+    Code int    // the HTTP status
+    Msg  string // the status line and the server's message
+}
+
+func (e Error) Error() string { return e.Msg }
+
+// IsNotFound reports whether err is the server's 404, as for a track it
+// does not have.
+func IsNotFound(err error) bool {
+    var e Error
+    return errors.As(err, &e) && e.Code == http.StatusNotFound
+}
+
+// Do makes one API request to uri (a path, with its query), with the extra
+// headers hdr, and decodes a JSON reply into out if given.
+func (c *Client) Do(ctx context.Context, method, uri string, body io.Reader, hdr http.Header, out any) error {
+    resp, err := c.request(ctx, method, uri, body, hdr)
+    if err != nil { return err }
+    defer resp.Body.Close()
+    b, _ := io.ReadAll(resp.Body)
+    if out != nil && len(b) > 0 { return json.Unmarshal(b, out) }
+    return nil
+}
+
+// Open makes a GET request to uri and hands back the reply's body, for
+// downloads (zips, audio, images) too big to hold; the caller closes it.
+func (c *Client) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+    resp, err := c.request(ctx, http.MethodGet, uri, nil, nil)
+    if err != nil { return nil, err }
+    return resp.Body, nil
+}
+
+// request makes one API request; a reply other than success is an Error.
+func (c *Client) request(ctx context.Context, method, uri string, body io.Reader, hdr http.Header) (*http.Response, error) {
+    req, err := http.NewRequestWithContext(ctx, method, c.Server+uri, body)
+    if err != nil { return nil, err }
+    for k, v := range hdr { req.Header[k] = v }
+    req.Header.Set("Tus-Resumable", "1.0.0")
+    if c.User != "" { req.Header.Set("X-AVCS-User", c.User) }
+    if c.Key != "" { req.Header.Set("Authorization", "Bearer "+c.Key) }
+    hc := c.HTTP
+    if hc == nil { hc = http.DefaultClient }
+    resp, err := hc.Do(req)
+    if err != nil { return nil, err }
+    if resp.StatusCode >= 300 {
+        b, _ := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        return nil, Error{resp.StatusCode, resp.Status + ": " + strings.TrimSpace(string(b))}
+    }
+    return resp, nil
+}
+
+// send makes a request with in as its JSON body.
+func (c *Client) send(ctx context.Context, method, uri string, in, out any) error {
+    b, err := json.Marshal(in)
+    if err != nil { return err }
+    return c.Do(ctx, method, uri, bytes.NewReader(b), http.Header{"Content-Type": {"application/json"}}, out)
+}
+
+// get makes a GET request.
+func (c *Client) get(ctx context.Context, uri string, out any) error {
+    return c.Do(ctx, http.MethodGet, uri, nil, nil, out)
+}
+
+// absolute makes a link the server gave relative to itself (the demo's) whole.
+func (c *Client) absolute(link string) string {
+    if strings.HasPrefix(link, "/") { return c.Server + link }
+    return link
+}
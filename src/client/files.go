@@ -0,0 +1,203 @@
+package client
+
+import (
+    "context"
+    "encoding/base64"
+    "errors"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+// ====== Files ======
+//
+// Files are uploaded under conventional names only: SuggestName makes one
+// from a loose file name and what is known of the file. Up to 150 MB a file
+// goes in one request, through the server (Upload) or straight to Dropbox on
+// a link the server issues (UploadLink); larger ones go through
+// UploadResumable, which picks up where a dropped connection left off.
+
+// MaxSimpleUpload is the most Upload and UploadLink take.
+const MaxSimpleUpload = 150 << 20
+
+// resumeBackoff is the pause before resuming an upload, times the attempt.
+const resumeBackoff = 5 * time.Second
+
+// Move is one file the server moved.
+type Move struct {
+    From string `json:"from"`
+    To   string `json:"to"`
+}
+
+// Link is a temporary link to the Dropbox file at p, to download or play it.
+func (c *Client) Link(ctx context.Context, p string) (string, error) {
+    var out struct {
+        URL string `json:"url"`
+    }
+    if err := c.get(ctx, "/api/link?path="+url.QueryEscape(p), &out); err != nil { return "", err }
+    return c.absolute(out.URL), nil
+}
+
+// Sign is a signed URL for uri, an /api/link or A/B stream path the caller
+// may fetch now, that works without a key until it expires.
+func (c *Client) Sign(ctx context.Context, uri string) (string, time.Time, error) {
+    var out struct {
+        URL     string    `json:"url"`
+        Expires time.Time `json:"expires"`
+    }
+    if err := c.get(ctx, "/api/sign?url="+url.QueryEscape(uri), &out); err != nil { return "", time.Time{}, err }
+    return c.absolute(out.URL), out.Expires, nil
+}
+
+// NameRequest is what is known about a file to be named. Only Filename is
+// required; the server guesses the rest from it and the catalog.
+type NameRequest struct {
+    Filename string
+    Track    string
+    Kind     string // snapshot, stem, mix, print, master
+    Time     string // RFC 3339 or a T token as 0430A; default now, by the server's clock
+    TZ       string // for Time and now; default the server's zone
+    T1, T2   string
+    Stem     string
+    Chain    string // of a print
+    Idx      string
+}
+
+// SuggestName is the conventional name for the file r describes.
+func (c *Client) SuggestName(ctx context.Context, r NameRequest) (*Suggestion, error) {
+    q := url.Values{"filename": {r.Filename}}
+    for k, v := range map[string]string{"track": r.Track, "kind": r.Kind, "time": r.Time, "tz": r.TZ, "t1": r.T1, "t2": r.T2, "stem": r.Stem, "chain": r.Chain, "idx": r.Idx} {
+        if v != "" { q.Set(k, v) }
+    }
+    var out Suggestion
+    if err := c.get(ctx, "/api/suggest-name?"+q.Encode(), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Uploaded is a file Upload put in place.
+type Uploaded struct {
+    Track         string  `json:"track"`
+    Kind          string  `json:"kind"`
+    File          FileRef `json:"file"`
+    CorrectedFrom string  `json:"corrected_from,omitempty"`
+}
+
+// Upload uploads r, up to MaxSimpleUpload, as name into the track folder's
+// layout. An existing file of that name is an error unless overwrite.
+func (c *Client) Upload(ctx context.Context, name string, r io.Reader, overwrite bool) (*Uploaded, error) {
+    var out Uploaded
+    if err := c.Do(ctx, http.MethodPost, "/api/upload?"+uploadQuery(name, overwrite), r, nil, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// UploadResumable uploads the size bytes of f as name through the server's
+// resumable uploads, resuming from the server's offset after a dropped
+// connection, and returns the finished upload.
+func (c *Client) UploadResumable(ctx context.Context, name string, f io.ReadSeeker, size int64, overwrite bool) (*Upload, error) {
+    hdr := http.Header{"Upload-Length": {strconv.FormatInt(size, 10)}, "Upload-Metadata": {"filename " + base64.StdEncoding.EncodeToString([]byte(name))}}
+    uri := "/api/uploads"
+    if overwrite { uri += "?overwrite=1" }
+    var u Upload
+    if err := c.Do(ctx, http.MethodPost, uri, nil, hdr, &u); err != nil { return nil, err }
+    for attempt := 0; ; attempt++ {
+        if _, err := f.Seek(u.Offset, io.SeekStart); err != nil { return nil, err }
+        hdr := http.Header{"Upload-Offset": {strconv.FormatInt(u.Offset, 10)}, "Content-Type": {"application/offset+octet-stream"}}
+        err := c.Do(ctx, http.MethodPatch, "/api/uploads/"+u.ID, f, hdr, nil)
+        if err == nil {
+            err = c.get(ctx, "/api/uploads/"+u.ID, &u)
+            return &u, err
+        }
+        if attempt == 5 { return nil, err }
+        select {
+        case <-ctx.Done(): return nil, ctx.Err()
+        case <-time.After(time.Duration(attempt+1) * resumeBackoff):
+        }
+        if err := c.get(ctx, "/api/uploads/"+u.ID, &u); err != nil { return nil, err }
+        if u.Status != "uploading" { return nil, errors.New("upload " + u.Status + ": " + u.Error) }
+    }
+}
+
+// Uploads are the resumable uploads the server keeps, newest first.
+func (c *Client) Uploads(ctx context.Context) ([]Upload, error) {
+    var out []Upload
+    return out, c.get(ctx, "/api/uploads", &out)
+}
+
+// UploadStatus is the resumable upload id and its progress.
+func (c *Client) UploadStatus(ctx context.Context, id string) (*Upload, error) {
+    var out Upload
+    if err := c.get(ctx, "/api/uploads/"+url.PathEscape(id), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// AbandonUpload gives up the resumable upload id.
+func (c *Client) AbandonUpload(ctx context.Context, id string) error {
+    return c.Do(ctx, http.MethodDelete, "/api/uploads/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// UploadLink is a single-use Dropbox link to POST a file named name to
+// (application/octet-stream, up to MaxSimpleUpload), so its bytes do not
+// pass through the server. Reindex once the files are in.
+func (c *Client) UploadLink(ctx context.Context, name string, overwrite bool) (*UploadLink, error) {
+    var out UploadLink
+    if err := c.Do(ctx, http.MethodPost, "/api/upload-link?"+uploadQuery(name, overwrite), nil, nil, &out); err != nil { return nil, err }
+    out.Link = c.absolute(out.Link)
+    return &out, nil
+}
+
+// uploadQuery is the query of an upload of name.
+func uploadQuery(name string, overwrite bool) string {
+    q := url.Values{"name": {name}}
+    if overwrite { q.Set("overwrite", "1") }
+    return q.Encode()
+}
+
+// Reindex has the server read the Dropbox folders again.
+func (c *Client) Reindex(ctx context.Context) error {
+    return c.Do(ctx, http.MethodPost, "/api/reindex", nil, nil, nil)
+}
+
+// Rename gives the file at p the conventional name to, moving a stem to
+// the stems folder of its new timestamps.
+func (c *Client) Rename(ctx context.Context, p, to string) (*RenameOp, error) {
+    var out RenameOp
+    if err := c.send(ctx, http.MethodPost, "/api/files/rename", map[string]string{"path": p, "to": to}, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Delete moves the files at paths to the trash, from which Undelete brings
+// them back until the server's grace period is over.
+func (c *Client) Delete(ctx context.Context, paths ...string) (*TrashItem, error) {
+    var out TrashItem
+    if err := c.send(ctx, http.MethodPost, "/api/files/delete", map[string]any{"paths": paths}, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// DeleteArtifact moves every file of the track's artifact a to the trash.
+func (c *Client) DeleteArtifact(ctx context.Context, track string, a ArtifactRef) (*TrashItem, error) {
+    var out TrashItem
+    if err := c.send(ctx, http.MethodPost, "/api/files/delete", map[string]any{"track": track, "artifact": a}, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Trash is what was deleted and may still be brought back.
+func (c *Client) Trash(ctx context.Context) ([]TrashItem, error) {
+    var out []TrashItem
+    return out, c.get(ctx, "/api/trash", &out)
+}
+
+// TrashEntry is the trash item id.
+func (c *Client) TrashEntry(ctx context.Context, id string) (*TrashItem, error) {
+    var out TrashItem
+    if err := c.get(ctx, "/api/trash/"+url.PathEscape(id), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Undelete moves the files of trash item id back where they were.
+func (c *Client) Undelete(ctx context.Context, id string) (*TrashItem, error) {
+    var out TrashItem
+    if err := c.Do(ctx, http.MethodPost, "/api/trash/"+url.PathEscape(id)+"/undelete", nil, nil, &out); err != nil { return nil, err }
+    return &out, nil
+}
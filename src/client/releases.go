@@ -0,0 +1,195 @@
+package client
+
+import (
+    "context"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+)
+
+// ====== Releases ======
+
+// Releases are the releases, the latest release date first.
+func (c *Client) Releases(ctx context.Context) ([]ResolvedRelease, error) {
+    var out []ResolvedRelease
+    return out, c.get(ctx, "/api/releases", &out)
+}
+
+// Release is the release id, with the FINAL each position resolves to.
+func (c *Client) Release(ctx context.Context, id string) (*ResolvedRelease, error) {
+    var out ResolvedRelease
+    if err := c.get(ctx, releaseURI(id, ""), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// CreateRelease makes a release of in's fields; it needs at least a title.
+func (c *Client) CreateRelease(ctx context.Context, in ReleaseChange) (*ResolvedRelease, error) {
+    var out ResolvedRelease
+    if err := c.send(ctx, http.MethodPost, "/api/releases", in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// UpdateRelease sets the fields in gives of the release id.
+func (c *Client) UpdateRelease(ctx context.Context, id string, in ReleaseChange) (*ResolvedRelease, error) {
+    var out ResolvedRelease
+    if err := c.send(ctx, http.MethodPatch, releaseURI(id, ""), in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// DeleteRelease deletes the release id; its tracks and files stay.
+func (c *Client) DeleteRelease(ctx context.Context, id string) error {
+    return c.Do(ctx, http.MethodDelete, releaseURI(id, ""), nil, nil, nil)
+}
+
+// ReleasePackage is the ready release as a zip of tagged masters, artwork
+// and metadata, for a distributor; the caller closes it.
+func (c *Client) ReleasePackage(ctx context.Context, id string) (io.ReadCloser, error) {
+    return c.Open(ctx, releaseURI(id, "package"))
+}
+
+// ReleaseDDP is the ready release as a zipped DDP 2.00 fileset, for a CD
+// plant; the caller closes it.
+func (c *Client) ReleaseDDP(ctx context.Context, id string) (io.ReadCloser, error) {
+    return c.Open(ctx, releaseURI(id, "ddp"))
+}
+
+// MetadataQuery says how ReleaseMetadata describes a release. The zero
+// value is a DDEX ERN message to the server's configured recipient.
+type MetadataQuery struct {
+    Format        string // ddex or csv
+    Test          bool   // mark the DDEX message a test
+    RecipientDPID string // the recipient's DDEX party ID
+    RecipientName string
+}
+
+// ReleaseMetadata is what a distributor asks for about the release, as DDEX
+// XML or CSV; the caller closes it.
+func (c *Client) ReleaseMetadata(ctx context.Context, id string, q MetadataQuery) (io.ReadCloser, error) {
+    v := url.Values{}
+    if q.Format != "" { v.Set("format", q.Format) }
+    if q.Test { v.Set("test", "1") }
+    if q.RecipientDPID != "" { v.Set("recipient_dpid", q.RecipientDPID) }
+    if q.RecipientName != "" { v.Set("recipient_name", q.RecipientName) }
+    return c.Open(ctx, releaseURI(id, "metadata")+"?"+v.Encode())
+}
+
+// releaseURI is the API path of release id's resource (its detail if empty).
+func releaseURI(id, resource string) string {
+    uri := "/api/releases/" + url.PathEscape(id)
+    if resource != "" { uri += "/" + resource }
+    return uri
+}
+
+// ====== ISRC and UPC Codes ======
+
+// Codes is every code in the catalog, those given twice, what the releases
+// still lack and the codes the label's ranges hand out next.
+type Codes struct {
+    ISRCPrefix string         `json:"isrc_prefix"`
+    NextISRC   string         `json:"next_isrc"`
+    UPCPrefix  string         `json:"upc_prefix"`
+    NextUPC    string         `json:"next_upc"`
+    ISRCs      []AssignedCode `json:"isrcs"`
+    UPCs       []AssignedCode `json:"upcs"`
+    Duplicates []string       `json:"duplicates"`
+    Missing    []CodesNeeded  `json:"missing"`
+}
+
+// Codes are the catalog's ISRCs and UPCs (GET /api/codes).
+func (c *Client) Codes(ctx context.Context) (*Codes, error) {
+    var out Codes
+    if err := c.get(ctx, "/api/codes", &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ReleaseCodes are the codes AssignCodes handed out.
+type ReleaseCodes struct {
+    Release string            `json:"release"`
+    UPC     string            `json:"upc,omitempty"`
+    ISRCs   map[string]string `json:"isrcs"` // by track
+}
+
+// AssignCodes gives the release id a UPC and its tracks ISRCs, from the
+// label's ranges, where they have none.
+func (c *Client) AssignCodes(ctx context.Context, id string) (*ReleaseCodes, error) {
+    var out ReleaseCodes
+    if err := c.Do(ctx, http.MethodPost, releaseURI(id, "codes"), nil, nil, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// AssignISRC gives the track the ISRC isrc or, if empty, the next from the
+// label's range, and returns the code it now has.
+func (c *Client) AssignISRC(ctx context.Context, track, isrc string) (string, error) {
+    var out struct {
+        ISRC string `json:"isrc"`
+    }
+    in := map[string]string{}
+    if isrc != "" { in["isrc"] = isrc }
+    if err := c.send(ctx, http.MethodPost, trackURI(track, "isrc"), in, &out); err != nil { return "", err }
+    return out.ISRC, nil
+}
+
+// ====== Deadlines ======
+
+// DeadlineFilter narrows the deadlines Deadlines lists. The zero value lists
+// every deadline not yet met.
+type DeadlineFilter struct {
+    Track   string // a track's
+    Release string // a release's, by ID
+    All     bool   // those met too
+}
+
+// Deadlines are the deadlines, the soonest due first.
+func (c *Client) Deadlines(ctx context.Context, f DeadlineFilter) ([]DeadlineState, error) {
+    q := url.Values{}
+    if f.Track != "" { q.Set("track", f.Track) }
+    if f.Release != "" { q.Set("release", f.Release) }
+    if f.All { q.Set("all", "1") }
+    var out []DeadlineState
+    return out, c.get(ctx, "/api/deadlines?"+q.Encode(), &out)
+}
+
+// DueDeadlines are the deadlines not met that are overdue or due within Days.
+type DueDeadlines struct {
+    Overdue []DeadlineState `json:"overdue"`
+    DueSoon []DeadlineState `json:"due_soon"`
+    Days    int             `json:"days"`
+}
+
+// Overdue is what is overdue, and what is due within days.
+func (c *Client) Overdue(ctx context.Context, days int) (*DueDeadlines, error) {
+    var out DueDeadlines
+    if err := c.get(ctx, "/api/deadlines/overdue?days="+strconv.Itoa(days), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Deadline is the deadline id as it stands.
+func (c *Client) Deadline(ctx context.Context, id string) (*DeadlineState, error) {
+    var out DeadlineState
+    if err := c.get(ctx, deadlineURI(id), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// CreateDeadline sets a deadline of in's fields; it needs a kind, a due
+// date and a track or a release.
+func (c *Client) CreateDeadline(ctx context.Context, in DeadlineChange) (*DeadlineState, error) {
+    var out DeadlineState
+    if err := c.send(ctx, http.MethodPost, "/api/deadlines", in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// UpdateDeadline sets the fields in gives of the deadline id, as Done to
+// mark it met by hand.
+func (c *Client) UpdateDeadline(ctx context.Context, id string, in DeadlineChange) (*DeadlineState, error) {
+    var out DeadlineState
+    if err := c.send(ctx, http.MethodPatch, deadlineURI(id), in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// DeleteDeadline removes the deadline id.
+func (c *Client) DeleteDeadline(ctx context.Context, id string) error {
+    return c.Do(ctx, http.MethodDelete, deadlineURI(id), nil, nil, nil)
+}
+
+func deadlineURI(id string) string { return "/api/deadlines/" + url.PathEscape(id) }
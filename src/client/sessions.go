@@ -0,0 +1,82 @@
+package client
+
+import (
+    "context"
+    "io"
+    "net/url"
+)
+
+// ====== Sessions ======
+//
+// What the server reads from a snapshot's DAW session: its tempo and
+// arrangement markers, the samples a Live set uses, its exported MIDI, and
+// the whole project as one zip.
+
+// Locators are a Live set's arrangement markers.
+type Locators struct {
+    T1       string    `json:"t1"`
+    Tempo    float64   `json:"tempo"`
+    Locators []Locator `json:"locators"`
+    Bounce   string    `json:"bounce,omitempty"` // the snapshot's WAV, to play them against
+}
+
+// Locators are the arrangement markers of snapshot t1's Live set.
+func (c *Client) Locators(ctx context.Context, track, t1 string) (*Locators, error) {
+    var out Locators
+    if err := c.get(ctx, snapURI(track, t1, "locators"), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// SessionInfo is what the header of snapshot t1's session says.
+func (c *Client) SessionInfo(ctx context.Context, track, t1 string) (*SessionInfo, error) {
+    var out SessionInfo
+    if err := c.get(ctx, snapURI(track, t1, "session"), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Samples are the samples snapshot t1's Live set uses, found in Dropbox or
+// not.
+func (c *Client) Samples(ctx context.Context, track, t1 string) (*DepManifest, error) {
+    var out DepManifest
+    if err := c.get(ctx, snapURI(track, t1, "manifest"), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// MidiFile is an exported MIDI file of a snapshot, as read.
+type MidiFile struct {
+    File     FileRef   `json:"file"`
+    Part     string    `json:"part,omitempty"` // "" for the whole arrangement
+    Download string    `json:"download"`       // the API path of a link to it
+    Info     *MidiInfo `json:"info,omitempty"`
+    Error    string    `json:"error,omitempty"` // why it could not be read
+}
+
+// MIDI is snapshot t1's exported MIDI; archived counts archived files in.
+func (c *Client) MIDI(ctx context.Context, track, t1 string, archived bool) ([]MidiFile, error) {
+    uri := snapURI(track, t1, "midi")
+    if archived { uri += "?archived=1" }
+    var out struct {
+        Files []MidiFile `json:"files"`
+    }
+    if err := c.get(ctx, uri, &out); err != nil { return nil, err }
+    return out.Files, nil
+}
+
+// SessionBundle is snapshot t1's Live set and its samples as a zip laid out
+// like the project folder; the caller closes it. A set with samples missing
+// is an error unless allowMissing.
+func (c *Client) SessionBundle(ctx context.Context, track, t1 string, allowMissing bool) (io.ReadCloser, error) {
+    uri := snapURI(track, t1, "bundle")
+    if allowMissing { uri += "?allow_missing=1" }
+    return c.Open(ctx, uri)
+}
+
+// StemsBundle is the stems set T1-T2 as a zip; the caller closes it.
+func (c *Client) StemsBundle(ctx context.Context, track, t1, t2 string) (io.ReadCloser, error) {
+    return c.Open(ctx, trackURI(track, "stems/"+url.PathEscape(t1+"-"+t2)+"/bundle"))
+}
+
+// snapURI is the API path of snapshot t1's resource.
+func snapURI(track, t1, resource string) string {
+    return trackURI(track, "ableton/"+url.PathEscape(t1)+"/"+resource)
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+    "context"
+    "io"
+    "net/http"
+    "net/url"
+    "time"
+)
+
+// ====== Share Links ======
+
+// ShareRequest is what a share link is made of.
+type ShareRequest struct {
+    Track      string
+    Artifact   *ArtifactRef // nil: the track's newest mix and FINAL
+    Expires    time.Time    // a week from now if zero; at most 90 days
+    NoDownload bool         // the files play in the page but are not offered as downloads
+    Password   string
+}
+
+// CreatedShare is what CreateShare made, and the link to hand out.
+type CreatedShare struct {
+    URL   string `json:"url"`
+    Share Share  `json:"share"`
+}
+
+// ShareChange is what UpdateShare changes of a link; nil fields are left as
+// they are.
+type ShareChange struct {
+    Password *string `json:"password,omitempty"` // "" takes the password off
+    Download *bool   `json:"download,omitempty"`
+}
+
+// Shares are the caller's share links (everyone's for admins), the newest
+// first.
+func (c *Client) Shares(ctx context.Context) ([]Share, error) {
+    var out []Share
+    return out, c.get(ctx, "/api/share", &out)
+}
+
+// CreateShare makes a share link; it needs the role to reach the files.
+func (c *Client) CreateShare(ctx context.Context, r ShareRequest) (*CreatedShare, error) {
+    var out CreatedShare
+    in := map[string]any{"track": r.Track, "download": !r.NoDownload}
+    if r.Artifact != nil { in["artifact"] = r.Artifact }
+    if !r.Expires.IsZero() { in["expires"] = r.Expires }
+    if r.Password != "" { in["password"] = r.Password }
+    if err := c.send(ctx, http.MethodPost, "/api/share", in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Share is the link token and how it has been used.
+func (c *Client) Share(ctx context.Context, token string) (*Share, *ShareStats, error) {
+    var out struct {
+        Share Share      `json:"share"`
+        Stats ShareStats `json:"stats"`
+    }
+    if err := c.get(ctx, shareURI(token), &out); err != nil { return nil, nil, err }
+    return &out.Share, &out.Stats, nil
+}
+
+// UpdateShare sets or takes off the link's password, or turns its downloads
+// on or off.
+func (c *Client) UpdateShare(ctx context.Context, token string, ch ShareChange) (*Share, error) {
+    var out Share
+    if err := c.send(ctx, http.MethodPatch, shareURI(token), ch, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// RevokeShare ends the link for good.
+func (c *Client) RevokeShare(ctx context.Context, token string) error {
+    return c.Do(ctx, http.MethodDelete, shareURI(token), nil, nil, nil)
+}
+
+func shareURI(token string) string { return "/api/share/" + url.PathEscape(token) }
+
+// ====== Blind A/B ======
+
+// ABEntry is one of the artifacts a blind A/B session compares.
+type ABEntry struct {
+    Track    string      `json:"track"`
+    Artifact ArtifactRef `json:"artifact"`
+}
+
+// ABSessions are the blind A/B sessions, the newest first.
+func (c *Client) ABSessions(ctx context.Context) ([]ABBlind, error) {
+    var out []ABBlind
+    return out, c.get(ctx, "/api/ab", &out)
+}
+
+// CreateAB sets a and b, two WAVs, against each other under random labels,
+// the louder turned down to match.
+func (c *Client) CreateAB(ctx context.Context, a, b ABEntry) (*ABBlind, error) {
+    var out ABBlind
+    in := map[string]ABEntry{"a": a, "b": b}
+    if err := c.send(ctx, http.MethodPost, "/api/ab", in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// AB is the session id as listeners see it before they pick.
+func (c *Client) AB(ctx context.Context, id string) (*ABBlind, error) {
+    var out ABBlind
+    if err := c.get(ctx, abURI(id, ""), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ABStream is the audio of the side labelled label, gain applied; the
+// caller closes it.
+func (c *Client) ABStream(ctx context.Context, id, label string) (io.ReadCloser, error) {
+    return c.Open(ctx, abURI(id, "stream/"+url.PathEscape(label)))
+}
+
+// PickAB records the caller's pick of label, replacing any earlier one.
+func (c *Client) PickAB(ctx context.Context, id, label, note string) (*ABPick, error) {
+    var out ABPick
+    in := map[string]string{"pick": label, "note": note}
+    if err := c.send(ctx, http.MethodPost, abURI(id, "pick"), in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ABResults reveals the session, once the caller has picked.
+func (c *Client) ABResults(ctx context.Context, id string) (*ABResults, error) {
+    var out ABResults
+    if err := c.get(ctx, abURI(id, "results"), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// DeleteAB deletes the session, which only its creator may.
+func (c *Client) DeleteAB(ctx context.Context, id string) error {
+    return c.Do(ctx, http.MethodDelete, abURI(id, ""), nil, nil, nil)
+}
+
+// abURI is the API path of session id's resource (the session if empty).
+func abURI(id, resource string) string {
+    uri := "/api/ab/" + url.PathEscape(id)
+    if resource != "" { uri += "/" + resource }
+    return uri
+}
@@ -0,0 +1,545 @@
+package client
+
+import (
+    "context"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ====== Tracks ======
+//
+// A track is named as the API names it: MIDNIGHT, a branch as
+// MIDNIGHT.RADIO_EDIT, or any former name the server keeps as an alias.
+
+// TrackFilter narrows the catalog Tracks lists. The zero value lists every
+// track but branches and archived ones.
+type TrackFilter struct {
+    Query    string   // in the current or a former name
+    Tags     []string // tracks with an artifact carrying each
+    Status   string   // at this workflow stage
+    Branches bool     // list branches as tracks of their own
+    Archived bool     // list archived tracks too
+}
+
+// Tracks is the catalog (GET /api/tracks).
+func (c *Client) Tracks(ctx context.Context, f TrackFilter) ([]TrackSummary, error) {
+    q := url.Values{"tag": f.Tags}
+    if f.Query != "" { q.Set("q", f.Query) }
+    if f.Status != "" { q.Set("status", f.Status) }
+    if f.Branches { q.Set("branches", "1") }
+    if f.Archived { q.Set("archived", "1") }
+    var out []TrackSummary
+    return out, c.get(ctx, "/api/tracks?"+q.Encode(), &out)
+}
+
+// Track is one track with everything attached to it (GET /api/tracks/{name}).
+func (c *Client) Track(ctx context.Context, name string) (*Track, error) {
+    var out Track
+    if err := c.get(ctx, trackURI(name, ""), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// TrackQuery narrows a track's detail, for clients that want little of it.
+type TrackQuery struct {
+    Fields []string  // only these fields, by their JSON names (name, mixes, masters, ...)
+    Since  time.Time // only the snapshots, stems sets, mixes and master sets changed since
+}
+
+// TrackWith is the track's detail narrowed by q; fields left out are empty.
+func (c *Client) TrackWith(ctx context.Context, name string, q TrackQuery) (*Track, error) {
+    v := url.Values{}
+    if len(q.Fields) > 0 { v.Set("fields", strings.Join(q.Fields, ",")) }
+    if !q.Since.IsZero() { v.Set("since", q.Since.Format(time.RFC3339)) }
+    var out Track
+    if err := c.get(ctx, trackURI(name, "")+"?"+v.Encode(), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Summary is the track's counts and status, as Tracks lists them.
+func (c *Client) Summary(ctx context.Context, name string) (*TrackSummary, error) {
+    var out TrackSummary
+    if err := c.get(ctx, trackURI(name, "summary"), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// CreatedTrack is what CreateTrack made.
+type CreatedTrack struct {
+    Track    string   `json:"track"`
+    Dir      string   `json:"dir"`
+    Folders  []string `json:"folders"`
+    Manifest string   `json:"manifest"`
+}
+
+// CreateTrack makes a new track's folder, with ableton/ and folders (stems,
+// mixes, masters, artwork) in it (POST /api/tracks).
+func (c *Client) CreateTrack(ctx context.Context, name string, folders ...string) (*CreatedTrack, error) {
+    var out CreatedTrack
+    in := map[string]any{"name": name, "folders": folders}
+    if err := c.send(ctx, http.MethodPost, "/api/tracks", in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Branches are the track's branches, as the catalog lists them.
+func (c *Client) Branches(ctx context.Context, track string) ([]TrackSummary, error) {
+    var out []TrackSummary
+    return out, c.get(ctx, trackURI(track, "branches"), &out)
+}
+
+// Changelog is the track's history since since (all of it if zero), oldest
+// first.
+func (c *Client) Changelog(ctx context.Context, track string, since time.Time) ([]ChangeEntry, error) {
+    q := url.Values{}
+    if !since.IsZero() { q.Set("since", since.Format(time.RFC3339)) }
+    var out []ChangeEntry
+    return out, c.get(ctx, trackURI(track, "changelog")+"?"+q.Encode(), &out)
+}
+
+// Status is where the track is in the workflow, and the stages it may move to.
+func (c *Client) Status(ctx context.Context, track string) (*TrackStatus, []string, error) {
+    var out struct {
+        Status  TrackStatus `json:"status"`
+        Allowed []string    `json:"allowed"`
+    }
+    if err := c.get(ctx, trackURI(track, "status"), &out); err != nil { return nil, nil, err }
+    return &out.Status, out.Allowed, nil
+}
+
+// SetStatus moves the track to the workflow stage status.
+func (c *Client) SetStatus(ctx context.Context, track, status, note string) (*TrackStatus, error) {
+    var out TrackStatus
+    in := map[string]string{"status": status, "note": note}
+    if err := c.send(ctx, http.MethodPost, trackURI(track, "status"), in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Metadata is the track's track.yaml.
+func (c *Client) Metadata(ctx context.Context, track string) (*TrackMeta, error) {
+    var out struct {
+        Metadata TrackMeta `json:"metadata"`
+    }
+    if err := c.get(ctx, trackURI(track, "metadata"), &out); err != nil { return nil, err }
+    return &out.Metadata, nil
+}
+
+// SetMetadata replaces the track's track.yaml fields with m's, clearing
+// those m leaves empty, and returns them as the server normalized them.
+func (c *Client) SetMetadata(ctx context.Context, track string, m TrackMeta) (*TrackMeta, error) {
+    var out struct {
+        Metadata TrackMeta `json:"metadata"`
+    }
+    if err := c.send(ctx, http.MethodPut, trackURI(track, "metadata"), m, &out); err != nil { return nil, err }
+    return &out.Metadata, nil
+}
+
+// ====== Artifacts ======
+
+// Annotations are the notes on the track's artifacts.
+func (c *Client) Annotations(ctx context.Context, track string) ([]Annotation, error) {
+    var out []Annotation
+    return out, c.get(ctx, trackURI(track, "annotations"), &out)
+}
+
+// Annotate adds a note to artifact a.
+func (c *Client) Annotate(ctx context.Context, track string, a ArtifactRef, text string) (*Annotation, error) {
+    var out Annotation
+    in := map[string]any{"artifact": a, "text": text}
+    if err := c.send(ctx, http.MethodPost, trackURI(track, "annotations"), in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Tags are the tags on the track's artifacts.
+func (c *Client) Tags(ctx context.Context, track string) ([]ArtifactTag, error) {
+    var out []ArtifactTag
+    return out, c.get(ctx, trackURI(track, "tags"), &out)
+}
+
+// Tag tags artifact a; tagging it twice is no error.
+func (c *Client) Tag(ctx context.Context, track string, a ArtifactRef, tag string) (*ArtifactTag, error) {
+    var out ArtifactTag
+    in := map[string]any{"artifact": a, "tag": tag}
+    if err := c.send(ctx, http.MethodPost, trackURI(track, "tags"), in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Untag takes tag off artifact a.
+func (c *Client) Untag(ctx context.Context, track string, a ArtifactRef, tag string) error {
+    q := artifactQuery(a)
+    q.Set("tag", tag)
+    return c.Do(ctx, http.MethodDelete, trackURI(track, "tags")+"?"+q.Encode(), nil, nil, nil)
+}
+
+// Comments are the comments on the track's mixes and masters.
+func (c *Client) Comments(ctx context.Context, track string) ([]Comment, error) {
+    var out []Comment
+    return out, c.get(ctx, trackURI(track, "comments"), &out)
+}
+
+// AddComment pins text to the moment at of artifact a.
+func (c *Client) AddComment(ctx context.Context, track string, a ArtifactRef, at time.Duration, text string) (*Comment, error) {
+    var out Comment
+    in := map[string]any{"artifact": a, "at": at.Seconds(), "text": text}
+    if err := c.send(ctx, http.MethodPost, trackURI(track, "comments"), in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ResolveComment marks comment id resolved, or open again.
+func (c *Client) ResolveComment(ctx context.Context, track, id string, resolved bool) (*Comment, error) {
+    var out Comment
+    in := map[string]bool{"resolved": resolved}
+    if err := c.send(ctx, http.MethodPatch, trackURI(track, "comments/"+url.PathEscape(id)), in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// DeleteComment deletes comment id, which only its author may.
+func (c *Client) DeleteComment(ctx context.Context, track, id string) error {
+    return c.Do(ctx, http.MethodDelete, trackURI(track, "comments/"+url.PathEscape(id)), nil, nil, nil)
+}
+
+// Ratings is how the track's mixes and masters are rated, per file and per
+// version, best first.
+type Ratings struct {
+    Artifacts []RatingSummary `json:"artifacts"`
+    Versions  []VersionRating `json:"versions"`
+}
+
+// Ratings are the ratings of the track's mixes and masters.
+func (c *Client) Ratings(ctx context.Context, track string) (*Ratings, error) {
+    var out Ratings
+    if err := c.get(ctx, trackURI(track, "ratings"), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Rate gives artifact a, a mix or a master file, the user's stars (1-5, or
+// 0 to only mark it a favorite).
+func (c *Client) Rate(ctx context.Context, track string, a ArtifactRef, stars int, favorite bool) (*Rating, error) {
+    var out Rating
+    in := map[string]any{"artifact": a, "stars": stars, "favorite": favorite}
+    if err := c.send(ctx, http.MethodPut, trackURI(track, "ratings"), in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Unrate takes back the user's rating of artifact a.
+func (c *Client) Unrate(ctx context.Context, track string, a ArtifactRef) error {
+    return c.Do(ctx, http.MethodDelete, trackURI(track, "ratings")+"?"+artifactQuery(a).Encode(), nil, nil, nil)
+}
+
+// Candidates are the master set T1-T2's candidates, best rated first.
+func (c *Client) Candidates(ctx context.Context, track, t1, t2 string) ([]RatedCandidate, error) {
+    var out []RatedCandidate
+    return out, c.get(ctx, trackURI(track, "masters/"+url.PathEscape(t1)+"/"+url.PathEscape(t2)+"/candidates"), &out)
+}
+
+// Promote makes candidate idx the master set T1-T2's FINAL.
+func (c *Client) Promote(ctx context.Context, track, t1, t2, idx string) (*MasterSet, error) {
+    var out MasterSet
+    uri := trackURI(track, "masters/"+url.PathEscape(t1)+"/"+url.PathEscape(t2)+"/promote") + "?candidate=" + url.QueryEscape(idx)
+    if err := c.Do(ctx, http.MethodPost, uri, nil, nil, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Deprecations are the track's stems sets and mixes marked not to be used.
+func (c *Client) Deprecations(ctx context.Context, track string) ([]Deprecation, error) {
+    var out []Deprecation
+    return out, c.get(ctx, trackURI(track, "deprecations"), &out)
+}
+
+// Deprecate marks artifact a, a stems set or mix, not to be used.
+func (c *Client) Deprecate(ctx context.Context, track string, a ArtifactRef, reason string) (*Deprecation, error) {
+    var out Deprecation
+    in := map[string]any{"artifact": a, "reason": reason}
+    if err := c.send(ctx, http.MethodPost, trackURI(track, "deprecations"), in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Undeprecate takes artifact a back into use.
+func (c *Client) Undeprecate(ctx context.Context, track string, a ArtifactRef) error {
+    return c.Do(ctx, http.MethodDelete, trackURI(track, "deprecations")+"?"+artifactQuery(a).Encode(), nil, nil, nil)
+}
+
+// Versions are the track's named versions.
+func (c *Client) Versions(ctx context.Context, track string) ([]VersionTag, error) {
+    var out []VersionTag
+    return out, c.get(ctx, trackURI(track, "versions"), &out)
+}
+
+// Version is the named version and its files.
+func (c *Client) Version(ctx context.Context, track, name string) (*VersionTag, []FileRef, error) {
+    var out struct {
+        Version VersionTag `json:"version"`
+        Files   []FileRef  `json:"files"`
+    }
+    if err := c.get(ctx, trackURI(track, "versions/"+url.PathEscape(name)), &out); err != nil { return nil, nil, err }
+    return &out.Version, out.Files, nil
+}
+
+// NameVersion names artifact a, as "v2".
+func (c *Client) NameVersion(ctx context.Context, track, name string, a ArtifactRef) (*VersionTag, error) {
+    var out VersionTag
+    in := map[string]any{"name": name, "artifact": a}
+    if err := c.send(ctx, http.MethodPost, trackURI(track, "versions"), in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// DeleteVersion removes the version name; the artifact stays.
+func (c *Client) DeleteVersion(ctx context.Context, track, name string) error {
+    return c.Do(ctx, http.MethodDelete, trackURI(track, "versions/"+url.PathEscape(name)), nil, nil, nil)
+}
+
+// ====== Locks ======
+
+// Locks are the track's live check-outs.
+func (c *Client) Locks(ctx context.Context, track string) ([]Lock, error) {
+    var out []Lock
+    return out, c.get(ctx, trackURI(track, "lock"), &out)
+}
+
+// Lock checks out the whole track (t1 empty) or its snapshot t1 for the
+// caller, for ttl (the server's default if zero).
+func (c *Client) Lock(ctx context.Context, track, t1 string, ttl time.Duration, note string) (*Lock, error) {
+    var out Lock
+    in := map[string]string{"t1": t1, "note": note}
+    if ttl > 0 { in["ttl"] = ttl.String() }
+    if err := c.send(ctx, http.MethodPost, trackURI(track, "lock"), in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// Unlock releases the caller's lock of the track or its snapshot t1; force
+// releases another's.
+func (c *Client) Unlock(ctx context.Context, track, t1 string, force bool) error {
+    q := url.Values{}
+    if t1 != "" { q.Set("t1", t1) }
+    if force { q.Set("force", "1") }
+    return c.Do(ctx, http.MethodDelete, trackURI(track, "lock")+"?"+q.Encode(), nil, nil, nil)
+}
+
+// ====== Lyrics ======
+
+// Lyrics is one revision of the track's lyrics, with what changed from the
+// one before.
+type Lyrics struct {
+    Track     string          `json:"track"`
+    Revision  int             `json:"revision"` // 1 is the first
+    Current   bool            `json:"current"`
+    Lyric     Lyric           `json:"lyric"`
+    Text      string          `json:"text"`
+    Revisions []LyricRevision `json:"revisions"`
+    Added     int             `json:"added"` // lines, since the revision before
+    Removed   int             `json:"removed"`
+}
+
+// LyricRevision is a revision of the lyrics by its number.
+type LyricRevision struct {
+    Revision int `json:"revision"`
+    Lyric
+}
+
+// Lyrics is the track's lyrics at revision rev, the current one if zero;
+// archived counts archived revisions in.
+func (c *Client) Lyrics(ctx context.Context, track string, rev int, archived bool) (*Lyrics, error) {
+    q := url.Values{}
+    if rev > 0 { q.Set("rev", strconv.Itoa(rev)) }
+    if archived { q.Set("archived", "1") }
+    var out Lyrics
+    if err := c.get(ctx, trackURI(track, "lyrics")+"?"+q.Encode(), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ====== Conflicted Copies and the Archive ======
+
+// Conflicts are the track's conflicted copies, awaiting a decision.
+func (c *Client) Conflicts(ctx context.Context, track string) ([]Conflict, error) {
+    var out []Conflict
+    return out, c.get(ctx, trackURI(track, "conflicts"), &out)
+}
+
+// ResolveConflict settles the conflicted copy at copyPath: keep "original"
+// archives the copy, "copy" puts it in the original's place, and "both"
+// renames it to renameTo, another conventional name in the track.
+func (c *Client) ResolveConflict(ctx context.Context, track, copyPath, keep, renameTo string) ([]Move, error) {
+    var out struct {
+        Moved []Move `json:"moved"`
+    }
+    in := map[string]string{"copy": copyPath, "keep": keep, "rename_to": renameTo}
+    if err := c.send(ctx, http.MethodPost, trackURI(track, "conflicts"), in, &out); err != nil { return nil, err }
+    return out.Moved, nil
+}
+
+// Archive moves artifacts' files under the Archive root, or the whole
+// track folder if none are given.
+func (c *Client) Archive(ctx context.Context, track string, artifacts ...ArtifactRef) ([]Move, error) {
+    return c.archive(ctx, http.MethodPost, track, artifacts)
+}
+
+// Unarchive moves artifacts' files, or the whole track folder, back.
+func (c *Client) Unarchive(ctx context.Context, track string, artifacts ...ArtifactRef) ([]Move, error) {
+    return c.archive(ctx, http.MethodDelete, track, artifacts)
+}
+
+func (c *Client) archive(ctx context.Context, method, track string, artifacts []ArtifactRef) ([]Move, error) {
+    var out struct {
+        Moved []Move `json:"moved"`
+    }
+    in := map[string][]ArtifactRef{"artifacts": artifacts}
+    if err := c.send(ctx, method, trackURI(track, "archive"), in, &out); err != nil { return nil, err }
+    return out.Moved, nil
+}
+
+// ====== Artwork ======
+
+// Artwork is the track's images and the one chosen as its cover.
+type Artwork struct {
+    Primary *FileRef  `json:"primary"` // the chosen image, else the newest
+    Images  []FileRef `json:"images"`
+}
+
+// UploadedArtwork is an image UploadArtwork put in the artwork folder.
+type UploadedArtwork struct {
+    Track   string  `json:"track"` // a branch's artwork is its track's
+    File    FileRef `json:"file"`
+    Format  string  `json:"format"` // jpeg, png, ...
+    Width   int     `json:"width"`
+    Height  int     `json:"height"`
+    Primary bool    `json:"primary,omitempty"`
+}
+
+// Artwork is the track's artwork.
+func (c *Client) Artwork(ctx context.Context, track string) (*Artwork, error) {
+    var out Artwork
+    if err := c.get(ctx, trackURI(track, "artwork"), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// UploadArtwork stores the image r as name in the track's artwork folder,
+// as the primary image if primary. An existing file of that name is an
+// error unless overwrite.
+func (c *Client) UploadArtwork(ctx context.Context, track, name string, r io.Reader, primary, overwrite bool) (*UploadedArtwork, error) {
+    q := url.Values{"name": {name}}
+    if primary { q.Set("primary", "1") }
+    if overwrite { q.Set("overwrite", "1") }
+    var out UploadedArtwork
+    if err := c.Do(ctx, http.MethodPost, trackURI(track, "artwork")+"?"+q.Encode(), r, nil, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// SetPrimaryArtwork makes the image at p, or named p, the track's cover.
+func (c *Client) SetPrimaryArtwork(ctx context.Context, track, p string) (*FileRef, error) {
+    var out FileRef
+    if err := c.send(ctx, http.MethodPut, trackURI(track, "artwork/primary"), map[string]string{"path": p}, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ResetPrimaryArtwork goes back to the newest image as the cover, and
+// returns it.
+func (c *Client) ResetPrimaryArtwork(ctx context.Context, track string) (*FileRef, error) {
+    var out struct {
+        Primary *FileRef `json:"primary"`
+    }
+    if err := c.Do(ctx, http.MethodDelete, trackURI(track, "artwork/primary"), nil, nil, &out); err != nil { return nil, err }
+    return out.Primary, nil
+}
+
+// ArtworkImage is the image at p (the cover if empty), as stored or, with
+// size, scaled to fit size x size as a JPEG; the caller closes it.
+func (c *Client) ArtworkImage(ctx context.Context, track, p string, size int) (io.ReadCloser, error) {
+    q := url.Values{}
+    if p != "" { q.Set("path", p) }
+    if size > 0 { q.Set("size", strconv.Itoa(size)) }
+    return c.Open(ctx, trackURI(track, "artwork/image")+"?"+q.Encode())
+}
+
+// ====== Manifests and Publishing ======
+
+// Manifest is the track's signed manifest, as it is (or would be) written
+// to its folder.
+func (c *Client) Manifest(ctx context.Context, track string) (*SignedManifest, error) {
+    var out SignedManifest
+    if err := c.get(ctx, trackURI(track, "manifest"), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// SoundCloud is what of the track was pushed to SoundCloud.
+func (c *Client) SoundCloud(ctx context.Context, track string) ([]Publication, error) {
+    var out []Publication
+    return out, c.get(ctx, trackURI(track, "soundcloud"), &out)
+}
+
+// PushSoundCloud uploads artifact a, a mix, master or snapshot bounce, to
+// SoundCloud as a private track titled title. A file pushed before is not
+// pushed again unless again.
+func (c *Client) PushSoundCloud(ctx context.Context, track string, a ArtifactRef, title string, again bool) (*Publication, error) {
+    uri := trackURI(track, "soundcloud")
+    if again { uri += "?again=1" }
+    var out Publication
+    in := map[string]any{"artifact": a, "title": title}
+    if err := c.send(ctx, http.MethodPost, uri, in, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ====== File Requests ======
+
+// FileRequestSpec is what a file request asks for.
+type FileRequestSpec struct {
+    Title       string `json:"title,omitempty"`    // default "Files for TRACK"
+    Kind        string `json:"kind,omitempty"`     // stem, mix or master; empty: to be told when filed
+    Deadline    string `json:"deadline,omitempty"` // YYYY-MM-DD
+    Description string `json:"description,omitempty"`
+}
+
+// FileRequestChange is what UpdateFileRequest changes; nil fields are left
+// as they are.
+type FileRequestChange struct {
+    Open  *bool   `json:"open,omitempty"`
+    Title *string `json:"title,omitempty"`
+}
+
+// FileRequests are the track's Dropbox file requests.
+func (c *Client) FileRequests(ctx context.Context, track string) ([]FileRequest, error) {
+    var out []FileRequest
+    return out, c.get(ctx, trackURI(track, "file-requests"), &out)
+}
+
+// FileRequest is the track's file request id.
+func (c *Client) FileRequest(ctx context.Context, track, id string) (*FileRequest, error) {
+    var out FileRequest
+    if err := c.get(ctx, trackURI(track, "file-requests/"+url.PathEscape(id)), &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// CreateFileRequest opens a Dropbox file request whose uploads land in the
+// track's inbox, to be filed under conventional names.
+func (c *Client) CreateFileRequest(ctx context.Context, track string, spec FileRequestSpec) (*FileRequest, error) {
+    var out FileRequest
+    if err := c.send(ctx, http.MethodPost, trackURI(track, "file-requests"), spec, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// UpdateFileRequest closes or reopens the file request id, or retitles it.
+func (c *Client) UpdateFileRequest(ctx context.Context, track, id string, ch FileRequestChange) (*FileRequest, error) {
+    var out FileRequest
+    if err := c.send(ctx, http.MethodPatch, trackURI(track, "file-requests/"+url.PathEscape(id)), ch, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// DeleteFileRequest deletes the file request id, which has to be closed.
+func (c *Client) DeleteFileRequest(ctx context.Context, track, id string) error {
+    return c.Do(ctx, http.MethodDelete, trackURI(track, "file-requests/"+url.PathEscape(id)), nil, nil, nil)
+}
+
+// trackURI is the API path of track's resource (its detail if empty).
+func trackURI(track, resource string) string {
+    uri := "/api/tracks/" + url.PathEscape(track)
+    if resource != "" { uri += "/" + resource }
+    return uri
+}
+
+// artifactQuery addresses a in a query, as DELETE requests do.
+func artifactQuery(a ArtifactRef) url.Values {
+    q := url.Values{"kind": {a.Kind}, "t1": {a.T1}}
+    if a.T2 != "" { q.Set("t2", a.T2) }
+    if a.Idx != "" { q.Set("idx", a.Idx) }
+    return q
+}
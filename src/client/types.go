@@ -0,0 +1,970 @@
+package client
+
+import (
+    "bytes"
+    "crypto/ed25519"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "path"
+    "strings"
+    "time"
+)
+
+// ====== Types ======
+//
+// What the API serves. A track is indexed from its Dropbox folder: DAW
+// snapshots by T1, stems sets, mixes and master sets by T1-T2. Artifacts are
+// addressed by ArtifactRef; the notes, tags, ratings and the like that people
+// attach to them are kept by the server, not in Dropbox.
+
+const (
+    KindSnapshot = "snapshot" // DAW save or its bounce
+    KindBackup   = "backup"
+    KindStem     = "stem"
+    KindStems    = "stems" // a whole stems set, as opposed to one KindStem file
+    KindMix      = "mix"
+    KindPrint    = "print" // a pass through outboard gear, by chain
+    KindMaster   = "master"
+    KindLyrics   = "lyrics" // one revision of a track's lyrics
+)
+
+type FileRef struct {
+    Name           string    `json:"name"`
+    Path           string    `json:"path"`
+    Size           int64     `json:"size"`
+    ServerModified time.Time `json:"server_modified"`
+    ContentHash    string    `json:"content_hash,omitempty"`
+    ContributedBy  string    `json:"contributed_by,omitempty"`
+    Archived       bool      `json:"archived,omitempty"` // under the Archive root
+}
+
+type AbletonSnap struct {
+    T1      string      `json:"t1"`
+    DAW     string      `json:"daw,omitempty"`     // ableton, logic, protools, reaper, flstudio, bitwig
+    ALS     *FileRef    `json:"als,omitempty"`
+    Session *FileRef    `json:"session,omitempty"` // non-Ableton session file or bundle
+    WAV     *FileRef    `json:"wav,omitempty"`
+    MP3     *FileRef    `json:"mp3,omitempty"`
+    MIDI    []FileRef   `json:"midi,omitempty"` // exported MIDI, whole or by part
+    Backups []BackupRef `json:"backups,omitempty"`
+    Latest  time.Time   `json:"latest"`
+}
+
+// BackupRef is an intermediate save Live kept in Backup/ between T1 snapshots.
+type BackupRef struct {
+    FileRef
+    Stamp string `json:"stamp"` // Live's timestamp (saving machine's local time) or copy number
+}
+
+type StemsSet struct {
+    T1     string    `json:"t1"`
+    T2     string    `json:"t2"`
+    Stems  []FileRef `json:"stems"`
+    Latest time.Time `json:"latest"`
+}
+
+type Mix struct {
+    T1     string   `json:"t1"`
+    T2     string   `json:"t2"`
+    File   FileRef  `json:"file"`
+    Latest time.Time `json:"latest"`
+}
+
+// Print is a pass of T1-T2 through hardware: outboard processing or an
+// analog summing chain, printed back to a file.
+type Print struct {
+    T1     string    `json:"t1"`
+    T2     string    `json:"t2"`
+    Chain  string    `json:"chain"` // what it went through, e.g. SSL_TAPE
+    File   FileRef   `json:"file"`
+    Latest time.Time `json:"latest"`
+}
+
+type MasterSet struct {
+    T1        string      `json:"t1"`
+    T2        string      `json:"t2"`
+    Candidates []FileRef  `json:"candidates"`
+    Final     *FileRef    `json:"final,omitempty"`
+    Latest    time.Time   `json:"latest"`
+}
+
+// Idea is a voice memo or rough recording of a track, from before or
+// beside its versions.
+type Idea struct {
+    FileRef
+    Recorded time.Time `json:"recorded"` // from its name, else when the device wrote it
+}
+
+// Lyric is one revision of a track's lyrics, a text or Markdown file.
+type Lyric struct {
+    FileRef
+    T1      string    `json:"t1,omitempty"` // the snapshot it was written for, when its name says
+    Written time.Time `json:"written"`      // when the device last wrote it
+}
+
+type Track struct {
+    Name     string       `json:"name"`
+    Dir      string        `json:"dir,omitempty"` // track folder: first level under the root
+    Ableton  []AbletonSnap `json:"ableton"`
+    Stems    []StemsSet    `json:"stems"`
+    Mixes    []Mix         `json:"mixes"`
+    Prints   []Print       `json:"prints,omitempty"` // hardware passes, by T1-T2 and chain
+    Masters  []MasterSet   `json:"masters"`
+
+    // Branches (TRACK.BRANCH) are indexed as tracks of their own under the full name.
+    Parent   string        `json:"parent,omitempty"`
+    Branch   string        `json:"branch,omitempty"`
+    Branches []string      `json:"branches,omitempty"`
+
+    Aliases    []string   `json:"aliases,omitempty"`    // former names whose files index here
+    Conflicts  []Conflict `json:"conflicts,omitempty"`  // Dropbox conflicted copies awaiting a decision
+    Archived   bool       `json:"archived,omitempty"`   // every file is under the Archive root
+    Artwork    []FileRef  `json:"artwork,omitempty"`    // images in the track folder's artwork/
+    References []FileRef  `json:"references,omitempty"` // commercial songs to compare against, not versions
+    Ideas      []Idea     `json:"ideas,omitempty"`      // voice memos and rough recordings, oldest first
+    Lyrics     []Lyric    `json:"lyrics,omitempty"`     // revisions, oldest first; the last is current
+
+    Metadata      *TrackMeta `json:"metadata,omitempty"`       // from the folder's track.yaml
+    MetadataError string     `json:"metadata_error,omitempty"` // why track.yaml could not be read
+
+    // Attached from the store when serving detail; not part of the index.
+    Annotations []Annotation  `json:"annotations,omitempty"`
+    Tags        []ArtifactTag `json:"tags,omitempty"`
+    Locks       []Lock        `json:"locks,omitempty"`
+    Comments    []Comment     `json:"comments,omitempty"`
+    Deprecated  []Deprecation `json:"deprecated,omitempty"`
+    Versions    []VersionTag  `json:"versions,omitempty"`
+    Published   []Publication `json:"published,omitempty"`
+    Cover       *FileRef      `json:"cover,omitempty"` // the primary artwork
+    Restricted  bool          `json:"restricted,omitempty"`
+}
+
+// TrackSummary is a track as the catalog lists it.
+type TrackSummary struct {
+    Name         string   `json:"name"`
+    Branch       string   `json:"branch,omitempty"`
+    AbletonCount int      `json:"ableton_count"`
+    StemSets     int      `json:"stem_sets"`
+    Mixes        int      `json:"mixes"`
+    Prints       int      `json:"prints,omitempty"`
+    Deprecated   int      `json:"deprecated,omitempty"` // stems sets and mixes left out of the counts
+    MasterSets   int      `json:"master_sets"`
+    Branches     int      `json:"branches,omitempty"`
+    Aliases      []string `json:"aliases,omitempty"`
+    Conflicts    int      `json:"conflicts,omitempty"`
+    References   int      `json:"references,omitempty"`
+    Ideas        int      `json:"ideas,omitempty"`
+    Lyrics       int      `json:"lyrics,omitempty"` // revisions
+    Archived     bool     `json:"archived,omitempty"`
+    Status       string   `json:"status"`
+    Locked       bool     `json:"locked,omitempty"`
+    Restricted   bool     `json:"restricted,omitempty"`
+}
+
+// ====== Artifact Addressing ======
+
+// ArtifactRef addresses one versioned artifact of a track by its timestamps.
+type ArtifactRef struct {
+    Kind string `json:"kind"`          // snapshot, stems, mix, print, master
+    T1   string `json:"t1"`
+    T2   string `json:"t2,omitempty"`
+    Idx  string `json:"idx,omitempty"` // master candidate or FINAL, or print chain; empty means the master set or every print
+}
+
+func (a ArtifactRef) String() string {
+    switch {
+    case a.Kind == KindSnapshot: return fmt.Sprintf("%s %s", a.Kind, a.T1)
+    case a.Idx != "": return fmt.Sprintf("%s %s-%s #%s", a.Kind, a.T1, a.T2, a.Idx)
+    }
+    return fmt.Sprintf("%s %s-%s", a.Kind, a.T1, a.T2)
+}
+
+// Matches reports whether b is a or lies within it (a master set contains its candidates).
+func (a ArtifactRef) Matches(b ArtifactRef) bool {
+    if a.Kind != b.Kind || a.T1 != b.T1 || a.T2 != b.T2 { return false }
+    return a.Idx == "" || strings.EqualFold(a.Idx, b.Idx)
+}
+
+// MatchesLoose is Matches with empty T1/T2 acting as wildcards, for query filters.
+func (a ArtifactRef) MatchesLoose(b ArtifactRef) bool {
+    if a.Kind != b.Kind { return false }
+    if a.T1 != "" && a.T1 != b.T1 || a.T2 != "" && a.T2 != b.T2 { return false }
+    return a.Idx == "" || strings.EqualFold(a.Idx, b.Idx)
+}
+
+// Resolve checks that the artifact exists in t.
+func (a ArtifactRef) Resolve(t *Track) error {
+    found := false
+    switch a.Kind {
+    case KindSnapshot:
+        found = t.Snapshot(a.T1) != nil
+    case KindStems:
+        for _, s := range t.Stems { found = found || s.T1 == a.T1 && s.T2 == a.T2 }
+    case KindMix:
+        for _, m := range t.Mixes { found = found || m.T1 == a.T1 && m.T2 == a.T2 }
+    case KindPrint:
+        for _, p := range t.Prints { found = found || p.T1 == a.T1 && p.T2 == a.T2 && (a.Idx == "" || strings.EqualFold(a.Idx, p.Chain)) }
+    case KindMaster:
+        for _, m := range t.Masters {
+            if m.T1 != a.T1 || m.T2 != a.T2 { continue }
+            switch {
+            case a.Idx == "": found = true
+            case strings.EqualFold(a.Idx, "FINAL"): found = m.Final != nil
+            default:
+                for _, c := range m.Candidates { found = found || CandidateIdx(c) == a.Idx }
+            }
+        }
+    default:
+        return fmt.Errorf("unknown artifact kind %q", a.Kind)
+    }
+    if !found { return fmt.Errorf("%s not found in %s", a, t.Name) }
+    return nil
+}
+
+// File returns the one audio file a names: a snapshot's WAV bounce, a mix,
+// a print, or a master candidate/FINAL.
+func (a ArtifactRef) File(t *Track) *FileRef {
+    switch a.Kind {
+    case KindSnapshot:
+        if snap := t.Snapshot(a.T1); snap != nil { return snap.WAV }
+    case KindMix:
+        for i := range t.Mixes {
+            if t.Mixes[i].T1 == a.T1 && t.Mixes[i].T2 == a.T2 { return &t.Mixes[i].File }
+        }
+    case KindPrint:
+        for i := range t.Prints {
+            if p := &t.Prints[i]; p.T1 == a.T1 && p.T2 == a.T2 && strings.EqualFold(a.Idx, p.Chain) { return &p.File }
+        }
+    case KindMaster:
+        for i := range t.Masters {
+            m := &t.Masters[i]
+            if m.T1 != a.T1 || m.T2 != a.T2 { continue }
+            if strings.EqualFold(a.Idx, "FINAL") { return m.Final }
+            for j := range m.Candidates {
+                if CandidateIdx(m.Candidates[j]) == a.Idx { return &m.Candidates[j] }
+            }
+        }
+    }
+    return nil
+}
+
+// Snapshot is t's snapshot at t1, or nil.
+func (t *Track) Snapshot(t1 string) *AbletonSnap {
+    for i := range t.Ableton { if t.Ableton[i].T1 == t1 { return &t.Ableton[i] } }
+    return nil
+}
+
+// CandidateIdx is the index a master candidate's name gives it, as "2" in
+// MIDNIGHT-0430A-0720P-2.wav.
+func CandidateIdx(f FileRef) string {
+    base := strings.TrimSuffix(f.Name, path.Ext(f.Name))
+    return base[strings.LastIndex(base, "-")+1:]
+}
+
+// ====== Notes on Artifacts ======
+
+// Conflict is a conflicted copy next to the artifact file it diverged from.
+type Conflict struct {
+    Artifact     ArtifactRef `json:"artifact"`
+    Copy         FileRef     `json:"copy"`
+    OriginalPath string      `json:"original_path"`
+    Original     *FileRef    `json:"original,omitempty"` // nil if nothing holds the original name any more
+    Owner        string      `json:"owner,omitempty"`    // whose copy, as Dropbox named it
+    Date         string      `json:"date"`
+    Identical    bool        `json:"identical"` // same content hash: safe to drop either
+}
+
+// TrackMeta is the content of track.yaml.
+type TrackMeta struct {
+    BPM           float64           `json:"bpm,omitempty"`
+    Key           string            `json:"key,omitempty"` // e.g. "F# minor"
+    Genre         string            `json:"genre,omitempty"`
+    Collaborators []string          `json:"collaborators,omitempty"`
+    ISRC          string            `json:"isrc,omitempty"`         // CCXXXYYNNNNN, without dashes
+    BranchISRCs   map[string]string `json:"branch_isrcs,omitempty"` // branch -> ISRC; an edit is a recording of its own
+    ReleaseDate   string            `json:"release_date,omitempty"` // YYYY-MM-DD
+    Project       string            `json:"project,omitempty"`      // the album or EP it is part of, e.g. NIGHT_DRIVE
+    Notes         string            `json:"notes,omitempty"`
+}
+
+// Annotation is a free-text note on one artifact ("vocal up 1 dB, new bridge").
+type Annotation struct {
+    ID       string      `json:"id"`
+    Artifact ArtifactRef `json:"artifact"`
+    Text     string      `json:"text"`
+    Author   string      `json:"author"`
+    Created  time.Time   `json:"created"`
+}
+
+// ArtifactTag labels one artifact ("radio edit", "client approved", "do not use").
+type ArtifactTag struct {
+    Artifact ArtifactRef `json:"artifact"`
+    Tag      string      `json:"tag"`
+    Author   string      `json:"author"`
+    Created  time.Time   `json:"created"`
+}
+
+// Lock is an advisory check-out of a whole track (T1 empty) or one snapshot,
+// so two producers don't open and overwrite the same session.
+type Lock struct {
+    T1       string    `json:"t1,omitempty"`
+    Owner    string    `json:"owner"`
+    Note     string    `json:"note,omitempty"`
+    Acquired time.Time `json:"acquired"`
+    Expires  time.Time `json:"expires"`
+}
+
+// Comment is feedback pinned to a moment in one mix or master file
+// ("2:13 — snare too loud").
+type Comment struct {
+    ID       string      `json:"id"`
+    Artifact ArtifactRef `json:"artifact"`
+    At       float64     `json:"at"`       // seconds from the start
+    AtLabel  string      `json:"at_label"` // m:ss
+    Text     string      `json:"text"`
+    Author   string      `json:"author"`
+    Created  time.Time   `json:"created"`
+    Edited   *time.Time  `json:"edited,omitempty"`
+    Resolved bool        `json:"resolved,omitempty"`
+}
+
+// Deprecation marks a stems set or mix as not to be used ("wrong tempo
+// export"). The files stay where they are; listings leave them out unless asked.
+type Deprecation struct {
+    Artifact ArtifactRef `json:"artifact"`
+    Reason   string      `json:"reason"`
+    By       string      `json:"by"`
+    Created  time.Time   `json:"created"`
+}
+
+// VersionTag gives a snapshot, mix or master a name to use with clients
+// ("v2.1", "mixRevB") instead of its timestamps. Names are unique per track,
+// compared without case.
+type VersionTag struct {
+    Name     string      `json:"name"`
+    Artifact ArtifactRef `json:"artifact"`
+    By       string      `json:"by"`
+    Created  time.Time   `json:"created"`
+}
+
+// Publication is an artifact put on an outside service.
+type Publication struct {
+    Service     string      `json:"service"` // soundcloud
+    Artifact    ArtifactRef `json:"artifact"`
+    Path        string      `json:"path"`
+    ContentHash string      `json:"content_hash"`
+    URL         string      `json:"url"`
+    ID          string      `json:"id"` // the service's
+    Title       string      `json:"title"`
+    By          string      `json:"by"`
+    Created     time.Time   `json:"created"`
+}
+
+// ChangeEntry is one line of a track's human-readable history.
+type ChangeEntry struct {
+    Time     time.Time    `json:"time"`
+    Kind     string       `json:"kind"` // idea, lyrics, session, stems, mix, print, masters, final, note, comment, status, rename, deprecated, version
+    Artifact *ArtifactRef `json:"artifact,omitempty"`
+    Summary  string       `json:"summary"`
+    Author   string       `json:"author,omitempty"`
+}
+
+// StatusChange is one move of a track between stages.
+type StatusChange struct {
+    From string    `json:"from"`
+    To   string    `json:"to"`
+    At   time.Time `json:"at"`
+    By   string    `json:"by"`
+    Note string    `json:"note,omitempty"`
+}
+
+// TrackStatus is where a track is in the workflow, and how it got there.
+type TrackStatus struct {
+    Status  string         `json:"status"`
+    Since   time.Time      `json:"since,omitempty"`
+    By      string         `json:"by,omitempty"`
+    History []StatusChange `json:"history,omitempty"`
+}
+
+// Rating is one user's verdict on a mix or master file.
+type Rating struct {
+    Artifact ArtifactRef `json:"artifact"`
+    User     string      `json:"user"`
+    Stars    int         `json:"stars,omitempty"` // 1-5, 0 = favorite only
+    Favorite bool        `json:"favorite,omitempty"`
+    Updated  time.Time   `json:"updated"`
+}
+
+// RatingSummary aggregates the ratings of one artifact.
+type RatingSummary struct {
+    Artifact  ArtifactRef    `json:"artifact"`
+    Average   float64        `json:"average"` // over star votes only
+    Votes     int            `json:"votes"`
+    Favorites int            `json:"favorites"`
+    Stars     map[string]int `json:"stars,omitempty"` // user -> stars
+}
+
+// VersionRating aggregates every rated mix and master of one T1-T2 version.
+type VersionRating struct {
+    T1        string       `json:"t1"`
+    T2        string       `json:"t2"`
+    Average   float64      `json:"average"`
+    Votes     int          `json:"votes"`
+    Favorites int          `json:"favorites"`
+    Best      *ArtifactRef `json:"best,omitempty"` // highest rated file of the version
+}
+
+// RatedCandidate is a master candidate with its aggregated rating.
+type RatedCandidate struct {
+    Idx    string        `json:"idx"`
+    File   FileRef       `json:"file"`
+    Rating RatingSummary `json:"rating"`
+}
+
+// ====== Files ======
+
+// Suggestion is the conventional name for a file and how it was arrived at.
+type Suggestion struct {
+    Name    string   `json:"name"`
+    Track   string   `json:"track"`
+    Kind    string   `json:"kind"`
+    Path    string   `json:"path"` // where the canonical layout puts it
+    Assumed []string `json:"assumed,omitempty"`
+}
+
+// UploadTarget is where an upload of a validated name goes.
+type UploadTarget struct {
+    Name          string `json:"name"`
+    CorrectedFrom string `json:"corrected_from,omitempty"`
+    Track         string `json:"track"`
+    Kind          string `json:"kind"`
+    Path          string `json:"path"`
+}
+
+// UploadLink is a Dropbox link to upload a file to, without the server.
+type UploadLink struct {
+    UploadTarget
+    Link    string    `json:"link"`
+    Expires time.Time `json:"expires"`
+}
+
+// Upload is one resumable upload and its progress.
+type Upload struct {
+    ID string `json:"id"`
+    UploadTarget
+    Overwrite bool      `json:"overwrite,omitempty"`
+    Length    int64     `json:"length"`
+    Offset    int64     `json:"offset"`
+    Percent   float64   `json:"percent"`
+    Status    string    `json:"status"` // uploading, done, failed
+    Error     string    `json:"error,omitempty"`
+    File      *FileRef  `json:"file,omitempty"` // once done
+    SessionID string    `json:"session_id"`
+    By        string    `json:"by"`
+    Created   time.Time `json:"created"`
+    Updated   time.Time `json:"updated"`
+}
+
+// RenameOp is one move of an artifact file to a conventional name.
+type RenameOp struct {
+    From     string       `json:"from"`
+    To       string       `json:"to"`
+    Track    string       `json:"track"`
+    Artifact ArtifactRef  `json:"artifact"`      // what the new name says
+    Was      *ArtifactRef `json:"was,omitempty"` // what the old name said, if it parsed
+    WasTrack string       `json:"was_track,omitempty"`
+}
+
+// TrashItem is one deletion: the files it moved to the trash.
+type TrashItem struct {
+    ID       string        `json:"id"`
+    Track    string        `json:"track,omitempty"`
+    Artifact *ArtifactRef  `json:"artifact,omitempty"`
+    Files    []TrashedFile `json:"files"`
+    By       string        `json:"by"`
+    Deleted  time.Time     `json:"deleted"`
+    Expires  time.Time     `json:"expires"`
+}
+
+type TrashedFile struct {
+    Path      string `json:"path"` // where it was, and goes back to
+    TrashPath string `json:"trash_path"`
+}
+
+// ====== Releases ======
+
+// Release groups FINAL masters of several tracks into an EP or album.
+type Release struct {
+    ID          string         `json:"id"`
+    Title       string         `json:"title"`
+    Type        string         `json:"type"`                   // single, ep, album
+    Artist      string         `json:"artist,omitempty"`
+    ReleaseDate string         `json:"release_date,omitempty"` // YYYY-MM-DD
+    UPC         string         `json:"upc,omitempty"`          // UPC-A or EAN-13
+    Label       string         `json:"label,omitempty"`
+    Territories []string       `json:"territories,omitempty"`  // ISO 3166-1 alpha-2 codes; none means worldwide
+    Artwork     string         `json:"artwork,omitempty"`      // Dropbox path of the cover image; default the first track's artwork
+    Tracks      []ReleaseTrack `json:"tracks"`                 // in track order
+    Created     time.Time      `json:"created"`
+    Updated     time.Time      `json:"updated"`
+}
+
+// ReleaseTrack picks one version's FINAL master; empty T1/T2 means the
+// track's most recent FINAL, whatever it is when the release is resolved.
+type ReleaseTrack struct {
+    Track string   `json:"track"`
+    T1    string   `json:"t1,omitempty"`
+    T2    string   `json:"t2,omitempty"`
+    Gap   *float64 `json:"gap,omitempty"` // seconds of silence before it on CD; default 2
+}
+
+// ResolvedTrack is a ReleaseTrack with the FINAL it currently points at.
+type ResolvedTrack struct {
+    ReleaseTrack
+    Position int      `json:"position"`
+    Final    *FileRef `json:"final,omitempty"`
+    Problem  string   `json:"problem,omitempty"` // why Final is missing
+}
+
+// ResolvedRelease is a Release with what each position currently resolves to.
+type ResolvedRelease struct {
+    Release
+    Tracks []ResolvedTrack `json:"tracks"`
+    Ready  bool            `json:"ready"` // every position has a FINAL
+}
+
+// ReleaseChange is the writable part of a Release; nil fields are left as
+// they are.
+type ReleaseChange struct {
+    Title       *string         `json:"title"`
+    Type        *string         `json:"type"`
+    Artist      *string         `json:"artist"`
+    ReleaseDate *string         `json:"release_date"`
+    UPC         *string         `json:"upc"`
+    Label       *string         `json:"label"`
+    Territories *[]string       `json:"territories"`
+    Artwork     *string         `json:"artwork"`
+    Tracks      *[]ReleaseTrack `json:"tracks"`
+}
+
+// Deadline is when something is due for a track or a release.
+type Deadline struct {
+    ID       string     `json:"id"`
+    Kind     string     `json:"kind"`              // stems, master, release
+    Track    string     `json:"track,omitempty"`   // a track's, or
+    Release  string     `json:"release,omitempty"` // a release's (its ID)
+    Due      string     `json:"due"`               // YYYY-MM-DD, in the server's local time
+    Note     string     `json:"note,omitempty"`
+    Done     *time.Time `json:"done,omitempty"` // marked done by hand
+    By       string     `json:"by"`
+    Created  time.Time  `json:"created"`
+    Reminded []string   `json:"reminded,omitempty"` // reminders sent for this due date
+}
+
+// DeadlineState is a deadline as it stands.
+type DeadlineState struct {
+    Deadline
+    Title   string   `json:"title"`             // "Master of NEON_RAIN"
+    Met     bool     `json:"met"`
+    Missing []string `json:"missing,omitempty"` // tracks still without their stems or FINAL
+    Days    int      `json:"days"`              // until it is due; negative when overdue
+    Overdue bool     `json:"overdue"`
+}
+
+// DeadlineChange is the writable part of a Deadline; nil fields are left
+// as they are.
+type DeadlineChange struct {
+    Kind    *string `json:"kind"`
+    Track   *string `json:"track"`
+    Release *string `json:"release"`
+    Due     *string `json:"due"`
+    Note    *string `json:"note"`
+    Done    *bool   `json:"done"`
+}
+
+// AssignedCode is an ISRC given to a track, or a UPC to a release.
+type AssignedCode struct {
+    Code    string `json:"code"`
+    Track   string `json:"track,omitempty"`
+    Release string `json:"release,omitempty"` // its ID
+    Title   string `json:"title,omitempty"`   // the release's
+}
+
+// CodesNeeded is what a release still lacks to be delivered.
+type CodesNeeded struct {
+    Release string   `json:"release"`
+    Title   string   `json:"title"`
+    UPC     bool     `json:"upc"`  // it has none
+    ISRC    []string `json:"isrc"` // its tracks without one
+}
+
+// ====== Share Links ======
+
+// Share is a public, read-only link.
+type Share struct {
+    Token    string       `json:"token"`
+    Track    string       `json:"track"`
+    Artifact *ArtifactRef `json:"artifact,omitempty"` // nil: the track's newest mix and FINAL
+    Download bool         `json:"download"`
+    Password string       `json:"password,omitempty"` // PBKDF2 hash, kept by the server and never served
+    Locked   bool         `json:"locked,omitempty"`   // has a password; set when shown
+    By       string       `json:"by"`
+    Created  time.Time    `json:"created"`
+    Expires  time.Time    `json:"expires"`
+}
+
+// ShareStats is how a share link has been used.
+type ShareStats struct {
+    Views     int                      `json:"views"`
+    Plays     int                      `json:"plays"`
+    Downloads int                      `json:"downloads"`
+    Visitors  map[string]*ShareVisitor `json:"visitors"` // key: client address
+    Recent    []ShareEvent             `json:"recent"`   // newest last, the last 200 at most
+}
+
+// ShareVisitor is one client address's use of a link.
+type ShareVisitor struct {
+    Views     int       `json:"views"`
+    Plays     int       `json:"plays"`
+    Downloads int       `json:"downloads"`
+    First     time.Time `json:"first"`
+    Last      time.Time `json:"last"`
+}
+
+type ShareEvent struct {
+    At    time.Time `json:"at"`
+    Kind  string    `json:"kind"` // view, play, download
+    IP    string    `json:"ip"`
+    Agent string    `json:"agent,omitempty"`
+    File  string    `json:"file,omitempty"`
+}
+
+// ====== Blind A/B ======
+
+// ABSide is one of the two files under comparison. Which artifact sits
+// behind label "A" is random, and only revealed with the results.
+type ABSide struct {
+    Label    string      `json:"label"`
+    Track    string      `json:"track"`
+    Artifact ArtifactRef `json:"artifact"`
+    File     FileRef     `json:"file"`
+    Loudness float64     `json:"loudness"` // integrated LUFS
+    GainDB   float64     `json:"gain_db"`  // applied when streaming, never positive
+}
+
+type ABPick struct {
+    Listener string    `json:"listener"`
+    Pick     string    `json:"pick"` // label
+    Note     string    `json:"note,omitempty"`
+    At       time.Time `json:"at"`
+}
+
+type ABSession struct {
+    ID      string    `json:"id"`
+    Creator string    `json:"creator"`
+    Created time.Time `json:"created"`
+    Sides   [2]ABSide `json:"sides"`
+    Picks   []ABPick  `json:"picks"`
+}
+
+// ABBlind is what listeners see of a session before they pick.
+type ABBlind struct {
+    ID      string    `json:"id"`
+    Creator string    `json:"creator"`
+    Created time.Time `json:"created"`
+    Sides   []ABLabel `json:"sides"`
+    Picks   int       `json:"picks"` // how many have picked
+}
+
+// ABLabel is a side by its label alone.
+type ABLabel struct {
+    Label  string `json:"label"`
+    Stream string `json:"stream"` // the API path of its audio, gain applied
+}
+
+// ABResults is a session revealed: which artifact each label was and who
+// picked which.
+type ABResults struct {
+    ID    string         `json:"id"`
+    Sides [2]ABSide      `json:"sides"`
+    Picks []ABPick       `json:"picks"`
+    Tally map[string]int `json:"tally"` // picks by label
+}
+
+// ====== Search ======
+
+// SearchHit is a line that matched.
+type SearchHit struct {
+    Track    string `json:"track"`
+    In       string `json:"in"`                 // lyrics, notes
+    Revision int    `json:"revision,omitempty"` // of the lyrics; 1 is the first
+    Current  bool   `json:"current,omitempty"`  // the current lyrics
+    File     string `json:"file,omitempty"`
+    Line     int    `json:"line"` // 1-based
+    Text     string `json:"text"`
+}
+
+// ====== Sessions ======
+
+// Locator is an arrangement marker (Verse, Chorus, Drop...) in a Live set.
+type Locator struct {
+    Name    string  `json:"name"`
+    Beat    float64 `json:"beat"`
+    Seconds float64 `json:"seconds"`
+}
+
+// SessionInfo is the lightweight metadata readable from a session file header.
+type SessionInfo struct {
+    DAW     string  `json:"daw"`
+    Tempo   float64 `json:"tempo,omitempty"`
+    Title   string  `json:"title,omitempty"`
+    Version string  `json:"version,omitempty"` // DAW version that saved the file
+}
+
+// MidiInfo is what a Standard MIDI File holds, in brief.
+type MidiInfo struct {
+    Format         int         `json:"format"` // 0: one track, 1: tracks played together, 2: separate patterns
+    Tracks         int         `json:"tracks"`
+    TicksPerBeat   int         `json:"ticks_per_beat,omitempty"` // 0 with SMPTE timing
+    Length         float64     `json:"length_seconds"`
+    Beats          float64     `json:"beats,omitempty"`
+    Tempo          []MidiTempo `json:"tempo"` // the tempo map; 120 BPM unless it says otherwise
+    TimeSignatures []MidiMeter `json:"time_signatures,omitempty"`
+    Parts          []MidiPart  `json:"parts"` // by track chunk
+}
+
+type MidiTempo struct {
+    At   float64 `json:"at"` // seconds
+    Beat float64 `json:"beat"`
+    BPM  float64 `json:"bpm"`
+}
+
+type MidiMeter struct {
+    At        float64 `json:"at"`
+    Beat      float64 `json:"beat"`
+    Signature string  `json:"signature"` // e.g. 6/8
+}
+
+type MidiPart struct {
+    Name     string `json:"name,omitempty"`
+    Notes    int    `json:"notes"`
+    Channels []int  `json:"channels,omitempty"` // 1-16
+}
+
+// SampleDep is one sample referenced by a Live set, resolved against Dropbox.
+type SampleDep struct {
+    Ref         string `json:"ref"`                    // path as recorded in the set
+    Path        string `json:"path,omitempty"`         // resolved Dropbox path
+    Size        int64  `json:"size"`
+    ContentHash string `json:"content_hash,omitempty"` // Dropbox content_hash
+    Missing     bool   `json:"missing,omitempty"`
+    External    bool   `json:"external,omitempty"`     // outside the project folder
+    Refused     bool   `json:"refused,omitempty"`      // outside the root, or of a track the caller may not see
+    Bundled     string `json:"bundled,omitempty"`      // name in the bundle
+}
+
+type DepManifest struct {
+    Track     string      `json:"track"`
+    T1        string      `json:"t1"`
+    Set       FileRef     `json:"set"`
+    SetHash   string      `json:"set_content_hash,omitempty"`
+    Samples   []SampleDep `json:"samples"`
+    TotalSize int64       `json:"total_size"`
+    Missing   int         `json:"missing"`
+    Generated time.Time   `json:"generated"`
+}
+
+// ====== Manifests ======
+
+// ManifestFile is one artifact file as the convention classifies it.
+type ManifestFile struct {
+    Kind string `json:"kind"` // snapshot, backup, stem, mix, print, master, lyrics
+    T1   string `json:"t1"`
+    T2   string `json:"t2,omitempty"`
+    Part string `json:"part,omitempty"` // stem name, master index, print chain, session DAW or MIDI part ("midi" for the whole)
+    FileRef
+}
+
+// Artifact is the artifact f belongs to.
+func (f ManifestFile) Artifact() ArtifactRef {
+    switch f.Kind {
+    case KindSnapshot, KindBackup: return ArtifactRef{Kind: KindSnapshot, T1: f.T1}
+    case KindStem: return ArtifactRef{Kind: KindStems, T1: f.T1, T2: f.T2}
+    case KindMaster, KindPrint: return ArtifactRef{Kind: f.Kind, T1: f.T1, T2: f.T2, Idx: f.Part}
+    }
+    return ArtifactRef{Kind: f.Kind, T1: f.T1, T2: f.T2}
+}
+
+// ManifestEdge says to was produced from from.
+type ManifestEdge struct {
+    From ArtifactRef `json:"from"`
+    To   ArtifactRef `json:"to"`
+}
+
+type Manifest struct {
+    Format  string         `json:"format"`
+    Track   string         `json:"track"`
+    Parent  string         `json:"parent,omitempty"`
+    Updated time.Time      `json:"updated"` // newest artifact, so unchanged tracks give identical bytes
+    Files   []ManifestFile `json:"files"`
+    Lineage []ManifestEdge `json:"lineage"`
+}
+
+type SignedManifest struct {
+    Manifest  json.RawMessage `json:"manifest"`
+    Algorithm string          `json:"algorithm"`
+    PublicKey string          `json:"public_key"` // base64
+    Signature string          `json:"signature"`  // base64
+}
+
+// Verify checks m's signature against its own public key, which the caller
+// should compare with the server's (ManifestKey), and decodes the manifest.
+// The signature covers the manifest's compact encoding, however it is laid
+// out in the file.
+func (m *SignedManifest) Verify() (*Manifest, error) {
+    key, err := base64.StdEncoding.DecodeString(m.PublicKey)
+    if err != nil || len(key) != ed25519.PublicKeySize { return nil, errors.New("bad public key") }
+    sig, err := base64.StdEncoding.DecodeString(m.Signature)
+    if err != nil { return nil, errors.New("bad signature") }
+    var body bytes.Buffer
+    if err := json.Compact(&body, m.Manifest); err != nil { return nil, err }
+    if m.Algorithm != "ed25519" || !ed25519.Verify(key, body.Bytes(), sig) { return nil, errors.New("signature does not match") }
+    var out Manifest
+    if err := json.Unmarshal(m.Manifest, &out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// ====== File Requests ======
+
+// FileRequest is a track's file request.
+type FileRequest struct {
+    ID          string     `json:"id"`
+    URL         string     `json:"url"` // the upload page, to send to whoever uploads
+    Title       string     `json:"title"`
+    Track       string     `json:"track"`
+    Kind        string     `json:"kind,omitempty"` // what its files are: stem, mix, master; empty: to be told
+    Folder      string     `json:"folder"`
+    Open        bool       `json:"open"`
+    Files       int        `json:"files"` // uploaded so far, filed or not
+    Deadline    *time.Time `json:"deadline,omitempty"`
+    Description string     `json:"description,omitempty"`
+    Created     time.Time  `json:"created"`
+}
+
+// ====== Projects ======
+
+// ProjectSummary is a project with its tracks rolled up.
+type ProjectSummary struct {
+    Name        string  `json:"name"`
+    Title       string  `json:"title,omitempty"`
+    Type        string  `json:"type,omitempty"`
+    Artist      string  `json:"artist,omitempty"`
+    ReleaseDate string  `json:"release_date,omitempty"`
+    Dir         string  `json:"dir,omitempty"` // "" when only track.yaml files name it
+    TrackCount  int     `json:"track_count"`
+    Complete    int     `json:"complete"` // at the workflow's last stage
+    Finals      int     `json:"finals"`   // with a FINAL master
+    Runtime     float64 `json:"runtime_seconds"`
+    Length      string  `json:"runtime"` // the same as m:ss
+    Error       string  `json:"error,omitempty"`
+}
+
+// ProjectTrack is one track of a project.
+type ProjectTrack struct {
+    Track   string   `json:"track"`
+    Status  string   `json:"status,omitempty"`
+    Final   *FileRef `json:"final,omitempty"` // the most recent
+    Seconds float64  `json:"seconds,omitempty"`
+    Problem string   `json:"problem,omitempty"`
+}
+
+type ProjectDetail struct {
+    ProjectSummary
+    Notes  string         `json:"notes,omitempty"`
+    Tracks []ProjectTrack `json:"tracks"`
+}
+
+// ====== People ======
+
+// ContributorStats is one person's activity across the index.
+type ContributorStats struct {
+    User   string         `json:"user"`
+    Files  int            `json:"files"`
+    Bytes  int64          `json:"bytes"`
+    Kinds  map[string]int `json:"kinds"` // snapshot, stem, mix, master, ...
+    Tracks []string       `json:"tracks"`
+    First  time.Time      `json:"first"`
+    Last   time.Time      `json:"last"`
+}
+
+// Person is someone credited or contributing across the catalog.
+type Person struct {
+    ID       string    `json:"id"`
+    Name     string    `json:"name"`            // as most often written
+    Roles    []string  `json:"roles,omitempty"` // as track.yaml files give them
+    Tracks   int       `json:"tracks"`
+    Played   int       `json:"played"` // tracks, by credit
+    Mixed    int       `json:"mixed"`
+    Mastered int       `json:"mastered"`
+    Credited int       `json:"credited"`
+    Files    int       `json:"files"` // added by them
+    Last     time.Time `json:"last"`  // their latest file
+}
+
+// Credit is what a person did on one track.
+type Credit struct {
+    Track string    `json:"track"`
+    As    string    `json:"as"`              // played, mixed, mastered, credited
+    Roles []string  `json:"roles,omitempty"` // from track.yaml
+    Files int       `json:"files,omitempty"` // added by them
+    Last  time.Time `json:"last"`
+}
+
+type PersonDetail struct {
+    Person
+    Credits []Credit `json:"credits"`
+}
+
+// ====== Aliases ======
+
+// TrackAlias says files named From belong to track To. Aliases may chain.
+type TrackAlias struct {
+    From    string    `json:"from"`
+    To      string    `json:"to"`
+    By      string    `json:"by"`
+    Created time.Time `json:"created"`
+}
+
+// ====== Inbox ======
+
+// InboxItem is one file waiting in the inbox.
+type InboxItem struct {
+    Path     string      `json:"path"`
+    Size     int64       `json:"size"`
+    Modified time.Time   `json:"modified"`
+    Proposed *Suggestion `json:"proposed,omitempty"`
+    Error    string      `json:"error,omitempty"` // why no name could be proposed
+}
+
+// ====== Integrity ======
+
+// Baseline is the expected content of one file.
+type Baseline struct {
+    Path        string      `json:"path"`
+    Track       string      `json:"track"`
+    Artifact    ArtifactRef `json:"artifact"`
+    ContentHash string      `json:"content_hash"`
+    Size        int64       `json:"size"`
+    Recorded    time.Time   `json:"recorded"`
+    By          string      `json:"by"` // "indexer" for automatic FINAL baselines
+}
+
+// VerifyResult is the outcome for one baselined file.
+type VerifyResult struct {
+    Baseline
+    Status string `json:"status"`           // ok, modified, missing, corrupt, error
+    Actual string `json:"actual,omitempty"` // hash found now
+    Detail string `json:"detail,omitempty"`
+}
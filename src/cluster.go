@@ -0,0 +1,187 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// ====== Replicas ======
+//
+// Several instances can serve the same catalog behind a load balancer when
+// they share a directory, SHARED_DIR, on a volume they all mount (NFS, EFS,
+// a Kubernetes ReadWriteMany claim). It holds what would otherwise be in
+// DATA_DIR and must be the same everywhere: the state document, changed
+// under a file lock so no instance overwrites another's change, and the
+// manifest and URL signing keys. Whoever reindexes publishes the catalog
+// there as index.json, which the others load within indexPoll instead of
+// listing Dropbox themselves, and which a starting instance serves at once.
+//
+// One instance at a time leads, holding a lease (leader.json) it renews
+// every leaseRenew; when it stops renewing, another takes over once the
+// lease runs out. The leader alone runs the scheduled reindex
+// (REINDEX_INTERVAL), the trash purge and inbox filing. Each instance is
+// named by INSTANCE_ID, or its host name. Access logs, rate limits and
+// uploads in progress stay per instance, so resumable uploads need sticky
+// sessions.
+
+const (
+    leaseTTL   = 30 * time.Second
+    leaseRenew = 10 * time.Second
+    indexPoll  = 5 * time.Second
+)
+
+type cluster struct {
+    dir      string
+    instance string
+    leader   atomic.Bool
+
+    mu        sync.Mutex
+    indexSeen os.FileInfo // index.json as last written or loaded here
+}
+
+// lease is leader.json.
+type lease struct {
+    Instance string    `json:"instance"`
+    Expires  time.Time `json:"expires"`
+}
+
+// sharedIndex is index.json.
+type sharedIndex struct {
+    Instance  string            `json:"instance"`
+    Published time.Time         `json:"published"`
+    Tracks    map[string]*Track `json:"tracks"`
+    Projects  map[string]*Project `json:"projects,omitempty"`
+}
+
+// loadCluster reads SHARED_DIR; nil if it is not set.
+func loadCluster() (*cluster, error) {
+    dir := os.Getenv("SHARED_DIR")
+    if dir == "" { return nil, nil }
+    if err := os.MkdirAll(dir, 0o700); err != nil { return nil, fmt.Errorf("SHARED_DIR: %w", err) }
+    c := &cluster{dir: dir, instance: os.Getenv("INSTANCE_ID")}
+    if c.instance == "" {
+        host, err := os.Hostname()
+        if err != nil { return nil, fmt.Errorf("INSTANCE_ID is required: %w", err) }
+        c.instance = host
+    }
+    return c, nil
+}
+
+func (c *cluster) path(name string) string { return filepath.Join(c.dir, name) }
+
+// leading reports whether this instance runs the scheduled work: always,
+// when it is the only one.
+func (s *Server) leading() bool { return s.cluster == nil || s.cluster.leader.Load() }
+
+// campaign takes or renews the lease every leaseRenew.
+func (c *cluster) campaign() {
+    for {
+        if err := c.renew(); err != nil { slog.Error("leader lease failed", "error", err) }
+        time.Sleep(leaseRenew)
+    }
+}
+
+// renew takes the lease if it is free or ours, and records whether we hold it.
+func (c *cluster) renew() error {
+    unlock, err := lockFile(c.path("leader.lock"))
+    if err != nil { return err }
+    defer unlock()
+    var cur lease
+    b, err := os.ReadFile(c.path("leader.json"))
+    if err != nil && !errors.Is(err, os.ErrNotExist) { return err }
+    if err == nil { json.Unmarshal(b, &cur) }
+    was := c.leader.Load()
+    if cur.Instance != c.instance && time.Now().Before(cur.Expires) {
+        if was { slog.Warn("lost the leader lease", "leader", cur.Instance) }
+        c.leader.Store(false)
+        return nil
+    }
+    b, _ = json.Marshal(lease{Instance: c.instance, Expires: time.Now().Add(leaseTTL).UTC()})
+    if err := writeFileAtomic(c.path("leader.json"), b); err != nil { c.leader.Store(false); return err }
+    if !was { slog.Info("leading", "instance", c.instance) }
+    c.leader.Store(true)
+    return nil
+}
+
+// currentLease is leader.json as it stands.
+func (c *cluster) currentLease() lease {
+    var l lease
+    if b, err := os.ReadFile(c.path("leader.json")); err == nil { json.Unmarshal(b, &l) }
+    return l
+}
+
+// publishIndex writes tracks to index.json for the other instances.
+func (s *Server) publishIndex(ctx context.Context, tracks map[string]*Track) {
+    c := s.cluster
+    if c == nil { return }
+    s.mu.RLock(); projects := s.projects; s.mu.RUnlock()
+    b, err := json.Marshal(sharedIndex{Instance: c.instance, Published: time.Now().UTC(), Tracks: tracks, Projects: projects})
+    if err == nil { err = writeFileAtomic(c.path("index.json"), b) }
+    if err != nil { slog.ErrorContext(ctx, "publishing the index failed", "error", err); return }
+    fi, _ := os.Stat(c.path("index.json"))
+    c.mu.Lock(); c.indexSeen = fi; c.mu.Unlock()
+}
+
+// loadIndex replaces the catalog with index.json if it changed since this
+// instance last wrote or read it, and reports whether it did.
+func (s *Server) loadIndex(ctx context.Context) (bool, error) {
+    c := s.cluster
+    fi, err := os.Stat(c.path("index.json"))
+    if errors.Is(err, os.ErrNotExist) { return false, nil }
+    if err != nil { return false, err }
+    c.mu.Lock(); seen := c.indexSeen; c.mu.Unlock()
+    if seen != nil && os.SameFile(fi, seen) && fi.ModTime().Equal(seen.ModTime()) { return false, nil }
+    b, err := os.ReadFile(c.path("index.json"))
+    if err != nil { return false, err }
+    var idx sharedIndex
+    if err := json.Unmarshal(b, &idx); err != nil { return false, fmt.Errorf("index.json: %w", err) }
+    if idx.Tracks == nil { idx.Tracks = map[string]*Track{} }
+    compactTracks(idx.Tracks)
+    s.mu.Lock(); s.tracks, s.projects = idx.Tracks, idx.Projects; s.indexGen++; s.mu.Unlock()
+    c.mu.Lock(); c.indexSeen = fi; c.mu.Unlock()
+    s.health.record("index", nil)
+    slog.InfoContext(ctx, "loaded the shared index", "tracks", len(idx.Tracks), "from", idx.Instance, "published", idx.Published)
+    return true, nil
+}
+
+// followIndex loads the index other instances publish; the leader then runs
+// the work that follows a reindex.
+func (s *Server) followIndex() {
+    for range time.Tick(indexPoll) {
+        ctx := startJob(context.Background(), "load-index")
+        loaded, err := s.loadIndex(ctx)
+        if err != nil { slog.ErrorContext(ctx, "loading the shared index failed", "error", err); continue }
+        if loaded && s.leading() && !s.readOnly {
+            go runJob(ctx, "purge-trash", s.purgeTrash)
+            go runJob(ctx, "inbox", s.fileInbox)
+        }
+    }
+}
+
+// scheduleReindex reindexes every interval, on the leader.
+func (s *Server) scheduleReindex(every time.Duration) {
+    for range time.Tick(every) {
+        if !s.leading() || !s.maintenanceOpen(context.Background(), "scheduled reindex") { continue }
+        s.reindex(context.Background()) // logs its own failure
+    }
+}
+
+// writeFileAtomic replaces the file at p with b via a temporary file of
+// its own, so instances writing at once do not mix their contents.
+func writeFileAtomic(p string, b []byte) error {
+    f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+    if err != nil { return err }
+    _, err = f.Write(b)
+    if cerr := f.Close(); err == nil { err = cerr }
+    if err == nil { err = os.Rename(f.Name(), p) }
+    if err != nil { os.Remove(f.Name()) }
+    return err
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+    "bytes"
+    "cmp"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "maps"
+    "math"
+    "net/http"
+    "os"
+    "path"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== ISRC and UPC Codes ======
+//
+// A track's ISRC is kept in its track.yaml, as "isrc" or, for a branch (an
+// edit is a recording of its own), under "branch_isrcs"; a release's UPC is
+// kept on the release. Either may be typed in, or handed out from the
+// label's own ranges:
+//
+//   ISRC_PREFIX   the country and registrant code from the ISRC agency,
+//                 e.g. GB-ABC: codes run GB-ABC-25-00001, -00002, ... each year
+//   UPC_PREFIX    the GS1 company prefix, as the first digits of an EAN-13
+//                 (a US prefix starts with 0): codes are the prefix, the next
+//                 item number and a check digit, and 12-digit UPC-As when
+//                 they start with 0
+//
+//   POST /api/tracks/{name}/isrc                     the next ISRC, if it has none
+//   POST /api/tracks/{name}/isrc {"isrc":"..."}      that one
+//   POST /api/releases/{id}/codes                    a UPC for the release and an
+//                                                    ISRC for each track, where missing
+//   GET  /api/codes                                  every code, duplicates, what
+//                                                    releases lack and the next codes
+//
+// No code is handed out twice: the next one follows both the last handed out
+// (kept in the state) and the highest in the catalog, so once a code issued
+// elsewhere is entered the range goes on after it. No two tracks may share
+// an ISRC, nor two releases a UPC.
+//
+// Files handed out (a release's delivery package, share-link downloads)
+// carry the codes and the track's metadata: WAVs in a LIST/INFO chunk, a
+// bext chunk whose description reads "ISRC:<code>" and an "id3 " chunk, MP3s
+// in an ID3v2.3 tag (the ISRC as TSRC). The audio is not touched.
+
+type (
+    AssignedCode = client.AssignedCode
+    CodesNeeded  = client.CodesNeeded
+)
+
+var rxISRCPrefix = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}$`)
+
+// codePools are the ranges codes are handed out from; empty: none.
+type codePools struct {
+    isrc string // country and registrant code, CCXXX
+    upc  string // GS1 company prefix
+}
+
+// loadCodePools reads ISRC_PREFIX and UPC_PREFIX.
+func loadCodePools() (codePools, error) {
+    p := codePools{isrc: normalISRC(os.Getenv("ISRC_PREFIX")), upc: strings.TrimSpace(os.Getenv("UPC_PREFIX"))}
+    if p.isrc != "" && !rxISRCPrefix.MatchString(p.isrc) { return p, fmt.Errorf("ISRC_PREFIX must be a country and registrant code like GB-ABC") }
+    if p.upc != "" && (len(p.upc) < 6 || len(p.upc) > 11 || strings.Trim(p.upc, "0123456789") != "") {
+        return p, fmt.Errorf("UPC_PREFIX must be a GS1 company prefix of 6 to 11 digits")
+    }
+    return p, nil
+}
+
+// gtinCheck is the check digit of the GTIN digits before it.
+func gtinCheck(digits string) byte {
+    sum := 0
+    for i := range len(digits) {
+        d := int(digits[len(digits)-1-i] - '0')
+        if i%2 == 0 { d *= 3 }
+        sum += d
+    }
+    return byte('0' + (10-sum%10)%10)
+}
+
+// validGTIN reports whether the UPC or EAN code has the right check digit.
+func validGTIN(code string) bool {
+    return len(code) > 1 && gtinCheck(code[:len(code)-1]) == code[len(code)-1]
+}
+
+// metaOf is the metadata that applies to t: a branch has its parent's, with
+// its own ISRC. Nil if there is none.
+func (s *Server) metaOf(t *Track) *TrackMeta {
+    if t.Parent == "" { return t.Metadata }
+    p := s.lookupTrack(t.Parent)
+    if p == nil || p.Metadata == nil { return nil }
+    m := *p.Metadata
+    m.ISRC, m.BranchISRCs = p.Metadata.BranchISRCs[t.Branch], nil
+    return &m
+}
+
+// isrcOwners lists the tracks each ISRC in the catalog is given to.
+func (s *Server) isrcOwners() map[string][]string {
+    out := map[string][]string{}
+    s.mu.RLock(); defer s.mu.RUnlock()
+    for name, t := range s.tracks {
+        if t.Metadata == nil { continue }
+        if t.Metadata.ISRC != "" { out[t.Metadata.ISRC] = append(out[t.Metadata.ISRC], name) }
+        for b, code := range t.Metadata.BranchISRCs { out[code] = append(out[code], name+"."+b) }
+    }
+    for _, owners := range out { sort.Strings(owners) }
+    return out
+}
+
+// isrcTaken is the track other than those mine accepts that has code.
+func (s *Server) isrcTaken(code string, mine func(string) bool) string {
+    for _, owner := range s.isrcOwners()[code] {
+        if !mine(owner) { return owner }
+    }
+    return ""
+}
+
+// releaseUPCs lists the releases each UPC is given to, as EAN-13s.
+func (s *Server) releaseUPCs() map[string][]*Release {
+    out := map[string][]*Release{}
+    s.store.view(func(d *storeData) {
+        for _, rel := range d.Releases {
+            if rel.UPC != "" { c := *rel; out[ean(rel.UPC)] = append(out[ean(rel.UPC)], &c) }
+        }
+    })
+    return out
+}
+
+// isrcRange is the key and stem of this year's ISRCs, and the highest
+// designation number in the catalog.
+func (s *Server) isrcRange() (key, stem string, highest int) {
+    stem = s.codes.isrc + time.Now().Format("06")
+    for code := range s.isrcOwners() {
+        if n, ok := strings.CutPrefix(code, stem); ok {
+            v, _ := strconv.Atoi(n)
+            highest = max(highest, v)
+        }
+    }
+    return "isrc " + stem, stem, highest
+}
+
+// upcRange is the key of the UPC range, how many digits its item numbers
+// have and the highest item number among the releases.
+func (s *Server) upcRange() (key string, digits, highest int) {
+    digits = 12 - len(s.codes.upc)
+    for code := range s.releaseUPCs() {
+        if n, ok := strings.CutPrefix(code, s.codes.upc); ok {
+            v, _ := strconv.Atoi(n[:digits])
+            highest = max(highest, v)
+        }
+    }
+    return "upc " + s.codes.upc, digits, highest
+}
+
+// upcCode is item number n in the UPC range.
+func (s *Server) upcCode(digits, n int) string {
+    code := fmt.Sprintf("%s%0*d", s.codes.upc, digits, n)
+    code += string(gtinCheck(code))
+    return strings.TrimPrefix(code, "0") // EAN-13s starting with 0 are UPC-As
+}
+
+// nextISRC is the ISRC that would be handed out next.
+func (s *Server) nextISRC() string {
+    if s.codes.isrc == "" { return "" }
+    key, stem, highest := s.isrcRange()
+    n := 0
+    s.store.view(func(d *storeData) { n = max(d.Codes[key], highest) + 1 })
+    if n > 99999 { return "" }
+    return fmt.Sprintf("%s%05d", stem, n)
+}
+
+// nextUPC is the UPC that would be handed out next.
+func (s *Server) nextUPC() string {
+    if s.codes.upc == "" { return "" }
+    key, digits, highest := s.upcRange()
+    n := 0
+    s.store.view(func(d *storeData) { n = max(d.Codes[key], highest) + 1 })
+    if n >= int(math.Pow10(digits)) { return "" }
+    return s.upcCode(digits, n)
+}
+
+// takeCode hands out the number after both the last handed out under key and
+// highest, up to limit.
+func (s *Server) takeCode(key string, highest, limit int) (int, error) {
+    var n int
+    err := s.store.update(func(d *storeData) error {
+        if n = max(d.Codes[key], highest) + 1; n > limit { return httpError{409, "the " + key + " range is used up"} }
+        if d.Codes == nil { d.Codes = map[string]int{} }
+        d.Codes[key] = n
+        return nil
+    })
+    return n, err
+}
+
+// takeISRC hands out the next ISRC.
+func (s *Server) takeISRC() (string, error) {
+    if s.codes.isrc == "" { return "", httpError{409, "no ISRC_PREFIX to hand out ISRCs from"} }
+    key, stem, highest := s.isrcRange()
+    n, err := s.takeCode(key, highest, 99999)
+    if err != nil { return "", err }
+    return fmt.Sprintf("%s%05d", stem, n), nil
+}
+
+// takeUPC hands out the next UPC.
+func (s *Server) takeUPC() (string, error) {
+    if s.codes.upc == "" { return "", httpError{409, "no UPC_PREFIX to hand out UPCs from"} }
+    key, digits, highest := s.upcRange()
+    n, err := s.takeCode(key, highest, int(math.Pow10(digits))-1)
+    if err != nil { return "", err }
+    return s.upcCode(digits, n), nil
+}
+
+// setISRC writes code to the track.yaml t's ISRC is kept in: its own, or its
+// parent's for a branch.
+func (s *Server) setISRC(ctx context.Context, t *Track, code string) error {
+    owner := t
+    if t.Parent != "" { owner = s.lookupTrack(t.Parent) }
+    if owner == nil || owner.Dir == "" { return httpError{409, t.Name + " has no folder"} }
+    if owner.Archived { return httpError{409, owner.Name + " is archived"} }
+    p := path.Join(owner.Dir, metadataFile)
+    s.writeMu.Lock(); defer s.writeMu.Unlock()
+    if other := s.isrcTaken(code, func(n string) bool { return n == t.Name }); other != "" { return httpError{409, code + " is " + other + "'s ISRC"} }
+    doc, err := s.readMetadataFile(ctx, p)
+    if err != nil { return httpError{502, err.Error()} }
+    m, err := parseTrackMeta(doc)
+    if err != nil { return httpError{409, p + ": " + err.Error()} } // rewriting it would lose what it says
+    if t.Parent == "" {
+        m.ISRC = code
+    } else {
+        m.BranchISRCs = maps.Clone(m.BranchISRCs)
+        if m.BranchISRCs == nil { m.BranchISRCs = map[string]string{} }
+        m.BranchISRCs[t.Branch] = code
+    }
+    if err := validateMeta(m); err != nil { return httpError{400, err.Error()} }
+    if _, err := s.dbxUpload(ctx, p, bytes.NewReader([]byte(renderYAML(doc, m)))); err != nil { return httpError{502, err.Error()} }
+    return nil
+}
+
+// POST /api/tracks/{name}/isrc
+// POST /api/tracks/{name}/isrc {"isrc":"GB-ABC-25-00007"}
+func (s *Server) handleTrackISRC(w http.ResponseWriter, r *http.Request, t *Track) {
+    if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    var req struct {
+        ISRC string `json:"isrc"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF { http.Error(w, "bad json: "+err.Error(), 400); return }
+    before := ""
+    if m := s.metaOf(t); m != nil { before = m.ISRC }
+    code, pooled := normalISRC(req.ISRC), req.ISRC == ""
+    switch {
+    case pooled && before != "":
+        http.Error(w, t.Name+" already has ISRC "+before+"; give one to replace it", 409); return
+    case pooled:
+        var err error
+        if code, err = s.takeISRC(); err != nil { writeError(w, err); return }
+    case !rxISRC.MatchString(code):
+        http.Error(w, "isrc must look like CC-XXX-YY-NNNNN", 400); return
+    }
+    if err := s.setISRC(r.Context(), t, code); err != nil { writeError(w, err); return }
+    s.audit(r, "isrc", t.Name, before, code)
+    if err := s.reindex(r.Context()); err != nil { http.Error(w, "saved, but reindex failed: "+err.Error(), 500); return }
+    writeJSON(w, map[string]any{"track": t.Name, "isrc": code, "pooled": pooled})
+}
+
+// handleReleaseCodes gives rel a UPC and its tracks ISRCs where they have
+// none, from the pools.
+func (s *Server) handleReleaseCodes(w http.ResponseWriter, r *http.Request, rel *Release) {
+    if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    var need []*Track
+    for _, rt := range rel.Tracks {
+        t := s.lookupTrack(rt.Track)
+        if t == nil { http.Error(w, "track not found: "+rt.Track, 404); return }
+        if m := s.metaOf(t); m == nil || m.ISRC == "" { need = append(need, t) }
+    }
+    if rel.UPC == "" && s.codes.upc == "" { http.Error(w, "no UPC_PREFIX to hand out UPCs from", 409); return }
+    if len(need) > 0 && s.codes.isrc == "" { http.Error(w, "no ISRC_PREFIX to hand out ISRCs from", 409); return }
+
+    out := map[string]any{"release": rel.ID}
+    if rel.UPC == "" {
+        code, err := s.takeUPC()
+        if err != nil { writeError(w, err); return }
+        before := *rel
+        err = s.store.update(func(d *storeData) error {
+            cur := d.Releases[rel.ID]
+            if cur == nil { return httpError{404, "release not found"} }
+            if cur.UPC != "" { code = cur.UPC; return nil } // given one meanwhile
+            next := *cur
+            next.UPC, next.Updated = code, time.Now().UTC()
+            d.Releases[rel.ID], rel = &next, &next
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "release-update", "", before, *rel)
+        out["upc"] = code
+    }
+    isrcs := map[string]string{}
+    for _, t := range need {
+        code, err := s.takeISRC()
+        if err == nil { err = s.setISRC(r.Context(), t, code) }
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "isrc", t.Name, "", code)
+        isrcs[t.Name] = code
+    }
+    out["isrcs"] = isrcs
+    if len(isrcs) > 0 {
+        if err := s.reindex(r.Context()); err != nil { http.Error(w, "saved, but reindex failed: "+err.Error(), 500); return }
+    }
+    writeJSON(w, out)
+}
+
+// GET /api/codes
+func (s *Server) handleCodes(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    isrcs, upcs, duplicates := []AssignedCode{}, []AssignedCode{}, []string{}
+    for code, owners := range s.isrcOwners() {
+        for _, o := range owners { isrcs = append(isrcs, AssignedCode{Code: code, Track: o}) }
+        if len(owners) > 1 { duplicates = append(duplicates, code) }
+    }
+    for code, rels := range s.releaseUPCs() {
+        for _, rel := range rels { upcs = append(upcs, AssignedCode{Code: rel.UPC, Release: rel.ID, Title: rel.Title}) }
+        if len(rels) > 1 { duplicates = append(duplicates, code) }
+    }
+    sort.Slice(isrcs, func(i, j int) bool { return isrcs[i].Code+isrcs[i].Track < isrcs[j].Code+isrcs[j].Track })
+    sort.Slice(upcs, func(i, j int) bool { return upcs[i].Code+upcs[i].Release < upcs[j].Code+upcs[j].Release })
+    sort.Strings(duplicates)
+
+    // what the releases still need
+    missing := []CodesNeeded{}
+    var rels []*Release
+    s.store.view(func(d *storeData) {
+        for _, rel := range d.Releases { c := *rel; rels = append(rels, &c) }
+    })
+    sort.Slice(rels, func(i, j int) bool { return rels[i].Title < rels[j].Title })
+    for _, rel := range rels {
+        tracks := []string{}
+        for _, rt := range rel.Tracks {
+            if t := s.lookupTrack(rt.Track); t != nil {
+                if m := s.metaOf(t); m == nil || m.ISRC == "" { tracks = append(tracks, t.Name) }
+            }
+        }
+        if rel.UPC == "" || len(tracks) > 0 {
+            missing = append(missing, CodesNeeded{Release: rel.ID, Title: rel.Title, UPC: rel.UPC == "", ISRC: tracks})
+        }
+    }
+    writeJSON(w, map[string]any{
+        "isrc_prefix": s.codes.isrc, "next_isrc": s.nextISRC(), "upc_prefix": s.codes.upc, "next_upc": s.nextUPC(),
+        "isrcs": isrcs, "upcs": upcs, "duplicates": duplicates, "missing": missing,
+    })
+}
+
+// audioTags is what files handed out are tagged with.
+type audioTags struct {
+    Title, Artist, Album, Label, Genre, Date, Key, ISRC string
+    Position                                           int
+    BPM                                                float64
+}
+
+// audioTagsOf tags a file of t: its metadata, and the artist and title of a
+// release it is on.
+func (s *Server) audioTagsOf(t *Track) audioTags {
+    a := audioTags{Title: releaseTitle(t.Name)}
+    if m := s.metaOf(t); m != nil {
+        a.Genre, a.Date, a.Key, a.ISRC, a.BPM = m.Genre, m.ReleaseDate, m.Key, m.ISRC, m.BPM
+    }
+    var on *Release
+    s.store.view(func(d *storeData) {
+        for _, rel := range d.Releases {
+            for i, rt := range rel.Tracks {
+                if rt.Track == t.Name && (on == nil || rel.ReleaseDate < on.ReleaseDate || rel.ReleaseDate == on.ReleaseDate && rel.ID < on.ID) {
+                    c := *rel
+                    on, a.Position = &c, i+1
+                }
+            }
+        }
+    })
+    if on != nil {
+        a.Artist, a.Album, a.Label, a.Date = on.Artist, on.Title, on.Label, cmp.Or(a.Date, on.ReleaseDate)
+    }
+    return a
+}
+
+var rxKeyNote = regexp.MustCompile(`^[A-G][#b]?m?$`)
+
+// id3 is the ID3v2.3 tag of a.
+func (a audioTags) id3() []byte {
+    key := "" // TKEY wants "F#m": the note, then m for minor
+    if f := strings.Fields(a.Key); len(f) > 0 && rxKeyNote.MatchString(f[0]) {
+        key = f[0]
+        if len(f) > 1 && strings.HasPrefix(strings.ToLower(f[1]), "min") && !strings.HasSuffix(key, "m") { key += "m" }
+    }
+    bpm := ""
+    if a.BPM > 0 { bpm = strconv.Itoa(int(a.BPM + 0.5)) }
+    return id3Tag([][2]string{
+        {"TIT2", a.Title}, {"TPE1", a.Artist}, {"TALB", a.Album}, {"TPUB", a.Label}, {"TCON", a.Genre},
+        {"TYER", a.Date[:min(len(a.Date), 4)]}, {"TRCK", position(a.Position)}, {"TBPM", bpm}, {"TKEY", key}, {"TSRC", a.ISRC}, {"TSSE", "avcs-browser"},
+    })
+}
+
+// wav is the chunks a WAV is tagged with.
+func (a audioTags) wav() []byte {
+    out := wavInfo([][2]string{
+        {"INAM", a.Title}, {"IART", a.Artist}, {"IPRD", a.Album}, {"IGNR", a.Genre},
+        {"ICRD", a.Date}, {"ITRK", position(a.Position)}, {"ISFT", "avcs-browser"},
+    })
+    description := a.Title
+    if a.ISRC != "" { description = "ISRC:" + a.ISRC }
+    out = append(out, wavBext(description, "avcs-browser", time.Now())...)
+    return append(out, wavChunk("id3 ", a.id3())...)
+}
+
+// position is a track number as text; empty for none.
+func position(n int) string {
+    if n == 0 { return "" }
+    return strconv.Itoa(n)
+}
+
+// tagFile is the file in r, of size bytes, tagged: WAVs and MP3s are,
+// anything else is left as it is. It also gives the new size.
+func tagFile(r io.Reader, name string, size int64, tags audioTags) (io.ReadCloser, int64, error) {
+    switch strings.ToLower(path.Ext(name)) {
+    case ".mp3":
+        rd, delta, err := withID3(r, tags.id3())
+        if err != nil { return nil, 0, err }
+        return io.NopCloser(rd), size + delta, nil
+    case ".wav":
+        chunks := tags.wav()
+        pr, pw := io.Pipe()
+        go func() { _, err := withTags(pw, r, chunks); pw.CloseWithError(err) }()
+        return pr, size + int64(len(chunks)), nil
+    }
+    return io.NopCloser(r), size, nil
+}
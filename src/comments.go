@@ -0,0 +1,180 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Time-Anchored Comments ======
+
+type Comment = client.Comment
+
+// parseOffset accepts seconds (133.5) or a clock position ("2:13", "1:02:13").
+func parseOffset(v any) (float64, error) {
+    switch x := v.(type) {
+    case float64:
+        if x < 0 { return 0, fmt.Errorf("negative offset") }
+        return x, nil
+    case string:
+        var sec float64
+        for _, f := range strings.Split(strings.TrimSpace(x), ":") {
+            n, err := strconv.ParseFloat(f, 64)
+            if err != nil || n < 0 { return 0, fmt.Errorf("bad offset %q", x) }
+            sec = sec*60 + n
+        }
+        return sec, nil
+    }
+    return 0, fmt.Errorf("offset required")
+}
+
+func formatOffset(sec float64) string {
+    s := int(sec)
+    if s >= 3600 { return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60) }
+    return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// GET    /api/tracks/{name}/comments[?kind=&t1=&t2=&idx=&resolved=0|1]
+// POST   /api/tracks/{name}/comments {"artifact":{...},"at":"2:13","text":"..."}
+// GET    /api/tracks/{name}/comments/{id}
+// PATCH  /api/tracks/{name}/comments/{id} {"text":"...","at":...,"resolved":true}
+// DELETE /api/tracks/{name}/comments/{id}
+func (s *Server) handleComments(w http.ResponseWriter, r *http.Request, t *Track, parts []string) {
+    if len(parts) > 0 && parts[0] != "" {
+        s.handleComment(w, r, t, parts[0])
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        q := r.URL.Query()
+        filter := ArtifactRef{Kind: q.Get("kind"), T1: q.Get("t1"), T2: q.Get("t2"), Idx: q.Get("idx")}
+        resolved := q.Get("resolved")
+        out := []Comment{}
+        for _, c := range s.commentsFor(t.Name) {
+            if filter.Kind != "" && !filter.MatchesLoose(c.Artifact) { continue }
+            if resolved != "" && c.Resolved != (resolved == "1" || resolved == "true") { continue }
+            out = append(out, c)
+        }
+        writeJSON(w, out)
+
+    case http.MethodPost:
+        var req struct {
+            Artifact ArtifactRef `json:"artifact"`
+            At       any         `json:"at"`
+            Text     string      `json:"text"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        req.Text = strings.TrimSpace(req.Text)
+        if req.Text == "" { http.Error(w, "text required", 400); return }
+        at, err := parseOffset(req.At)
+        if err != nil { http.Error(w, err.Error(), 400); return }
+        if !singleFile(req.Artifact) { http.Error(w, "comments go on a mix or a master candidate/FINAL", 400); return }
+        if err := req.Artifact.Resolve(t); err != nil { http.Error(w, err.Error(), 404); return }
+        c := Comment{ID: newID(), Artifact: req.Artifact, At: at, AtLabel: formatOffset(at), Text: req.Text, Author: actorOf(r), Created: time.Now().UTC()}
+        err = s.store.update(func(d *storeData) error {
+            if d.Comments == nil { d.Comments = map[string][]Comment{} }
+            d.Comments[t.Name] = append(d.Comments[t.Name], c)
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "comment", t.Name, nil, c)
+        writeJSONStatus(w, http.StatusCreated, c)
+
+    default:
+        http.Error(w, "GET or POST required", 405)
+    }
+}
+
+func (s *Server) handleComment(w http.ResponseWriter, r *http.Request, t *Track, id string) {
+    // edit applies fn to the stored comment; before is its prior state.
+    edit := func(fn func(c *Comment) error) (before, after Comment, err error) {
+        err = s.store.update(func(d *storeData) error {
+            for i, c := range d.Comments[t.Name] {
+                if c.ID != id { continue }
+                before = c
+                if err := fn(&c); err != nil { return err } // c is a copy: nothing half-applied
+                d.Comments[t.Name][i], after = c, c
+                return nil
+            }
+            return httpError{404, "comment not found"}
+        })
+        return
+    }
+    own := func(c *Comment) error {
+        if c.Author != actorOf(r) { return httpError{403, "only " + c.Author + " can change this comment"} }
+        return nil
+    }
+
+    switch r.Method {
+    case http.MethodGet:
+        for _, c := range s.commentsFor(t.Name) {
+            if c.ID == id { writeJSON(w, c); return }
+        }
+        http.Error(w, "comment not found", 404)
+
+    case http.MethodPatch:
+        var req struct {
+            Text     *string `json:"text"`
+            At       any     `json:"at"`
+            Resolved *bool   `json:"resolved"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        before, after, err := edit(func(c *Comment) error {
+            if req.Text != nil || req.At != nil {
+                if err := own(c); err != nil { return err }
+                now := time.Now().UTC()
+                c.Edited = &now
+            }
+            if req.Text != nil {
+                if c.Text = strings.TrimSpace(*req.Text); c.Text == "" { return httpError{400, "text required"} }
+            }
+            if req.At != nil {
+                at, err := parseOffset(req.At)
+                if err != nil { return httpError{400, err.Error()} }
+                c.At, c.AtLabel = at, formatOffset(at)
+            }
+            if req.Resolved != nil { c.Resolved = *req.Resolved }
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "comment-edit", t.Name, before, after)
+        writeJSON(w, after)
+
+    case http.MethodDelete:
+        var removed Comment
+        err := s.store.update(func(d *storeData) error {
+            list := d.Comments[t.Name]
+            for i := range list {
+                if list[i].ID != id { continue }
+                if err := own(&list[i]); err != nil { return err }
+                removed = list[i]
+                d.Comments[t.Name] = append(list[:i:i], list[i+1:]...)
+                return nil
+            }
+            return httpError{404, "comment not found"}
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "comment-delete", t.Name, removed, nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "GET, PATCH or DELETE required", 405)
+    }
+}
+
+// commentsFor returns the track's comments ordered by artifact, then position.
+func (s *Server) commentsFor(track string) []Comment {
+    var out []Comment
+    s.store.view(func(d *storeData) { out = append(out, d.Comments[track]...) })
+    sort.SliceStable(out, func(i, j int) bool {
+        if a, b := out[i].Artifact.String(), out[j].Artifact.String(); a != b { return a < b }
+        return out[i].At < out[j].At
+    })
+    return out
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== CLI Completion ======
+//
+// `avcs completion bash|zsh|fish` prints a script for the shell to source:
+//
+//   source <(avcs completion bash)          # in ~/.bashrc
+//   source <(avcs completion zsh)           # in ~/.zshrc, after compinit
+//   avcs completion fish > ~/.config/fish/completions/avcs.fish
+//
+// The scripts hand the command line to `avcs __complete`, which answers
+// with commands, flags, and track names and stems sets fetched live from
+// the server, fuzzily matched (see pick.go): nr<Tab> finds NEON_RAIN and
+// --set 1130<Tab> finds 1040P-1130P. Where it has nothing to offer, the
+// shell completes file names.
+
+// cliTrackCommands are the commands whose operand is a track.
+var cliTrackCommands = []string{"latest", "stems", "changelog", "open"}
+
+// cliFlagsWanted, while set, is handed a command's flags by parseCLI, which
+// then panics with errFlagsOnly instead of running the command.
+var cliFlagsWanted func(*flag.FlagSet)
+
+var errFlagsOnly = errors.New("flags only")
+
+// completeTimeout bounds the server requests made for one completion, so a
+// server out of reach does not hang the shell.
+const completeTimeout = 3 * time.Second
+
+// cliCompletion is `avcs completion`.
+func cliCompletion(args []string) error {
+    scripts := map[string]string{"bash": bashCompletion, "zsh": zshCompletion, "fish": fishCompletion}
+    if len(args) != 1 || scripts[args[0]] == "" {
+        fmt.Fprintln(os.Stderr, "usage: avcs completion bash|zsh|fish")
+        os.Exit(2)
+    }
+    fmt.Print(scripts[args[0]])
+    return nil
+}
+
+// cliComplete is `avcs __complete <words>`: the candidates for the last of
+// the words, the one being typed, one per line. It fails quietly, as a
+// completion must.
+func cliComplete(args []string) error {
+    if len(args) == 0 { return nil }
+    for _, c := range completeWords(args) { fmt.Println(c) }
+    return nil
+}
+
+// completeWords are the candidates for the last of words, which follow avcs.
+func completeWords(words []string) []string {
+    cur := words[len(words)-1]
+    cmds := cliCommands()
+    if len(words) == 1 {
+        var names []string
+        for n := range cmds {
+            if !strings.HasPrefix(n, "_") { names = append(names, n) }
+        }
+        sort.Strings(names)
+        return fuzzyFilter(cur, names)
+    }
+    cmd := words[0]
+    if cmd == "completion" { return fuzzyFilter(cur, []string{"bash", "zsh", "fish"}) }
+    fs := cliFlagSet(cmds[cmd])
+    if fs == nil { return nil }
+
+    // Walk the words typed so far for the operands and the flag awaiting a value.
+    var operands []string
+    track, pending := "", ""
+    kind := kindStem // of the versions --set takes: stems sets, or what avcs open opens
+    if cmd == "open" { kind = kindMaster }
+    for _, w := range words[1 : len(words)-1] {
+        if pending != "" {
+            if pending == "track" { track = w }
+            pending = ""
+            continue
+        }
+        name, _, hasValue := strings.Cut(strings.TrimLeft(w, "-"), "=")
+        switch {
+        case !strings.HasPrefix(w, "-") || w == "-": operands = append(operands, w)
+        case cmd == "open" && name == "mix": kind = kindMix
+        case cmd == "open" && name == "stem": kind = kindStem
+        }
+        if f := fs.Lookup(name); strings.HasPrefix(w, "-") && f != nil && !hasValue && !isBoolFlag(f) { pending = name }
+    }
+    if track == "" && len(operands) > 0 && slices.Contains(cliTrackCommands, cmd) { track = operands[0] }
+
+    switch pending {
+    case "":
+    case "track":
+        return completeTracks(words, cur)
+    case "set":
+        return completeVersions(words, track, kind, cur)
+    case "master":
+        return fuzzyFilter(cur, []string{"final", "latest"})
+    case "kind":
+        kinds := []string{"session", "stems", "mix", "print", "master"}
+        if cmd == "latest" { kinds = []string{"master", "mix", "stems", "session", "bounce"} }
+        return fuzzyFilter(cur, kinds)
+    case "profile":
+        profiles, _, _ := cliProfiles()
+        var names []string
+        for n := range profiles { names = append(names, n) }
+        sort.Strings(names)
+        return fuzzyFilter(cur, names)
+    default:
+        return nil
+    }
+    if strings.HasPrefix(cur, "-") {
+        var names []string
+        fs.VisitAll(func(f *flag.Flag) {
+            if strings.HasPrefix(f.Name, strings.TrimLeft(cur, "-")) { names = append(names, "--"+f.Name) }
+        })
+        return names
+    }
+    if len(operands) == 0 && slices.Contains(cliTrackCommands, cmd) { return completeTracks(words, cur) }
+    return nil
+}
+
+// cliFlagSet is the flags cmd takes: it is run only as far as parseCLI.
+func cliFlagSet(cmd func([]string) error) (fs *flag.FlagSet) {
+    if cmd == nil { return nil }
+    cliFlagsWanted = func(got *flag.FlagSet) { fs = got }
+    defer func() {
+        cliFlagsWanted = nil
+        if r := recover(); r != nil && r != errFlagsOnly { panic(r) }
+    }()
+    cmd(nil)
+    return fs
+}
+
+// isBoolFlag reports whether f takes no value.
+func isBoolFlag(f *flag.Flag) bool {
+    b, ok := f.Value.(interface{ IsBoolFlag() bool })
+    return ok && b.IsBoolFlag()
+}
+
+// completeClient is the client of the server the command line picks, by
+// --profile, --server and --key, else the default.
+func completeClient(words []string) (*cliClient, error) {
+    fs := flag.NewFlagSet("complete", flag.ContinueOnError)
+    fs.SetOutput(io.Discard)
+    connect := cliFlags(fs)
+    var args []string
+    typed := words[1 : len(words)-1]
+    for i, w := range typed {
+        name, _, hasValue := strings.Cut(strings.TrimLeft(w, "-"), "=")
+        if !strings.HasPrefix(w, "-") || fs.Lookup(name) == nil { continue }
+        if hasValue {
+            args = append(args, w)
+        } else if i+1 < len(typed) {
+            args = append(args, w, typed[i+1])
+        }
+    }
+    fs.Parse(args)
+    return connect()
+}
+
+// completeTracks are the server's tracks matching cur.
+func completeTracks(words []string, cur string) []string {
+    c, err := completeClient(words)
+    if err != nil { return nil }
+    ctx, cancel := context.WithTimeout(context.Background(), completeTimeout)
+    defer cancel()
+    names, err := c.trackNames(ctx)
+    if err != nil { return nil }
+    return fuzzyFilter(cur, names)
+}
+
+// completeVersions are the T1-T2 of track's stems sets, mixes or masters, by
+// kind, matching cur, newest first.
+func completeVersions(words []string, track, kind, cur string) []string {
+    if track == "" { return nil }
+    c, err := completeClient(words)
+    if err != nil { return nil }
+    ctx, cancel := context.WithTimeout(context.Background(), completeTimeout)
+    defer cancel()
+    t, err := c.Track(ctx, strings.ToUpper(track))
+    if client.IsNotFound(err) {
+        names, _ := c.trackNames(ctx)
+        if m := fuzzyFilter(track, names); len(m) == 1 { t, err = c.Track(ctx, m[0]) }
+    }
+    if err != nil { return nil }
+    return fuzzyFilter(cur, versionLabels(t, kind))
+}
+
+
+const bashCompletion = `# avcs completion for bash: source <(avcs completion bash)
+_avcs() {
+    local IFS=$'\n'
+    COMPREPLY=($(avcs __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _avcs avcs
+`
+
+const zshCompletion = `#compdef avcs
+# avcs completion for zsh: source <(avcs completion zsh), after compinit
+_avcs() {
+    local -a candidates
+    candidates=("${(@f)$(avcs __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    if [[ -n "${candidates[1]}" ]]; then
+        compadd -U -Q -a candidates
+    else
+        _files
+    fi
+}
+compdef _avcs avcs
+`
+
+const fishCompletion = `# avcs completion for fish: avcs completion fish > ~/.config/fish/completions/avcs.fish
+function __avcs_complete
+    set -l candidates (avcs __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)
+    if test (count $candidates) -gt 0
+        printf '%s\n' $candidates
+    else
+        __fish_complete_path (commandline -ct)
+    end
+end
+complete -c avcs -f -a '(__avcs_complete)'
+`
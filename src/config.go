@@ -0,0 +1,271 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "slices"
+    "sort"
+    "strings"
+)
+
+// ====== Config File ======
+//
+// Settings may also come from a YAML file, named by -config or CONFIG_FILE,
+// that groups them by what they are about:
+//
+//   server:
+//     bind_addr: ":8080"
+//     data_dir: /data
+//   dropbox:
+//     token_file: /run/secrets/dropbox_token
+//     root: /Tracks
+//   auth:
+//     default_role: viewer
+//     oidc:
+//       issuer: https://accounts.google.com
+//       allowed_domains: [example.com]
+//
+// Each key stands for one environment variable (see configEnv), and the
+// environment wins over the file, so a deployment can override single
+// settings. Lists become comma-separated, and secrets take the same _file
+// and _vault variants as their variables. Unknown keys are an error, so a
+// typo does not go unnoticed. -validate-config checks every setting,
+// including that secrets can be read and DATA_DIR written, and exits.
+
+// configEnv maps config file keys to environment variables.
+var configEnv = map[string]string{
+    "server.bind_addr":            "BIND_ADDR",
+    "server.data_dir":             "DATA_DIR",
+    "server.trust_proxy":          "TRUST_PROXY",
+    "server.read_only":            "READ_ONLY",
+    "server.request_timeout":      "REQUEST_TIMEOUT",
+    "server.long_request_timeout": "LONG_REQUEST_TIMEOUT",
+    "server.public_url":           "PUBLIC_URL",
+    "server.spill_history":        "SPILL_HISTORY",
+    "server.stream_cache_mb":      "STREAM_CACHE_MB",
+
+    "cluster.shared_dir":  "SHARED_DIR",
+    "cluster.instance_id": "INSTANCE_ID",
+
+    "tls.cert_file":      "TLS_CERT_FILE",
+    "tls.key_file":       "TLS_KEY_FILE",
+    "tls.acme.domains":   "ACME_DOMAINS",
+    "tls.acme.email":     "ACME_EMAIL",
+    "tls.acme.directory": "ACME_DIRECTORY",
+    "tls.acme.http_addr": "ACME_HTTP_ADDR",
+
+    "cors.origins":     "CORS_ORIGINS",
+    "cors.methods":     "CORS_METHODS",
+    "cors.headers":     "CORS_HEADERS",
+    "cors.credentials": "CORS_CREDENTIALS",
+    "cors.max_age":     "CORS_MAX_AGE",
+
+    "dropbox.token":         "DROPBOX_TOKEN",
+    "dropbox.refresh_token": "DROPBOX_REFRESH_TOKEN",
+    "dropbox.app_key":       "DROPBOX_APP_KEY",
+    "dropbox.app_secret":    "DROPBOX_APP_SECRET",
+    "dropbox.root":          "DROPBOX_ROOT",
+    "dropbox.archive_root":  "ARCHIVE_ROOT",
+    "dropbox.inbox_root":    "INBOX_ROOT",
+
+    "conventions.status_flow":        "STATUS_FLOW",
+    "conventions.status_transitions": "STATUS_TRANSITIONS",
+    "conventions.trash_days":         "TRASH_DAYS",
+    "conventions.inbox_confirm":      "INBOX_CONFIRM",
+    "conventions.write_manifests":    "WRITE_MANIFESTS",
+    "conventions.idea_folders":       "IDEA_FOLDERS",
+    "conventions.idea_pattern":       "IDEA_PATTERN",
+
+    "auth.admin_api_key":        "ADMIN_API_KEY",
+    "auth.default_role":         "DEFAULT_ROLE",
+    "auth.manifest_signing_key": "MANIFEST_SIGNING_KEY",
+    "auth.url_signing_key":      "URL_SIGNING_KEY",
+    "auth.signed_urls":          "SIGNED_URLS",
+    "auth.signed_url_ttl":       "SIGNED_URL_TTL",
+    "auth.oidc.provider":        "OIDC_PROVIDER",
+    "auth.oidc.issuer":          "OIDC_ISSUER",
+    "auth.oidc.client_id":       "OIDC_CLIENT_ID",
+    "auth.oidc.client_secret":   "OIDC_CLIENT_SECRET",
+    "auth.oidc.redirect_url":    "OIDC_REDIRECT_URL",
+    "auth.oidc.allowed_domains": "OIDC_ALLOWED_DOMAINS",
+    "auth.oidc.allowed_users":   "OIDC_ALLOWED_USERS",
+    "auth.oidc.admins":          "OIDC_ADMINS",
+
+    "limits.ip":        "IP_RATE_LIMIT",
+    "limits.api_key":   "API_KEY_RATE_LIMIT",
+    "limits.fetch_ip":  "FETCH_IP_RATE_LIMIT",
+    "limits.fetch_key": "FETCH_KEY_RATE_LIMIT",
+    "limits.analysis_workers":     "ANALYSIS_WORKERS",
+    "limits.analysis_mbps":        "ANALYSIS_MBPS",
+    "limits.analysis_worker_mbps": "ANALYSIS_WORKER_MBPS",
+
+    "schedules.secrets_refresh":     "SECRETS_REFRESH",
+    "schedules.reindex_interval":    "REINDEX_INTERVAL",
+    "schedules.maintenance_windows": "MAINTENANCE_WINDOWS",
+    "schedules.sync_interval":       "SYNC_INTERVAL",
+    "schedules.full_relist_interval": "FULL_RELIST_INTERVAL",
+
+    "vault.addr":       "VAULT_ADDR",
+    "vault.token":      "VAULT_TOKEN",
+    "vault.token_file": "VAULT_TOKEN_FILE",
+    "vault.namespace":  "VAULT_NAMESPACE",
+
+    "access_log.max_mb": "ACCESS_LOG_MAX_MB",
+
+    "slack.webhook_url": "SLACK_WEBHOOK_URL",
+    "slack.bot_token":   "SLACK_BOT_TOKEN",
+    "slack.channel":     "SLACK_CHANNEL",
+    "slack.events":      "SLACK_EVENTS",
+
+    "discord.webhook_url":           "DISCORD_WEBHOOK_URL",
+    "discord.candidate_webhook_url": "DISCORD_WEBHOOK_CANDIDATE",
+    "discord.final_webhook_url":     "DISCORD_WEBHOOK_FINAL",
+    "discord.stems_webhook_url":     "DISCORD_WEBHOOK_STEMS",
+    "discord.mix_webhook_url":       "DISCORD_WEBHOOK_MIX",
+    "discord.deadline_webhook_url":  "DISCORD_WEBHOOK_DEADLINE",
+
+    "telegram.bot_token": "TELEGRAM_BOT_TOKEN",
+    "telegram.chats":     "TELEGRAM_CHATS",
+    "telegram.events":    "TELEGRAM_EVENTS",
+    "telegram.role":      "TELEGRAM_ROLE",
+
+    "soundcloud.client_id":     "SOUNDCLOUD_CLIENT_ID",
+    "soundcloud.client_secret": "SOUNDCLOUD_CLIENT_SECRET",
+    "soundcloud.refresh_token": "SOUNDCLOUD_REFRESH_TOKEN",
+
+    "smtp.host":     "SMTP_HOST",
+    "smtp.port":     "SMTP_PORT",
+    "smtp.username": "SMTP_USERNAME",
+    "smtp.password": "SMTP_PASSWORD",
+    "smtp.from":     "SMTP_FROM",
+
+    "digest.recipients": "DIGEST_RECIPIENTS",
+    "digest.schedule":   "DIGEST_SCHEDULE",
+
+    "sheets.spreadsheet_id":  "SHEETS_SPREADSHEET_ID",
+    "sheets.tab":             "SHEETS_TAB",
+    "sheets.service_account": "GOOGLE_SERVICE_ACCOUNT",
+
+    "airtable.base":  "AIRTABLE_BASE",
+    "airtable.table": "AIRTABLE_TABLE",
+    "airtable.token": "AIRTABLE_TOKEN",
+
+    "notion.token":              "NOTION_TOKEN",
+    "notion.tracks_database":    "NOTION_TRACKS_DATABASE",
+    "notion.releases_database":  "NOTION_RELEASES_DATABASE",
+    "notion.track_properties":   "NOTION_TRACK_PROPERTIES",
+    "notion.release_properties": "NOTION_RELEASE_PROPERTIES",
+
+    "codes.isrc_prefix": "ISRC_PREFIX",
+    "codes.upc_prefix":  "UPC_PREFIX",
+
+    "ddex.sender_dpid":    "DDEX_SENDER_DPID",
+    "ddex.sender_name":    "DDEX_SENDER_NAME",
+    "ddex.recipient_dpid": "DDEX_RECIPIENT_DPID",
+    "ddex.recipient_name": "DDEX_RECIPIENT_NAME",
+
+    "deadlines.reminders":     "DEADLINE_REMINDERS",
+    "deadlines.reminder_hour": "DEADLINE_REMINDER_HOUR",
+
+    "logging.format": "LOG_FORMAT",
+    "logging.level":  "LOG_LEVEL",
+}
+
+// secretSettings are the variables read with readSecret, which also take
+// NAME_FILE and NAME_VAULT.
+var secretSettings = []string{"DROPBOX_TOKEN", "DROPBOX_REFRESH_TOKEN", "DROPBOX_APP_KEY", "DROPBOX_APP_SECRET", "ADMIN_API_KEY", "OIDC_CLIENT_SECRET", "URL_SIGNING_KEY", "SLACK_WEBHOOK_URL", "SLACK_BOT_TOKEN", "DISCORD_WEBHOOK_URL", "DISCORD_WEBHOOK_CANDIDATE", "DISCORD_WEBHOOK_FINAL", "DISCORD_WEBHOOK_STEMS", "DISCORD_WEBHOOK_MIX", "DISCORD_WEBHOOK_DEADLINE", "TELEGRAM_BOT_TOKEN", "SMTP_PASSWORD", "SOUNDCLOUD_CLIENT_ID", "SOUNDCLOUD_CLIENT_SECRET", "SOUNDCLOUD_REFRESH_TOKEN", "GOOGLE_SERVICE_ACCOUNT", "AIRTABLE_TOKEN", "NOTION_TOKEN"}
+
+// flagSettings are on when set to anything; false in the file leaves them off.
+var flagSettings = []string{"TRUST_PROXY", "WRITE_MANIFESTS", "READ_ONLY", "SPILL_HISTORY"}
+
+// configVar is the variable a config file key stands for.
+func configVar(key string) (string, bool) {
+    if v, ok := configEnv[key]; ok { return v, true }
+    for _, suffix := range []string{"_file", "_vault"} {
+        base, ok := strings.CutSuffix(key, suffix)
+        if v := configEnv[base]; ok && slices.Contains(secretSettings, v) {
+            return v + strings.ToUpper(suffix), true
+        }
+    }
+    return "", false
+}
+
+// flattenYAML reads nested mappings into out under dotted keys.
+func flattenYAML(doc, prefix string, out map[string]string) error {
+    for _, b := range splitYAML(doc) {
+        if b.key == "" {
+            for _, l := range b.lines {
+                if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "#") && l != "---" { return fmt.Errorf("expected \"key: value\", not %q", l) }
+            }
+            continue
+        }
+        key := prefix + strings.ToLower(b.key)
+        _, rest, _ := strings.Cut(b.lines[0], ":")
+        if rest = strings.TrimSpace(rest); rest == "" || strings.HasPrefix(rest, "#") {
+            if body := dedent(b.lines[1:]); body != "" && !strings.HasPrefix(body, "-") {
+                if err := flattenYAML(body, key+".", out); err != nil { return err }
+                continue
+            }
+        }
+        v, list, err := b.value()
+        if err != nil { return fmt.Errorf("%s: %w", key, err) }
+        if list != nil { v = strings.Join(list, ",") }
+        out[key] = v
+    }
+    return nil
+}
+
+// dedent strips the common indentation of lines, leaving out comments and
+// blank lines.
+func dedent(lines []string) string {
+    var kept []string
+    indent := -1
+    for _, l := range lines {
+        if t := strings.TrimSpace(l); t == "" || strings.HasPrefix(t, "#") { continue }
+        if n := len(l) - len(strings.TrimLeft(l, " \t")); indent < 0 || n < indent { indent = n }
+        kept = append(kept, l)
+    }
+    for i, l := range kept { kept[i] = l[indent:] }
+    return strings.Join(kept, "\n")
+}
+
+// envSet reports whether the environment sets name, or for a secret any of
+// its variants: DROPBOX_TOKEN in the environment beats a token_file in the file.
+func envSet(name string) bool {
+    base := strings.TrimSuffix(strings.TrimSuffix(name, "_FILE"), "_VAULT")
+    if !slices.Contains(secretSettings, base) { _, ok := os.LookupEnv(name); return ok }
+    for _, v := range []string{base, base + "_FILE", base + "_VAULT"} {
+        if _, ok := os.LookupEnv(v); ok { return true }
+    }
+    return false
+}
+
+// loadConfig applies the config file at path to the environment, leaving
+// variables that are already set alone.
+func loadConfig(path string) error {
+    b, err := os.ReadFile(path)
+    if err != nil { return err }
+    flat := map[string]string{}
+    if err := flattenYAML(string(b), "", flat); err != nil { return fmt.Errorf("%s: %w", path, err) }
+    var unknown []string
+    for key := range flat {
+        if _, ok := configVar(key); !ok { unknown = append(unknown, key) }
+    }
+    if len(unknown) > 0 {
+        sort.Strings(unknown)
+        return fmt.Errorf("%s: unknown settings %s", path, strings.Join(unknown, ", "))
+    }
+    for key, v := range flat {
+        name, _ := configVar(key)
+        if envSet(name) { continue }
+        if slices.Contains(flagSettings, name) {
+            switch strings.ToLower(v) {
+            case "false", "no", "off", "0", "": continue
+            case "true", "yes", "on", "1": v = "1"
+            default: return fmt.Errorf("%s: %s must be true or false", path, key)
+            }
+        }
+        os.Setenv(name, v)
+    }
+    return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "path"
+    "regexp"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Conflicted Copies ======
+//
+// When two people save the same file offline, Dropbox keeps both and renames
+// one "ENERGY-0430A (Kim's conflicted copy 2024-05-01).als". Such names fail
+// the convention; the indexer strips the suffix and files the copy under the
+// artifact it belongs to so someone can decide which version wins.
+
+type Conflict = client.Conflict
+
+var rxConflicted = regexp.MustCompile(`^(.+?) \((?:(.+)'s )?conflicted copy (\d{4}-\d{2}-\d{2})(?: \d+)?\)(\.[A-Za-z0-9]+)?$`)
+
+// splitConflicted returns the name a conflicted copy was made from.
+func splitConflicted(base string) (orig, owner, date string, ok bool) {
+    m := rxConflicted.FindStringSubmatch(base)
+    if m == nil { return "", "", "", false }
+    return m[1] + m[4], m[2], m[3], true
+}
+
+// conflictArtifact is the artifact a conventional file name belongs to.
+func conflictArtifact(np nameParts) ArtifactRef {
+    switch np.Kind {
+    case kindSnapshot, kindBackup: return ArtifactRef{Kind: kindSnapshot, T1: np.T1}
+    case kindStem: return ArtifactRef{Kind: kindStems, T1: np.T1, T2: np.T2}
+    case kindMaster: return ArtifactRef{Kind: kindMaster, T1: np.T1, T2: np.T2, Idx: np.Idx}
+    }
+    return ArtifactRef{Kind: np.Kind, T1: np.T1, T2: np.T2}
+}
+
+// stampedPath inserts a UTC timestamp before p's extension, for archive names
+// that must not collide.
+func stampedPath(p string) string {
+    ext := path.Ext(p)
+    return strings.TrimSuffix(p, ext) + "." + time.Now().UTC().Format("20060102T150405Z") + ext
+}
+
+// GET  /api/tracks/{name}/conflicts
+// POST /api/tracks/{name}/conflicts {"copy":"/Tracks/...","keep":"original|copy|both","rename_to":"ENERGY-0431A.als"}
+// keep=original archives the copy; keep=copy archives the original and puts
+// the copy in its place; keep=both renames the copy to another valid name.
+func (s *Server) handleConflicts(w http.ResponseWriter, r *http.Request, t *Track) {
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, append([]Conflict{}, t.Conflicts...))
+
+    case http.MethodPost:
+        var req struct {
+            Copy     string `json:"copy"`
+            Keep     string `json:"keep"`
+            RenameTo string `json:"rename_to"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+
+        s.writeMu.Lock(); defer s.writeMu.Unlock()
+        s.mu.RLock(); t = s.tracks[t.Name]; s.mu.RUnlock()
+        if t == nil { http.Error(w, "track not found", 404); return }
+        var c *Conflict
+        for i := range t.Conflicts {
+            if strings.EqualFold(t.Conflicts[i].Copy.Path, req.Copy) { c = &t.Conflicts[i] }
+        }
+        if c == nil { http.Error(w, "no conflicted copy at "+req.Copy, 404); return }
+
+        ctx := r.Context()
+        moves := [][2]string{} // from, to
+        switch req.Keep {
+        case "original":
+            moves = append(moves, [2]string{c.Copy.Path, archivePath(t, c.Copy.Path)})
+        case "copy":
+            if c.Original != nil { moves = append(moves, [2]string{c.Original.Path, stampedPath(archivePath(t, c.Original.Path))}) }
+            moves = append(moves, [2]string{c.Copy.Path, c.OriginalPath})
+        case "both":
+            np, ok := classifyName(req.RenameTo)
+            if !ok || strings.Contains(req.RenameTo, "/") { http.Error(w, "rename_to must be a file name that follows the convention", 400); return }
+            if canonicalName(s.aliasMap(), np.Track) != t.Name { http.Error(w, "rename_to must stay in "+t.Name, 400); return }
+            moves = append(moves, [2]string{c.Copy.Path, path.Join(path.Dir(c.Copy.Path), req.RenameTo)})
+        default:
+            http.Error(w, "keep must be original, copy or both", 400); return
+        }
+        if err := s.moveAll(ctx, moves); err != nil { writeError(w, err); return }
+        moved := []map[string]string{}
+        for _, m := range moves { moved = append(moved, map[string]string{"from": m[0], "to": m[1]}) }
+        s.audit(r, "conflict-resolve", t.Name, c, map[string]any{"keep": req.Keep, "moved": moved})
+        if err := s.reindex(ctx); err != nil { http.Error(w, "resolved, but reindex failed: "+err.Error(), 500); return }
+        writeJSON(w, map[string]any{"keep": req.Keep, "moved": moved})
+
+    default:
+        http.Error(w, "GET or POST required", 405)
+    }
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "sort"
+    "strings"
+
+    "avcs-browser/client"
+)
+
+// ====== Contributors ======
+
+// resolveAccounts looks up display names for the modified_by account IDs not
+// seen before. Failures only cost attribution, so they are logged.
+func (s *Server) resolveAccounts(ctx context.Context, entries []dbxEntry) {
+    s.acctMu.Lock(); defer s.acctMu.Unlock()
+    var ids []string
+    seen := map[string]bool{}
+    for _, e := range entries {
+        if e.SharingInfo == nil || e.SharingInfo.ModifiedBy == "" { continue }
+        id := e.SharingInfo.ModifiedBy
+        if _, ok := s.accounts[id]; ok || seen[id] { continue }
+        seen[id] = true
+        ids = append(ids, id)
+    }
+    for len(ids) > 0 {
+        n := min(len(ids), 300) // get_account_batch limit
+        resp, err := s.dbxRPC(ctx, "/2/users/get_account_batch", map[string]any{"account_ids": ids[:n]})
+        if err != nil { slog.WarnContext(ctx, "resolving Dropbox accounts failed", "error", err); return }
+        var accts []struct {
+            AccountID string `json:"account_id"`
+            Name      struct {
+                DisplayName string `json:"display_name"`
+            } `json:"name"`
+        }
+        if err := json.Unmarshal(resp, &accts); err != nil { slog.WarnContext(ctx, "resolving Dropbox accounts failed", "error", err); return }
+        for _, a := range accts { s.accounts[a.AccountID] = a.Name.DisplayName }
+        ids = ids[n:]
+    }
+}
+
+// contributorOf names who added a file: the Dropbox account that last
+// modified it (shared folders only), else an "@name" folder in its path
+// (e.g. /Tracks/ENERGY/stems/@kim/...), else nobody.
+func (s *Server) contributorOf(e *dbxEntry) string {
+    if e.SharingInfo != nil && e.SharingInfo.ModifiedBy != "" {
+        s.acctMu.Lock(); name := s.accounts[e.SharingInfo.ModifiedBy]; s.acctMu.Unlock()
+        if name != "" { return name }
+    }
+    segs := strings.Split(e.PathDisplay, "/")
+    for i := len(segs) - 2; i > 0; i-- {
+        if u := strings.TrimPrefix(segs[i], "@"); u != segs[i] && u != "" { return u }
+    }
+    return ""
+}
+
+type ContributorStats = client.ContributorStats
+
+// GET /api/contributors[?since=2024-06-01][&track=]
+func (s *Server) handleContributors(w http.ResponseWriter, r *http.Request) {
+    since, err := parseSince(r.URL.Query().Get("since"))
+    if err != nil { http.Error(w, "bad since: "+err.Error(), 400); return }
+    only := r.URL.Query().Get("track")
+    stats := map[string]*ContributorStats{}
+    trackSeen := map[string]map[string]bool{}
+    s.mu.RLock()
+    for name, t := range s.tracks {
+        if only != "" && name != only || !s.mayAccess(r, name) { continue }
+        for _, f := range trackFiles(t) {
+            if f.ContributedBy == "" || f.ServerModified.Before(since) { continue }
+            st := stats[f.ContributedBy]
+            if st == nil {
+                st = &ContributorStats{User: f.ContributedBy, Kinds: map[string]int{}, First: f.ServerModified}
+                stats[f.ContributedBy] = st
+                trackSeen[f.ContributedBy] = map[string]bool{}
+            }
+            st.Files++
+            st.Bytes += f.Size
+            st.Kinds[f.Kind]++
+            if !trackSeen[st.User][name] { trackSeen[st.User][name] = true; st.Tracks = append(st.Tracks, name) }
+            if f.ServerModified.Before(st.First) { st.First = f.ServerModified }
+            if f.ServerModified.After(st.Last) { st.Last = f.ServerModified }
+        }
+    }
+    s.mu.RUnlock()
+    out := []*ContributorStats{}
+    for _, st := range stats {
+        sort.Strings(st.Tracks)
+        out = append(out, st)
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Files != out[j].Files { return out[i].Files > out[j].Files }
+        return out[i].User < out[j].User
+    })
+    writeJSON(w, out)
+}
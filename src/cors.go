@@ -0,0 +1,99 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "os"
+    "slices"
+    "strconv"
+    "strings"
+)
+
+// ====== CORS ======
+//
+// A frontend served from another origin, or a desktop app's web view, may
+// call the API once its origin is in CORS_ORIGINS: exact origins
+// ("https://app.example.com"), wildcard subdomains ("https://*.example.com")
+// or "*" for any. CORS_METHODS and CORS_HEADERS list what such requests may
+// use, and CORS_CREDENTIALS=true lets them send cookies, which "*" cannot be
+// combined with. Preflights are answered here, before authentication, and
+// cached by browsers for CORS_MAX_AGE seconds.
+
+const (
+    defaultCORSMethods = "GET, HEAD, POST, PUT, PATCH, DELETE"
+    defaultCORSHeaders = "Authorization, Content-Type, X-API-Key, X-AVCS-User"
+    corsExposed        = "Content-Disposition, ETag, Retry-After"
+)
+
+type corsPolicy struct {
+    origins     []string
+    methods     string
+    headers     string
+    credentials bool
+    maxAge      int
+}
+
+// loadCORS reads the CORS settings; nil if no origin is allowed.
+func loadCORS() (*corsPolicy, error) {
+    c := &corsPolicy{
+        origins: splitList(os.Getenv("CORS_ORIGINS")),
+        methods: strings.ToUpper(os.Getenv("CORS_METHODS")),
+        headers: os.Getenv("CORS_HEADERS"),
+        maxAge:  600,
+    }
+    if len(c.origins) == 0 { return nil, nil }
+    if c.methods == "" { c.methods = defaultCORSMethods }
+    if c.headers == "" { c.headers = defaultCORSHeaders }
+    if v := os.Getenv("CORS_CREDENTIALS"); v != "" {
+        b, err := strconv.ParseBool(v)
+        if err != nil { return nil, errors.New("CORS_CREDENTIALS must be true or false") }
+        c.credentials = b
+    }
+    if c.credentials && slices.Contains(c.origins, "*") { return nil, errors.New(`CORS_CREDENTIALS cannot be used with CORS_ORIGINS="*"; list the origins`) }
+    if v := os.Getenv("CORS_MAX_AGE"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 0 { return nil, errors.New("CORS_MAX_AGE must be a number of seconds") }
+        c.maxAge = n
+    }
+    for _, o := range c.origins {
+        if o != "*" && !strings.HasPrefix(o, "http://") && !strings.HasPrefix(o, "https://") {
+            return nil, errors.New("CORS_ORIGINS are scheme://host[:port], not " + o)
+        }
+    }
+    return c, nil
+}
+
+// allows reports whether origin may call the API. Origins are compared in
+// lower case, as splitList leaves the configured ones.
+func (c *corsPolicy) allows(origin string) bool {
+    origin = strings.ToLower(origin)
+    for _, o := range c.origins {
+        if o == "*" || o == origin { return true }
+        scheme, host, ok := strings.Cut(o, "://*.")
+        if ok && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+host) { return true }
+    }
+    return false
+}
+
+// cors adds CORS headers for allowed origins and answers their preflights.
+func (s *Server) cors(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        c := s.corsPolicy
+        origin := r.Header.Get("Origin")
+        if c == nil || origin == "" { next.ServeHTTP(w, r); return }
+        w.Header().Add("Vary", "Origin")
+        if !c.allows(origin) { next.ServeHTTP(w, r); return }
+        h := w.Header()
+        h.Set("Access-Control-Allow-Origin", origin)
+        if c.credentials { h.Set("Access-Control-Allow-Credentials", "true") }
+        if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+            h.Set("Access-Control-Allow-Methods", c.methods)
+            h.Set("Access-Control-Allow-Headers", c.headers)
+            h.Set("Access-Control-Max-Age", strconv.Itoa(c.maxAge))
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        h.Set("Access-Control-Expose-Headers", corsExposed)
+        next.ServeHTTP(w, r)
+    })
+}
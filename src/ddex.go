@@ -0,0 +1,359 @@
+package main
+
+import (
+    "cmp"
+    "context"
+    "encoding/csv"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// ====== Release Metadata ======
+//
+// GET /api/releases/{id}/metadata is what a distributor asks for about a
+// release, so nobody retypes it into their forms:
+//
+//   ?format=ddex   (the default) a DDEX ERN 4.3 NewReleaseMessage
+//   ?format=csv    one row per track in the columns most distributors'
+//                  bulk-upload sheets share
+//
+// Titles come from the track names (a branch's name is its version), the
+// UPC, label, territories and date from the release, and each track's ISRC,
+// genre and contributors from its track.yaml. A collaborator written as
+// "Name (Role)" gets that role, e.g. "Dana Lee (Mastering Engineer)". Audio
+// files are named as in the delivery package (delivery.go) and their
+// durations read from the FINALs. The message names its sender and
+// recipient from DDEX_SENDER_DPID / DDEX_SENDER_NAME and DDEX_RECIPIENT_DPID /
+// DDEX_RECIPIENT_NAME, or ?recipient_dpid= and ?recipient_name=; ?test=1
+// marks it a TestMessage.
+
+// releaseMeta is a release as distributors see it.
+type releaseMeta struct {
+    Release     *Release
+    Title       string
+    Artist      string
+    Label       string
+    Date        string
+    Genre       string
+    Territories []string
+    Tracks      []trackMeta
+}
+
+// trackMeta is one position of a release.
+type trackMeta struct {
+    Position     int
+    Track        string
+    Title        string
+    Version      string
+    ISRC         string
+    Genre        string
+    Contributors []contributor
+    Duration     time.Duration // zero if unknown
+    File         string
+}
+
+type contributor struct {
+    Name string
+    Role string // as written; "" if none was
+}
+
+// parseContributor reads "Name (Role)".
+func parseContributor(s string) contributor {
+    if open := strings.LastIndex(s, "("); open > 0 && strings.HasSuffix(s, ")") {
+        return contributor{Name: strings.TrimSpace(s[:open]), Role: strings.TrimSpace(s[open+1 : len(s)-1])}
+    }
+    return contributor{Name: s}
+}
+
+// ddexRoles are the DDEX contributor roles for the roles people write.
+var ddexRoles = map[string]string{
+    "producer": "Producer", "co-producer": "CoProducer", "mixer": "Mixer", "mix engineer": "Mixer", "mixing engineer": "Mixer",
+    "mastering": "MasteringEngineer", "mastering engineer": "MasteringEngineer", "engineer": "Engineer", "recording engineer": "RecordingEngineer",
+    "composer": "Composer", "lyricist": "Lyricist", "songwriter": "ComposerLyricist", "writer": "ComposerLyricist", "arranger": "Arranger",
+}
+
+// releaseMetadata gathers what the distributor needs to know about rel.
+func (s *Server) releaseMetadata(ctx context.Context, rel *Release) *releaseMeta {
+    res := s.resolveRelease(rel)
+    out := &releaseMeta{Release: rel, Title: rel.Title, Artist: rel.Artist, Label: rel.Label, Date: rel.ReleaseDate, Territories: rel.Territories}
+    for _, rt := range res.Tracks {
+        tm := trackMeta{Position: rt.Position, Track: rt.Track}
+        tm.Title, tm.Version = titleParts(rt.Track)
+        tm.File = packageFile(rt.Position, releaseTitle(rt.Track))
+        var m *TrackMeta
+        if t := s.lookupTrack(rt.Track); t != nil { m = s.metaOf(t) }
+        if m != nil {
+            tm.ISRC, tm.Genre = m.ISRC, m.Genre
+            for _, c := range m.Collaborators { tm.Contributors = append(tm.Contributors, parseContributor(c)) }
+            if out.Date == "" && rt.Position == 1 { out.Date = m.ReleaseDate }
+        }
+        out.Genre = cmp.Or(out.Genre, tm.Genre)
+        if rt.Final != nil {
+            if d, err := s.wavDuration(ctx, rt.Final.Path); err == nil { tm.Duration = d }
+        }
+        out.Tracks = append(out.Tracks, tm)
+    }
+    return out
+}
+
+// wavDuration reads the length of the WAV at path from its header.
+func (s *Server) wavDuration(ctx context.Context, path string) (time.Duration, error) {
+    body, err := s.dbxDownload(ctx, path)
+    if err != nil { return 0, err }
+    defer body.Close()
+    ws, err := readWAV(body)
+    if err != nil { return 0, err }
+    return time.Duration(float64(ws.DataLen/int64(ws.BlockAlign)) / float64(ws.Rate) * float64(time.Second)), nil
+}
+
+// GET /api/releases/{id}/metadata[?format=ddex|csv][&test=1][&recipient_dpid=&recipient_name=]
+func (s *Server) handleReleaseMetadata(w http.ResponseWriter, r *http.Request, rel *Release) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    q := r.URL.Query()
+    format := cmp.Or(q.Get("format"), "ddex")
+    if format != "ddex" && format != "csv" { http.Error(w, "format must be ddex or csv", 400); return }
+    meta := s.releaseMetadata(r.Context(), rel)
+    name := fileSafe(rel.Title)
+    if rel.Artist != "" { name = fileSafe(rel.Artist) + " - " + name }
+    if format == "csv" {
+        w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+        w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+        writeReleaseCSV(w, meta)
+        return
+    }
+    msg := ddexMessage(meta, ernParty{ID: os.Getenv("DDEX_SENDER_DPID"), Name: cmp.Or(os.Getenv("DDEX_SENDER_NAME"), rel.Label, rel.Artist)},
+        ernParty{ID: cmp.Or(q.Get("recipient_dpid"), os.Getenv("DDEX_RECIPIENT_DPID")), Name: cmp.Or(q.Get("recipient_name"), os.Getenv("DDEX_RECIPIENT_NAME"))},
+        q.Get("test") != "")
+    b, err := xml.MarshalIndent(msg, "", "  ")
+    if err != nil { http.Error(w, err.Error(), 500); return }
+    w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+    w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.xml"`)
+    w.Write([]byte(xml.Header))
+    w.Write(b)
+}
+
+// ====== Distributor CSV ======
+
+var releaseCSVColumns = []string{"Release Title", "Release Type", "UPC", "Label", "Primary Artist", "Release Date", "Genre", "Territories",
+    "Track Number", "Track Title", "Version", "ISRC", "Track Artist", "Contributors", "Duration", "Audio File"}
+
+func writeReleaseCSV(w io.Writer, m *releaseMeta) {
+    cw := csv.NewWriter(w)
+    cw.Write(releaseCSVColumns)
+    territories := strings.Join(m.Territories, " ")
+    if territories == "" { territories = "Worldwide" }
+    kind := map[string]string{"single": "Single", "ep": "EP", "album": "Album"}[m.Release.Type]
+    for _, t := range m.Tracks {
+        var people []string
+        for _, c := range t.Contributors {
+            if c.Role != "" { people = append(people, c.Name+" ("+c.Role+")") } else { people = append(people, c.Name) }
+        }
+        length := ""
+        if t.Duration > 0 { length = clockLength(t.Duration) }
+        cw.Write([]string{m.Title, kind, m.Release.UPC, m.Label, m.Artist, m.Date, cmp.Or(t.Genre, m.Genre), territories,
+            fmt.Sprint(t.Position), t.Title, t.Version, t.ISRC, m.Artist, strings.Join(people, "; "), length, t.File})
+    }
+    cw.Flush()
+}
+
+// ====== DDEX ERN ======
+
+const ernNamespace = "http://ddex.net/xml/ern/43"
+
+type ernMessage struct {
+    XMLName    xml.Name        `xml:"ern:NewReleaseMessage"`
+    Namespace  string          `xml:"xmlns:ern,attr"`
+    Language   string          `xml:"LanguageAndScriptCode,attr"`
+    AVS        string          `xml:"AvsVersionId,attr"`
+    Header     ernHeader       `xml:"MessageHeader"`
+    Parties    []ernPartyEntry `xml:"PartyList>Party"`
+    Recordings []ernRecording  `xml:"ResourceList>SoundRecording"`
+    Release    ernRelease      `xml:"ReleaseList>Release"`
+    Deals      ernReleaseDeal  `xml:"DealList>ReleaseDeal"`
+}
+
+type ernHeader struct {
+    ThreadID  string    `xml:"MessageThreadId"`
+    ID        string    `xml:"MessageId"`
+    Sender    ernParty  `xml:"MessageSender"`
+    Recipient *ernParty `xml:"MessageRecipient,omitempty"`
+    Created   string    `xml:"MessageCreatedDateTime"`
+    Control   string    `xml:"MessageControlType"`
+}
+
+// ernParty is a message sender or recipient: a DDEX party ID and a name.
+type ernParty struct {
+    ID   string `xml:"PartyId,omitempty"`
+    Name string `xml:"PartyName>FullName,omitempty"`
+}
+
+type ernPartyEntry struct {
+    Ref  string `xml:"PartyReference"`
+    Name string `xml:"PartyName>FullName"`
+}
+
+type ernTitle struct {
+    Text     string       `xml:"TitleText"`
+    SubTitle *ernSubTitle `xml:"SubTitle,omitempty"`
+}
+
+type ernSubTitle struct {
+    Type  string `xml:"SubTitleType,attr"`
+    Value string `xml:",chardata"`
+}
+
+type ernArtist struct {
+    Seq  int    `xml:"SequenceNumber,attr"`
+    Ref  string `xml:"ArtistPartyReference"`
+    Role string `xml:"DisplayArtistRole"`
+}
+
+type ernContributor struct {
+    Seq  int     `xml:"SequenceNumber,attr"`
+    Ref  string  `xml:"ContributorPartyReference"`
+    Role ernRole `xml:"Role"`
+}
+
+type ernRole struct {
+    UserDefined string `xml:"UserDefinedValue,attr,omitempty"`
+    Value       string `xml:",chardata"`
+}
+
+type ernPLine struct {
+    Year int    `xml:"Year,omitempty"`
+    Text string `xml:"PLineText"`
+}
+
+type ernCLine struct {
+    Year int    `xml:"Year,omitempty"`
+    Text string `xml:"CLineText"`
+}
+
+type ernRecording struct {
+    Ref          string           `xml:"ResourceReference"`
+    Type         string           `xml:"Type"`
+    ID           *ernResourceID   `xml:"SoundRecordingEdition>ResourceId,omitempty"`
+    PLine        *ernPLine        `xml:"SoundRecordingEdition>PLine,omitempty"`
+    Technical    ernTechnical     `xml:"SoundRecordingEdition>TechnicalDetails"`
+    TitleText    string           `xml:"DisplayTitleText"`
+    Title        ernTitle         `xml:"DisplayTitle"`
+    ArtistName   string           `xml:"DisplayArtistName,omitempty"`
+    Artists      []ernArtist      `xml:"DisplayArtist"`
+    Contributors []ernContributor `xml:"Contributor"`
+    Duration     string           `xml:"Duration,omitempty"`
+    Warning      string           `xml:"ParentalWarningType"`
+}
+
+type ernResourceID struct {
+    ISRC string `xml:"ISRC"`
+}
+
+type ernReleaseID struct {
+    ICPN string `xml:"ICPN"`
+}
+
+type ernTechnical struct {
+    Ref   string `xml:"TechnicalResourceDetailsReference"`
+    Type  string `xml:"DeliveryFile>Type"`
+    Codec string `xml:"DeliveryFile>AudioCodecType"`
+    URI   string `xml:"DeliveryFile>File>URI"`
+}
+
+type ernRelease struct {
+    Ref        string         `xml:"ReleaseReference"`
+    Type       string         `xml:"ReleaseType"`
+    ID         *ernReleaseID  `xml:"ReleaseId,omitempty"`
+    TitleText  string         `xml:"DisplayTitleText"`
+    Title      ernTitle       `xml:"DisplayTitle"`
+    ArtistName string         `xml:"DisplayArtistName,omitempty"`
+    Artists    []ernArtist    `xml:"DisplayArtist"`
+    Label      string         `xml:"ReleaseLabelReference,omitempty"`
+    PLine      *ernPLine      `xml:"PLine,omitempty"`
+    CLine      *ernCLine      `xml:"CLine,omitempty"`
+    Genre      string         `xml:"Genre>GenreText,omitempty"`
+    Date       string         `xml:"OriginalReleaseDate,omitempty"`
+    Warning    string         `xml:"ParentalWarningType"`
+    Items      []ernGroupItem `xml:"ResourceGroup>ResourceGroupContentItem"`
+}
+
+type ernGroupItem struct {
+    Seq int    `xml:"SequenceNumber"`
+    Ref string `xml:"ReleaseResourceReference"`
+}
+
+type ernReleaseDeal struct {
+    Ref   string    `xml:"DealReleaseReference"`
+    Deals []ernDeal `xml:"Deal"`
+}
+
+type ernDeal struct {
+    Territories []string `xml:"DealTerms>TerritoryCode"`
+    Start       string   `xml:"DealTerms>ValidityPeriod>StartDate"`
+    Model       string   `xml:"DealTerms>CommercialModelType"`
+    Uses        []string `xml:"DealTerms>UseType"`
+}
+
+// ddexMessage is m as an ERN 4.3 NewReleaseMessage.
+func ddexMessage(m *releaseMeta, sender, recipient ernParty, test bool) *ernMessage {
+    msg := &ernMessage{Namespace: ernNamespace, Language: "en", AVS: "4"}
+    msg.Header = ernHeader{ThreadID: m.Release.ID, ID: newID(), Sender: sender, Created: time.Now().UTC().Format(time.RFC3339), Control: "LiveMessage"}
+    if recipient != (ernParty{}) { msg.Header.Recipient = &recipient }
+    if test { msg.Header.Control = "TestMessage" }
+
+    parties := map[string]string{} // name -> reference
+    party := func(name string) string {
+        if ref, ok := parties[name]; ok { return ref }
+        ref := fmt.Sprintf("P%d", len(parties)+1)
+        parties[name] = ref
+        msg.Parties = append(msg.Parties, ernPartyEntry{Ref: ref, Name: name})
+        return ref
+    }
+    var artists []ernArtist
+    if m.Artist != "" { artists = []ernArtist{{Seq: 1, Ref: party(m.Artist), Role: "MainArtist"}} }
+    year := 0
+    if d, err := time.Parse("2006-01-02", m.Date); err == nil { year = d.Year() }
+    var pline *ernPLine
+    var cline *ernCLine
+    if owner := cmp.Or(m.Label, m.Artist); owner != "" {
+        if year > 0 { owner = fmt.Sprint(year, " ", owner) }
+        pline, cline = &ernPLine{Year: year, Text: "℗ " + owner}, &ernCLine{Year: year, Text: "© " + owner}
+    }
+
+    rel := ernRelease{Ref: "R0", Type: map[string]string{"single": "Single", "ep": "EP", "album": "Album"}[m.Release.Type],
+        TitleText: m.Title, Title: ernTitle{Text: m.Title}, ArtistName: m.Artist, Artists: artists, PLine: pline, CLine: cline,
+        Genre: m.Genre, Date: m.Date, Warning: "NoAdviceAvailable"}
+    if m.Label != "" { rel.Label = party(m.Label) }
+    if m.Release.UPC != "" { rel.ID = &ernReleaseID{ICPN: m.Release.UPC} }
+    for _, t := range m.Tracks {
+        ref := fmt.Sprintf("A%d", t.Position)
+        rec := ernRecording{Ref: ref, Type: "MusicalWorkSoundRecording", PLine: pline,
+            Technical: ernTechnical{Ref: fmt.Sprintf("T%d", t.Position), Type: "AudioFile", Codec: "PCM", URI: t.File},
+            TitleText: releaseTitle(t.Track), Title: ernTitle{Text: t.Title}, ArtistName: m.Artist, Artists: artists, Warning: "NoAdviceAvailable"}
+        if t.ISRC != "" { rec.ID = &ernResourceID{ISRC: t.ISRC} }
+        if t.Version != "" { rec.Title.SubTitle = &ernSubTitle{Type: "VersionTitle", Value: t.Version} }
+        for i, c := range t.Contributors {
+            role := ernRole{Value: ddexRoles[strings.ToLower(c.Role)]}
+            if role.Value == "" { role = ernRole{Value: "UserDefined", UserDefined: cmp.Or(c.Role, "Contributor")} }
+            rec.Contributors = append(rec.Contributors, ernContributor{Seq: i + 1, Ref: party(c.Name), Role: role})
+        }
+        if t.Duration > 0 { rec.Duration = fmt.Sprintf("PT%dM%dS", int(t.Duration.Minutes()), int(t.Duration.Seconds())%60) }
+        msg.Recordings = append(msg.Recordings, rec)
+        rel.Items = append(rel.Items, ernGroupItem{Seq: t.Position, Ref: ref})
+    }
+    msg.Release = rel
+
+    territories := m.Territories
+    if len(territories) == 0 { territories = []string{"Worldwide"} }
+    start := cmp.Or(m.Date, time.Now().UTC().Format("2006-01-02"))
+    msg.Deals = ernReleaseDeal{Ref: "R0", Deals: []ernDeal{
+        {Territories: territories, Start: start, Model: "PayAsYouGoModel", Uses: []string{"PermanentDownload"}},
+        {Territories: territories, Start: start, Model: "SubscriptionModel", Uses: []string{"OnDemandStream"}},
+        {Territories: territories, Start: start, Model: "AdvertisementSupportedModel", Uses: []string{"OnDemandStream"}},
+    }}
+    return msg
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+    "archive/zip"
+    "bufio"
+    "crypto/md5"
+    "encoding/binary"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "log/slog"
+    "math"
+    "math/rand/v2"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// ====== DDP Images ======
+//
+// GET /api/releases/{id}/ddp is a ready release as a DDP 2.00 fileset, what
+// CD plants take instead of a master disc, zipped in a folder named after
+// the release:
+//
+//   DDPID          the DDP level, the release's UPC/EAN and its title
+//   DDPMS          the map of the two streams below
+//   PQDESCR        the PQ subcode: where each track and its pause start,
+//                  with ISRCs, and the lead-out
+//   IMAGE.DAT      the disc's audio, 16-bit 44.1 kHz stereo, little-endian
+//   PQ.TXT         the PQ sheet, for people
+//   CHECKSUM.MD5   MD5 sums of the above, as md5sum -c reads them
+//
+// Each track is preceded by its release track's "gap" in seconds of silence
+// (2 by default; the first track needs at least 2) and padded to whole CD
+// frames. FINALs have to be 44.1 kHz mono or stereo WAVs; deeper ones are
+// reduced to 16 bits with TPDF dither, 16-bit ones are copied as they are.
+// A release that is not ready, or does not fit the Red Book (tracks of 4
+// seconds or more, 79:57 in all), answers 409 with the problems.
+
+const (
+    cdRate         = 44100
+    cdFrameBytes   = 2352 // a CD frame (sector): 588 stereo 16-bit samples
+    cdFrameSamples = cdFrameBytes / 4
+    cdFPS          = 75 // frames per second
+    cdMaxFrames    = (79*60 + 57) * cdFPS
+    cdMinTrack     = 4 * cdFPS
+    cdDefaultGap   = 2.0
+)
+
+// ddpTrack is a release track laid out on the disc, in CD frames.
+type ddpTrack struct {
+    ResolvedTrack
+    Title  string
+    ISRC   string
+    Gap    int64 // silence before it
+    Start  int64 // index 01
+    Frames int64
+}
+
+// cdTime writes frames as MM:SS:FF.
+func cdTime(frames int64) string {
+    return fmt.Sprintf("%02d:%02d:%02d", frames/cdFPS/60, frames/cdFPS%60, frames%cdFPS)
+}
+
+// ddpField is s left-justified in n characters of printable ASCII.
+func ddpField(s string, n int) string {
+    b := []byte(strings.Map(func(r rune) rune {
+        if r < 0x20 || r > 0x7e { return '_' }
+        return r
+    }, s))
+    if len(b) > n { b = b[:n] }
+    return string(b) + strings.Repeat(" ", n-len(b))
+}
+
+// ean is a UPC-A or EAN-13 as the 13 digits DDP has room for.
+func ean(upc string) string {
+    if len(upc) == 12 { return "0" + upc }
+    return upc
+}
+
+// handleDDP streams the DDP image of rel.
+func (s *Server) handleDDP(w http.ResponseWriter, r *http.Request, rel *Release) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    res := s.resolveRelease(rel)
+    if !res.Ready { writeJSONStatus(w, http.StatusConflict, res); return }
+    tracks := make([]*ddpTrack, len(res.Tracks))
+    var problems []string
+    var pos int64
+    for i, rt := range res.Tracks {
+        t := s.lookupTrack(rt.Track)
+        if t == nil { http.Error(w, "track not found: "+rt.Track, 404); return }
+        if !can(r, permMasters) && !s.released(t) {
+            http.Error(w, "the "+requestKey(r).Role+" role may not "+permWhat[permMasters]+", so not image them either", 403); return
+        }
+        dt := &ddpTrack{ResolvedTrack: rt, Title: releaseTitle(rt.Track)}
+        if m := s.metaOf(t); m != nil { dt.ISRC = m.ISRC }
+        gap := cdDefaultGap
+        if rt.Gap != nil { gap = *rt.Gap }
+        dt.Gap = int64(math.Round(gap * cdFPS))
+        // The headers give the lengths, so the layout is known before any
+        // audio is sent.
+        ws, body, err := s.openCDAudio(r, rt.Final.Path)
+        if err != nil { problems = append(problems, fmt.Sprintf("%d. %s: %v", rt.Position, rt.Track, err)); continue }
+        body.Close()
+        dt.Frames = (ws.DataLen/int64(ws.BlockAlign) + cdFrameSamples - 1) / cdFrameSamples
+        if dt.Frames < cdMinTrack { problems = append(problems, fmt.Sprintf("%d. %s: shorter than 4 seconds", rt.Position, rt.Track)) }
+        dt.Start = pos + dt.Gap
+        pos = dt.Start + dt.Frames
+        tracks[i] = dt
+    }
+    leadOut := pos
+    if len(problems) == 0 && leadOut > cdMaxFrames { problems = append(problems, "the release runs "+cdTime(leadOut)+", longer than a CD's 79:57:00") }
+    if len(problems) > 0 { writeJSONStatus(w, http.StatusConflict, map[string]any{"release": res, "problems": problems}); return }
+
+    name := fileSafe(rel.Title)
+    if rel.Artist != "" { name = fileSafe(rel.Artist) + " - " + name }
+    name += " DDP"
+    s.logAccess(r, AccessEvent{Kind: accessBundle, File: "release " + rel.ID + " DDP"})
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+    zw := zip.NewWriter(w)
+    defer zw.Close()
+    var sums strings.Builder
+    add := func(file string, fn func(io.Writer) error) error {
+        f, err := zw.CreateHeader(&zip.FileHeader{Name: name + "/" + file, Method: zip.Store, Modified: time.Now()})
+        if err != nil { return err }
+        h := md5.New()
+        if err := fn(io.MultiWriter(f, h)); err != nil { return err }
+        fmt.Fprintf(&sums, "%s  %s\n", hex.EncodeToString(h.Sum(nil)), file)
+        return nil
+    }
+    text := func(file, body string) error { return add(file, func(out io.Writer) error { _, err := io.WriteString(out, body); return err }) }
+
+    err := add("IMAGE.DAT", func(out io.Writer) error {
+        bw := bufio.NewWriterSize(out, 1<<20)
+        silence := make([]byte, cdFrameBytes)
+        for _, dt := range tracks {
+            for range dt.Gap {
+                if _, err := bw.Write(silence); err != nil { return err }
+            }
+            ws, body, err := s.openCDAudio(r, dt.Final.Path)
+            if err != nil { return err }
+            n, err := writeCDAudio(bw, ws)
+            body.Close()
+            if err != nil { return fmt.Errorf("%s: %w", dt.Final.Path, err) }
+            if n != dt.Frames { return fmt.Errorf("%s changed while the image was made", dt.Final.Path) }
+        }
+        return bw.Flush()
+    })
+    if err != nil { slog.ErrorContext(r.Context(), "DDP image failed", "release", rel.ID, "error", err); return }
+
+    upc := ean(rel.UPC)
+    var pq strings.Builder
+    // 64-byte entries: track, index, HHMMSSFF, both control bytes (audio,
+    // no pre-emphasis, no copying), ISRC, UPC/EAN and 19 reserved
+    entry := func(track string, index int, at int64, isrc, upc string) {
+        fmt.Fprintf(&pq, "VVVS%s%02d%02d%s0000%s%s%s", track, index, at/cdFPS/3600, strings.ReplaceAll(cdTime(at%(3600*cdFPS)), ":", ""),
+            ddpField(isrc, 12), ddpField(upc, 13), ddpField("", 19))
+    }
+    var sheet strings.Builder
+    fmt.Fprintf(&sheet, "PQ sheet: %s\n", rel.Title)
+    if rel.Artist != "" { fmt.Fprintf(&sheet, "%s\n", rel.Artist) }
+    if upc != "" { fmt.Fprintf(&sheet, "UPC/EAN %s\n", upc) }
+    fmt.Fprintf(&sheet, "\n %-2s  %-32s  %-12s  %-8s  %-8s  %s\n", "#", "Title", "ISRC", "Pause", "Start", "Length")
+    for i, dt := range tracks {
+        no, code := fmt.Sprintf("%02d", i+1), ""
+        if i == 0 { code = upc } // the disc's, given once
+        if dt.Gap > 0 { entry(no, 0, dt.Start-dt.Gap, dt.ISRC, code); code = "" }
+        entry(no, 1, dt.Start, dt.ISRC, code)
+        fmt.Fprintf(&sheet, "%2d.  %-32s  %-12s  %-8s  %-8s  %s\n", i+1, truncate(dt.Title, 32), dt.ISRC, cdTime(dt.Gap), cdTime(dt.Start), cdTime(dt.Frames))
+    }
+    entry("AA", 1, leadOut, "", "")
+    fmt.Fprintf(&sheet, "\nLead-out %s\n", cdTime(leadOut))
+
+    var ms strings.Builder
+    stream := func(mpv, dst, dsp, dsl, dss, sub, cdm, dsi string) {
+        ms.WriteString(mpv + dst + ddpField(dsp, 8) + ddpField(dsl, 8) + ddpField(dss, 8) + ddpField(sub, 8) + ddpField(cdm, 2) + "00" +
+            ddpField("", 4+4+4+1+2+2+12+3) + ddpField(dsi, 17) + ddpField("", 1+4+8+9+15))
+    }
+    stream("VVVM", "D0", "00000000", fmt.Sprintf("%08d", leadOut), "00000000", "", "DA", "IMAGE.DAT")
+    stream("VVVS", "S0", "", fmt.Sprintf("%08d", pq.Len()), "", "PQ DESCR", "", "PQDESCR")
+    id := "DDP 2.00" + ddpField(upc, 13) + ddpField("", 8+8+1) + ddpField(strings.ToUpper(rel.Title), 48) + ddpField("", 1)
+    id += ddpField("", 128-len(id))
+
+    for _, f := range [][2]string{{"DDPID", id}, {"DDPMS", ms.String()}, {"PQDESCR", pq.String()}, {"PQ.TXT", sheet.String()}} {
+        if err := text(f[0], f[1]); err != nil { return }
+    }
+    if f, err := zw.CreateHeader(&zip.FileHeader{Name: name + "/CHECKSUM.MD5", Method: zip.Store, Modified: time.Now()}); err == nil { io.WriteString(f, sums.String()) }
+}
+
+// openCDAudio opens the WAV at path and checks it can go on a CD.
+func (s *Server) openCDAudio(r *http.Request, path string) (*wavStream, io.ReadCloser, error) {
+    body, err := s.dbxDownload(r.Context(), path)
+    if err != nil { return nil, nil, err }
+    ws, err := readWAV(body)
+    switch {
+    case err != nil:
+    case ws.Rate != cdRate: err = fmt.Errorf("%d Hz; a CD needs 44100", ws.Rate)
+    case ws.Channels > 2: err = fmt.Errorf("%d channels; a CD has 2", ws.Channels)
+    }
+    if err != nil { body.Close(); return nil, nil, err }
+    return ws, body, nil
+}
+
+// writeCDAudio writes the samples of ws as 16-bit stereo, padded to whole CD
+// frames, and says how many frames that made.
+func writeCDAudio(w io.Writer, ws *wavStream) (int64, error) {
+    size := ws.Bits / 8
+    plain := ws.Format == wavPCM && ws.Bits == 16
+    out := make([]byte, 0, 4*4096)
+    put := func(v float64) {
+        n := v * (1 << 15)
+        if !plain { n += rand.Float64() - rand.Float64() } // TPDF, one LSB
+        n = max(min(math.Round(n), 1<<15-1), -(1 << 15))
+        out = binary.LittleEndian.AppendUint16(out, uint16(int16(n)))
+    }
+    var samples int64
+    err := ws.frames(func(buf []byte) error {
+        out = out[:0]
+        for off := 0; off+ws.BlockAlign <= len(buf); off += ws.BlockAlign {
+            l := ws.sample(buf[off:])
+            rr := l
+            if ws.Channels == 2 { rr = ws.sample(buf[off+size:]) }
+            put(l); put(rr)
+            samples++
+        }
+        _, err := w.Write(out)
+        return err
+    })
+    if err != nil { return 0, err }
+    frames := (samples + cdFrameSamples - 1) / cdFrameSamples
+    if pad := frames*cdFrameSamples - samples; pad > 0 {
+        if _, err := w.Write(make([]byte, pad*4)); err != nil { return 0, err }
+    }
+    return frames, nil
+}
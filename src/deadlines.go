@@ -0,0 +1,348 @@
+package main
+
+import (
+    "cmp"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "math"
+    "net/http"
+    "os"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Deadlines ======
+//
+// A track or a release can be given deadlines: when its stems are due, when
+// its master is due and its release date. A deadline is met once every
+// track it covers has a stems set (stems) or a FINAL (master and release),
+// or when it is marked done; until then the leader reminds the configured
+// channels (Slack, Discord, Telegram, webhooks as "deadline", and email to
+// DIGEST_RECIPIENTS) at DEADLINE_REMINDER_HOUR (9, local time) on the days
+// in DEADLINE_REMINDERS ("7,1,0": a week before, the day before and on the
+// day), and once a week while it is overdue.
+//
+//   GET    /api/deadlines?track=&release=&all=1   open ones (all=1: met too), soonest first
+//   POST   /api/deadlines {"kind":"master","track":"NEON_RAIN","due":"2025-03-01"[,"note":"..."]}
+//   GET    /api/deadlines/overdue?days=7          the overdue report, and what is due within days
+//   GET    /api/deadlines/{id}
+//   PATCH  /api/deadlines/{id} {"due":"...","note":"...","done":true}
+//   DELETE /api/deadlines/{id}
+//
+// A release's deadline covers every track on it. Without a due date a
+// release deadline takes the release's release_date, or the track's from
+// track.yaml. Changing the due date starts its reminders over.
+
+const (
+    deadlineStems   = "stems"
+    deadlineMaster  = "master"
+    deadlineRelease = "release"
+)
+
+var deadlineKinds = []string{deadlineStems, deadlineMaster, deadlineRelease}
+
+type (
+    Deadline      = client.Deadline
+    deadlineState = client.DeadlineState
+)
+
+// reminderPlan is when deadline reminders go out.
+type reminderPlan struct {
+    days []int // before the due date, ascending
+    hour int   // local
+}
+
+// loadReminderPlan reads DEADLINE_REMINDERS and DEADLINE_REMINDER_HOUR.
+func loadReminderPlan() (reminderPlan, error) {
+    var p reminderPlan
+    for _, d := range strings.Split(cmp.Or(os.Getenv("DEADLINE_REMINDERS"), "7,1,0"), ",") {
+        n, err := strconv.Atoi(strings.TrimSpace(d))
+        if err != nil || n < 0 || n > 365 { return p, fmt.Errorf("DEADLINE_REMINDERS: %q is not a number of days", d) }
+        if !slices.Contains(p.days, n) { p.days = append(p.days, n) }
+    }
+    sort.Ints(p.days)
+    h, err := strconv.Atoi(cmp.Or(os.Getenv("DEADLINE_REMINDER_HOUR"), "9"))
+    if err != nil || h < 0 || h > 23 { return p, fmt.Errorf("DEADLINE_REMINDER_HOUR must be 0 to 23") }
+    p.hour = h
+    return p, nil
+}
+
+// daysUntil counts the days from today to due, both local dates.
+func daysUntil(due string, now time.Time) int {
+    d, _ := time.ParseInLocation("2006-01-02", due, time.Local)
+    y, m, day := now.Date()
+    today := time.Date(y, m, day, 0, 0, 0, 0, time.Local)
+    return int(math.Round(d.Sub(today).Hours() / 24)) // a DST day is 23 or 25 hours
+}
+
+// deadlineState works out where dl stands.
+func (s *Server) deadlineState(dl *Deadline, now time.Time) deadlineState {
+    st := deadlineState{Deadline: *dl, Days: daysUntil(dl.Due, now)}
+    tracks := []string{dl.Track}
+    subject := dl.Track
+    if dl.Release != "" {
+        tracks, subject = nil, "release "+dl.Release
+        s.store.view(func(d *storeData) {
+            if rel := d.Releases[dl.Release]; rel != nil {
+                subject = rel.Title
+                for _, rt := range rel.Tracks { tracks = append(tracks, rt.Track) }
+            }
+        })
+    }
+    switch dl.Kind {
+    case deadlineStems: st.Title = "Stems for " + subject
+    case deadlineMaster: st.Title = "Master of " + subject
+    default: st.Title = "Release of " + subject
+    }
+    for _, name := range tracks {
+        t := s.lookupTrack(name)
+        has := false
+        if t != nil && dl.Kind == deadlineStems {
+            has = slices.ContainsFunc(t.Stems, func(set StemsSet) bool { return len(set.Stems) > 0 && !set.Stems[0].Archived })
+        } else if t != nil {
+            has = slices.ContainsFunc(t.Masters, func(m MasterSet) bool { return m.Final != nil && !m.Final.Archived })
+        }
+        if !has { st.Missing = append(st.Missing, name) }
+    }
+    st.Met = dl.Done != nil || len(st.Missing) == 0 && len(tracks) > 0
+    st.Overdue = !st.Met && st.Days < 0
+    return st
+}
+
+// deadlineReminder is the text of a reminder about st.
+func deadlineReminder(st *deadlineState) string {
+    when := ""
+    switch {
+    case st.Days > 1: when = fmt.Sprintf("is due in %d days", st.Days)
+    case st.Days == 1: when = "is due tomorrow"
+    case st.Days == 0: when = "is due today"
+    case st.Days == -1: when = "was due yesterday"
+    default: when = fmt.Sprintf("is %d days overdue", -st.Days)
+    }
+    what := map[string]string{deadlineStems: "no stems yet", deadlineMaster: "no FINAL yet", deadlineRelease: "no FINAL yet"}[st.Kind]
+    out := fmt.Sprintf("%s %s (%s); %s: %s", st.Title, when, st.Due, what, strings.Join(st.Missing, ", "))
+    if st.Note != "" { out += " — " + st.Note }
+    return out
+}
+
+// deadlines lists the deadlines as they stand, soonest first.
+func (s *Server) deadlines(keep func(*Deadline) bool) []deadlineState {
+    var list []*Deadline
+    s.store.view(func(d *storeData) {
+        for _, dl := range d.Deadlines {
+            if keep(dl) { c := *dl; list = append(list, &c) }
+        }
+    })
+    now := time.Now()
+    out := []deadlineState{}
+    for _, dl := range list { out = append(out, s.deadlineState(dl, now)) }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Due != out[j].Due { return out[i].Due < out[j].Due }
+        return out[i].Title < out[j].Title
+    })
+    return out
+}
+
+// mayAccessDeadline reports whether r may see dl: its track, or every track
+// of its release.
+func (s *Server) mayAccessDeadline(r *http.Request, dl *Deadline) bool {
+    if dl.Track != "" && !s.mayAccess(r, dl.Track) { return false }
+    var rel *Release
+    s.store.view(func(d *storeData) {
+        if x := d.Releases[dl.Release]; x != nil { c := *x; rel = &c }
+    })
+    return rel == nil || s.mayAccessRelease(r, rel)
+}
+
+// remindDeadlines sends the reminders due now, once each across replicas.
+func (s *Server) remindDeadlines(ctx context.Context) {
+    // the stage of each deadline's reminders it has reached
+    stages := map[string]string{}
+    states := map[string]deadlineState{}
+    for _, st := range s.deadlines(func(*Deadline) bool { return true }) {
+        switch {
+        case st.Met:
+        case st.Days < 0:
+            stages[st.ID] = fmt.Sprintf("overdue-%d", (-st.Days-1)/7) // the day after, then weekly
+        default:
+            if i := slices.IndexFunc(s.reminders.days, func(n int) bool { return st.Days <= n }); i >= 0 { stages[st.ID] = strconv.Itoa(s.reminders.days[i]) }
+        }
+        states[st.ID] = st
+    }
+    var due []deadlineState
+    err := s.store.update(func(d *storeData) error {
+        for id, stage := range stages {
+            dl := d.Deadlines[id]
+            if dl == nil || dl.Due != states[id].Due || slices.Contains(dl.Reminded, stage) { continue }
+            c := *dl
+            c.Reminded = append(slices.Clone(dl.Reminded), stage)
+            d.Deadlines[id] = &c
+            due = append(due, states[id])
+        }
+        return nil
+    })
+    if err != nil { slog.ErrorContext(ctx, "recording deadline reminders failed", "error", err); return }
+    for _, st := range due {
+        e := catalogEvent{key: "deadline:" + st.ID, kind: eventDeadline, track: st.Track, deadline: &st}
+        for _, n := range s.notifiers {
+            if !n.wants(eventDeadline) { continue }
+            if err := n.notify(ctx, e); err != nil {
+                slog.ErrorContext(ctx, "deadline reminder failed", "to", n.name(), "deadline", st.ID, "error", err)
+                continue
+            }
+            slog.InfoContext(ctx, "deadline reminder sent", "to", n.name(), "deadline", st.ID, "days", st.Days)
+        }
+    }
+}
+
+// watchDeadlines sends reminders from the leader, from the reminder hour on.
+func (s *Server) watchDeadlines() {
+    for range time.Tick(10 * time.Minute) {
+        if time.Now().Hour() < s.reminders.hour || !s.leading() || s.readOnly || !slices.ContainsFunc(s.notifiers, func(n notifier) bool { return n.wants(eventDeadline) }) { continue }
+        go runJob(context.Background(), "deadlines", s.remindDeadlines)
+    }
+}
+
+// mailNotifier emails deadline reminders to the digest's recipients.
+type mailNotifier struct{ d *digester }
+
+func (n mailNotifier) name() string { return "email" }
+
+func (n mailNotifier) wants(kind string) bool { return kind == eventDeadline }
+
+func (n mailNotifier) notify(ctx context.Context, e catalogEvent) error {
+    subject := e.deadline.Title + " is due " + e.deadline.Due
+    if e.deadline.Overdue { subject = e.deadline.Title + " is overdue" }
+    return n.d.mail.send(ctx, n.d.to, subject, e.title()+"\n")
+}
+
+type deadlineInput = client.DeadlineChange
+
+func (s *Server) applyDeadline(dl *Deadline, in deadlineInput) error {
+    if in.Kind != nil { dl.Kind = strings.ToLower(strings.TrimSpace(*in.Kind)) }
+    if in.Track != nil {
+        dl.Track = strings.TrimSpace(*in.Track)
+        if t := s.lookupTrack(dl.Track); t != nil { dl.Track = t.Name } else if dl.Track != "" { return httpError{404, "track not found: " + dl.Track} }
+    }
+    if in.Release != nil { dl.Release = strings.TrimSpace(*in.Release) }
+    if in.Note != nil { dl.Note = strings.TrimSpace(*in.Note) }
+    if in.Done != nil {
+        now := time.Now().UTC()
+        if dl.Done = nil; *in.Done { dl.Done = &now }
+    }
+    if in.Due != nil && strings.TrimSpace(*in.Due) != dl.Due { dl.Due, dl.Reminded = strings.TrimSpace(*in.Due), nil }
+
+    if !slices.Contains(deadlineKinds, dl.Kind) { return httpError{400, "kind must be one of " + strings.Join(deadlineKinds, ", ")} }
+    if (dl.Track == "") == (dl.Release == "") { return httpError{400, "give a track or a release"} }
+    var rel *Release
+    if dl.Release != "" {
+        s.store.view(func(d *storeData) { rel = d.Releases[dl.Release] })
+        if rel == nil { return httpError{404, "release not found: " + dl.Release} }
+    }
+    if dl.Due == "" && dl.Kind == deadlineRelease {
+        if rel != nil {
+            dl.Due = rel.ReleaseDate
+        } else if t := s.lookupTrack(dl.Track); t != nil {
+            if m := s.metaOf(t); m != nil { dl.Due = m.ReleaseDate }
+        }
+    }
+    if _, err := time.Parse("2006-01-02", dl.Due); err != nil { return httpError{400, "due must be YYYY-MM-DD"} }
+    return nil
+}
+
+// handleDeadlines serves /api/deadlines and /api/deadlines/{id}.
+func (s *Server) handleDeadlines(w http.ResponseWriter, r *http.Request) {
+    id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/deadlines"), "/")
+    q := r.URL.Query()
+    switch {
+    case id == "" && r.Method == http.MethodGet:
+        track, release, all := q.Get("track"), q.Get("release"), q.Get("all") != ""
+        if t := s.lookupTrack(track); t != nil { track = t.Name }
+        list := s.deadlines(func(dl *Deadline) bool {
+            return (track == "" || dl.Track == track) && (release == "" || dl.Release == release)
+        })
+        out := []deadlineState{}
+        for _, st := range list {
+            if (all || !st.Met) && s.mayAccessDeadline(r, &st.Deadline) { out = append(out, st) }
+        }
+        writeJSON(w, out)
+
+    case id == "" && r.Method == http.MethodPost:
+        var in deadlineInput
+        if err := json.NewDecoder(r.Body).Decode(&in); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        dl := &Deadline{ID: newID(), By: actorOf(r), Created: time.Now().UTC()}
+        if err := s.applyDeadline(dl, in); err != nil { writeError(w, err); return }
+        if !s.mayAccessDeadline(r, dl) { http.Error(w, "track not found", 404); return }
+        err := s.store.update(func(d *storeData) error {
+            if d.Deadlines == nil { d.Deadlines = map[string]*Deadline{} }
+            d.Deadlines[dl.ID] = dl
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "deadline-create", dl.Track, nil, dl)
+        writeJSONStatus(w, http.StatusCreated, s.deadlineState(dl, time.Now()))
+
+    case id == "":
+        http.Error(w, "GET or POST required", 405)
+
+    case id == "overdue":
+        if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+        within := 7
+        if v := q.Get("days"); v != "" {
+            n, err := strconv.Atoi(v)
+            if err != nil || n < 0 { http.Error(w, "days must be a number of days", 400); return }
+            within = n
+        }
+        overdue, soon := []deadlineState{}, []deadlineState{}
+        for _, st := range s.deadlines(func(*Deadline) bool { return true }) {
+            switch {
+            case st.Met, !s.mayAccessDeadline(r, &st.Deadline):
+            case st.Overdue: overdue = append(overdue, st)
+            case st.Days <= within: soon = append(soon, st)
+            }
+        }
+        writeJSON(w, map[string]any{"overdue": overdue, "due_soon": soon, "days": within})
+
+    default:
+        var cur *Deadline
+        s.store.view(func(d *storeData) {
+            if dl := d.Deadlines[id]; dl != nil { c := *dl; cur = &c }
+        })
+        if cur == nil || !s.mayAccessDeadline(r, cur) { http.Error(w, "deadline not found", 404); return }
+        switch r.Method {
+        case http.MethodGet:
+            writeJSON(w, s.deadlineState(cur, time.Now()))
+        case http.MethodPatch, http.MethodPut:
+            var in deadlineInput
+            if err := json.NewDecoder(r.Body).Decode(&in); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+            next := *cur
+            if err := s.applyDeadline(&next, in); err != nil { writeError(w, err); return }
+            if !s.mayAccessDeadline(r, &next) { http.Error(w, "track not found", 404); return }
+            err := s.store.update(func(d *storeData) error {
+                if d.Deadlines[id] == nil { return httpError{404, "deadline not found"} }
+                d.Deadlines[id] = &next
+                return nil
+            })
+            if err != nil { writeError(w, err); return }
+            s.audit(r, "deadline-update", next.Track, cur, next)
+            writeJSON(w, s.deadlineState(&next, time.Now()))
+        case http.MethodDelete:
+            err := s.store.update(func(d *storeData) error {
+                delete(d.Deadlines, id)
+                return nil
+            })
+            if err != nil { writeError(w, err); return }
+            s.audit(r, "deadline-delete", cur.Track, cur, nil)
+            w.WriteHeader(http.StatusNoContent)
+        default:
+            http.Error(w, "GET, PATCH or DELETE required", 405)
+        }
+    }
+}
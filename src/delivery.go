@@ -0,0 +1,147 @@
+package main
+
+import (
+    "archive/zip"
+    "cmp"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "path"
+    "strings"
+    "time"
+)
+
+// ====== Delivery Packages ======
+//
+// GET /api/releases/{id}/package is a release made ready to hand to Bandcamp
+// or a distributor's upload portal, as one zip named after it:
+//
+//   01 - Neon Rain.wav   each FINAL, numbered in release order and titled
+//                        after its track, tagged (see codes.go) with the
+//                        title, artist, release, genre, date, position and ISRC
+//   cover.jpg            the release's artwork, if it has any
+//   tracklist.txt        the release, its tracks, their lengths and ISRCs
+//   SHA256SUMS           checksums of the above, as sha256sum -c reads them
+//
+// The release has to be ready (a FINAL at every position); otherwise the
+// answer is 409 with the release, showing what is missing. The WAV audio is
+// not touched: only tags are added, and tags the files already had are
+// blanked out.
+
+// releaseTitle turns a track name into a title: NEON_RAIN.RADIO_EDIT reads
+// "Neon Rain (Radio Edit)".
+func releaseTitle(track string) string {
+    title, version := titleParts(track)
+    if version == "" { return title }
+    return title + " (" + version + ")"
+}
+
+// titleParts is a track's title and, for a branch, its version: "Neon Rain"
+// and "Radio Edit".
+func titleParts(track string) (string, string) {
+    words := func(s string) string {
+        f := strings.Fields(strings.ReplaceAll(s, "_", " "))
+        for i, w := range f { f[i] = strings.ToUpper(w[:1]) + strings.ToLower(w[1:]) }
+        return strings.Join(f, " ")
+    }
+    base, branch, _ := strings.Cut(track, ".")
+    return words(base), words(branch)
+}
+
+// packageFile is the name a release position's WAV has in the package.
+func packageFile(position int, title string) string {
+    return fmt.Sprintf("%02d - %s.wav", position, fileSafe(title))
+}
+
+// fileSafe drops the characters file systems and portals refuse.
+func fileSafe(s string) string {
+    return strings.TrimSpace(strings.Map(func(r rune) rune {
+        if strings.ContainsRune(`/\:*?"<>|`, r) || r < 0x20 { return -1 }
+        return r
+    }, s))
+}
+
+// handlePackage streams the delivery package of rel.
+func (s *Server) handlePackage(w http.ResponseWriter, r *http.Request, rel *Release) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    res := s.resolveRelease(rel)
+    if !res.Ready { writeJSONStatus(w, http.StatusConflict, res); return }
+    tracks := make([]*Track, len(res.Tracks))
+    for i, rt := range res.Tracks {
+        if tracks[i] = s.lookupTrack(rt.Track); tracks[i] == nil { http.Error(w, "track not found: "+rt.Track, 404); return }
+        if !can(r, permMasters) && !s.released(tracks[i]) {
+            http.Error(w, "the "+requestKey(r).Role+" role may not "+permWhat[permMasters]+", so not package them either", 403); return
+        }
+    }
+
+    name := fileSafe(rel.Title)
+    if rel.Artist != "" { name = fileSafe(rel.Artist) + " - " + name }
+    s.logAccess(r, AccessEvent{Kind: accessBundle, File: "release " + rel.ID})
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+    zw := zip.NewWriter(w)
+    defer zw.Close()
+    root := name + "/"
+    var sums strings.Builder
+    add := func(file string, fn func(io.Writer) error) error {
+        f, err := zw.CreateHeader(&zip.FileHeader{Name: root + file, Method: zip.Store, Modified: time.Now()})
+        if err != nil { return err }
+        h := sha256.New()
+        if err := fn(io.MultiWriter(f, h)); err != nil { return err }
+        fmt.Fprintf(&sums, "%s  %s\n", hex.EncodeToString(h.Sum(nil)), file)
+        return nil
+    }
+
+    var list strings.Builder
+    fmt.Fprintf(&list, "%s\n", rel.Title)
+    if rel.Artist != "" { fmt.Fprintf(&list, "%s\n", rel.Artist) }
+    list.WriteString(map[string]string{"single": "Single", "ep": "EP", "album": "Album"}[rel.Type])
+    if rel.ReleaseDate != "" { fmt.Fprintf(&list, ", released %s", rel.ReleaseDate) }
+    list.WriteString("\n\n")
+    var total time.Duration
+    for i, rt := range res.Tracks {
+        t, title := tracks[i], releaseTitle(rt.Track)
+        meta := s.metaOf(t)
+        if meta == nil { meta = &TrackMeta{} }
+        tags := audioTags{Title: title, Artist: rel.Artist, Album: rel.Title, Label: rel.Label, Genre: meta.Genre,
+            Date: cmp.Or(meta.ReleaseDate, rel.ReleaseDate), Key: meta.Key, ISRC: meta.ISRC, Position: rt.Position, BPM: meta.BPM}.wav()
+        file := packageFile(rt.Position, title)
+        var length time.Duration
+        err := add(file, func(out io.Writer) error {
+            body, err := s.dbxDownload(r.Context(), rt.Final.Path)
+            if err != nil { return err }
+            defer body.Close()
+            length, err = withTags(out, body, tags)
+            return err
+        })
+        if err != nil { slog.ErrorContext(r.Context(), "release package failed", "release", rel.ID, "path", rt.Final.Path, "error", err); return }
+        total += length
+        fmt.Fprintf(&list, "%2d. %s  %s", rt.Position, title, clockLength(length))
+        if meta.ISRC != "" { fmt.Fprintf(&list, "  ISRC %s", meta.ISRC) }
+        list.WriteString("\n")
+    }
+    fmt.Fprintf(&list, "\nTotal %s\n", clockLength(total))
+
+    if res.Artwork != "" {
+        err := add("cover"+strings.ToLower(path.Ext(res.Artwork)), func(out io.Writer) error {
+            body, err := s.dbxDownload(r.Context(), res.Artwork)
+            if err != nil { return err }
+            defer body.Close()
+            _, err = io.Copy(out, body)
+            return err
+        })
+        if err != nil { slog.ErrorContext(r.Context(), "release package failed", "release", rel.ID, "path", res.Artwork, "error", err); return }
+    }
+    if err := add("tracklist.txt", func(out io.Writer) error { _, err := io.WriteString(out, list.String()); return err }); err != nil { return }
+    if f, err := zw.Create(root + "SHA256SUMS"); err == nil { io.WriteString(f, sums.String()) }
+}
+
+// clockLength writes d as m:ss, or h:mm:ss.
+func clockLength(d time.Duration) string {
+    sec := int(d.Round(time.Second).Seconds())
+    if sec >= 3600 { return fmt.Sprintf("%d:%02d:%02d", sec/3600, sec/60%60, sec%60) }
+    return fmt.Sprintf("%d:%02d", sec/60, sec%60)
+}
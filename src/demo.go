@@ -0,0 +1,419 @@
+package main
+
+import (
+    "bytes"
+    "cmp"
+    "compress/gzip"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math"
+    "net"
+    "net/http"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ====== Demo Mode ======
+//
+// -demo serves a sample catalog without a Dropbox account, for trying the
+// UI and API and for end-to-end tests. The server talks to an emulation of
+// the Dropbox endpoints it uses, on a loopback port, over files generated
+// at startup: a few tracks with Live sets (tempo and locators), stems, mixes
+// and master candidates as short test tones, a branch, an archived track
+// and an inbox file. Everything, uploads and renames included, works on
+// that copy in memory and is gone when the server stops; state goes to a
+// temporary DATA_DIR unless one is set. Temporary links point to
+// /demo/files/ on the server itself, a file request's upload page is
+// /demo/requests/{id}, taking a POST of the file (?name=), and upload links
+// are /demo/upload/{id}.
+
+const demoAccount = "dbid:demo"
+
+// demoFile is a file or folder in the emulated Dropbox.
+type demoFile struct {
+    display  string // path as shown
+    folder   bool
+    data     []byte
+    modified time.Time
+    by       string // account that last changed it
+}
+
+// demoDropbox emulates the Dropbox API endpoints the server calls.
+type demoDropbox struct {
+    mu       sync.Mutex
+    files    map[string]*demoFile // key: lower-case path
+    changed  []string             // lower-case paths as changed, for cursors: root@len
+    sessions map[string][]byte    // upload sessions, by ID
+    requests map[string]map[string]any // file requests, by ID
+    links    map[string]map[string]any // upload links' commit_info, by ID
+}
+
+// startDemo fills the emulation with the sample catalog and serves it on a
+// loopback port, which the Dropbox calls then go to.
+func startDemo() (*demoDropbox, error) {
+    d := &demoDropbox{files: map[string]*demoFile{}, sessions: map[string][]byte{}, requests: map[string]map[string]any{}, links: map[string]map[string]any{}}
+    d.seed()
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { return nil, err }
+    go http.Serve(ln, d)
+    dbxAPIHost, dbxContentHost = "http://"+ln.Addr().String(), "http://"+ln.Addr().String()
+    return d, nil
+}
+
+// demoAuth stands in for Dropbox credentials.
+func demoAuth() *dropboxAuth { return &dropboxAuth{creds: dropboxCreds{token: "demo"}} }
+
+// seed creates the sample catalog, newest files last.
+func (d *demoDropbox) seed() {
+    start := time.Now().Add(-21 * 24 * time.Hour).UTC().Truncate(time.Second)
+    n := 0
+    add := func(p string, data []byte, by string) {
+        n++
+        d.put(p, data, start.Add(time.Duration(n)*6*time.Hour), by)
+    }
+    tone := func(hz float64) []byte { return demoTone(hz, 2*time.Second) }
+    add("/Tracks/NEON_RAIN/ideas/2026-09-20 22.41.07.m4a", tone(200), demoAccount)
+    add("/Tracks/NEON_RAIN/track.yaml", []byte("bpm: 124\nkey: A minor\ngenre: Synthwave\ncollaborators: [Kim, Lee]\nnotes: |\n  Sample track of the demo catalog.\n"), demoAccount)
+    add("/Tracks/NEON_RAIN/ableton/NEON_RAIN-0915P.als", demoLiveSet(124, "Intro", "Verse", "Drop"), demoAccount)
+    add("/Tracks/NEON_RAIN/ableton/NEON_RAIN-0915P.wav", tone(220), demoAccount)
+    add("/Tracks/NEON_RAIN/lyrics/NEON_RAIN-0915P-LYRICS.txt", []byte("Neon rain on the boulevard\nEvery light is a falling star\n\nHold on, hold on\nWe drive until the night is gone\n"), demoAccount)
+    add("/Tracks/NEON_RAIN/ableton/NEON_RAIN-1040P.als", demoLiveSet(124, "Intro", "Verse", "Drop", "Outro"), demoAccount)
+    add("/Tracks/NEON_RAIN/ableton/NEON_RAIN-1040P.wav", tone(247), demoAccount)
+    add("/Tracks/NEON_RAIN/lyrics/NEON_RAIN-1040P-LYRICS.md", []byte("## Verse\nNeon rain on the boulevard\nEvery sign is a falling star\n\n## Chorus\nHold on, hold on\nWe drive until the night is gone\nHold on\n"), "dbid:lee")
+    add("/Tracks/NEON_RAIN/ableton/NEON_RAIN-1040P.mid", demoMIDI(124, "Bass", "Lead", "Pads"), demoAccount)
+    add("/Tracks/NEON_RAIN/ableton/NEON_RAIN-1040P-BASS.mid", demoMIDI(124, "Bass"), "dbid:lee")
+    for i, stem := range []string{"DRUMS", "BASS", "SYNTH", "VOCALS"} {
+        add("/Tracks/NEON_RAIN/stems/1040P-1130P/NEON_RAIN-1040P-1130P-"+stem+".wav", tone(110*float64(i+1)), "dbid:lee")
+    }
+    add("/Tracks/NEON_RAIN/mixes/NEON_RAIN-1040P-1130P-[unmastered].wav", tone(262), "dbid:lee")
+    add("/Tracks/NEON_RAIN/prints/NEON_RAIN-1040P-1130P-PRINT_SSL_TAPE.wav", tone(262), "dbid:lee")
+    add("/Tracks/NEON_RAIN/masters/NEON_RAIN-1040P-1130P-1.wav", tone(294), "dbid:kim")
+    add("/Tracks/NEON_RAIN/masters/NEON_RAIN-1040P-1130P-2.wav", tone(330), "dbid:kim")
+    add("/Tracks/NEON_RAIN/masters/NEON_RAIN-1040P-1130P-FINAL.wav", tone(330), "dbid:kim")
+    add("/Tracks/NEON_RAIN/References/Club Reference.wav", tone(440), "dbid:lee")
+
+    add("/Tracks/GLASS_HOUSE/ableton/GLASS_HOUSE-0130A.als", demoLiveSet(98, "Intro", "Chorus"), demoAccount)
+    add("/Tracks/GLASS_HOUSE/ableton/GLASS_HOUSE-0130A.wav", tone(196), demoAccount)
+    add("/Tracks/GLASS_HOUSE/GLASS_HOUSE-LYRICS.txt", []byte("Stones in a glass house\nWe never learned to stay quiet\n"), "dbid:kim")
+    add("/Tracks/GLASS_HOUSE/ableton/GLASS_HOUSE.RADIO_EDIT-0200A.als", demoLiveSet(98, "Chorus"), demoAccount)
+    add("/Tracks/GLASS_HOUSE/ableton/GLASS_HOUSE.RADIO_EDIT-0200A.wav", tone(208), demoAccount)
+    add("/Tracks/GLASS_HOUSE/mixes/GLASS_HOUSE-0130A-0300A-[unmastered].wav", tone(233), "dbid:lee")
+    add("/Tracks/GLASS_HOUSE/mixes/GLASS_HOUSE.RADIO_EDIT-Radio Loudness-REF.wav", tone(392), "dbid:lee")
+
+    add("/Tracks/SLOW_BURN/track.yaml", []byte("bpm: 76\nproject: Night Drive\n"), demoAccount)
+    add("/Tracks/SLOW_BURN/SLOW_BURN-IDEA-bassline.m4a", tone(87), "dbid:lee")
+    add("/Tracks/SLOW_BURN/ableton/SLOW_BURN-1100A.als", demoLiveSet(76, "Intro"), demoAccount)
+    add("/Tracks/SLOW_BURN/ableton/SLOW_BURN-1100A.wav", tone(175), demoAccount)
+
+    add("/Archive/OLD_FLAME/ableton/OLD_FLAME-0800P.als", demoLiveSet(110, "Intro", "Hook"), demoAccount)
+    add("/Archive/OLD_FLAME/masters/OLD_FLAME-0800P-0900P-FINAL.wav", tone(277), "dbid:kim")
+
+    add("/Tracks/NIGHT_DRIVE/project.yaml", []byte("title: Night Drive\ntype: ep\nartist: Kim & Lee\ntracks: [NEON_RAIN, GLASS_HOUSE]\n"), demoAccount)
+
+    add("/_inbox/SLOW_BURN-1100A-1200P-[unmastered].wav", tone(185), "dbid:lee")
+}
+
+// put stores a file, creating its folders; d.mu is held or not yet shared.
+func (d *demoDropbox) put(p string, data []byte, at time.Time, by string) *demoFile {
+    for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+        if d.files[strings.ToLower(dir)] == nil {
+            d.files[strings.ToLower(dir)] = &demoFile{display: dir, folder: true}
+            d.changed = append(d.changed, strings.ToLower(dir))
+        }
+    }
+    f := &demoFile{display: p, data: data, modified: at, by: by}
+    d.files[strings.ToLower(p)] = f
+    d.changed = append(d.changed, strings.ToLower(p))
+    return f
+}
+
+// remove deletes the file or folder at k, a lower-case path; d.mu is held.
+func (d *demoDropbox) remove(k string) {
+    delete(d.files, k)
+    d.changed = append(d.changed, k)
+}
+
+// entry is f as Dropbox describes it.
+func (f *demoFile) entry() map[string]any {
+    e := map[string]any{".tag": "folder", "name": path.Base(f.display), "path_display": f.display, "path_lower": strings.ToLower(f.display), "id": "id:" + strings.ToLower(f.display)}
+    if f.folder { return e }
+    h := newContentHasher()
+    h.Write(f.data)
+    modified := f.modified.Format(time.RFC3339)
+    e[".tag"], e["size"], e["content_hash"], e["server_modified"], e["client_modified"] = "file", len(f.data), h.Sum(), modified, modified
+    e["rev"] = fmt.Sprintf("%x", f.modified.UnixNano())
+    if f.by != "" { e["sharing_info"] = map[string]any{"read_only": false, "parent_shared_folder_id": "1", "modified_by": f.by} }
+    return e
+}
+
+// demoError answers like a Dropbox endpoint error, e.g. "path/not_found".
+func demoError(w http.ResponseWriter, summary string) {
+    w.WriteHeader(409)
+    json.NewEncoder(w).Encode(map[string]any{"error_summary": summary + "/.."})
+}
+
+func (d *demoDropbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    var arg map[string]any
+    if a := r.Header.Get("Dropbox-API-Arg"); a != "" {
+        json.Unmarshal([]byte(a), &arg)
+    } else if r.URL.Path != "/2/files/upload" {
+        json.NewDecoder(r.Body).Decode(&arg)
+    }
+    str := func(m map[string]any, k string) string { v, _ := m[k].(string); return v }
+    sub := func(k string) map[string]any { v, _ := arg[k].(map[string]any); return v }
+    d.mu.Lock(); defer d.mu.Unlock()
+    w.Header().Set("Content-Type", "application/json")
+    enc := json.NewEncoder(w)
+    switch r.URL.Path {
+    case "/2/files/list_folder":
+        p := strings.ToLower(strings.TrimSuffix(str(arg, "path"), "/"))
+        if p != "" && d.files[p] == nil { demoError(w, "path/not_found"); return }
+        recursive, _ := arg["recursive"].(bool)
+        entries := []map[string]any{}
+        keys := make([]string, 0, len(d.files))
+        for k := range d.files { keys = append(keys, k) }
+        sort.Strings(keys)
+        for _, k := range keys {
+            if !strings.HasPrefix(k, p+"/") || (!recursive && strings.Contains(k[len(p)+1:], "/")) { continue }
+            entries = append(entries, d.files[k].entry())
+        }
+        enc.Encode(map[string]any{"entries": entries, "cursor": p + "@" + strconv.Itoa(len(d.changed)), "has_more": false})
+    case "/2/files/list_folder/continue":
+        cursor := str(arg, "cursor")
+        i := strings.LastIndex(cursor, "@")
+        n, err := strconv.Atoi(cursor[i+1:])
+        if i < 0 || err != nil || n < 0 || n > len(d.changed) { demoError(w, "reset"); return }
+        p := cursor[:i]
+        // Each path changed since, once, in the order of its last change.
+        last := map[string]int{}
+        for j, k := range d.changed[n:] { last[k] = j }
+        entries := []map[string]any{}
+        for j, k := range d.changed[n:] {
+            if last[k] != j || !strings.HasPrefix(k, p+"/") { continue }
+            if f := d.files[k]; f != nil { entries = append(entries, f.entry()); continue }
+            entries = append(entries, map[string]any{".tag": "deleted", "name": path.Base(k), "path_display": k, "path_lower": k}) // as it was is gone
+        }
+        enc.Encode(map[string]any{"entries": entries, "cursor": p + "@" + strconv.Itoa(len(d.changed)), "has_more": false})
+    case "/2/files/get_metadata":
+        f := d.files[strings.ToLower(str(arg, "path"))]
+        if f == nil { demoError(w, "path/not_found"); return }
+        enc.Encode(f.entry())
+    case "/2/files/get_temporary_link":
+        f := d.files[strings.ToLower(str(arg, "path"))]
+        if f == nil || f.folder { demoError(w, "path/not_found"); return }
+        enc.Encode(map[string]any{"link": "/demo/files" + f.display, "metadata": f.entry()})
+    case "/2/files/download":
+        f := d.files[strings.ToLower(str(arg, "path"))]
+        if f == nil || f.folder { demoError(w, "path/not_found"); return }
+        b, _ := json.Marshal(f.entry())
+        w.Header().Set("Dropbox-API-Result", string(b))
+        w.Header().Set("Content-Type", "application/octet-stream")
+        w.Write(f.data)
+    case "/2/files/move_v2", "/2/files/copy_v2":
+        from, to := strings.ToLower(str(arg, "from_path")), str(arg, "to_path")
+        if d.files[from] == nil { demoError(w, "from_lookup/not_found"); return }
+        if d.files[strings.ToLower(to)] != nil { demoError(w, "to/conflict/file"); return }
+        var moved []string
+        for k := range d.files {
+            if k == from || strings.HasPrefix(k, from+"/") { moved = append(moved, k) }
+        }
+        sort.Strings(moved) // folders before what they hold
+        for _, k := range moved {
+            f := *d.files[k]
+            f.display = to + f.display[len(from):]
+            if !f.folder { f.modified = time.Now().UTC() }
+            if r.URL.Path == "/2/files/move_v2" { d.remove(k) }
+            d.put(f.display, f.data, f.modified, f.by).folder = f.folder
+        }
+        enc.Encode(map[string]any{"metadata": d.files[strings.ToLower(to)].entry()})
+    case "/2/files/delete_v2":
+        p := strings.ToLower(str(arg, "path"))
+        f := d.files[p]
+        if f == nil { demoError(w, "path_lookup/not_found"); return }
+        for k := range d.files {
+            if k == p || strings.HasPrefix(k, p+"/") { d.remove(k) }
+        }
+        enc.Encode(map[string]any{"metadata": f.entry()})
+    case "/2/files/create_folder_v2":
+        p := str(arg, "path")
+        if d.files[strings.ToLower(p)] != nil { demoError(w, "path/conflict/folder"); return }
+        d.put(p+"/.", nil, time.Now().UTC(), "")
+        d.remove(strings.ToLower(p + "/."))
+        enc.Encode(map[string]any{"metadata": d.files[strings.ToLower(p)].entry()})
+    case "/2/files/upload":
+        b, _ := io.ReadAll(r.Body)
+        d.commit(w, str(arg, "path"), str(arg, "mode"), b)
+    case "/2/files/upload_session/start":
+        id := newID()
+        d.sessions[id], _ = io.ReadAll(r.Body)
+        enc.Encode(map[string]any{"session_id": id})
+    case "/2/files/upload_session/append_v2", "/2/files/upload_session/finish":
+        cur := sub("cursor")
+        id := str(cur, "session_id")
+        data, ok := d.sessions[id]
+        if !ok { demoError(w, "lookup_failed/not_found"); return }
+        if off, _ := cur["offset"].(float64); int(off) != len(data) { demoError(w, "lookup_failed/incorrect_offset"); return }
+        b, _ := io.ReadAll(r.Body)
+        d.sessions[id] = append(data, b...)
+        if r.URL.Path == "/2/files/upload_session/append_v2" { w.Write([]byte("null")); return }
+        delete(d.sessions, id)
+        commit := sub("commit")
+        d.commit(w, str(commit, "path"), str(commit, "mode"), append(data, b...))
+    case "/2/files/get_temporary_upload_link":
+        id := newID()
+        d.links[id] = sub("commit_info")
+        enc.Encode(map[string]any{"link": "/demo/upload/" + id})
+    case "/2/file_requests/create":
+        id := newID()
+        fr := map[string]any{"id": id, "url": "/demo/requests/" + id, "title": str(arg, "title"), "destination": str(arg, "destination"),
+            "created": time.Now().UTC().Format(time.RFC3339), "is_open": true, "file_count": 0, "description": str(arg, "description")}
+        if dl := sub("deadline"); dl != nil { fr["deadline"] = map[string]any{"deadline": str(dl, "deadline")} }
+        d.requests[id] = fr
+        enc.Encode(fr)
+    case "/2/file_requests/list_v2":
+        list := []any{}
+        for _, fr := range d.requests { list = append(list, fr) }
+        enc.Encode(map[string]any{"file_requests": list, "cursor": "demo", "has_more": false})
+    case "/2/file_requests/get", "/2/file_requests/update":
+        fr := d.requests[str(arg, "id")]
+        if fr == nil { demoError(w, "not_found"); return }
+        if open, ok := arg["open"].(bool); ok { fr["is_open"] = open }
+        if t := str(arg, "title"); t != "" { fr["title"] = t }
+        enc.Encode(fr)
+    case "/2/file_requests/delete":
+        ids, _ := arg["ids"].([]any)
+        out := []any{}
+        for _, id := range ids {
+            fr := d.requests[fmt.Sprint(id)]
+            if fr == nil { demoError(w, "not_found"); return }
+            if fr["is_open"] == true { demoError(w, "file_request_open"); return }
+            out = append(out, fr)
+        }
+        for _, fr := range out { delete(d.requests, fr.(map[string]any)["id"].(string)) }
+        enc.Encode(map[string]any{"file_requests": out})
+    case "/2/users/get_current_account":
+        enc.Encode(map[string]any{"account_id": demoAccount, "name": map[string]any{"display_name": "Demo Producer"}, "email": "demo@example.com"})
+    case "/2/users/get_account_batch":
+        names := map[string]string{demoAccount: "Demo Producer", "dbid:kim": "Kim (mastering)", "dbid:lee": "Lee (mixing)"}
+        out := []any{}
+        ids, _ := arg["account_ids"].([]any)
+        for _, id := range ids {
+            s, _ := id.(string)
+            out = append(out, map[string]any{"account_id": s, "name": map[string]any{"display_name": cmp.Or(names[s], "Demo User")}})
+        }
+        enc.Encode(out)
+    default:
+        w.WriteHeader(400)
+        enc.Encode(map[string]any{"error_summary": "the demo does not support " + r.URL.Path})
+    }
+}
+
+// commit writes an uploaded file; d.mu is held.
+func (d *demoDropbox) commit(w http.ResponseWriter, p, mode string, data []byte) {
+    if d.files[strings.ToLower(p)] != nil && mode != "overwrite" { demoError(w, "path/conflict/file"); return }
+    f := d.put(p, data, time.Now().UTC(), demoAccount)
+    json.NewEncoder(w).Encode(f.entry())
+}
+
+// GET /demo/files/{path}: what temporary links point to.
+func (d *demoDropbox) serveFile(w http.ResponseWriter, r *http.Request) {
+    p := strings.TrimPrefix(r.URL.Path, "/demo/files")
+    d.mu.Lock()
+    f := d.files[strings.ToLower(p)]
+    d.mu.Unlock()
+    if f == nil || f.folder { http.NotFound(w, r); return }
+    http.ServeContent(w, r, path.Base(f.display), f.modified, bytes.NewReader(f.data))
+}
+
+// POST /demo/requests/{id}?name=: an upload through a file request.
+func (d *demoDropbox) serveRequest(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { http.Error(w, "POST the file", 405); return }
+    name := path.Base("/" + r.URL.Query().Get("name"))
+    if name == "/" { http.Error(w, "name required", 400); return }
+    data, err := io.ReadAll(r.Body)
+    if err != nil { http.Error(w, err.Error(), 400); return }
+    d.mu.Lock(); defer d.mu.Unlock()
+    fr := d.requests[strings.TrimPrefix(r.URL.Path, "/demo/requests/")]
+    if fr == nil || fr["is_open"] != true { http.Error(w, "this file request is closed", 404); return }
+    d.put(path.Join(fr["destination"].(string), name), data, time.Now().UTC(), demoAccount)
+    fr["file_count"] = fr["file_count"].(int) + 1
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /demo/upload/{id}: an upload to a temporary upload link, which
+// works once.
+func (d *demoDropbox) serveUpload(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { http.Error(w, "POST the file", 405); return }
+    data, err := io.ReadAll(r.Body)
+    if err != nil { http.Error(w, err.Error(), 400); return }
+    d.mu.Lock(); defer d.mu.Unlock()
+    id := strings.TrimPrefix(r.URL.Path, "/demo/upload/")
+    ci := d.links[id]
+    if ci == nil { http.Error(w, "no such upload link", 409); return }
+    delete(d.links, id)
+    p, _ := ci["path"].(string)
+    mode, _ := ci["mode"].(string)
+    d.commit(w, p, mode, data)
+}
+
+// demoTone is a mono 16-bit WAV of a sine at hz, faded in and out.
+func demoTone(hz float64, d time.Duration) []byte {
+    const rate = 22050
+    n := int(d.Seconds() * rate)
+    var b bytes.Buffer
+    le := func(v any) { binary.Write(&b, binary.LittleEndian, v) }
+    b.WriteString("RIFF"); le(uint32(36 + 2*n)); b.WriteString("WAVE")
+    b.WriteString("fmt "); le(uint32(16)); le(uint16(1)); le(uint16(1)); le(uint32(rate)); le(uint32(2 * rate)); le(uint16(2)); le(uint16(16))
+    b.WriteString("data"); le(uint32(2 * n))
+    for i := 0; i < n; i++ {
+        fade := math.Min(1, math.Min(float64(i), float64(n-i))/(rate/20))
+        le(int16(0.3 * fade * math.MaxInt16 * math.Sin(2*math.Pi*hz*float64(i)/rate)))
+    }
+    return b.Bytes()
+}
+
+// demoLiveSet is a gzipped Live set with a tempo and locators a bar apart.
+func demoLiveSet(bpm float64, locators ...string) []byte {
+    var x strings.Builder
+    fmt.Fprintf(&x, `<?xml version="1.0" encoding="UTF-8"?><Ableton MajorVersion="5" Creator="Ableton Live 12.0"><LiveSet>`)
+    fmt.Fprintf(&x, `<MainTrack><DeviceChain><Mixer><Tempo><Manual Value="%g"/></Tempo></Mixer></DeviceChain></MainTrack><Locators><Locators>`, bpm)
+    for i, name := range locators {
+        fmt.Fprintf(&x, `<Locator Id="%d"><Time Value="%d"/><Name Value="%s"/></Locator>`, i, i*32, name)
+    }
+    x.WriteString(`</Locators></Locators></LiveSet></Ableton>`)
+    var b bytes.Buffer
+    zw := gzip.NewWriter(&b)
+    zw.Write([]byte(x.String()))
+    zw.Close()
+    return b.Bytes()
+}
+
+// demoMIDI is a Standard MIDI File of eight 4/4 bars at bpm: a tempo track,
+// then one track per part playing a note each beat.
+func demoMIDI(bpm float64, parts ...string) []byte {
+    const ticks = 480
+    var b bytes.Buffer
+    chunk := func(id string, data []byte) {
+        b.WriteString(id)
+        binary.Write(&b, binary.BigEndian, uint32(len(data)))
+        b.Write(data)
+    }
+    chunk("MThd", []byte{0, 1, 0, byte(len(parts) + 1), ticks >> 8, ticks & 0xFF})
+    us := uint32(60e6 / bpm)
+    chunk("MTrk", []byte{0, 0xFF, 0x51, 3, byte(us >> 16), byte(us >> 8), byte(us), 0, 0xFF, 0x58, 4, 4, 2, 24, 8, 0, 0xFF, 0x2F, 0})
+    for i, name := range parts {
+        t := append([]byte{0, 0xFF, 0x03, byte(len(name))}, name...)
+        for beat := 0; beat < 32; beat++ {
+            note := byte(36 + 12*i + beat%4*2)
+            t = append(t, 0, 0x90|byte(i), note, 100, 0x83, 0x60, 0x80|byte(i), note, 0) // a beat (480 ticks) long
+        }
+        chunk("MTrk", append(t, 0, 0xFF, 0x2F, 0))
+    }
+    return b.Bytes()
+}
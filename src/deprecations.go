@@ -0,0 +1,99 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Deprecation ======
+
+type Deprecation = client.Deprecation
+
+func (s *Server) deprecationsFor(track string) []Deprecation {
+    var out []Deprecation
+    s.store.view(func(d *storeData) { out = append(out, d.Deprecations[track]...) })
+    return out
+}
+
+func isDeprecated(list []Deprecation, ref ArtifactRef) bool {
+    for _, d := range list {
+        if d.Artifact == ref { return true }
+    }
+    return false
+}
+
+// hideDeprecated drops deprecated stems sets and mixes from t in place; t must be a copy.
+func hideDeprecated(t *Track, list []Deprecation) {
+    if len(list) == 0 { return }
+    stems := []StemsSet{}
+    for _, st := range t.Stems {
+        if !isDeprecated(list, ArtifactRef{Kind: kindStems, T1: st.T1, T2: st.T2}) { stems = append(stems, st) }
+    }
+    mixes := []Mix{}
+    for _, m := range t.Mixes {
+        if !isDeprecated(list, ArtifactRef{Kind: kindMix, T1: m.T1, T2: m.T2}) { mixes = append(mixes, m) }
+    }
+    t.Stems, t.Mixes = stems, mixes
+}
+
+// GET    /api/tracks/{name}/deprecations
+// POST   /api/tracks/{name}/deprecations {"artifact":{"kind":"mix","t1":"...","t2":"..."},"reason":"..."}
+// DELETE /api/tracks/{name}/deprecations?kind=&t1=&t2=
+func (s *Server) handleDeprecations(w http.ResponseWriter, r *http.Request, t *Track) {
+    q := r.URL.Query()
+    switch r.Method {
+    case http.MethodGet:
+        out := []Deprecation{}
+        writeJSON(w, append(out, s.deprecationsFor(t.Name)...))
+
+    case http.MethodPost:
+        var req struct {
+            Artifact ArtifactRef `json:"artifact"`
+            Reason   string      `json:"reason"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        if req.Artifact.Kind != kindStems && req.Artifact.Kind != kindMix { http.Error(w, "only a stems set or a mix can be deprecated", 400); return }
+        req.Reason = strings.TrimSpace(req.Reason)
+        if req.Reason == "" { http.Error(w, "reason required", 400); return }
+        req.Artifact.Idx = ""
+        if err := req.Artifact.Resolve(t); err != nil { http.Error(w, err.Error(), 404); return }
+        dep := Deprecation{Artifact: req.Artifact, Reason: req.Reason, By: actorOf(r), Created: time.Now().UTC()}
+        var before any
+        err := s.store.update(func(d *storeData) error {
+            if d.Deprecations == nil { d.Deprecations = map[string][]Deprecation{} }
+            list := d.Deprecations[t.Name]
+            for i, x := range list {
+                if x.Artifact == dep.Artifact { before = x; list[i] = dep; return nil }
+            }
+            d.Deprecations[t.Name] = append(list, dep)
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "deprecate", t.Name, before, dep)
+        writeJSONStatus(w, http.StatusCreated, dep)
+
+    case http.MethodDelete:
+        ref := ArtifactRef{Kind: q.Get("kind"), T1: q.Get("t1"), T2: q.Get("t2")}
+        var removed *Deprecation
+        err := s.store.update(func(d *storeData) error {
+            list := d.Deprecations[t.Name]
+            for i, x := range list {
+                if x.Artifact != ref { continue }
+                removed = &x
+                d.Deprecations[t.Name] = append(list[:i:i], list[i+1:]...)
+                return nil
+            }
+            return httpError{404, ref.String() + " is not deprecated"}
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "undeprecate", t.Name, removed, nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "GET, POST or DELETE required", 405)
+    }
+}
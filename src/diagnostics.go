@@ -0,0 +1,135 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "expvar"
+    "net/http"
+    "net/http/pprof"
+    "runtime"
+    rpprof "runtime/pprof"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ====== Diagnostics ======
+//
+// For finding out why the server is stuck or slow, such as a reindex that
+// never finishes, admins can reach:
+//
+//   /api/debug/pprof/    the net/http/pprof profiles (goroutine, heap, CPU profile, trace, ...)
+//   /api/debug/vars      expvar: memory statistics, uptime, tracks, running jobs and the leader
+//   /api/debug/snapshot  goroutines, heap and running jobs at a glance, with every stack
+//
+// Jobs (reindexes and what they set off) are listed with what started them
+// and for how long they have run, and their goroutines carry a "job" label
+// in profiles and dumps. These endpoints only answer when authentication is
+// on: without it they would be open to anyone.
+
+var processStart = time.Now()
+
+// jobInfo is a job that is running.
+type jobInfo struct {
+    ID      string    `json:"id"`
+    Trigger string    `json:"trigger,omitempty"`
+    Started time.Time `json:"started"`
+    Running string    `json:"running"` // for how long, when listed
+}
+
+var runningJobs sync.Map // job ID -> *jobInfo
+
+// runJob runs fn as the job kind (see startJob), listed as running until it
+// returns.
+func runJob(ctx context.Context, kind string, fn func(context.Context)) {
+    ctx = startJob(ctx, kind)
+    id, _ := ctx.Value(ctxJob).(string)
+    trigger, _ := ctx.Value(ctxTrigger).(string)
+    runningJobs.Store(id, &jobInfo{ID: id, Trigger: trigger, Started: time.Now().UTC()})
+    defer runningJobs.Delete(id)
+    rpprof.Do(ctx, rpprof.Labels("job", id), fn)
+}
+
+// jobsRunning lists the running jobs, oldest first.
+func jobsRunning() []jobInfo {
+    out := []jobInfo{}
+    runningJobs.Range(func(_, v any) bool {
+        j := *v.(*jobInfo)
+        j.Running = time.Since(j.Started).Round(time.Millisecond).String()
+        out = append(out, j)
+        return true
+    })
+    sort.Slice(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+    return out
+}
+
+// publishVars adds the server's own variables to expvar.
+func (s *Server) publishVars() {
+    expvar.Publish("uptime_seconds", expvar.Func(func() any { return int64(time.Since(processStart).Seconds()) }))
+    expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+    expvar.Publish("tracks", expvar.Func(func() any { s.mu.RLock(); defer s.mu.RUnlock(); return len(s.tracks) }))
+    expvar.Publish("jobs", expvar.Func(func() any { return jobsRunning() }))
+    if c := s.cluster; c != nil {
+        expvar.Publish("cluster", expvar.Func(func() any {
+            l := c.currentLease()
+            return map[string]any{"instance": c.instance, "leading": c.leader.Load(), "leader": l.Instance, "lease_expires": l.Expires}
+        }))
+    }
+}
+
+// GET /api/debug/pprof/...
+// GET /api/debug/vars
+// GET /api/debug/snapshot[?stacks=0]
+func (s *Server) handleDebug(w http.ResponseWriter, r *http.Request) {
+    if !s.authEnabled() { http.Error(w, "diagnostics need authentication: set ADMIN_API_KEY, create an API key or configure web login", 404); return }
+    p := strings.TrimPrefix(r.URL.Path, "/api")
+    switch {
+    case p == "/debug/vars":
+        expvar.Handler().ServeHTTP(w, r)
+    case p == "/debug/snapshot":
+        s.debugSnapshot(w, r)
+    case p == "/debug/pprof/cmdline":
+        pprof.Cmdline(w, r)
+    case p == "/debug/pprof/profile":
+        pprof.Profile(w, r)
+    case p == "/debug/pprof/symbol":
+        pprof.Symbol(w, r)
+    case p == "/debug/pprof/trace":
+        pprof.Trace(w, r)
+    case strings.HasPrefix(p, "/debug/pprof/"):
+        // Index finds the profile by the path under /debug/pprof/.
+        r2 := r.Clone(r.Context())
+        r2.URL.Path = p
+        pprof.Index(w, r2)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+func (s *Server) debugSnapshot(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    var m runtime.MemStats
+    runtime.ReadMemStats(&m)
+    out := map[string]any{
+        "time":       time.Now().UTC(),
+        "uptime":     time.Since(processStart).Round(time.Second).String(),
+        "goroutines": runtime.NumGoroutine(),
+        "jobs":       jobsRunning(),
+        "heap": map[string]any{
+            "alloc_bytes":    m.HeapAlloc,
+            "inuse_bytes":    m.HeapInuse,
+            "objects":        m.HeapObjects,
+            "sys_bytes":      m.Sys,
+            "gc_cycles":      m.NumGC,
+            "gc_pause_total": time.Duration(m.PauseTotalNs).String(),
+        },
+    }
+    if m.LastGC > 0 { out["heap"].(map[string]any)["last_gc"] = time.Unix(0, int64(m.LastGC)).UTC() }
+    if r.URL.Query().Get("stacks") != "0" {
+        var buf bytes.Buffer
+        rpprof.Lookup("goroutine").WriteTo(&buf, 1)
+        out["stacks"] = buf.String()
+    }
+    writeJSON(w, out)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+    "bytes"
+    "cmp"
+    "context"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "log/slog"
+    "mime"
+    "mime/quotedprintable"
+    "net"
+    "net/http"
+    "net/mail"
+    "net/smtp"
+    "os"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ====== Email Digest ======
+//
+// For whoever would rather read an email than open the browser: with an SMTP
+// server and DIGEST_RECIPIENTS set, the leader mails a summary of what
+// happened to each track since the last digest (the week before, for the
+// first one): new sessions, stems, mixes, master candidates and FINALs, and
+// status changes.
+//
+//   SMTP_HOST, SMTP_PORT   the mail server (port 587 by default, with
+//                          STARTTLS when offered; 465 is TLS from the start)
+//   SMTP_USERNAME          sign-in, with SMTP_PASSWORD (a secret)
+//   SMTP_FROM              the sender (default SMTP_USERNAME)
+//   DIGEST_RECIPIENTS      comma-separated addresses
+//   DIGEST_SCHEDULE        when, as a five-field cron expression in the
+//                          server's local time (default "0 9 * * 1",
+//                          Mondays at 9:00)
+//
+// GET /api/admin/digest shows what the next digest would say; POST sends it
+// now. When a digest cannot be sent the next one covers its period too.
+// Read-only instances send nothing on schedule.
+
+const defaultDigestSchedule = "0 9 * * 1"
+
+// digestKinds are the changelog entries a digest reports.
+var digestKinds = []string{"session", "stems", "mix", "masters", "final", "status"}
+
+// mailer sends mail through the SMTP server.
+type mailer struct {
+    host, port         string
+    username, password string
+    from               string
+}
+
+// digester is the digest setup; nil if there is none.
+type digester struct {
+    mail     *mailer
+    to       []string
+    spec     string
+    schedule *cronSpec
+}
+
+// loadDigest reads the SMTP and digest settings.
+func loadDigest() (*digester, error) {
+    host, recipients := os.Getenv("SMTP_HOST"), os.Getenv("DIGEST_RECIPIENTS")
+    if host == "" && recipients == "" { return nil, nil }
+    if host == "" || recipients == "" { return nil, errors.New("SMTP_HOST and DIGEST_RECIPIENTS go together") }
+    password, err := readSecret("SMTP_PASSWORD")
+    if err != nil { return nil, err }
+    m := &mailer{host: host, port: cmp.Or(os.Getenv("SMTP_PORT"), "587"), username: os.Getenv("SMTP_USERNAME"), password: password}
+    m.from = cmp.Or(os.Getenv("SMTP_FROM"), m.username)
+    from, err := mail.ParseAddress(m.from)
+    if err != nil { return nil, fmt.Errorf("SMTP_FROM: %q is not an address", m.from) }
+    m.from = from.Address
+    d := &digester{mail: m, spec: cmp.Or(os.Getenv("DIGEST_SCHEDULE"), defaultDigestSchedule)}
+    list, err := mail.ParseAddressList(recipients)
+    if err != nil { return nil, fmt.Errorf("DIGEST_RECIPIENTS: %w", err) }
+    for _, a := range list { d.to = append(d.to, a.Address) }
+    if d.schedule, err = parseCron(d.spec); err != nil { return nil, fmt.Errorf("DIGEST_SCHEDULE: %w", err) }
+    return d, nil
+}
+
+// next is when the digest is due after t; zero if not within a year.
+func (d *digester) next(t time.Time) time.Time {
+    t = t.Local().Truncate(time.Minute)
+    for i := 0; i < 366*24*60; i++ {
+        t = t.Add(time.Minute)
+        if d.schedule.matches(t) { return t }
+    }
+    return time.Time{}
+}
+
+// digestTrack is what happened to one track.
+type digestTrack struct {
+    Track   string        `json:"track"`
+    Status  string        `json:"status"`
+    Entries []ChangeEntry `json:"entries"`
+}
+
+// digestOf collects what happened between from and to, by track.
+func (s *Server) digestOf(from, to time.Time) []digestTrack {
+    s.mu.RLock()
+    tracks := make([]*Track, 0, len(s.tracks))
+    for _, t := range s.tracks {
+        if !t.Archived { tracks = append(tracks, t) }
+    }
+    s.mu.RUnlock()
+    sort.Slice(tracks, func(i, j int) bool { return tracks[i].Name < tracks[j].Name })
+    out := []digestTrack{}
+    for _, t := range tracks {
+        var entries []ChangeEntry
+        for _, e := range s.changelog(t, from) {
+            if e.Time.IsZero() || !e.Time.Before(to) || !slices.Contains(digestKinds, e.Kind) { continue }
+            entries = append(entries, e)
+        }
+        if len(entries) == 0 { continue }
+        slices.Reverse(entries) // oldest first reads as a story
+        out = append(out, digestTrack{Track: t.Name, Status: s.statusOf(t.Name).Status, Entries: entries})
+    }
+    return out
+}
+
+// digestPeriod is what the next digest covers: since the last one, or the
+// week before the first.
+func (s *Server) digestPeriod(now time.Time) time.Time {
+    from := now.Add(-7 * 24 * time.Hour)
+    s.store.view(func(d *storeData) {
+        if d.DigestSent != nil { from = *d.DigestSent }
+    })
+    return from
+}
+
+// digestText is the digest as an email subject and body.
+func digestText(from, to time.Time, tracks []digestTrack) (string, string) {
+    span := from.Local().Format("Jan 2") + " – " + to.Local().Format("Jan 2, 2006")
+    subject := fmt.Sprintf("Studio digest, %s: %d track%s changed", span, len(tracks), plural(len(tracks)))
+    var b strings.Builder
+    fmt.Fprintf(&b, "What happened in the studio, %s.\n", span)
+    if len(tracks) == 0 { b.WriteString("\nNothing new.\n") }
+    for _, t := range tracks {
+        fmt.Fprintf(&b, "\n%s (%s)\n", t.Track, t.Status)
+        for _, e := range t.Entries {
+            fmt.Fprintf(&b, "  %s  %s", e.Time.Local().Format("Mon Jan 2"), e.Summary)
+            if e.Author != "" { fmt.Fprintf(&b, " (%s)", e.Author) }
+            b.WriteString("\n")
+        }
+    }
+    if base := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/"); base != "" { fmt.Fprintf(&b, "\nListen: %s/\n", base) }
+    return subject, b.String()
+}
+
+// sendDigest mails what happened since the last digest and records it as
+// sent; a failed digest is left for the next one.
+func (s *Server) sendDigest(ctx context.Context) (int, error) {
+    now := time.Now().UTC()
+    from := s.digestPeriod(now)
+    tracks := s.digestOf(from, now)
+    subject, body := digestText(from, now, tracks)
+    if err := s.digest.mail.send(ctx, s.digest.to, subject, body); err != nil { return 0, err }
+    err := s.store.update(func(d *storeData) error { d.DigestSent = &now; return nil })
+    return len(tracks), err
+}
+
+// watchDigest sends the digest on schedule, from the leader.
+func (s *Server) watchDigest() {
+    for range time.Tick(time.Minute) {
+        now := time.Now()
+        if !s.digest.schedule.matches(now) || !s.leading() || s.readOnly { continue }
+        // A digest sent this minute, by another replica or before a restart,
+        // is not sent again.
+        minute := now.Truncate(time.Minute)
+        sent := false
+        s.store.view(func(d *storeData) { sent = d.DigestSent != nil && !d.DigestSent.Before(minute) })
+        if sent { continue }
+        ctx := context.Background()
+        go runJob(ctx, "digest", func(ctx context.Context) {
+            n, err := s.sendDigest(ctx)
+            if err != nil { slog.ErrorContext(ctx, "sending the digest failed", "error", err); return }
+            slog.InfoContext(ctx, "digest sent", "tracks", n, "recipients", len(s.digest.to))
+        })
+    }
+}
+
+// GET  /api/admin/digest   what the next digest would say
+// POST /api/admin/digest   send it now
+func (s *Server) handleDigest(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        now := time.Now().UTC()
+        from := s.digestPeriod(now)
+        tracks := s.digestOf(from, now)
+        subject, body := digestText(from, now, tracks)
+        out := map[string]any{"from": from, "to": now, "tracks": tracks, "subject": subject, "text": body}
+        if s.digest != nil {
+            out["recipients"], out["schedule"] = s.digest.to, s.digest.spec
+            if next := s.digest.next(now); !next.IsZero() { out["next"] = next }
+        }
+        writeJSON(w, out)
+    case http.MethodPost:
+        if s.digest == nil { http.Error(w, "no email digest is set up (SMTP_HOST, DIGEST_RECIPIENTS)", 409); return }
+        n, err := s.sendDigest(r.Context())
+        if err != nil { http.Error(w, "sending the digest failed: "+err.Error(), 502); return }
+        s.audit(r, "digest-send", "", nil, map[string]any{"tracks": n, "recipients": s.digest.to})
+        writeJSON(w, map[string]any{"sent": true, "tracks": n, "recipients": s.digest.to})
+    default:
+        http.Error(w, "GET or POST required", 405)
+    }
+}
+
+// ====== SMTP ======
+
+// send mails a plain-text message to every address in to.
+func (m *mailer) send(ctx context.Context, to []string, subject, body string) error {
+    addr := net.JoinHostPort(m.host, m.port)
+    dialer := &net.Dialer{Timeout: 30 * time.Second}
+    var conn net.Conn
+    var err error
+    if m.port == "465" {
+        conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: m.host}}).DialContext(ctx, "tcp", addr)
+    } else {
+        conn, err = dialer.DialContext(ctx, "tcp", addr)
+    }
+    if err != nil { return err }
+    conn.SetDeadline(time.Now().Add(2 * time.Minute))
+    c, err := smtp.NewClient(conn, m.host)
+    if err != nil { conn.Close(); return err }
+    defer c.Close()
+    if ok, _ := c.Extension("STARTTLS"); ok && m.port != "465" {
+        if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil { return err }
+    }
+    if m.username != "" {
+        // PlainAuth refuses to send the password unencrypted, except to localhost.
+        if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil { return err }
+    }
+    if err := c.Mail(m.from); err != nil { return err }
+    for _, a := range to {
+        if err := c.Rcpt(a); err != nil { return fmt.Errorf("%s: %w", a, err) }
+    }
+    wc, err := c.Data()
+    if err != nil { return err }
+    if _, err := wc.Write(m.message(to, subject, body)); err != nil { return err }
+    if err := wc.Close(); err != nil { return err }
+    return c.Quit()
+}
+
+// message is the RFC 5322 message, quoted-printable UTF-8 text.
+func (m *mailer) message(to []string, subject, body string) []byte {
+    var b bytes.Buffer
+    for _, h := range [][2]string{
+        {"From", m.from},
+        {"To", strings.Join(to, ", ")},
+        {"Subject", mime.QEncoding.Encode("utf-8", subject)},
+        {"Date", time.Now().Format(time.RFC1123Z)},
+        {"Message-ID", "<" + newID() + "@" + m.host + ">"},
+        {"MIME-Version", "1.0"},
+        {"Content-Type", "text/plain; charset=utf-8"},
+        {"Content-Transfer-Encoding", "quoted-printable"},
+    } {
+        fmt.Fprintf(&b, "%s: %s\r\n", h[0], h[1])
+    }
+    b.WriteString("\r\n")
+    qp := quotedprintable.NewWriter(&b)
+    qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+    qp.Close()
+    return b.Bytes()
+}
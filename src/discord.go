@@ -0,0 +1,88 @@
+package main
+
+import (
+    "bytes"
+    "cmp"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// ====== Discord Notifications ======
+//
+// The same announcements as on Slack (see notify.go), posted to Discord
+// webhooks as embeds with a play link. DISCORD_WEBHOOK_URL takes every kind
+// of event; a channel per kind is set with DISCORD_WEBHOOK_CANDIDATE,
+// DISCORD_WEBHOOK_FINAL, DISCORD_WEBHOOK_STEMS, DISCORD_WEBHOOK_MIX and
+// DISCORD_WEBHOOK_DEADLINE (reminders, see deadlines.go), which win over it:
+//
+//   DISCORD_WEBHOOK_MIX    https://discord.com/api/webhooks/…   #mixes
+//   DISCORD_WEBHOOK_FINAL  https://discord.com/api/webhooks/…   #releases
+//
+// A kind with no webhook is not posted.
+
+// discordColors are the embed colors by kind of event.
+var discordColors = map[string]int{eventCandidate: 0x3498db, eventFinal: 0xf1c40f, eventStems: 0x9b59b6, eventMix: 0x2ecc71, eventDeadline: 0xe74c3c}
+
+type discordNotifier struct {
+    webhooks map[string]string // by kind of event
+    client   *http.Client
+}
+
+// loadDiscord reads the Discord webhooks; nil if there are none.
+func loadDiscord() (*discordNotifier, error) {
+    all, err := readSecret("DISCORD_WEBHOOK_URL")
+    if err != nil { return nil, err }
+    n := &discordNotifier{webhooks: map[string]string{}, client: &http.Client{Timeout: 15 * time.Second}}
+    for _, kind := range notifyKinds {
+        url, err := readSecret("DISCORD_WEBHOOK_" + strings.ToUpper(kind))
+        if err != nil { return nil, err }
+        if url = cmp.Or(url, all); url != "" { n.webhooks[kind] = url }
+    }
+    if len(n.webhooks) == 0 { return nil, nil }
+    return n, nil
+}
+
+func (n *discordNotifier) name() string { return "discord" }
+
+func (n *discordNotifier) wants(kind string) bool { return n.webhooks[kind] != "" }
+
+// notify posts e as an embed to the webhook for its kind.
+func (n *discordNotifier) notify(ctx context.Context, e catalogEvent) error {
+    if e.kind == eventDeadline { return n.post(ctx, e.kind, map[string]any{"title": e.title(), "color": discordColors[e.kind]}) }
+    f := e.files[0]
+    var desc []string
+    if e.kind != eventStems { desc = append(desc, "`"+f.Name+"`") }
+    if e.link != "" { desc = append(desc, fmt.Sprintf("[▶ Play](%s)", e.link)) }
+    type field struct {
+        Name   string `json:"name"`
+        Value  string `json:"value"`
+        Inline bool   `json:"inline"`
+    }
+    var fields []field
+    if f.ContributedBy != "" { fields = append(fields, field{"Contributor", f.ContributedBy, true}) }
+    if e.lufs != nil { fields = append(fields, field{"Loudness", fmt.Sprintf("%.1f LUFS", *e.lufs), true}) }
+    embed := map[string]any{"title": e.title(), "description": strings.Join(desc, "\n"), "color": discordColors[e.kind], "timestamp": f.ServerModified.UTC().Format(time.RFC3339)}
+    if e.link != "" { embed["url"] = e.link }
+    if len(fields) > 0 { embed["fields"] = fields }
+    return n.post(ctx, e.kind, embed)
+}
+
+// post sends embed to the webhook for kind.
+func (n *discordNotifier) post(ctx context.Context, kind string, embed map[string]any) error {
+    b, _ := json.Marshal(map[string]any{"embeds": []any{embed}})
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, n.webhooks[kind], bytes.NewReader(b))
+    req.Header.Set("Content-Type", "application/json")
+    res, err := n.client.Do(req)
+    if err != nil { return err }
+    defer res.Body.Close()
+    if res.StatusCode/100 != 2 {
+        body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+        return fmt.Errorf("discord -> %s: %s", res.Status, truncate(string(body), 400))
+    }
+    return nil
+}
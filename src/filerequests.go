@@ -0,0 +1,198 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "path"
+    "sort"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== File Requests ======
+//
+// A feature artist or session player with no Dropbox account, or no access
+// to the rest of the catalog, can be sent a Dropbox file request: a link to
+// an upload page whose files land in the track's inbox folder
+// (INBOX_ROOT/TRACK), or its Stems, Mixes or Masters subfolder to say what
+// they are. From there the inbox names and files them like anything else
+// dropped into it (see inbox.go).
+//
+//   GET    /api/tracks/{name}/file-requests          the track's, open ones first
+//   POST   /api/tracks/{name}/file-requests {"title":"Vocals for NEON_RAIN"[,"kind":"stem","deadline":"2025-03-01","description":"..."]}
+//   PATCH  /api/tracks/{name}/file-requests/{id} {"open":false}   close or reopen it
+//   DELETE /api/tracks/{name}/file-requests/{id}
+//
+// The Dropbox app needs the file_requests.read and file_requests.write
+// scopes. A deadline is the end of that day, local time, and needs a paid
+// Dropbox plan.
+
+// fileRequestFolders are the inbox subfolders by kind; see folderKind.
+var fileRequestFolders = map[string]string{kindStem: "Stems", kindMix: "Mixes", kindMaster: "Masters"}
+
+// dbxFileRequest is a file request as the Dropbox API has it.
+type dbxFileRequest struct {
+    ID          string    `json:"id"`
+    URL         string    `json:"url"`
+    Title       string    `json:"title"`
+    Destination string    `json:"destination"`
+    Created     time.Time `json:"created"`
+    IsOpen      bool      `json:"is_open"`
+    FileCount   int       `json:"file_count"`
+    Description string    `json:"description"`
+    Deadline    *struct {
+        Deadline time.Time `json:"deadline"`
+    } `json:"deadline"`
+}
+
+type FileRequest = client.FileRequest
+
+// inboxFolder is where files for track t go in the inbox.
+func (s *Server) inboxFolder(t *Track) string {
+    return path.Join(s.inboxRoot, t.Name)
+}
+
+// fileRequestOf is fr as a request of t; nil if it does not upload into t's
+// inbox folder.
+func (s *Server) fileRequestOf(t *Track, fr dbxFileRequest) *FileRequest {
+    base := strings.ToLower(s.inboxFolder(t))
+    dest := strings.ToLower(fr.Destination)
+    if dest != base && !strings.HasPrefix(dest, base+"/") { return nil }
+    out := &FileRequest{ID: fr.ID, URL: fr.URL, Title: fr.Title, Track: t.Name, Folder: fr.Destination,
+        Open: fr.IsOpen, Files: fr.FileCount, Description: fr.Description, Created: fr.Created}
+    for kind, sub := range fileRequestFolders {
+        if dest == base+"/"+strings.ToLower(sub) { out.Kind = kind }
+    }
+    if fr.Deadline != nil { out.Deadline = &fr.Deadline.Deadline }
+    return out
+}
+
+// fileRequests lists t's file requests.
+func (s *Server) fileRequests(ctx context.Context, t *Track) ([]FileRequest, error) {
+    out := []FileRequest{}
+    resp, err := s.dbxRPC(ctx, "/2/file_requests/list_v2", map[string]any{"limit": 1000})
+    for {
+        if err != nil { return nil, err }
+        var lr struct {
+            FileRequests []dbxFileRequest `json:"file_requests"`
+            Cursor       string           `json:"cursor"`
+            HasMore      bool             `json:"has_more"`
+        }
+        if err := json.Unmarshal(resp, &lr); err != nil { return nil, err }
+        for _, fr := range lr.FileRequests {
+            if f := s.fileRequestOf(t, fr); f != nil { out = append(out, *f) }
+        }
+        if !lr.HasMore { break }
+        resp, err = s.dbxRPC(ctx, "/2/file_requests/list/continue", map[string]string{"cursor": lr.Cursor})
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Open != out[j].Open { return out[i].Open }
+        return out[i].Created.After(out[j].Created)
+    })
+    return out, nil
+}
+
+// fileRequest fetches t's request id; a 404 if it is not one of t's.
+func (s *Server) fileRequest(ctx context.Context, t *Track, id string) (*FileRequest, error) {
+    resp, err := s.dbxRPC(ctx, "/2/file_requests/get", map[string]string{"id": id})
+    if isNotFound(err) { return nil, httpError{404, "file request not found"} }
+    if err != nil { return nil, httpError{502, err.Error()} }
+    var fr dbxFileRequest
+    if err := json.Unmarshal(resp, &fr); err != nil { return nil, err }
+    f := s.fileRequestOf(t, fr)
+    if f == nil { return nil, httpError{404, "file request not found"} }
+    return f, nil
+}
+
+// updateFileRequest calls file_requests/update and returns the result as t's.
+func (s *Server) updateFileRequest(ctx context.Context, t *Track, args map[string]any) (*FileRequest, error) {
+    resp, err := s.dbxRPC(ctx, "/2/file_requests/update", args)
+    if err != nil { return nil, httpError{502, err.Error()} }
+    var fr dbxFileRequest
+    if err := json.Unmarshal(resp, &fr); err != nil { return nil, err }
+    return s.fileRequestOf(t, fr), nil
+}
+
+// handleFileRequests serves /api/tracks/{name}/file-requests[/{id}].
+func (s *Server) handleFileRequests(w http.ResponseWriter, r *http.Request, t *Track, parts []string) {
+    if t.Parent != "" { http.Error(w, "file requests are for a track, not a branch: ask for "+t.Parent, 400); return }
+    ctx := r.Context()
+    if len(parts) == 0 || parts[0] == "" {
+        switch r.Method {
+        case http.MethodGet:
+            list, err := s.fileRequests(ctx, t)
+            if err != nil { http.Error(w, err.Error(), 502); return }
+            writeJSON(w, list)
+        case http.MethodPost:
+            var req struct {
+                Title       string `json:"title"`
+                Kind        string `json:"kind"`
+                Deadline    string `json:"deadline"` // YYYY-MM-DD
+                Description string `json:"description"`
+            }
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+            req.Title, req.Kind = strings.TrimSpace(req.Title), strings.ToLower(strings.TrimSpace(req.Kind))
+            if req.Title == "" { req.Title = "Files for " + t.Name }
+            folder := s.inboxFolder(t)
+            if req.Kind != "" {
+                sub, ok := fileRequestFolders[req.Kind]
+                if !ok { http.Error(w, "kind must be stem, mix or master", 400); return }
+                folder = path.Join(folder, sub)
+            }
+            args := map[string]any{"title": req.Title, "destination": folder, "open": true}
+            if d := strings.TrimSpace(req.Description); d != "" { args["description"] = d }
+            if req.Deadline != "" {
+                day, err := time.ParseInLocation("2006-01-02", req.Deadline, time.Local)
+                if err != nil { http.Error(w, "deadline must be YYYY-MM-DD", 400); return }
+                args["deadline"] = map[string]any{"deadline": day.AddDate(0, 0, 1).Add(-time.Second).UTC().Format("2006-01-02T15:04:05Z")}
+            }
+            // Uploads need the folder to exist; it may well already.
+            if _, err := s.dbxRPC(ctx, "/2/files/create_folder_v2", map[string]any{"path": folder, "autorename": false}); err != nil && !strings.Contains(err.Error(), "conflict") {
+                http.Error(w, "create "+folder+": "+err.Error(), 502); return
+            }
+            resp, err := s.dbxRPC(ctx, "/2/file_requests/create", args)
+            if err != nil { http.Error(w, err.Error(), 502); return }
+            var fr dbxFileRequest
+            if err := json.Unmarshal(resp, &fr); err != nil { http.Error(w, err.Error(), 502); return }
+            out := s.fileRequestOf(t, fr)
+            s.audit(r, "file-request-create", t.Name, nil, out)
+            writeJSONStatus(w, http.StatusCreated, out)
+        default:
+            http.Error(w, "GET or POST required", 405)
+        }
+        return
+    }
+
+    cur, err := s.fileRequest(ctx, t, parts[0])
+    if err != nil { writeError(w, err); return }
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, cur)
+    case http.MethodPatch:
+        var req struct {
+            Open  *bool   `json:"open"`
+            Title *string `json:"title"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        args := map[string]any{"id": cur.ID}
+        if req.Open != nil { args["open"] = *req.Open }
+        if req.Title != nil && strings.TrimSpace(*req.Title) != "" { args["title"] = strings.TrimSpace(*req.Title) }
+        next, err := s.updateFileRequest(ctx, t, args)
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "file-request-update", t.Name, cur, next)
+        writeJSON(w, next)
+    case http.MethodDelete:
+        // Only a closed request can be deleted.
+        if cur.Open {
+            if _, err := s.updateFileRequest(ctx, t, map[string]any{"id": cur.ID, "open": false}); err != nil { writeError(w, err); return }
+        }
+        if _, err := s.dbxRPC(ctx, "/2/file_requests/delete", map[string]any{"ids": []string{cur.ID}}); err != nil { http.Error(w, err.Error(), 502); return }
+        s.audit(r, "file-request-delete", t.Name, cur, nil)
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        http.Error(w, "GET, PATCH or DELETE required", 405)
+    }
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "path"
+    "strings"
+
+    "avcs-browser/client"
+)
+
+// ====== File Operations ======
+
+type renameOp = client.RenameOp
+
+// underRoot reports whether p lies inside the Dropbox root.
+func (s *Server) underRoot(p string) bool {
+    return strings.HasPrefix(strings.ToLower(p), strings.ToLower(strings.TrimSuffix(s.dropboxRoot, "/"))+"/")
+}
+
+// planRename checks that from exists and that to is a conventional name, and
+// works out the destination: the same folder, except that a stem moves to the
+// stems/<T1>-<T2> folder of its new timestamps when it sat in the old one's.
+func (s *Server) planRename(ctx context.Context, from, to string) (*renameOp, error) {
+    if !s.underRoot(from) { return nil, httpError{400, "path must be under " + s.dropboxRoot} }
+    to = strings.TrimSpace(to)
+    if to == "" || strings.Contains(to, "/") { return nil, httpError{400, "to must be a file name"} }
+    np, ok := classifyName(to)
+    if !ok { return nil, httpError{422, to + " does not follow the naming convention"} }
+    e, err := s.dbxMetadata(ctx, from)
+    if isNotFound(err) { return nil, httpError{404, from + " not found"} }
+    if err != nil { return nil, httpError{502, err.Error()} }
+    if e.Tag != "file" && !strings.HasSuffix(e.lower(), ".logicx") { return nil, httpError{400, from + " is not a file"} }
+    if e.Name == to { return nil, httpError{400, "name unchanged"} }
+    old, _ := classifyName(e.Name) // may well not parse: fixing that is the point
+
+    aliases := s.aliasMap()
+    dir := path.Dir(e.PathDisplay)
+    if np.Kind == kindStem && old.Kind == kindStem && strings.EqualFold(path.Base(dir), old.T1+"-"+old.T2) {
+        dir = path.Join(path.Dir(dir), np.T1+"-"+np.T2)
+    }
+    op := &renameOp{From: e.PathDisplay, To: path.Join(dir, to), Track: canonicalName(aliases, np.Track), Artifact: conflictArtifact(np)}
+    if err := s.checkUploadTarget(ctx, op.To, false); err != nil { return nil, err }
+    if old.Kind != "" {
+        was := conflictArtifact(old)
+        op.Was, op.WasTrack = &was, canonicalName(aliases, old.Track)
+    }
+    return op, nil
+}
+
+// moveAll makes the moves in order; if one fails, those already made are undone.
+func (s *Server) moveAll(ctx context.Context, moves [][2]string) error {
+    for i, m := range moves {
+        if _, err := s.dbxMove(ctx, m[0], m[1]); err != nil {
+            for j := i - 1; j >= 0; j-- { // put back what already moved
+                if _, rerr := s.dbxMove(ctx, moves[j][1], moves[j][0]); rerr != nil { err = fmt.Errorf("%v (undoing %s also failed: %v)", err, moves[j][0], rerr) }
+            }
+            return httpError{502, "move " + m[0] + ": " + err.Error()}
+        }
+    }
+    return nil
+}
+
+// applyRename moves the file and carries store state addressed to the old
+// name along: the baseline of its path, and notes, tags, ratings and the like
+// when the file alone is the artifact they were left on (a mix or one master).
+func (s *Server) applyRename(ctx context.Context, op *renameOp) error {
+    if _, err := s.dbxMove(ctx, op.From, op.To); err != nil { return httpError{502, "move: " + err.Error()} }
+    return s.store.update(func(d *storeData) error {
+        moveBaselines(d, op.From, op.To)
+        if b := d.Baselines[strings.ToLower(op.To)]; b != nil { b.Track, b.Artifact = op.Track, op.Artifact }
+        if op.Was == nil || !singleFile(*op.Was) || !singleFile(op.Artifact) { return nil }
+        moveArtifactState(d, op.WasTrack, *op.Was, op.Track, op.Artifact)
+        return nil
+    })
+}
+
+// moveArtifactState re-points store entries left on one artifact to another.
+func moveArtifactState(d *storeData, fromTrack string, from ArtifactRef, toTrack string, to ArtifactRef) {
+    moveRefs(d.Annotations, fromTrack, toTrack, func(a *Annotation) *ArtifactRef { return &a.Artifact }, from, to)
+    moveRefs(d.Tags, fromTrack, toTrack, func(a *ArtifactTag) *ArtifactRef { return &a.Artifact }, from, to)
+    moveRefs(d.Comments, fromTrack, toTrack, func(a *Comment) *ArtifactRef { return &a.Artifact }, from, to)
+    moveRefs(d.Ratings, fromTrack, toTrack, func(a *Rating) *ArtifactRef { return &a.Artifact }, from, to)
+    moveRefs(d.Versions, fromTrack, toTrack, func(a *VersionTag) *ArtifactRef { return &a.Artifact }, from, to)
+    moveRefs(d.Published, fromTrack, toTrack, func(a *Publication) *ArtifactRef { return &a.Artifact }, from, to)
+}
+
+// moveRefs moves the entries of m[fromTrack] whose artifact is from to
+// m[toTrack], addressed to to. Slices are rebuilt, never modified in place.
+func moveRefs[V any](m map[string][]V, fromTrack, toTrack string, ref func(*V) *ArtifactRef, from, to ArtifactRef) {
+    var keep, moved []V
+    for _, v := range m[fromTrack] {
+        if r := ref(&v); *r == from { *r = to; moved = append(moved, v); continue }
+        keep = append(keep, v)
+    }
+    if len(moved) == 0 { return }
+    m[fromTrack] = keep
+    m[toTrack] = append(append([]V(nil), m[toTrack]...), moved...)
+}
+
+// POST /api/files/rename {"path":"/Tracks/ENERGY/mixes/ENERGY-0430A-0702P-[unmastered].wav","to":"ENERGY-0430A-0720P-[unmastered].wav"}
+// Only renames to a name the convention accepts.
+func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    var req struct{ Path, To string }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+    s.writeMu.Lock()
+    op, err := s.planRename(r.Context(), req.Path, req.To)
+    if err == nil { err = s.applyRename(r.Context(), op) }
+    s.writeMu.Unlock()
+    if err != nil { writeError(w, err); return }
+    s.audit(r, "rename", op.Track, map[string]string{"path": op.From}, op)
+    if err := s.reindex(r.Context()); err != nil { http.Error(w, "renamed, but reindex failed: "+err.Error(), 500); return }
+    writeJSON(w, op)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func lockFile(path string) (unlock func(), err error) {
+    return nil, errors.New("SHARED_DIR needs file locks, which this system does not have")
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// lockFile takes an exclusive lock on the file at path, shared with other
+// processes and hosts that mount the same volume, waiting for it if need be.
+func lockFile(path string) (unlock func(), err error) {
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+    if err != nil { return nil, err }
+    if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil { f.Close(); return nil, err }
+    return func() { syscall.Flock(int(f.Fd()), syscall.LOCK_UN); f.Close() }, nil
+}
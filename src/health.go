@@ -0,0 +1,127 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "os"
+    "sync"
+    "time"
+)
+
+// ====== Health Checks ======
+//
+// GET /healthz answers as long as the process serves requests. GET /readyz
+// checks what the server depends on and answers 503 when any check fails,
+// so a load balancer stops sending requests to an instance that cannot
+// serve them:
+//
+//   dropbox  the access token is accepted (asked at most every healthProbe)
+//   storage  DATA_DIR, and SHARED_DIR with replicas, can be written
+//   index    the catalog was built or loaded, and when it last was; "stale"
+//            if the last reindex failed but an earlier one is being served
+//   jobs     no reindex or other job has been running for over jobStuck
+//
+// Each check reports its status, error, when it was checked and when it
+// last passed. Neither endpoint needs a key, and neither is logged but at
+// debug level.
+
+const (
+    healthProbe = 30 * time.Second
+    jobStuck    = 30 * time.Minute
+)
+
+// healthCheck is the state of one dependency.
+type healthCheck struct {
+    Status      string     `json:"status"` // ok, failing, stale
+    Error       string     `json:"error,omitempty"`
+    Checked     time.Time  `json:"checked"`
+    LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+type health struct {
+    mu     sync.Mutex
+    checks map[string]*healthCheck
+}
+
+// record sets the outcome of check name.
+func (h *health) record(name string, err error) {
+    h.mu.Lock(); defer h.mu.Unlock()
+    if h.checks == nil { h.checks = map[string]*healthCheck{} }
+    c := h.checks[name]
+    if c == nil { c = &healthCheck{}; h.checks[name] = c }
+    c.Checked = time.Now().UTC()
+    c.Status, c.Error = "ok", ""
+    if err != nil { c.Status, c.Error = "failing", err.Error(); return }
+    now := c.Checked
+    c.LastSuccess = &now
+}
+
+// get is a copy of check name; nil if it never ran.
+func (h *health) get(name string) *healthCheck {
+    h.mu.Lock(); defer h.mu.Unlock()
+    c := h.checks[name]
+    if c == nil { return nil }
+    cp := *c
+    return &cp
+}
+
+// checkDropbox asks Dropbox whose token this is, unless it did so lately.
+func (s *Server) checkDropbox(ctx context.Context) {
+    if c := s.health.get("dropbox"); c != nil && time.Since(c.Checked) < healthProbe { return }
+    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+    _, err := s.dbxRPC(ctx, "/2/users/get_current_account", nil)
+    s.health.record("dropbox", err)
+}
+
+// checkStorage writes and removes a file in each directory state is kept in.
+func (s *Server) checkStorage() {
+    dirs := []string{s.dataDir}
+    if s.cluster != nil { dirs = append(dirs, s.cluster.dir) }
+    for _, dir := range dirs {
+        f, err := os.CreateTemp(dir, ".readyz-*")
+        if err == nil {
+            _, err = f.WriteString("ok")
+            f.Close()
+            if rerr := os.Remove(f.Name()); err == nil { err = rerr }
+        }
+        if err != nil { s.health.record("storage", fmt.Errorf("%s: %w", dir, err)); return }
+    }
+    s.health.record("storage", nil)
+}
+
+// checkJobs fails while a job has been running for too long.
+func (s *Server) checkJobs() {
+    for _, j := range jobsRunning() {
+        if d := time.Since(j.Started); d > jobStuck {
+            s.health.record("jobs", fmt.Errorf("%s has been running for %s", j.ID, d.Round(time.Second)))
+            return
+        }
+    }
+    s.health.record("jobs", nil)
+}
+
+// GET /healthz
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Cache-Control", "no-store")
+    w.Write([]byte("ok\n"))
+}
+
+// GET /readyz
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+    s.checkDropbox(r.Context())
+    s.checkStorage()
+    s.checkJobs()
+    checks := map[string]*healthCheck{}
+    status, code := "ok", http.StatusOK
+    for _, name := range []string{"dropbox", "storage", "index", "jobs"} {
+        c := s.health.get(name)
+        if c == nil { c = &healthCheck{Status: "failing", Error: "not checked yet"} }
+        if name == "index" && c.Status == "failing" && c.LastSuccess != nil { c.Status = "stale" }
+        if c.Status == "failing" { status, code = "failing", http.StatusServiceUnavailable }
+        checks[name] = c
+    }
+    w.Header().Set("Cache-Control", "no-store")
+    writeJSONStatus(w, code, map[string]any{"status": status, "checks": checks})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "cmp"
+    "fmt"
+    "os"
+    "path"
+    "regexp"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ====== Ideas ======
+//
+// Most songs start as a phone voice memo or a rough recording of a jam,
+// long before the first session is saved. Those are a track's ideas: audio
+// files in one of its IDEA_FOLDERS (ideas, voice memos and memos, in any
+// case, anywhere in the track folder), or named by IDEA_PATTERN anywhere
+// under the root. The pattern is a regular expression over the file name
+// whose "track" group names the track; by default
+//
+//   <TRACK>-IDEA-<title>.<ext>     e.g. SLOW_BURN-IDEA-bassline.m4a
+//   <TRACK>-IDEA.<ext>
+//
+// Ideas are listed in the track's ideas, oldest first, each with when it was
+// recorded: the date and time in its name when it has them (as phones name
+// memos: "2024-03-01 14.22.10.m4a", "20240301_142210.m4a"), else when the
+// device last wrote it. They show in the track's changelog, but are not
+// versions: no manifest holds them and migration leaves them alone.
+
+var (
+    ideaExts = []string{"m4a", "wav", "mp3", "aif", "aiff", "caf", "aac", "3gp", "amr", "ogg", "flac"}
+
+    defaultIdeaPattern = `^` + reTrack + `-IDEA(?:-.+)?\.[A-Za-z0-9]+$`
+
+    // Dates phones write into recordings' names, in local time.
+    rxIdeaDate    = regexp.MustCompile(`([0-9]{4})-([0-9]{2})-([0-9]{2})(?:[ _T]([0-9]{2})[.:-]([0-9]{2})(?:[.:-]([0-9]{2}))?)?`)
+    rxIdeaCompact = regexp.MustCompile(`([0-9]{4})([0-9]{2})([0-9]{2})[_-]([0-9]{2})([0-9]{2})([0-9]{2})`)
+)
+
+// ideaRules say which files are ideas.
+type ideaRules struct {
+    folders []string       // lower case
+    pattern *regexp.Regexp // nil: none are named
+}
+
+// loadIdeas reads IDEA_FOLDERS and IDEA_PATTERN.
+func loadIdeas() (ideaRules, error) {
+    rules := ideaRules{folders: splitList(cmp.Or(os.Getenv("IDEA_FOLDERS"), "ideas,voice memos,memos"))}
+    rx, err := regexp.Compile(cmp.Or(os.Getenv("IDEA_PATTERN"), defaultIdeaPattern))
+    if err != nil { return rules, fmt.Errorf("IDEA_PATTERN: %w", err) }
+    if !slices.Contains(rx.SubexpNames(), "track") { return rules, fmt.Errorf("IDEA_PATTERN must name the track with (?P<track>...)") }
+    rules.pattern = rx
+    return rules, nil
+}
+
+// ideaTrack is the track an idea's name gives, if it has one.
+func (s *Server) ideaTrack(base string) (string, bool) {
+    if s.ideas.pattern == nil { return "", false }
+    g := rxGroups(s.ideas.pattern, base)
+    if g == nil || g["track"] == "" { return "", false }
+    return strings.ToUpper(g["track"]), true
+}
+
+// isIdea reports whether e is an idea.
+func (s *Server) isIdea(e *dbxEntry) bool {
+    if e.Tag != "file" || !slices.Contains(ideaExts, strings.TrimPrefix(path.Ext(e.lower()), ".")) { return false }
+    if _, ok := s.ideaTrack(path.Base(e.PathDisplay)); ok { return true }
+    dir := path.Dir(e.PathDisplay)
+    rel := strings.TrimPrefix(strings.ToLower(dir), strings.ToLower(s.trackDir(e.PathDisplay)))
+    if len(rel) == len(dir) { return false } // not in a track folder
+    return slices.ContainsFunc(strings.Split(rel, "/"), func(seg string) bool { return slices.Contains(s.ideas.folders, seg) })
+}
+
+// ideaOf is e as an idea, dated.
+func (s *Server) ideaOf(e *dbxEntry) Idea {
+    ref := fileRefOf(e)
+    ref.ContributedBy = s.contributorOf(e)
+    ref.Archived = s.inArchive(e.lower())
+    return Idea{FileRef: ref, Recorded: cmp.Or(recordedAt(ref.Name), e.ClientModified, e.ServerModified)}
+}
+
+// recordedAt is the date and time a recording's name gives; zero if none.
+func recordedAt(name string) time.Time {
+    m := rxIdeaCompact.FindStringSubmatch(name)
+    if m == nil { m = rxIdeaDate.FindStringSubmatch(name) }
+    if m == nil { return time.Time{} }
+    n := make([]int, 6)
+    for i := range n {
+        if i+1 < len(m) { n[i], _ = strconv.Atoi(m[i+1]) } // "": 0
+    }
+    t := time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], 0, time.Local)
+    if t.Month() != time.Month(n[1]) || t.Day() != n[2] || n[0] < 1990 { return time.Time{} } // not a date after all
+    return t
+}
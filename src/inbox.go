@@ -0,0 +1,153 @@
+package main
+
+import (
+    "cmp"
+    "context"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Inbox ======
+//
+// Anything dropped into the inbox folder (INBOX_ROOT, default /_inbox) is
+// named by suggestName and filed into its track's canonical folder. Files
+// whose name said everything needed are filed as soon as the index is
+// refreshed; the rest wait in a queue until someone confirms the proposed
+// name (or corrects it) or rejects the file into the trash. INBOX_CONFIRM=all
+// queues everything. A subfolder named after a track (/_inbox/ENERGY/...)
+// says which track its files belong to.
+
+type InboxItem = client.InboxItem
+
+func (s *Server) inInbox(p string) bool {
+    return strings.HasPrefix(strings.ToLower(p), strings.ToLower(strings.TrimSuffix(s.inboxRoot, "/"))+"/")
+}
+
+// inboxRequest is what the inbox knows about e for suggestName.
+func (s *Server) inboxRequest(e *dbxEntry) suggestReq {
+    req := suggestReq{Filename: e.Name, Kind: folderKind(e.PathDisplay), Time: e.ClientModified.Format(time.RFC3339)}
+    rel := strings.TrimPrefix(e.PathDisplay[len(strings.TrimSuffix(s.inboxRoot, "/")):], "/")
+    if dir, _, ok := strings.Cut(rel, "/"); ok && s.guessTrack(normalizedBase(e.Name)) == "" { req.Track = normalizedBase(dir) }
+    return req
+}
+
+// inboxItems lists the inbox with a proposed name for each file.
+func (s *Server) inboxItems(ctx context.Context) ([]InboxItem, error) {
+    entries, err := s.dbxListAll(ctx, s.inboxRoot)
+    if isNotFound(err) { return []InboxItem{}, nil }
+    if err != nil { return nil, err }
+    out := []InboxItem{}
+    for i := range entries {
+        e := &entries[i]
+        if e.Tag != "file" { continue }
+        it := InboxItem{Path: e.PathDisplay, Size: e.Size, Modified: e.ServerModified}
+        sg, err := s.suggestName(s.inboxRequest(e))
+        if err != nil { it.Error = err.Error() } else { it.Proposed = sg }
+        out = append(out, it)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Modified.Before(out[j].Modified) })
+    return out, nil
+}
+
+// fileFromInbox moves an inbox file to where the convention puts name. The
+// caller holds writeMu.
+func (s *Server) fileFromInbox(ctx context.Context, from, name string) (*uploadTarget, error) {
+    if !s.inInbox(from) { return nil, httpError{400, from + " is not in " + s.inboxRoot} }
+    ut, err := s.uploadTarget(name, false)
+    if err != nil { return nil, err }
+    if err := s.checkUploadTarget(ctx, ut.Path, false); err != nil { return nil, err }
+    if _, err := s.dbxMove(ctx, from, ut.Path); err != nil {
+        if isNotFound(err) { return nil, httpError{404, from + " is no longer in the inbox"} }
+        return nil, httpError{502, "move: " + err.Error()}
+    }
+    return ut, nil
+}
+
+// fileInbox files every inbox item whose proposed name needed no guesswork.
+// Like purgeTrash it runs in the background after a reindex, and reindexes
+// again if it filed anything.
+func (s *Server) fileInbox(ctx context.Context) {
+    if s.inboxConfirmAll { return }
+    items, err := s.inboxItems(ctx)
+    if err != nil { slog.ErrorContext(ctx, "reading inbox failed", "error", err); return }
+    filed := 0
+    s.writeMu.Lock()
+    for _, it := range items {
+        if it.Proposed == nil || len(it.Proposed.Assumed) > 0 { continue }
+        ut, err := s.fileFromInbox(ctx, it.Path, it.Proposed.Name)
+        if err != nil { slog.ErrorContext(ctx, "filing from inbox failed", "path", it.Path, "error", err); continue }
+        s.audit(nil, "ingest", ut.Track, map[string]string{"path": it.Path}, ut)
+        filed++
+    }
+    s.writeMu.Unlock()
+    if filed == 0 { return }
+    slog.InfoContext(ctx, "filed from the inbox", "files", filed)
+    if err := s.reindex(ctx); err != nil { slog.ErrorContext(ctx, "reindex after filing failed", "error", err) }
+}
+
+// GET  /api/inbox
+// POST /api/inbox/file   {"path":"/_inbox/bass take 2.wav"[,"name":"ENERGY-0430A-0720P-BASS.wav"]}
+// POST /api/inbox/file   {"path":"...","track":"ENERGY","kind":"stem","stem":"BASS",...}
+// POST /api/inbox/reject {"path":"..."}
+// file takes the proposed name, an explicit name, or a new proposal from the
+// suggest-name fields given; reject moves the file to the trash.
+func (s *Server) handleInbox(w http.ResponseWriter, r *http.Request) {
+    action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/inbox"), "/")
+    if action == "" {
+        if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+        items, err := s.inboxItems(r.Context())
+        if err != nil { http.Error(w, err.Error(), 502); return }
+        writeJSON(w, items)
+        return
+    }
+    if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    var req struct {
+        suggestReq
+        Name string `json:"name"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+    if !s.inInbox(req.Path) { http.Error(w, "path must be in "+s.inboxRoot, 400); return }
+    ctx := r.Context()
+
+    switch action {
+    case "file":
+        name := req.Name
+        if name == "" {
+            e, err := s.dbxMetadata(ctx, req.Path)
+            if isNotFound(err) { http.Error(w, req.Path+" not found", 404); return }
+            if err != nil { http.Error(w, err.Error(), 502); return }
+            sr := s.inboxRequest(e)
+            sr.Track, sr.Kind, sr.Time = cmp.Or(req.Track, sr.Track), cmp.Or(req.Kind, sr.Kind), cmp.Or(req.Time, sr.Time)
+            sr.TZ, sr.T1, sr.T2, sr.Stem, sr.Idx = req.TZ, req.T1, req.T2, req.Stem, req.Idx
+            sg, err := s.suggestName(sr)
+            if err != nil { writeError(w, err); return }
+            name = sg.Name
+        }
+        s.writeMu.Lock()
+        ut, err := s.fileFromInbox(ctx, req.Path, name)
+        s.writeMu.Unlock()
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "ingest", ut.Track, map[string]string{"path": req.Path}, ut)
+        if err := s.reindex(ctx); err != nil { http.Error(w, "filed, but reindex failed: "+err.Error(), 500); return }
+        writeJSON(w, ut)
+
+    case "reject":
+        item := TrashItem{ID: newID(), By: actorOf(r), Deleted: time.Now().UTC()}
+        item.Expires = item.Deleted.Add(s.trashGrace)
+        s.writeMu.Lock()
+        err := s.trashPaths(ctx, &item, []string{req.Path})
+        s.writeMu.Unlock()
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "delete", item.Track, nil, item)
+        writeJSON(w, item)
+
+    default:
+        http.NotFound(w, r)
+    }
+}
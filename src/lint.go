@@ -0,0 +1,394 @@
+package main
+
+import (
+    "cmp"
+    "context"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "regexp"
+    "slices"
+    "strings"
+    "text/tabwriter"
+
+    "avcs-browser/client"
+)
+
+// ====== CLI Lint ======
+//
+// avcs lint checks a local export folder before anything in it is uploaded,
+// with the rules /api/upload applies and a few it cannot:
+//
+//   avcs lint ./MIDNIGHT [--track MIDNIGHT] [--stems DRUMS,BASS,VOX] [--offline] [-v]
+//
+// Every .wav, .mp3 and session file under the folder gets a verdict:
+//
+//   ok          uploads as it is named (-v lists these, and where they go)
+//   uploaded    the server already has it, same content
+//   rename      breaks the convention in a way upload's fix=1 repairs
+//   rejected    breaks it beyond repair, is a Live backup or a Logic bundle,
+//               or uploads under the same name as another file here
+//   misfiled    is named for another track than the folder's (--track, else
+//               the folder's own name), or sits in a stems/, mixes/, masters/,
+//               ableton/ or stems/T1-T2/ folder that is not where it is filed
+//   exists      the server has another file by that name, which upload keeps
+//   incomplete  a stems set lacks stems: those --stems lists, else those of
+//               the track's latest set on the server
+//   mismatched  a stem's sample rate, bit depth or length differs from the
+//               rest of its set's, so it was not exported with them
+//
+// Any verdict but ok, uploaded and rename makes it exit non-zero. --offline
+// leaves the server out: aliases are not resolved, and without --stems
+// completeness is not checked.
+
+// rxStemsFolder is a stems set's folder name, T1-T2.
+var rxStemsFolder = regexp.MustCompile(`^[0-9]{4}[AP]-[0-9]{4}[AP]$`)
+
+// lintFinding is lint's verdict on one local file, or on a stems set.
+type lintFinding struct {
+    File    string `json:"file"` // relative to the folder; "TRACK T1-T2 stems" for a set
+    Verdict string `json:"verdict"`
+    Name    string `json:"name,omitempty"` // what it uploads as
+    Dest    string `json:"dest,omitempty"` // where the server files it, if it knows the track
+    Problem string `json:"problem,omitempty"`
+
+    local string    // the file itself
+    np    nameParts // of Name
+}
+
+// lintProblem reports whether a verdict has to be dealt with before upload.
+func lintProblem(verdict string) bool { return verdict != "ok" && verdict != "uploaded" && verdict != "rename" }
+
+// linter judges local files, asking the server what it needs to know.
+type linter struct {
+    c      *cliClient        // nil: offline
+    track  string            // the folder's track; empty: any
+    stems  []string          // the stems every set needs; nil: the server's latest set's
+    tracks map[string]*Track // fetched, by the name asked for; nil if the server has none
+}
+
+// remote is the server's track by name or alias; nil if it has none, or
+// lint is offline.
+func (l *linter) remote(name string) (*Track, error) {
+    if l.c == nil { return nil, nil }
+    if t, ok := l.tracks[name]; ok { return t, nil }
+    t, err := l.c.Track(context.Background(), name)
+    if client.IsNotFound(err) { t, err = nil, nil }
+    if err != nil { return nil, err }
+    l.tracks[name] = t
+    return t, nil
+}
+
+// lintWalk lists the files under dir that lint looks at: audio and session
+// files, and Logic bundles, outside hidden folders.
+func lintWalk(dir string) ([]string, error) {
+    var out []string
+    err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+        if err != nil { return err }
+        if p == dir { return nil }
+        if strings.HasPrefix(d.Name(), ".") {
+            if d.IsDir() { return filepath.SkipDir }
+            return nil
+        }
+        ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(d.Name())), ".")
+        if d.IsDir() {
+            if ext == "logicx" { out = append(out, p); return filepath.SkipDir }
+            return nil
+        }
+        if ext == "wav" || ext == "mp3" || sessionDAW[ext] != "" { out = append(out, p) }
+        return nil
+    })
+    return out, err
+}
+
+// lint judges files, which are under root, then the stems sets among them.
+// names has the name to upload a file as, if not its own (see avcs push).
+func (l *linter) lint(root string, files []string, names map[string]string) ([]lintFinding, error) {
+    var out []lintFinding
+    taken := map[string]string{} // upload name, lower-cased → the file uploading as it
+    for _, p := range files {
+        rel, err := filepath.Rel(root, p)
+        if err != nil { rel = p }
+        f := lintFinding{File: filepath.ToSlash(rel), Verdict: "ok", Name: names[p], local: p}
+        if err := l.judge(&f); err != nil { return nil, err }
+        if f.Name != "" && f.Verdict != "rejected" {
+            if other, ok := taken[strings.ToLower(f.Name)]; ok {
+                f.Verdict, f.Problem = "rejected", "uploads as "+f.Name+", as "+other+" does"
+            } else {
+                taken[strings.ToLower(f.Name)] = f.File
+            }
+        }
+        out = append(out, f)
+    }
+    return l.lintSets(out)
+}
+
+// judge gives f its verdict.
+func (l *linter) judge(f *lintFinding) error {
+    given := cmp.Or(f.Name, filepath.Base(f.local))
+    f.Name = given
+    np, ok := classifyName(given)
+    if given != filepath.Base(f.local) { f.Verdict, f.Problem = "rename", "uploads as "+f.Name }
+    if !ok {
+        f.Name = correctName(given)
+        if np, ok = classifyName(f.Name); !ok {
+            f.Name, f.Verdict, f.Problem = "", "rejected", "does not follow the naming convention"
+            return nil
+        }
+        f.Verdict, f.Problem = "rename", "uploads as "+f.Name
+    }
+    f.np = np
+    switch {
+    case np.Kind == kindBackup:
+        f.Verdict, f.Problem = "rejected", "a Live backup: backups are written by Live, not uploaded"
+        return nil
+    case np.Ext == "logicx":
+        f.Verdict, f.Problem = "rejected", "a Logic project: bundles sync with Dropbox instead"
+        return nil
+    }
+
+    parent, _, _ := strings.Cut(np.Track, ".")
+    t, err := l.remote(parent)
+    if err != nil { return err }
+    track := parent
+    if t != nil { track, f.Dest = t.Name, path.Join(t.Dir, layoutDir(np), f.Name) }
+    if l.track != "" && track != l.track {
+        f.Verdict, f.Problem = "misfiled", "named for "+np.Track+", not "+l.track
+        if track != parent { f.Problem = "named for " + np.Track + " (now " + track + "), not " + l.track }
+        return nil
+    }
+    dir := path.Dir(f.File)
+    kind := folderKind(f.File)
+    if slices.Contains(strings.Split(strings.ToLower(dir), "/"), "ableton") { kind = kindSnapshot }
+    switch {
+    case kind != "" && kind != np.Kind:
+        f.Verdict, f.Problem = "misfiled", "a "+np.Kind+" in "+dir+"/: it is filed in "+layoutDir(np)+"/"
+        return nil
+    case np.Kind == kindStem && rxStemsFolder.MatchString(strings.ToUpper(path.Base(dir))) && strings.ToUpper(path.Base(dir)) != np.T1+"-"+np.T2:
+        f.Verdict, f.Problem = "misfiled", "in "+dir+"/, but of the "+np.T1+"-"+np.T2+" stems set"
+        return nil
+    }
+
+    if np.Track != parent {
+        if t, err = l.remote(np.Track); err != nil { return err } // a branch is a track of its own
+    }
+    if t == nil { return nil }
+    for _, rf := range trackFiles(t) {
+        if !strings.EqualFold(rf.Name, f.Name) { continue }
+        same, err := sameContent(f.local, rf.FileRef)
+        if err != nil { return err }
+        switch {
+        case same: f.Verdict, f.Problem, f.Dest = "uploaded", "", rf.Path
+        case strings.EqualFold(rf.Path, f.Dest): f.Verdict, f.Problem = "exists", "the server has another "+f.Name+" at "+rf.Path
+        default: continue
+        }
+        return nil
+    }
+    return nil
+}
+
+// sameContent reports whether the local file at p is rf.
+func sameContent(p string, rf FileRef) (bool, error) {
+    fh, err := os.Open(p)
+    if err != nil { return false, err }
+    defer fh.Close()
+    st, err := fh.Stat()
+    if err != nil { return false, err }
+    if st.Size() != rf.Size || rf.ContentHash == "" { return st.Size() == rf.Size, nil }
+    h := newContentHasher()
+    if _, err := io.Copy(h, fh); err != nil { return false, err }
+    return h.Sum() == rf.ContentHash, nil
+}
+
+// lintSets adds a finding for every stems set in out that lacks stems, and
+// marks the stems that do not match the rest of their set.
+func (l *linter) lintSets(out []lintFinding) ([]lintFinding, error) {
+    sets := map[string][]int{} // "TRACK T1-T2" → indexes into out
+    var keys []string
+    for i, f := range out {
+        if f.np.Kind != kindStem || f.Verdict == "rejected" || f.Verdict == "misfiled" { continue }
+        k := f.np.Track + " " + f.np.T1 + "-" + f.np.T2
+        if sets[k] == nil { keys = append(keys, k) }
+        sets[k] = append(sets[k], i)
+    }
+    for _, k := range keys {
+        np := out[sets[k][0]].np
+        have := map[string]bool{}
+        for _, i := range sets[k] { have[out[i].np.Stem] = true }
+        want, from := l.stems, "--stems asks for"
+        t, err := l.remote(np.Track)
+        if err != nil { return nil, err }
+        if t != nil {
+            for _, st := range t.Stems {
+                if st.T1 != np.T1 || st.T2 != np.T2 { continue }
+                for _, f := range st.Stems { have[strings.TrimSuffix(f.Name, ".wav")] = true }
+            }
+            if want == nil {
+                if a, files, err := latestArtifact(t, "stems"); err == nil {
+                    from = "the " + a.T1 + "-" + a.T2 + " set has"
+                    for _, f := range files { want = append(want, strings.TrimSuffix(f.Name, ".wav")) }
+                }
+            }
+        }
+        var missing []string
+        for _, s := range want {
+            if !have[s] { missing = append(missing, s) }
+        }
+        if len(missing) > 0 {
+            out = append(out, lintFinding{File: k + " stems", Verdict: "incomplete", Problem: "no " + strings.Join(missing, ", ") + ", which " + from})
+        }
+        if err := lintStemFormats(out, sets[k]); err != nil { return nil, err }
+    }
+    return out, nil
+}
+
+// stemFormat is what has to agree across the stems of a set.
+type stemFormat struct {
+    rate, bits int
+    centis     int64 // length, in hundredths of a second
+}
+
+// lintStemFormats marks the stems among out[idx] whose sample rate, bit depth
+// or length is not that of most of the set.
+func lintStemFormats(out []lintFinding, idx []int) error {
+    formats := map[int]stemFormat{}
+    for _, i := range idx {
+        if lintProblem(out[i].Verdict) || out[i].Verdict == "uploaded" { continue }
+        fh, err := os.Open(out[i].local)
+        if err != nil { return err }
+        ws, err := readWAV(fh)
+        fh.Close()
+        if err != nil { out[i].Verdict, out[i].Problem = "mismatched", "unreadable WAV: "+err.Error(); continue }
+        formats[i] = stemFormat{ws.Rate, ws.Bits, ws.DataLen / int64(ws.BlockAlign) * 100 / int64(ws.Rate)}
+    }
+    if len(formats) < 2 { return nil }
+    // The most common value of each, the first stem's on a tie.
+    common := func(of func(stemFormat) int64) int64 {
+        counts, best := map[int64]int{}, int64(-1)
+        for _, i := range idx {
+            f, ok := formats[i]
+            if !ok { continue }
+            v := of(f)
+            if counts[v]++; best < 0 || counts[v] > counts[best] { best = v }
+        }
+        return best
+    }
+    rate, bits := common(func(f stemFormat) int64 { return int64(f.rate) }), common(func(f stemFormat) int64 { return int64(f.bits) })
+    length := common(func(f stemFormat) int64 { return f.centis })
+    for _, i := range idx {
+        f, ok := formats[i]
+        if !ok { continue }
+        switch {
+        case int64(f.rate) != rate || int64(f.bits) != bits:
+            out[i].Problem = fmt.Sprintf("%d Hz, %d-bit where the rest of the set is %d Hz, %d-bit", f.rate, f.bits, rate, bits)
+        case f.centis != length:
+            out[i].Problem = "lasts " + stemLength(f.centis) + " where the rest of the set lasts " + stemLength(length)
+        default:
+            continue
+        }
+        out[i].Verdict = "mismatched"
+    }
+    return nil
+}
+
+// stemLength formats hundredths of a second as m:ss.cc.
+func stemLength(centis int64) string {
+    return fmt.Sprintf("%d:%02d.%02d", centis/6000, centis/100%60, centis%100)
+}
+
+// cliLint is `avcs lint`.
+func cliLint(args []string) error {
+    fs := flag.NewFlagSet("lint", flag.ExitOnError)
+    connect := cliFlags(fs)
+    track := fs.String("track", "", "the folder's track (default: the folder's name, if it is a track name)")
+    stems := fs.String("stems", "", "the stems every set needs, as DRUMS,BASS,VOX (default: the track's latest set's on the server)")
+    offline := fs.Bool("offline", false, "do not ask the server")
+    verbose := fs.Bool("v", false, "list the files that pass too")
+    asJSON := fs.Bool("json", false, "print the verdicts as JSON")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs lint <folder> [flags]")
+        fs.PrintDefaults()
+    }
+    operands := parseCLI(fs, args)
+    if len(operands) != 1 { fs.Usage(); os.Exit(2) }
+    root := operands[0]
+    if st, err := os.Stat(root); err != nil {
+        return err
+    } else if !st.IsDir() {
+        return fmt.Errorf("%s is not a folder", root)
+    }
+
+    l := &linter{track: strings.ToUpper(strings.TrimSpace(*track)), stems: splitStems(*stems), tracks: map[string]*Track{}}
+    if l.track == "" {
+        abs, _ := filepath.Abs(root)
+        if base := strings.TrimSuffix(strings.ToUpper(filepath.Base(abs)), " PROJECT"); rxTrackName.MatchString(base) { l.track = base }
+    }
+    if !*offline {
+        c, err := connect()
+        if err != nil { return err }
+        l.c = c
+        if l.track != "" {
+            t, err := l.remote(l.track)
+            if err != nil { return err }
+            if t != nil {
+                l.track = t.Name
+            } else {
+                fmt.Fprintf(os.Stderr, "%s is not on %s yet: its files will start a new track folder\n", l.track, c.Server)
+            }
+        }
+    }
+
+    files, err := lintWalk(root)
+    if err != nil { return err }
+    found, err := l.lint(root, files, nil)
+    if err != nil { return err }
+
+    problems := 0
+    for _, f := range found {
+        if lintProblem(f.Verdict) { problems++ }
+    }
+    if *asJSON {
+        if err := printJSON(found); err != nil { return err }
+    } else if err := printFindings(found, len(files), *verbose); err != nil {
+        return err
+    }
+    if problems > 0 { return fmt.Errorf("%d to fix before upload", problems) }
+    return nil
+}
+
+// splitStems reads a --stems list, DRUMS,BASS,VOX.
+func splitStems(list string) []string {
+    var out []string
+    for _, s := range strings.Split(list, ",") {
+        if s = strings.ToUpper(strings.TrimSpace(s)); s != "" { out = append(out, s) }
+    }
+    return out
+}
+
+// printFindings prints the verdicts other than ok and uploaded, or all of
+// them if verbose, and a count of each for the n files judged.
+func printFindings(found []lintFinding, n int, verbose bool) error {
+    counts := map[string]int{}
+    tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    for _, f := range found {
+        counts[f.Verdict]++
+        detail := f.Problem
+        switch {
+        case f.Verdict == "ok" && verbose: detail = "→ " + cmp.Or(f.Dest, path.Join(layoutDir(f.np), f.Name))
+        case f.Verdict == "uploaded" && verbose: detail = "at " + f.Dest
+        case f.Verdict == "ok" || f.Verdict == "uploaded": continue
+        }
+        fmt.Fprintf(tw, "%s\t%s\t%s\n", strings.ToUpper(f.Verdict), f.File, detail)
+    }
+    if err := tw.Flush(); err != nil { return err }
+    var summary []string
+    for _, v := range []string{"ok", "uploaded", "rename", "rejected", "misfiled", "exists", "incomplete", "mismatched"} {
+        if counts[v] > 0 { summary = append(summary, fmt.Sprintf("%d %s", counts[v], v)) }
+    }
+    if len(summary) == 0 { summary = []string{"nothing to upload"} }
+    fmt.Printf("%d file%s: %s\n", n, plural(n), strings.Join(summary, ", "))
+    return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ====== Incremental Listing ======
+//
+// Listing a big library in full takes minutes, and the index is rebuilt
+// after every upload, rename and promotion. So the server keeps each root's
+// listing between reindexes, with the cursor Dropbox gave for it, and a
+// reindex only asks what changed since (list_folder/continue) and applies
+// that: changed and new entries replace theirs, deleted ones go with
+// everything under them.
+//
+// The roots are listed in full the first time, when Dropbox has expired a
+// cursor (after a while unused, or a restore on its side), on POST
+// /api/reindex?full=1, and every FULL_RELIST_INTERVAL (24h; in a maintenance
+// window when there are some; 0 for every reindex) to reconcile: entries
+// the deltas missed count as drift, which should stay at zero. /api/metrics
+// counts refreshes by kind, the changes they applied and the drift found.
+
+const defaultFullRelist = 24 * time.Hour
+
+// listing is a root's entries as last listed, and where to continue from.
+type listing struct {
+    entries map[string]dbxEntry // key: lower-case path
+    cursor  string
+    full    time.Time // when last listed in full
+}
+
+func init() {
+    metricHelp["avcs_index_refreshes_total"] = "Listings of a root for a reindex, by kind: delta, or full with the reason (first, reset, reconcile, requested)."
+    metricHelp["avcs_index_delta_entries_total"] = "Changes applied from cursor deltas, by change: changed or deleted."
+    metricHelp["avcs_index_drift_entries_total"] = "Entries a full relist found the deltas had missed, by change: added, changed or removed."
+}
+
+// listEntries is everything under root as it is now, in path order, from
+// the changes since the last listing unless full or one is due.
+func (s *Server) listEntries(ctx context.Context, root string, full bool) ([]dbxEntry, error) {
+    s.listMu.Lock(); defer s.listMu.Unlock()
+    if s.listings == nil { s.listings = map[string]*listing{} }
+    l := s.listings[root]
+    reason := ""
+    switch {
+    case l == nil: reason = "first"
+    case full: reason = "requested"
+    case time.Since(l.full) >= s.fullRelist && s.maintenanceOpen(ctx, "full relist"): reason = "reconcile"
+    }
+    if reason == "" {
+        changes, cursor, err := s.dbxListContinue(ctx, l.cursor)
+        switch {
+        case isCursorReset(err): reason = "reset"
+        case err != nil: return nil, err
+        default:
+            s.applyChanges(l, changes)
+            l.cursor = cursor
+            s.metrics.add("avcs_index_refreshes_total", 1, "kind", "delta")
+        }
+    }
+    if reason != "" {
+        entries, cursor, err := s.dbxList(ctx, root)
+        if isNotFound(err) { delete(s.listings, root) } // none to keep up with
+        if err != nil { return nil, err }
+        fresh := &listing{entries: make(map[string]dbxEntry, len(entries)), cursor: cursor, full: time.Now()}
+        for _, e := range entries { fresh.entries[e.lower()] = e }
+        if l != nil && reason != "first" { s.countDrift(ctx, root, l, fresh) }
+        l = fresh
+        s.listings[root] = l
+        s.metrics.add("avcs_index_refreshes_total", 1, "kind", "full", "reason", reason)
+    }
+
+    out := make([]dbxEntry, 0, len(l.entries))
+    for _, e := range l.entries { out = append(out, e) }
+    sort.Slice(out, func(i, j int) bool { return out[i].lower() < out[j].lower() })
+    return out, nil
+}
+
+// applyChanges applies a delta to l.
+func (s *Server) applyChanges(l *listing, changes []dbxEntry) {
+    for _, e := range changes {
+        k := e.lower()
+        if e.Tag != "deleted" {
+            l.entries[k] = e
+            s.metrics.add("avcs_index_delta_entries_total", 1, "change", "changed")
+            continue
+        }
+        // A deleted folder's contents need not be listed as deleted too.
+        if l.entries[k].Tag == "folder" {
+            for p := range l.entries {
+                if strings.HasPrefix(p, k+"/") { delete(l.entries, p) }
+            }
+        }
+        delete(l.entries, k)
+        s.metrics.add("avcs_index_delta_entries_total", 1, "change", "deleted")
+    }
+}
+
+// countDrift counts the differences between l, kept up to date by deltas,
+// and fresh, listed in full.
+func (s *Server) countDrift(ctx context.Context, root string, l, fresh *listing) {
+    drift := map[string]int{}
+    for k, e := range fresh.entries {
+        old, ok := l.entries[k]
+        switch {
+        case !ok: drift["added"]++
+        case old.Tag != e.Tag || old.PathDisplay != e.PathDisplay || old.Size != e.Size || old.ContentHash != e.ContentHash || !old.ServerModified.Equal(e.ServerModified): drift["changed"]++
+        }
+    }
+    for k := range l.entries {
+        if _, ok := fresh.entries[k]; !ok { drift["removed"]++ }
+    }
+    for change, n := range drift { s.metrics.add("avcs_index_drift_entries_total", float64(n), "change", change) }
+    if len(drift) > 0 { slog.WarnContext(ctx, "full relist found changes the deltas missed", "root", root, "added", drift["added"], "changed", drift["changed"], "removed", drift["removed"]) }
+}
+
+// isCursorReset reports whether Dropbox has expired a listing's cursor.
+func isCursorReset(err error) bool { return err != nil && strings.Contains(err.Error(), "reset") }
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Advisory Locks ======
+
+const (
+    defaultLockTTL = 4 * time.Hour
+    maxLockTTL     = 7 * 24 * time.Hour
+)
+
+type Lock = client.Lock
+
+// locksOverlap reports whether two locks cover the same session.
+func locksOverlap(l, o Lock) bool { return l.T1 == "" || o.T1 == "" || l.T1 == o.T1 }
+
+func liveLocks(list []Lock, now time.Time) []Lock {
+    out := []Lock{}
+    for _, l := range list {
+        if now.Before(l.Expires) { out = append(out, l) }
+    }
+    return out
+}
+
+func (s *Server) locksFor(track string) []Lock {
+    var out []Lock
+    s.store.view(func(d *storeData) { out = liveLocks(d.Locks[track], time.Now()) })
+    return out
+}
+
+// GET    /api/tracks/{name}/lock
+// POST   /api/tracks/{name}/lock {"t1":"0430A","ttl":"2h","note":"..."}
+// DELETE /api/tracks/{name}/lock[?t1=][&force=1]
+//
+// A lock is always the caller's (actorOf); only force breaks another's.
+func (s *Server) handleLock(w http.ResponseWriter, r *http.Request, t *Track) {
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, s.locksFor(t.Name))
+
+    case http.MethodPost:
+        var req struct {
+            T1   string `json:"t1"`
+            TTL  string `json:"ttl"`
+            Note string `json:"note"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        if req.T1 != "" && findSnap(t, req.T1) == nil { http.Error(w, "snapshot not found", 404); return }
+        ttl := defaultLockTTL
+        if req.TTL != "" {
+            d, err := time.ParseDuration(req.TTL)
+            if err != nil || d <= 0 || d > maxLockTTL { http.Error(w, "bad ttl", 400); return }
+            ttl = d
+        }
+        owner := actorOf(r)
+        now := time.Now().UTC()
+        l := Lock{T1: req.T1, Owner: owner, Note: strings.TrimSpace(req.Note), Acquired: now, Expires: now.Add(ttl)}
+        err := s.store.update(func(d *storeData) error {
+            list := liveLocks(d.Locks[t.Name], now)
+            kept := list[:0]
+            for _, o := range list {
+                if !locksOverlap(o, l) { kept = append(kept, o); continue }
+                if o.Owner != owner {
+                    return httpError{409, fmt.Sprintf("locked by %s until %s", o.Owner, o.Expires.Format(time.RFC3339))}
+                }
+                if o.T1 == l.T1 { // same owner renews
+                    l.Acquired = o.Acquired
+                    if l.Note == "" { l.Note = o.Note }
+                    continue
+                }
+                kept = append(kept, o)
+            }
+            if d.Locks == nil { d.Locks = map[string][]Lock{} }
+            d.Locks[t.Name] = append(kept, l)
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "lock", t.Name, nil, l)
+        writeJSON(w, l)
+
+    case http.MethodDelete:
+        t1 := r.URL.Query().Get("t1")
+        force := r.URL.Query().Get("force") != ""
+        owner := actorOf(r)
+        var released []Lock
+        err := s.store.update(func(d *storeData) error {
+            list := liveLocks(d.Locks[t.Name], time.Now())
+            kept := list[:0]
+            for _, o := range list {
+                if o.T1 != t1 { kept = append(kept, o); continue }
+                if o.Owner != owner && !force {
+                    return httpError{403, "lock held by " + o.Owner + "; use force=1 to break it"}
+                }
+                released = append(released, o)
+            }
+            if len(released) == 0 { return httpError{404, "no such lock"} }
+            d.Locks[t.Name] = kept
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        for _, l := range released { s.audit(r, "unlock", t.Name, l, nil) }
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "GET, POST or DELETE required", 405)
+    }
+}
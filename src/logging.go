@@ -0,0 +1,240 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "os"
+    "os/signal"
+    "regexp"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "syscall"
+    "time"
+)
+
+// ====== Logging ======
+//
+// Logs are structured, through log/slog: JSON lines by default, or
+// LOG_FORMAT=text for people reading a terminal, at LOG_LEVEL (info; debug
+// adds every Dropbox call). Each request gets an ID, taken from X-Request-ID
+// when a proxy set a sane one and echoed back in that header, which is logged
+// with everything done for the request, Dropbox calls included. Work that
+// outlives a request, such as a reindex and what it sets off, logs under a
+// job ID of its own plus the ID of the request that started it, so a run can
+// be traced back to whoever asked for it.
+//
+// Admins can change the level while the server runs with PUT /api/logging,
+// and turn on tracing of Dropbox calls, which logs their request and
+// response bodies (file contents and tokens left out); "for" undoes both
+// after a while, so debugging a session cannot be forgotten on. SIGHUP
+// toggles debug logging for those at the console.
+
+var (
+    logLevel     = new(slog.LevelVar)
+    logBase      slog.Level  // the configured level, restored by reverts
+    traceDropbox atomic.Bool // log Dropbox request and response bodies
+
+    logMu     sync.Mutex
+    logUntil  time.Time   // when the runtime settings revert; zero: never
+    logRevert *time.Timer
+)
+
+const (
+    ctxRequestID ctxKey = "request_id"
+    ctxJob       ctxKey = "job"
+    ctxTrigger   ctxKey = "trigger" // request that started the job
+)
+
+var rxRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// setupLogging installs the slog handler as the default logger, which the
+// log package then writes through too.
+func setupLogging() error {
+    if v := os.Getenv("LOG_LEVEL"); v != "" {
+        if err := logLevel.UnmarshalText([]byte(v)); err != nil { return fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, not %q", v) }
+    }
+    logBase = logLevel.Level()
+    opts := &slog.HandlerOptions{Level: logLevel}
+    var h slog.Handler
+    switch strings.ToLower(os.Getenv("LOG_FORMAT")) {
+    case "", "json": h = slog.NewJSONHandler(os.Stderr, opts)
+    case "text": h = slog.NewTextHandler(os.Stderr, opts)
+    default: return fmt.Errorf("LOG_FORMAT must be json or text, not %q", os.Getenv("LOG_FORMAT"))
+    }
+    slog.SetDefault(slog.New(ctxHandler{h}))
+    return nil
+}
+
+// ctxHandler adds the request and job IDs carried by the context to each record.
+type ctxHandler struct{ slog.Handler }
+
+func (h ctxHandler) Handle(ctx context.Context, r slog.Record) error {
+    for _, k := range []ctxKey{ctxRequestID, ctxJob, ctxTrigger} {
+        if v, _ := ctx.Value(k).(string); v != "" { r.AddAttrs(slog.String(string(k), v)) }
+    }
+    return h.Handler.Handle(ctx, r)
+}
+
+func (h ctxHandler) WithAttrs(as []slog.Attr) slog.Handler { return ctxHandler{h.Handler.WithAttrs(as)} }
+func (h ctxHandler) WithGroup(name string) slog.Handler   { return ctxHandler{h.Handler.WithGroup(name)} }
+
+func newLogID() string {
+    b := make([]byte, 8)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// requestID is the ID of the request ctx belongs to; "" if none.
+func requestID(ctx context.Context) string {
+    id, _ := ctx.Value(ctxRequestID).(string)
+    return id
+}
+
+// startJob is the context for one run of background work named kind, such
+// as a reindex: its records carry a run ID of their own and the ID of the
+// request that set it off, directly or through another job. Work that
+// outlives the request should detach ctx first (context.WithoutCancel).
+func startJob(ctx context.Context, kind string) context.Context {
+    trigger := requestID(ctx)
+    if trigger == "" { trigger, _ = ctx.Value(ctxTrigger).(string) }
+    ctx = context.WithValue(ctx, ctxRequestID, "")
+    ctx = context.WithValue(ctx, ctxJob, kind+"-"+newLogID())
+    return context.WithValue(ctx, ctxTrigger, trigger)
+}
+
+// logDropbox records one Dropbox API call: at debug level when it worked,
+// as a warning when it did not.
+func logDropbox(ctx context.Context, endpoint string, start time.Time, status int, err error) {
+    attrs := []any{"endpoint", endpoint, "status", status, "duration_ms", time.Since(start).Milliseconds()}
+    if err != nil {
+        slog.WarnContext(ctx, "dropbox call failed", append(attrs, "error", err)...)
+        return
+    }
+    slog.DebugContext(ctx, "dropbox call", attrs...)
+}
+
+// traceDropboxBody logs what went to and came back from a Dropbox endpoint,
+// when tracing is on, whatever the level.
+func traceDropboxBody(ctx context.Context, endpoint string, sent any, got []byte) {
+    if !traceDropbox.Load() { return }
+    out, _ := json.Marshal(sent)
+    slog.InfoContext(ctx, "dropbox trace", "endpoint", endpoint, "request", truncate(string(out), 4096), "response", truncate(string(got), 4096))
+}
+
+// loggingState is the runtime log settings as shown to admins.
+func loggingState() map[string]any {
+    logMu.Lock(); defer logMu.Unlock()
+    out := map[string]any{"level": strings.ToLower(logLevel.Level().String()), "configured": strings.ToLower(logBase.String()), "trace_dropbox": traceDropbox.Load()}
+    if !logUntil.IsZero() { out["until"] = logUntil }
+    return out
+}
+
+// setLogging changes the level and tracing, until the configured settings
+// come back after d (never, if d is 0).
+func setLogging(level slog.Level, trace bool, d time.Duration) {
+    logMu.Lock(); defer logMu.Unlock()
+    logLevel.Set(level)
+    traceDropbox.Store(trace)
+    if logRevert != nil { logRevert.Stop(); logRevert = nil }
+    logUntil = time.Time{}
+    if d > 0 {
+        logUntil = time.Now().Add(d).UTC()
+        logRevert = time.AfterFunc(d, func() {
+            setLogging(logBase, false, 0)
+            slog.Info("runtime log settings reverted", "level", logBase.String())
+        })
+    }
+}
+
+// watchHangup toggles debug logging on SIGHUP.
+func watchHangup() {
+    ch := make(chan os.Signal, 1)
+    signal.Notify(ch, syscall.SIGHUP)
+    for range ch {
+        level := slog.LevelDebug
+        if logLevel.Level() == slog.LevelDebug { level = max(logBase, slog.LevelInfo) }
+        setLogging(level, traceDropbox.Load(), 0)
+        slog.Info("SIGHUP: log level changed", "level", level.String())
+    }
+}
+
+// GET /api/logging
+// PUT /api/logging {"level":"debug"[,"trace_dropbox":true][,"for":"30m"]}
+// Without "level" the current one is kept; "for" reverts to the configured
+// level, with tracing off, once it has passed.
+func (s *Server) handleLogging(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, loggingState())
+    case http.MethodPut:
+        var req struct {
+            Level        string `json:"level"`
+            TraceDropbox bool   `json:"trace_dropbox"`
+            For          string `json:"for"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        level := logLevel.Level()
+        if req.Level != "" {
+            if err := level.UnmarshalText([]byte(req.Level)); err != nil { http.Error(w, "level must be debug, info, warn or error", 400); return }
+        }
+        var d time.Duration
+        if req.For != "" {
+            var err error
+            if d, err = time.ParseDuration(req.For); err != nil || d <= 0 { http.Error(w, "for must be a duration like 30m", 400); return }
+        }
+        before := loggingState()
+        setLogging(level, req.TraceDropbox, d)
+        after := loggingState()
+        slog.InfoContext(r.Context(), "log settings changed", "level", level.String(), "trace_dropbox", req.TraceDropbox, "for", req.For)
+        s.audit(r, "logging", "", before, after)
+        writeJSON(w, after)
+    default:
+        http.Error(w, "GET or PUT required", 405)
+    }
+}
+
+// statusWriter records the status and size of a response.
+type statusWriter struct {
+    http.ResponseWriter
+    status int
+    bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+    if w.status == 0 { w.status = code }
+    w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+    if w.status == 0 { w.status = http.StatusOK }
+    n, err := w.ResponseWriter.Write(b)
+    w.bytes += int64(n)
+    return n, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// logRequests gives each request its ID and logs it once answered.
+func logRequests(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        t := time.Now()
+        id := r.Header.Get("X-Request-ID")
+        if !rxRequestID.MatchString(id) { id = newLogID() }
+        w.Header().Set("X-Request-ID", id)
+        r = r.WithContext(context.WithValue(r.Context(), ctxRequestID, id))
+        sw := &statusWriter{ResponseWriter: w}
+        next.ServeHTTP(sw, r)
+        status := sw.status
+        if status == 0 { status = http.StatusOK }
+        level := slog.LevelInfo
+        if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" { level = slog.LevelDebug } // probes
+        slog.Log(r.Context(), level, "request", "method", r.Method, "path", r.URL.Path, "status", status,
+            "bytes", sw.bytes, "duration_ms", time.Since(t).Milliseconds())
+    })
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+    "cmp"
+    "context"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "path"
+    "regexp"
+    "slices"
+    "strconv"
+    "strings"
+)
+
+// ====== Lyrics ======
+//
+// Lyrics are text (.txt) or Markdown (.md) files kept with a track: any in a
+// lyrics/ folder anywhere in its track folder, or named with the LYRICS
+// suffix anywhere under the root:
+//
+//   <TRACK>-LYRICS.<ext>              e.g. NEON_RAIN-LYRICS.txt
+//   <TRACK>-<T1>-LYRICS.<ext>         as sung in snapshot T1
+//   <TRACK>-LYRICS-<title>.<ext>
+//
+// Like references, a named file belongs to the track (or branch) its name
+// gives, one in a folder to the track whose folder it is and its branches.
+// Each file is a revision, and revisions are versions like any other: they
+// are listed in the track's lyrics oldest first (by when they were written),
+// the last being the current lyrics, and are put in manifests, baselines and
+// the changelog. GET /api/tracks/{name}/lyrics reads the current revision,
+// ?rev=N (1 is the first) an earlier one, with how many lines it added and
+// removed; GET /api/search finds words in them. What is read is cached by
+// path and content.
+
+const lyricsLimit = 256 << 10 // bytes of a lyrics file read
+
+var (
+    lyricsExts = []string{"txt", "md"}
+    reLyrics   = regexp.MustCompile(`^` + reTrack + `(?:-(?P<t1>[0-9]{4}[AP]))?-LYRICS(?:-(?P<title>.+))?\.(?P<ext>[A-Za-z0-9]+)$`)
+)
+
+// lyricsTrack is the track a lyrics file's name gives, if it has one, and
+// the snapshot it was written for.
+func lyricsTrack(base string) (track, t1 string, ok bool) {
+    g := rxGroups(reLyrics, base)
+    if g == nil || !slices.Contains(lyricsExts, strings.ToLower(g["ext"])) { return "", "", false }
+    return g["track"], g["t1"], true
+}
+
+// isLyrics reports whether e is a lyrics file.
+func (s *Server) isLyrics(e *dbxEntry) bool {
+    if e.Tag != "file" || !slices.Contains(lyricsExts, strings.TrimPrefix(path.Ext(e.lower()), ".")) { return false }
+    if _, _, ok := lyricsTrack(path.Base(e.PathDisplay)); ok { return true }
+    dir := path.Dir(e.PathDisplay)
+    rel := strings.TrimPrefix(strings.ToLower(dir), strings.ToLower(s.trackDir(e.PathDisplay)))
+    if len(rel) == len(dir) { return false } // not in a track folder
+    return slices.Contains(strings.Split(rel, "/"), "lyrics")
+}
+
+// lyricOf is e as a revision of lyrics.
+func (s *Server) lyricOf(e *dbxEntry) Lyric {
+    ref := fileRefOf(e)
+    ref.ContributedBy = s.contributorOf(e)
+    ref.Archived = s.inArchive(e.lower())
+    _, t1, _ := lyricsTrack(ref.Name)
+    return Lyric{FileRef: ref, T1: t1, Written: cmp.Or(e.ClientModified, e.ServerModified)}
+}
+
+// lyricsText reads the lyrics file ref, caching by path and content.
+func (s *Server) lyricsText(ctx context.Context, ref *FileRef) (string, error) {
+    key := strings.ToLower(ref.Path) + "@" + ref.ContentHash
+    s.alsMu.Lock(); text, ok := s.lyricsCache[key]; s.alsMu.Unlock()
+    if ok { return text, nil }
+    body, err := s.dbxDownload(ctx, ref.Path)
+    if err != nil { return "", err }
+    defer body.Close()
+    b, err := io.ReadAll(io.LimitReader(body, lyricsLimit))
+    if err != nil { return "", err }
+    text = strings.ReplaceAll(strings.TrimPrefix(string(b), "\ufeff"), "\r\n", "\n")
+    s.alsMu.Lock(); cachePut(s.lyricsCache, key, text); s.alsMu.Unlock()
+    return text, nil
+}
+
+// readLyrics reads every revision of lyrics in tracks ahead of searches.
+func (s *Server) readLyrics(ctx context.Context, tracks map[string]*Track) {
+    seen := map[string]bool{}
+    for _, t := range tracks {
+        for i := range t.Lyrics {
+            ref := &t.Lyrics[i].FileRef
+            if seen[ref.Path] { continue } // shared with branches
+            seen[ref.Path] = true
+            if _, err := s.lyricsText(ctx, ref); err != nil { slog.WarnContext(ctx, "lyrics could not be read", "path", ref.Path, "error", err) }
+            if ctx.Err() != nil { return }
+        }
+    }
+}
+
+// lineChanges counts the lines added and removed from before to after,
+// whatever their order.
+func lineChanges(before, after string) (added, removed int) {
+    count := map[string]int{}
+    for _, l := range strings.Split(before, "\n") {
+        if l = strings.TrimSpace(l); l != "" { count[l]++ }
+    }
+    for _, l := range strings.Split(after, "\n") {
+        if l = strings.TrimSpace(l); l == "" { continue }
+        if count[l] > 0 { count[l]-- } else { added++ }
+    }
+    for _, n := range count { removed += n }
+    return added, removed
+}
+
+// GET /api/tracks/{name}/lyrics[?rev=N][&archived=1]
+func (s *Server) handleLyrics(w http.ResponseWriter, r *http.Request, t *Track) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    revs := t.Lyrics
+    if !t.Archived && r.URL.Query().Get("archived") == "" {
+        revs = slices.DeleteFunc(slices.Clone(revs), func(l Lyric) bool { return l.Archived })
+    }
+    if len(revs) == 0 { http.Error(w, "no lyrics", 404); return }
+    n := len(revs)
+    if v := r.URL.Query().Get("rev"); v != "" {
+        var err error
+        if n, err = strconv.Atoi(v); err != nil || n < 1 || n > len(revs) { http.Error(w, fmt.Sprintf("rev must be 1 to %d", len(revs)), 400); return }
+    }
+    type revision struct {
+        Revision int `json:"revision"`
+        Lyric
+    }
+    list := make([]revision, len(revs))
+    for i, l := range revs { list[i] = revision{i + 1, l} }
+    cur := &revs[n-1]
+    text, err := s.lyricsText(r.Context(), &cur.FileRef)
+    if err != nil { http.Error(w, err.Error(), 502); return }
+    out := map[string]any{"track": t.Name, "revision": n, "current": n == len(revs), "lyric": cur, "text": text, "revisions": list}
+    if n > 1 {
+        prev, err := s.lyricsText(r.Context(), &revs[n-2].FileRef)
+        if err != nil { http.Error(w, err.Error(), 502); return }
+        added, removed := lineChanges(prev, text)
+        out["added"], out["removed"] = added, removed
+    }
+    writeJSON(w, out)
+}
@@ -2,20 +2,35 @@ package main
 
 import (
     "bytes"
+    "cmp"
     "context"
+    "crypto/ed25519"
+    "crypto/sha256"
     "embed"
+    "encoding/hex"
     "encoding/json"
     "errors"
+    "flag"
     "fmt"
+    "hash"
+    "io"
     "log"
+    "log/slog"
+    "maps"
     "net/http"
     "os"
     "path"
+    "path/filepath"
     "regexp"
+    "slices"
     "sort"
+    "strconv"
     "strings"
     "sync"
+    "sync/atomic"
     "time"
+
+    "avcs-browser/client"
 )
 
 //go:embed web/*
@@ -32,6 +47,10 @@ type dbxEntry struct {
     ClientModified time.Time `json:"client_modified"`
     ServerModified time.Time `json:"server_modified"`
     Size           int64     `json:"size"`
+    ContentHash    string    `json:"content_hash"`
+    SharingInfo    *struct {
+        ModifiedBy string `json:"modified_by"` // account ID, files in shared folders only
+    } `json:"sharing_info,omitempty"`
 }
 
 type dbxListResp struct {
@@ -47,105 +66,444 @@ type dbxTempLinkResp struct {
 
 // ====== AVCS Parsing ======
 
-// TRACK: [A-Z0-9_]+
+// TRACK: [A-Z0-9_]+, optionally .BRANCH ([A-Z0-9_]+) for alternate versions
 // T1/T2: 4 digits + A|P (HHMM A/P)
 // STEM: [A-Z0-9_]+
 
+const reTrack = `(?P<track>[A-Z0-9_]+(?:\.[A-Z0-9_]+)?)`
+
 var (
-    reAbleton  = regexp.MustCompile(`^(?P<track>[A-Z0-9_]+)-(?P<t1>[0-9]{4}[AP])\.(?P<ext>als|wav|mp3)$`)
-    reStems    = regexp.MustCompile(`^(?P<track>[A-Z0-9_]+)-(?P<t1>[0-9]{4}[AP])-(?P<t2>[0-9]{4}[AP])-(?P<stem>[A-Z0-9_]+)\.wav$`)
-    reUnmaster = regexp.MustCompile(`^(?P<track>[A-Z0-9_]+)-(?P<t1>[0-9]{4}[AP])-(?P<t2>[0-9]{4}[AP])-\[unmastered\]\.wav$`)
-    reMaster   = regexp.MustCompile(`^(?P<track>[A-Z0-9_]+)-(?P<t1>[0-9]{4}[AP])-(?P<t2>[0-9]{4}[AP])-(?P<idx>FINAL|[1-9][0-9]*)\.wav$`)
+    reSnapshot = regexp.MustCompile(`^` + reTrack + `-(?P<t1>[0-9]{4}[AP])\.(?P<ext>als|logicx|ptx|rpp|flp|bwproject|wav|mp3)$`)
+    reStems    = regexp.MustCompile(`^` + reTrack + `-(?P<t1>[0-9]{4}[AP])-(?P<t2>[0-9]{4}[AP])-(?P<stem>[A-Z0-9_]+)\.wav$`)
+    rePrint    = regexp.MustCompile(`^` + reTrack + `-(?P<t1>[0-9]{4}[AP])-(?P<t2>[0-9]{4}[AP])-PRINT_(?P<chain>[A-Z0-9_]+)\.wav$`)
+    reUnmaster = regexp.MustCompile(`^` + reTrack + `-(?P<t1>[0-9]{4}[AP])-(?P<t2>[0-9]{4}[AP])-\[unmastered\]\.wav$`)
+    reMaster   = regexp.MustCompile(`^` + reTrack + `-(?P<t1>[0-9]{4}[AP])-(?P<t2>[0-9]{4}[AP])-(?P<idx>FINAL|[1-9][0-9]*)\.wav$`)
+    // Live's automatic copies in the project's Backup/ folder: "NAME [YYYY-MM-DD HHMMSS].als"
+    reBackup   = regexp.MustCompile(`^` + reTrack + `-(?P<t1>[0-9]{4}[AP]) (?:\[(?P<stamp>[0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{6})\]|\((?P<n>[0-9]+)\))\.als$`)
+    // MIDI exported from a snapshot, whole or one part: NAME-1040P-BASS.mid
+    reMIDI     = regexp.MustCompile(`^` + reTrack + `-(?P<t1>[0-9]{4}[AP])(?:-(?P<part>[A-Z0-9_]+))?\.(?:mid|midi)$`)
 )
 
-func rxGroup(rx *regexp.Regexp, s string, name string) string {
+// Session file extensions by DAW. Logic projects are folder bundles.
+var sessionDAW = map[string]string{
+    "als":       "ableton",
+    "logicx":    "logic",
+    "ptx":       "protools",
+    "rpp":       "reaper",
+    "flp":       "flstudio",
+    "bwproject": "bitwig",
+}
+
+func rxGroups(rx *regexp.Regexp, s string) map[string]string {
     m := rx.FindStringSubmatch(s)
-    if m == nil { return "" }
+    if m == nil { return nil }
+    out := map[string]string{}
     for i, n := range rx.SubexpNames() {
-        if n == name { return m[i] }
+        if n != "" { out[n] = m[i] }
     }
-    return ""
+    return out
 }
 
-// ====== In-Memory Index ======
-
-type FileRef struct {
-    Name           string    `json:"name"`
-    Path           string    `json:"path"`
-    Size           int64     `json:"size"`
-    ServerModified time.Time `json:"server_modified"`
-}
+const (
+    kindSnapshot = client.KindSnapshot // DAW save or its bounce
+    kindBackup   = client.KindBackup
+    kindStem     = client.KindStem
+    kindMix      = client.KindMix
+    kindPrint    = client.KindPrint
+    kindMaster   = client.KindMaster
+    kindLyrics   = client.KindLyrics
+)
 
-type AbletonSnap struct {
-    T1     string   `json:"t1"`
-    ALS    *FileRef `json:"als,omitempty"`
-    WAV    *FileRef `json:"wav,omitempty"`
-    MP3    *FileRef `json:"mp3,omitempty"`
-    Latest time.Time `json:"latest"`
+// nameParts is what the naming convention encodes in a file name.
+type nameParts struct {
+    Kind  string
+    Track string
+    T1    string
+    T2    string
+    Ext   string // snapshot extension
+    DAW   string // snapshot session DAW, empty for bounces
+    Stem  string // stem name, MIDI part or print chain
+    Idx   string // master index or FINAL
+    Stamp string // backup timestamp or copy number
 }
 
-type StemsSet struct {
-    T1     string    `json:"t1"`
-    T2     string    `json:"t2"`
-    Stems  []FileRef `json:"stems"`
-    Latest time.Time `json:"latest"`
+// classifyName applies the convention to a base name. Masters and prints are
+// tried before stems since IDX (1, 2, FINAL) and PRINT_<CHAIN> are also valid
+// STEM tokens.
+func classifyName(base string) (nameParts, bool) {
+    if g := rxGroups(reSnapshot, base); g != nil {
+        return nameParts{Kind: kindSnapshot, Track: g["track"], T1: g["t1"], Ext: g["ext"], DAW: sessionDAW[g["ext"]]}, true
+    }
+    if g := rxGroups(reMIDI, base); g != nil {
+        return nameParts{Kind: kindSnapshot, Track: g["track"], T1: g["t1"], Ext: "mid", Stem: g["part"]}, true
+    }
+    if g := rxGroups(reBackup, base); g != nil {
+        stamp := g["stamp"]
+        if stamp == "" { stamp = g["n"] }
+        return nameParts{Kind: kindBackup, Track: g["track"], T1: g["t1"], Ext: "als", DAW: "ableton", Stamp: stamp}, true
+    }
+    if g := rxGroups(reUnmaster, base); g != nil {
+        return nameParts{Kind: kindMix, Track: g["track"], T1: g["t1"], T2: g["t2"]}, true
+    }
+    if g := rxGroups(reMaster, base); g != nil {
+        return nameParts{Kind: kindMaster, Track: g["track"], T1: g["t1"], T2: g["t2"], Idx: g["idx"]}, true
+    }
+    if g := rxGroups(rePrint, base); g != nil {
+        return nameParts{Kind: kindPrint, Track: g["track"], T1: g["t1"], T2: g["t2"], Stem: g["chain"]}, true
+    }
+    if g := rxGroups(reStems, base); g != nil {
+        return nameParts{Kind: kindStem, Track: g["track"], T1: g["t1"], T2: g["t2"], Stem: g["stem"]}, true
+    }
+    return nameParts{}, false
 }
 
-type Mix struct {
-    T1     string   `json:"t1"`
-    T2     string   `json:"t2"`
-    File   FileRef  `json:"file"`
-    Latest time.Time `json:"latest"`
-}
+// ====== In-Memory Index ======
+//
+// The index is made of the types the API serves, which Go clients import
+// from avcs-browser/client.
 
-type MasterSet struct {
-    T1        string      `json:"t1"`
-    T2        string      `json:"t2"`
-    Candidates []FileRef  `json:"candidates"`
-    Final     *FileRef    `json:"final,omitempty"`
-    Latest    time.Time   `json:"latest"`
-}
+type (
+    FileRef     = client.FileRef
+    AbletonSnap = client.AbletonSnap
+    BackupRef   = client.BackupRef
+    StemsSet    = client.StemsSet
+    Mix         = client.Mix
+    Print       = client.Print
+    MasterSet   = client.MasterSet
+    Track       = client.Track
+    Idea        = client.Idea
+    Lyric       = client.Lyric
+)
 
-type Track struct {
-    Name     string       `json:"name"`
-    Ableton  []AbletonSnap `json:"ableton"`
-    Stems    []StemsSet    `json:"stems"`
-    Mixes    []Mix         `json:"mixes"`
-    Masters  []MasterSet   `json:"masters"`
+func fileRefOf(e *dbxEntry) FileRef {
+    return FileRef{Name: path.Base(e.PathDisplay), Path: e.PathDisplay, Size: e.Size, ServerModified: e.ServerModified, ContentHash: e.ContentHash}
 }
 
 type Server struct {
-    dropboxToken string
-    dropboxRoot  string
-    bindAddr     string
+    dropbox           *dropboxAuth
+    dropboxRoot       string
+    archiveRoot       string
+    trashGrace        time.Duration
+    inboxRoot         string
+    inboxConfirmAll   bool
+    adminKey          string
+    keyRateLimit      int
+    ipRateLimit       int
+    fetchIPRateLimit  int
+    fetchKeyRateLimit int
+    trustProxy        bool   // client addresses from X-Forwarded-For
+    defaultRole       string // of signed-in users without one of their own
+    signingKey        []byte
+    signedURLs        bool   // /api/link and streams must be signed
+    signedURLTTL      time.Duration
+    bindAddr          string
+    dataDir           string
+
+    store     *Store
+    flow      *workflow
+    summaries summaryCache // see summaries.go
+
+    mu       sync.RWMutex
+    tracks   map[string]*Track   // key: TRACK name
+    projects map[string]*Project // key: PROJECT name; see projects.go
+
+    writeMu sync.Mutex // serializes changes made to Dropbox
+
+    acctMu   sync.Mutex
+    accounts map[string]string // Dropbox account ID -> display name
 
-    mu     sync.RWMutex
-    tracks map[string]*Track // key: TRACK name
+    writeManifests bool
+    readOnly       bool // READ_ONLY: no writes, see readonly.go
+    auditFile      string // SPILL_HISTORY: the audit log, beside the state; empty: in it
+
+    listMu     sync.Mutex
+    listings   map[string]*listing // key: root; see listing.go
+    fullRelist time.Duration       // FULL_RELIST_INTERVAL; 0: every reindex
+    relistNext atomic.Bool         // the next reindex lists in full
+
+    analysis *analysisBudget // see analysis.go
+
+    indexGen    uint64        // under mu: moved on whenever tracks changes
+    responses   responseCache // see respcache.go
+    streamCache *streamCache  // STREAM_CACHE_MB; nil: off. See streamcache.go
+    ideas       ideaRules     // IDEA_FOLDERS, IDEA_PATTERN; see ideas.go
+    timeouts       timeouts
+    maintenance    *maintenance // nil: heavy work may run any time
+    notifiers      []notifier     // Slack, Discord, Telegram, webhooks, email; see notify.go
+    telegram       *telegramBot   // nil: no Telegram bot
+    digest         *digester      // nil: no email digest
+    soundcloud     *soundCloud    // nil: not set up
+    catalogSync    *catalogSync   // nil: no Sheets or Airtable sync
+    codes          codePools      // ISRC and UPC ranges; see codes.go
+    reminders      reminderPlan   // when deadline reminders go out; see deadlines.go
+    manifestKey    ed25519.PrivateKey
+
+    alsMu     sync.Mutex
+    alsCache  map[string]*alsInfo     // key: path@server_modified
+    sessCache map[string]*SessionInfo // key: path@server_modified
+    loudCache map[string]float64      // key: path@server_modified, LUFS
+    metaCache map[string]*TrackMeta   // key: path@content_hash@server_modified
+    projCache map[string]*Project     // key: path@content_hash@server_modified
+    lenCache  map[string]time.Duration // key: path@content_hash, a WAV's length
+    midiCache map[string]*MidiInfo    // key: path@server_modified
+    lyricsCache map[string]string     // key: path@content_hash, a lyrics file's text
+
+    uploadMu  sync.Mutex
+    uploading map[string]*Upload // uploads with a PATCH in progress, key: ID
+
+    limiter   *keyLimiter // by key ID, and fetches by key or user
+    ipLimiter *keyLimiter // by client address
+    metrics   *metrics
+    accessLog *accessLog
+
+    oidc       *oidcClient // nil: no web login
+    corsPolicy *corsPolicy // nil: same origin only
+    tls        *tlsSource  // nil: plain HTTP
+    cluster    *cluster    // nil: the only instance
+    health     health
 }
 
 func main() {
+    if strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe") == "avcs" { os.Exit(runCLI(os.Args[1:])) } // the companion CLI, see cli.go
+    if len(os.Args) > 1 && os.Args[1] == "watch" { os.Exit(runWatch(os.Args[2:])) }
+    if len(os.Args) > 1 && os.Args[1] == "backup" { os.Exit(runBackup(os.Args[2:])) }
+    if len(os.Args) > 1 && os.Args[1] == "restore" { os.Exit(runRestore(os.Args[2:])) }
+    if len(os.Args) > 1 && os.Args[1] == "bench" { os.Exit(runBench(os.Args[2:])) }
+    configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML config file; environment variables override it")
+    validate := flag.Bool("validate-config", false, "check the configuration and exit")
+    demoMode := flag.Bool("demo", false, "serve a built-in sample catalog instead of Dropbox, without a token")
+    flag.Parse()
+    if *configFile != "" {
+        if err := loadConfig(*configFile); err != nil { log.Fatalf("config: %v", err) }
+    }
+    if err := setupLogging(); err != nil { log.Fatal(err) }
+    go watchHangup()
     s := &Server{
-        dropboxToken: strings.TrimSpace(os.Getenv("DROPBOX_TOKEN")),
         dropboxRoot:  os.Getenv("DROPBOX_ROOT"),
+        archiveRoot:  os.Getenv("ARCHIVE_ROOT"),
+        inboxRoot:    os.Getenv("INBOX_ROOT"),
         bindAddr:     os.Getenv("BIND_ADDR"),
+        dataDir:      os.Getenv("DATA_DIR"),
         tracks:       map[string]*Track{},
+        alsCache:     map[string]*alsInfo{},
+        sessCache:    map[string]*SessionInfo{},
+        loudCache:    map[string]float64{},
+        metaCache:    map[string]*TrackMeta{},
+        projCache:    map[string]*Project{},
+        lenCache:     map[string]time.Duration{},
+        midiCache:    map[string]*MidiInfo{},
+        lyricsCache:  map[string]string{},
+        uploading:    map[string]*Upload{},
+        accounts:     map[string]string{},
+        limiter:      &keyLimiter{buckets: map[string]*keyBucket{}},
+        ipLimiter:    &keyLimiter{buckets: map[string]*keyBucket{}, idle: limiterIdle},
+        metrics:      newMetrics(),
     }
-    if s.dropboxToken == "" {
-        log.Fatal("DROPBOX_TOKEN env var is required")
+    var err error
+    var demo *demoDropbox
+    if *demoMode {
+        if demo, err = startDemo(); err != nil { log.Fatalf("demo: %v", err) }
+        s.dropbox = demoAuth()
+        if s.dataDir == "" {
+            if s.dataDir, err = os.MkdirTemp("", "avcs-demo-"); err != nil { log.Fatalf("demo: %v", err) }
+        }
+        slog.Info("demo mode: serving the sample catalog, changes are not kept", "data_dir", s.dataDir)
+    } else if s.dropbox, err = loadDropboxAuth(); err != nil { log.Fatal(err) }
+    refresh := defaultSecretsRefresh
+    if v := os.Getenv("SECRETS_REFRESH"); v != "" {
+        d, err := time.ParseDuration(v)
+        if err != nil || d < 0 { log.Fatalf("SECRETS_REFRESH must be a duration like 1m, or 0, not %q", v) }
+        refresh = d
     }
+    if refresh > 0 && demo == nil { go s.dropbox.watch(refresh) }
+    if s.adminKey, err = readSecret("ADMIN_API_KEY"); err != nil { log.Fatal(err) }
     if s.dropboxRoot == "" { s.dropboxRoot = "/Tracks" }
+    if s.archiveRoot == "" { s.archiveRoot = "/Archive" }
+    s.trashGrace = 30 * 24 * time.Hour
+    if v := os.Getenv("TRASH_DAYS"); v != "" {
+        days, err := strconv.Atoi(v)
+        if err != nil || days < 0 { log.Fatalf("TRASH_DAYS must be a number of days, not %q", v) }
+        s.trashGrace = time.Duration(days) * 24 * time.Hour
+    }
+    if s.inboxRoot == "" { s.inboxRoot = "/_inbox" }
+    s.inboxConfirmAll = os.Getenv("INBOX_CONFIRM") == "all"
+    roots := []string{s.dropboxRoot, s.archiveRoot, s.inboxRoot}
+    for i, a := range roots {
+        for _, b := range roots[i+1:] {
+            la, lb := strings.ToLower(strings.TrimSuffix(a, "/"))+"/", strings.ToLower(strings.TrimSuffix(b, "/"))+"/"
+            if strings.HasPrefix(la, lb) || strings.HasPrefix(lb, la) { log.Fatal("DROPBOX_ROOT, ARCHIVE_ROOT and INBOX_ROOT must not contain one another") }
+        }
+    }
+    s.keyRateLimit = envPerMinute("API_KEY_RATE_LIMIT", 120)
+    s.ipRateLimit = envPerMinute("IP_RATE_LIMIT", 600)
+    s.fetchIPRateLimit = envPerMinute("FETCH_IP_RATE_LIMIT", 60)
+    s.fetchKeyRateLimit = envPerMinute("FETCH_KEY_RATE_LIMIT", 120)
+    s.analysis = loadAnalysisBudget()
+    s.trustProxy = os.Getenv("TRUST_PROXY") != ""
+    s.readOnly = os.Getenv("READ_ONLY") != ""
+    if s.dataDir == "" { s.dataDir = "data" }
+
+    if s.tls, err = loadTLS(s.dataDir); err != nil { log.Fatalf("TLS: %v", err) }
+    if s.bindAddr == "" && s.tls != nil { s.bindAddr = ":443" }
     if s.bindAddr == "" { s.bindAddr = ":8080" }
+    if s.flow, err = parseWorkflow(os.Getenv("STATUS_FLOW"), os.Getenv("STATUS_TRANSITIONS")); err != nil {
+        log.Fatalf("status workflow: %v", err)
+    }
+    if s.cluster, err = loadCluster(); err != nil { log.Fatal(err) }
+    stateDir := s.dataDir
+    if s.cluster != nil {
+        stateDir = s.cluster.dir
+        s.store, err = openSharedStore(s.cluster.path("state.json"), s.cluster.path("state.lock"))
+    } else {
+        s.store, err = openStore(filepath.Join(s.dataDir, "state.json"))
+    }
+    if err != nil { log.Fatalf("open state: %v", err) }
+    if os.Getenv("SPILL_HISTORY") != "" { s.auditFile = filepath.Join(stateDir, "audit.jsonl") }
+    s.writeManifests = os.Getenv("WRITE_MANIFESTS") != ""
+    signingKey, err := readSecret("URL_SIGNING_KEY")
+    if err != nil { log.Fatal(err) }
+    // Replicas starting together must make one key between them.
+    unlockKeys := func() {}
+    if s.cluster != nil {
+        if unlockKeys, err = lockFile(s.cluster.path("keys.lock")); err != nil { log.Fatalf("SHARED_DIR: %v", err) }
+    }
+    if s.manifestKey, err = loadManifestKey(os.Getenv("MANIFEST_SIGNING_KEY"), filepath.Join(stateDir, "manifest.key")); err != nil {
+        log.Fatalf("manifest signing key: %v", err)
+    }
+    if s.signingKey, err = loadSigningKey(signingKey, filepath.Join(stateDir, "url-signing.key")); err != nil {
+        log.Fatalf("URL signing key: %v", err)
+    }
+    unlockKeys()
+    if s.oidc, err = loadOIDC(); err != nil { log.Fatalf("web login: %v", err) }
+    if s.corsPolicy, err = loadCORS(); err != nil { log.Fatalf("CORS: %v", err) }
+    var reindexEvery time.Duration
+    if v := os.Getenv("REINDEX_INTERVAL"); v != "" {
+        if reindexEvery, err = time.ParseDuration(v); err != nil || reindexEvery < 0 { log.Fatalf("REINDEX_INTERVAL must be a duration like 15m, or 0, not %q", v) }
+    }
+    s.fullRelist = defaultFullRelist
+    if v := os.Getenv("FULL_RELIST_INTERVAL"); v != "" {
+        if s.fullRelist, err = time.ParseDuration(v); err != nil || s.fullRelist < 0 { log.Fatalf("FULL_RELIST_INTERVAL must be a duration like 24h, or 0, not %q", v) }
+    }
+    if s.timeouts, err = loadTimeouts(); err != nil { log.Fatal(err) }
+    if s.ideas, err = loadIdeas(); err != nil { log.Fatal(err) }
+    if s.maintenance, err = loadMaintenance(os.Getenv("MAINTENANCE_WINDOWS")); err != nil { log.Fatal(err) }
+    if s.notifiers, err = loadNotifiers(); err != nil { log.Fatal(err) }
+    s.notifiers = append(s.notifiers, hookNotifier{s}) // subscriptions come and go at run time
+    if s.telegram, err = loadTelegram(); err != nil { log.Fatalf("Telegram: %v", err) }
+    if s.telegram != nil { s.notifiers = append(s.notifiers, s.telegram) }
+    if s.digest, err = loadDigest(); err != nil { log.Fatalf("email digest: %v", err) }
+    if s.digest != nil { s.notifiers = append(s.notifiers, mailNotifier{s.digest}) }
+    if s.reminders, err = loadReminderPlan(); err != nil { log.Fatal(err) }
+    if s.soundcloud, err = loadSoundCloud(stateDir); err != nil { log.Fatalf("SoundCloud: %v", err) }
+    if s.catalogSync, err = loadCatalogSync(); err != nil { log.Fatalf("catalog sync: %v", err) }
+    if s.codes, err = loadCodePools(); err != nil { log.Fatal(err) }
+    if s.signedURLs, s.signedURLTTL, err = parseSignedURLs(os.Getenv("SIGNED_URLS"), os.Getenv("SIGNED_URL_TTL")); err != nil { log.Fatal(err) }
+    s.accessLog = &accessLog{path: filepath.Join(s.dataDir, "access.jsonl"), maxBytes: 64 << 20}
+    if v := os.Getenv("ACCESS_LOG_MAX_MB"); v != "" {
+        mb, err := strconv.Atoi(v)
+        if err != nil || mb <= 0 { log.Fatalf("ACCESS_LOG_MAX_MB must be a number of megabytes, not %q", v) }
+        s.accessLog.maxBytes = int64(mb) << 20
+    }
+    if s.streamCache, err = loadStreamCache(s.dataDir); err != nil { log.Fatalf("stream cache: %v", err) }
+    s.defaultRole = strings.ToLower(cmp.Or(os.Getenv("DEFAULT_ROLE"), roleProducer))
+    if !slices.Contains(roleNames, s.defaultRole) { log.Fatalf("DEFAULT_ROLE must be one of %s", strings.Join(roleNames, ", ")) }
+    if *validate {
+        f, err := os.CreateTemp(s.dataDir, ".validate-*")
+        if err != nil { log.Fatalf("DATA_DIR: %v", err) }
+        f.Close(); os.Remove(f.Name())
+        slog.Info("configuration OK")
+        return
+    }
 
-    log.Printf("Indexing Dropbox root: %s", s.dropboxRoot)
-    if err := s.reindex(context.Background()); err != nil {
-        log.Printf("initial index error: %v", err)
+    loaded := false
+    if s.cluster != nil {
+        s.cluster.renew()
+        go s.cluster.campaign()
+        if loaded, err = s.loadIndex(context.Background()); err != nil { slog.Error("loading the shared index failed", "error", err) }
+        go s.followIndex()
+    }
+    if !loaded {
+        slog.Info("indexing", "root", s.dropboxRoot)
+        s.reindex(context.Background()) // logs its own failure
     }
+    if reindexEvery > 0 { go s.scheduleReindex(reindexEvery) }
+    if s.maintenance != nil { go s.watchMaintenance() }
+    if s.digest != nil { go s.watchDigest() }
+    if s.catalogSync != nil { go s.scheduleCatalogSync() }
+    if s.telegram != nil { go s.pollTelegram() }
+    go s.watchDeadlines()
+    if s.readOnly { slog.Info("read-only: changes are refused") }
 
+    s.publishVars()
     mux := http.NewServeMux()
     mux.HandleFunc("/api/tracks", s.handleListTracks)
     mux.HandleFunc("/api/tracks/", s.handleGetTrack) // /api/tracks/{name}
     mux.HandleFunc("/api/link", s.handleTempLink)    // ?path=/Tracks/...
+    mux.HandleFunc("/api/sign", s.handleSign)
     mux.HandleFunc("/api/reindex", s.handleReindex)
+    mux.HandleFunc("/api/tags", s.handleAllTags)
+    mux.HandleFunc("/api/workflow", s.handleWorkflow)
+    mux.HandleFunc("/api/maintenance", s.handleMaintenance)
+    mux.HandleFunc("/api/audit", s.handleAudit)
+    mux.HandleFunc("/api/access", s.handleAccess)
+    mux.HandleFunc("/api/logging", s.handleLogging)
+    mux.HandleFunc("/api/debug/", s.handleDebug)
+    mux.HandleFunc("/api/admin/backup", s.handleBackup)
+    mux.HandleFunc("/api/admin/restore", s.handleRestore)
+    mux.HandleFunc("/api/admin/digest", s.handleDigest)
+    mux.HandleFunc("/api/admin/sync", s.handleCatalogSync)
+    mux.HandleFunc("/api/admin/analysis", s.handleAnalysis)
+    mux.HandleFunc("/api/metrics", s.handleMetrics)
+    mux.HandleFunc("/api/keys", s.handleKeys)
+    mux.HandleFunc("/api/keys/", s.handleKeys)
+    mux.HandleFunc("/api/webhooks", s.handleWebhooks)
+    mux.HandleFunc("/api/webhooks/", s.handleWebhooks)
+    mux.HandleFunc("/api/roles", s.handleRoles)
+    mux.HandleFunc("/api/roles/", s.handleRoles)
+    mux.HandleFunc("/auth/", s.handleAuth)
+    mux.HandleFunc("/api/manifest-key", s.handleManifestKey)
+    mux.HandleFunc("/api/projects", s.handleProjects)
+    mux.HandleFunc("/api/projects/", s.handleProjects)
+    mux.HandleFunc("/api/releases", s.handleReleases)
+    mux.HandleFunc("/api/releases/", s.handleRelease)
+    mux.HandleFunc("/api/codes", s.handleCodes)
+    mux.HandleFunc("/api/deadlines", s.handleDeadlines)
+    mux.HandleFunc("/api/deadlines/", s.handleDeadlines)
+    mux.HandleFunc("/api/contributors", s.handleContributors)
+    mux.HandleFunc("/api/people", s.handlePeople)
+    mux.HandleFunc("/api/people/", s.handlePeople)
+    mux.HandleFunc("/api/search", s.handleSearch)
+    mux.HandleFunc("/api/retention/rules", s.handleRetentionRules)
+    mux.HandleFunc("/api/retention/report", s.handleRetentionReport)
+    mux.HandleFunc("/api/retention/apply", s.handleRetentionApply)
+    mux.HandleFunc("/api/aliases", s.handleAliases)
+    mux.HandleFunc("/api/aliases/", s.handleAlias)
+    mux.HandleFunc("/api/upload", s.handleUpload)
+    mux.HandleFunc("/api/uploads", s.handleUploads)
+    mux.HandleFunc("/api/upload-link", s.handleUploadLink)
+    mux.HandleFunc("/api/uploads/", s.handleUploadItem)
+    mux.HandleFunc("/api/files/rename", s.handleRename)
+    mux.HandleFunc("/api/files/delete", s.handleDelete)
+    mux.HandleFunc("/api/suggest-name", s.handleSuggestName)
+    mux.HandleFunc("/api/migrate", s.handleMigrate)
+    mux.HandleFunc("/api/inbox", s.handleInbox)
+    mux.HandleFunc("/api/inbox/", s.handleInbox)
+    mux.HandleFunc("/api/trash", s.handleTrash)
+    mux.HandleFunc("/api/trash/", s.handleTrashItem)
+    mux.HandleFunc("/api/baselines", s.handleBaselines)
+    mux.HandleFunc("/api/verify", s.handleVerify)
+    mux.HandleFunc("/api/ab", s.handleABSessions)
+    mux.HandleFunc("/api/ab/", s.handleAB)
+    mux.HandleFunc("/api/share", s.handleShares)
+    mux.HandleFunc("/api/share/", s.handleShares)
+    mux.HandleFunc("/s/", s.handleSharePage) // public, by token
+    mux.HandleFunc("/healthz", s.handleHealthz)
+    mux.HandleFunc("/readyz", s.handleReadyz)
+    if demo != nil {
+        mux.HandleFunc("/demo/files/", demo.serveFile)
+        mux.HandleFunc("/demo/requests/", demo.serveRequest)
+        mux.HandleFunc("/demo/upload/", demo.serveUpload)
+    }
 
     // Static UI
     mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -162,8 +520,19 @@ func main() {
         http.NotFound(w, r)
     })
 
-    srv := &http.Server{ Addr: s.bindAddr, Handler: logRequests(mux) }
-    log.Printf("Listening on %s", s.bindAddr)
+    srv := &http.Server{
+        Addr:              s.bindAddr,
+        Handler:           logRequests(s.withTimeouts(s.cors(s.limitRequests(s.requireAuth(s.refuseWrites(mux)))))),
+        ReadHeaderTimeout: headerTimeout,
+        ReadTimeout:       s.timeouts.long, // each request narrows these, see timeouts.go
+        WriteTimeout:      s.timeouts.long,
+        IdleTimeout:       idleTimeout,
+    }
+    if s.tls != nil {
+        slog.Info("listening", "addr", s.bindAddr, "tls", true)
+        log.Fatal(s.tls.serve(srv))
+    }
+    slog.Info("listening", "addr", s.bindAddr, "tls", false)
     log.Fatal(srv.ListenAndServe())
 }
 
@@ -175,30 +544,34 @@ func serveFS(w http.ResponseWriter, name string) {
     w.Write(b)
 }
 
-func logRequests(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        t := time.Now()
-        next.ServeHTTP(w, r)
-        log.Printf("%s %s %s", r.Method, r.URL.Path, time.Since(t))
-    })
-}
-
 // ====== Handlers ======
 
+type trackSummary = client.TrackSummary
+
+// GET /api/tracks[?q=][&tag=...][&status=][&branches=1][&archived=1]
+// Branches are listed under their parent unless branches=1, archived tracks
+// not at all unless archived=1; q matches current and former names.
+// POST /api/tracks creates a new track's folders (see handleCreateTrack).
 func (s *Server) handleListTracks(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodPost { s.handleCreateTrack(w, r); return }
+    s.cachedJSON(w, r, "list", func(w http.ResponseWriter) { s.listTracks(w, r) })
+}
+
+func (s *Server) listTracks(w http.ResponseWriter, r *http.Request) {
     s.mu.RLock(); defer s.mu.RUnlock()
-    type summary struct {
-        Name         string `json:"name"`
-        AbletonCount int    `json:"ableton_count"`
-        StemSets     int    `json:"stem_sets"`
-        Mixes        int    `json:"mixes"`
-        MasterSets   int    `json:"master_sets"`
-    }
-    var out []summary
+    tags := r.URL.Query()["tag"]
+    status := strings.ToLower(r.URL.Query().Get("status"))
+    withBranches := r.URL.Query().Get("branches") != ""
+    withArchived := r.URL.Query().Get("archived") != ""
+    q := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("q")))
+    var out []trackSummary
     for name, t := range s.tracks {
-        out = append(out, summary{
-            Name: name, AbletonCount: len(t.Ableton), StemSets: len(t.Stems), Mixes: len(t.Mixes), MasterSets: len(t.Masters),
-        })
+        if t.Parent != "" && !withBranches || t.Archived && !withArchived || !s.mayAccess(r, name) { continue }
+        if q != "" && !strings.Contains(name, q) && !slices.ContainsFunc(t.Aliases, func(a string) bool { return strings.Contains(a, q) }) { continue }
+        if len(tags) > 0 && !s.trackHasTags(name, tags) { continue }
+        sum := s.summarize(t)
+        if status != "" && sum.Status != status { continue }
+        out = append(out, sum)
     }
     sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
     writeJSON(w, out)
@@ -208,102 +581,448 @@ func (s *Server) handleGetTrack(w http.ResponseWriter, r *http.Request) {
     // Expect /api/tracks/{name}
     parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/tracks/"), "/")
     if len(parts) < 1 || parts[0] == "" { http.NotFound(w, r); return }
-    name := parts[0]
-    s.mu.RLock(); t := s.tracks[name]; s.mu.RUnlock()
-    if t == nil { http.Error(w, "track not found", 404); return }
-    writeJSON(w, t)
+    t := s.lookupTrack(parts[0])
+    if t == nil || !s.mayAccess(r, t.Name) { http.Error(w, "track not found", 404); return }
+    if len(parts) > 1 && parts[1] != "" {
+        s.handleTrackSub(w, r, t, parts[1:])
+        return
+    }
+    s.cachedJSON(w, r, "detail", func(w http.ResponseWriter) { s.getTrack(w, r, t) })
+}
+
+func (s *Server) getTrack(w http.ResponseWriter, r *http.Request, t *Track) {
+    fields, err := parseFields(r.URL.Query().Get("fields"))
+    if err != nil { writeError(w, err); return }
+    since, err := parseSince(r.URL.Query().Get("since"))
+    if err != nil { http.Error(w, "bad since: "+err.Error(), 400); return }
+    out := s.trackDetail(t, r.URL.Query().Get("deprecated") != "", r.URL.Query().Get("archived") != "")
+    if !can(r, permMasters) && !s.released(t) { out.Masters = nil }
+    if !since.IsZero() { changedSince(out, since) }
+    if fields == nil { writeJSON(w, out); return }
+    sel, err := selectFields(out, fields)
+    if err != nil { http.Error(w, err.Error(), 500); return }
+    writeJSON(w, sel)
 }
 
+// trackDetail is the indexed track with its store-held state attached.
+// Deprecated stems sets and mixes are left out unless withDeprecated, and
+// archived files unless withArchived or the whole track is archived.
+func (s *Server) trackDetail(t *Track, withDeprecated, withArchived bool) *Track {
+    out := *t
+    out.Annotations = s.annotationsFor(t.Name)
+    out.Tags = s.tagsFor(t.Name)
+    out.Locks = s.locksFor(t.Name)
+    out.Comments = s.commentsFor(t.Name)
+    out.Deprecated = s.deprecationsFor(t.Name)
+    out.Versions = s.versionsFor(t.Name)
+    out.Published = s.publicationsFor(t.Name)
+    out.Cover = s.primaryArtwork(t)
+    out.Restricted = s.restrictionFor(t.Name) != nil
+    if !withDeprecated { hideDeprecated(&out, out.Deprecated) }
+    if !withArchived && !t.Archived { hideArchived(&out) }
+    return &out
+}
+
+// handleTrackSub dispatches /api/tracks/{name}/{resource}/...
+func (s *Server) handleTrackSub(w http.ResponseWriter, r *http.Request, t *Track, parts []string) {
+    switch parts[0] {
+    case "annotations":
+        s.handleAnnotations(w, r, t)
+        return
+    case "tags":
+        s.handleTags(w, r, t)
+        return
+    case "status":
+        s.handleStatus(w, r, t)
+        return
+    case "changelog":
+        s.handleChangelog(w, r, t)
+        return
+    case "lyrics":
+        s.handleLyrics(w, r, t)
+        return
+    case "summary":
+        s.handleSummary(w, r, t)
+        return
+    case "ratings":
+        s.handleRatings(w, r, t)
+        return
+    case "comments":
+        s.handleComments(w, r, t, parts[1:])
+        return
+    case "lock":
+        s.handleLock(w, r, t)
+        return
+    case "deprecations":
+        s.handleDeprecations(w, r, t)
+        return
+    case "conflicts":
+        s.handleConflicts(w, r, t)
+        return
+    case "archive":
+        s.handleArchive(w, r, t)
+        return
+    case "restriction":
+        s.handleRestriction(w, r, t)
+        return
+    case "versions":
+        s.handleVersions(w, r, t, parts[1:])
+        return
+    case "artwork":
+        s.handleArtwork(w, r, t, parts[1:])
+        return
+    case "metadata":
+        s.handleMetadata(w, r, t)
+        return
+    case "soundcloud":
+        s.handleSoundCloud(w, r, t)
+        return
+    case "isrc":
+        s.handleTrackISRC(w, r, t)
+        return
+    case "file-requests":
+        s.handleFileRequests(w, r, t, parts[1:])
+        return
+    case "manifest":
+        s.handleManifest(w, r, t)
+        return
+    case "branches":
+        s.handleBranches(w, r, t, parts[1:])
+        return
+    case "stems":
+        // /api/tracks/{name}/stems/{T1-T2}/bundle
+        if len(parts) == 3 && parts[2] == "bundle" { s.handleStemsBundle(w, r, t, parts[1]); return }
+    case "masters":
+        // /api/tracks/{name}/masters/{t1}/{t2}/{promote|candidates}
+        if len(parts) == 4 && parts[3] == "promote" {
+            s.handlePromote(w, r, t, parts[1], parts[2])
+            return
+        }
+        if len(parts) == 4 && parts[3] == "candidates" {
+            s.handleCandidates(w, r, t, parts[1], parts[2])
+            return
+        }
+    case "ableton":
+        // /api/tracks/{name}/ableton/{t1}/{locators|manifest|bundle|session|midi}
+        if len(parts) == 3 {
+            switch parts[2] {
+            case "locators": s.handleLocators(w, r, t, parts[1]); return
+            case "manifest": s.handleDepManifest(w, r, t, parts[1]); return
+            case "bundle": s.handleBundle(w, r, t, parts[1]); return
+            case "session": s.handleSessionInfo(w, r, t, parts[1]); return
+            case "midi": s.handleMIDI(w, r, t, parts[1]); return
+            }
+        }
+    }
+    http.NotFound(w, r)
+}
+
+// POST /api/reindex[?full=1]: full lists the roots anew rather than only
+// what changed (see listing.go).
 func (s *Server) handleReindex(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    if r.URL.Query().Get("full") != "" { s.relistNext.Store(true) }
+    s.mu.RLock(); before := len(s.tracks); s.mu.RUnlock()
     if err := s.reindex(r.Context()); err != nil {
         http.Error(w, err.Error(), 500); return
     }
+    s.mu.RLock(); after := len(s.tracks); s.mu.RUnlock()
+    s.audit(r, "reindex", "", map[string]int{"tracks": before}, map[string]int{"tracks": after})
     writeJSON(w, map[string]any{"status":"ok"})
 }
 
 func (s *Server) handleTempLink(w http.ResponseWriter, r *http.Request) {
     p := r.URL.Query().Get("path")
-    if p == "" || !strings.HasPrefix(p, s.dropboxRoot) && !strings.HasPrefix(strings.ToLower(p), strings.ToLower(s.dropboxRoot)) {
+    if p == "" || !strings.HasPrefix(p, s.dropboxRoot) && !strings.HasPrefix(strings.ToLower(p), strings.ToLower(s.dropboxRoot)) && !s.inArchive(p) {
         http.Error(w, "bad path", 400); return
     }
+    if err := s.checkAccess(r); err != nil { writeError(w, err); return }
     link, err := s.dbxTempLink(r.Context(), p)
     if err != nil { http.Error(w, err.Error(), 502); return }
+    s.logAccess(r, AccessEvent{Kind: accessLink, Track: s.trackAt(p), File: p})
     writeJSON(w, map[string]string{"url": link})
 }
 
+// actorOf names who made a request, for attribution of notes and changes:
+// the signed-in user, else the API key as principal names it. X-AVCS-User is
+// only taken from requests that carry neither, as on a server without keys.
+func actorOf(r *http.Request) string {
+    if u := authenticatedAs(r); u != "" { return u }
+    if u := strings.TrimSpace(r.Header.Get("X-AVCS-User")); u != "" { return u }
+    return "anonymous"
+}
+
+// authenticatedAs is who r's session or API key shows made it; "" if neither.
+func authenticatedAs(r *http.Request) string {
+    if u, _ := r.Context().Value(ctxUser).(string); u != "" { return u }
+    if k := requestKey(r); k != nil { return "key:" + k.ID }
+    return ""
+}
+
+// claimedActor is who X-AVCS-User says made r, when that is not actor.
+func claimedActor(r *http.Request, actor string) string {
+    u := strings.TrimSpace(r.Header.Get("X-AVCS-User"))
+    if u == actor { return "" }
+    return u
+}
+
 // ====== Indexer ======
 
-func (s *Server) reindex(ctx context.Context) error {
-    entries, err := s.dbxListAll(ctx, s.dropboxRoot)
-    if err != nil { return err }
+// reindex rebuilds the catalog from Dropbox, as a job of its own.
+func (s *Server) reindex(ctx context.Context) (err error) {
+    runJob(ctx, "reindex", func(ctx context.Context) {
+        start := time.Now()
+        slog.InfoContext(ctx, "reindex started")
+        err = s.index(ctx)
+        s.health.record("index", err)
+        if err != nil { slog.ErrorContext(ctx, "reindex failed", "error", err); return }
+        s.mu.RLock(); n := len(s.tracks); s.mu.RUnlock()
+        slog.InfoContext(ctx, "reindex finished", "tracks", n, "duration_ms", time.Since(start).Milliseconds())
+    })
+    return err
+}
+
+func (s *Server) index(ctx context.Context) error {
+    full := s.relistNext.Swap(false)
+    entries, err := s.listEntries(ctx, s.dropboxRoot, full)
+    var archived []dbxEntry
+    if err == nil {
+        archived, err = s.listEntries(ctx, s.archiveRoot, full)
+        if err != nil && !isNotFound(err) { err = fmt.Errorf("archive root: %w", err) } else { err = nil }
+    }
+    if err != nil {
+        if full { s.relistNext.Store(true) } // for the next try
+        return err
+    }
+    // Archived entries go first so that a live file wins a shared slot.
+    entries = append(archived, entries...)
+
+    // Logic projects are folder bundles: size and modification come from their contents.
+    bundles := map[string]*dbxEntry{}
+    for _, e := range entries {
+        if e.Tag != "file" { continue }
+        lower := e.lower()
+        if i := strings.Index(lower, ".logicx/"); i >= 0 {
+            key := lower[:i+len(".logicx")]
+            b := bundles[key]
+            if b == nil { b = &dbxEntry{}; bundles[key] = b }
+            b.Size += e.Size
+            if e.ServerModified.After(b.ServerModified) { b.ServerModified = e.ServerModified }
+        }
+    }
+
+    s.resolveAccounts(ctx, entries)
+    aliases := s.aliasMap()
 
     tracks := map[string]*Track{}
+    builds := map[*Track]*trackBuild{} // each track's artifacts by timestamps, until sorted into its slices
+    artwork := map[string][]FileRef{} // key: lower-case track folder
+    refs := map[string][]FileRef{}    // references by folder; key: lower-case track folder
+    ideas := map[string][]Idea{}      // ideas by folder; key: lower-case track folder
+    lyrics := map[string][]Lyric{}    // lyrics by folder; key: lower-case track folder
+    metaFiles := map[string]*dbxEntry{} // key: lower-case track folder
+    var projectFiles []*dbxEntry
     // Track folders are immediate children of root; but we will infer from file names/folders under root as well.
     for _, e := range entries {
-        if e.Tag != "file" { continue }
+        lower := e.lower()
+        if strings.Contains(lower, "/"+archiveDir+"/") { continue } // superseded files
+        if s.inTrash(lower) { continue }
+        if e.Tag == "folder" {
+            b := bundles[lower]
+            if b == nil { continue }
+            e.Size, e.ServerModified = b.Size, b.ServerModified
+        } else if e.Tag != "file" || strings.Contains(lower, ".logicx/") {
+            continue
+        }
+        if s.isArtwork(&e) {
+            ref := fileRefOf(&e)
+            ref.ContributedBy = s.contributorOf(&e)
+            ref.Archived = s.inArchive(lower)
+            dir := strings.ToLower(s.trackDir(e.PathDisplay))
+            artwork[dir] = append(artwork[dir], ref)
+            continue
+        }
+        if s.isReference(&e) {
+            ref := fileRefOf(&e)
+            ref.ContributedBy = s.contributorOf(&e)
+            ref.Archived = s.inArchive(lower)
+            if name, ok := referenceTrack(path.Base(e.PathDisplay)); ok {
+                T := ensureTrack(tracks, canonicalName(aliases, name))
+                T.References = append(T.References, ref)
+            } else {
+                dir := strings.ToLower(s.trackDir(e.PathDisplay))
+                refs[dir] = append(refs[dir], ref)
+            }
+            continue
+        }
+        if s.isIdea(&e) {
+            idea := s.ideaOf(&e)
+            if name, ok := s.ideaTrack(path.Base(e.PathDisplay)); ok {
+                T := ensureTrack(tracks, canonicalName(aliases, name))
+                T.Ideas = append(T.Ideas, idea)
+            } else {
+                dir := strings.ToLower(s.trackDir(e.PathDisplay))
+                ideas[dir] = append(ideas[dir], idea)
+            }
+            continue
+        }
+        if s.isLyrics(&e) {
+            lyric := s.lyricOf(&e)
+            if name, _, ok := lyricsTrack(path.Base(e.PathDisplay)); ok {
+                T := ensureTrack(tracks, canonicalName(aliases, name))
+                T.Lyrics = append(T.Lyrics, lyric)
+            } else {
+                dir := strings.ToLower(s.trackDir(e.PathDisplay))
+                lyrics[dir] = append(lyrics[dir], lyric)
+            }
+            continue
+        }
+        if s.isMetadataFile(&e) {
+            metaFiles[strings.ToLower(path.Dir(e.PathDisplay))] = &e
+            continue
+        }
+        if s.isProjectFile(&e) {
+            if !s.inArchive(lower) { projectFiles = append(projectFiles, &e) }
+            continue
+        }
+        if name := s.sidecarTrack(&e); name != "" { // a track folder, possibly still empty
+            T := ensureTrack(tracks, canonicalName(aliases, name))
+            if T.Dir == "" { T.Dir = path.Dir(e.PathDisplay) }
+            continue
+        }
         base := path.Base(e.PathDisplay)
-        // Identify by regexes in priority order.
-        switch {
-        case reAbleton.MatchString(base):
-            tr := rxGroup(reAbleton, base, "track")
-            t1 := rxGroup(reAbleton, base, "t1")
-            ext := rxGroup(reAbleton, base, "ext")
-            T := ensureTrack(tracks, tr)
-            snap := findOrCreateSnap(&T.Ableton, t1)
-            ref := FileRef{Name: base, Path: e.PathDisplay, Size: e.Size, ServerModified: e.ServerModified}
-            switch ext {
-            case "als": snap.ALS = &ref
+        np, ok := classifyName(base)
+        inArchive := s.inArchive(lower)
+        if orig, owner, date, isCopy := splitConflicted(base); !ok && isCopy && e.Tag == "file" && !inArchive {
+            if np, ok := classifyName(orig); ok {
+                T := ensureTrack(tracks, canonicalName(aliases, np.Track))
+                ref := fileRefOf(&e)
+                ref.ContributedBy = s.contributorOf(&e)
+                T.Conflicts = append(T.Conflicts, Conflict{Artifact: conflictArtifact(np), Copy: ref, OriginalPath: path.Join(path.Dir(e.PathDisplay), orig), Owner: owner, Date: date})
+            }
+            continue
+        }
+        if !ok {
+            // ignore other files (sessions, manifests, etc.)
+            continue
+        }
+        if (np.Ext == "logicx") != (e.Tag == "folder") { continue }
+        T := ensureTrack(tracks, canonicalName(aliases, np.Track))
+        ref := fileRefOf(&e)
+        ref.ContributedBy = s.contributorOf(&e)
+        ref.Archived = inArchive
+        if T.Dir == "" || !inArchive && s.inArchive(T.Dir) { T.Dir = s.trackDir(e.PathDisplay) }
+        b := builds[T]
+        if b == nil { b = newTrackBuild(); builds[T] = b }
+        switch np.Kind {
+        case kindSnapshot:
+            snap := b.snap(np.T1)
+            switch np.Ext {
+            case "als": snap.ALS = &ref; snap.DAW = np.DAW
             case "wav": snap.WAV = &ref
             case "mp3": snap.MP3 = &ref
+            case "mid": snap.MIDI = append(snap.MIDI, ref)
+            default: snap.Session = &ref; snap.DAW = np.DAW
             }
-            latest := e.ServerModified
-            if latest.After(snap.Latest) { snap.Latest = latest }
-            // write back
-            replaceSnap(&T.Ableton, *snap)
-
-        case reStems.MatchString(base):
-            tr := rxGroup(reStems, base, "track")
-            t1 := rxGroup(reStems, base, "t1")
-            t2 := rxGroup(reStems, base, "t2")
-            stem := rxGroup(reStems, base, "stem")
-            T := ensureTrack(tracks, tr)
-            set := findOrCreateStems(&T.Stems, t1, t2)
-            set.Stems = append(set.Stems, FileRef{Name: stem + ".wav", Path: e.PathDisplay, Size: e.Size, ServerModified: e.ServerModified})
+            if e.ServerModified.After(snap.Latest) { snap.Latest = e.ServerModified }
+
+        case kindBackup:
+            if path.Base(path.Dir(e.PathDisplay)) != "Backup" { continue }
+            snap := b.snap(np.T1)
+            snap.Backups = append(snap.Backups, BackupRef{FileRef: ref, Stamp: np.Stamp})
+
+        case kindStem:
+            set := b.stemsSet(np.T1, np.T2)
+            ref.Name = np.Stem + ".wav"
+            set.Stems = append(set.Stems, ref)
             if e.ServerModified.After(set.Latest) { set.Latest = e.ServerModified }
-            replaceStems(&T.Stems, *set)
-
-        case reUnmaster.MatchString(base):
-            tr := rxGroup(reUnmaster, base, "track")
-            t1 := rxGroup(reUnmaster, base, "t1")
-            t2 := rxGroup(reUnmaster, base, "t2")
-            T := ensureTrack(tracks, tr)
-            m := Mix{T1: t1, T2: t2, File: FileRef{Name: base, Path: e.PathDisplay, Size: e.Size, ServerModified: e.ServerModified}, Latest: e.ServerModified}
+
+        case kindMix:
+            m := Mix{T1: np.T1, T2: np.T2, File: ref, Latest: e.ServerModified}
             T.Mixes = append(T.Mixes, m)
 
-        case reMaster.MatchString(base):
-            tr := rxGroup(reMaster, base, "track")
-            t1 := rxGroup(reMaster, base, "t1")
-            t2 := rxGroup(reMaster, base, "t2")
-            idx := rxGroup(reMaster, base, "idx")
-            T := ensureTrack(tracks, tr)
-            set := findOrCreateMaster(&T.Masters, t1, t2)
-            ref := FileRef{Name: base, Path: e.PathDisplay, Size: e.Size, ServerModified: e.ServerModified}
-            if strings.EqualFold(idx, "FINAL") {
+        case kindPrint:
+            T.Prints = append(T.Prints, Print{T1: np.T1, T2: np.T2, Chain: np.Stem, File: ref, Latest: e.ServerModified})
+
+        case kindMaster:
+            set := b.masterSet(np.T1, np.T2)
+            if strings.EqualFold(np.Idx, "FINAL") {
                 set.Final = &ref
             } else {
                 set.Candidates = append(set.Candidates, ref)
             }
             if e.ServerModified.After(set.Latest) { set.Latest = e.ServerModified }
-            replaceMaster(&T.Masters, *set)
-        default:
-            // ignore other files (refs, prints, sessions, manifests, etc.)
         }
     }
+    for t, b := range builds { b.materialize(t) } // sorted with the rest below
 
+    // Hang branches off their parent, which exists even if only branches have files.
+    for _, t := range tracks {
+        if t.Parent == "" { continue }
+        p := ensureTrack(tracks, t.Parent)
+        p.Branches = append(p.Branches, t.Branch)
+        if p.Dir == "" { p.Dir = t.Dir }
+    }
+    for _, t := range tracks {
+        t.Artwork = artwork[strings.ToLower(t.Dir)]
+        t.References = append(t.References, refs[strings.ToLower(t.Dir)]...)
+        sort.Slice(t.References, func(i, j int) bool { return t.References[i].Name < t.References[j].Name })
+        t.Ideas = append(t.Ideas, ideas[strings.ToLower(t.Dir)]...)
+        sort.SliceStable(t.Ideas, func(i, j int) bool { return t.Ideas[i].Recorded.Before(t.Ideas[j].Recorded) })
+        t.Lyrics = append(t.Lyrics, lyrics[strings.ToLower(t.Dir)]...)
+        sort.Slice(t.Lyrics, func(i, j int) bool {
+            if !t.Lyrics[i].Written.Equal(t.Lyrics[j].Written) { return t.Lyrics[i].Written.Before(t.Lyrics[j].Written) }
+            return t.Lyrics[i].Name < t.Lyrics[j].Name
+        })
+        sort.Slice(t.Artwork, func(i, j int) bool { return t.Artwork[i].Name < t.Artwork[j].Name })
+        if e := metaFiles[strings.ToLower(t.Dir)]; e != nil && t.Parent == "" {
+            if t.Metadata, err = s.trackMeta(ctx, e); err != nil { t.MetadataError = err.Error() }
+        }
+    }
+
+    // Pair conflicted copies with the file whose name they were made from.
+    files := map[string]*dbxEntry{} // key: lower-case path of an original
+    for _, t := range tracks {
+        for _, c := range t.Conflicts { files[strings.ToLower(c.OriginalPath)] = nil }
+    }
+    existing := map[string]string{} // manifests: lower-case path -> content hash
+    for i := range entries {
+        e := &entries[i]
+        if e.Tag != "file" { continue }
+        lower := e.lower()
+        if _, ok := files[lower]; ok { files[lower] = e }
+        if strings.HasSuffix(lower, ".avcs.json") { existing[lower] = e.ContentHash }
+    }
+    for _, t := range tracks {
+        for i := range t.Conflicts {
+            c := &t.Conflicts[i]
+            if e := files[strings.ToLower(c.OriginalPath)]; e != nil {
+                ref := fileRefOf(e)
+                ref.ContributedBy = s.contributorOf(e)
+                c.Original = &ref
+                c.Identical = ref.ContentHash != "" && ref.ContentHash == c.Copy.ContentHash
+            }
+        }
+        sort.Slice(t.Conflicts, func(i, j int) bool { return t.Conflicts[i].Copy.Path < t.Conflicts[j].Copy.Path })
+    }
+
+    former := formerNames(aliases)
     // Sort collections for stable output
     for _, t := range tracks {
+        t.Aliases = trackAliases(former, t)
+        tf := trackFiles(t)
+        t.Archived = len(tf) > 0 && !slices.ContainsFunc(tf, func(f ManifestFile) bool { return !f.Archived })
+        sort.Strings(t.Branches)
         sort.SliceStable(t.Ableton, func(i, j int) bool { return t.Ableton[i].T1 < t.Ableton[j].T1 })
+        for i := range t.Ableton {
+            b := t.Ableton[i].Backups
+            sort.SliceStable(b, func(x, y int) bool {
+                if len(b[x].Stamp) != len(b[y].Stamp) { return len(b[x].Stamp) < len(b[y].Stamp) } // copy numbers before dates
+                return b[x].Stamp < b[y].Stamp
+            })
+            m := t.Ableton[i].MIDI
+            sort.SliceStable(m, func(x, y int) bool { return m[x].Name < m[y].Name })
+        }
         sort.SliceStable(t.Stems, func(i, j int) bool {
             if t.Stems[i].T1 == t.Stems[j].T1 { return t.Stems[i].T2 < t.Stems[j].T2 }
             return t.Stems[i].T1 < t.Stems[j].T1
@@ -312,6 +1031,12 @@ func (s *Server) reindex(ctx context.Context) error {
             if t.Mixes[i].T1 == t.Mixes[j].T1 { return t.Mixes[i].T2 < t.Mixes[j].T2 }
             return t.Mixes[i].T1 < t.Mixes[j].T1
         })
+        sort.SliceStable(t.Prints, func(i, j int) bool {
+            a, b := t.Prints[i], t.Prints[j]
+            if a.T1 != b.T1 { return a.T1 < b.T1 }
+            if a.T2 != b.T2 { return a.T2 < b.T2 }
+            return a.Chain < b.Chain
+        })
         sort.SliceStable(t.Masters, func(i, j int) bool {
             if t.Masters[i].T1 == t.Masters[j].T1 { return t.Masters[i].T2 < t.Masters[j].T2 }
             return t.Masters[i].T1 < t.Masters[j].T1
@@ -321,71 +1046,177 @@ func (s *Server) reindex(ctx context.Context) error {
         }
     }
 
-    s.mu.Lock(); s.tracks = tracks; s.mu.Unlock()
-    log.Printf("Indexed %d tracks", len(tracks))
+    projects := map[string]*Project{}
+    for _, e := range projectFiles {
+        p := s.projectDef(ctx, e)
+        projects[p.Name] = p
+    }
+
+    compactTracks(tracks)
+    s.mu.Lock(); s.tracks, s.projects = tracks, projects; s.indexGen++; s.mu.Unlock()
+    s.publishIndex(ctx, tracks)
+    s.catalogChanged(ctx, tracks)
+    s.catalogSync.poke()
+    s.baselineFinals(ctx, tracks)
+    bg := context.WithoutCancel(ctx)
+    if s.writeManifests && !s.readOnly && s.maintenanceOpen(ctx, "manifests") { go runJob(bg, "manifests", func(ctx context.Context) { s.syncManifests(ctx, tracks, existing) }) }
+    go runJob(bg, "lyrics", func(ctx context.Context) { s.readLyrics(ctx, tracks) })
+    if s.leading() && !s.readOnly {
+        go runJob(bg, "purge-trash", s.purgeTrash)
+        go runJob(bg, "inbox", s.fileInbox)
+    }
     return nil
 }
 
+// trackDir is the first-level folder under the root (or the Archive root)
+// that holds p, or the root itself.
+func (s *Server) trackDir(p string) string {
+    root := strings.TrimSuffix(s.dropboxRoot, "/")
+    if s.inArchive(p) { root = strings.TrimSuffix(s.archiveRoot, "/") }
+    if len(p) <= len(root) || !strings.EqualFold(p[:len(root)], root) { return path.Dir(p) }
+    dir, _, ok := strings.Cut(strings.TrimPrefix(p[len(root):], "/"), "/")
+    if !ok { return path.Dir(p) }
+    return p[:len(root)] + "/" + dir
+}
+
+// updateTrack applies fn to a copy of the named track and swaps it into the
+// index, so readers holding the old pointer never see a partial change.
+func (s *Server) updateTrack(name string, fn func(t *Track)) *Track {
+    s.mu.Lock()
+    old := s.tracks[name]
+    if old == nil { s.mu.Unlock(); return nil }
+    t := cloneTrack(old)
+    fn(t)
+    s.tracks[name] = t
+    s.indexGen++
+    var shared map[string]*Track
+    if s.cluster != nil || s.notifying() { shared = maps.Clone(s.tracks) }
+    s.mu.Unlock()
+    s.catalogSync.poke()
+    if shared != nil {
+        s.publishIndex(context.Background(), shared)
+        s.catalogChanged(context.Background(), shared)
+    }
+    return t
+}
+
+// cloneTrack copies the track's slices; FileRefs are never modified in place.
+func cloneTrack(old *Track) *Track {
+    t := *old
+    t.Ableton = append([]AbletonSnap(nil), old.Ableton...)
+    t.Stems = append([]StemsSet(nil), old.Stems...)
+    t.Mixes = append([]Mix(nil), old.Mixes...)
+    t.Prints = append([]Print(nil), old.Prints...)
+    t.Masters = append([]MasterSet(nil), old.Masters...)
+    for i := range t.Masters {
+        t.Masters[i].Candidates = append([]FileRef(nil), t.Masters[i].Candidates...)
+    }
+    return &t
+}
+
 func ensureTrack(m map[string]*Track, name string) *Track {
     t := m[name]
-    if t == nil { t = &Track{Name: name}; m[name] = t }
+    if t == nil {
+        t = &Track{Name: name}
+        t.Parent, t.Branch, _ = strings.Cut(name, ".")
+        if t.Branch == "" { t.Parent = "" }
+        m[name] = t
+    }
     return t
 }
 
-func findOrCreateSnap(list *[]AbletonSnap, t1 string) *AbletonSnap {
-    for i := range *list {
-        if (*list)[i].T1 == t1 { return &(*list)[i] }
-    }
-    *list = append(*list, AbletonSnap{T1: t1})
-    return &(*list)[len(*list)-1]
+// trackBuild is a track's snapshots, stems sets and master sets while the
+// index is built, by their timestamps, so that each file finds its own in
+// constant time however many versions the track has.
+type trackBuild struct {
+    snaps   map[string]*AbletonSnap // key: T1
+    stems   map[[2]string]*StemsSet // key: T1, T2
+    masters map[[2]string]*MasterSet
 }
 
-func replaceSnap(list *[]AbletonSnap, v AbletonSnap) {
-    for i := range *list { if (*list)[i].T1 == v.T1 { (*list)[i] = v; return } }
+func newTrackBuild() *trackBuild {
+    return &trackBuild{snaps: map[string]*AbletonSnap{}, stems: map[[2]string]*StemsSet{}, masters: map[[2]string]*MasterSet{}}
 }
 
-func findOrCreateStems(list *[]StemsSet, t1, t2 string) *StemsSet {
-    for i := range *list { if (*list)[i].T1 == t1 && (*list)[i].T2 == t2 { return &(*list)[i] } }
-    *list = append(*list, StemsSet{T1: t1, T2: t2})
-    return &(*list)[len(*list)-1]
+func (b *trackBuild) snap(t1 string) *AbletonSnap {
+    if b.snaps[t1] == nil { b.snaps[t1] = &AbletonSnap{T1: t1} }
+    return b.snaps[t1]
 }
 
-func replaceStems(list *[]StemsSet, v StemsSet) {
-    for i := range *list { if (*list)[i].T1 == v.T1 && (*list)[i].T2 == v.T2 { (*list)[i] = v; return } }
+func (b *trackBuild) stemsSet(t1, t2 string) *StemsSet {
+    k := [2]string{t1, t2}
+    if b.stems[k] == nil { b.stems[k] = &StemsSet{T1: t1, T2: t2} }
+    return b.stems[k]
 }
 
-func findOrCreateMaster(list *[]MasterSet, t1, t2 string) *MasterSet {
-    for i := range *list { if (*list)[i].T1 == t1 && (*list)[i].T2 == t2 { return &(*list)[i] } }
-    *list = append(*list, MasterSet{T1: t1, T2: t2})
-    return &(*list)[len(*list)-1]
+func (b *trackBuild) masterSet(t1, t2 string) *MasterSet {
+    k := [2]string{t1, t2}
+    if b.masters[k] == nil { b.masters[k] = &MasterSet{T1: t1, T2: t2} }
+    return b.masters[k]
 }
 
-func replaceMaster(list *[]MasterSet, v MasterSet) {
-    for i := range *list { if (*list)[i].T1 == v.T1 && (*list)[i].T2 == v.T2 { (*list)[i] = v; return } }
+// materialize puts what was built into t's slices, in no particular order.
+func (b *trackBuild) materialize(t *Track) {
+    for _, snap := range b.snaps { t.Ableton = append(t.Ableton, *snap) }
+    for _, set := range b.stems { t.Stems = append(t.Stems, *set) }
+    for _, set := range b.masters { t.Masters = append(t.Masters, *set) }
+}
+
+func findSnap(t *Track, t1 string) *AbletonSnap {
+    for i := range t.Ableton { if t.Ableton[i].T1 == t1 { return &t.Ableton[i] } }
+    return nil
 }
 
 // ====== Dropbox HTTP (no external deps) ======
 
+var (
+    dbxAPIHost     = "https://api.dropboxapi.com"
+    dbxContentHost = "https://content.dropboxapi.com"
+)
+
+// dbxContentClient moves file data, which has no overall time limit but
+// must start arriving within dbxHeaderTimeout.
+var dbxContentClient = &http.Client{Transport: func() http.RoundTripper {
+    t := http.DefaultTransport.(*http.Transport).Clone()
+    t.ResponseHeaderTimeout = dbxHeaderTimeout
+    return t
+}()}
+
 func (s *Server) dbxListAll(ctx context.Context, root string) ([]dbxEntry, error) {
-    var out []dbxEntry
+    entries, _, err := s.dbxList(ctx, root)
+    return entries, err
+}
+
+// dbxList lists everything under root, with the cursor to continue from.
+func (s *Server) dbxList(ctx context.Context, root string) ([]dbxEntry, string, error) {
     body := map[string]any{
         "path": root,
         "recursive": true,
         "include_non_downloadable_files": false,
     }
     resp, err := s.dbxRPC(ctx, "/2/files/list_folder", body)
-    if err != nil { return nil, err }
+    if err != nil { return nil, "", err }
     var lr dbxListResp
-    if err := json.Unmarshal(resp, &lr); err != nil { return nil, err }
-    out = append(out, lr.Entries...)
-    for lr.HasMore {
-        resp, err = s.dbxRPC(ctx, "/2/files/list_folder/continue", map[string]string{"cursor": lr.Cursor})
-        if err != nil { return nil, err }
-        lr = dbxListResp{}
-        if err := json.Unmarshal(resp, &lr); err != nil { return nil, err }
-        out = append(out, lr.Entries...)
+    if err := json.Unmarshal(resp, &lr); err != nil { return nil, "", err }
+    compactEntries(lr.Entries)
+    if !lr.HasMore { return lr.Entries, lr.Cursor, nil }
+    more, cursor, err := s.dbxListContinue(ctx, lr.Cursor)
+    return append(lr.Entries, more...), cursor, err
+}
+
+// dbxListContinue lists what changed since cursor was given, with the
+// cursor to continue from then. Deleted entries have the tag "deleted".
+func (s *Server) dbxListContinue(ctx context.Context, cursor string) ([]dbxEntry, string, error) {
+    var out []dbxEntry
+    for {
+        resp, err := s.dbxRPC(ctx, "/2/files/list_folder/continue", map[string]string{"cursor": cursor})
+        if err != nil { return nil, "", err }
+        var lr dbxListResp
+        if err := json.Unmarshal(resp, &lr); err != nil { return nil, "", err }
+        compactEntries(lr.Entries)
+        out, cursor = append(out, lr.Entries...), lr.Cursor
+        if !lr.HasMore { return out, cursor, nil }
     }
-    return out, nil
 }
 
 func (s *Server) dbxTempLink(ctx context.Context, p string) (string, error) {
@@ -397,24 +1228,185 @@ func (s *Server) dbxTempLink(ctx context.Context, p string) (string, error) {
     return lr.Link, nil
 }
 
+// dbxRelocationResp is the result of move_v2 and copy_v2.
+type dbxRelocationResp struct {
+    Metadata dbxEntry `json:"metadata"`
+}
+
+func (s *Server) dbxMove(ctx context.Context, from, to string) (*dbxEntry, error) {
+    return s.dbxRelocate(ctx, "/2/files/move_v2", from, to)
+}
+
+func (s *Server) dbxCopy(ctx context.Context, from, to string) (*dbxEntry, error) {
+    return s.dbxRelocate(ctx, "/2/files/copy_v2", from, to)
+}
+
+func (s *Server) dbxRelocate(ctx context.Context, endpoint, from, to string) (*dbxEntry, error) {
+    resp, err := s.dbxRPC(ctx, endpoint, map[string]any{"from_path": from, "to_path": to, "autorename": false})
+    if err != nil { return nil, err }
+    var rr dbxRelocationResp
+    if err := json.Unmarshal(resp, &rr); err != nil { return nil, err }
+    return &rr.Metadata, nil
+}
+
+func (s *Server) dbxMetadata(ctx context.Context, p string) (*dbxEntry, error) {
+    resp, err := s.dbxRPC(ctx, "/2/files/get_metadata", map[string]string{"path": p})
+    if err != nil { return nil, err }
+    var e dbxEntry
+    if err := json.Unmarshal(resp, &e); err != nil { return nil, err }
+    return &e, nil
+}
+
 func (s *Server) dbxRPC(ctx context.Context, endpoint string, payload any) ([]byte, error) {
+    s.metrics.add("avcs_dropbox_requests_total", 1, "endpoint", endpoint)
     b, _ := json.Marshal(payload)
-    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.dropboxapi.com"+endpoint, bytes.NewReader(b))
-    req.Header.Set("Authorization", "Bearer "+s.dropboxToken)
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, dbxAPIHost+endpoint, bytes.NewReader(b))
+    if err := s.dropbox.authorize(req); err != nil { return nil, err }
     req.Header.Set("Content-Type", "application/json")
     httpClient := &http.Client{ Timeout: 30 * time.Second }
+    start := time.Now()
     res, err := httpClient.Do(req)
+    if err != nil { logDropbox(ctx, endpoint, start, 0, err); return nil, err }
+    defer res.Body.Close()
+    buf := new(bytes.Buffer); buf.ReadFrom(res.Body)
+    if res.StatusCode != 200 {
+        if res.StatusCode == http.StatusUnauthorized { s.dropbox.refused() }
+        err := fmt.Errorf("dropbox %s -> %s: %s", endpoint, res.Status, truncate(buf.String(), 400))
+        logDropbox(ctx, endpoint, start, res.StatusCode, err)
+        return nil, err
+    }
+    logDropbox(ctx, endpoint, start, res.StatusCode, nil)
+    traceDropboxBody(ctx, endpoint, payload, buf.Bytes())
+    return buf.Bytes(), nil
+}
+
+// dbxDownload streams a file's content; the caller closes the body.
+func (s *Server) dbxDownload(ctx context.Context, p string) (io.ReadCloser, error) {
+    s.metrics.add("avcs_dropbox_requests_total", 1, "endpoint", "/2/files/download")
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, dbxContentHost+"/2/files/download", nil)
+    if err := s.dropbox.authorize(req); err != nil { return nil, err }
+    req.Header.Set("Dropbox-API-Arg", dbxArg(map[string]string{"path": p}))
+    start := time.Now()
+    res, err := dbxContentClient.Do(req)
+    if err != nil { logDropbox(ctx, "/2/files/download", start, 0, err); return nil, err }
+    if res.StatusCode != 200 {
+        if res.StatusCode == http.StatusUnauthorized { s.dropbox.refused() }
+        defer res.Body.Close()
+        buf := new(bytes.Buffer); buf.ReadFrom(io.LimitReader(res.Body, 4096))
+        err := fmt.Errorf("dropbox download %s -> %s: %s", p, res.Status, truncate(buf.String(), 400))
+        logDropbox(ctx, "/2/files/download", start, res.StatusCode, err)
+        return nil, err
+    }
+    logDropbox(ctx, "/2/files/download", start, res.StatusCode, nil)
+    traceDropboxBody(ctx, "/2/files/download", map[string]string{"path": p}, []byte(res.Header.Get("Dropbox-API-Result")))
+    return res.Body, nil
+}
+
+// dbxUpload writes a small file (<150 MB) in one request, replacing any existing one.
+func (s *Server) dbxUpload(ctx context.Context, p string, body io.Reader) (*dbxEntry, error) {
+    resp, err := s.dbxContentRPC(ctx, "/2/files/upload", map[string]any{"path": p, "mode": "overwrite", "mute": true}, body)
     if err != nil { return nil, err }
+    var e dbxEntry
+    if err := json.Unmarshal(resp, &e); err != nil { return nil, err }
+    return &e, nil
+}
+
+// dbxContentRPC calls a content endpoint that takes its arguments in the
+// Dropbox-API-Arg header and file data as the body.
+func (s *Server) dbxContentRPC(ctx context.Context, endpoint string, arg any, body io.Reader) ([]byte, error) {
+    s.metrics.add("avcs_dropbox_requests_total", 1, "endpoint", endpoint)
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, dbxContentHost+endpoint, body)
+    if err := s.dropbox.authorize(req); err != nil { return nil, err }
+    req.Header.Set("Content-Type", "application/octet-stream")
+    req.Header.Set("Dropbox-API-Arg", dbxArg(arg))
+    start := time.Now()
+    res, err := dbxContentClient.Do(req)
+    if err != nil { logDropbox(ctx, endpoint, start, 0, err); return nil, err }
     defer res.Body.Close()
     buf := new(bytes.Buffer); buf.ReadFrom(res.Body)
     if res.StatusCode != 200 {
-        return nil, fmt.Errorf("dropbox %s -> %s: %s", endpoint, res.Status, truncate(buf.String(), 400))
+        if res.StatusCode == http.StatusUnauthorized { s.dropbox.refused() }
+        err := fmt.Errorf("dropbox %s -> %s: %s", endpoint, res.Status, truncate(buf.String(), 400))
+        logDropbox(ctx, endpoint, start, res.StatusCode, err)
+        return nil, err
     }
+    logDropbox(ctx, endpoint, start, res.StatusCode, nil)
+    traceDropboxBody(ctx, endpoint, arg, buf.Bytes())
     return buf.Bytes(), nil
 }
 
+// dbxArg encodes a Dropbox-API-Arg header value; non-ASCII must be \u-escaped.
+func dbxArg(v any) string {
+    b, _ := json.Marshal(v)
+    var sb strings.Builder
+    for _, r := range string(b) {
+        if r < 0x80 { sb.WriteRune(r); continue }
+        if r > 0xFFFF {
+            r -= 0x10000
+            fmt.Fprintf(&sb, "\\u%04x\\u%04x", 0xD800+(r>>10), 0xDC00+(r&0x3FF))
+            continue
+        }
+        fmt.Fprintf(&sb, "\\u%04x", r)
+    }
+    return sb.String()
+}
+
+// isNotFound reports whether a Dropbox error is a path/not_found lookup failure.
+func isNotFound(err error) bool { return err != nil && strings.Contains(err.Error(), "not_found") }
+
+// contentHasher computes Dropbox's content_hash: SHA-256 over the concatenated
+// SHA-256 digests of each 4 MiB block.
+type contentHasher struct {
+    block   hash.Hash
+    n       int
+    overall hash.Hash
+}
+
+const dbxBlockSize = 4 << 20
+
+func newContentHasher() *contentHasher { return &contentHasher{block: sha256.New(), overall: sha256.New()} }
+
+func (h *contentHasher) Write(p []byte) (int, error) {
+    total := len(p)
+    for len(p) > 0 {
+        k := dbxBlockSize - h.n
+        if k > len(p) { k = len(p) }
+        h.block.Write(p[:k]); h.n += k; p = p[k:]
+        if h.n == dbxBlockSize { h.overall.Write(h.block.Sum(nil)); h.block.Reset(); h.n = 0 }
+    }
+    return total, nil
+}
+
+func (h *contentHasher) Sum() string {
+    if h.n > 0 { h.overall.Write(h.block.Sum(nil)); h.block.Reset(); h.n = 0 }
+    return hex.EncodeToString(h.overall.Sum(nil))
+}
+
 func truncate(s string, n int) string { if len(s) <= n { return s }; return s[:n] + "…" }
 
+// httpError carries a status code out of helpers that return plain errors.
+type httpError struct {
+    code int
+    msg  string
+}
+
+func (e httpError) Error() string { return e.msg }
+
+func writeError(w http.ResponseWriter, err error) {
+    var he httpError
+    if errors.As(err, &he) { http.Error(w, he.msg, he.code); return }
+    http.Error(w, err.Error(), 500)
+}
+
+func writeJSONStatus(w http.ResponseWriter, code int, v any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(code)
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    enc.Encode(v)
+}
+
 func writeJSON(w http.ResponseWriter, v any) {
     w.Header().Set("Content-Type", "application/json")
     w.Header().Set("Cache-Control", "no-store")
@@ -0,0 +1,218 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// ====== Maintenance Windows ======
+//
+// MAINTENANCE_WINDOWS keeps the work that saturates the studio's connection
+// to the times it is given, in the server's local time (TZ), separated by
+// semicolons. Each is a daily range, HH:MM-HH:MM (it may cross midnight), or
+// a five-field cron expression (minute hour day-of-month month day-of-week)
+// matching the minutes that belong to the window:
+//
+//   MAINTENANCE_WINDOWS="01:00-06:00; * 0-23 * * 6,0"   nights, and all weekend
+//
+// Outside the windows the scheduled reindex (REINDEX_INTERVAL) and manifest
+// uploads (WRITE_MANIFESTS) wait: whatever was put off runs as a reindex when
+// the next window opens. Verification with rehash, which downloads every
+// file, answers 503 with Retry-After unless asked with ?force=1. Reindexes
+// after changes made through the API are not held back. Without windows,
+// every time is maintenance time.
+
+// window is one maintenance window.
+type window struct {
+    spec     string
+    cron     *cronSpec
+    from, to int // minutes after midnight, for a daily range
+}
+
+// maintenance is the set of windows; nil means no restriction.
+type maintenance struct {
+    windows  []window
+    deferred atomic.Bool // work was put off until the next window
+}
+
+// loadMaintenance parses MAINTENANCE_WINDOWS; nil if it is not set.
+func loadMaintenance(v string) (*maintenance, error) {
+    m := &maintenance{}
+    for _, spec := range strings.Split(v, ";") {
+        spec = strings.TrimSpace(spec)
+        if spec == "" { continue }
+        w, err := parseWindow(spec)
+        if err != nil { return nil, fmt.Errorf("MAINTENANCE_WINDOWS: %q: %w", spec, err) }
+        m.windows = append(m.windows, w)
+    }
+    if len(m.windows) == 0 { return nil, nil }
+    return m, nil
+}
+
+func parseWindow(spec string) (window, error) {
+    w := window{spec: spec}
+    if from, to, ok := strings.Cut(spec, "-"); ok && !strings.ContainsAny(spec, " *,/") {
+        var err error
+        if w.from, err = clockMinutes(from); err != nil { return w, err }
+        if w.to, err = clockMinutes(to); err != nil { return w, err }
+        if w.from == w.to { return w, fmt.Errorf("the window is empty") }
+        return w, nil
+    }
+    c, err := parseCron(spec)
+    w.cron = c
+    return w, err
+}
+
+// clockMinutes reads HH:MM as minutes after midnight; 24:00 is the end of
+// the day.
+func clockMinutes(v string) (int, error) {
+    t, err := time.Parse("15:04", strings.TrimSpace(v))
+    if err == nil { return t.Hour()*60 + t.Minute(), nil }
+    if strings.TrimSpace(v) == "24:00" { return 24 * 60, nil }
+    return 0, fmt.Errorf("%q is not a time like 01:30", v)
+}
+
+// open reports whether t is in a window.
+func (m *maintenance) open(t time.Time) bool {
+    if m == nil { return true }
+    t = t.Local()
+    for _, w := range m.windows {
+        if w.cron != nil {
+            if w.cron.matches(t) { return true }
+            continue
+        }
+        min := t.Hour()*60 + t.Minute()
+        if w.from < w.to && min >= w.from && min < w.to || w.from > w.to && (min >= w.from || min < w.to) { return true }
+    }
+    return false
+}
+
+// next is when the next window opens after t; zero if none does within a
+// year.
+func (m *maintenance) next(t time.Time) time.Time {
+    if m == nil { return t }
+    t = t.Truncate(time.Minute)
+    for i := 0; i < 366*24*60; i++ {
+        t = t.Add(time.Minute)
+        if m.open(t) { return t }
+    }
+    return time.Time{}
+}
+
+// maintenanceOpen reports whether heavy work named what may run now, and
+// when it may not, remembers to catch up when the next window opens.
+func (s *Server) maintenanceOpen(ctx context.Context, what string) bool {
+    if s.maintenance.open(time.Now()) { return true }
+    if !s.maintenance.deferred.Swap(true) {
+        slog.InfoContext(ctx, "put off until the maintenance window", "work", what, "opens", s.maintenance.next(time.Now()))
+    }
+    return false
+}
+
+// watchMaintenance reindexes when a window opens if work was put off.
+func (s *Server) watchMaintenance() {
+    for range time.Tick(time.Minute) {
+        if !s.maintenance.open(time.Now()) || !s.leading() || !s.maintenance.deferred.Swap(false) { continue }
+        slog.Info("maintenance window open; running what was put off")
+        s.reindex(context.Background()) // logs its own failure
+    }
+}
+
+// GET /api/maintenance
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    m := s.maintenance
+    out := map[string]any{"windows": []string{}, "open": m.open(time.Now())}
+    if m != nil {
+        specs := []string{}
+        for _, w := range m.windows { specs = append(specs, w.spec) }
+        out["windows"], out["deferred"] = specs, m.deferred.Load()
+        if next := m.next(time.Now()); !out["open"].(bool) && !next.IsZero() { out["next"] = next }
+    }
+    writeJSON(w, out)
+}
+
+// refuseHeavy answers 503 outside the windows, unless ?force=1 is given.
+func (s *Server) refuseHeavy(w http.ResponseWriter, r *http.Request) bool {
+    if s.maintenance.open(time.Now()) || r.URL.Query().Get("force") == "1" { return false }
+    next := s.maintenance.next(time.Now())
+    msg := "this is only done in a maintenance window (add ?force=1 to do it now)"
+    if !next.IsZero() {
+        w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(next).Seconds())+1))
+        msg = fmt.Sprintf("this is only done in a maintenance window, the next opening at %s (add ?force=1 to do it now)", next.Format(time.RFC3339))
+    }
+    http.Error(w, msg, http.StatusServiceUnavailable)
+    return true
+}
+
+// ====== Cron Expressions ======
+
+// cronSpec is a parsed five-field cron expression.
+type cronSpec struct {
+    minute, hour, dom, month, dow uint64 // bit n set: value n matches
+    domAny, dowAny                bool
+}
+
+func parseCron(spec string) (*cronSpec, error) {
+    f := strings.Fields(spec)
+    if len(f) != 5 { return nil, fmt.Errorf("want HH:MM-HH:MM or five cron fields, got %d fields", len(f)) }
+    c := &cronSpec{domAny: f[2] == "*", dowAny: f[4] == "*"}
+    bounds := []struct {
+        set      *uint64
+        min, max int
+    }{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7}}
+    for i, b := range bounds {
+        set, err := cronField(f[i], b.min, b.max)
+        if err != nil { return nil, err }
+        *b.set = set
+    }
+    if c.dow&(1<<7) != 0 { c.dow |= 1 } // 7 is Sunday too
+    return c, nil
+}
+
+// cronField parses one field: *, n, a-b and */s or a-b/s, comma-separated.
+func cronField(v string, min, max int) (uint64, error) {
+    var set uint64
+    for _, part := range strings.Split(v, ",") {
+        rng, stepStr, hasStep := strings.Cut(part, "/")
+        lo, hi, step := min, max, 1
+        if hasStep {
+            n, err := strconv.Atoi(stepStr)
+            if err != nil || n <= 0 { return 0, fmt.Errorf("bad step in %q", part) }
+            step = n
+        }
+        if rng != "*" {
+            a, b, isRange := strings.Cut(rng, "-")
+            var err error
+            if lo, err = strconv.Atoi(a); err != nil { return 0, fmt.Errorf("bad value in %q", part) }
+            hi = lo
+            if isRange {
+                if hi, err = strconv.Atoi(b); err != nil { return 0, fmt.Errorf("bad value in %q", part) }
+            } else if hasStep {
+                hi = max
+            }
+        }
+        if lo < min || hi > max || lo > hi { return 0, fmt.Errorf("%q is outside %d-%d", part, min, max) }
+        for n := lo; n <= hi; n += step { set |= 1 << n }
+    }
+    return set, nil
+}
+
+// matches reports whether the minute of t is in c. As in cron, when both
+// day fields are restricted, either one matching is enough.
+func (c *cronSpec) matches(t time.Time) bool {
+    if c.minute&(1<<t.Minute()) == 0 || c.hour&(1<<t.Hour()) == 0 || c.month&(1<<int(t.Month())) == 0 { return false }
+    dom, dow := c.dom&(1<<t.Day()) != 0, c.dow&(1<<int(t.Weekday())) != 0
+    switch {
+    case c.domAny && c.dowAny: return true
+    case c.domAny: return dow
+    case c.dowAny: return dom
+    }
+    return dom || dow
+}
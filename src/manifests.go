@@ -0,0 +1,203 @@
+package main
+
+import (
+    "bytes"
+    "cmp"
+    "context"
+    "crypto/ed25519"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "avcs-browser/client"
+)
+
+// ====== Commit Manifests ======
+//
+// With WRITE_MANIFESTS set, every reindex writes <dir>/<TRACK>.avcs.json: the
+// track's artifacts, their content hashes and how they derive from each other,
+// signed with ed25519. The signature covers the compact JSON encoding of the
+// "manifest" member, so the file stays readable and verifiable without the server.
+
+const manifestFormat = "avcs-manifest/1"
+
+type (
+    ManifestFile   = client.ManifestFile
+    ManifestEdge   = client.ManifestEdge
+    Manifest       = client.Manifest
+    SignedManifest = client.SignedManifest
+)
+
+// loadManifestKey takes a base64 ed25519 seed from env, else from file,
+// generating and saving one on first use.
+func loadManifestKey(env, file string) (ed25519.PrivateKey, error) {
+    src := strings.TrimSpace(env)
+    if src == "" {
+        b, err := os.ReadFile(file)
+        switch {
+        case err == nil:
+            src = strings.TrimSpace(string(b))
+        case errors.Is(err, os.ErrNotExist):
+            seed := make([]byte, ed25519.SeedSize)
+            if _, err := rand.Read(seed); err != nil { return nil, err }
+            src = base64.StdEncoding.EncodeToString(seed)
+            if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil { return nil, err }
+            if err := os.WriteFile(file, []byte(src+"\n"), 0o600); err != nil { return nil, err }
+        default:
+            return nil, err
+        }
+    }
+    seed, err := base64.StdEncoding.DecodeString(src)
+    if err != nil || len(seed) != ed25519.SeedSize { return nil, fmt.Errorf("want a base64 %d-byte ed25519 seed", ed25519.SeedSize) }
+    return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func manifestPath(t *Track) string { return path.Join(t.Dir, t.Name+".avcs.json") }
+
+// trackFiles lists every indexed file of t with what the convention says it is.
+func trackFiles(t *Track) []ManifestFile {
+    out := []ManifestFile{}
+    add := func(f ManifestFile) { out = append(out, f) }
+    for _, a := range t.Ableton {
+        for _, f := range []*FileRef{a.ALS, a.Session, a.WAV, a.MP3} {
+            if f == nil { continue }
+            part := ""
+            if f == a.ALS || f == a.Session { part = a.DAW }
+            add(ManifestFile{Kind: kindSnapshot, T1: a.T1, Part: part, FileRef: *f})
+        }
+        for _, f := range a.MIDI {
+            np, _ := classifyName(f.Name)
+            add(ManifestFile{Kind: kindSnapshot, T1: a.T1, Part: cmp.Or(np.Stem, "midi"), FileRef: f})
+        }
+        for _, b := range a.Backups { add(ManifestFile{Kind: kindBackup, T1: a.T1, Part: b.Stamp, FileRef: b.FileRef}) }
+    }
+    for _, st := range t.Stems {
+        for _, f := range st.Stems { add(ManifestFile{Kind: kindStem, T1: st.T1, T2: st.T2, Part: strings.TrimSuffix(f.Name, ".wav"), FileRef: f}) }
+    }
+    for _, x := range t.Mixes { add(ManifestFile{Kind: kindMix, T1: x.T1, T2: x.T2, FileRef: x.File}) }
+    for _, p := range t.Prints { add(ManifestFile{Kind: kindPrint, T1: p.T1, T2: p.T2, Part: p.Chain, FileRef: p.File}) }
+    for _, ms := range t.Masters {
+        for _, c := range ms.Candidates { add(ManifestFile{Kind: kindMaster, T1: ms.T1, T2: ms.T2, Part: candidateIdx(c), FileRef: c}) }
+        if ms.Final != nil { add(ManifestFile{Kind: kindMaster, T1: ms.T1, T2: ms.T2, Part: "FINAL", FileRef: *ms.Final}) }
+    }
+    for _, l := range t.Lyrics { add(ManifestFile{Kind: kindLyrics, T1: l.T1, FileRef: l.FileRef}) }
+    // Index entries carry the stem's short name; report real file names.
+    for i := range out { out[i].Name = path.Base(out[i].Path) }
+    return out
+}
+
+func buildManifest(t *Track) *Manifest {
+    m := &Manifest{Format: manifestFormat, Track: t.Name, Parent: t.Parent, Files: trackFiles(t), Lineage: []ManifestEdge{}}
+    for _, f := range m.Files {
+        if f.ServerModified.After(m.Updated) { m.Updated = f.ServerModified }
+    }
+
+    // Lineage: each version's chain snapshot -> stems -> mix -> masters, skipping
+    // missing steps, each print from the latest step before it, and the
+    // candidate a FINAL duplicates (same content hash).
+    type version struct{ t1, t2 string }
+    var versions []version
+    seen := map[version]bool{}
+    note := func(t1, t2 string) {
+        if v := (version{t1, t2}); !seen[v] { seen[v] = true; versions = append(versions, v) }
+    }
+    for _, st := range t.Stems { note(st.T1, st.T2) }
+    for _, x := range t.Mixes { note(x.T1, x.T2) }
+    for _, ms := range t.Masters { note(ms.T1, ms.T2) }
+    sort.Slice(versions, func(i, j int) bool {
+        if versions[i].t1 == versions[j].t1 { return versions[i].t2 < versions[j].t2 }
+        return versions[i].t1 < versions[j].t1
+    })
+    for _, v := range versions {
+        var prev *ArtifactRef
+        for _, ref := range []ArtifactRef{
+            {Kind: kindSnapshot, T1: v.t1},
+            {Kind: kindStems, T1: v.t1, T2: v.t2},
+            {Kind: kindMix, T1: v.t1, T2: v.t2},
+            {Kind: kindMaster, T1: v.t1, T2: v.t2},
+        } {
+            if ref.Resolve(t) != nil { continue }
+            if prev != nil { m.Lineage = append(m.Lineage, ManifestEdge{From: *prev, To: ref}) }
+            prev = &ref
+        }
+    }
+    for _, p := range t.Prints {
+        for _, from := range []ArtifactRef{{Kind: kindMix, T1: p.T1, T2: p.T2}, {Kind: kindStems, T1: p.T1, T2: p.T2}, {Kind: kindSnapshot, T1: p.T1}} {
+            if from.Resolve(t) != nil { continue }
+            m.Lineage = append(m.Lineage, ManifestEdge{From: from, To: ArtifactRef{Kind: kindPrint, T1: p.T1, T2: p.T2, Idx: p.Chain}})
+            break
+        }
+    }
+    for _, ms := range t.Masters {
+        if ms.Final == nil || ms.Final.ContentHash == "" { continue }
+        for _, c := range ms.Candidates {
+            if c.ContentHash == ms.Final.ContentHash {
+                m.Lineage = append(m.Lineage, ManifestEdge{
+                    From: ArtifactRef{Kind: kindMaster, T1: ms.T1, T2: ms.T2, Idx: candidateIdx(c)},
+                    To:   ArtifactRef{Kind: kindMaster, T1: ms.T1, T2: ms.T2, Idx: "FINAL"},
+                })
+            }
+        }
+    }
+    return m
+}
+
+// signManifest renders the file written to storage.
+func (s *Server) signManifest(m *Manifest) ([]byte, error) {
+    body, err := json.Marshal(m)
+    if err != nil { return nil, err }
+    sm := SignedManifest{
+        Manifest:  body,
+        Algorithm: "ed25519",
+        PublicKey: base64.StdEncoding.EncodeToString(s.manifestKey.Public().(ed25519.PublicKey)),
+        Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.manifestKey, body)),
+    }
+    out, err := json.MarshalIndent(sm, "", "  ")
+    if err != nil { return nil, err }
+    return append(out, '\n'), nil
+}
+
+// syncManifests uploads the manifest of every track whose stored copy, by
+// existing (lower-case path -> content hash), differs.
+func (s *Server) syncManifests(ctx context.Context, tracks map[string]*Track, existing map[string]string) {
+    s.writeMu.Lock(); defer s.writeMu.Unlock()
+    written := 0
+    for _, t := range tracks {
+        if t.Dir == "" { continue }
+        b, err := s.signManifest(buildManifest(t))
+        if err != nil { slog.ErrorContext(ctx, "writing manifest failed", "track", t.Name, "error", err); continue }
+        h := newContentHasher(); h.Write(b)
+        p := manifestPath(t)
+        if existing[strings.ToLower(p)] == h.Sum() { continue }
+        if _, err := s.dbxUpload(ctx, p, bytes.NewReader(b)); err != nil { slog.ErrorContext(ctx, "writing manifest failed", "track", t.Name, "error", err); continue }
+        written++
+    }
+    if written > 0 { slog.InfoContext(ctx, "wrote track manifests", "count", written) }
+}
+
+// GET /api/tracks/{name}/manifest
+// The signed manifest as it is (or would be) written to the track folder.
+func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request, t *Track) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    b, err := s.signManifest(buildManifest(t))
+    if err != nil { http.Error(w, err.Error(), 500); return }
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(b)
+}
+
+// GET /api/manifest-key: the public key manifests are signed with.
+func (s *Server) handleManifestKey(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, map[string]string{
+        "algorithm":  "ed25519",
+        "public_key": base64.StdEncoding.EncodeToString(s.manifestKey.Public().(ed25519.PublicKey)),
+    })
+}
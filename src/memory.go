@@ -0,0 +1,105 @@
+package main
+
+import (
+    "strings"
+)
+
+// ====== Index Memory ======
+//
+// A catalog of hundreds of thousands of files is mostly strings, and most of
+// them repeat: every stem of a set carries its T1, T2 and contributor, and
+// every set the same stem names. The index keeps each once. A file's name is
+// the end of its path rather than a copy of it, and the rest is interned,
+// both in the index as built and in one decoded from index.json or a backup,
+// where every string arrives as a copy of its own. Dropbox listings, kept
+// between reindexes (see listing.go), keep a path's lower-case form only
+// where it is not just the path in lower case (see dbxEntry.lower). SPILL_HISTORY keeps
+// the audit log out of memory too (see audit.go).
+
+// interner hands out one copy of each string it is given.
+type interner map[string]string
+
+func (in interner) of(s string) string {
+    if s == "" { return "" }
+    if v, ok := in[s]; ok { return v }
+    s = strings.Clone(s) // not a slice of something larger
+    in[s] = s
+    return s
+}
+
+// file shares f's strings: its name with its path, the rest through in.
+func (in interner) file(f *FileRef) {
+    if f == nil { return }
+    if n := len(f.Path) - len(f.Name); n > 0 && f.Path[n-1] == '/' && f.Path[n:] == f.Name {
+        f.Name = f.Path[n:]
+    } else {
+        f.Name = in.of(f.Name) // a stem's, which is not its file's
+    }
+    f.ContentHash = in.of(f.ContentHash) // alike for copies, as a candidate promoted to FINAL
+    f.ContributedBy = in.of(f.ContributedBy)
+}
+
+// compactTracks shares the strings of tracks, which nothing else may be
+// reading yet.
+func compactTracks(tracks map[string]*Track) {
+    in := interner{}
+    for _, t := range tracks {
+        t.Parent, t.Branch, t.Dir = in.of(t.Parent), in.of(t.Branch), in.of(t.Dir)
+        for i := range t.Ableton {
+            a := &t.Ableton[i]
+            a.T1, a.DAW = in.of(a.T1), in.of(a.DAW)
+            for _, f := range []*FileRef{a.ALS, a.Session, a.WAV, a.MP3} { in.file(f) }
+            for j := range a.MIDI { in.file(&a.MIDI[j]) }
+            for j := range a.Backups { in.file(&a.Backups[j].FileRef) }
+        }
+        for i := range t.Stems {
+            st := &t.Stems[i]
+            st.T1, st.T2 = in.of(st.T1), in.of(st.T2)
+            for j := range st.Stems { in.file(&st.Stems[j]) }
+        }
+        for i := range t.Mixes {
+            m := &t.Mixes[i]
+            m.T1, m.T2 = in.of(m.T1), in.of(m.T2)
+            in.file(&m.File)
+        }
+        for i := range t.Prints {
+            p := &t.Prints[i]
+            p.T1, p.T2, p.Chain = in.of(p.T1), in.of(p.T2), in.of(p.Chain)
+            in.file(&p.File)
+        }
+        for i := range t.Masters {
+            ms := &t.Masters[i]
+            ms.T1, ms.T2 = in.of(ms.T1), in.of(ms.T2)
+            for j := range ms.Candidates { in.file(&ms.Candidates[j]) }
+            in.file(ms.Final)
+        }
+        for i := range t.Artwork { in.file(&t.Artwork[i]) }
+        for i := range t.References { in.file(&t.References[i]) }
+        for i := range t.Ideas { in.file(&t.Ideas[i].FileRef) }
+        for i := range t.Lyrics {
+            t.Lyrics[i].T1 = in.of(t.Lyrics[i].T1)
+            in.file(&t.Lyrics[i].FileRef)
+        }
+        for i := range t.Conflicts {
+            in.file(&t.Conflicts[i].Copy)
+            in.file(t.Conflicts[i].Original)
+        }
+        for i, b := range t.Branches { t.Branches[i] = in.of(b) }
+    }
+}
+
+// lower is e's path in lower case, as Dropbox compares paths.
+func (e *dbxEntry) lower() string {
+    if e.PathLower != "" { return e.PathLower }
+    return strings.ToLower(e.PathDisplay)
+}
+
+// compactEntries drops from a listing what can be had again from the rest:
+// lower-case paths that lower gives, and names that are the end of the path.
+func compactEntries(entries []dbxEntry) {
+    for i := range entries {
+        e := &entries[i]
+        if e.PathLower == strings.ToLower(e.PathDisplay) { e.PathLower = "" }
+        if strings.HasSuffix(e.PathDisplay, "/"+e.Name) { e.Name = e.PathDisplay[len(e.PathDisplay)-len(e.Name):] }
+    }
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "maps"
+    "net/http"
+    "path"
+    "regexp"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Track Metadata ======
+//
+// What the files do not say about a song (tempo, key, genre, who worked on
+// it, its ISRC and its branches', release date, the project it belongs to
+// and free-form notes) lives
+// next to them in <track dir>/track.yaml, so it travels with the folder and stays editable by
+// hand. Only a small subset of YAML is read and written: top-level keys with
+// plain or quoted scalars, "- item" or [a, b] lists, and "|" blocks. Editing
+// through the API rewrites the known keys and leaves everything else in the
+// file (comments, keys of other tools) as it was.
+
+const metadataFile = "track.yaml"
+
+type TrackMeta = client.TrackMeta
+
+var (
+    rxISRC      = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$`)
+    rxBranch    = regexp.MustCompile(`^[A-Z0-9_]+$`)
+    rxYAMLPlain = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9 ._/()+&,#'-]*$`)
+)
+
+// isMetadataFile reports whether e is the track.yaml of a track folder.
+func (s *Server) isMetadataFile(e *dbxEntry) bool {
+    return e.Tag == "file" && path.Base(e.lower()) == metadataFile && path.Dir(e.PathDisplay) == s.trackDir(e.PathDisplay)
+}
+
+// normalISRC is an ISRC as stored: upper case, without dashes.
+func normalISRC(code string) string {
+    return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// validateMeta normalizes m and checks each field.
+func validateMeta(m *TrackMeta) error {
+    m.Key, m.Genre, m.Notes = strings.TrimSpace(m.Key), strings.TrimSpace(m.Genre), strings.TrimSpace(m.Notes)
+    m.ISRC = normalISRC(m.ISRC)
+    m.ReleaseDate = strings.TrimSpace(m.ReleaseDate)
+    m.Project = projectName(m.Project)
+    var people []string
+    for _, c := range m.Collaborators {
+        if c = strings.TrimSpace(c); c != "" { people = append(people, c) }
+    }
+    m.Collaborators = people
+    if m.BPM < 0 || m.BPM > 999 { return fmt.Errorf("bpm must be between 0 and 999") }
+    if m.ISRC != "" && !rxISRC.MatchString(m.ISRC) { return fmt.Errorf("isrc must look like CC-XXX-YY-NNNNN") }
+    branches := map[string]string{}
+    for b, code := range m.BranchISRCs {
+        b, code = strings.ToUpper(strings.TrimSpace(b)), normalISRC(code)
+        if b == "" || !rxBranch.MatchString(b) { return fmt.Errorf("branch_isrcs: %q is not a branch name", b) }
+        if code == "" { continue }
+        if !rxISRC.MatchString(code) { return fmt.Errorf("branch_isrcs: %s's isrc must look like CC-XXX-YY-NNNNN", b) }
+        if code == m.ISRC || slices.Contains(slices.Collect(maps.Values(branches)), code) { return fmt.Errorf("branch_isrcs: %s is given twice", code) }
+        branches[b] = code
+    }
+    m.BranchISRCs = branches
+    if len(branches) == 0 { m.BranchISRCs = nil }
+    if m.ReleaseDate != "" {
+        if _, err := time.Parse("2006-01-02", m.ReleaseDate); err != nil { return fmt.Errorf("release_date must be YYYY-MM-DD") }
+    }
+    if m.Project != "" && !rxBranch.MatchString(m.Project) { return fmt.Errorf("project: %q is not a project name", m.Project) }
+    for _, v := range append([]string{m.Key, m.Genre, m.ISRC}, m.Collaborators...) {
+        if strings.ContainsAny(v, "\r\n") { return fmt.Errorf("only notes may span lines") }
+    }
+    return nil
+}
+
+// yamlBlock is one top-level key of a YAML document and the lines under it,
+// or (with no key) comments and blank lines before the first key.
+type yamlBlock struct {
+    key   string
+    lines []string
+}
+
+// splitYAML cuts a document into top-level blocks.
+func splitYAML(doc string) []yamlBlock {
+    var out []yamlBlock
+    for _, line := range strings.Split(strings.ReplaceAll(doc, "\r\n", "\n"), "\n") {
+        trimmed := strings.TrimSpace(line)
+        if line != "" && line[0] != ' ' && line[0] != '\t' && line[0] != '#' && line[0] != '-' && trimmed != "---" {
+            if k, _, ok := strings.Cut(line, ":"); ok {
+                out = append(out, yamlBlock{key: strings.TrimSpace(k), lines: []string{line}})
+                continue
+            }
+        }
+        if len(out) == 0 { out = append(out, yamlBlock{}) }
+        out[len(out)-1].lines = append(out[len(out)-1].lines, line)
+    }
+    for len(out) > 0 { // no trailing blank lines
+        b := &out[len(out)-1]
+        if n := len(b.lines); n > 0 && strings.TrimSpace(b.lines[n-1]) == "" { b.lines = b.lines[:n-1]; continue }
+        if len(b.lines) == 0 { out = out[:len(out)-1]; continue }
+        break
+    }
+    return out
+}
+
+// yamlScalar reads a plain or quoted scalar, dropping a trailing comment.
+func yamlScalar(v string) (string, error) {
+    v = strings.TrimSpace(v)
+    switch {
+    case strings.HasPrefix(v, `"`):
+        end := 1
+        for ; end < len(v); end++ {
+            if v[end] == '\\' { end++; continue }
+            if v[end] == '"' { break }
+        }
+        if end >= len(v) { return "", fmt.Errorf("unterminated string %s", v) }
+        var s string
+        if err := json.Unmarshal([]byte(v[:end+1]), &s); err != nil { return "", fmt.Errorf("bad string %s", v[:end+1]) }
+        return s, nil
+    case strings.HasPrefix(v, "'"):
+        var b strings.Builder
+        for i := 1; i < len(v); i++ {
+            if v[i] != '\'' { b.WriteByte(v[i]); continue }
+            if i+1 < len(v) && v[i+1] == '\'' { b.WriteByte('\''); i++; continue }
+            return b.String(), nil
+        }
+        return "", fmt.Errorf("unterminated string %s", v)
+    }
+    if i := strings.Index(v, " #"); i >= 0 { v = v[:i] }
+    if strings.HasPrefix(v, "#") { return "", nil }
+    v = strings.TrimSpace(v)
+    if v == "~" || strings.EqualFold(v, "null") { return "", nil }
+    return v, nil
+}
+
+// value is the block's scalar, list items or "|" text.
+func (b yamlBlock) value() (string, []string, error) {
+    _, rest, _ := strings.Cut(b.lines[0], ":")
+    rest = strings.TrimSpace(rest)
+    body := b.lines[1:]
+    switch {
+    case rest == "|" || rest == "|-" || rest == ">" || rest == ">-":
+        indent := -1
+        for _, l := range body {
+            if strings.TrimSpace(l) == "" { continue }
+            if n := len(l) - len(strings.TrimLeft(l, " ")); indent < 0 || n < indent { indent = n }
+        }
+        var text []string
+        for _, l := range body {
+            if len(l) >= indent && indent >= 0 { l = l[indent:] } else { l = strings.TrimSpace(l) }
+            text = append(text, l)
+        }
+        sep := "\n"
+        if rest[0] == '>' { sep = " " }
+        return strings.TrimRight(strings.Join(text, sep), "\n "), nil, nil
+
+    case strings.HasPrefix(rest, "["):
+        inner, ok := strings.CutSuffix(strings.TrimSpace(strings.SplitN(rest, " #", 2)[0]), "]")
+        if !ok { return "", nil, fmt.Errorf("%s: unterminated list", b.key) }
+        var items []string
+        for _, it := range strings.Split(inner[1:], ",") {
+            v, err := yamlScalar(it)
+            if err != nil { return "", nil, fmt.Errorf("%s: %w", b.key, err) }
+            if v != "" { items = append(items, v) }
+        }
+        return "", items, nil
+
+    case rest == "" || strings.HasPrefix(rest, "#"):
+        var items []string
+        for _, l := range body {
+            l = strings.TrimSpace(l)
+            if l == "" || strings.HasPrefix(l, "#") { continue }
+            item, ok := strings.CutPrefix(l, "-")
+            if !ok { return "", nil, fmt.Errorf("%s: expected a \"- item\" list", b.key) }
+            v, err := yamlScalar(item)
+            if err != nil { return "", nil, fmt.Errorf("%s: %w", b.key, err) }
+            items = append(items, v)
+        }
+        return "", items, nil
+    }
+    v, err := yamlScalar(rest)
+    if err != nil { return "", nil, fmt.Errorf("%s: %w", b.key, err) }
+    return v, nil, nil
+}
+
+// parseTrackMeta reads track.yaml. Keys it does not know are ignored.
+func parseTrackMeta(doc string) (*TrackMeta, error) {
+    m := &TrackMeta{}
+    for _, b := range splitYAML(doc) {
+        key := strings.ToLower(b.key)
+        switch key {
+        case "bpm", "key", "genre", "isrc", "branch_isrcs", "release_date", "project", "notes", "collaborators":
+        default:
+            continue // not ours, however it is laid out
+        }
+        v, list, err := b.value()
+        if err != nil { return nil, err }
+        switch key {
+        case "bpm":
+            if v == "" { continue }
+            if m.BPM, err = strconv.ParseFloat(v, 64); err != nil { return nil, fmt.Errorf("bpm: %q is not a number", v) }
+        case "key": m.Key = v
+        case "genre": m.Genre = v
+        case "isrc": m.ISRC = v
+        case "branch_isrcs":
+            m.BranchISRCs = map[string]string{}
+            for _, it := range list {
+                b, code, ok := strings.Cut(it, "=")
+                if !ok { return nil, fmt.Errorf("branch_isrcs: %q is not BRANCH=ISRC", it) }
+                m.BranchISRCs[b] = code
+            }
+        case "release_date": m.ReleaseDate = v
+        case "project": m.Project = v
+        case "notes": m.Notes = v
+        case "collaborators":
+            m.Collaborators = list
+            if v != "" { m.Collaborators = []string{v} }
+        }
+    }
+    if err := validateMeta(m); err != nil { return nil, err }
+    return m, nil
+}
+
+// yamlString writes s plain where YAML reads it back as the same string.
+func yamlString(s string) string {
+    switch strings.ToLower(s) {
+    case "true", "false", "yes", "no", "on", "off", "null":
+    default:
+        if rxYAMLPlain.MatchString(s) && !strings.Contains(s, " #") && !strings.HasSuffix(s, " ") { return s }
+    }
+    b, _ := json.Marshal(s) // JSON strings are valid YAML double-quoted scalars
+    return string(b)
+}
+
+// renderYAML replaces m's keys in doc, keeping the rest of it.
+func renderYAML(doc string, m *TrackMeta) string {
+    blocks := splitYAML(doc)
+    set := func(key string, lines ...string) {
+        for i := range blocks {
+            if strings.EqualFold(blocks[i].key, key) {
+                if len(lines) == 0 { blocks = append(blocks[:i], blocks[i+1:]...) } else { blocks[i].lines = lines }
+                return
+            }
+        }
+        if len(lines) > 0 { blocks = append(blocks, yamlBlock{key: key, lines: lines}) }
+    }
+    scalar := func(key, v string) {
+        if v == "" { set(key); return }
+        set(key, key+": "+yamlString(v))
+    }
+    if m.BPM != 0 { set("bpm", "bpm: "+strconv.FormatFloat(m.BPM, 'f', -1, 64)) } else { set("bpm") }
+    scalar("key", m.Key)
+    scalar("genre", m.Genre)
+    if len(m.Collaborators) > 0 {
+        lines := []string{"collaborators:"}
+        for _, c := range m.Collaborators { lines = append(lines, "  - "+yamlString(c)) }
+        set("collaborators", lines...)
+    } else {
+        set("collaborators")
+    }
+    scalar("isrc", m.ISRC)
+    if len(m.BranchISRCs) > 0 {
+        lines := []string{"branch_isrcs:"}
+        for _, b := range slices.Sorted(maps.Keys(m.BranchISRCs)) { lines = append(lines, "  - "+b+"="+m.BranchISRCs[b]) }
+        set("branch_isrcs", lines...)
+    } else {
+        set("branch_isrcs")
+    }
+    scalar("release_date", m.ReleaseDate)
+    scalar("project", m.Project)
+    if strings.Contains(m.Notes, "\n") {
+        lines := []string{"notes: |"}
+        for _, l := range strings.Split(m.Notes, "\n") { lines = append(lines, strings.TrimRight("  "+l, " ")) }
+        set("notes", lines...)
+    } else {
+        scalar("notes", m.Notes)
+    }
+
+    var out []string
+    for _, b := range blocks { out = append(out, b.lines...) }
+    return strings.Join(out, "\n") + "\n"
+}
+
+// readMetadataFile downloads a track.yaml; a missing one reads as empty.
+func (s *Server) readMetadataFile(ctx context.Context, p string) (string, error) {
+    body, err := s.dbxDownload(ctx, p)
+    if isNotFound(err) { return "", nil }
+    if err != nil { return "", err }
+    defer body.Close()
+    b, err := io.ReadAll(io.LimitReader(body, 1<<20))
+    return string(b), err
+}
+
+// trackMeta parses the track.yaml e, caching by path and revision.
+func (s *Server) trackMeta(ctx context.Context, e *dbxEntry) (*TrackMeta, error) {
+    key := e.lower() + "@" + e.ContentHash + "@" + e.ServerModified.Format(time.RFC3339)
+    s.alsMu.Lock(); m := s.metaCache[key]; s.alsMu.Unlock()
+    if m != nil { return m, nil }
+    doc, err := s.readMetadataFile(ctx, e.PathDisplay)
+    if err != nil { return nil, err }
+    if m, err = parseTrackMeta(doc); err != nil { return nil, fmt.Errorf("%s: %w", e.PathDisplay, err) }
+    s.alsMu.Lock(); s.metaCache[key] = m; s.alsMu.Unlock()
+    return m, nil
+}
+
+// GET /api/tracks/{name}/metadata
+// PUT /api/tracks/{name}/metadata {"bpm":124,"key":"F# minor","genre":"House","collaborators":["Kim"],"isrc":"US-ABC-25-00001","branch_isrcs":{"RADIO_EDIT":"US-ABC-25-00002"},"release_date":"2025-03-01","project":"NIGHT_DRIVE","notes":"..."}
+// PUT replaces every field (omitted ones are cleared) and writes track.yaml
+// back to the track folder. Branches share their parent's file. An ISRC
+// another track has is refused.
+func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request, t *Track) {
+    switch r.Method {
+    case http.MethodGet:
+        m := t.Metadata
+        if m == nil { m = &TrackMeta{} }
+        out := map[string]any{"track": t.Name, "path": path.Join(t.Dir, metadataFile), "metadata": m}
+        if t.MetadataError != "" { out["error"] = t.MetadataError }
+        writeJSON(w, out)
+
+    case http.MethodPut:
+        if t.Parent != "" { http.Error(w, "branches share "+t.Parent+"'s metadata; edit it there", 400); return }
+        if t.Dir == "" { http.Error(w, t.Name+" has no folder", 409); return }
+        if t.Archived { http.Error(w, t.Name+" is archived", 409); return }
+        var m TrackMeta
+        if err := json.NewDecoder(r.Body).Decode(&m); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        if err := validateMeta(&m); err != nil { http.Error(w, err.Error(), 400); return }
+        mine := func(owner string) bool { return owner == t.Name || strings.HasPrefix(owner, t.Name+".") }
+        for _, code := range append(slices.Collect(maps.Values(m.BranchISRCs)), m.ISRC) {
+            if other := s.isrcTaken(code, mine); code != "" && other != "" { http.Error(w, code+" is "+other+"'s ISRC", 409); return }
+        }
+
+        p := path.Join(t.Dir, metadataFile)
+        s.writeMu.Lock()
+        doc, err := s.readMetadataFile(r.Context(), p)
+        if err == nil { _, err = s.dbxUpload(r.Context(), p, bytes.NewReader([]byte(renderYAML(doc, &m)))) }
+        s.writeMu.Unlock()
+        if err != nil { http.Error(w, err.Error(), 502); return }
+        s.audit(r, "metadata", t.Name, t.Metadata, m)
+        if err := s.reindex(r.Context()); err != nil { http.Error(w, "saved, but reindex failed: "+err.Error(), 500); return }
+        writeJSON(w, map[string]any{"track": t.Name, "path": p, "metadata": m})
+
+    default:
+        http.Error(w, "GET or PUT required", 405)
+    }
+}
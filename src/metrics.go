@@ -0,0 +1,62 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// ====== Metrics ======
+//
+// Counters kept in memory since the server started, served by GET
+// /api/metrics in the Prometheus text format (admin scope; a scraper sends an
+// admin key as its bearer token).
+
+var metricHelp = map[string]string{
+    "avcs_requests_total":         "HTTP requests, by class: fetch (links, streams, bundles, shared files), api or other.",
+    "avcs_rate_limited_total":     "Requests refused with 429, by the limit they hit.",
+    "avcs_dropbox_requests_total": "Calls made to the Dropbox API, by endpoint.",
+}
+
+type metrics struct {
+    mu       sync.Mutex
+    counters map[string]map[string]float64 // name -> rendered labels -> value
+}
+
+func newMetrics() *metrics { return &metrics{counters: map[string]map[string]float64{}} }
+
+// add adds v to the counter name with the given label pairs ("endpoint", "/2/files/download", ...).
+func (m *metrics) add(name string, v float64, labels ...string) {
+    var b strings.Builder
+    for i := 0; i+1 < len(labels); i += 2 {
+        if b.Len() > 0 { b.WriteByte(',') }
+        fmt.Fprintf(&b, "%s=%q", labels[i], labels[i+1])
+    }
+    m.mu.Lock(); defer m.mu.Unlock()
+    if m.counters[name] == nil { m.counters[name] = map[string]float64{} }
+    m.counters[name][b.String()] += v
+}
+
+// GET /api/metrics
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    m := s.metrics
+    m.mu.Lock(); defer m.mu.Unlock()
+    names := make([]string, 0, len(m.counters))
+    for n := range m.counters { names = append(names, n) }
+    sort.Strings(names)
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    for _, n := range names {
+        if h := metricHelp[n]; h != "" { fmt.Fprintf(w, "# HELP %s %s\n", n, h) }
+        fmt.Fprintf(w, "# TYPE %s counter\n", n)
+        labels := make([]string, 0, len(m.counters[n]))
+        for l := range m.counters[n] { labels = append(labels, l) }
+        sort.Strings(labels)
+        for _, l := range labels {
+            if l == "" { fmt.Fprintf(w, "%s %g\n", n, m.counters[n][l]); continue }
+            fmt.Fprintf(w, "%s{%s} %g\n", n, l, m.counters[n][l])
+        }
+    }
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "net/url"
+    "sort"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== MIDI ======
+//
+// Some collaborators work from MIDI rather than stems. MIDI exported from a
+// snapshot is named like its session, with an optional part:
+//
+//   <TRACK>-<T1>.mid          the whole arrangement
+//   <TRACK>-<T1>-<PART>.mid   one part, e.g. NEON_RAIN-1040P-BASS.mid
+//
+// and is listed with the snapshot (midi). GET
+// /api/tracks/{name}/ableton/{T1}/midi reads each file's header and events:
+// its tracks with their names, notes and channels, its length, and the
+// tempo and time signature changes, with a download link (/api/link) for
+// each. What is read is cached by path and revision, like session info.
+
+const midiLimit = 16 << 20 // bytes of a MIDI file read
+
+type (
+    MidiInfo  = client.MidiInfo
+    MidiTempo = client.MidiTempo
+    MidiMeter = client.MidiMeter
+    MidiPart  = client.MidiPart
+)
+
+// midiTempo is a tempo change in ticks and microseconds per beat.
+type midiTempo struct {
+    tick int64
+    us   float64
+}
+
+// parseMIDI reads a Standard MIDI File.
+func parseMIDI(r io.Reader) (*MidiInfo, error) {
+    b, err := io.ReadAll(io.LimitReader(r, midiLimit))
+    if err != nil { return nil, err }
+    if len(b) < 14 || string(b[:4]) != "MThd" { return nil, errors.New("not a Standard MIDI File") }
+    hl := int(binary.BigEndian.Uint32(b[4:8]))
+    if hl < 6 || 8+hl > len(b) { return nil, errors.New("short MIDI header") }
+    info := &MidiInfo{Format: int(binary.BigEndian.Uint16(b[8:10])), Tempo: []MidiTempo{}, Parts: []MidiPart{}}
+    division := binary.BigEndian.Uint16(b[12:14])
+    smpte := division&0x8000 != 0
+    if !smpte { info.TicksPerBeat = int(division) }
+    if !smpte && division == 0 { return nil, errors.New("MIDI header gives no ticks per beat") }
+
+    var tempos []midiTempo
+    type meter struct {
+        tick int64
+        sig  string
+    }
+    var meters []meter
+    var end int64
+    for off := 8 + hl; off+8 <= len(b); {
+        id, n := string(b[off:off+4]), int(binary.BigEndian.Uint32(b[off+4:off+8]))
+        off += 8
+        if n > len(b)-off { n = len(b) - off } // cut short; read what is there
+        chunk := b[off : off+n]
+        off += n
+        if id != "MTrk" { continue }
+        part := MidiPart{}
+        channels := map[int]bool{}
+        var tick int64
+        var running byte
+        for i := 0; i < len(chunk); {
+            delta, k := midiVarLen(chunk[i:])
+            if k == 0 { break }
+            i += k
+            tick += delta
+            if i >= len(chunk) { break }
+            status := chunk[i]
+            if status < 0x80 { // running status: the data starts here
+                if running == 0 { return nil, fmt.Errorf("track %d: data byte without a status", len(info.Parts)+1) }
+                status = running
+            } else {
+                i++
+            }
+            switch {
+            case status == 0xFF:
+                if i >= len(chunk) { break }
+                kind := chunk[i]
+                l, k := midiVarLen(chunk[i+1:])
+                data := chunk[min(i+1+k, len(chunk)):min(i+1+k+int(l), len(chunk))]
+                i += 1 + k + int(l)
+                switch {
+                case kind == 0x51 && len(data) == 3:
+                    us := float64(uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2]))
+                    if us > 0 { tempos = append(tempos, midiTempo{tick, us}) }
+                case kind == 0x58 && len(data) >= 2 && data[1] < 8:
+                    meters = append(meters, meter{tick, fmt.Sprintf("%d/%d", data[0], 1<<data[1])})
+                case kind == 0x03 && part.Name == "":
+                    part.Name = string(data)
+                }
+            case status == 0xF0 || status == 0xF7:
+                l, k := midiVarLen(chunk[i:])
+                i += k + int(l)
+                running = 0
+            default:
+                running = status
+                size := 2
+                if status>>4 == 0xC || status>>4 == 0xD { size = 1 }
+                if i+size > len(chunk) { i = len(chunk); break }
+                if status>>4 == 0x9 && chunk[i+1] > 0 {
+                    part.Notes++
+                    channels[int(status&0x0F)+1] = true
+                }
+                i += size
+            }
+        }
+        for c := 1; c <= 16; c++ {
+            if channels[c] { part.Channels = append(part.Channels, c) }
+        }
+        info.Parts = append(info.Parts, part)
+        end = max(end, tick)
+    }
+    info.Tracks = len(info.Parts)
+    if info.Tracks == 0 { return nil, errors.New("no MIDI tracks") }
+
+    if smpte {
+        fps := -int(int8(division >> 8))
+        if fps == 29 { fps = 30 } // 29.97 drop-frame counts 30 frames a second
+        perSecond := float64(fps * int(division&0xFF))
+        if perSecond <= 0 { return nil, errors.New("MIDI header gives no SMPTE rate") }
+        info.Length = round3(float64(end) / perSecond)
+        return info, nil
+    }
+    sort.SliceStable(tempos, func(i, j int) bool { return tempos[i].tick < tempos[j].tick })
+    if len(tempos) == 0 || tempos[0].tick > 0 { tempos = append([]midiTempo{{0, 500000}}, tempos...) }
+    beats := func(tick int64) float64 { return float64(tick) / float64(division) }
+    seconds := func(tick int64) float64 {
+        sec := 0.0
+        for i, t := range tempos {
+            if t.tick >= tick { break }
+            next := tick
+            if i+1 < len(tempos) && tempos[i+1].tick < tick { next = tempos[i+1].tick }
+            sec += beats(next-t.tick) * t.us / 1e6
+        }
+        return sec
+    }
+    for i, t := range tempos {
+        if i > 0 && t.us == tempos[i-1].us { continue } // no change
+        info.Tempo = append(info.Tempo, MidiTempo{At: round3(seconds(t.tick)), Beat: round3(beats(t.tick)), BPM: round3(60e6 / t.us)})
+    }
+    sort.SliceStable(meters, func(i, j int) bool { return meters[i].tick < meters[j].tick })
+    for _, m := range meters {
+        info.TimeSignatures = append(info.TimeSignatures, MidiMeter{At: round3(seconds(m.tick)), Beat: round3(beats(m.tick)), Signature: m.sig})
+    }
+    info.Length, info.Beats = round3(seconds(end)), round3(beats(end))
+    return info, nil
+}
+
+// midiVarLen reads a variable-length quantity, and how many bytes it took;
+// 0 bytes if it is cut short.
+func midiVarLen(b []byte) (int64, int) {
+    var v int64
+    for i := 0; i < len(b) && i < 4; i++ {
+        v = v<<7 | int64(b[i]&0x7F)
+        if b[i]&0x80 == 0 { return v, i + 1 }
+    }
+    return 0, 0
+}
+
+func round3(f float64) float64 { return math.Round(f*1000) / 1000 }
+
+// midiInfo reads the MIDI file ref, caching by path and revision.
+func (s *Server) midiInfo(ctx context.Context, ref *FileRef) (*MidiInfo, error) {
+    key := ref.Path + "@" + ref.ServerModified.Format(time.RFC3339)
+    s.alsMu.Lock(); info := s.midiCache[key]; s.alsMu.Unlock()
+    if info != nil { return info, nil }
+    body, err := s.analysisDownload(ctx, ref.Path)
+    if err != nil { return nil, err }
+    defer body.Close()
+    if info, err = parseMIDI(body); err != nil { return nil, fmt.Errorf("%s: %w", ref.Name, err) }
+    s.alsMu.Lock(); cachePut(s.midiCache, key, info); s.alsMu.Unlock()
+    return info, nil
+}
+
+// GET /api/tracks/{name}/ableton/{t1}/midi
+func (s *Server) handleMIDI(w http.ResponseWriter, r *http.Request, t *Track, t1 string) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    snap := findSnap(t, t1)
+    if snap == nil { http.Error(w, "snapshot not found", 404); return }
+    type midiFile struct {
+        File     FileRef   `json:"file"`
+        Part     string    `json:"part,omitempty"` // "" for the whole arrangement
+        Download string    `json:"download"`
+        Info     *MidiInfo `json:"info,omitempty"`
+        Error    string    `json:"error,omitempty"` // why it could not be read
+    }
+    out := []midiFile{}
+    for i := range snap.MIDI {
+        f := &snap.MIDI[i]
+        if f.Archived && !t.Archived && r.URL.Query().Get("archived") == "" { continue }
+        np, _ := classifyName(f.Name)
+        mf := midiFile{File: *f, Part: np.Stem, Download: "/api/link?path=" + url.QueryEscape(f.Path)}
+        info, err := s.midiInfo(r.Context(), f)
+        if err != nil { mf.Error = err.Error() } else { mf.Info = info }
+        out = append(out, mf)
+    }
+    writeJSON(w, map[string]any{"track": t.Name, "t1": snap.T1, "files": out})
+}
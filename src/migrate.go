@@ -0,0 +1,163 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "path"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ====== Library Migration ======
+//
+// Onboarding a library that predates the convention: every audio or session
+// file the indexer cannot place gets a proposed conventional name and canonical
+// folder. The plan is reviewed (and edited) first, then applied in one go;
+// if any move fails, the ones already made are undone.
+
+// MigrateStep moves one file into the convention.
+type MigrateStep struct {
+    From    string   `json:"from"`
+    To      string   `json:"to,omitempty"`
+    Track   string   `json:"track,omitempty"`
+    Kind    string   `json:"kind,omitempty"`
+    Assumed []string `json:"assumed,omitempty"`
+    Error   string   `json:"error,omitempty"` // why no step could be planned
+}
+
+// migratable reports whether e is an audio or session file that should
+// follow the convention but does not. Live's project internals are left alone.
+func (s *Server) migratable(e *dbxEntry) bool {
+    if e.Tag != "file" || s.inTrash(e.lower()) || strings.Contains(e.lower(), "/"+archiveDir+"/") { return false }
+    for _, skip := range []string{"/samples/", "/backup/", "/ableton project info/", ".logicx/"} {
+        if strings.Contains(e.lower(), skip) { return false }
+    }
+    ext := strings.TrimPrefix(path.Ext(e.lower()), ".")
+    if _, ok := sessionDAW[ext]; !ok && ext != "wav" && ext != "mp3" { return false }
+    if _, ok := classifyName(e.Name); ok || s.isReference(e) || s.isIdea(e) { return false }
+    _, _, _, conflicted := splitConflicted(e.Name)
+    return !conflicted
+}
+
+// folderKind is the artifact kind a canonical subfolder in p implies.
+func folderKind(p string) string {
+    for _, seg := range strings.Split(strings.ToLower(path.Dir(p)), "/") {
+        switch seg {
+        case "stems": return kindStem
+        case "mixes": return kindMix
+        case "prints": return kindPrint
+        case "masters": return kindMaster
+        }
+    }
+    return ""
+}
+
+// migrationPlan proposes a step for every migratable file, of one track's
+// folder if track is given. Times come from when the file was last written.
+func (s *Server) migrationPlan(ctx context.Context, track string) ([]MigrateStep, error) {
+    entries, err := s.dbxListAll(ctx, s.dropboxRoot)
+    if err != nil { return nil, err }
+    steps := []MigrateStep{}
+    taken := map[string]string{} // lower-case path -> what is or will be there
+    for _, e := range entries { taken[e.lower()] = e.PathDisplay }
+    for i := range entries {
+        e := &entries[i]
+        if !s.migratable(e) { continue }
+        step := MigrateStep{From: e.PathDisplay}
+        req := suggestReq{Filename: e.Name, Kind: folderKind(e.PathDisplay), Time: e.ClientModified.Format(time.RFC3339)}
+        if s.guessTrack(normalizedBase(e.Name)) == "" {
+            if dir := s.trackDir(e.PathDisplay); dir != strings.TrimSuffix(s.dropboxRoot, "/") { req.Track = normalizedBase(path.Base(dir)) }
+        }
+        sg, err := s.suggestName(req)
+        if owner := req.Track; track != "" {
+            if sg != nil { owner = sg.Track }
+            if owner != track { continue }
+        }
+        switch {
+        case err != nil:
+            step.Error = err.Error()
+        case taken[strings.ToLower(sg.Path)] != "":
+            step.Error = sg.Path + " is taken by " + taken[strings.ToLower(sg.Path)]
+        default:
+            step.To, step.Track, step.Kind, step.Assumed = sg.Path, sg.Track, sg.Kind, sg.Assumed
+            taken[strings.ToLower(sg.Path)] = e.PathDisplay
+        }
+        steps = append(steps, step)
+    }
+    sort.Slice(steps, func(i, j int) bool { return steps[i].From < steps[j].From })
+    return steps, nil
+}
+
+// checkSteps validates a reviewed plan before anything moves.
+func (s *Server) checkSteps(ctx context.Context, steps []MigrateStep) error {
+    seen := map[string]bool{}
+    for _, st := range steps {
+        if !s.underRoot(st.From) || !s.underRoot(st.To) { return httpError{400, "steps must stay under " + s.dropboxRoot} }
+        if _, ok := classifyName(path.Base(st.To)); !ok { return httpError{422, path.Base(st.To) + " does not follow the naming convention"} }
+        if seen[strings.ToLower(st.To)] { return httpError{400, "two steps move to " + st.To} }
+        seen[strings.ToLower(st.To)] = true
+        if err := s.checkUploadTarget(ctx, st.To, false); err != nil { return err }
+    }
+    return nil
+}
+
+// GET  /api/migrate[?track=]   the plan, for review; nothing moves
+// POST /api/migrate {"steps":[{"from":"...","to":"..."}],"dry_run":false}
+// POST applies the given steps, or every plannable step of the current plan
+// if none are given; with dry_run it only validates them.
+func (s *Server) handleMigrate(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        track := strings.ToUpper(r.URL.Query().Get("track"))
+        if track != "" { track = canonicalName(s.aliasMap(), track) }
+        steps, err := s.migrationPlan(r.Context(), track)
+        if err != nil { http.Error(w, err.Error(), 502); return }
+        planned := 0
+        for _, st := range steps {
+            if st.Error == "" { planned++ }
+        }
+        writeJSON(w, map[string]any{"planned": planned, "unplanned": len(steps) - planned, "steps": steps})
+
+    case http.MethodPost:
+        var req struct {
+            Steps  []MigrateStep `json:"steps"`
+            DryRun bool          `json:"dry_run"`
+        }
+        if r.ContentLength != 0 {
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        }
+        s.writeMu.Lock()
+        var err error
+        if len(req.Steps) == 0 {
+            var plan []MigrateStep
+            plan, err = s.migrationPlan(r.Context(), "")
+            for _, st := range plan {
+                if st.Error == "" { req.Steps = append(req.Steps, st) }
+            }
+        }
+        if err == nil { err = s.checkSteps(r.Context(), req.Steps) }
+        if err == nil && !req.DryRun && len(req.Steps) > 0 {
+            moves := make([][2]string, len(req.Steps))
+            for i, st := range req.Steps { moves[i] = [2]string{st.From, st.To} }
+            if err = s.moveAll(r.Context(), moves); err == nil {
+                err = s.store.update(func(d *storeData) error {
+                    for _, m := range moves { moveBaselines(d, m[0], m[1]) }
+                    return nil
+                })
+            }
+        }
+        s.writeMu.Unlock()
+        if err != nil { writeError(w, err); return }
+        out := map[string]any{"dry_run": req.DryRun, "moved": 0, "steps": req.Steps}
+        if req.DryRun || len(req.Steps) == 0 { writeJSON(w, out); return }
+        out["moved"] = len(req.Steps)
+        s.audit(r, "migrate", "", nil, req.Steps)
+        if err := s.reindex(r.Context()); err != nil { http.Error(w, "migrated, but reindex failed: "+err.Error(), 500); return }
+        writeJSON(w, out)
+
+    default:
+        http.Error(w, "GET or POST required", 405)
+    }
+}
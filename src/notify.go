@@ -0,0 +1,202 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "os"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ====== Catalog Notifications ======
+//
+// After each reindex or promotion the server looks for artifacts it has not
+// announced yet (a master candidate, a new or replaced FINAL, a stems set or
+// a mix) and hands each to the notifiers set up: Slack (slack.go), Discord
+// (discord.go), Telegram (telegram.go) and webhooks (webhooks.go); they
+// also carry deadline reminders (deadlines.go), as does email. With
+// PUBLIC_URL set, every announcement carries a share link to play the
+// artifact, made by the "notifications" user for the usual week.
+//
+// What has been announced is kept in the state, so replicas and restarts do
+// not repeat themselves; what already exists when announcing of a kind of
+// event begins is only noted. Read-only instances announce nothing.
+
+const (
+    eventCandidate = "candidate"
+    eventFinal     = "final"
+    eventStems     = "stems"
+    eventMix       = "mix"
+    eventDeadline  = "deadline" // a reminder, not found by a reindex
+)
+
+var eventKinds = []string{eventCandidate, eventFinal, eventStems, eventMix}
+
+// notifyKinds are what a notifier can be set up to pass on.
+var notifyKinds = append(slices.Clone(eventKinds), eventDeadline)
+
+// notifier passes catalog events on somewhere.
+type notifier interface {
+    name() string
+    wants(kind string) bool
+    notify(ctx context.Context, e catalogEvent) error
+}
+
+// catalogEvent is something a reindex found.
+type catalogEvent struct {
+    key      string // what was announced, as kept in the state
+    kind     string
+    track    string
+    artifact ArtifactRef
+    files    []FileRef
+    lufs     *float64 // if measured
+    link     string   // share link, if PUBLIC_URL is set
+    deadline *deadlineState // a deadline reminder's
+}
+
+// title is a one-line summary of e.
+func (e catalogEvent) title() string {
+    switch e.kind {
+    case eventCandidate: return fmt.Sprintf("New master candidate for %s: %s-%s #%s", e.track, e.artifact.T1, e.artifact.T2, e.artifact.Idx)
+    case eventFinal: return fmt.Sprintf("New FINAL for %s: %s-%s", e.track, e.artifact.T1, e.artifact.T2)
+    case eventStems: return fmt.Sprintf("New stems for %s: %s-%s, %d files", e.track, e.artifact.T1, e.artifact.T2, len(e.files))
+    case eventDeadline: return deadlineReminder(e.deadline)
+    }
+    return fmt.Sprintf("New mix for %s: %s-%s", e.track, e.artifact.T1, e.artifact.T2)
+}
+
+// eventKindList parses a comma-separated list of event kinds, as in
+// SLACK_EVENTS; def if v is empty.
+func eventKindList(name, v string, def []string) ([]string, error) {
+    if v == "" { return def, nil }
+    var out []string
+    for _, e := range strings.Split(v, ",") {
+        e = strings.ToLower(strings.TrimSpace(e))
+        if !slices.Contains(notifyKinds, e) { return nil, fmt.Errorf("%s: %q is not %s", name, e, strings.Join(notifyKinds, ", ")) }
+        out = append(out, e)
+    }
+    return out, nil
+}
+
+// loadNotifiers sets up every notifier that is configured.
+func loadNotifiers() ([]notifier, error) {
+    var out []notifier
+    slack, err := loadSlack()
+    if err != nil { return nil, fmt.Errorf("Slack: %w", err) }
+    if slack != nil { out = append(out, slack) }
+    discord, err := loadDiscord()
+    if err != nil { return nil, fmt.Errorf("Discord: %w", err) }
+    if discord != nil { out = append(out, discord) }
+    return out, nil
+}
+
+// catalogEvents lists every artifact in tracks that can be announced.
+func catalogEvents(tracks map[string]*Track) []catalogEvent {
+    var out []catalogEvent
+    for _, t := range tracks {
+        for _, m := range t.Masters {
+            for _, c := range m.Candidates {
+                if c.Archived { continue }
+                out = append(out, catalogEvent{key: "candidate:" + strings.ToLower(c.Path), kind: eventCandidate, track: t.Name,
+                    artifact: ArtifactRef{Kind: kindMaster, T1: m.T1, T2: m.T2, Idx: candidateIdx(c)}, files: []FileRef{c}})
+            }
+            if f := m.Final; f != nil && !f.Archived {
+                out = append(out, catalogEvent{key: "final:" + strings.ToLower(f.Path) + "@" + f.ContentHash, kind: eventFinal, track: t.Name,
+                    artifact: ArtifactRef{Kind: kindMaster, T1: m.T1, T2: m.T2, Idx: "FINAL"}, files: []FileRef{*f}})
+            }
+        }
+        for _, st := range t.Stems {
+            if len(st.Stems) == 0 || st.Stems[0].Archived { continue }
+            out = append(out, catalogEvent{key: "stems:" + strings.ToLower(t.Name) + "/" + st.T1 + "-" + st.T2, kind: eventStems, track: t.Name,
+                artifact: ArtifactRef{Kind: kindStems, T1: st.T1, T2: st.T2}, files: st.Stems})
+        }
+        for _, m := range t.Mixes {
+            if m.File.Archived { continue }
+            out = append(out, catalogEvent{key: "mix:" + strings.ToLower(m.File.Path), kind: eventMix, track: t.Name,
+                artifact: ArtifactRef{Kind: kindMix, T1: m.T1, T2: m.T2}, files: []FileRef{m.File}})
+        }
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].key < out[j].key })
+    return out
+}
+
+// catalogChanged announces what is new in tracks, in the background.
+func (s *Server) catalogChanged(ctx context.Context, tracks map[string]*Track) {
+    if !s.notifying() || s.readOnly { return }
+    go runJob(context.WithoutCancel(ctx), "notify", func(ctx context.Context) { s.announce(ctx, tracks) })
+}
+
+// notifying reports whether any notifier wants catalog events.
+func (s *Server) notifying() bool {
+    for _, n := range s.notifiers {
+        if slices.ContainsFunc(eventKinds, n.wants) { return true }
+    }
+    return false
+}
+
+// announce passes on what is new in tracks since the last announcement.
+func (s *Server) announce(ctx context.Context, tracks map[string]*Track) {
+    all := catalogEvents(tracks)
+    var fresh []catalogEvent
+    err := s.store.update(func(d *storeData) error {
+        now := time.Now().UTC()
+        seen := map[string]time.Time{}
+        for _, kind := range eventKinds {
+            // since:<kind> is when announcing it began
+            if at, ok := d.Announced["since:"+kind]; ok { seen["since:"+kind] = at } else { seen["since:"+kind] = now }
+        }
+        for _, e := range all {
+            if at, ok := d.Announced[e.key]; ok { seen[e.key] = at; continue }
+            seen[e.key] = now
+            if _, ok := d.Announced["since:"+e.kind]; ok { fresh = append(fresh, e) }
+        }
+        d.Announced = seen // what left the catalog is forgotten
+        return nil
+    })
+    if err != nil { slog.ErrorContext(ctx, "recording announcements failed", "error", err); return }
+    for _, e := range fresh {
+        var to []notifier
+        for _, n := range s.notifiers {
+            if n.wants(e.kind) { to = append(to, n) }
+        }
+        if len(to) == 0 { continue }
+        if e.kind != eventStems {
+            f := e.files[0]
+            s.alsMu.Lock(); v, ok := s.loudCache[f.Path+"@"+f.ServerModified.String()]; s.alsMu.Unlock()
+            if ok { e.lufs = &v }
+        }
+        e.link = s.eventShare(ctx, e)
+        for _, n := range to {
+            if err := n.notify(ctx, e); err != nil {
+                slog.ErrorContext(ctx, "notification failed", "to", n.name(), "event", e.kind, "track", e.track, "error", err)
+                continue
+            }
+            slog.InfoContext(ctx, "notified", "to", n.name(), "event", e.kind, "track", e.track)
+        }
+    }
+}
+
+// eventShare makes a share link to e; empty without PUBLIC_URL.
+func (s *Server) eventShare(ctx context.Context, e catalogEvent) string {
+    return s.botShare(ctx, e.track, e.artifact)
+}
+
+// botShare makes a share link to a for the "notifications" user, who also
+// answers in chat; empty without PUBLIC_URL.
+func (s *Server) botShare(ctx context.Context, track string, a ArtifactRef) string {
+    base := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+    if base == "" { return "" }
+    now := time.Now().UTC()
+    sh := Share{Token: randToken(), Track: track, Artifact: &a, Download: true, By: "notifications", Created: now, Expires: now.Add(defaultShareTTL)}
+    err := s.store.update(func(d *storeData) error {
+        if d.Shares == nil { d.Shares = map[string]*Share{} }
+        d.Shares[sh.Token] = &sh
+        return nil
+    })
+    if err != nil { slog.ErrorContext(ctx, "making a share link to announce failed", "error", err); return "" }
+    s.audit(nil, "share-create", track, nil, publicShare(sh))
+    return base + "/s/" + sh.Token
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ====== Notion ======
+//
+// The band's Notion workspace can mirror the catalog: a page per track in
+// NOTION_TRACKS_DATABASE and a page per release in NOTION_RELEASES_DATABASE
+// (either may be left out), written by the integration whose token is
+// NOTION_TOKEN (a secret) and which the databases are shared with. Pages are
+// matched by their title, the track name or release title; ones for tracks
+// or releases that are gone are left alone.
+//
+// Each column of the catalog sync (the track columns, or Release | ID | Type
+// | Artist | Release date | Tracks | Ready | Missing | Updated) goes to the
+// property of the same name, if the database has one, and the track name or
+// release title to the title property. NOTION_TRACK_PROPERTIES and
+// NOTION_RELEASE_PROPERTIES map them otherwise, as "column=Property" pairs:
+//
+//   NOTION_TRACK_PROPERTIES="Latest master=Master,LUFS=Loudness,Updated="
+//
+// (an empty property leaves the column out). Title, text, number, checkbox,
+// select, multi-select, status, date and URL properties can be written; a
+// select option that does not exist yet is added. Besides the schedule,
+// Notion is synced shortly after the index, a status or a release changes,
+// and only pages whose properties changed are written.
+
+var notionAPI = "https://api.notion.com"
+
+const notionVersion = "2022-06-28"
+
+type notionSink struct {
+    token    string
+    tracks   notionDatabase
+    releases notionDatabase
+    client   *http.Client
+
+    mu   sync.Mutex
+    sent map[string]string // page ID -> hash of the properties last written
+}
+
+// notionDatabase is a database and which property each column goes to.
+type notionDatabase struct {
+    id      string
+    key     string            // the column matched against the title
+    columns []string
+    mapping map[string]string // column -> property; "" leaves it out
+}
+
+// loadNotion reads the Notion settings; nil if there are none.
+func loadNotion() (*notionSink, error) {
+    token, err := readSecret("NOTION_TOKEN")
+    if err != nil { return nil, err }
+    tracks, releases := os.Getenv("NOTION_TRACKS_DATABASE"), os.Getenv("NOTION_RELEASES_DATABASE")
+    if token == "" && tracks == "" && releases == "" { return nil, nil }
+    if token == "" || tracks == "" && releases == "" { return nil, errors.New("NOTION_TOKEN goes with NOTION_TRACKS_DATABASE and/or NOTION_RELEASES_DATABASE") }
+    n := &notionSink{token: token, client: &http.Client{Timeout: time.Minute}, sent: map[string]string{}}
+    n.tracks = notionDatabase{id: tracks, key: "Track", columns: catalogColumns}
+    if n.tracks.mapping, err = parsePropertyMap("NOTION_TRACK_PROPERTIES", catalogColumns); err != nil { return nil, err }
+    n.releases = notionDatabase{id: releases, key: "Release", columns: releaseColumns}
+    if n.releases.mapping, err = parsePropertyMap("NOTION_RELEASE_PROPERTIES", releaseColumns); err != nil { return nil, err }
+    return n, nil
+}
+
+// parsePropertyMap reads "column=Property,..." from the variable name.
+func parsePropertyMap(name string, columns []string) (map[string]string, error) {
+    out := map[string]string{}
+    for _, pair := range strings.Split(os.Getenv(name), ",") {
+        if strings.TrimSpace(pair) == "" { continue }
+        col, prop, ok := strings.Cut(pair, "=")
+        col, prop = strings.TrimSpace(col), strings.TrimSpace(prop)
+        if !ok { return nil, fmt.Errorf("%s: %q is not column=Property", name, pair) }
+        if !slices.Contains(columns, col) { return nil, fmt.Errorf("%s: no column %q (there are %s)", name, col, strings.Join(columns, ", ")) }
+        out[col] = prop
+    }
+    return out, nil
+}
+
+func (n *notionSink) name() string   { return "notion" }
+func (n *notionSink) onChange() bool { return true }
+
+// call makes one API call, keeping under Notion's 3 requests a second.
+func (n *notionSink) call(ctx context.Context, method, path string, body, out any) error {
+    time.Sleep(350 * time.Millisecond)
+    var rd io.Reader
+    if body != nil { b, _ := json.Marshal(body); rd = bytes.NewReader(b) }
+    req, err := http.NewRequestWithContext(ctx, method, notionAPI+"/v1/"+path, rd)
+    if err != nil { return err }
+    req.Header.Set("Authorization", "Bearer "+n.token)
+    req.Header.Set("Notion-Version", notionVersion)
+    req.Header.Set("Content-Type", "application/json")
+    return doJSON(n.client, req, out)
+}
+
+// push writes the tracks and the releases.
+func (n *notionSink) push(ctx context.Context, c catalog) (int, error) {
+    n.mu.Lock(); defer n.mu.Unlock()
+    written := 0
+    if n.tracks.id != "" {
+        rows := make([]map[string]any, len(c.Tracks))
+        for i, r := range c.Tracks { rows[i] = r.fields() }
+        w, err := n.pushDatabase(ctx, n.tracks, rows)
+        if written += w; err != nil { return written, fmt.Errorf("tracks database: %w", err) }
+    }
+    if n.releases.id != "" {
+        rows := make([]map[string]any, len(c.Releases))
+        for i, r := range c.Releases { rows[i] = r.fields() }
+        w, err := n.pushDatabase(ctx, n.releases, rows)
+        if written += w; err != nil { return written, fmt.Errorf("releases database: %w", err) }
+    }
+    return written, nil
+}
+
+// pushDatabase updates or adds a page for each row, skipping pages already
+// written with the same properties.
+func (n *notionSink) pushDatabase(ctx context.Context, db notionDatabase, rows []map[string]any) (int, error) {
+    var schema struct {
+        Properties map[string]struct {
+            Type string `json:"type"`
+        } `json:"properties"`
+    }
+    if err := n.call(ctx, http.MethodGet, "databases/"+db.id, nil, &schema); err != nil { return 0, err }
+    title := ""
+    for name, p := range schema.Properties {
+        if p.Type == "title" { title = name }
+    }
+    if title == "" { return 0, errors.New("the database has no title property") }
+    props := map[string]string{} // column -> property
+    for _, col := range db.columns {
+        prop, ok := db.mapping[col]
+        if !ok && col == db.key { prop = title } else if !ok { prop = col }
+        if p, exists := schema.Properties[prop]; prop != "" && exists && notionValue(p.Type, nil) != nil { props[col] = prop }
+    }
+    if props[db.key] != title { return 0, fmt.Errorf("%s has to go to the title property, %q", db.key, title) }
+
+    pages := map[string]string{} // title -> page ID
+    cursor := ""
+    for {
+        q := map[string]any{"page_size": 100}
+        if cursor != "" { q["start_cursor"] = cursor }
+        var res struct {
+            Results []struct {
+                ID         string                     `json:"id"`
+                Properties map[string]json.RawMessage `json:"properties"`
+            } `json:"results"`
+            HasMore    bool   `json:"has_more"`
+            NextCursor string `json:"next_cursor"`
+        }
+        if err := n.call(ctx, http.MethodPost, "databases/"+db.id+"/query", q, &res); err != nil { return 0, err }
+        for _, p := range res.Results {
+            var t struct {
+                Title []struct {
+                    PlainText string `json:"plain_text"`
+                } `json:"title"`
+            }
+            json.Unmarshal(p.Properties[title], &t)
+            var name strings.Builder
+            for _, part := range t.Title { name.WriteString(part.PlainText) }
+            if _, dup := pages[name.String()]; !dup { pages[name.String()] = p.ID }
+        }
+        if cursor = res.NextCursor; !res.HasMore || cursor == "" { break }
+    }
+
+    written := 0
+    for _, row := range rows {
+        values := map[string]any{}
+        for col, prop := range props { values[prop] = notionValue(schema.Properties[prop].Type, row[col]) }
+        b, _ := json.Marshal(values)
+        sum := sha256.Sum256(b)
+        hash := hex.EncodeToString(sum[:])
+        key, _ := row[db.key].(string)
+        if id, ok := pages[key]; ok {
+            if n.sent[id] == hash { continue }
+            if err := n.call(ctx, http.MethodPatch, "pages/"+id, map[string]any{"properties": values}, nil); err != nil { return written, err }
+            n.sent[id] = hash
+        } else {
+            var page struct {
+                ID string `json:"id"`
+            }
+            body := map[string]any{"parent": map[string]string{"database_id": db.id}, "properties": values}
+            if err := n.call(ctx, http.MethodPost, "pages", body, &page); err != nil { return written, err }
+            n.sent[page.ID] = hash
+        }
+        written++
+    }
+    return written, nil
+}
+
+// notionValue is v as a property value of the given type; nil if the type
+// cannot be written.
+func notionValue(typ string, v any) any {
+    text := ""
+    switch v := v.(type) {
+    case nil:
+    case bool: text = map[bool]string{true: "yes", false: "no"}[v]
+    case float64: text = strconv.FormatFloat(v, 'f', -1, 64)
+    default: text = fmt.Sprint(v)
+    }
+    rich := []any{map[string]any{"type": "text", "text": map[string]string{"content": truncate(text, 2000)}}}
+    if text == "" { rich = []any{} }
+    switch typ {
+    case "title": return map[string]any{"title": rich}
+    case "rich_text": return map[string]any{"rich_text": rich}
+    case "number":
+        f, err := strconv.ParseFloat(text, 64)
+        if b, ok := v.(bool); ok { f, err = map[bool]float64{true: 1}[b], nil }
+        if err != nil { return map[string]any{"number": nil} }
+        return map[string]any{"number": f}
+    case "checkbox":
+        b, _ := v.(bool)
+        return map[string]any{"checkbox": b}
+    case "select", "status":
+        if text == "" { return map[string]any{typ: nil} }
+        return map[string]any{typ: map[string]string{"name": strings.ReplaceAll(text, ",", " ")}}
+    case "multi_select":
+        opts := []any{}
+        for _, o := range strings.Split(text, ";") {
+            if o = strings.ReplaceAll(strings.TrimSpace(o), ",", " "); o != "" { opts = append(opts, map[string]string{"name": truncate(o, 100)}) }
+        }
+        return map[string]any{"multi_select": opts}
+    case "date":
+        if text == "" { return map[string]any{"date": nil} }
+        return map[string]any{"date": map[string]string{"start": text}}
+    case "url":
+        if text == "" { return map[string]any{"url": nil} }
+        return map[string]any{"url": text}
+    }
+    return nil
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+    "cmp"
+    "context"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "math/big"
+    "net/http"
+    "net/url"
+    "os"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ====== Web Login (OpenID Connect) ======
+//
+// With OIDC_CLIENT_ID set, the web UI signs people in with an identity
+// provider instead of relying on whoever knows the URL: OIDC_ISSUER names any
+// OpenID Connect provider (https://accounts.google.com, an Authentik
+// application, ...), or OIDC_PROVIDER=github uses GitHub's OAuth, which has no
+// ID tokens and is asked for the user and their e-mail addresses instead.
+// Only people in OIDC_ALLOWED_USERS (verified e-mail addresses, GitHub logins
+// or OIDC subjects) or with a verified address in OIDC_ALLOWED_DOMAINS get in; OIDC_ADMINS are admins,
+// everyone else has the role they are given (see roles.go). A signed-in
+// browser carries an HttpOnly, SameSite=Lax session cookie, which /api
+// accepts in place of an API key.
+
+const (
+    sessionCookie = "avcs_session"
+    sessionTTL    = 14 * 24 * time.Hour
+    loginTTL      = 10 * time.Minute // to come back from the provider
+)
+
+// Session is a signed-in web user.
+type Session struct {
+    User    string    `json:"user"` // the GitHub login; else the verified e-mail address, or the OIDC subject
+    Name    string    `json:"name,omitempty"`
+    Email   string    `json:"email,omitempty"`
+    Admin   bool      `json:"admin,omitempty"`
+    Role    string    `json:"role,omitempty"` // filled in by /auth/me
+    Created time.Time `json:"created"`
+    Expires time.Time `json:"expires"`
+}
+
+type oidcClient struct {
+    github       bool
+    issuer       string
+    clientID     string
+    clientSecret string
+    redirectURL  string
+    domains      []string
+    users        []string
+    admins       []string
+
+    mu       sync.Mutex
+    authURL  string
+    tokenURL string
+    jwksURL  string
+    keys     map[string]crypto.PublicKey // key: kid
+    pending  map[string]*pendingLogin    // key: state
+}
+
+// pendingLogin is a login sent to the provider and not yet back.
+type pendingLogin struct {
+    verifier string // PKCE
+    nonce    string
+    next     string
+    expires  time.Time
+}
+
+// identity is who the provider says signed in.
+type identity struct {
+    login    string
+    sub      string // the provider's id for the user
+    email    string
+    verified bool
+    name     string
+}
+
+// loadOIDC reads the login configuration; nil if login is not configured.
+func loadOIDC() (*oidcClient, error) {
+    o := &oidcClient{
+        github:       strings.EqualFold(os.Getenv("OIDC_PROVIDER"), "github"),
+        issuer:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+        clientID:     os.Getenv("OIDC_CLIENT_ID"),
+        redirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+        domains:      splitList(os.Getenv("OIDC_ALLOWED_DOMAINS")),
+        users:        splitList(os.Getenv("OIDC_ALLOWED_USERS")),
+        admins:       splitList(os.Getenv("OIDC_ADMINS")),
+        keys:         map[string]crypto.PublicKey{},
+        pending:      map[string]*pendingLogin{},
+    }
+    if o.clientID == "" { return nil, nil }
+    var err error
+    if o.clientSecret, err = readSecret("OIDC_CLIENT_SECRET"); err != nil { return nil, err }
+    if o.github {
+        o.authURL, o.tokenURL = "https://github.com/login/oauth/authorize", "https://github.com/login/oauth/access_token"
+    } else if o.issuer == "" {
+        return nil, errors.New("OIDC_ISSUER is required (or OIDC_PROVIDER=github)")
+    }
+    if o.redirectURL == "" { return nil, errors.New("OIDC_REDIRECT_URL is required, e.g. https://avcs.example.com/auth/callback") }
+    if len(o.domains)+len(o.users)+len(o.admins) == 0 { return nil, errors.New("set OIDC_ALLOWED_DOMAINS or OIDC_ALLOWED_USERS; anyone with an account could sign in otherwise") }
+    return o, nil
+}
+
+// splitList splits a comma-separated setting into lower-case items.
+func splitList(v string) []string {
+    var out []string
+    for _, it := range strings.Split(v, ",") {
+        if it = strings.ToLower(strings.TrimSpace(it)); it != "" { out = append(out, it) }
+    }
+    return out
+}
+
+func randToken() string {
+    b := make([]byte, 32)
+    rand.Read(b)
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// getJSON fetches url into out, with the bearer token if given.
+func getJSON(ctx context.Context, u, token string, out any) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+    if err != nil { return err }
+    req.Header.Set("Accept", "application/json")
+    if token != "" { req.Header.Set("Authorization", "Bearer "+token) }
+    res, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+    if err != nil { return err }
+    defer res.Body.Close()
+    b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+    if res.StatusCode != 200 { return fmt.Errorf("%s -> %s: %s", u, res.Status, truncate(string(b), 200)) }
+    return json.Unmarshal(b, out)
+}
+
+// discover looks up the provider's endpoints, once.
+func (o *oidcClient) discover(ctx context.Context) error {
+    o.mu.Lock(); done := o.authURL != ""; o.mu.Unlock()
+    if done { return nil }
+    var doc struct {
+        Issuer string `json:"issuer"`
+        Auth   string `json:"authorization_endpoint"`
+        Token  string `json:"token_endpoint"`
+        JWKS   string `json:"jwks_uri"`
+    }
+    if err := getJSON(ctx, o.issuer+"/.well-known/openid-configuration", "", &doc); err != nil { return err }
+    if strings.TrimSuffix(doc.Issuer, "/") != o.issuer { return fmt.Errorf("provider says its issuer is %s, not %s", doc.Issuer, o.issuer) }
+    o.mu.Lock(); o.authURL, o.tokenURL, o.jwksURL = doc.Auth, doc.Token, doc.JWKS; o.mu.Unlock()
+    return nil
+}
+
+// key is the provider's signing key kid, refetching the key set when a new one appears.
+func (o *oidcClient) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+    o.mu.Lock(); k := o.keys[kid]; o.mu.Unlock()
+    if k != nil { return k, nil }
+    var set struct {
+        Keys []struct {
+            Kty, Kid, N, E, Crv, X, Y string
+        } `json:"keys"`
+    }
+    if err := getJSON(ctx, o.jwksURL, "", &set); err != nil { return nil, err }
+    num := func(s string) *big.Int { b, _ := base64.RawURLEncoding.DecodeString(s); return new(big.Int).SetBytes(b) }
+    o.mu.Lock(); defer o.mu.Unlock()
+    for _, jk := range set.Keys {
+        switch {
+        case jk.Kty == "RSA": o.keys[jk.Kid] = &rsa.PublicKey{N: num(jk.N), E: int(num(jk.E).Int64())}
+        case jk.Kty == "EC" && jk.Crv == "P-256": o.keys[jk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: num(jk.X), Y: num(jk.Y)}
+        }
+    }
+    if k = o.keys[kid]; k == nil { return nil, fmt.Errorf("no signing key %q", kid) }
+    return k, nil
+}
+
+// verifyIDToken checks the ID token's signature and claims and returns the claims.
+func (o *oidcClient) verifyIDToken(ctx context.Context, raw, nonce string) (map[string]any, error) {
+    parts := strings.Split(raw, ".")
+    if len(parts) != 3 { return nil, errors.New("malformed ID token") }
+    var hdr struct{ Alg, Kid string }
+    b, err := base64.RawURLEncoding.DecodeString(parts[0])
+    if err == nil { err = json.Unmarshal(b, &hdr) }
+    if err != nil { return nil, fmt.Errorf("ID token header: %w", err) }
+    sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil { return nil, fmt.Errorf("ID token signature: %w", err) }
+    key, err := o.key(ctx, hdr.Kid)
+    if err != nil { return nil, err }
+    sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+    switch pub := key.(type) {
+    case *rsa.PublicKey:
+        if hdr.Alg != "RS256" || rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) != nil { return nil, errors.New("bad ID token signature") }
+    case *ecdsa.PublicKey:
+        if hdr.Alg != "ES256" || len(sig) != 64 || !ecdsa.Verify(pub, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) { return nil, errors.New("bad ID token signature") }
+    }
+
+    var claims map[string]any
+    b, err = base64.RawURLEncoding.DecodeString(parts[1])
+    if err == nil { err = json.Unmarshal(b, &claims) }
+    if err != nil { return nil, fmt.Errorf("ID token claims: %w", err) }
+    str := func(k string) string { v, _ := claims[k].(string); return v }
+    aud := []string{str("aud")}
+    if list, ok := claims["aud"].([]any); ok {
+        for _, a := range list { if s, ok := a.(string); ok { aud = append(aud, s) } }
+    }
+    exp, _ := claims["exp"].(float64)
+    switch {
+    case strings.TrimSuffix(str("iss"), "/") != o.issuer: return nil, errors.New("ID token from another issuer")
+    case !slices.Contains(aud, o.clientID): return nil, errors.New("ID token for another client")
+    case time.Now().After(time.Unix(int64(exp), 0).Add(time.Minute)): return nil, errors.New("ID token expired")
+    case str("nonce") != nonce: return nil, errors.New("ID token nonce mismatch")
+    }
+    return claims, nil
+}
+
+// exchange trades the authorization code for the signed-in identity.
+func (o *oidcClient) exchange(ctx context.Context, code string, p *pendingLogin) (*identity, error) {
+    form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {o.redirectURL},
+        "client_id": {o.clientID}, "client_secret": {o.clientSecret}, "code_verifier": {p.verifier}}
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.tokenURL, strings.NewReader(form.Encode()))
+    if err != nil { return nil, err }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.Header.Set("Accept", "application/json")
+    res, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+    if err != nil { return nil, err }
+    defer res.Body.Close()
+    var tok struct {
+        AccessToken string `json:"access_token"`
+        IDToken     string `json:"id_token"`
+        Error       string `json:"error"`
+        Description string `json:"error_description"`
+    }
+    if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&tok); err != nil { return nil, fmt.Errorf("token endpoint -> %s", res.Status) }
+    if tok.Error != "" { return nil, fmt.Errorf("token endpoint: %s %s", tok.Error, tok.Description) }
+
+    if o.github {
+        var u struct{ Login, Name string }
+        if err := getJSON(ctx, "https://api.github.com/user", tok.AccessToken, &u); err != nil { return nil, err }
+        id := &identity{login: u.Login, name: u.Name}
+        var emails []struct {
+            Email             string
+            Primary, Verified bool
+        }
+        if err := getJSON(ctx, "https://api.github.com/user/emails", tok.AccessToken, &emails); err != nil { return nil, err }
+        for _, e := range emails {
+            if e.Primary { id.email, id.verified = e.Email, e.Verified }
+        }
+        return id, nil
+    }
+    if tok.IDToken == "" { return nil, errors.New("the provider sent no ID token") }
+    claims, err := o.verifyIDToken(ctx, tok.IDToken, p.nonce)
+    if err != nil { return nil, err }
+    str := func(k string) string { v, _ := claims[k].(string); return v }
+    if str("sub") == "" { return nil, errors.New("the ID token names no subject") }
+    verified, _ := claims["email_verified"].(bool)
+    return &identity{login: str("preferred_username"), sub: str("sub"), email: str("email"), verified: verified, name: str("name")}, nil
+}
+
+// account is the name the provider vouches for: the GitHub login, else the
+// OIDC subject. preferred_username is not one: users may set it to anything.
+func (o *oidcClient) account(id *identity) string {
+    if o.github { return id.login }
+    return id.sub
+}
+
+// allowed reports whether id may sign in, and as an admin.
+func (o *oidcClient) allowed(id *identity) (ok, admin bool) {
+    names := []string{strings.ToLower(o.account(id))}
+    if id.verified { names = append(names, strings.ToLower(id.email)) }
+    in := func(list []string) bool { return slices.ContainsFunc(names, func(n string) bool { return n != "" && slices.Contains(list, n) }) }
+    admin = in(o.admins)
+    _, domain, _ := strings.Cut(strings.ToLower(id.email), "@")
+    return admin || in(o.users) || id.verified && slices.Contains(o.domains, domain), admin
+}
+
+// sessionOf is the live session r's cookie names, if any.
+func (s *Server) sessionOf(r *http.Request) *Session {
+    if s.oidc == nil { return nil }
+    c, err := r.Cookie(sessionCookie)
+    if err != nil || c.Value == "" { return nil }
+    sum := sha256.Sum256([]byte(c.Value))
+    var out *Session
+    s.store.view(func(d *storeData) {
+        if sess := d.Sessions[hex.EncodeToString(sum[:])]; sess != nil && time.Now().Before(sess.Expires) { c := *sess; out = &c }
+    })
+    return out
+}
+
+func (s *Server) setSessionCookie(w http.ResponseWriter, value string, maxAge int) {
+    http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: value, Path: "/", MaxAge: maxAge, HttpOnly: true,
+        Secure: strings.HasPrefix(s.oidc.redirectURL, "https://"), SameSite: http.SameSiteLaxMode})
+}
+
+// GET  /auth/login[?next=/]   off to the provider
+// GET  /auth/callback         back from it
+// GET  /auth/me               the signed-in user, or 401
+// POST /auth/logout
+func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
+    o := s.oidc
+    if o == nil { http.Error(w, "login is not configured", 404); return }
+    switch strings.TrimPrefix(r.URL.Path, "/auth/") {
+    case "login":
+        if err := o.discover(r.Context()); err != nil { http.Error(w, "identity provider: "+err.Error(), 502); return }
+        next := r.URL.Query().Get("next")
+        if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") { next = "/" }
+        state, p := randToken(), &pendingLogin{verifier: randToken(), nonce: randToken(), next: next, expires: time.Now().Add(loginTTL)}
+        o.mu.Lock()
+        for k, v := range o.pending {
+            if time.Now().After(v.expires) { delete(o.pending, k) }
+        }
+        o.pending[state] = p
+        authURL := o.authURL
+        o.mu.Unlock()
+        challenge := sha256.Sum256([]byte(p.verifier))
+        q := url.Values{"response_type": {"code"}, "client_id": {o.clientID}, "redirect_uri": {o.redirectURL}, "state": {state},
+            "code_challenge": {base64.RawURLEncoding.EncodeToString(challenge[:])}, "code_challenge_method": {"S256"}}
+        if o.github {
+            q.Set("scope", "read:user user:email")
+        } else {
+            q.Set("scope", "openid email profile")
+            q.Set("nonce", p.nonce)
+        }
+        http.Redirect(w, r, authURL+"?"+q.Encode(), http.StatusFound)
+
+    case "callback":
+        q := r.URL.Query()
+        o.mu.Lock()
+        p := o.pending[q.Get("state")]
+        delete(o.pending, q.Get("state"))
+        o.mu.Unlock()
+        if p == nil || time.Now().After(p.expires) { http.Error(w, "this login has expired; start again at /auth/login", 400); return }
+        if e := q.Get("error"); e != "" { s.authFailed(r, "", "login refused by the provider: "+e); http.Error(w, "login refused: "+e+" "+q.Get("error_description"), 403); return }
+        id, err := o.exchange(r.Context(), q.Get("code"), p)
+        if err != nil { slog.WarnContext(r.Context(), "login failed", "error", err); s.authFailed(r, "", "login failed: "+err.Error()); http.Error(w, "login failed: "+err.Error(), 502); return }
+        ok, admin := o.allowed(id)
+        if !ok {
+            slog.WarnContext(r.Context(), "login refused", "login", id.login, "email", id.email)
+            s.authFailed(r, cmp.Or(id.email, id.login), "not allowed to sign in")
+            http.Error(w, "you are signed in as "+cmp.Or(id.email, id.login)+", which is not allowed here", 403); return
+        }
+        now := time.Now().UTC()
+        // Roles and restrictions go by User, so it must be a name the
+        // provider vouches for, not one the user picked.
+        sess := &Session{User: o.account(id), Name: id.name, Email: id.email, Admin: admin, Created: now, Expires: now.Add(sessionTTL)}
+        if id.verified && id.email != "" && !o.github { sess.User = strings.ToLower(id.email) }
+        token := randToken()
+        sum := sha256.Sum256([]byte(token))
+        err = s.store.update(func(d *storeData) error {
+            if d.Sessions == nil { d.Sessions = map[string]*Session{} }
+            for k, v := range d.Sessions {
+                if now.After(v.Expires) { delete(d.Sessions, k) }
+            }
+            d.Sessions[hex.EncodeToString(sum[:])] = sess
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.setSessionCookie(w, token, int(sessionTTL.Seconds()))
+        s.audit(r.WithContext(context.WithValue(r.Context(), ctxUser, sess.User)), "login", "", nil, sess)
+        http.Redirect(w, r, p.next, http.StatusFound)
+
+    case "me":
+        sess := s.sessionOf(r)
+        if sess == nil { http.Error(w, "not signed in", 401); return }
+        sess.Role = s.sessionRole(sess)
+        writeJSON(w, sess)
+
+    case "logout":
+        if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+        if c, err := r.Cookie(sessionCookie); err == nil {
+            sum := sha256.Sum256([]byte(c.Value))
+            if sess := s.sessionOf(r); sess != nil { s.audit(r.WithContext(context.WithValue(r.Context(), ctxUser, sess.User)), "logout", "", nil, nil) }
+            s.store.update(func(d *storeData) error { delete(d.Sessions, hex.EncodeToString(sum[:])); return nil })
+        }
+        s.setSessionCookie(w, "", -1)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.NotFound(w, r)
+    }
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "os/exec"
+    "path"
+    "runtime"
+    "strings"
+)
+
+// ====== CLI Open ======
+//
+// "Just let me hear the latest":
+//
+//   avcs open MIDNIGHT                    the FINAL, else the newest candidate
+//   avcs open MIDNIGHT --master final     the FINAL (--master 2: candidate 2,
+//                                         --master latest: the newest master)
+//   avcs open MIDNIGHT --mix [--set 1130] the newest mix, or of that version
+//   avcs open MIDNIGHT --stem DRUMS       the stem from the newest set having it
+//   avcs open MIDNIGHT --bounce           the newest session bounce
+//
+// resolves the artifact on the server's index, gets a temporary link to its
+// file and opens it in the default browser, which plays it or hands it to
+// the player: open on macOS, xdg-open elsewhere, or the command in
+// AVCS_OPENER (as mpv). --print prints the link instead.
+
+// cliOpen is `avcs open`.
+func cliOpen(args []string) error {
+    fs := flag.NewFlagSet("open", flag.ExitOnError)
+    connect := cliFlags(fs)
+    master := fs.String("master", "", "a master: final, latest (the newest, candidate or FINAL) or a candidate's index (default: the FINAL, else the newest candidate)")
+    mix := fs.Bool("mix", false, "the newest mix")
+    stem := fs.String("stem", "", "the stem (DRUMS, BASS, ...) from the newest stems set having it")
+    bounce := fs.Bool("bounce", false, "the newest session bounce")
+    set := fs.String("set", "", "of this version, as T1-T2 or enough of it to tell, instead of the newest")
+    printOnly := fs.Bool("print", false, "print the link instead of opening it")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs open [track] [--master final | --mix | --stem NAME | --bounce] [flags]")
+        fs.PrintDefaults()
+    }
+    operands := parseCLI(fs, args)
+    kind, which := kindMaster, strings.ToUpper(*master)
+    kinds := 0
+    if *master != "" { kinds++ }
+    if *mix { kind, kinds = kindMix, kinds+1 }
+    if *stem != "" { kind, which, kinds = kindStem, strings.ToUpper(*stem), kinds+1 }
+    if *bounce { kind, kinds = kindSnapshot, kinds+1 }
+    if len(operands) > 1 || kinds > 1 || *bounce && *set != "" { fs.Usage(); os.Exit(2) }
+    c, err := connect()
+    if err != nil { return err }
+
+    ctx := context.Background()
+    t, err := c.track(ctx, strings.Join(operands, ""))
+    if err != nil { return err }
+    a, f, err := openArtifact(t, kind, which, *set)
+    if err != nil { return fmt.Errorf("%s: %w", t.Name, err) }
+    link, err := c.Link(ctx, f.Path)
+    if err != nil { return err }
+    if *printOnly {
+        fmt.Fprintf(os.Stderr, "%s %s: %s\n", t.Name, artifactLabel(a), path.Base(f.Path))
+        fmt.Println(link)
+        return nil
+    }
+    fmt.Printf("Opening %s %s: %s\n", t.Name, artifactLabel(a), path.Base(f.Path))
+    return openURL(link)
+}
+
+// openArtifact is the artifact of t avcs open means, and its file: of kind,
+// which (a master's index, FINAL or LATEST, or a stem), from the version set
+// if given, else the newest version having it.
+func openArtifact(t *Track, kind, which, set string) (ArtifactRef, FileRef, error) {
+    if kind == kindSnapshot {
+        a, files, err := latestArtifact(t, "bounce")
+        if err != nil { return a, FileRef{}, err }
+        return a, files[0], nil
+    }
+    versions := versionLabels(t, kind)
+    what := map[string]string{kindStem: "stems set", kindMix: "mix", kindMaster: "master set"}[kind]
+    if len(versions) == 0 { return ArtifactRef{}, FileRef{}, fmt.Errorf("no %s yet", map[string]string{kindStem: "stems", kindMix: "mixes", kindMaster: "masters"}[kind]) }
+    label, in := "", ""
+    if set != "" {
+        var err error
+        if label, err = pick(what, set, versions); err != nil { return ArtifactRef{}, FileRef{}, err }
+        versions, in = []string{label}, " in "+label
+    }
+    if kind == kindMaster && which == "" {
+        if a, f, err := openArtifact(t, kind, "FINAL", label); err == nil { return a, f, nil }
+        which = "LATEST"
+    }
+    artifactKind := kind
+    if kind == kindStem { artifactKind = kindStems }
+    var a ArtifactRef
+    var best FileRef
+    for _, v := range versions {
+        for _, f := range versionFiles(t, kind, v) {
+            np, _ := classifyName(path.Base(f.Path)) // a stem's Name is only the stem's
+            if kind == kindStem && !strings.EqualFold(np.Stem, which) || kind == kindMaster && which != "LATEST" && np.Idx != which { continue }
+            if a.Kind == "" || which == "LATEST" && f.ServerModified.After(best.ServerModified) {
+                a, best = ArtifactRef{Kind: artifactKind, T1: np.T1, T2: np.T2, Idx: np.Idx}, f
+            }
+        }
+        if a.Kind != "" && which != "LATEST" { break }
+    }
+    switch {
+    case a.Kind != "": return a, best, nil
+    case kind == kindStem: return a, best, fmt.Errorf("no %s stem%s", which, in)
+    case which == "FINAL" && in == "": return a, best, errors.New("no FINAL yet (--master latest opens the newest candidate)")
+    case which == "FINAL": return a, best, fmt.Errorf("no FINAL%s", in)
+    }
+    return a, best, fmt.Errorf("no master candidate %s%s", which, in)
+}
+
+// versionFiles are the files of t's stems set, mix or master set v, as T1-T2.
+func versionFiles(t *Track, kind, v string) []FileRef {
+    var out []FileRef
+    switch kind {
+    case kindStem:
+        for _, s := range t.Stems {
+            if s.T1+"-"+s.T2 == v { out = append(out, s.Stems...) }
+        }
+    case kindMix:
+        for _, m := range t.Mixes {
+            if m.T1+"-"+m.T2 == v { out = append(out, m.File) }
+        }
+    case kindMaster:
+        for _, m := range t.Masters {
+            if m.T1+"-"+m.T2 != v { continue }
+            out = append(out, m.Candidates...)
+            if m.Final != nil { out = append(out, *m.Final) }
+        }
+    }
+    return out
+}
+
+// openURL opens link with AVCS_OPENER, else the system's handler for links.
+func openURL(link string) error {
+    var cmd *exec.Cmd
+    switch opener := strings.Fields(os.Getenv("AVCS_OPENER")); {
+    case len(opener) > 0: cmd = exec.Command(opener[0], append(opener[1:], link)...)
+    case runtime.GOOS == "darwin": cmd = exec.Command("open", link)
+    case runtime.GOOS == "windows": cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", link)
+    default: cmd = exec.Command("xdg-open", link)
+    }
+    cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+    if err := cmd.Run(); err != nil { return fmt.Errorf("open with %s: %w (--print prints the link instead)", cmd.Args[0], err) }
+    return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+    "net/http"
+    "slices"
+    "sort"
+    "strings"
+    "unicode"
+
+    "avcs-browser/client"
+)
+
+// ====== People ======
+//
+// Who worked on what is written in two places: the collaborators of each
+// track.yaml ("Dana Lee (Mastering Engineer)") and who added each file
+// (contributors.go). People brings both together as one record per person,
+// found by name whatever its case or spacing, with what they did on each
+// track:
+//
+//   played     stems and session saves they added; a role track.yaml gives
+//              that is not a studio one (Guitar, Vocals, ...)
+//   mixed      mixes and hardware prints they added; mixer roles
+//   mastered   masters they added; mastering roles
+//   credited   other roles (Producer, Composer, Engineer, ...) or none
+//
+//   GET /api/people[?as=mixed][&q=dana]    everyone, most tracks first
+//   GET /api/people/{id}[?as=mastered]     one person's credits, by track
+//
+// A person's ID is their name in lower case with dashes, e.g. dana-lee.
+// Tracks the caller may not see are left out.
+
+type (
+    Person       = client.Person
+    Credit       = client.Credit
+    PersonDetail = client.PersonDetail
+)
+
+var creditKinds = []string{"played", "mixed", "mastered", "credited"}
+
+// personID is the ID of the person written as name.
+func personID(name string) string {
+    var b strings.Builder
+    dash := false
+    for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+        if unicode.IsLetter(r) || unicode.IsDigit(r) {
+            if dash && b.Len() > 0 { b.WriteByte('-') }
+            b.WriteRune(r)
+            dash = false
+        } else {
+            dash = true
+        }
+    }
+    return b.String()
+}
+
+// creditAs is what a role written in track.yaml counts as.
+func creditAs(role string) string {
+    if role == "" { return "credited" }
+    switch ddexRoles[strings.ToLower(role)] {
+    case "Mixer": return "mixed"
+    case "MasteringEngineer": return "mastered"
+    case "": return "played"
+    }
+    return "credited"
+}
+
+// fileCredit is what adding a file of kind counts as; "" for none.
+func fileCredit(kind string) string {
+    switch kind {
+    case kindSnapshot, kindStem: return "played"
+    case kindMix, kindPrint: return "mixed"
+    case kindMaster: return "mastered"
+    }
+    return ""
+}
+
+// people gathers everyone r may see the work of, by ID.
+func (s *Server) people(r *http.Request) map[string]*PersonDetail {
+    out := map[string]*PersonDetail{}
+    spellings := map[string]map[string]int{} // ID -> name as written -> times
+    // credit finds the credit of who for track, written "Name (Role)";
+    // nil if there is no name.
+    credit := func(who, track, as string) *Credit {
+        c := parseContributor(who)
+        id := personID(c.Name)
+        if id == "" { return nil }
+        p := out[id]
+        if p == nil { p = &PersonDetail{Person: Person{ID: id}}; out[id] = p; spellings[id] = map[string]int{} }
+        spellings[id][c.Name]++
+        i := slices.IndexFunc(p.Credits, func(c Credit) bool { return c.Track == track && c.As == as })
+        if i < 0 { p.Credits = append(p.Credits, Credit{Track: track, As: as}); i = len(p.Credits) - 1 }
+        return &p.Credits[i]
+    }
+    s.mu.RLock()
+    for name, t := range s.tracks {
+        if !s.mayAccess(r, name) { continue }
+        if t.Parent == "" && t.Metadata != nil {
+            for _, who := range t.Metadata.Collaborators {
+                role := parseContributor(who).Role
+                if c := credit(who, name, creditAs(role)); c != nil && role != "" && !slices.Contains(c.Roles, role) { c.Roles = append(c.Roles, role) }
+            }
+        }
+        for _, f := range trackFiles(t) {
+            as := fileCredit(f.Kind)
+            if f.ContributedBy == "" || as == "" { continue }
+            c := credit(f.ContributedBy, name, as)
+            if c == nil { continue }
+            c.Files++
+            if f.ServerModified.After(c.Last) { c.Last = f.ServerModified }
+        }
+    }
+    s.mu.RUnlock()
+
+    for id, p := range out {
+        for name, n := range spellings[id] {
+            if best := spellings[id][p.Name]; p.Name == "" || n > best || n == best && name < p.Name { p.Name = name }
+        }
+        for _, c := range p.Credits {
+            for _, role := range c.Roles {
+                if !slices.Contains(p.Roles, role) { p.Roles = append(p.Roles, role) }
+            }
+        }
+        sort.Slice(p.Credits, func(i, j int) bool {
+            if p.Credits[i].Track != p.Credits[j].Track { return p.Credits[i].Track < p.Credits[j].Track }
+            return slices.Index(creditKinds, p.Credits[i].As) < slices.Index(creditKinds, p.Credits[j].As)
+        })
+        tracks := map[string]bool{}
+        for _, c := range p.Credits {
+            tracks[c.Track] = true
+            switch c.As {
+            case "played": p.Played++
+            case "mixed": p.Mixed++
+            case "mastered": p.Mastered++
+            default: p.Credited++
+            }
+            p.Files += c.Files
+            if c.Last.After(p.Last) { p.Last = c.Last }
+        }
+        p.Tracks = len(tracks)
+        sort.Strings(p.Roles)
+    }
+    return out
+}
+
+// GET /api/people[?as=][&q=]
+// GET /api/people/{id}[?as=]
+func (s *Server) handlePeople(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    as := strings.ToLower(r.URL.Query().Get("as"))
+    if as != "" && !slices.Contains(creditKinds, as) { http.Error(w, "as must be one of "+strings.Join(creditKinds, ", "), 400); return }
+    keep := func(p *PersonDetail) bool {
+        if as != "" { p.Credits = slices.DeleteFunc(p.Credits, func(c Credit) bool { return c.As != as }) }
+        return len(p.Credits) > 0
+    }
+    id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/people"), "/")
+    if id != "" {
+        p := s.people(r)[personID(id)]
+        if p == nil || !keep(p) { http.Error(w, "person not found", 404); return }
+        writeJSON(w, p)
+        return
+    }
+    q := personID(r.URL.Query().Get("q"))
+    out := []Person{}
+    for _, p := range s.people(r) {
+        if q != "" && !strings.Contains(p.ID, q) || !keep(p) { continue }
+        out = append(out, p.Person)
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Tracks != out[j].Tracks { return out[i].Tracks > out[j].Tracks }
+        return out[i].ID < out[j].ID
+    })
+    writeJSON(w, out)
+}
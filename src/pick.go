@@ -0,0 +1,199 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== CLI Picker ======
+//
+// Track names and timestamps need not be typed exactly. What is typed for a
+// track (avcs latest, stems, changelog) or a stems set (--set) is matched
+// fuzzily: NEON_RAIN is found by neon, "neon rain" or nr, and 1040P-1130P by
+// 1130. One match is taken; when several match, or the track is left out,
+// avcs asks on the terminal:
+//
+//   avcs latest                 pick the track from all of them
+//   avcs stems neon --set 10    pick among NEON_RAIN's sets matching 10
+//
+// The picker is fzf if it is installed, or the command in AVCS_PICKER (as
+// sk), which is given the choices one per line and prints the one picked;
+// AVCS_PICKER=builtin, or no fzf, asks with a numbered list that narrows as
+// letters are typed. Without a terminal several matches are an error
+// listing them.
+
+// pickListed is how many choices the built-in picker lists at a time.
+const pickListed = 20
+
+var errNotPicked = errors.New("nothing picked")
+
+// fuzzyFold is s as fuzzyFilter compares it: lower case, without the
+// separators that are typed in any which way.
+func fuzzyFold(s string) string {
+    return strings.NewReplacer("_", "", "-", "", ".", "", " ", "").Replace(strings.ToLower(s))
+}
+
+// fuzzyFilter is the options that typed matches, best first: equal, then
+// starting with it, containing it, and containing its letters in order.
+// Options matching as well keep their order; nothing typed matches all.
+func fuzzyFilter(typed string, options []string) []string {
+    want := fuzzyFold(typed)
+    rank := func(o string) int {
+        have := fuzzyFold(o)
+        switch {
+        case have == want: return 0
+        case strings.HasPrefix(have, want): return 1
+        case strings.Contains(have, want): return 2
+        }
+        rest := want
+        for _, r := range have {
+            if rest != "" && strings.HasPrefix(rest, string(r)) { rest = rest[len(string(r)):] }
+        }
+        if rest == "" { return 3 }
+        return -1
+    }
+    var out []string
+    ranks := map[string]int{}
+    for _, o := range options {
+        if r := rank(o); r >= 0 { out, ranks[o] = append(out, o), r }
+    }
+    sort.SliceStable(out, func(i, j int) bool { return ranks[out[i]] < ranks[out[j]] })
+    return out
+}
+
+// isTerminal reports whether avcs can ask on stdin: a character device other
+// than the null device.
+func isTerminal() bool {
+    fi, err := os.Stdin.Stat()
+    if err != nil || fi.Mode()&os.ModeCharDevice == 0 { return false }
+    null, err := os.Stat(os.DevNull)
+    return err != nil || !os.SameFile(fi, null)
+}
+
+// pick is the one of options that typed means, asking on the terminal when
+// several match; what names them in messages.
+func pick(what, typed string, options []string) (string, error) {
+    matches := fuzzyFilter(typed, options)
+    switch {
+    case len(matches) == 0 && typed == "": return "", fmt.Errorf("no %ss yet", what)
+    case len(matches) == 0: return "", fmt.Errorf("no %s matches %q", what, typed)
+    case len(matches) == 1 || fuzzyFold(matches[0]) == fuzzyFold(typed) && typed != "":
+        if !strings.EqualFold(matches[0], typed) { fmt.Fprintf(os.Stderr, "%s %s\n", what, matches[0]) }
+        return matches[0], nil
+    case !isTerminal() && typed == "": return "", fmt.Errorf("which %s? give it", what)
+    case !isTerminal():
+        if len(matches) > pickListed { matches = append(matches[:pickListed], "...") }
+        return "", fmt.Errorf("%q matches more than one %s: %s", typed, what, strings.Join(matches, ", "))
+    }
+    picker := os.Getenv("AVCS_PICKER")
+    if picker == "" {
+        if _, err := exec.LookPath("fzf"); err == nil { picker = "fzf" }
+    }
+    if picker != "" && picker != "builtin" { return pickWith(picker, what, typed, matches) }
+    return pickListing(what, matches)
+}
+
+// pickWith has the picker command choose among options.
+func pickWith(picker, what, typed string, options []string) (string, error) {
+    args := strings.Fields(picker)
+    if base := filepath.Base(args[0]); base == "fzf" || base == "sk" {
+        args = append(args, "--height=40%", "--reverse", "--prompt="+what+"> ", "--query="+typed)
+    }
+    cmd := exec.Command(args[0], args[1:]...)
+    cmd.Stdin = strings.NewReader(strings.Join(options, "\n"))
+    cmd.Stderr = os.Stderr
+    out, err := cmd.Output()
+    choice := strings.TrimSpace(string(out))
+    var ee *exec.ExitError
+    if errors.As(err, &ee) || err == nil && choice == "" { return "", errNotPicked } // cancelled
+    if err != nil { return "", fmt.Errorf("picker %s: %w", args[0], err) }
+    if !slices.Contains(options, choice) { return "", fmt.Errorf("picker %s: %q is not a %s", args[0], choice, what) }
+    return choice, nil
+}
+
+// pickListing asks for one of options with a numbered list, narrowed by
+// what is typed until one is left or a number is.
+func pickListing(what string, options []string) (string, error) {
+    in := bufio.NewScanner(os.Stdin)
+    shown := options
+    for {
+        for i, o := range shown[:min(len(shown), pickListed)] { fmt.Fprintf(os.Stderr, "%4d  %s\n", i+1, o) }
+        if len(shown) > pickListed { fmt.Fprintf(os.Stderr, "      and %d more\n", len(shown)-pickListed) }
+        fmt.Fprintf(os.Stderr, "%s (number, or letters to narrow): ", what)
+        if !in.Scan() { fmt.Fprintln(os.Stderr); return "", errNotPicked }
+        typed := strings.TrimSpace(in.Text())
+        if typed == "" { return "", errNotPicked }
+        if n, err := strconv.Atoi(typed); err == nil && n >= 1 && n <= min(len(shown), pickListed) { return shown[n-1], nil }
+        narrowed := fuzzyFilter(typed, shown)
+        switch len(narrowed) {
+        case 0: fmt.Fprintf(os.Stderr, "Nothing matches %q\n", typed)
+        case 1: return narrowed[0], nil
+        default: shown = narrowed
+        }
+    }
+}
+
+// trackNames are the server's tracks, branches among them.
+func (c *cliClient) trackNames(ctx context.Context) ([]string, error) {
+    list, err := c.Tracks(ctx, client.TrackFilter{Branches: true})
+    if err != nil { return nil, err }
+    names := make([]string, len(list))
+    for i, t := range list { names[i] = t.Name }
+    sort.Strings(names)
+    return names, nil
+}
+
+// track is the track typed means: its name or alias, else the one it
+// fuzzily matches, else the one picked.
+func (c *cliClient) track(ctx context.Context, typed string) (*Track, error) {
+    if typed != "" {
+        t, err := c.Track(ctx, strings.ToUpper(typed))
+        if !client.IsNotFound(err) { return t, err }
+    }
+    names, err := c.trackNames(ctx)
+    if err != nil { return nil, err }
+    name, err := pick("track", typed, names)
+    if err != nil { return nil, err }
+    return c.Track(ctx, name)
+}
+
+// newestSets are t's stems sets, newest first.
+func newestSets(t *Track) []StemsSet {
+    sets := slices.Clone(t.Stems)
+    sort.SliceStable(sets, func(i, j int) bool { return sets[i].Latest.After(sets[j].Latest) })
+    return sets
+}
+
+// versionLabels are the T1-T2 of t's stems sets, mixes or masters, by kind,
+// newest first.
+func versionLabels(t *Track, kind string) []string {
+    type version struct {
+        label  string
+        latest time.Time
+    }
+    var vs []version
+    switch kind {
+    case kindStem, kindStems:
+        for _, s := range t.Stems { vs = append(vs, version{s.T1 + "-" + s.T2, s.Latest}) }
+    case kindMix:
+        for _, m := range t.Mixes { vs = append(vs, version{m.T1 + "-" + m.T2, m.Latest}) }
+    case kindMaster:
+        for _, m := range t.Masters { vs = append(vs, version{m.T1 + "-" + m.T2, m.Latest}) }
+    }
+    sort.SliceStable(vs, func(i, j int) bool { return vs[i].latest.After(vs[j].latest) })
+    labels := make([]string, len(vs))
+    for i, v := range vs { labels[i] = v.label }
+    return labels
+}
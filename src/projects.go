@@ -0,0 +1,218 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "path"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Projects ======
+//
+// Tracks are one flat namespace, but they are made for albums and EPs. A
+// project groups them, set up either way or both:
+//
+//   - a first-level folder holding a project.yaml, e.g.
+//     /Tracks/NIGHT_DRIVE/project.yaml, names the project for the folder and
+//     lists its tracks in order, with what else is known of it:
+//
+//       title: Night Drive
+//       type: ep                  # album, ep or single
+//       artist: Kim & Lee
+//       release_date: 2025-06-01
+//       tracks: [NEON_RAIN, GLASS_HOUSE]
+//       notes: |
+//         ...
+//
+//   - a track's track.yaml says "project: NIGHT_DRIVE"; such tracks follow
+//     the listed ones, by name.
+//
+// GET /api/projects lists them with their roll-up: tracks, how many are at
+// the workflow's last stage, how many have a FINAL, and the FINALs' total
+// running time. GET /api/projects/{name} adds each track's status, its most
+// recent FINAL and length. Tracks the caller may not see are left out, and
+// so are projects with none left. Projects are not releases (releases.go):
+// those pick the exact masters to deliver, these only group the work.
+
+const projectFile = "project.yaml"
+
+// Project is a project.yaml as read at the last reindex.
+type Project struct {
+    Name        string   `json:"name"`
+    Title       string   `json:"title,omitempty"`
+    Type        string   `json:"type,omitempty"` // album, ep, single
+    Artist      string   `json:"artist,omitempty"`
+    ReleaseDate string   `json:"release_date,omitempty"` // YYYY-MM-DD
+    Tracks      []string `json:"tracks,omitempty"`       // in order, as listed
+    Notes       string   `json:"notes,omitempty"`
+    Dir         string   `json:"dir,omitempty"`
+    Error       string   `json:"error,omitempty"` // why project.yaml could not be read
+}
+
+type (
+    ProjectSummary = client.ProjectSummary
+    ProjectTrack   = client.ProjectTrack
+    ProjectDetail  = client.ProjectDetail
+)
+
+// projectName is a project's name as the API uses it: NIGHT_DRIVE for
+// "Night Drive".
+func projectName(s string) string {
+    return strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToUpper(strings.TrimSpace(s)))
+}
+
+// isProjectFile reports whether e is the project.yaml of a first-level folder.
+func (s *Server) isProjectFile(e *dbxEntry) bool {
+    return e.Tag == "file" && path.Base(e.lower()) == projectFile && path.Dir(e.PathDisplay) == s.trackDir(e.PathDisplay)
+}
+
+// parseProject reads project.yaml. Keys it does not know are ignored.
+func parseProject(doc string) (*Project, error) {
+    p := &Project{}
+    for _, b := range splitYAML(doc) {
+        key := strings.ToLower(b.key)
+        switch key {
+        case "title", "type", "artist", "release_date", "tracks", "notes":
+        default:
+            continue
+        }
+        v, list, err := b.value()
+        if err != nil { return nil, err }
+        switch key {
+        case "title": p.Title = strings.TrimSpace(v)
+        case "type": p.Type = strings.ToLower(strings.TrimSpace(v))
+        case "artist": p.Artist = strings.TrimSpace(v)
+        case "release_date": p.ReleaseDate = strings.TrimSpace(v)
+        case "notes": p.Notes = strings.TrimSpace(v)
+        case "tracks":
+            if v != "" { list = []string{v} }
+            for _, t := range list {
+                if t = strings.ToUpper(strings.TrimSpace(t)); t != "" && !slices.Contains(p.Tracks, t) { p.Tracks = append(p.Tracks, t) }
+            }
+        }
+    }
+    if p.Type != "" && !slices.Contains(releaseTypes, p.Type) { return nil, fmt.Errorf("type must be one of %s", strings.Join(releaseTypes, ", ")) }
+    if p.ReleaseDate != "" {
+        if _, err := time.Parse("2006-01-02", p.ReleaseDate); err != nil { return nil, fmt.Errorf("release_date must be YYYY-MM-DD") }
+    }
+    return p, nil
+}
+
+// projectDef reads the project.yaml e, caching by path and revision; one
+// that cannot be read still names its project, with the error.
+func (s *Server) projectDef(ctx context.Context, e *dbxEntry) *Project {
+    key := e.lower() + "@" + e.ContentHash + "@" + e.ServerModified.Format(time.RFC3339)
+    s.alsMu.Lock(); p := s.projCache[key]; s.alsMu.Unlock()
+    if p != nil { return p }
+    dir := path.Dir(e.PathDisplay)
+    doc, err := s.readMetadataFile(ctx, e.PathDisplay)
+    if err == nil { p, err = parseProject(doc) }
+    if err != nil {
+        slog.WarnContext(ctx, "project.yaml could not be read", "path", e.PathDisplay, "error", err)
+        return &Project{Name: projectName(path.Base(dir)), Dir: dir, Error: err.Error()} // read again next time
+    }
+    p.Name, p.Dir = projectName(path.Base(dir)), dir
+    s.alsMu.Lock(); s.projCache[key] = p; s.alsMu.Unlock()
+    return p
+}
+
+// finalLength is the length of the WAV f, caching by path and content.
+func (s *Server) finalLength(ctx context.Context, f *FileRef) (time.Duration, error) {
+    key := strings.ToLower(f.Path) + "@" + f.ContentHash
+    s.alsMu.Lock(); d, ok := s.lenCache[key]; s.alsMu.Unlock()
+    if ok { return d, nil }
+    d, err := s.wavDuration(ctx, f.Path)
+    if err != nil { return 0, err }
+    s.alsMu.Lock(); s.lenCache[key] = d; s.alsMu.Unlock()
+    return d, nil
+}
+
+// projectDetails rolls up every project r may see, by name; only the one
+// named when only is set.
+func (s *Server) projectDetails(r *http.Request, only string) []*ProjectDetail {
+    aliases := s.aliasMap()
+    last := s.flow.Stages[len(s.flow.Stages)-1]
+    var out []*ProjectDetail
+    s.mu.RLock()
+    defs := map[string]*Project{}
+    for name, p := range s.projects { defs[name] = p }
+    named := map[string][]string{} // project -> tracks whose track.yaml names it
+    for name, t := range s.tracks {
+        if t.Parent != "" || t.Metadata == nil || t.Metadata.Project == "" { continue }
+        named[t.Metadata.Project] = append(named[t.Metadata.Project], name)
+        if defs[t.Metadata.Project] == nil { defs[t.Metadata.Project] = &Project{Name: t.Metadata.Project} }
+    }
+    for name, p := range defs {
+        if only != "" && name != only { continue }
+        d := &ProjectDetail{ProjectSummary: ProjectSummary{Name: name, Title: p.Title, Type: p.Type, Artist: p.Artist, ReleaseDate: p.ReleaseDate, Dir: p.Dir, Error: p.Error}, Notes: p.Notes, Tracks: []ProjectTrack{}}
+        sort.Strings(named[name])
+        members := append(slices.Clone(p.Tracks), named[name]...)
+        seen := map[string]bool{}
+        hidden := 0
+        for _, m := range members {
+            m = canonicalName(aliases, m)
+            if seen[m] { continue }
+            seen[m] = true
+            if !s.mayAccess(r, m) { hidden++; continue }
+            pt := ProjectTrack{Track: m}
+            t := s.tracks[m]
+            if t == nil { pt.Problem = "track not found"; d.Tracks = append(d.Tracks, pt); continue }
+            pt.Status = s.statusOf(m).Status
+            if pt.Status == last { d.Complete++ }
+            for i := range t.Masters {
+                if f := t.Masters[i].Final; f != nil && (pt.Final == nil || f.ServerModified.After(pt.Final.ServerModified)) { pt.Final = f }
+            }
+            if pt.Final != nil { d.Finals++ } else { pt.Problem = "no FINAL master" }
+            d.Tracks = append(d.Tracks, pt)
+        }
+        if len(d.Tracks) == 0 && hidden > 0 { continue } // none of it may be seen
+        d.TrackCount = len(d.Tracks)
+        out = append(out, d)
+    }
+    s.mu.RUnlock()
+
+    // Lengths may need the FINALs' headers downloaded; not under the lock.
+    for _, d := range out {
+        var total time.Duration
+        for i := range d.Tracks {
+            pt := &d.Tracks[i]
+            if pt.Final == nil { continue }
+            length, err := s.finalLength(r.Context(), pt.Final)
+            if err != nil { slog.WarnContext(r.Context(), "FINAL length unknown", "path", pt.Final.Path, "error", err); continue }
+            pt.Seconds = length.Round(time.Millisecond).Seconds()
+            total += length
+        }
+        d.Runtime, d.Length = total.Round(time.Millisecond).Seconds(), clockLength(total)
+        for i := range d.Tracks {
+            pt := &d.Tracks[i]
+            if pt.Final == nil || can(r, permMasters) { continue }
+            if t := s.lookupTrack(pt.Track); t == nil || !s.released(t) { pt.Final = nil } // counted, not handed out
+        }
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+    return out
+}
+
+// GET /api/projects
+// GET /api/projects/{name}
+func (s *Server) handleProjects(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/projects"), "/")
+    if name == "" {
+        out := []ProjectSummary{}
+        for _, d := range s.projectDetails(r, "") { out = append(out, d.ProjectSummary) }
+        writeJSON(w, out)
+        return
+    }
+    if strings.Contains(name, "/") { http.NotFound(w, r); return }
+    found := s.projectDetails(r, projectName(name))
+    if len(found) == 0 { http.Error(w, "project not found", 404); return }
+    writeJSON(w, found[0])
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "net/http"
+    "path"
+    "strings"
+    "time"
+)
+
+// ====== Master Promotion ======
+
+// handlePromote makes a master candidate the FINAL. Per the spec the FINAL is
+// a duplicate of the chosen candidate, so the candidate is copied to
+// <TRACK>-<T1>-<T2>-FINAL.wav; a previous FINAL is moved into _archive/ first.
+func (s *Server) handlePromote(w http.ResponseWriter, r *http.Request, t *Track, t1, t2 string) {
+    if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    idx := r.URL.Query().Get("candidate")
+    if idx == "" || strings.EqualFold(idx, "FINAL") { http.Error(w, "candidate index required", 400); return }
+
+    s.writeMu.Lock(); defer s.writeMu.Unlock()
+    // re-read under the write lock; the track may have changed since routing
+    s.mu.RLock(); t = s.tracks[t.Name]; s.mu.RUnlock()
+    if t == nil { http.Error(w, "track not found", 404); return }
+    var set *MasterSet
+    for i := range t.Masters {
+        if t.Masters[i].T1 == t1 && t.Masters[i].T2 == t2 { set = &t.Masters[i] }
+    }
+    if set == nil { http.Error(w, "master set not found", 404); return }
+    var cand *FileRef
+    for i := range set.Candidates {
+        if candidateIdx(set.Candidates[i]) == idx { cand = &set.Candidates[i] }
+    }
+    if cand == nil { http.Error(w, "candidate "+idx+" not found", 404); return }
+
+    ctx := r.Context()
+    dir := path.Dir(cand.Path)
+    finalPath := path.Join(dir, fmt.Sprintf("%s-%s-%s-FINAL.wav", t.Name, t1, t2))
+    var archived string
+    if set.Final != nil {
+        archived = path.Join(path.Dir(set.Final.Path), archiveDir,
+            strings.TrimSuffix(set.Final.Name, ".wav")+"."+time.Now().UTC().Format("20060102T150405Z")+".wav")
+        if _, err := s.dbxMove(ctx, set.Final.Path, archived); err != nil {
+            http.Error(w, "archive previous FINAL: "+err.Error(), 502); return
+        }
+        finalPath = path.Join(path.Dir(set.Final.Path), set.Final.Name)
+    }
+    e, err := s.dbxCopy(ctx, cand.Path, finalPath)
+    if err != nil {
+        if archived != "" {
+            if _, rerr := s.dbxMove(ctx, archived, set.Final.Path); rerr != nil {
+                err = fmt.Errorf("%v (restoring previous FINAL also failed: %v)", err, rerr)
+            }
+        }
+        http.Error(w, "copy candidate: "+err.Error(), 502); return
+    }
+
+    final := fileRefOf(e)
+    final.ContributedBy = s.contributorOf(e)
+    var updated MasterSet
+    s.updateTrack(t.Name, func(t *Track) {
+        for i := range t.Masters {
+            if t.Masters[i].T1 != t1 || t.Masters[i].T2 != t2 { continue }
+            t.Masters[i].Final = &final
+            if final.ServerModified.After(t.Masters[i].Latest) { t.Masters[i].Latest = final.ServerModified }
+            updated = t.Masters[i]
+        }
+    })
+    var before any
+    if set.Final != nil { before = map[string]string{"final": set.Final.Path} }
+    after := map[string]string{"candidate": cand.Path, "final": final.Path}
+    if archived != "" { after["archived"] = archived }
+    s.audit(r, "promote", t.Name, before, after)
+    if final.ContentHash != "" {
+        b := Baseline{Path: final.Path, Track: t.Name, Artifact: ArtifactRef{Kind: kindMaster, T1: t1, T2: t2, Idx: "FINAL"},
+            ContentHash: final.ContentHash, Size: final.Size, Recorded: time.Now().UTC(), By: actorOf(r)}
+        if err := s.setBaselines([]Baseline{b}); err != nil { slog.ErrorContext(r.Context(), "recording baseline failed", "path", final.Path, "error", err) }
+    }
+    writeJSON(w, updated)
+}
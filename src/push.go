@@ -0,0 +1,220 @@
+package main
+
+import (
+    "cmp"
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== CLI Push ======
+//
+// avcs push uploads exports, named to the convention on the way:
+//
+//   avcs push ./bounces/*.wav --track MIDNIGHT [--kind stems] [--direct] [--dry-run]
+//
+// A file named to the convention, or nearly (see correctName), keeps its
+// name; any other is named by the server's /api/suggest-name from its file
+// name, --track, --kind, --t1 and --t2, taking the newest file's time as the
+// export time, so stems exported together share their T2. Stems take a T1
+// remembered by avcs name. Folders stand for the files avcs lint would look
+// at in them.
+//
+// The files are then judged as avcs lint judges them, and nothing is
+// uploaded while any would be rejected or misfiled. --overwrite replaces
+// files the server has by the same name, --force lets incomplete or
+// mismatched stems sets through; files the server already has are skipped.
+//
+// Uploads go through the server's /api/upload, or /api/uploads over 150 MB,
+// resuming after a dropped connection. With --direct they go straight to
+// Dropbox, up to 150 MB, on links the server issues (/api/upload-link), and
+// the server is asked to reindex after. Either way push then waits, up to
+// --wait, until the index lists every file it uploaded.
+
+// pollInterval is how often push asks whether the index has its files yet.
+const pollInterval = 2 * time.Second
+
+// cliPush is `avcs push`.
+func cliPush(args []string) error {
+    fs := flag.NewFlagSet("push", flag.ExitOnError)
+    connect := cliFlags(fs)
+    track := fs.String("track", "", "the track the files belong to (default: from each file's name)")
+    kind := fs.String("kind", "", "session, stems, mix or master (default: from each file's name)")
+    t1 := fs.String("t1", "", "T1 (default for stems: remembered by avcs name, else the latest snapshot's)")
+    t2 := fs.String("t2", "", "T2, for a mix or master (default: the latest stems set's or mix's)")
+    stems := fs.String("stems", "", "the stems every set needs, as DRUMS,BASS,VOX (default: the track's latest set's on the server)")
+    overwrite := fs.Bool("overwrite", false, "replace files the server has by the same name")
+    force := fs.Bool("force", false, "push stems sets that are incomplete or mismatched")
+    direct := fs.Bool("direct", false, "upload straight to Dropbox, on links the server issues")
+    dryRun := fs.Bool("dry-run", false, "show what would be uploaded, as what, and stop")
+    wait := fs.Duration("wait", 2*time.Minute, "how long to wait for the index to list the files (0: do not wait)")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs push <file or folder>... [flags]")
+        fs.PrintDefaults()
+    }
+    operands := parseCLI(fs, args)
+    if len(operands) == 0 { fs.Usage(); os.Exit(2) }
+    c, err := connect()
+    if err != nil { return err }
+
+    var files []string
+    for _, p := range operands {
+        st, err := os.Stat(p)
+        if err != nil { return err }
+        if !st.IsDir() { files = append(files, p); continue }
+        in, err := lintWalk(p)
+        if err != nil { return err }
+        files = append(files, in...)
+    }
+    if len(files) == 0 { return errors.New("nothing to push") }
+
+    names, err := c.pushNames(files, *track, cliKind(*kind), *t1, *t2)
+    if err != nil { return err }
+    l := &linter{c: c, track: strings.ToUpper(strings.TrimSpace(*track)), stems: splitStems(*stems), tracks: map[string]*Track{}}
+    if l.track != "" {
+        t, err := l.remote(l.track)
+        if err != nil { return err }
+        if t != nil { l.track = t.Name }
+    }
+    found, err := l.lint(".", files, names)
+    if err != nil { return err }
+
+    var todo []lintFinding
+    blocked := 0
+    for _, f := range found {
+        switch f.Verdict {
+        case "ok", "rename":
+        case "uploaded": continue
+        case "exists":
+            if !*overwrite { blocked++; continue }
+        case "incomplete", "mismatched":
+            if !*force { blocked++; continue }
+        default:
+            blocked++; continue
+        }
+        if f.local != "" { todo = append(todo, f) }
+    }
+    if *dryRun || blocked > 0 {
+        if err := printFindings(found, len(files), true); err != nil { return err }
+        if blocked > 0 { return fmt.Errorf("nothing pushed: %d to fix first", blocked) }
+        return nil
+    }
+    if len(todo) == 0 { fmt.Println("The server has all of them already"); return nil }
+
+    reindex := false
+    for i, f := range todo {
+        p, viaLink, err := c.push(f, *direct, *overwrite)
+        if err != nil { return fmt.Errorf("%s (after %d of %d uploaded): %w", f.File, i, len(todo), err) }
+        reindex = reindex || viaLink
+        fmt.Printf("Uploaded %s → %s\n", f.File, cmp.Or(p, f.Name))
+    }
+    if reindex {
+        if err := c.Reindex(context.Background()); err != nil { return fmt.Errorf("uploaded, but reindex failed: %w", err) }
+    }
+    if *wait <= 0 { return nil }
+    return c.awaitIndex(todo, *wait)
+}
+
+// pushNames is the name each of files is to be uploaded as where that is not
+// its own, or nearly: the server's suggestion, with the newest file's time
+// as the export time.
+func (c *cliClient) pushNames(files []string, track, kind, t1, t2 string) (map[string]string, error) {
+    var newest time.Time
+    for _, p := range files {
+        st, err := os.Stat(p)
+        if err != nil { return nil, err }
+        if st.ModTime().After(newest) { newest = st.ModTime() }
+    }
+    sessions := cliSessions()
+    names := map[string]string{}
+    for _, p := range files {
+        base := filepath.Base(p)
+        if _, ok := classifyName(base); ok { continue }
+        if _, ok := classifyName(correctName(base)); ok { continue }
+        nr := client.NameRequest{Filename: base, Track: track, Kind: kind, Time: timeToken(newest), T1: t1, T2: t2}
+        sg, err := c.SuggestName(context.Background(), nr)
+        if err != nil { return nil, fmt.Errorf("%s: %w", p, err) }
+        if was, ok := sessions[c.Server+" "+sg.Track]; ok && t1 == "" && sg.Kind == kindStem {
+            nr.T1 = was.T1
+            if sg, err = c.SuggestName(context.Background(), nr); err != nil { return nil, fmt.Errorf("%s: %w", p, err) }
+        }
+        names[p] = sg.Name
+    }
+    return names, nil
+}
+
+// push uploads the file f judges, as f.Name, and returns its Dropbox path if
+// known and whether it went on an upload link, which the server has yet to
+// index.
+func (c *cliClient) push(f lintFinding, direct, overwrite bool) (string, bool, error) {
+    fh, err := os.Open(f.local)
+    if err != nil { return "", false, err }
+    defer fh.Close()
+    st, err := fh.Stat()
+    if err != nil { return "", false, err }
+    ctx := context.Background()
+    switch {
+    case st.Size() > maxSimpleUpload:
+        _, err := c.UploadResumable(ctx, f.Name, fh, st.Size(), overwrite)
+        return f.Dest, false, err
+    case direct:
+        ul, err := c.UploadLink(ctx, f.Name, overwrite)
+        if err != nil { return "", false, err }
+        req, err := http.NewRequest(http.MethodPost, ul.Link, fh)
+        if err != nil { return "", false, err }
+        req.ContentLength = st.Size()
+        req.Header.Set("Content-Type", "application/octet-stream")
+        res, err := c.HTTP.Do(req)
+        if err != nil { return "", false, err }
+        defer res.Body.Close()
+        if res.StatusCode >= 300 {
+            b, _ := io.ReadAll(res.Body)
+            return "", false, fmt.Errorf("upload to Dropbox: %s: %s", res.Status, truncate(strings.TrimSpace(string(b)), 200))
+        }
+        return ul.Path, true, nil
+    }
+    out, err := c.Upload(ctx, f.Name, fh, overwrite)
+    if err != nil { return "", false, err }
+    return out.File.Path, false, nil
+}
+
+// awaitIndex waits until the server's index lists every file pushed, by name
+// and size, or timeout has passed.
+func (c *cliClient) awaitIndex(pushed []lintFinding, timeout time.Duration) error {
+    sizes := map[string]int64{}
+    for _, f := range pushed {
+        st, err := os.Stat(f.local)
+        if err != nil { return err }
+        sizes[f.local] = st.Size()
+    }
+    deadline := time.Now().Add(timeout)
+    for {
+        tracks := map[string]*Track{}
+        var missing []string
+        for _, f := range pushed {
+            t, ok := tracks[f.np.Track]
+            if !ok {
+                got, err := c.Track(context.Background(), f.np.Track)
+                if err != nil && !client.IsNotFound(err) { return err }
+                t = got
+                tracks[f.np.Track] = t
+            }
+            if t == nil || !slices.ContainsFunc(trackFiles(t), func(rf ManifestFile) bool { return strings.EqualFold(rf.Name, f.Name) && rf.Size == sizes[f.local] }) {
+                missing = append(missing, f.Name)
+            }
+        }
+        if len(missing) == 0 { fmt.Printf("Indexed: the server lists all %d\n", len(pushed)); return nil }
+        if time.Now().After(deadline) { return fmt.Errorf("after %s the index still lacks %s", timeout, strings.Join(missing, ", ")) }
+        time.Sleep(pollInterval)
+    }
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "log"
+    "math"
+    "net"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ====== Rate Limits ======
+//
+// Token buckets, in requests per minute, on top of each key's own rate limit:
+//
+//   IP_RATE_LIMIT         every request from one client address (default 600)
+//   FETCH_IP_RATE_LIMIT   fetches from one client address (default 60)
+//   FETCH_KEY_RATE_LIMIT  fetches by one key or signed-in user (default 120)
+//
+// Fetches are the requests that pull audio out of Dropbox for the client:
+// temporary links, A/B streams, session bundles and shared files. They cost
+// Dropbox API quota, so they get tighter limits. 0 turns a limit off. Client
+// addresses are the connection's, or with TRUST_PROXY set the first one in
+// X-Forwarded-For, which only a proxy in front of the server should be
+// trusted to set.
+
+const limiterIdle = 10 * time.Minute // address buckets unused this long are dropped
+
+// fetchPath reports whether p pulls a file's content or a link to it.
+func fetchPath(p string) bool {
+    if signedPath(p) { return true }
+    if parts := strings.Split(strings.Trim(strings.TrimPrefix(p, "/s/"), "/"), "/"); strings.HasPrefix(p, "/s/") && len(parts) == 3 && parts[1] == "files" {
+        return true
+    }
+    return strings.HasPrefix(p, "/api/tracks/") && strings.HasSuffix(p, "/bundle")
+}
+
+// envPerMinute reads a requests-per-minute setting.
+func envPerMinute(name string, def int) int {
+    v := os.Getenv(name)
+    if v == "" { return def }
+    n, err := strconv.Atoi(v)
+    if err != nil || n < 0 { log.Fatalf("%s must be requests per minute, not %q", name, v) }
+    return n
+}
+
+// clientIP is the address a request came from.
+func (s *Server) clientIP(r *http.Request) string {
+    if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" && s.trustProxy {
+        first, _, _ := strings.Cut(fwd, ",")
+        if ip := strings.TrimSpace(first); ip != "" { return ip }
+    }
+    if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil { return host }
+    return r.RemoteAddr
+}
+
+// allowOrRefuse takes a token for id from l, or answers 429 and counts which
+// limit was hit.
+func (s *Server) allowOrRefuse(w http.ResponseWriter, l *keyLimiter, id string, perMinute int, limit string) bool {
+    ok, wait := l.allow(id, perMinute, time.Now())
+    if ok { return true }
+    s.metrics.add("avcs_rate_limited_total", 1, "limit", limit)
+    w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+    http.Error(w, "rate limit exceeded", 429)
+    return false
+}
+
+// limitRequests applies the per-address limits and counts requests.
+func (s *Server) limitRequests(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        p := r.URL.Path
+        class := "other"
+        switch {
+        case fetchPath(p): class = "fetch"
+        case strings.HasPrefix(p, "/api/"): class = "api"
+        }
+        s.metrics.add("avcs_requests_total", 1, "class", class)
+        ip := s.clientIP(r)
+        if !s.allowOrRefuse(w, s.ipLimiter, ip, s.ipRateLimit, "ip") { return }
+        if class == "fetch" && !s.allowOrRefuse(w, s.ipLimiter, "fetch:"+ip, s.fetchIPRateLimit, "fetch_ip") { return }
+        next.ServeHTTP(w, r)
+    })
+}
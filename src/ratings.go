@@ -0,0 +1,178 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Ratings & Favorites ======
+
+type (
+    Rating        = client.Rating
+    RatingSummary = client.RatingSummary
+    VersionRating = client.VersionRating
+)
+
+func (s *Server) ratingsFor(track string) []Rating {
+    var out []Rating
+    s.store.view(func(d *storeData) { out = append(out, d.Ratings[track]...) })
+    return out
+}
+
+// summarizeRatings aggregates per artifact, keyed by the artifact ref.
+func summarizeRatings(list []Rating) map[ArtifactRef]*RatingSummary {
+    out := map[ArtifactRef]*RatingSummary{}
+    for _, rt := range list {
+        sum := out[rt.Artifact]
+        if sum == nil { sum = &RatingSummary{Artifact: rt.Artifact, Stars: map[string]int{}}; out[rt.Artifact] = sum }
+        if rt.Favorite { sum.Favorites++ }
+        if rt.Stars > 0 {
+            sum.Average = (sum.Average*float64(sum.Votes) + float64(rt.Stars)) / float64(sum.Votes+1)
+            sum.Votes++
+            sum.Stars[rt.User] = rt.Stars
+        }
+    }
+    return out
+}
+
+// byRating orders best first: higher average, then more votes, then more favorites.
+func byRating(a, b *RatingSummary) bool {
+    if a.Average != b.Average { return a.Average > b.Average }
+    if a.Votes != b.Votes { return a.Votes > b.Votes }
+    return a.Favorites > b.Favorites
+}
+
+// GET    /api/tracks/{name}/ratings[?user=]
+// PUT    /api/tracks/{name}/ratings {"artifact":{...},"stars":4,"favorite":true}
+// DELETE /api/tracks/{name}/ratings?kind=&t1=&t2=&idx=
+// Ratings are per user; PUT replaces the caller's rating of that artifact.
+func (s *Server) handleRatings(w http.ResponseWriter, r *http.Request, t *Track) {
+    q := r.URL.Query()
+    switch r.Method {
+    case http.MethodGet:
+        all := s.ratingsFor(t.Name)
+        if user := q.Get("user"); user != "" {
+            mine := []Rating{}
+            for _, rt := range all {
+                if rt.User == user { mine = append(mine, rt) }
+            }
+            writeJSON(w, mine)
+            return
+        }
+        artifacts := []*RatingSummary{}
+        for _, sum := range summarizeRatings(all) { artifacts = append(artifacts, sum) }
+        sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].Artifact.String() < artifacts[j].Artifact.String() })
+        sort.SliceStable(artifacts, func(i, j int) bool { return byRating(artifacts[i], artifacts[j]) })
+        // artifacts is best first, so the first file seen per version is its best.
+        type key struct{ t1, t2 string }
+        byVersion := map[key]*VersionRating{}
+        versions := []*VersionRating{}
+        for _, sum := range artifacts {
+            k := key{sum.Artifact.T1, sum.Artifact.T2}
+            v := byVersion[k]
+            if v == nil {
+                best := sum.Artifact
+                v = &VersionRating{T1: k.t1, T2: k.t2, Best: &best}
+                byVersion[k] = v
+                versions = append(versions, v)
+            }
+            if sum.Votes > 0 { v.Average = (v.Average*float64(v.Votes) + sum.Average*float64(sum.Votes)) / float64(v.Votes+sum.Votes) }
+            v.Votes += sum.Votes
+            v.Favorites += sum.Favorites
+        }
+        sort.SliceStable(versions, func(i, j int) bool {
+            a, b := versions[i], versions[j]
+            if a.Average != b.Average { return a.Average > b.Average }
+            return a.Votes > b.Votes
+        })
+        writeJSON(w, map[string]any{"artifacts": artifacts, "versions": versions})
+
+    case http.MethodPut:
+        var req struct {
+            Artifact ArtifactRef `json:"artifact"`
+            Stars    int         `json:"stars"`
+            Favorite bool        `json:"favorite"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        if req.Stars < 0 || req.Stars > 5 { http.Error(w, "stars must be 1-5", 400); return }
+        if req.Stars == 0 && !req.Favorite { http.Error(w, "stars or favorite required", 400); return }
+        if !singleFile(req.Artifact) { http.Error(w, "ratings go on a mix or a master candidate/FINAL", 400); return }
+        if err := req.Artifact.Resolve(t); err != nil { http.Error(w, err.Error(), 404); return }
+        rt := Rating{Artifact: req.Artifact, User: actorOf(r), Stars: req.Stars, Favorite: req.Favorite, Updated: time.Now().UTC()}
+        var before any
+        err := s.store.update(func(d *storeData) error {
+            if d.Ratings == nil { d.Ratings = map[string][]Rating{} }
+            list := d.Ratings[t.Name]
+            for i, x := range list {
+                if x.User == rt.User && x.Artifact == rt.Artifact { before = x; list[i] = rt; return nil }
+            }
+            d.Ratings[t.Name] = append(list, rt)
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "rate", t.Name, before, rt)
+        writeJSON(w, rt)
+
+    case http.MethodDelete:
+        ref := ArtifactRef{Kind: q.Get("kind"), T1: q.Get("t1"), T2: q.Get("t2"), Idx: q.Get("idx")}
+        user := actorOf(r)
+        var removed *Rating
+        err := s.store.update(func(d *storeData) error {
+            list := d.Ratings[t.Name]
+            for i, x := range list {
+                if x.User != user || x.Artifact != ref { continue }
+                removed = &x
+                d.Ratings[t.Name] = append(list[:i:i], list[i+1:]...)
+                return nil
+            }
+            return httpError{404, "no rating of yours on " + ref.String()}
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "unrate", t.Name, removed, nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "GET, PUT or DELETE required", 405)
+    }
+}
+
+type RatedCandidate = client.RatedCandidate
+
+// GET /api/tracks/{name}/masters/{t1}/{t2}/candidates[?sort=rating|idx]
+// Best rated first by default, to help pick the one to promote to FINAL.
+func (s *Server) handleCandidates(w http.ResponseWriter, r *http.Request, t *Track, t1, t2 string) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    var set *MasterSet
+    for i := range t.Masters {
+        if t.Masters[i].T1 == t1 && t.Masters[i].T2 == t2 { set = &t.Masters[i] }
+    }
+    if set == nil { http.Error(w, "master set not found", 404); return }
+    per := summarizeRatings(s.ratingsFor(t.Name))
+    out := []RatedCandidate{}
+    for _, c := range set.Candidates {
+        ref := ArtifactRef{Kind: kindMaster, T1: t1, T2: t2, Idx: candidateIdx(c)}
+        rc := RatedCandidate{Idx: ref.Idx, File: c, Rating: RatingSummary{Artifact: ref}}
+        if sum := per[ref]; sum != nil { rc.Rating = *sum }
+        out = append(out, rc)
+    }
+    idxLess := func(i, j int) bool {
+        a, _ := strconv.Atoi(out[i].Idx)
+        b, _ := strconv.Atoi(out[j].Idx)
+        return a < b
+    }
+    switch r.URL.Query().Get("sort") {
+    case "idx":
+        sort.SliceStable(out, idxLess)
+    case "", "rating":
+        sort.SliceStable(out, idxLess)
+        sort.SliceStable(out, func(i, j int) bool { return byRating(&out[i].Rating, &out[j].Rating) })
+    default:
+        http.Error(w, "sort must be rating or idx", 400); return
+    }
+    writeJSON(w, out)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+)
+
+// ====== Read-only Mode ======
+//
+// With READ_ONLY set, the server browses, streams and links but changes
+// nothing: every request that needs the write scope (uploads, renames,
+// promotions, deletions, tags, comments, ...) answers 403, whoever sends it,
+// and neither inbox filing, the trash purge nor WRITE_MANIFESTS touch
+// Dropbox, nor is anything announced on Slack or Discord. This is for a staging
+// instance pointed at the production Dropbox. Reindexing and verification
+// only read, so they still run; admin endpoints such as API keys and logging
+// are not writes to the catalog and keep working.
+
+// refuseWrites answers 403 to requests that would change something when the
+// server is read-only.
+func (s *Server) refuseWrites(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        p := r.URL.Path
+        if s.readOnly && strings.HasPrefix(p, "/api/") && requiredScope(r) == scopeWrite && p != "/api/reindex" && p != "/api/verify" {
+            http.Error(w, "the server is read-only (READ_ONLY is set)", 403)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+    "path"
+    "regexp"
+    "slices"
+    "strings"
+)
+
+// ====== Reference Tracks ======
+//
+// Commercial songs a track is mixed and mastered against are kept with it,
+// but they are nobody's version of it. A reference is an audio file either
+// in a References/ (or refs/) folder anywhere in a track folder, or named
+// with the REF suffix anywhere under the root:
+//
+//   <TRACK>-<title>-REF.<ext>     e.g. NEON_RAIN-Midnight City-REF.mp3
+//   <TRACK>-REF.<ext>
+//
+// A named one belongs to the track (or branch) its name gives; one in a
+// folder to the track whose folder it is in, and its branches. Either way it
+// is listed in the track's references, sorted by name, and never among the
+// snapshots, stems, mixes or masters; nor is it counted a version, put in a
+// manifest or proposed for renaming. A name that reads as a stem
+// (<TRACK>-<T1>-<T2>-REF.wav) stays one, unless it is in a references folder.
+
+var (
+    referenceExts = []string{"wav", "mp3", "aif", "aiff", "flac", "m4a", "ogg"}
+    referenceDirs = []string{"references", "refs"}
+    reReference   = regexp.MustCompile(`^` + reTrack + `(?:-(?P<title>.+))?-REF\.(?P<ext>[A-Za-z0-9]+)$`)
+)
+
+// referenceTrack is the track a reference's name gives, if it has one.
+func referenceTrack(base string) (string, bool) {
+    g := rxGroups(reReference, base)
+    if g == nil || !slices.Contains(referenceExts, strings.ToLower(g["ext"])) { return "", false }
+    if _, ok := classifyName(base); ok { return "", false }
+    return g["track"], true
+}
+
+// isReference reports whether e is a reference track.
+func (s *Server) isReference(e *dbxEntry) bool {
+    if e.Tag != "file" || !slices.Contains(referenceExts, strings.TrimPrefix(path.Ext(e.lower()), ".")) { return false }
+    if _, ok := referenceTrack(path.Base(e.PathDisplay)); ok { return true }
+    dir := path.Dir(e.PathDisplay)
+    rel := strings.TrimPrefix(strings.ToLower(dir), strings.ToLower(s.trackDir(e.PathDisplay)))
+    if len(rel) == len(dir) { return false } // not in a track folder
+    return slices.ContainsFunc(strings.Split(rel, "/"), func(seg string) bool { return slices.Contains(referenceDirs, seg) })
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Releases ======
+
+var releaseTypes = []string{"single", "ep", "album"}
+
+type (
+    Release         = client.Release
+    ReleaseTrack    = client.ReleaseTrack
+    ResolvedTrack   = client.ResolvedTrack
+    ResolvedRelease = client.ResolvedRelease
+)
+
+// resolveRelease looks up each position's FINAL in the current index.
+func (s *Server) resolveRelease(rel *Release) *ResolvedRelease {
+    out := &ResolvedRelease{Release: *rel, Tracks: []ResolvedTrack{}, Ready: true}
+    s.mu.RLock(); defer s.mu.RUnlock()
+    for i, rt := range rel.Tracks {
+        res := ResolvedTrack{ReleaseTrack: rt, Position: i + 1}
+        t := s.tracks[rt.Track]
+        var best *MasterSet
+        if t != nil {
+            for j := range t.Masters {
+                m := &t.Masters[j]
+                if m.Final == nil { continue }
+                if rt.T1 != "" && (m.T1 != rt.T1 || m.T2 != rt.T2) { continue }
+                if best == nil || m.Final.ServerModified.After(best.Final.ServerModified) { best = m }
+            }
+        }
+        switch {
+        case t == nil: res.Problem = "track not found"
+        case best == nil && rt.T1 != "": res.Problem = fmt.Sprintf("no FINAL for %s-%s", rt.T1, rt.T2)
+        case best == nil: res.Problem = "no FINAL master"
+        default:
+            f := *best.Final
+            res.Final = &f
+            res.T1, res.T2 = best.T1, best.T2
+        }
+        if res.Final == nil { out.Ready = false }
+        out.Tracks = append(out.Tracks, res)
+    }
+    if len(out.Tracks) == 0 { out.Ready = false }
+    if out.Artwork == "" && len(rel.Tracks) > 0 { // the lead track's cover
+        if t := s.tracks[rel.Tracks[0].Track]; t != nil {
+            if a := s.primaryArtwork(t); a != nil { out.Artwork = a.Path }
+        }
+    }
+    return out
+}
+
+// releaseInput is the writable part of a Release; nil fields are left as they are.
+type releaseInput = client.ReleaseChange
+
+func (s *Server) applyRelease(ctx context.Context, rel *Release, in releaseInput) error {
+    if in.Title != nil { rel.Title = strings.TrimSpace(*in.Title) }
+    if in.Type != nil { rel.Type = strings.ToLower(strings.TrimSpace(*in.Type)) }
+    if in.Artist != nil { rel.Artist = strings.TrimSpace(*in.Artist) }
+    if in.ReleaseDate != nil { rel.ReleaseDate = strings.TrimSpace(*in.ReleaseDate) }
+    if in.UPC != nil { rel.UPC = strings.TrimSpace(*in.UPC) }
+    if in.Label != nil { rel.Label = strings.TrimSpace(*in.Label) }
+    if in.Territories != nil {
+        rel.Territories = nil
+        for _, c := range *in.Territories {
+            c = strings.ToUpper(strings.TrimSpace(c))
+            if c == "WORLDWIDE" || c == "WW" { rel.Territories = nil; break }
+            if len(c) != 2 || strings.Trim(c, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" { return httpError{400, "territories must be ISO 3166-1 alpha-2 codes like GB, or Worldwide"} }
+            if !slices.Contains(rel.Territories, c) { rel.Territories = append(rel.Territories, c) }
+        }
+    }
+    if in.Artwork != nil { rel.Artwork = strings.TrimSpace(*in.Artwork) }
+    if in.Tracks != nil { rel.Tracks = append([]ReleaseTrack{}, *in.Tracks...) }
+
+    if rel.Title == "" { return httpError{400, "title required"} }
+    if rel.Type == "" { rel.Type = "single" }
+    if !slices.Contains(releaseTypes, rel.Type) { return httpError{400, "type must be one of " + strings.Join(releaseTypes, ", ")} }
+    if rel.ReleaseDate != "" {
+        if _, err := time.Parse("2006-01-02", rel.ReleaseDate); err != nil { return httpError{400, "release_date must be YYYY-MM-DD"} }
+    }
+    if rel.UPC != "" && (len(rel.UPC) != 12 && len(rel.UPC) != 13 || strings.Trim(rel.UPC, "0123456789") != "") {
+        return httpError{400, "upc must be a 12-digit UPC-A or a 13-digit EAN"}
+    }
+    if rel.UPC != "" && !validGTIN(rel.UPC) { return httpError{400, "upc " + rel.UPC + " has the wrong check digit"} }
+    if in.UPC != nil && rel.UPC != "" {
+        for _, other := range s.releaseUPCs()[ean(rel.UPC)] {
+            if other.ID != rel.ID { return httpError{409, "upc " + rel.UPC + " is already " + other.Title + "'s"} }
+        }
+    }
+    if rel.Artwork != "" && !strings.HasPrefix(strings.ToLower(rel.Artwork), strings.ToLower(s.dropboxRoot)+"/") {
+        return httpError{400, "artwork must be under " + s.dropboxRoot}
+    }
+    if in.Artwork != nil && rel.Artwork != "" {
+        e, err := s.dbxMetadata(ctx, rel.Artwork)
+        if isNotFound(err) { return httpError{404, "artwork not found: " + rel.Artwork} }
+        if err != nil { return httpError{502, err.Error()} }
+        rel.Artwork = e.PathDisplay
+    }
+    seen := map[string]bool{}
+    s.mu.RLock(); defer s.mu.RUnlock()
+    for _, rt := range rel.Tracks {
+        if s.tracks[rt.Track] == nil { return httpError{404, "track not found: " + rt.Track} }
+        if (rt.T1 == "") != (rt.T2 == "") { return httpError{400, rt.Track + ": give both t1 and t2 or neither"} }
+        if seen[rt.Track] { return httpError{400, rt.Track + " is listed twice"} }
+        if rt.Gap != nil && (*rt.Gap < 0 || *rt.Gap > 60) { return httpError{400, rt.Track + ": gap must be 0 to 60 seconds"} }
+        if rt.Gap != nil && *rt.Gap < cdDefaultGap && len(seen) == 0 { return httpError{400, rt.Track + ": the first track's gap is at least 2 seconds on CD"} }
+        seen[rt.Track] = true
+    }
+    return nil
+}
+
+// mayAccessRelease reports whether r may see every track rel lists; a
+// release with one it may not is hidden whole, as its exports would carry it.
+func (s *Server) mayAccessRelease(r *http.Request, rel *Release) bool {
+    return !slices.ContainsFunc(rel.Tracks, func(rt ReleaseTrack) bool { return !s.mayAccess(r, rt.Track) })
+}
+
+// GET  /api/releases
+// POST /api/releases {"title":"...","type":"ep","release_date":"2025-03-01","artwork":"/Tracks/...","tracks":[{"track":"ENERGY"}]}
+func (s *Server) handleReleases(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        var list []*Release
+        s.store.view(func(d *storeData) {
+            for _, rel := range d.Releases { c := *rel; list = append(list, &c) }
+        })
+        list = slices.DeleteFunc(list, func(rel *Release) bool { return !s.mayAccessRelease(r, rel) })
+        sort.Slice(list, func(i, j int) bool {
+            if list[i].ReleaseDate != list[j].ReleaseDate { return list[i].ReleaseDate > list[j].ReleaseDate }
+            return list[i].Title < list[j].Title
+        })
+        out := []*ResolvedRelease{}
+        for _, rel := range list { out = append(out, s.resolveRelease(rel)) }
+        writeJSON(w, out)
+
+    case http.MethodPost:
+        var in releaseInput
+        if err := json.NewDecoder(r.Body).Decode(&in); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        now := time.Now().UTC()
+        rel := &Release{ID: newID(), Tracks: []ReleaseTrack{}, Created: now, Updated: now}
+        if err := s.applyRelease(r.Context(), rel, in); err != nil { writeError(w, err); return }
+        err := s.store.update(func(d *storeData) error {
+            if d.Releases == nil { d.Releases = map[string]*Release{} }
+            d.Releases[rel.ID] = rel
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "release-create", "", nil, rel)
+        writeJSONStatus(w, http.StatusCreated, s.resolveRelease(rel))
+
+    default:
+        http.Error(w, "GET or POST required", 405)
+    }
+}
+
+// GET    /api/releases/{id}
+// PATCH  /api/releases/{id} {any writable field}
+// DELETE /api/releases/{id}
+// GET    /api/releases/{id}/package   see delivery.go
+// GET    /api/releases/{id}/ddp       see ddp.go
+// GET    /api/releases/{id}/metadata  see ddex.go
+// POST   /api/releases/{id}/codes     see codes.go
+func (s *Server) handleRelease(w http.ResponseWriter, r *http.Request) {
+    id, sub, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/releases/"), "/"), "/")
+    if id == "" || sub != "" && sub != "package" && sub != "ddp" && sub != "metadata" && sub != "codes" { http.NotFound(w, r); return }
+    var cur *Release
+    s.store.view(func(d *storeData) {
+        if rel := d.Releases[id]; rel != nil { c := *rel; cur = &c }
+    })
+    if cur == nil || !s.mayAccessRelease(r, cur) { http.Error(w, "release not found", 404); return }
+    if sub == "package" { s.handlePackage(w, r, cur); return }
+    if sub == "ddp" { s.handleDDP(w, r, cur); return }
+    if sub == "metadata" { s.handleReleaseMetadata(w, r, cur); return }
+    if sub == "codes" { s.handleReleaseCodes(w, r, cur); return }
+
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, s.resolveRelease(cur))
+
+    case http.MethodPatch, http.MethodPut:
+        var in releaseInput
+        if err := json.NewDecoder(r.Body).Decode(&in); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        before := *cur
+        next := *cur
+        if err := s.applyRelease(r.Context(), &next, in); err != nil { writeError(w, err); return }
+        next.Updated = time.Now().UTC()
+        err := s.store.update(func(d *storeData) error {
+            if d.Releases[id] == nil { return httpError{404, "release not found"} }
+            d.Releases[id] = &next
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "release-update", "", before, next)
+        writeJSON(w, s.resolveRelease(&next))
+
+    case http.MethodDelete:
+        err := s.store.update(func(d *storeData) error {
+            delete(d.Releases, id)
+            for k, dl := range d.Deadlines {
+                if dl.Release == id { delete(d.Deadlines, k) }
+            }
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "release-delete", "", cur, nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "GET, PATCH or DELETE required", 405)
+    }
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "time"
+)
+
+// ====== Response Cache ======
+//
+// When a listening party opens the UI everyone asks for the catalog and the
+// same few tracks at once, and each answer used to be built and encoded
+// anew: every summary of the catalog, every file of a track. GET
+// /api/tracks and track details are kept as encoded once made, per query
+// and per audience (the caller's role; and who they are, while any track is
+// restricted), until the index or the state changes or the first lock in
+// the state expires. Requests arriving while an answer is being made wait
+// for it rather than make it again. The cache holds up to 32 MB, dropping
+// the answers least recently served; /api/metrics counts hits and misses.
+
+const responseCacheMax = 32 << 20 // bytes of answers kept
+
+// responseCache holds encoded answers made since the index and the state
+// last changed.
+type responseCache struct {
+    mu      sync.Mutex
+    index   uint64 // the index's generation they were made at
+    state   uint64 // the store's
+    until   time.Time // when the first lock expires; zero: none does
+    m       map[string]*cachedResponse // key: path, query and audience
+    size    int
+    pending map[string]chan struct{} // answers being made, closed once they are
+}
+
+type cachedResponse struct {
+    body []byte
+    used time.Time
+}
+
+func init() {
+    metricHelp["avcs_response_cache_total"] = "Answers of the catalog list and track details, by endpoint and result: hit (from the cache) or miss."
+}
+
+// cachedJSON serves r from the cache, or serves what render writes and
+// keeps it when it is a 200.
+func (s *Server) cachedJSON(w http.ResponseWriter, r *http.Request, endpoint string, render func(w http.ResponseWriter)) {
+    c := &s.responses
+    key := r.URL.Path + "?" + r.URL.Query().Encode() + "\x00" + s.audience(r)
+    s.mu.RLock(); index := s.indexGen; s.mu.RUnlock()
+    state := s.store.generation()
+    for {
+        c.mu.Lock()
+        if c.index != index || c.state != state || !c.until.IsZero() && time.Now().After(c.until) || c.m == nil {
+            c.index, c.state, c.until, c.m, c.size = index, state, s.firstLockExpiry(), map[string]*cachedResponse{}, 0
+        }
+        if e := c.m[key]; e != nil {
+            e.used = time.Now()
+            c.mu.Unlock()
+            s.metrics.add("avcs_response_cache_total", 1, "endpoint", endpoint, "result", "hit")
+            w.Header().Set("Content-Type", "application/json")
+            w.Header().Set("Cache-Control", "no-store")
+            w.Write(e.body)
+            return
+        }
+        wait := c.pending[key]
+        if wait == nil { break }
+        c.mu.Unlock()
+        select {
+        case <-wait:
+        case <-r.Context().Done(): return
+        }
+    }
+    done := make(chan struct{})
+    if c.pending == nil { c.pending = map[string]chan struct{}{} }
+    c.pending[key] = done
+    c.mu.Unlock()
+    defer func() {
+        c.mu.Lock(); delete(c.pending, key); c.mu.Unlock()
+        close(done)
+    }()
+
+    s.metrics.add("avcs_response_cache_total", 1, "endpoint", endpoint, "result", "miss")
+    rec := httptest.NewRecorder()
+    render(rec)
+    for k, v := range rec.Header() { w.Header()[k] = v }
+    w.WriteHeader(rec.Code)
+    w.Write(rec.Body.Bytes())
+    if rec.Code != http.StatusOK { return }
+    c.mu.Lock(); defer c.mu.Unlock()
+    if c.index != index || c.state != state { return } // made from what has changed since
+    c.m[key] = &cachedResponse{body: rec.Body.Bytes(), used: time.Now()}
+    c.size += rec.Body.Len()
+    for c.size > responseCacheMax {
+        var oldest string
+        for k, e := range c.m {
+            if oldest == "" || e.used.Before(c.m[oldest].used) { oldest = k }
+        }
+        c.size -= len(c.m[oldest].body)
+        delete(c.m, oldest)
+    }
+}
+
+// audience is who r is as far as what it may see goes: its role, and while
+// any track is restricted to some, the caller.
+func (s *Server) audience(r *http.Request) string {
+    k := requestKey(r)
+    if k == nil { return "" }
+    restricted := false
+    s.store.view(func(d *storeData) { restricted = len(d.Restrictions) > 0 })
+    if !restricted || k.Role == roleAdmin { return k.Role }
+    return k.Role + "\x00" + principal(r)
+}
+
+// firstLockExpiry is when the first lock still held expires; zero if none.
+func (s *Server) firstLockExpiry() time.Time {
+    var first time.Time
+    now := time.Now()
+    s.store.view(func(d *storeData) {
+        for _, list := range d.Locks {
+            for _, l := range list {
+                if l.Expires.After(now) && (first.IsZero() || l.Expires.Before(first)) { first = l.Expires }
+            }
+        }
+    })
+    return first
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "slices"
+    "strings"
+    "time"
+)
+
+// ====== Track Restrictions ======
+//
+// A track can be restricted to named users and roles, for material under NDA
+// and the like. Anyone else finds it missing: it is left out of the track
+// list, search, the workflow board, tag counts, contributors, and the
+// releases and deadlines that name it, and its detail, links to its files,
+// A/B sessions on it and their streams all answer 404. Admins always have
+// access, and so does every request when there is no authentication; only
+// they may link files the index does not list. Branches share their parent's
+// restriction. Users are signed-in users' verified e-mail addresses, GitHub
+// logins or OIDC subjects, or "key:{id}" for an API key. Share links made
+// before a restriction keep working until they are revoked.
+
+// Restriction limits who may see a track.
+type Restriction struct {
+    Users []string  `json:"users,omitempty"`
+    Roles []string  `json:"roles,omitempty"`
+    Note  string    `json:"note,omitempty"` // why, e.g. "NDA until the announcement"
+    By    string    `json:"by"`
+    At    time.Time `json:"at"`
+}
+
+// restrictionFor is the restriction on a track or its parent; nil if none.
+func (s *Server) restrictionFor(name string) *Restriction {
+    parent, _, _ := strings.Cut(name, ".")
+    var out *Restriction
+    s.store.view(func(d *storeData) {
+        if rs := d.Restrictions[parent]; rs != nil { c := *rs; out = &c }
+    })
+    return out
+}
+
+// principal names r's caller as restrictions list users.
+func principal(r *http.Request) string {
+    if u, _ := r.Context().Value(ctxUser).(string); u != "" { return strings.ToLower(u) }
+    if k := requestKey(r); k != nil { return "key:" + k.ID }
+    return ""
+}
+
+// mayAccess reports whether r may see the named track.
+func (s *Server) mayAccess(r *http.Request, name string) bool {
+    rs := s.restrictionFor(name)
+    if rs == nil { return true }
+    k := requestKey(r)
+    if k == nil || k.Role == roleAdmin { return true }
+    return slices.Contains(rs.Roles, k.Role) || slices.Contains(rs.Users, principal(r))
+}
+
+// hiddenFrom is the set of indexed tracks r may not see.
+func (s *Server) hiddenFrom(r *http.Request) map[string]bool {
+    s.mu.RLock()
+    names := make([]string, 0, len(s.tracks))
+    for name := range s.tracks { names = append(names, name) }
+    s.mu.RUnlock()
+    hidden := map[string]bool{}
+    for _, name := range names {
+        if !s.mayAccess(r, name) { hidden[name] = true }
+    }
+    return hidden
+}
+
+// trackAt is the name of the top-level track whose folder holds p; "" if none.
+func (s *Server) trackAt(p string) string {
+    dir := s.trackDir(p)
+    s.mu.RLock(); defer s.mu.RUnlock()
+    for _, t := range s.tracks {
+        if t.Parent == "" && strings.EqualFold(t.Dir, dir) { return t.Name }
+    }
+    return ""
+}
+
+// indexedAt names the tracks whose index lists the file at p.
+func (s *Server) indexedAt(p string) []string {
+    var out []string
+    s.mu.RLock(); defer s.mu.RUnlock()
+    for name, t := range s.tracks {
+        refs := append(slices.Clone(t.Artwork), t.References...)
+        for _, i := range t.Ideas { refs = append(refs, i.FileRef) }
+        for _, c := range t.Conflicts { refs = append(refs, c.Copy) }
+        for _, f := range trackFiles(t) { refs = append(refs, f.FileRef) }
+        if slices.ContainsFunc(refs, func(f FileRef) bool { return strings.EqualFold(f.Path, p) }) { out = append(out, name) }
+    }
+    return out
+}
+
+// abTracks are the tracks an A/B session compares.
+func abTracks(ab *ABSession) []string {
+    out := make([]string, 0, len(ab.Sides))
+    for _, sd := range ab.Sides { out = append(out, sd.Track) }
+    return out
+}
+
+// checkAccess refuses r if it fetches a file of a track r may not see.
+func (s *Server) checkAccess(r *http.Request) error {
+    p := r.URL.Path
+    var tracks []string
+    switch {
+    case p == "/api/link":
+        // A file the index does not list could be anyone's, misfiled.
+        fp := r.URL.Query().Get("path")
+        tracks = s.indexedAt(fp)
+        if k := requestKey(r); len(tracks) == 0 && k != nil && k.Role != roleAdmin { return httpError{404, "not found"} }
+        tracks = append(tracks, s.trackAt(fp))
+    case strings.HasPrefix(p, "/api/ab/"):
+        id, _, _ := strings.Cut(strings.Trim(strings.TrimPrefix(p, "/api/ab/"), "/"), "/")
+        if ab := s.abSession(id); ab != nil { tracks = abTracks(ab) }
+    }
+    for _, t := range tracks {
+        if t != "" && !s.mayAccess(r, t) { return httpError{404, "not found"} }
+    }
+    return nil
+}
+
+// GET    /api/tracks/{name}/restriction
+// PUT    /api/tracks/{name}/restriction {"users":["ana@example.com"],"roles":["mastering"],"note":"NDA"}
+// DELETE /api/tracks/{name}/restriction
+func (s *Server) handleRestriction(w http.ResponseWriter, r *http.Request, t *Track) {
+    if t.Parent != "" { http.Error(w, "branches share "+t.Parent+"'s restriction", 400); return }
+    before := s.restrictionFor(t.Name)
+    switch r.Method {
+    case http.MethodGet:
+        if before == nil { http.Error(w, t.Name+" is not restricted", 404); return }
+        writeJSON(w, before)
+
+    case http.MethodPut:
+        var req struct {
+            Users []string `json:"users"`
+            Roles []string `json:"roles"`
+            Note  string   `json:"note"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        rs := &Restriction{Note: strings.TrimSpace(req.Note), By: actorOf(r), At: time.Now().UTC()}
+        for _, u := range req.Users {
+            if u = strings.ToLower(strings.TrimSpace(u)); u != "" && !slices.Contains(rs.Users, u) { rs.Users = append(rs.Users, u) }
+        }
+        for _, role := range req.Roles {
+            role = strings.ToLower(strings.TrimSpace(role))
+            if !slices.Contains(roleNames, role) { http.Error(w, "roles must be among "+strings.Join(roleNames, ", "), 400); return }
+            if !slices.Contains(rs.Roles, role) { rs.Roles = append(rs.Roles, role) }
+        }
+        err := s.store.update(func(d *storeData) error {
+            if d.Restrictions == nil { d.Restrictions = map[string]*Restriction{} }
+            d.Restrictions[t.Name] = rs
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "restrict", t.Name, before, rs)
+        writeJSON(w, rs)
+
+    case http.MethodDelete:
+        if before == nil { http.Error(w, t.Name+" is not restricted", 404); return }
+        if err := s.store.update(func(d *storeData) error { delete(d.Restrictions, t.Name); return nil }); err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "unrestrict", t.Name, before, nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "GET, PUT or DELETE required", 405)
+    }
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "path"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ====== Retention ======
+
+// archiveDir holds superseded files inside a track folder; the indexer skips it.
+const archiveDir = "_archive"
+
+// RetentionRule keeps the newest KeepLast files of a group and marks older
+// ones past OlderThanDays for archiving. Groups are a master set's non-FINAL
+// candidates ("master") or a snapshot's Live backups ("backup").
+type RetentionRule struct {
+    Kind          string `json:"kind"`
+    KeepLast      int    `json:"keep_last"`
+    OlderThanDays int    `json:"older_than_days"`
+}
+
+func (r RetentionRule) String() string {
+    return fmt.Sprintf("keep last %d %s files, archive older than %d days", r.KeepLast, r.Kind, r.OlderThanDays)
+}
+
+// PendingArchive is a file a rule would move to the archive.
+type PendingArchive struct {
+    Track    string      `json:"track"`
+    Artifact ArtifactRef `json:"artifact"`
+    File     FileRef     `json:"file"`
+    Rule     string      `json:"rule"`
+    Archive  string      `json:"archive"` // destination path
+}
+
+func (s *Server) retentionRules() []RetentionRule {
+    var out []RetentionRule
+    s.store.view(func(d *storeData) { out = append(out, d.Retention...) })
+    return out
+}
+
+// archivePath mirrors p's place in the track folder under <dir>/_archive.
+func archivePath(t *Track, p string) string {
+    dir := t.Dir
+    if dir == "" { dir = path.Dir(p) }
+    rel := strings.TrimPrefix(p[min(len(dir), len(p)):], "/")
+    return path.Join(dir, archiveDir, rel)
+}
+
+// retentionReport lists what the rules would archive now. Candidates a FINAL
+// duplicates and tracks checked out as a whole are never touched.
+func (s *Server) retentionReport(now time.Time) []PendingArchive {
+    rules := s.retentionRules()
+    out := []PendingArchive{}
+    if len(rules) == 0 { return out }
+    s.mu.RLock()
+    tracks := make([]*Track, 0, len(s.tracks))
+    for _, t := range s.tracks { tracks = append(tracks, t) }
+    s.mu.RUnlock()
+    sort.Slice(tracks, func(i, j int) bool { return tracks[i].Name < tracks[j].Name })
+
+    for _, t := range tracks {
+        locked := false
+        for _, l := range s.locksFor(t.Name) { locked = locked || l.T1 == "" }
+        if locked { continue }
+        pending := map[string]bool{}
+        consider := func(rule RetentionRule, ref func(f FileRef) ArtifactRef, files []FileRef) {
+            files = slices.DeleteFunc(files, func(f FileRef) bool { return f.Archived })
+            sort.SliceStable(files, func(i, j int) bool { return files[i].ServerModified.After(files[j].ServerModified) })
+            cutoff := now.AddDate(0, 0, -rule.OlderThanDays)
+            for i, f := range files {
+                if i < rule.KeepLast || !f.ServerModified.Before(cutoff) || pending[f.Path] { continue }
+                pending[f.Path] = true
+                out = append(out, PendingArchive{Track: t.Name, Artifact: ref(f), File: f, Rule: rule.String(), Archive: archivePath(t, f.Path)})
+            }
+        }
+        for _, rule := range rules {
+            switch rule.Kind {
+            case kindMaster:
+                for _, ms := range t.Masters {
+                    var files []FileRef
+                    for _, c := range ms.Candidates {
+                        if ms.Final != nil && c.ContentHash != "" && c.ContentHash == ms.Final.ContentHash { continue }
+                        files = append(files, c)
+                    }
+                    consider(rule, func(f FileRef) ArtifactRef {
+                        return ArtifactRef{Kind: kindMaster, T1: ms.T1, T2: ms.T2, Idx: candidateIdx(f)}
+                    }, files)
+                }
+            case kindBackup:
+                for _, a := range t.Ableton {
+                    var files []FileRef
+                    for _, b := range a.Backups { files = append(files, b.FileRef) }
+                    consider(rule, func(FileRef) ArtifactRef { return ArtifactRef{Kind: kindSnapshot, T1: a.T1} }, files)
+                }
+            }
+        }
+    }
+    return out
+}
+
+// GET /api/retention/rules
+// PUT /api/retention/rules [{"kind":"master","keep_last":3,"older_than_days":90}]
+func (s *Server) handleRetentionRules(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, append([]RetentionRule{}, s.retentionRules()...))
+
+    case http.MethodPut:
+        var rules []RetentionRule
+        if err := json.NewDecoder(r.Body).Decode(&rules); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        for _, rule := range rules {
+            if rule.Kind != kindMaster && rule.Kind != kindBackup { http.Error(w, "kind must be master or backup", 400); return }
+            if rule.KeepLast < 0 || rule.OlderThanDays < 0 { http.Error(w, "keep_last and older_than_days must not be negative", 400); return }
+        }
+        before := s.retentionRules()
+        err := s.store.update(func(d *storeData) error { d.Retention = rules; return nil })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "retention-rules", "", before, rules)
+        writeJSON(w, append([]RetentionRule{}, rules...))
+
+    default:
+        http.Error(w, "GET or PUT required", 405)
+    }
+}
+
+// GET /api/retention/report
+func (s *Server) handleRetentionReport(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    pending := s.retentionReport(time.Now())
+    var total int64
+    for _, p := range pending { total += p.File.Size }
+    writeJSON(w, map[string]any{"pending": pending, "files": len(pending), "bytes": total})
+}
+
+// POST /api/retention/apply {"paths":["/Tracks/..."]} or {"all":true}
+// Only files still in the current report are moved, so an approval of a
+// stale report cannot archive something that has since become FINAL.
+func (s *Server) handleRetentionApply(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    var req struct {
+        Paths []string `json:"paths"`
+        All   bool     `json:"all"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+    if !req.All && len(req.Paths) == 0 { http.Error(w, "paths or all required", 400); return }
+    approved := map[string]bool{}
+    for _, p := range req.Paths { approved[strings.ToLower(p)] = true }
+
+    type result struct {
+        Path    string `json:"path"`
+        Archive string `json:"archive,omitempty"`
+        Error   string `json:"error,omitempty"`
+    }
+    moved, failed := []result{}, []result{}
+    skipped := len(approved)
+    s.writeMu.Lock()
+    for _, p := range s.retentionReport(time.Now()) {
+        if !req.All && !approved[strings.ToLower(p.File.Path)] { continue }
+        if !req.All { skipped-- }
+        if _, err := s.dbxMove(r.Context(), p.File.Path, p.Archive); err != nil {
+            failed = append(failed, result{Path: p.File.Path, Error: err.Error()})
+            continue
+        }
+        moved = append(moved, result{Path: p.File.Path, Archive: p.Archive})
+    }
+    s.writeMu.Unlock()
+    if len(moved) > 0 {
+        s.audit(r, "retention-archive", "", nil, moved)
+        if err := s.reindex(r.Context()); err != nil { http.Error(w, "archived, but reindex failed: "+err.Error(), 500); return }
+    }
+    writeJSON(w, map[string]any{"moved": moved, "failed": failed, "not_pending": skipped})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+    "cmp"
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "path"
+    "slices"
+    "strings"
+)
+
+// ====== Roles ======
+//
+// Scopes say what kind of request a key may make; a role says what its holder
+// may get at and do within them:
+//
+//   viewer        browse the catalog, hear mixes and released masters
+//   producer      also fetch stems, hear unreleased masters, promote FINALs and make changes
+//   mix-engineer  fetch stems and make changes
+//   mastering     hear unreleased masters, promote FINALs and make changes
+//   admin         everything
+//
+// A track's masters are released once it reaches the last workflow stage.
+// Signed-in users have the role set for them under /api/roles, or else admin
+// for OIDC_ADMINS and DEFAULT_ROLE (producer) for everyone else. Keys have
+// the role they were given; keys with the admin scope are admin, and keys
+// without a role producers. Without any authentication there are no roles
+// and nothing is held back.
+
+const (
+    roleViewer      = "viewer"
+    roleProducer    = "producer"
+    roleMixEngineer = "mix-engineer"
+    roleMastering   = "mastering"
+    roleAdmin       = "admin"
+)
+
+var roleNames = []string{roleViewer, roleProducer, roleMixEngineer, roleMastering, roleAdmin}
+
+// Permissions a role may hold.
+const (
+    permStems   = "stems"   // links to stems and session bundles
+    permMasters = "masters" // masters of unreleased tracks
+    permPromote = "promote"
+    permWrite   = "write"
+)
+
+var rolePerms = map[string][]string{
+    roleViewer:      {},
+    roleProducer:    {permStems, permMasters, permPromote, permWrite},
+    roleMixEngineer: {permStems, permWrite},
+    roleMastering:   {permMasters, permPromote, permWrite},
+    roleAdmin:       {permStems, permMasters, permPromote, permWrite},
+}
+
+// permWhat describes a permission for refusals: "the viewer role may not ...".
+var permWhat = map[string]string{
+    permStems:   "fetch stems or session bundles",
+    permMasters: "hear masters of unreleased tracks",
+    permPromote: "promote FINALs",
+    permWrite:   "make changes",
+}
+
+func roleCan(role, perm string) bool { return slices.Contains(rolePerms[role], perm) }
+
+// roleScopes are the scopes a signed-in user with role has.
+func roleScopes(role string) []string {
+    switch {
+    case role == roleAdmin: return []string{scopeAdmin}
+    case roleCan(role, permWrite): return []string{scopeRead, scopeLink, scopeWrite}
+    }
+    return []string{scopeRead, scopeLink}
+}
+
+// keyRole is the role a stored key acts with.
+func keyRole(k *APIKey) string {
+    if k.has(scopeAdmin) { return roleAdmin }
+    return cmp.Or(k.Role, roleProducer)
+}
+
+// sessionRole is the role a signed-in user acts with.
+func (s *Server) sessionRole(sess *Session) string {
+    var role string
+    s.store.view(func(d *storeData) { role = d.Roles[sess.User] })
+    if role != "" { return role }
+    if sess.Admin { return roleAdmin }
+    return s.defaultRole
+}
+
+// can reports whether r's role holds perm; requests made without
+// authentication can do anything.
+func can(r *http.Request, perm string) bool {
+    k := requestKey(r)
+    return k == nil || roleCan(k.Role, perm)
+}
+
+// released reports whether t's masters may be heard by every role.
+func (s *Server) released(t *Track) bool {
+    return s.statusOf(t.Name).Status == s.flow.Stages[len(s.flow.Stages)-1]
+}
+
+// filePerms is what fetching the file at p needs. Its kind is the one its
+// name gives, as the indexer reads it; the folder it is in only speaks for
+// names that give none.
+func (s *Server) filePerms(p string) []string {
+    kind := folderKind(p)
+    if np, ok := classifyName(path.Base(p)); ok { kind = np.Kind }
+    switch kind {
+    case kindStem:
+        return []string{permStems}
+    case kindMaster:
+        dir := s.trackDir(p)
+        s.mu.RLock(); defer s.mu.RUnlock()
+        for _, t := range s.tracks {
+            if t.Parent == "" && strings.EqualFold(t.Dir, dir) && s.released(t) { return nil }
+        }
+        return []string{permMasters}
+    }
+    return nil
+}
+
+// permsFor is what r needs of its role, beyond the scope it needs.
+func (s *Server) permsFor(r *http.Request) []string {
+    p := r.URL.Path
+    var need []string
+    if r.Method != http.MethodGet && r.Method != http.MethodHead { need = append(need, permWrite) }
+    switch {
+    case p == "/api/link":
+        need = append(need, s.filePerms(r.URL.Query().Get("path"))...)
+
+    case strings.HasPrefix(p, "/api/ab/"):
+        // /api/ab/{id}/stream/{label}
+        parts := strings.Split(strings.Trim(strings.TrimPrefix(p, "/api/ab/"), "/"), "/")
+        if len(parts) != 3 || parts[1] != "stream" { break }
+        if ab := s.abSession(parts[0]); ab != nil {
+            for _, sd := range ab.Sides {
+                if strings.EqualFold(sd.Label, parts[2]) { need = append(need, s.filePerms(sd.File.Path)...) }
+            }
+        }
+
+    case strings.HasPrefix(p, "/api/tracks/"):
+        parts := strings.Split(strings.Trim(strings.TrimPrefix(p, "/api/tracks/"), "/"), "/")
+        t := s.lookupTrack(parts[0])
+        switch {
+        case t == nil:
+        case len(parts) == 5 && parts[1] == "masters" && parts[4] == "promote":
+            need = append(need, permPromote)
+        case len(parts) == 5 && parts[1] == "masters" && parts[4] == "candidates" && !s.released(t):
+            need = append(need, permMasters)
+        case len(parts) == 4 && (parts[1] == "ableton" || parts[1] == "stems") && parts[3] == "bundle":
+            need = append(need, permStems)
+        }
+    }
+    return need
+}
+
+// checkRole refuses r if role lacks something it needs.
+func (s *Server) checkRole(r *http.Request, role string) error {
+    for _, perm := range s.permsFor(r) {
+        if !roleCan(role, perm) { return httpError{403, "the " + role + " role may not " + permWhat[perm]} }
+    }
+    return nil
+}
+
+// GET    /api/roles                   the roles, the default and who has which
+// PUT    /api/roles/{user} {"role":"mastering"}
+// DELETE /api/roles/{user}            back to the default
+// user is a signed-in user's e-mail address, or GitHub login.
+func (s *Server) handleRoles(w http.ResponseWriter, r *http.Request) {
+    user, _ := url.PathUnescape(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/roles"), "/"))
+    user = strings.ToLower(strings.TrimSpace(user))
+    if user == "" {
+        if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+        users := map[string]string{}
+        s.store.view(func(d *storeData) {
+            for u, role := range d.Roles { users[u] = role }
+        })
+        writeJSON(w, map[string]any{"roles": rolePerms, "default": s.defaultRole, "users": users})
+        return
+    }
+
+    var before string
+    s.store.view(func(d *storeData) { before = d.Roles[user] })
+    switch r.Method {
+    case http.MethodPut:
+        var req struct {
+            Role string `json:"role"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        role := strings.ToLower(strings.TrimSpace(req.Role))
+        if !slices.Contains(roleNames, role) { http.Error(w, "role must be one of "+strings.Join(roleNames, ", "), 400); return }
+        err := s.store.update(func(d *storeData) error {
+            if d.Roles == nil { d.Roles = map[string]string{} }
+            d.Roles[user] = role
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "role-set", "", map[string]string{"user": user, "role": before}, map[string]string{"user": user, "role": role})
+        writeJSON(w, map[string]string{"user": user, "role": role})
+
+    case http.MethodDelete:
+        if before == "" { http.Error(w, user+" has the default role", 404); return }
+        if err := s.store.update(func(d *storeData) error { delete(d.Roles, user); return nil }); err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "role-unset", "", map[string]string{"user": user, "role": before}, nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "PUT or DELETE required", 405)
+    }
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "archive/zip"
+    "cmp"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "path"
+    "strings"
+    "sync"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Sample Dependency Manifests ======
+
+type (
+    SampleDep   = client.SampleDep
+    DepManifest = client.DepManifest
+)
+
+// depManifest resolves every sample of a snapshot's .als relative to the folder
+// the set lives in, which is where Live's "Collect All and Save" puts them.
+// Paths leading out of the root, or into a track r may not see, are refused
+// and counted missing: the set is not trusted to name only its own files.
+func (s *Server) depManifest(r *http.Request, t *Track, snap *AbletonSnap) (*DepManifest, error) {
+    ctx := r.Context()
+    info, err := s.alsInfo(ctx, snap.ALS)
+    if err != nil { return nil, err }
+    m := &DepManifest{Track: t.Name, T1: snap.T1, Set: *snap.ALS, Generated: time.Now().UTC()}
+    if e, err := s.dbxMetadata(ctx, snap.ALS.Path); err == nil { m.SetHash = e.ContentHash }
+
+    projectDir := path.Dir(snap.ALS.Path)
+    m.Samples = make([]SampleDep, len(info.Samples))
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, 8)
+    for i, smp := range info.Samples {
+        dep := &m.Samples[i]
+        dep.Ref, dep.Size = smp.RelativePath, smp.Size
+        if smp.RelativePath == "" {
+            // only an absolute path on someone's machine: not in Dropbox
+            dep.Ref, dep.External, dep.Missing = smp.Path, true, true
+            continue
+        }
+        dep.External = strings.HasPrefix(path.Clean(smp.RelativePath), "../")
+        p := path.Join(projectDir, smp.RelativePath)
+        if !s.underRoot(p) && !s.inArchive(p) || !s.mayAccess(r, cmp.Or(s.trackAt(p), t.Name)) {
+            dep.Refused, dep.Missing = true, true
+            continue
+        }
+        wg.Add(1)
+        go func(p string) {
+            defer wg.Done()
+            sem <- struct{}{}; defer func() { <-sem }()
+            e, err := s.dbxMetadata(ctx, p)
+            if err != nil {
+                if !isNotFound(err) { slog.WarnContext(ctx, "sample metadata failed", "path", p, "error", err) }
+                dep.Missing = true
+                return
+            }
+            dep.Path, dep.Size, dep.ContentHash = e.PathDisplay, e.Size, e.ContentHash
+        }(p)
+    }
+    wg.Wait()
+    if err := ctx.Err(); err != nil { return nil, err }
+
+    m.TotalSize = snap.ALS.Size
+    for _, d := range m.Samples {
+        if d.Missing { m.Missing++; continue }
+        m.TotalSize += d.Size
+    }
+    return m, nil
+}
+
+func (s *Server) handleDepManifest(w http.ResponseWriter, r *http.Request, t *Track, t1 string) {
+    snap := findSnap(t, t1)
+    if snap == nil { http.Error(w, "snapshot not found", 404); return }
+    if snap.ALS == nil { http.Error(w, "snapshot has no .als", 404); return }
+    m, err := s.depManifest(r, t, snap)
+    if err != nil { http.Error(w, err.Error(), 502); return }
+    writeJSON(w, m)
+}
+
+// handleBundle streams the set plus all samples as a zip laid out like the
+// project folder. Hashes are re-computed while streaming and recorded in the
+// bundled manifest.json, so the archive can be checked without the server.
+func (s *Server) handleBundle(w http.ResponseWriter, r *http.Request, t *Track, t1 string) {
+    snap := findSnap(t, t1)
+    if snap == nil { http.Error(w, "snapshot not found", 404); return }
+    if snap.ALS == nil { http.Error(w, "snapshot has no .als", 404); return }
+    m, err := s.depManifest(r, t, snap)
+    if err != nil { http.Error(w, err.Error(), 502); return }
+    if m.Missing > 0 && r.URL.Query().Get("allow_missing") == "" {
+        writeJSONStatus(w, http.StatusConflict, m)
+        return
+    }
+
+    s.logAccess(r, AccessEvent{Kind: accessBundle, Track: t.Name, File: snap.ALS.Path})
+    root := strings.TrimSuffix(snap.ALS.Name, ".als") + " Project/"
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", `attachment; filename="`+strings.TrimSuffix(snap.ALS.Name, ".als")+`.zip"`)
+    zw := zip.NewWriter(w)
+    members := []bundleMember{{path: snap.ALS.Path, name: root + snap.ALS.Name, modified: snap.ALS.ServerModified}}
+    var deps []*SampleDep
+    taken := map[string]bool{strings.ToLower(snap.ALS.Name): true, "manifest.json": true}
+    for i := range m.Samples {
+        d := &m.Samples[i]
+        if d.Missing { continue }
+        name := path.Clean(d.Ref)
+        if d.External { name = "External/" + path.Base(name) }
+        // External samples from different folders may share a base name.
+        base, ext := strings.TrimSuffix(name, path.Ext(name)), path.Ext(name)
+        for n := 2; taken[strings.ToLower(name)]; n++ { name = fmt.Sprintf("%s-%d%s", base, n, ext) }
+        taken[strings.ToLower(name)] = true
+        d.Bundled = name
+        members = append(members, bundleMember{path: d.Path, name: root + name, modified: time.Now()})
+        deps = append(deps, d)
+    }
+    sums, err := s.writeBundle(r.Context(), zw, members)
+    // Cut short, the zip lacks its directory, so that it does not pass for whole.
+    if err != nil { slog.ErrorContext(r.Context(), "bundle download failed", "set", snap.ALS.Path, "error", err); return }
+    if m.SetHash != "" && sums[0] != m.SetHash { slog.WarnContext(r.Context(), "bundle content hash changed during download", "path", snap.ALS.Path) }
+    m.SetHash = sums[0]
+    for i, d := range deps {
+        if d.ContentHash != "" && sums[i+1] != d.ContentHash { slog.WarnContext(r.Context(), "bundle content hash changed during download", "path", d.Path) }
+        d.ContentHash = sums[i+1]
+    }
+    f, err := zw.Create(root + "manifest.json")
+    if err != nil { return }
+    enc := json.NewEncoder(f)
+    enc.SetIndent("", "  ")
+    enc.Encode(m)
+    zw.Close()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "path"
+    "slices"
+    "strings"
+)
+
+// ====== Track Scaffolding ======
+//
+// New songs start as the canonical layout: a track folder with ableton/, any
+// other subfolders asked for, and the track's manifest sidecar, which lets the
+// indexer list the track before its first file lands.
+
+// scaffoldFolders are the optional subfolders of the canonical layout.
+var scaffoldFolders = []string{"stems", "post", "mixes", "masters", "manifests", "artwork"}
+
+// sidecarTrack is the track a <TRACK>.avcs.json directly in a track folder names.
+func (s *Server) sidecarTrack(e *dbxEntry) string {
+    name, ok := strings.CutSuffix(path.Base(e.PathDisplay), ".avcs.json")
+    if !ok || e.Tag != "file" || !rxTrackOrBranch.MatchString(name) || path.Dir(e.PathDisplay) != s.trackDir(e.PathDisplay) { return "" }
+    return name
+}
+
+// POST /api/tracks {"name":"NEW_SONG","folders":["stems","masters"]}
+// Creates <root>/NEW_SONG/ableton, the listed folders and NEW_SONG.avcs.json.
+func (s *Server) handleCreateTrack(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Name    string   `json:"name"`
+        Folders []string `json:"folders"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+    req.Name = strings.ToUpper(rxSpaces.ReplaceAllString(strings.TrimSpace(req.Name), "_"))
+    if !rxTrackName.MatchString(req.Name) { http.Error(w, "name must be [A-Z0-9_]+; branches are created by their files", 400); return }
+    folders := []string{"ableton"}
+    for _, f := range req.Folders {
+        f = strings.ToLower(strings.Trim(f, "/ "))
+        if !slices.Contains(scaffoldFolders, f) { http.Error(w, fmt.Sprintf("folders may be %s", strings.Join(scaffoldFolders, ", ")), 400); return }
+        if !slices.Contains(folders, f) { folders = append(folders, f) }
+    }
+
+    ctx := r.Context()
+    t := &Track{Name: req.Name, Dir: path.Join(s.dropboxRoot, req.Name)}
+    s.writeMu.Lock()
+    made := false // the track folder is ours to remove if a later step fails
+    err := func() error {
+        if existing := s.lookupTrack(req.Name); existing != nil { return httpError{409, req.Name + " already exists in " + existing.Dir} }
+        if err := s.checkUploadTarget(ctx, t.Dir, false); err != nil { return err }
+        made = true
+        for _, f := range folders {
+            if _, err := s.dbxRPC(ctx, "/2/files/create_folder_v2", map[string]any{"path": path.Join(t.Dir, f), "autorename": false}); err != nil {
+                return httpError{502, "create " + f + ": " + err.Error()}
+            }
+        }
+        b, err := s.signManifest(buildManifest(t))
+        if err == nil { _, err = s.dbxUpload(ctx, manifestPath(t), bytes.NewReader(b)) }
+        if err != nil { return httpError{502, "write manifest: " + err.Error()} }
+        return nil
+    }()
+    if err != nil && made { s.dbxRPC(ctx, "/2/files/delete_v2", map[string]string{"path": t.Dir}) }
+    s.writeMu.Unlock()
+    if err != nil { writeError(w, err); return }
+
+    created := map[string]any{"track": t.Name, "dir": t.Dir, "folders": folders, "manifest": manifestPath(t)}
+    s.audit(r, "create-track", t.Name, nil, created)
+    if err := s.reindex(ctx); err != nil { http.Error(w, "created, but reindex failed: "+err.Error(), 500); return }
+    writeJSONStatus(w, http.StatusCreated, created)
+}
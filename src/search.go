@@ -0,0 +1,94 @@
+package main
+
+import (
+    "net/http"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+
+    "avcs-browser/client"
+)
+
+// ====== Full-Text Search ======
+//
+// GET /api/search?q=neon+lights finds the lines, in every track the caller
+// may see, holding all of q's words in any case: in each revision of its
+// lyrics, and in the notes of its track.yaml. ?current=1 keeps to the
+// current lyrics; ?in=lyrics or ?in=notes to one kind; ?archived=1 searches
+// archived tracks and revisions too; ?limit= (100) caps the hits. Names are
+// searched by GET /api/tracks?q=. Lyrics not yet read are downloaded, so the
+// first search after a reindex may be slow.
+
+var searchKinds = []string{"lyrics", "notes"}
+
+type SearchHit = client.SearchHit
+
+// matchLines adds a hit for each line of text holding every word.
+func matchLines(hits []SearchHit, hit SearchHit, text string, words []string) []SearchHit {
+    for i, line := range strings.Split(text, "\n") {
+        lower := strings.ToLower(line)
+        if !slices.ContainsFunc(words, func(w string) bool { return !strings.Contains(lower, w) }) {
+            hit.Line, hit.Text = i+1, strings.TrimSpace(line)
+            hits = append(hits, hit)
+        }
+    }
+    return hits
+}
+
+// GET /api/search?q=[&in=][&current=1][&archived=1][&limit=]
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    q := r.URL.Query()
+    words := strings.Fields(strings.ToLower(q.Get("q")))
+    if len(words) == 0 { http.Error(w, "q required", 400); return }
+    in := strings.ToLower(q.Get("in"))
+    if in != "" && !slices.Contains(searchKinds, in) { http.Error(w, "in must be one of "+strings.Join(searchKinds, ", "), 400); return }
+    limit := 100
+    if v := q.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 || n > 1000 { http.Error(w, "limit must be 1 to 1000", 400); return }
+        limit = n
+    }
+    currentOnly, withArchived := q.Get("current") != "", q.Get("archived") != ""
+
+    // Lyrics may need downloading; gather them, then read outside the lock.
+    type revision struct {
+        hit SearchHit
+        ref FileRef
+    }
+    var revs []revision
+    hits := []SearchHit{}
+    s.mu.RLock()
+    for name, t := range s.tracks {
+        if t.Archived && !withArchived || !s.mayAccess(r, name) { continue }
+        if (in == "" || in == "notes") && t.Metadata != nil && t.Metadata.Notes != "" {
+            hits = matchLines(hits, SearchHit{Track: name, In: "notes"}, t.Metadata.Notes, words)
+        }
+        if in != "" && in != "lyrics" { continue }
+        shown := t.Lyrics
+        if !t.Archived && !withArchived { shown = slices.DeleteFunc(slices.Clone(shown), func(l Lyric) bool { return l.Archived }) }
+        var parent []Lyric
+        if p := s.tracks[t.Parent]; p != nil { parent = p.Lyrics }
+        for i, l := range shown {
+            current := i == len(shown)-1
+            if currentOnly && !current || slices.ContainsFunc(parent, func(p Lyric) bool { return p.Path == l.Path }) { continue } // found with the parent
+            revs = append(revs, revision{SearchHit{Track: name, In: "lyrics", Revision: i + 1, Current: current, File: l.Name}, l.FileRef})
+        }
+    }
+    s.mu.RUnlock()
+    for _, rv := range revs {
+        text, err := s.lyricsText(r.Context(), &rv.ref)
+        if err != nil { http.Error(w, err.Error(), 502); return }
+        hits = matchLines(hits, rv.hit, text, words)
+    }
+
+    sort.Slice(hits, func(i, j int) bool {
+        a, b := hits[i], hits[j]
+        if a.Track != b.Track { return a.Track < b.Track }
+        if a.In != b.In { return a.In < b.In }
+        if a.Revision != b.Revision { return a.Revision > b.Revision } // newest first
+        return a.Line < b.Line
+    })
+    writeJSON(w, map[string]any{"q": q.Get("q"), "total": len(hits), "hits": hits[:min(limit, len(hits))]})
+}
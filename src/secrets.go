@@ -0,0 +1,227 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ====== Secrets ======
+//
+// Secrets can come from the environment, from files or from HashiCorp Vault.
+// Each may be set as NAME (the value itself), NAME_FILE (a path, such as a
+// Docker or Kubernetes secret mounted under /run/secrets) or NAME_VAULT
+// ("path#field" of a KV secret at VAULT_ADDR, read with VAULT_TOKEN or the
+// token in VAULT_TOKEN_FILE; both KV versions work, so "secret/data/avcs#token"
+// for version 2).
+//
+// The Dropbox credentials are read again every SECRETS_REFRESH (default 1m),
+// and at once after Dropbox refuses a token, so a rotation takes effect
+// without a restart. They are DROPBOX_TOKEN, a fixed access token, or
+// DROPBOX_REFRESH_TOKEN with DROPBOX_APP_KEY (and DROPBOX_APP_SECRET unless
+// the app uses PKCE), from which the server gets short-lived access tokens
+// itself. ADMIN_API_KEY, OIDC_CLIENT_SECRET and URL_SIGNING_KEY are read the
+// same ways, once, at startup.
+
+const defaultSecretsRefresh = time.Minute
+
+// secretRef is where one secret comes from.
+type secretRef struct {
+    name  string
+    value string // NAME
+    file  string // NAME_FILE
+    vault string // NAME_VAULT: path#field
+}
+
+func secretFromEnv(name string) secretRef {
+    return secretRef{name: name, value: strings.TrimSpace(os.Getenv(name)), file: os.Getenv(name + "_FILE"), vault: os.Getenv(name + "_VAULT")}
+}
+
+func (r secretRef) set() bool { return r.value != "" || r.file != "" || r.vault != "" }
+
+// read fetches the secret's current value; "" if it is not set.
+func (r secretRef) read(ctx context.Context, v *vaultClient) (string, error) {
+    switch {
+    case r.file != "":
+        b, err := os.ReadFile(r.file)
+        if err != nil { return "", fmt.Errorf("%s_FILE: %w", r.name, err) }
+        return strings.TrimSpace(string(b)), nil
+    case r.vault != "":
+        if v == nil { return "", fmt.Errorf("%s_VAULT needs VAULT_ADDR", r.name) }
+        p, field, ok := strings.Cut(r.vault, "#")
+        if !ok || field == "" { return "", fmt.Errorf("%s_VAULT must be path#field", r.name) }
+        val, err := v.get(ctx, p, field)
+        if err != nil { return "", fmt.Errorf("%s_VAULT: %w", r.name, err) }
+        return val, nil
+    }
+    return r.value, nil
+}
+
+// readSecret reads a secret once, for settings that are not reloaded.
+func readSecret(name string) (string, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    return secretFromEnv(name).read(ctx, vaultFromEnv())
+}
+
+// vaultClient reads KV secrets from Vault.
+type vaultClient struct {
+    addr      string
+    token     string
+    tokenFile string // re-read on every request: agents rotate it
+    namespace string
+    client    *http.Client
+}
+
+// vaultFromEnv is the client for VAULT_ADDR; nil if that is not set.
+func vaultFromEnv() *vaultClient {
+    addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+    if addr == "" { return nil }
+    return &vaultClient{addr: addr, token: os.Getenv("VAULT_TOKEN"), tokenFile: os.Getenv("VAULT_TOKEN_FILE"),
+        namespace: os.Getenv("VAULT_NAMESPACE"), client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// get reads field of the secret at path.
+func (v *vaultClient) get(ctx context.Context, path, field string) (string, error) {
+    token := v.token
+    if v.tokenFile != "" {
+        b, err := os.ReadFile(v.tokenFile)
+        if err != nil { return "", err }
+        token = strings.TrimSpace(string(b))
+    }
+    req, _ := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+    req.Header.Set("X-Vault-Token", token)
+    if v.namespace != "" { req.Header.Set("X-Vault-Namespace", v.namespace) }
+    res, err := v.client.Do(req)
+    if err != nil { return "", err }
+    defer res.Body.Close()
+    b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+    if res.StatusCode != 200 { return "", fmt.Errorf("vault %s -> %s: %s", path, res.Status, truncate(string(b), 200)) }
+    var out struct {
+        Data map[string]any `json:"data"`
+    }
+    if err := json.Unmarshal(b, &out); err != nil { return "", err }
+    data := out.Data
+    if inner, ok := data["data"].(map[string]any); ok { data = inner } // KV version 2
+    val, ok := data[field].(string)
+    if !ok { return "", fmt.Errorf("%s has no field %q", path, field) }
+    return strings.TrimSpace(val), nil
+}
+
+// dropboxCreds are the Dropbox secrets as last read.
+type dropboxCreds struct {
+    token, refresh, appKey, appSecret string
+}
+
+// dropboxAuth hands out Dropbox access tokens from credentials that may
+// change while the server runs.
+type dropboxAuth struct {
+    refs  [4]secretRef // token, refresh token, app key, app secret
+    vault *vaultClient
+
+    mu      sync.Mutex
+    creds   dropboxCreds
+    access  string    // from the refresh token
+    expires time.Time // of access
+    stale   bool      // Dropbox refused a token: read the secrets again
+}
+
+// loadDropboxAuth reads the Dropbox credentials for the first time.
+func loadDropboxAuth() (*dropboxAuth, error) {
+    a := &dropboxAuth{vault: vaultFromEnv()}
+    for i, name := range []string{"DROPBOX_TOKEN", "DROPBOX_REFRESH_TOKEN", "DROPBOX_APP_KEY", "DROPBOX_APP_SECRET"} { a.refs[i] = secretFromEnv(name) }
+    if !a.refs[0].set() && !a.refs[1].set() {
+        return nil, errors.New("DROPBOX_TOKEN, or DROPBOX_REFRESH_TOKEN with DROPBOX_APP_KEY, is required (as the value, _FILE or _VAULT)")
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    a.mu.Lock(); defer a.mu.Unlock()
+    return a, a.reload(ctx)
+}
+
+// reload reads the secrets again; a.mu is held.
+func (a *dropboxAuth) reload(ctx context.Context) error {
+    var vals [4]string
+    for i, ref := range a.refs {
+        v, err := ref.read(ctx, a.vault)
+        if err != nil { return err }
+        vals[i] = v
+    }
+    c := dropboxCreds{token: vals[0], refresh: vals[1], appKey: vals[2], appSecret: vals[3]}
+    if c.token == "" && (c.refresh == "" || c.appKey == "") { return errors.New("no Dropbox access token, or refresh token and app key") }
+    if c != a.creds && a.creds != (dropboxCreds{}) {
+        slog.InfoContext(ctx, "Dropbox credentials changed; using the new ones")
+        a.access, a.expires = "", time.Time{}
+    }
+    a.creds, a.stale = c, false
+    return nil
+}
+
+// watch reloads the secrets every interval.
+func (a *dropboxAuth) watch(every time.Duration) {
+    for range time.Tick(every) {
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        a.mu.Lock()
+        if err := a.reload(ctx); err != nil { slog.WarnContext(ctx, "reading Dropbox credentials failed; keeping the current ones", "error", err) }
+        a.mu.Unlock()
+        cancel()
+    }
+}
+
+// refused is called when Dropbox answers 401, so the next request reads
+// the secrets again and gets a fresh access token.
+func (a *dropboxAuth) refused() {
+    a.mu.Lock(); defer a.mu.Unlock()
+    a.stale, a.access = true, ""
+}
+
+// authorize sets req's Authorization header.
+func (a *dropboxAuth) authorize(req *http.Request) error {
+    tok, err := a.token(req.Context())
+    if err != nil { return err }
+    req.Header.Set("Authorization", "Bearer "+tok)
+    return nil
+}
+
+// token is the access token to send now.
+func (a *dropboxAuth) token(ctx context.Context) (string, error) {
+    a.mu.Lock(); defer a.mu.Unlock()
+    if a.stale {
+        if err := a.reload(ctx); err != nil { slog.WarnContext(ctx, "reading Dropbox credentials failed; keeping the current ones", "error", err) }
+    }
+    c := a.creds
+    if c.refresh == "" || c.appKey == "" { return c.token, nil }
+    if a.access != "" && time.Until(a.expires) > 5*time.Minute { return a.access, nil }
+
+    form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {c.refresh}, "client_id": {c.appKey}}
+    if c.appSecret != "" { form.Set("client_secret", c.appSecret) }
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, dbxAPIHost+"/oauth2/token", strings.NewReader(form.Encode()))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    start := time.Now()
+    res, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+    if err != nil { logDropbox(ctx, "/oauth2/token", start, 0, err); return "", err }
+    defer res.Body.Close()
+    b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+    if res.StatusCode != 200 {
+        err := fmt.Errorf("dropbox token refresh -> %s: %s", res.Status, truncate(string(b), 400))
+        logDropbox(ctx, "/oauth2/token", start, res.StatusCode, err)
+        return "", err
+    }
+    logDropbox(ctx, "/oauth2/token", start, res.StatusCode, nil)
+    var tok struct {
+        AccessToken string `json:"access_token"`
+        ExpiresIn   int    `json:"expires_in"`
+    }
+    if err := json.Unmarshal(b, &tok); err != nil || tok.AccessToken == "" { return "", fmt.Errorf("dropbox token refresh: bad response") }
+    a.access, a.expires = tok.AccessToken, time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second)
+    return a.access, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/binary"
+    "errors"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    "unicode/utf16"
+
+    "avcs-browser/client"
+)
+
+// ====== Session Header Metadata ======
+
+type SessionInfo = client.SessionInfo
+
+// headerLimit bounds how much of a session is read for metadata.
+const headerLimit = 8 << 20
+
+func (s *Server) sessionInfo(ctx context.Context, daw string, ref *FileRef) (*SessionInfo, error) {
+    if daw == "ableton" {
+        info, err := s.alsInfo(ctx, ref)
+        if err != nil { return nil, err }
+        return &SessionInfo{DAW: daw, Tempo: info.Tempo}, nil
+    }
+    parse := sessionParsers[daw]
+    if parse == nil { return &SessionInfo{DAW: daw}, nil }
+
+    key := ref.Path + "@" + ref.ServerModified.Format(time.RFC3339)
+    s.alsMu.Lock(); info := s.sessCache[key]; s.alsMu.Unlock()
+    if info != nil { return info, nil }
+
+    body, err := s.analysisDownload(ctx, ref.Path)
+    if err != nil { return nil, err }
+    defer body.Close()
+    info, err = parse(io.LimitReader(body, headerLimit))
+    if err != nil { return nil, err }
+    info.DAW = daw
+
+    s.alsMu.Lock(); cachePut(s.sessCache, key, info); s.alsMu.Unlock()
+    return info, nil
+}
+
+var sessionParsers = map[string]func(io.Reader) (*SessionInfo, error){
+    "flstudio": parseFLP,
+    "bitwig":   parseBitwig,
+    "reaper":   parseRPP,
+}
+
+// FL Studio event ids (byte events < 64, word < 128, dword < 192, else sized).
+const (
+    flpTempoCoarse = 66  // word, whole BPM (old projects)
+    flpTempoFine   = 156 // dword, BPM * 1000
+    flpTitle       = 194
+    flpVersion     = 199
+
+    flpTextLimit = 64 << 10 // bytes of a title or version read; longer ones are skipped
+)
+
+// parseFLP reads the FLhd/FLdt event stream. Text is UTF-16LE from FL 11.5 on.
+func parseFLP(r io.Reader) (*SessionInfo, error) {
+    br := bufio.NewReader(r)
+    var hdr [8]byte
+    if _, err := io.ReadFull(br, hdr[:]); err != nil { return nil, err }
+    if string(hdr[:4]) != "FLhd" { return nil, errors.New("not an FL Studio project") }
+    if _, err := br.Discard(int(binary.LittleEndian.Uint32(hdr[4:]))); err != nil { return nil, err }
+    if _, err := io.ReadFull(br, hdr[:]); err != nil { return nil, err }
+    if string(hdr[:4]) != "FLdt" { return nil, errors.New("missing FLdt chunk") }
+
+    info := &SessionInfo{}
+    unicode := false
+    for {
+        id, err := br.ReadByte()
+        if err != nil { break } // truncated by headerLimit or end of data
+        switch {
+        case id < 64:
+            if _, err := br.Discard(1); err != nil { return info, nil }
+        case id < 128:
+            var v [2]byte
+            if _, err := io.ReadFull(br, v[:]); err != nil { return info, nil }
+            if id == flpTempoCoarse && info.Tempo == 0 { info.Tempo = float64(binary.LittleEndian.Uint16(v[:])) }
+        case id < 192:
+            var v [4]byte
+            if _, err := io.ReadFull(br, v[:]); err != nil { return info, nil }
+            if id == flpTempoFine { info.Tempo = float64(binary.LittleEndian.Uint32(v[:])) / 1000 }
+        default:
+            n, err := binary.ReadUvarint(br)
+            if err != nil || n > headerLimit { return info, nil } // longer than what is read: corrupt or cut short
+            if id != flpTitle && id != flpVersion || n > flpTextLimit {
+                if _, err := br.Discard(int(n)); err != nil { return info, nil }
+                continue
+            }
+            data := make([]byte, n)
+            if _, err := io.ReadFull(br, data); err != nil { return info, nil }
+            if id == flpVersion {
+                info.Version = strings.TrimRight(string(data), "\x00")
+                unicode = flVersionAtLeast(info.Version, 11, 5)
+            } else {
+                info.Title = flText(data, unicode)
+            }
+        }
+    }
+    return info, nil
+}
+
+func flVersionAtLeast(v string, major, minor int) bool {
+    parts := strings.Split(v, ".")
+    maj, _ := strconv.Atoi(parts[0])
+    mnr := 0
+    if len(parts) > 1 { mnr, _ = strconv.Atoi(parts[1]) }
+    return maj > major || maj == major && mnr >= minor
+}
+
+func flText(b []byte, unicode bool) string {
+    if !unicode { return strings.TrimRight(string(b), "\x00") }
+    u := make([]uint16, len(b)/2)
+    for i := range u { u[i] = binary.LittleEndian.Uint16(b[2*i:]) }
+    return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+}
+
+// parseBitwig is best effort: the format is undocumented, but the header
+// after the "BtWg" magic carries length-prefixed key/value strings.
+func parseBitwig(r io.Reader) (*SessionInfo, error) {
+    head := make([]byte, 64<<10)
+    n, err := io.ReadFull(r, head)
+    if err != nil && err != io.ErrUnexpectedEOF { return nil, err }
+    head = head[:n]
+    if !bytes.HasPrefix(head, []byte("BtWg")) { return nil, errors.New("not a Bitwig project") }
+
+    var strs []string
+    for i := 4; i+4 <= len(head); {
+        l := int(binary.BigEndian.Uint32(head[i:]))
+        if l > 0 && l <= 256 && i+4+l <= len(head) && printable(head[i+4:i+4+l]) {
+            strs = append(strs, string(head[i+4:i+4+l]))
+            i += 4 + l
+            continue
+        }
+        i++
+    }
+    info := &SessionInfo{}
+    for i := 0; i+1 < len(strs); i++ {
+        switch strs[i] {
+        case "application_version_name": info.Version = strs[i+1]
+        case "title": info.Title = strs[i+1]
+        case "tempo", "bpm":
+            if v, err := strconv.ParseFloat(strs[i+1], 64); err == nil { info.Tempo = v }
+        }
+    }
+    return info, nil
+}
+
+func printable(b []byte) bool {
+    for _, c := range b {
+        if c < 0x20 || c > 0x7e { return false }
+    }
+    return true
+}
+
+// parseRPP reads the plain-text Reaper project header.
+func parseRPP(r io.Reader) (*SessionInfo, error) {
+    info := &SessionInfo{}
+    sc := bufio.NewScanner(r)
+    sc.Buffer(make([]byte, 64<<10), 1<<20)
+    for sc.Scan() {
+        f := strings.Fields(sc.Text())
+        if len(f) == 0 { continue }
+        switch f[0] {
+        case "<REAPER_PROJECT":
+            if len(f) > 2 { info.Version = strings.Trim(f[2], `"`) }
+        case "TEMPO":
+            if len(f) > 1 { info.Tempo, _ = strconv.ParseFloat(f[1], 64) }
+        case "TITLE":
+            info.Title = strings.Trim(strings.Join(f[1:], " "), `"`)
+        case "<TRACK":
+            return info, nil // header settings come before the first track
+        }
+    }
+    return info, sc.Err()
+}
+
+func (s *Server) handleSessionInfo(w http.ResponseWriter, r *http.Request, t *Track, t1 string) {
+    snap := findSnap(t, t1)
+    if snap == nil { http.Error(w, "snapshot not found", 404); return }
+    ref := snap.Session
+    if snap.DAW == "ableton" { ref = snap.ALS }
+    if ref == nil { http.Error(w, "snapshot has no session file", 404); return }
+    info, err := s.sessionInfo(r.Context(), snap.DAW, ref)
+    if err != nil { http.Error(w, err.Error(), 502); return }
+    writeJSON(w, info)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "mime"
+    "net/http"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Share Links ======
+//
+// A share link lets someone without an account or Dropbox access listen to
+// one artifact, or to a track's newest mix and FINAL, until it expires. The
+// token in /s/{token}, and the link's password if it has one, is all it takes:
+// the page and its files are served through this server, so Dropbox paths and the rest of the catalog
+// stay out of sight. With download off the files play in the page but are
+// not offered as downloads; downloaded WAVs and MP3s are tagged with the
+// track's title, ISRC and the like (codes.go). What a link shows is looked
+// up when it is used, so a track link follows new mixes and FINALs.
+
+const (
+    defaultShareTTL = 7 * 24 * time.Hour
+    maxShareTTL     = 90 * 24 * time.Hour
+)
+
+type Share = client.Share
+
+// publicShare is sh as shown to its sharer, without the password hash.
+func publicShare(sh Share) Share { sh.Locked, sh.Password = sh.Password != "", ""; return sh }
+
+// sharedFiles are the files a share of t exposes now.
+func sharedFiles(t *Track, a *ArtifactRef) []FileRef {
+    var out []FileRef
+    add := func(f *FileRef) { if f != nil { out = append(out, *f) } }
+    if a == nil {
+        var mix *Mix
+        var final *FileRef
+        for i := range t.Mixes {
+            if mix == nil || t.Mixes[i].Latest.After(mix.Latest) { mix = &t.Mixes[i] }
+        }
+        for i := range t.Masters {
+            if f := t.Masters[i].Final; f != nil && (final == nil || f.ServerModified.After(final.ServerModified)) { final = f }
+        }
+        if mix != nil { add(&mix.File) }
+        add(final)
+        return out
+    }
+    switch a.Kind {
+    case kindSnapshot:
+        if snap := findSnap(t, a.T1); snap != nil { add(snap.WAV); add(snap.MP3) }
+    case kindStems:
+        for _, st := range t.Stems {
+            if st.T1 == a.T1 && st.T2 == a.T2 { out = append(out, st.Stems...) }
+        }
+    case kindMaster:
+        if a.Idx != "" { add(a.File(t)); break }
+        for _, m := range t.Masters {
+            if m.T1 != a.T1 || m.T2 != a.T2 { continue }
+            out = append(out, m.Candidates...)
+            add(m.Final)
+        }
+    default:
+        add(a.File(t))
+    }
+    return out
+}
+
+// liveShare is the unexpired share with token, and what it exposes.
+func (s *Server) liveShare(token string) (*Share, []FileRef, error) {
+    var sh *Share
+    s.store.view(func(d *storeData) {
+        if cur := d.Shares[token]; cur != nil { c := *cur; sh = &c }
+    })
+    if sh == nil { return nil, nil, httpError{404, "this link does not exist or was revoked"} }
+    if time.Now().After(sh.Expires) { return nil, nil, httpError{410, "this link has expired"} }
+    t := s.lookupTrack(sh.Track)
+    if t == nil { return nil, nil, httpError{404, "what this link shared is gone"} }
+    files := sharedFiles(t, sh.Artifact)
+    if len(files) == 0 { return nil, nil, httpError{404, "what this link shared is gone"} }
+    return sh, files, nil
+}
+
+// baseURL is how the client reached the server, for links to hand out.
+func baseURL(r *http.Request) string {
+    scheme := "http"
+    if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" { scheme = "https" }
+    return scheme + "://" + r.Host
+}
+
+// GET    /api/share              the caller's links (everyone's for admins)
+// POST   /api/share {"track":"ENERGY"[,"artifact":{"kind":"mix","t1":"0430A","t2":"0720P"}][,"expires_in":"72h"|"expires":"..."][,"download":false][,"password":"..."]}
+// GET    /api/share/{token}      the link and how it was used
+// PATCH  /api/share/{token} {"password":"..."|""[,"download":true]}
+// DELETE /api/share/{token}      revokes a link
+// Links last a week unless told otherwise, and at most 90 days. Sharing needs
+// the role to reach the files: stems, or masters of an unreleased track. Only
+// the sharer and admins see a link once made.
+func (s *Server) handleShares(w http.ResponseWriter, r *http.Request) {
+    token := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/share"), "/")
+    admin := requestKey(r) == nil || requestKey(r).has(scopeAdmin)
+    switch {
+    case token == "" && r.Method == http.MethodGet:
+        out := []Share{}
+        me := actorOf(r)
+        s.store.view(func(d *storeData) {
+            for _, sh := range d.Shares {
+                if admin || sh.By == me { out = append(out, publicShare(*sh)) }
+            }
+        })
+        sort.Slice(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+        writeJSON(w, out)
+
+    case token == "" && r.Method == http.MethodPost:
+        var req struct {
+            Track     string       `json:"track"`
+            Artifact  *ArtifactRef `json:"artifact"`
+            ExpiresIn string       `json:"expires_in"`
+            Expires   *time.Time   `json:"expires"`
+            Download  *bool        `json:"download"`
+            Password  string       `json:"password"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        t := s.lookupTrack(req.Track)
+        if t == nil || !s.mayAccess(r, t.Name) { http.Error(w, "track not found", 404); return }
+        if req.Artifact != nil {
+            if err := req.Artifact.Resolve(t); err != nil { http.Error(w, err.Error(), 404); return }
+        }
+        now := time.Now().UTC()
+        sh := Share{Token: randToken(), Track: t.Name, Artifact: req.Artifact, Download: req.Download == nil || *req.Download,
+            By: actorOf(r), Created: now, Expires: now.Add(defaultShareTTL)}
+        switch {
+        case req.Expires != nil:
+            sh.Expires = req.Expires.UTC()
+        case req.ExpiresIn != "":
+            d, err := time.ParseDuration(req.ExpiresIn)
+            if err != nil { http.Error(w, "expires_in: "+err.Error(), 400); return }
+            sh.Expires = now.Add(d)
+        }
+        if !sh.Expires.After(now) || sh.Expires.Sub(now) > maxShareTTL {
+            http.Error(w, fmt.Sprintf("a link must expire within %d days", int(maxShareTTL.Hours()/24)), 400); return
+        }
+        if req.Password != "" { sh.Password = hashSharePassword(req.Password) }
+        files := sharedFiles(t, sh.Artifact)
+        if len(files) == 0 { http.Error(w, "nothing to share: "+t.Name+" has no mix or FINAL yet", 409); return }
+        for _, f := range files {
+            for _, perm := range s.filePerms(f.Path) {
+                if !can(r, perm) { http.Error(w, "the "+requestKey(r).Role+" role may not "+permWhat[perm]+", so not share them either", 403); return }
+            }
+        }
+        err := s.store.update(func(d *storeData) error {
+            if d.Shares == nil { d.Shares = map[string]*Share{} }
+            d.Shares[sh.Token] = &sh
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "share-create", t.Name, nil, publicShare(sh))
+        writeJSONStatus(w, http.StatusCreated, map[string]any{"url": baseURL(r) + "/s/" + sh.Token, "share": publicShare(sh)})
+
+    case token == "":
+        http.Error(w, "GET or POST required", 405)
+
+    default:
+        var sh *Share
+        s.store.view(func(d *storeData) {
+            if cur := d.Shares[token]; cur != nil { c := *cur; sh = &c }
+        })
+        if sh == nil { http.Error(w, "link not found", 404); return }
+        if !admin && sh.By != actorOf(r) { http.Error(w, "only "+sh.By+" or an admin can see or change this link", 403); return }
+        switch r.Method {
+        case http.MethodGet:
+            writeJSON(w, map[string]any{"share": publicShare(*sh), "stats": s.shareStats(token)})
+
+        case http.MethodPatch:
+            var req struct {
+                Password *string `json:"password"`
+                Download *bool   `json:"download"`
+            }
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+            before := publicShare(*sh)
+            if req.Password != nil {
+                sh.Password = ""
+                if *req.Password != "" { sh.Password = hashSharePassword(*req.Password) }
+            }
+            if req.Download != nil { sh.Download = *req.Download }
+            err := s.store.update(func(d *storeData) error {
+                if d.Shares[token] == nil { return httpError{404, "link not found"} }
+                d.Shares[token] = sh
+                return nil
+            })
+            if err != nil { writeError(w, err); return }
+            s.audit(r, "share-update", sh.Track, before, publicShare(*sh))
+            writeJSON(w, publicShare(*sh))
+
+        case http.MethodDelete:
+            err := s.store.update(func(d *storeData) error { delete(d.Shares, token); delete(d.ShareStats, token); return nil })
+            if err != nil { http.Error(w, err.Error(), 500); return }
+            s.audit(r, "share-revoke", sh.Track, publicShare(*sh), nil)
+            w.WriteHeader(http.StatusNoContent)
+
+        default:
+            http.Error(w, "GET, PATCH or DELETE required", 405)
+        }
+    }
+}
+
+// GET  /s/{token}                  the listening page
+// POST /s/{token}/unlock password=...
+// GET  /s/{token}/info             what it shares, without paths
+// GET  /s/{token}/files/{n}[?download=1]
+// These need no key or login; expired links answer 410, and info and files
+// answer 401 until a password-protected link is unlocked.
+func (s *Server) handleSharePage(w http.ResponseWriter, r *http.Request) {
+    parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/s/"), "/"), "/")
+    sh, files, err := s.liveShare(parts[0])
+    if err != nil { writeError(w, err); return }
+    w.Header().Set("X-Robots-Tag", "noindex")
+    if len(parts) == 2 && parts[1] == "unlock" {
+        if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+        s.unlockShare(w, r, sh)
+        return
+    }
+    if r.Method != http.MethodGet && r.Method != http.MethodHead { http.Error(w, "GET required", 405); return }
+    if len(parts) > 1 && !unlocked(r, sh) { http.Error(w, "password required", 401); return }
+    switch {
+    case len(parts) == 1:
+        serveFS(w, "web/share.html")
+
+    case len(parts) == 2 && parts[1] == "info":
+        type sharedFile struct {
+            Name string `json:"name"`
+            Size int64  `json:"size"`
+        }
+        out := map[string]any{"track": sh.Track, "artifact": sh.Artifact, "download": sh.Download, "expires": sh.Expires}
+        list := []sharedFile{}
+        for _, f := range files { list = append(list, sharedFile{f.Name, f.Size}) }
+        out["files"] = list
+        s.recordShare(r, sh.Token, shareView, "")
+        writeJSON(w, out)
+
+    case len(parts) == 3 && parts[1] == "files":
+        n, err := strconv.Atoi(parts[2])
+        if err != nil || n < 0 || n >= len(files) { http.Error(w, "no such file", 404); return }
+        f := files[n]
+        disposition, kind := "inline", sharePlay
+        if r.URL.Query().Get("download") != "" {
+            if !sh.Download { http.Error(w, "downloads are off for this link", 403); return }
+            disposition, kind = "attachment", shareDownload
+        }
+        body, err := s.streamDownload(r.Context(), f)
+        if err != nil { http.Error(w, err.Error(), 502); return }
+        defer body.Close()
+        var out io.ReadCloser = body
+        size := f.Size
+        if t := s.lookupTrack(sh.Track); t != nil && kind == shareDownload { // downloads leave tagged, see codes.go
+            if out, size, err = tagFile(body, f.Name, f.Size, s.audioTagsOf(t)); err != nil { http.Error(w, err.Error(), 502); return }
+            defer out.Close()
+        }
+        ct := mime.TypeByExtension(path.Ext(f.Name))
+        if ct == "" { ct = "application/octet-stream" }
+        w.Header().Set("Content-Type", ct)
+        w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+        w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": f.Name}))
+        w.Header().Set("Cache-Control", "no-store")
+        if r.Method == http.MethodHead { return }
+        s.recordShare(r, sh.Token, kind, f.Name)
+        io.Copy(w, out)
+
+    default:
+        http.NotFound(w, r)
+    }
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/hex"
+    "fmt"
+    "log/slog"
+    "maps"
+    "net/http"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Share Link Passwords and Analytics ======
+//
+// A share link can ask for a password before it shows anything. Only a
+// PBKDF2 hash of the password is kept; opening the link with it sets a cookie
+// for that link alone, which stops working when the password changes.
+// Attempts are limited per address.
+//
+// Every link counts its views (the page opened), plays (a file streamed) and
+// downloads, per client address, and keeps the most recent of them as events,
+// so a sharer can tell whether and how a link was used. Addresses are those
+// the rate limits see (see ratelimit.go).
+
+const (
+    sharePasswordIter = 100_000
+    shareUnlockCookie = "avcs_share"
+    shareUnlockLimit  = 10 // password attempts per minute and address
+    shareEventsKept   = 200
+)
+
+const (
+    shareView     = "view"
+    sharePlay     = "play"
+    shareDownload = "download"
+)
+
+type (
+    ShareStats   = client.ShareStats
+    ShareVisitor = client.ShareVisitor
+    ShareEvent   = client.ShareEvent
+)
+
+// pbkdf2 is PBKDF2 with HMAC-SHA256 (RFC 8018).
+func pbkdf2(password, salt []byte, iter, size int) []byte {
+    prf := hmac.New(sha256.New, password)
+    var out []byte
+    for block := uint32(1); len(out) < size; block++ {
+        prf.Reset()
+        prf.Write(salt)
+        prf.Write(binary.BigEndian.AppendUint32(nil, block))
+        u := prf.Sum(nil)
+        t := slices.Clone(u)
+        for i := 1; i < iter; i++ {
+            prf.Reset()
+            prf.Write(u)
+            u = prf.Sum(u[:0])
+            for j := range t { t[j] ^= u[j] }
+        }
+        out = append(out, t...)
+    }
+    return out[:size]
+}
+
+// hashSharePassword is "pbkdf2-sha256$<iterations>$<salt>$<key>".
+func hashSharePassword(pw string) string {
+    salt := make([]byte, 16)
+    rand.Read(salt)
+    key := pbkdf2([]byte(pw), salt, sharePasswordIter, 32)
+    b64 := base64.RawStdEncoding.EncodeToString
+    return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", sharePasswordIter, b64(salt), b64(key))
+}
+
+func checkSharePassword(hash, pw string) bool {
+    parts := strings.Split(hash, "$")
+    if len(parts) != 4 || parts[0] != "pbkdf2-sha256" { return false }
+    iter, err := strconv.Atoi(parts[1])
+    if err != nil || iter < 1 { return false }
+    salt, err1 := base64.RawStdEncoding.DecodeString(parts[2])
+    key, err2 := base64.RawStdEncoding.DecodeString(parts[3])
+    if err1 != nil || err2 != nil { return false }
+    return subtle.ConstantTimeCompare(pbkdf2([]byte(pw), salt, iter, len(key)), key) == 1
+}
+
+// unlockValue is what the cookie for sh holds once its password was given.
+func unlockValue(sh *Share) string {
+    mac := hmac.New(sha256.New, []byte(sh.Password))
+    mac.Write([]byte(sh.Token))
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// unlocked reports whether r may see sh.
+func unlocked(r *http.Request, sh *Share) bool {
+    if sh.Password == "" { return true }
+    c, err := r.Cookie(shareUnlockCookie)
+    return err == nil && hmac.Equal([]byte(c.Value), []byte(unlockValue(sh)))
+}
+
+// unlockShare checks the password posted to /s/{token}/unlock and, if it is
+// right, gives the browser the link's cookie.
+func (s *Server) unlockShare(w http.ResponseWriter, r *http.Request, sh *Share) {
+    if sh.Password == "" { w.WriteHeader(http.StatusNoContent); return }
+    if ok, wait := s.ipLimiter.allow("unlock:"+s.clientIP(r), shareUnlockLimit, time.Now()); !ok {
+        w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+        http.Error(w, "too many attempts; wait a minute", 429); return
+    }
+    if !checkSharePassword(sh.Password, r.FormValue("password")) {
+        s.logAccess(r, AccessEvent{Kind: accessAuthFailure, Track: sh.Track, Share: sh.Token, Detail: "wrong share password"})
+        http.Error(w, "wrong password", 401); return
+    }
+    http.SetCookie(w, &http.Cookie{Name: shareUnlockCookie, Value: unlockValue(sh), Path: "/s/" + sh.Token,
+        Expires: sh.Expires, HttpOnly: true, Secure: r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https", SameSite: http.SameSiteLaxMode})
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// recordShare counts one use of the link with token. Failing to record it
+// does not stop the listener.
+func (s *Server) recordShare(r *http.Request, token, kind, file string) {
+    ev := ShareEvent{At: time.Now().UTC(), Kind: kind, IP: s.clientIP(r), Agent: truncate(r.UserAgent(), 200), File: file}
+    var track string
+    s.store.view(func(d *storeData) {
+        if sh := d.Shares[token]; sh != nil { track = sh.Track }
+    })
+    s.logAccess(r, AccessEvent{Kind: "share-" + kind, Track: track, File: file, Share: token})
+    err := s.store.update(func(d *storeData) error {
+        if d.Shares[token] == nil { return nil }
+        st := ShareStats{Visitors: map[string]*ShareVisitor{}}
+        if cur := d.ShareStats[token]; cur != nil { st = *cur; st.Visitors = maps.Clone(cur.Visitors) }
+        v := ShareVisitor{First: ev.At}
+        if cur := st.Visitors[ev.IP]; cur != nil { v = *cur }
+        v.Last = ev.At
+        switch kind {
+        case shareView: st.Views++; v.Views++
+        case sharePlay: st.Plays++; v.Plays++
+        case shareDownload: st.Downloads++; v.Downloads++
+        }
+        st.Visitors[ev.IP] = &v
+        st.Recent = append(slices.Clone(st.Recent), ev)
+        if n := len(st.Recent) - shareEventsKept; n > 0 { st.Recent = st.Recent[n:] }
+        if d.ShareStats == nil { d.ShareStats = map[string]*ShareStats{} }
+        d.ShareStats[token] = &st
+        return nil
+    })
+    if err != nil { slog.ErrorContext(r.Context(), "recording share use failed", "kind", kind, "error", err) }
+}
+
+// shareStats is a copy of the stats of the link with token.
+func (s *Server) shareStats(token string) ShareStats {
+    st := ShareStats{Visitors: map[string]*ShareVisitor{}, Recent: []ShareEvent{}}
+    s.store.view(func(d *storeData) {
+        cur := d.ShareStats[token]
+        if cur == nil { return }
+        st = *cur
+        st.Visitors = map[string]*ShareVisitor{}
+        for ip, v := range cur.Visitors { c := *v; st.Visitors[ip] = &c }
+        st.Recent = slices.Clone(cur.Recent)
+    })
+    return st
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "maps"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ====== Signed URLs ======
+//
+// The URLs that hand out audio (/api/link and the A/B streams) can be signed
+// by the server: GET /api/sign?url=... answers with the URL plus an expiry
+// and an HMAC of both, which works without a key or login until it expires.
+// A player can use it where headers cannot be sent, and a copied URL stops
+// working minutes later. With SIGNED_URLS=required these URLs must be signed,
+// for everyone; SIGNED_URL_TTL sets how long a signature lasts (default 15m).
+// The key is URL_SIGNING_KEY, or one generated in DATA_DIR/url-signing.key.
+
+const defaultSignedURLTTL = 15 * time.Minute
+
+var errUnsigned = errors.New("unsigned")
+
+// signedPath reports whether p is one of the URLs signing applies to.
+func signedPath(p string) bool {
+    if p == "/api/link" { return true }
+    parts := strings.Split(strings.Trim(strings.TrimPrefix(p, "/api/ab/"), "/"), "/")
+    return strings.HasPrefix(p, "/api/ab/") && len(parts) == 3 && parts[1] == "stream"
+}
+
+// loadSigningKey reads the URL signing key from env, or from file, creating it.
+func loadSigningKey(env, file string) ([]byte, error) {
+    if env = strings.TrimSpace(env); env != "" {
+        if len(env) < 32 { return nil, errors.New("URL_SIGNING_KEY must be at least 32 characters") }
+        return []byte(env), nil
+    }
+    b, err := os.ReadFile(file)
+    if err == nil { return base64.StdEncoding.DecodeString(strings.TrimSpace(string(b))) }
+    if !errors.Is(err, os.ErrNotExist) { return nil, err }
+    key := make([]byte, 32)
+    if _, err := rand.Read(key); err != nil { return nil, err }
+    if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil { return nil, err }
+    return key, os.WriteFile(file, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600)
+}
+
+// urlSignature is the HMAC of a path and its query, less any sig.
+func (s *Server) urlSignature(p string, q url.Values) string {
+    q = maps.Clone(q)
+    q.Del("sig")
+    mac := hmac.New(sha256.New, s.signingKey)
+    mac.Write([]byte(p + "?" + q.Encode())) // Encode sorts by key
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signURL adds exp and sig to u, which must be a path on this server.
+func (s *Server) signURL(u *url.URL, ttl time.Duration) (string, time.Time) {
+    exp := time.Now().Add(ttl).Truncate(time.Second).UTC()
+    q := u.Query()
+    q.Set("exp", strconv.FormatInt(exp.Unix(), 10))
+    q.Set("sig", s.urlSignature(u.Path, q))
+    return u.Path + "?" + q.Encode(), exp
+}
+
+// checkSignature verifies r's signature: errUnsigned if it has none.
+func (s *Server) checkSignature(r *http.Request) error {
+    q := r.URL.Query()
+    sig := q.Get("sig")
+    if sig == "" { return errUnsigned }
+    if !hmac.Equal([]byte(sig), []byte(s.urlSignature(r.URL.Path, q))) { return errors.New("bad URL signature") }
+    exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+    if err != nil || time.Now().Unix() > exp { return errors.New("this signed URL has expired") }
+    return nil
+}
+
+// GET /api/sign?url=/api/link%3Fpath%3D...
+// Signs a URL the caller could fetch now, for SIGNED_URL_TTL.
+func (s *Server) handleSign(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    u, err := url.Parse(r.URL.Query().Get("url"))
+    if err != nil || u.Scheme != "" || u.Host != "" { http.Error(w, "url must be a path on this server", 400); return }
+    if !signedPath(u.Path) { http.Error(w, "only /api/link and A/B stream URLs are signed", 400); return }
+    q := u.Query()
+    q.Del("exp"); q.Del("sig")
+    u.RawQuery = q.Encode()
+
+    // The caller must be allowed what the URL does.
+    target := r.Clone(r.Context())
+    target.URL = u
+    if k := requestKey(r); k != nil {
+        if need := requiredScope(target); !k.has(need) { http.Error(w, "signing this URL needs the "+need+" scope", 403); return }
+        if err := s.checkRole(target, k.Role); err != nil { writeError(w, err); return }
+    }
+    if err := s.checkAccess(target); err != nil { writeError(w, err); return }
+    signed, exp := s.signURL(u, s.signedURLTTL)
+    writeJSON(w, map[string]any{"url": signed, "expires": exp})
+}
+
+// parseSignedURLs reads SIGNED_URLS and SIGNED_URL_TTL.
+func parseSignedURLs(mode, ttl string) (required bool, d time.Duration, err error) {
+    switch mode {
+    case "", "optional":
+    case "required": required = true
+    default: return false, 0, fmt.Errorf("SIGNED_URLS must be optional or required, not %q", mode)
+    }
+    d = defaultSignedURLTTL
+    if ttl != "" {
+        if d, err = time.ParseDuration(ttl); err != nil || d <= 0 { return false, 0, fmt.Errorf("SIGNED_URL_TTL must be a duration like 15m, not %q", ttl) }
+    }
+    return required, d, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "slices"
+    "strings"
+    "time"
+)
+
+// ====== Slack Notifications ======
+//
+// New master candidates, FINALs and stems sets (see notify.go) are posted to
+// Slack through an incoming webhook, SLACK_WEBHOOK_URL, or as an app with
+// SLACK_BOT_TOKEN to SLACK_CHANNEL: the track, which version, who
+// contributed it, the loudness if it was measured and the share link.
+// SLACK_EVENTS picks what is posted, among candidate, final, stems, mix and
+// deadline (reminders, see deadlines.go).
+
+var slackAPI = "https://slack.com/api"
+
+type slackNotifier struct {
+    webhook string
+    token   string
+    channel string
+    events  []string
+    client  *http.Client
+}
+
+// loadSlack reads the Slack settings; nil if Slack is not set up.
+func loadSlack() (*slackNotifier, error) {
+    webhook, err := readSecret("SLACK_WEBHOOK_URL")
+    if err != nil { return nil, err }
+    token, err := readSecret("SLACK_BOT_TOKEN")
+    if err != nil { return nil, err }
+    n := &slackNotifier{webhook: webhook, token: token, channel: os.Getenv("SLACK_CHANNEL"), client: &http.Client{Timeout: 15 * time.Second}}
+    switch {
+    case webhook == "" && token == "": return nil, nil
+    case webhook == "" && n.channel == "": return nil, errors.New("SLACK_BOT_TOKEN needs SLACK_CHANNEL")
+    }
+    n.events, err = eventKindList("SLACK_EVENTS", os.Getenv("SLACK_EVENTS"), []string{eventCandidate, eventFinal, eventStems, eventDeadline})
+    return n, err
+}
+
+func (n *slackNotifier) name() string { return "slack" }
+
+func (n *slackNotifier) wants(kind string) bool { return slices.Contains(n.events, kind) }
+
+// notify posts e as mrkdwn text.
+func (n *slackNotifier) notify(ctx context.Context, e catalogEvent) error {
+    icon := map[string]string{eventCandidate: ":level_slider:", eventFinal: ":trophy:", eventStems: ":control_knobs:", eventMix: ":headphones:", eventDeadline: ":alarm_clock:"}[e.kind]
+    var b strings.Builder
+    track := strings.Replace(e.title(), e.track, "*"+e.track+"*", 1)
+    fmt.Fprintf(&b, "%s %s", icon, track)
+    if e.kind == eventDeadline { return n.post(ctx, b.String()) }
+    f := e.files[0]
+    if e.kind != eventStems { fmt.Fprintf(&b, "\n`%s`", f.Name) }
+    if e.lufs != nil { fmt.Fprintf(&b, " · %.1f LUFS", *e.lufs) }
+    if f.ContributedBy != "" { fmt.Fprintf(&b, " · by %s", f.ContributedBy) }
+    if e.link != "" { fmt.Fprintf(&b, "\n<%s|Listen>", e.link) }
+    return n.post(ctx, b.String())
+}
+
+// post sends text to the webhook or the channel.
+func (n *slackNotifier) post(ctx context.Context, text string) error {
+    url, msg := n.webhook, map[string]any{"text": text}
+    if url == "" { url, msg["channel"] = slackAPI+"/chat.postMessage", n.channel }
+    b, _ := json.Marshal(msg)
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+    req.Header.Set("Content-Type", "application/json; charset=utf-8")
+    if n.webhook == "" { req.Header.Set("Authorization", "Bearer "+n.token) }
+    res, err := n.client.Do(req)
+    if err != nil { return err }
+    defer res.Body.Close()
+    body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+    if res.StatusCode != 200 { return fmt.Errorf("slack -> %s: %s", res.Status, truncate(string(body), 400)) }
+    if n.webhook != "" { return nil }
+    var reply struct {
+        OK    bool   `json:"ok"`
+        Error string `json:"error"`
+    }
+    if err := json.Unmarshal(body, &reply); err != nil { return err }
+    if !reply.OK { return errors.New("slack: " + reply.Error) }
+    return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "mime/multipart"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== SoundCloud ======
+//
+// A master candidate, a FINAL, a mix or a snapshot's WAV bounce (the preview
+// render) can be pushed to SoundCloud as a private track, to send a reference
+// to a collaborator as one link:
+//
+//   POST /api/tracks/{name}/soundcloud {"artifact":{"kind":"master","t1":"0430A","t2":"0720P","idx":"FINAL"}}
+//
+// The file goes from Dropbox to SoundCloud without touching the disk, and
+// the private link SoundCloud answers with is recorded on the artifact (the
+// track's "published" list). Pushing the same file again returns that link
+// unless ?again=1 is given. Masters of unreleased tracks need a role that
+// may hear them.
+//
+// The server acts as the SoundCloud account that authorized the app:
+// SOUNDCLOUD_CLIENT_ID, SOUNDCLOUD_CLIENT_SECRET and SOUNDCLOUD_REFRESH_TOKEN
+// (secrets). SoundCloud replaces the refresh token each time it is used, so
+// the latest one is kept in soundcloud.token next to the state; a new
+// SOUNDCLOUD_REFRESH_TOKEN takes over from it.
+
+var (
+    soundcloudAPI   = "https://api.soundcloud.com"
+    soundcloudOAuth = "https://secure.soundcloud.com/oauth/token"
+)
+
+type Publication = client.Publication
+
+func (s *Server) publicationsFor(track string) []Publication {
+    var out []Publication
+    s.store.view(func(d *storeData) { out = append(out, d.Published[track]...) })
+    sort.SliceStable(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+    return out
+}
+
+// soundCloud is the SoundCloud app and its tokens.
+type soundCloud struct {
+    clientID, clientSecret string
+    seed                   string // hash of SOUNDCLOUD_REFRESH_TOKEN
+    tokenFile              string
+
+    mu      sync.Mutex
+    refresh string
+    access  string
+    expires time.Time
+}
+
+// soundcloudToken is what soundcloud.token holds.
+type soundcloudToken struct {
+    Seed         string `json:"seed"`
+    RefreshToken string `json:"refresh_token"`
+}
+
+// loadSoundCloud reads the SoundCloud settings; nil if there are none.
+func loadSoundCloud(stateDir string) (*soundCloud, error) {
+    var vals [3]string
+    for i, name := range []string{"SOUNDCLOUD_CLIENT_ID", "SOUNDCLOUD_CLIENT_SECRET", "SOUNDCLOUD_REFRESH_TOKEN"} {
+        v, err := readSecret(name)
+        if err != nil { return nil, err }
+        vals[i] = v
+    }
+    if vals == [3]string{} { return nil, nil }
+    if vals[0] == "" || vals[1] == "" || vals[2] == "" { return nil, errors.New("SOUNDCLOUD_CLIENT_ID, SOUNDCLOUD_CLIENT_SECRET and SOUNDCLOUD_REFRESH_TOKEN go together") }
+    sum := sha256.Sum256([]byte(vals[2]))
+    sc := &soundCloud{clientID: vals[0], clientSecret: vals[1], seed: hex.EncodeToString(sum[:]), refresh: vals[2], tokenFile: filepath.Join(stateDir, "soundcloud.token")}
+    b, err := os.ReadFile(sc.tokenFile)
+    if err != nil && !errors.Is(err, os.ErrNotExist) { return nil, err }
+    var saved soundcloudToken
+    if err == nil && json.Unmarshal(b, &saved) == nil && saved.Seed == sc.seed && saved.RefreshToken != "" { sc.refresh = saved.RefreshToken }
+    return sc, nil
+}
+
+// token is the access token to send now.
+func (sc *soundCloud) token(ctx context.Context) (string, error) {
+    sc.mu.Lock(); defer sc.mu.Unlock()
+    if sc.access != "" && time.Until(sc.expires) > 5*time.Minute { return sc.access, nil }
+    form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {sc.refresh}, "client_id": {sc.clientID}, "client_secret": {sc.clientSecret}}
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, soundcloudOAuth, strings.NewReader(form.Encode()))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.Header.Set("Accept", "application/json; charset=utf-8")
+    res, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+    if err != nil { return "", err }
+    defer res.Body.Close()
+    b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+    if res.StatusCode != 200 { return "", fmt.Errorf("soundcloud token refresh -> %s: %s", res.Status, truncate(string(b), 400)) }
+    var tok struct {
+        AccessToken  string `json:"access_token"`
+        RefreshToken string `json:"refresh_token"`
+        ExpiresIn    int    `json:"expires_in"`
+    }
+    if err := json.Unmarshal(b, &tok); err != nil || tok.AccessToken == "" { return "", errors.New("soundcloud token refresh: bad response") }
+    sc.access, sc.expires = tok.AccessToken, time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second)
+    if tok.RefreshToken != "" && tok.RefreshToken != sc.refresh {
+        sc.refresh = tok.RefreshToken
+        b, _ := json.Marshal(soundcloudToken{Seed: sc.seed, RefreshToken: sc.refresh})
+        if err := writeFileAtomic(sc.tokenFile, b); err != nil {
+            slog.ErrorContext(ctx, "keeping the new SoundCloud refresh token failed; the next restart will need a new SOUNDCLOUD_REFRESH_TOKEN", "error", err)
+        }
+    }
+    return sc.access, nil
+}
+
+// soundcloudTrack is the part of SoundCloud's answer that is kept.
+type soundcloudTrack struct {
+    ID           int64  `json:"id"`
+    PermalinkURL string `json:"permalink_url"`
+    SecretToken  string `json:"secret_token"`
+    SecretURI    string `json:"secret_uri"`
+}
+
+// link is the private link to t.
+func (t soundcloudTrack) link() string {
+    secret := t.SecretToken
+    if u, err := url.Parse(t.SecretURI); secret == "" && err == nil { secret = u.Query().Get("secret_token") }
+    if secret == "" { return t.PermalinkURL }
+    return strings.TrimSuffix(t.PermalinkURL, "/") + "/" + secret
+}
+
+// upload sends body to SoundCloud as a private track.
+func (sc *soundCloud) upload(ctx context.Context, title, name string, body io.Reader) (soundcloudTrack, error) {
+    var t soundcloudTrack
+    tok, err := sc.token(ctx)
+    if err != nil { return t, err }
+    pr, pw := io.Pipe()
+    mw := multipart.NewWriter(pw)
+    go func() {
+        for _, f := range [][2]string{{"track[title]", title}, {"track[sharing]", "private"}, {"track[downloadable]", "false"}} {
+            mw.WriteField(f[0], f[1])
+        }
+        part, err := mw.CreateFormFile("track[asset_data]", name)
+        if err == nil { _, err = io.Copy(part, body) }
+        if err == nil { err = mw.Close() }
+        pw.CloseWithError(err)
+    }()
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, soundcloudAPI+"/tracks", pr)
+    req.Header.Set("Authorization", "OAuth "+tok)
+    req.Header.Set("Content-Type", mw.FormDataContentType())
+    req.Header.Set("Accept", "application/json; charset=utf-8")
+    res, err := http.DefaultClient.Do(req)
+    pr.CloseWithError(errors.New("upload ended")) // stops the copy if SoundCloud answered early
+    if err != nil { return t, err }
+    defer res.Body.Close()
+    b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+    if res.StatusCode == http.StatusUnauthorized {
+        sc.mu.Lock(); sc.access = ""; sc.mu.Unlock()
+    }
+    if res.StatusCode/100 != 2 { return t, fmt.Errorf("soundcloud upload -> %s: %s", res.Status, truncate(string(b), 400)) }
+    if err := json.Unmarshal(b, &t); err != nil || t.PermalinkURL == "" { return t, errors.New("soundcloud upload: bad response") }
+    return t, nil
+}
+
+// GET  /api/tracks/{name}/soundcloud   what was pushed
+// POST /api/tracks/{name}/soundcloud {"artifact":{"kind":"mix","t1":"0430A","t2":"0720P"}[,"title":"..."]}[?again=1]
+func (s *Server) handleSoundCloud(w http.ResponseWriter, r *http.Request, t *Track) {
+    switch r.Method {
+    case http.MethodGet:
+        out := []Publication{}
+        for _, p := range s.publicationsFor(t.Name) {
+            if p.Service == "soundcloud" { out = append(out, p) }
+        }
+        writeJSON(w, out)
+        return
+    case http.MethodPost:
+    default:
+        http.Error(w, "GET or POST required", 405)
+        return
+    }
+    if s.soundcloud == nil { http.Error(w, "SoundCloud is not set up (SOUNDCLOUD_CLIENT_ID, SOUNDCLOUD_CLIENT_SECRET, SOUNDCLOUD_REFRESH_TOKEN)", 409); return }
+    var req struct {
+        Artifact ArtifactRef `json:"artifact"`
+        Title    string      `json:"title"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+    if !singleFile(req.Artifact) && req.Artifact.Kind != kindSnapshot { http.Error(w, "a master candidate, FINAL, mix or snapshot bounce can be pushed to SoundCloud", 400); return }
+    if err := req.Artifact.Resolve(t); err != nil { http.Error(w, err.Error(), 404); return }
+    f := req.Artifact.File(t)
+    if f == nil { http.Error(w, req.Artifact.String()+" has no WAV bounce", 404); return }
+    if req.Artifact.Kind == kindMaster && !can(r, permMasters) && !s.released(t) {
+        http.Error(w, "the "+requestKey(r).Role+" role may not "+permWhat[permMasters], 403); return
+    }
+    if r.URL.Query().Get("again") == "" {
+        for _, p := range s.publicationsFor(t.Name) {
+            if p.Service == "soundcloud" && strings.EqualFold(p.Path, f.Path) && p.ContentHash == f.ContentHash { writeJSON(w, p); return }
+        }
+    }
+    title := strings.TrimSpace(req.Title)
+    if title == "" { title = fmt.Sprintf("%s (%s)", t.Name, req.Artifact) }
+    body, err := s.dbxDownload(r.Context(), f.Path)
+    if err != nil { http.Error(w, err.Error(), 502); return }
+    defer body.Close()
+    sct, err := s.soundcloud.upload(r.Context(), title, f.Name, body)
+    if err != nil { slog.ErrorContext(r.Context(), "soundcloud upload failed", "path", f.Path, "error", err); http.Error(w, err.Error(), 502); return }
+    p := Publication{Service: "soundcloud", Artifact: req.Artifact, Path: f.Path, ContentHash: f.ContentHash, URL: sct.link(),
+        ID: fmt.Sprint(sct.ID), Title: title, By: actorOf(r), Created: time.Now().UTC()}
+    err = s.store.update(func(d *storeData) error {
+        if d.Published == nil { d.Published = map[string][]Publication{} }
+        d.Published[t.Name] = append(append([]Publication(nil), d.Published[t.Name]...), p)
+        return nil
+    })
+    if err != nil { writeError(w, err); return }
+    s.audit(r, "publish", t.Name, nil, p)
+    writeJSONStatus(w, http.StatusCreated, p)
+}
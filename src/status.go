@@ -0,0 +1,229 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// ====== CLI Status ======
+//
+// A local track folder is a working copy of the track's Dropbox folder, laid
+// out the same way (ableton/, stems/T1-T2/, mixes/, masters/). Like git
+// status, avcs status compares the two:
+//
+//   avcs status [folder] [--track MIDNIGHT]     what is only here, only on the
+//                                               server, or different
+//   avcs pull [folder] [--kind stems] [--overwrite] [--dry-run]
+//                                               download what is only on the
+//                                               server, into the layout
+//
+// The folder defaults to the current one and the track to its name. Files
+// are matched by name, wherever they sit, and compared by content hash;
+// Live backups and archived files are left out. avcs push reconciles the
+// other way.
+
+// statusStates are the states of a file, in the order status lists them.
+var statusStates = []string{"changed", "local", "remote", "blocked", "synced"}
+
+// statusEntry is a file of the working copy, the server or both.
+type statusEntry struct {
+    State  string `json:"state"` // synced, changed (here and on the server, differently), local, remote, blocked (cannot be uploaded)
+    File   string `json:"file"`  // relative to the folder: where it is, or where pull puts it
+    Name   string `json:"name,omitempty"`
+    Remote string `json:"remote,omitempty"` // its Dropbox path
+    Note   string `json:"note,omitempty"`
+
+    local string  // the file here
+    ref   FileRef // the server's
+}
+
+// remoteFiles are t's files and its branches', by lower-cased name, but for
+// backups and archived files.
+func (l *linter) remoteFiles(t *Track) (map[string]ManifestFile, error) {
+    out := map[string]ManifestFile{}
+    tracks := []*Track{t}
+    for _, b := range t.Branches {
+        bt, err := l.remote(t.Name + "." + b)
+        if err != nil { return nil, err }
+        if bt != nil { tracks = append(tracks, bt) }
+    }
+    for _, tt := range tracks {
+        for _, f := range trackFiles(tt) {
+            if f.Kind != kindBackup && !f.Archived { out[strings.ToLower(f.Name)] = f }
+        }
+    }
+    return out, nil
+}
+
+// workingStatus compares the folder root, a working copy of t, with the server.
+func (l *linter) workingStatus(root string, t *Track) ([]statusEntry, error) {
+    files, err := lintWalk(root)
+    if err != nil { return nil, err }
+    found, err := l.lint(root, files, nil)
+    if err != nil { return nil, err }
+    remote, err := l.remoteFiles(t)
+    if err != nil { return nil, err }
+
+    var out []statusEntry
+    for _, f := range found {
+        if f.local == "" { continue } // a stems set's verdict
+        e := statusEntry{File: f.File, Name: f.Name, local: f.local}
+        rf, ok := remote[strings.ToLower(f.Name)]
+        if f.Name == "" { ok = false }
+        if ok {
+            delete(remote, strings.ToLower(f.Name))
+            e.Remote, e.ref = rf.Path, rf.FileRef
+            same := f.Verdict == "uploaded"
+            if f.Verdict != "uploaded" && f.Verdict != "exists" {
+                if same, err = sameContent(f.local, rf.FileRef); err != nil { return nil, err }
+            }
+            e.State = "synced"
+            if !same { e.State, e.Note = "changed", "differs from the server's" }
+            out = append(out, e)
+            continue
+        }
+        switch f.Verdict {
+        case "rejected", "misfiled":
+            e.State, e.Note = "blocked", f.Problem
+        default:
+            e.State = "local"
+            if f.Verdict == "rename" || f.Verdict == "mismatched" { e.Note = f.Problem }
+        }
+        out = append(out, e)
+    }
+    for _, rf := range remote {
+        rel := path.Join(layoutDir(nameParts{Kind: rf.Kind, T1: rf.T1, T2: rf.T2}), rf.Name)
+        if dir := t.Dir + "/"; len(rf.Path) > len(dir) && strings.EqualFold(rf.Path[:len(dir)], dir) { rel = rf.Path[len(dir):] }
+        out = append(out, statusEntry{State: "remote", File: rel, Name: rf.Name, Remote: rf.Path, ref: rf.FileRef})
+    }
+    sort.SliceStable(out, func(i, j int) bool {
+        if out[i].State != out[j].State { return statusRank(out[i].State) < statusRank(out[j].State) }
+        return out[i].File < out[j].File
+    })
+    return out, nil
+}
+
+// statusRank is where state comes in statusStates.
+func statusRank(state string) int {
+    for i, s := range statusStates {
+        if s == state { return i }
+    }
+    return len(statusStates)
+}
+
+// workingCopy connects and finds the track the folder is a working copy of,
+// for avcs status and pull.
+func workingCopy(connect func() (*cliClient, error), root, track string) (*linter, *Track, error) {
+    if st, err := os.Stat(root); err != nil {
+        return nil, nil, err
+    } else if !st.IsDir() {
+        return nil, nil, fmt.Errorf("%s is not a folder", root)
+    }
+    c, err := connect()
+    if err != nil { return nil, nil, err }
+    l := &linter{c: c, track: strings.ToUpper(strings.TrimSpace(track)), tracks: map[string]*Track{}}
+    if l.track == "" {
+        abs, _ := filepath.Abs(root)
+        l.track = strings.TrimSuffix(strings.ToUpper(filepath.Base(abs)), " PROJECT")
+        if !rxTrackName.MatchString(l.track) { return nil, nil, fmt.Errorf("%s is not named for a track; give --track", root) }
+    }
+    t, err := l.remote(l.track)
+    if err != nil { return nil, nil, err }
+    if t == nil { return nil, nil, fmt.Errorf("%s is not on %s", l.track, c.Server) }
+    l.track = t.Name
+    return l, t, nil
+}
+
+// cliStatus is `avcs status`.
+func cliStatus(args []string) error {
+    fs := flag.NewFlagSet("status", flag.ExitOnError)
+    connect := cliFlags(fs)
+    track := fs.String("track", "", "the folder's track (default: the folder's name)")
+    all := fs.Bool("a", false, "list the files in sync too")
+    asJSON := fs.Bool("json", false, "print the status as JSON")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs status [folder] [flags]")
+        fs.PrintDefaults()
+    }
+    operands := parseCLI(fs, args)
+    if len(operands) > 1 { fs.Usage(); os.Exit(2) }
+    root := "."
+    if len(operands) == 1 { root = operands[0] }
+    l, t, err := workingCopy(connect, root, *track)
+    if err != nil { return err }
+    entries, err := l.workingStatus(root, t)
+    if err != nil { return err }
+    if *asJSON { return printJSON(entries) }
+
+    fmt.Printf("%s on %s (%s)\n", t.Name, l.c.Server, t.Dir)
+    heads := map[string]string{
+        "changed": "Changed here (avcs push --overwrite to replace the server's, avcs pull --overwrite to take it):",
+        "local":   "Not uploaded (avcs push):",
+        "remote":  "Only on the server (avcs pull):",
+        "blocked": "Cannot be uploaded (avcs lint):",
+        "synced":  "In sync:",
+    }
+    counts := map[string]int{}
+    for _, e := range entries { counts[e.State]++ }
+    for _, state := range statusStates {
+        if counts[state] == 0 || state == "synced" && !*all { continue }
+        fmt.Printf("\n%s\n", heads[state])
+        for _, e := range entries {
+            if e.State != state { continue }
+            line := "    " + e.File
+            if e.Note != "" { line += "  (" + e.Note + ")" }
+            fmt.Println(line)
+        }
+    }
+    if counts["changed"]+counts["local"]+counts["remote"]+counts["blocked"] == 0 {
+        fmt.Printf("\nUp to date: %d file%s in sync\n", counts["synced"], plural(counts["synced"]))
+    } else if !*all && counts["synced"] > 0 {
+        fmt.Printf("\n%d file%s in sync\n", counts["synced"], plural(counts["synced"]))
+    }
+    return nil
+}
+
+// cliPull is `avcs pull`.
+func cliPull(args []string) error {
+    fs := flag.NewFlagSet("pull", flag.ExitOnError)
+    connect := cliFlags(fs)
+    track := fs.String("track", "", "the folder's track (default: the folder's name)")
+    kind := fs.String("kind", "", "only this kind: session, stems, mix or master")
+    overwrite := fs.Bool("overwrite", false, "replace files changed here with the server's")
+    dryRun := fs.Bool("dry-run", false, "list what would be downloaded, and stop")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs pull [folder] [flags]")
+        fs.PrintDefaults()
+    }
+    operands := parseCLI(fs, args)
+    if len(operands) > 1 { fs.Usage(); os.Exit(2) }
+    root := "."
+    if len(operands) == 1 { root = operands[0] }
+    l, t, err := workingCopy(connect, root, *track)
+    if err != nil { return err }
+    entries, err := l.workingStatus(root, t)
+    if err != nil { return err }
+
+    k := cliKind(*kind)
+    n, kept := 0, 0
+    for _, e := range entries {
+        if e.State != "remote" && e.State != "changed" { continue }
+        if np, _ := classifyName(e.Name); k != "" && np.Kind != k { continue }
+        if e.State == "changed" && !*overwrite { kept++; continue }
+        dir := filepath.Join(root, filepath.FromSlash(path.Dir(e.File)))
+        if e.local != "" { dir = filepath.Dir(e.local) }
+        n++
+        if *dryRun { fmt.Println("Would download", filepath.Join(dir, e.Name)); continue }
+        p, err := l.c.download(e.ref, dir)
+        if err != nil { return err }
+        fmt.Println("Saved", p)
+    }
+    if kept > 0 { fmt.Fprintf(os.Stderr, "%d file%s changed here kept; --overwrite takes the server's\n", kept, plural(kept)) }
+    if n == 0 && kept == 0 { fmt.Println("Nothing to pull") }
+    return nil
+}
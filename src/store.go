@@ -0,0 +1,161 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// ====== Persistent State ======
+
+// storeData is everything the server keeps that Dropbox does not.
+type storeData struct {
+    Annotations  map[string][]Annotation  `json:"annotations,omitempty"`  // key: track
+    Tags         map[string][]ArtifactTag `json:"tags,omitempty"`         // key: track
+    Status       map[string]*TrackStatus  `json:"status,omitempty"`       // key: track
+    Locks        map[string][]Lock        `json:"locks,omitempty"`        // key: track
+    Comments     map[string][]Comment     `json:"comments,omitempty"`     // key: track
+    Ratings      map[string][]Rating      `json:"ratings,omitempty"`      // key: track
+    Deprecations map[string][]Deprecation `json:"deprecations,omitempty"` // key: track
+    Versions     map[string][]VersionTag  `json:"versions,omitempty"`     // key: track
+    Published    map[string][]Publication `json:"published,omitempty"`    // key: track
+    Releases     map[string]*Release      `json:"releases,omitempty"`     // key: release ID
+    Aliases      []TrackAlias             `json:"aliases,omitempty"`
+    Retention    []RetentionRule          `json:"retention,omitempty"`
+    AB           map[string]*ABSession    `json:"ab,omitempty"`           // key: session ID
+    Baselines    map[string]*Baseline     `json:"baselines,omitempty"`    // key: lower-case path
+    Uploads      map[string]*Upload       `json:"uploads,omitempty"`      // key: upload ID
+    Trash        map[string]*TrashItem    `json:"trash,omitempty"`        // key: trash item ID
+    Artwork      map[string]string        `json:"artwork,omitempty"`      // key: track, value: path of the primary artwork
+    APIKeys      map[string]*APIKey       `json:"api_keys,omitempty"`     // key: key ID
+    Sessions     map[string]*Session      `json:"sessions,omitempty"`     // key: sha256 of the session cookie
+    Roles        map[string]string        `json:"roles,omitempty"`        // key: signed-in user, value: role
+    Shares       map[string]*Share        `json:"shares,omitempty"`       // key: token
+    ShareStats   map[string]*ShareStats   `json:"share_stats,omitempty"`  // key: share token
+    Restrictions map[string]*Restriction  `json:"restrictions,omitempty"` // key: top-level track
+    Announced    map[string]time.Time     `json:"announced,omitempty"`    // key: what was announced, see catalogEvent
+    DigestSent   *time.Time               `json:"digest_sent,omitempty"`  // when the last email digest went out
+    Webhooks     map[string]*Webhook      `json:"webhooks,omitempty"`     // key: webhook ID
+    WebhookLog   map[string][]Delivery    `json:"webhook_log,omitempty"`  // key: webhook ID, oldest first
+    Codes        map[string]int           `json:"codes,omitempty"`        // key: ISRC or UPC range, value: the last number handed out
+    Deadlines    map[string]*Deadline     `json:"deadlines,omitempty"`    // key: deadline ID
+    Audit        []AuditEntry             `json:"audit,omitempty"`
+}
+
+// Store holds storeData in memory and persists it as one JSON document.
+// A shared store (see cluster.go) is one document that several instances
+// change: each change is made under a file lock on the latest version, and
+// reads pick up other instances' changes within storeRecheck.
+type Store struct {
+    mu   sync.RWMutex
+    path string // empty: memory only
+    data storeData
+
+    lock    string      // shared: the lock file; empty: this process only
+    seen    os.FileInfo // the document as last read or written
+    checked time.Time   // when seen was last compared to the file
+    gen     uint64      // moved on by every change, here or read from the file
+}
+
+const storeRecheck = time.Second
+
+func openStore(path string) (*Store, error) {
+    st := &Store{path: path}
+    if path == "" { return st, nil }
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil { return nil, err }
+    b, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) { return st, nil }
+    if err != nil { return nil, err }
+    if err := json.Unmarshal(b, &st.data); err != nil { return nil, fmt.Errorf("%s: %w", path, err) }
+    st.seen, _ = os.Stat(path)
+    return st, nil
+}
+
+// openSharedStore opens the document at path, shared with other instances
+// through the lock file lock.
+func openSharedStore(path, lock string) (*Store, error) {
+    unlock, err := lockFile(lock)
+    if err != nil { return nil, err }
+    defer unlock()
+    st, err := openStore(path)
+    if err != nil { return nil, err }
+    st.lock = lock
+    return st, nil
+}
+
+// reload reads the document again if another instance has changed it since;
+// st.mu is held for writing.
+func (st *Store) reload() error {
+    st.checked = time.Now()
+    fi, err := os.Stat(st.path)
+    if errors.Is(err, os.ErrNotExist) { return nil }
+    if err != nil { return err }
+    if st.seen != nil && os.SameFile(fi, st.seen) && fi.ModTime().Equal(st.seen.ModTime()) && fi.Size() == st.seen.Size() { return nil }
+    b, err := os.ReadFile(st.path)
+    if err != nil { return err }
+    var d storeData
+    if err := json.Unmarshal(b, &d); err != nil { return fmt.Errorf("%s: %w", st.path, err) }
+    st.data, st.seen = d, fi
+    st.gen++
+    return nil
+}
+
+// view runs fn with the state read-locked. fn must not retain references.
+func (st *Store) view(fn func(d *storeData)) {
+    if st.lock != "" {
+        st.mu.Lock()
+        if time.Since(st.checked) >= storeRecheck {
+            if err := st.reload(); err != nil { slog.Error("reading shared state failed", "error", err) }
+        }
+        st.mu.Unlock()
+    }
+    st.mu.RLock(); defer st.mu.RUnlock()
+    fn(&st.data)
+}
+
+// update runs fn with the state locked and persists the result if fn succeeds.
+func (st *Store) update(fn func(d *storeData) error) error {
+    st.mu.Lock(); defer st.mu.Unlock()
+    if st.lock != "" {
+        unlock, err := lockFile(st.lock)
+        if err != nil { return err }
+        defer unlock()
+        if err := st.reload(); err != nil { return err }
+    }
+    st.gen++ // before fn, which may change some of the state and then fail
+    if err := fn(&st.data); err != nil { return err }
+    return st.save()
+}
+
+// generation identifies the state as it is: it changes whenever the state
+// may have, so what was made from the state can be kept until then.
+func (st *Store) generation() uint64 {
+    var gen uint64
+    st.view(func(*storeData) { gen = st.gen })
+    return gen
+}
+
+// save writes via a temp file so a crash never leaves a truncated document.
+func (st *Store) save() error {
+    if st.path == "" { return nil }
+    b, err := json.Marshal(&st.data)
+    if err != nil { return err }
+    tmp := st.path + ".tmp"
+    if err := os.WriteFile(tmp, b, 0o600); err != nil { return err }
+    if err := os.Rename(tmp, st.path); err != nil { return err }
+    st.seen, _ = os.Stat(st.path)
+    return nil
+}
+
+func newID() string {
+    var b [8]byte
+    rand.Read(b[:])
+    return hex.EncodeToString(b[:])
+}
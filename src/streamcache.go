@@ -0,0 +1,195 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ====== Stream Cache ======
+//
+// An A/B session is two masters played over and over, each pass a fresh
+// download of a few hundred megabytes from Dropbox. So what the streaming
+// proxy serves, A/B streams and shared files, is kept on local disk in
+// DATA_DIR/stream-cache, up to STREAM_CACHE_MB (2048; 0 turns it off),
+// the files played least recently going first. A file is cached as it is
+// first streamed; when the listener stops early the rest is still fetched,
+// as they are likely to play it again. Entries are named for the file's
+// content hash, so a changed file is a new entry, and a download whose bytes
+// do not match that hash is not kept. /api/metrics counts hits and misses.
+
+const streamFillTimeout = 30 * time.Minute // to finish a download the listener left
+
+// streamCache is the files kept, by the hash of their content.
+type streamCache struct {
+    dir     string
+    max     int64 // bytes
+    mu      sync.Mutex
+    files   map[string]*streamEntry // key: file name in dir
+    size    int64
+    filling map[string]bool
+}
+
+type streamEntry struct {
+    size int64
+    used time.Time
+}
+
+func init() {
+    metricHelp["avcs_stream_cache_total"] = "Files streamed, by result: hit (from the disk cache) or miss."
+    metricHelp["avcs_stream_cache_evictions_total"] = "Files dropped from the stream cache to stay within STREAM_CACHE_MB."
+}
+
+// loadStreamCache opens the cache in dataDir, with what an earlier run
+// left there; nil when it is turned off.
+func loadStreamCache(dataDir string) (*streamCache, error) {
+    max := int64(2048) << 20
+    if v := os.Getenv("STREAM_CACHE_MB"); v != "" {
+        mb, err := strconv.Atoi(v)
+        if err != nil || mb < 0 { return nil, fmt.Errorf("STREAM_CACHE_MB must be a number of megabytes, or 0, not %q", v) }
+        max = int64(mb) << 20
+    }
+    if max == 0 { return nil, nil }
+    c := &streamCache{dir: filepath.Join(dataDir, "stream-cache"), max: max, files: map[string]*streamEntry{}, filling: map[string]bool{}}
+    if err := os.MkdirAll(c.dir, 0o700); err != nil { return nil, err }
+    des, err := os.ReadDir(c.dir)
+    if err != nil { return nil, err }
+    for _, de := range des {
+        fi, err := de.Info()
+        if err != nil || !fi.Mode().IsRegular() { continue }
+        if strings.HasSuffix(de.Name(), ".part") { os.Remove(filepath.Join(c.dir, de.Name())); continue } // cut off by a restart
+        c.files[de.Name()] = &streamEntry{size: fi.Size(), used: fi.ModTime()}
+        c.size += fi.Size()
+    }
+    return c, nil
+}
+
+// name is the entry for f: its content hash, else its path and revision.
+func (c *streamCache) name(f FileRef) string {
+    if f.ContentHash != "" { return f.ContentHash }
+    sum := sha256.Sum256([]byte(strings.ToLower(f.Path) + "@" + f.ServerModified.Format(time.RFC3339)))
+    return hex.EncodeToString(sum[:])
+}
+
+// streamDownload streams f's content, from the cache if it holds it, else
+// from Dropbox while caching it; the caller closes the body.
+func (s *Server) streamDownload(ctx context.Context, f FileRef) (io.ReadCloser, error) {
+    c := s.streamCache
+    if c == nil { return s.dbxDownload(ctx, f.Path) }
+    name := c.name(f)
+    p := filepath.Join(c.dir, name)
+    c.mu.Lock()
+    if e := c.files[name]; e != nil {
+        e.used = time.Now()
+        c.mu.Unlock()
+        if file, err := os.Open(p); err == nil {
+            os.Chtimes(p, e.used, e.used) // for the next start's order
+            s.metrics.add("avcs_stream_cache_total", 1, "result", "hit")
+            return file, nil
+        }
+        c.mu.Lock()
+        if c.files[name] == e { c.size -= e.size; delete(c.files, name) } // removed behind our back
+    }
+    fill := !c.filling[name] && f.Size <= c.max/4 // no one file crowds out the rest
+    if fill { c.filling[name] = true }
+    c.mu.Unlock()
+    s.metrics.add("avcs_stream_cache_total", 1, "result", "miss")
+    if !fill { return s.dbxDownload(ctx, f.Path) }
+
+    done := func() { c.mu.Lock(); delete(c.filling, name); c.mu.Unlock() }
+    tmp, err := os.CreateTemp(c.dir, name+".*.part")
+    if err != nil {
+        slog.WarnContext(ctx, "stream cache: cannot write", "error", err)
+        done()
+        return s.dbxDownload(ctx, f.Path)
+    }
+    // Not cancelled with the request, so that Close can finish it.
+    dctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), streamFillTimeout)
+    body, err := s.dbxDownload(dctx, f.Path)
+    if err != nil { cancel(); tmp.Close(); os.Remove(tmp.Name()); done(); return nil, err }
+    return &fillingBody{body: body, tmp: tmp, hash: newContentHasher(), f: f, finish: func(ok bool) {
+        cancel()
+        tmp.Close()
+        if ok { ok = os.Rename(tmp.Name(), p) == nil }
+        if !ok { os.Remove(tmp.Name()) }
+        if ok { s.streamCached(name, f.Size) }
+        done()
+    }}, nil
+}
+
+// streamCached records the entry just written, then drops the least
+// recently played ones beyond the cache's size.
+func (s *Server) streamCached(name string, size int64) {
+    c := s.streamCache
+    c.mu.Lock(); defer c.mu.Unlock()
+    if old := c.files[name]; old != nil { c.size -= old.size }
+    c.files[name] = &streamEntry{size: size, used: time.Now()}
+    c.size += size
+    for c.size > c.max {
+        oldest := ""
+        for k, e := range c.files {
+            if k != name && (oldest == "" || e.used.Before(c.files[oldest].used)) { oldest = k }
+        }
+        if oldest == "" { break }
+        os.Remove(filepath.Join(c.dir, oldest)) // readers still holding it keep reading
+        c.size -= c.files[oldest].size
+        delete(c.files, oldest)
+        s.metrics.add("avcs_stream_cache_evictions_total", 1)
+    }
+}
+
+// fillingBody is a download that is written to the cache as it is read.
+type fillingBody struct {
+    body   io.ReadCloser
+    tmp    *os.File
+    hash   *contentHasher
+    f      FileRef
+    n      int64
+    failed bool // the copy could not be written; it is not kept
+    closed bool
+    finish func(ok bool)
+}
+
+func (b *fillingBody) Read(p []byte) (int, error) {
+    n, err := b.body.Read(p)
+    if n > 0 && !b.failed {
+        if _, werr := b.tmp.Write(p[:n]); werr != nil { b.failed = true }
+        b.hash.Write(p[:n])
+        b.n += int64(n)
+    }
+    if err == io.EOF { b.complete() }
+    return n, err
+}
+
+// complete keeps the copy if it is the whole file, as Dropbox hashed it.
+func (b *fillingBody) complete() {
+    if b.finish == nil { return }
+    ok := !b.failed && b.n == b.f.Size && (b.f.ContentHash == "" || b.hash.Sum() == b.f.ContentHash)
+    b.finish(ok)
+    b.finish = nil
+}
+
+// Close gives the body back at once; what the listener did not hear is
+// fetched in the background to complete the copy.
+func (b *fillingBody) Close() error {
+    if b.closed { return nil }
+    b.closed = true
+    if b.finish == nil { return b.body.Close() }
+    go func() {
+        defer b.body.Close()
+        buf := make([]byte, 64<<10)
+        for b.finish != nil {
+            if _, err := b.Read(buf); err != nil && err != io.EOF { b.finish(false); b.finish = nil }
+        }
+    }()
+    return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "path"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Name Suggestions ======
+//
+// Turns whatever a bounce or export happened to be called into the name the
+// convention wants, filling in what the file name does not say from context:
+// the track's latest snapshot, stems set or mix, and the current time.
+
+var (
+    rxTrackOrBranch = regexp.MustCompile(`^` + reTrack + `$`)
+    rxTimeToken     = regexp.MustCompile(`^[0-9]{4}[AP]$`)
+    rxNoise         = regexp.MustCompile(`(^|_)(V[0-9]+|[0-9]{4}[AP]|STEMS?|BOUNCE|EXPORT|PRINT|FINAL|MASTER(ED)?|UNMASTERED|MIX)(_|$)`)
+    rxStemVocab     = regexp.MustCompile(`(^|_)(BASS|DRUMS|KICK|SNARE|PERC|VOCALS?|VOX|BGV|SYNTH|PIANO|GTR|GUITAR|FX|PAD|LEAD|SUB|ROOM|BUS)(_|$)`)
+)
+
+// timeToken formats t as a T1/T2 token: 12-hour HHMM plus A or P.
+func timeToken(t time.Time) string {
+    ap := "A"
+    if t.Hour() >= 12 { ap = "P" }
+    return t.Format("0304") + ap
+}
+
+// suggestReq is what is known about a file to be named. Only Filename is
+// required; the rest is inferred where possible.
+type suggestReq struct {
+    Filename string `json:"filename"`
+    Track    string `json:"track"`
+    Kind     string `json:"kind"` // snapshot, stem, mix, print, master
+    Time     string `json:"time"` // RFC 3339 or a T token; default now
+    TZ       string `json:"tz"`   // for Time and now; default the server's zone
+    T1       string `json:"t1"`
+    T2       string `json:"t2"`
+    Stem     string `json:"stem"`
+    Chain    string `json:"chain"` // of a print
+    Idx      string `json:"idx"`
+
+    Path  string `json:"path"`  // the file in Dropbox, to rename with apply
+    Apply bool   `json:"apply"`
+}
+
+type Suggestion = client.Suggestion
+
+// normalizedBase is the file name without extension in the convention's
+// charset: upper case, words joined by "_".
+func normalizedBase(name string) string {
+    base := strings.ToUpper(strings.TrimSuffix(name, path.Ext(name)))
+    base = strings.Map(func(r rune) rune {
+        if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' { return r }
+        return '_'
+    }, base)
+    return strings.Trim(rxSpaces.ReplaceAllString(base, "_"), "_")
+}
+
+// guessTrack finds the known track (or former name) that base starts with,
+// preferring the longest.
+func (s *Server) guessTrack(base string) string {
+    names := map[string]bool{}
+    s.mu.RLock()
+    for n := range s.tracks { names[n] = true }
+    s.mu.RUnlock()
+    for from := range s.aliasMap() { names[from] = true }
+    best := ""
+    for n := range names {
+        flat := strings.ReplaceAll(n, ".", "_")
+        if (base == flat || strings.HasPrefix(base, flat+"_")) && len(n) > len(best) { best = n }
+    }
+    return best
+}
+
+// suggestName works out the conventional name for req.
+func (s *Server) suggestName(req suggestReq) (*Suggestion, error) {
+    name := path.Base(strings.TrimSpace(req.Filename))
+    if name == "" || name == "." || name == "/" { return nil, httpError{400, "filename required"} }
+    sg := &Suggestion{}
+    assume := func(format string, args ...any) { sg.Assumed = append(sg.Assumed, fmt.Sprintf(format, args...)) }
+
+    // Names that already follow the convention, or nearly, need no context.
+    if req.Track == "" && req.Kind == "" {
+        for _, n := range []string{name, correctName(name)} {
+            if np, ok := classifyName(n); ok && np.Kind != kindBackup {
+                sg.Name, sg.Track, sg.Kind, sg.Path = n, canonicalName(s.aliasMap(), np.Track), np.Kind, s.uploadPath(np, n)
+                return sg, nil
+            }
+        }
+    }
+
+    ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+    base := normalizedBase(name)
+
+    loc := time.Local
+    if req.TZ != "" {
+        l, err := time.LoadLocation(req.TZ)
+        if err != nil { return nil, httpError{400, "unknown tz " + req.TZ} }
+        loc = l
+    }
+    now := timeToken(time.Now().In(loc))
+    switch {
+    case rxTimeToken.MatchString(strings.ToUpper(req.Time)):
+        now = strings.ToUpper(req.Time)
+    case req.Time != "":
+        t, err := time.Parse(time.RFC3339, req.Time)
+        if err != nil { return nil, httpError{400, "time must be RFC 3339 or a T token like 0430P"} }
+        now = timeToken(t.In(loc))
+    }
+
+    track := strings.ToUpper(rxSpaces.ReplaceAllString(strings.TrimSpace(req.Track), "_"))
+    if track == "" {
+        if track = s.guessTrack(base); track == "" { return nil, httpError{422, "cannot tell the track from " + name + "; give track"} }
+        assume("track %s, from the file name", track)
+    }
+    if !rxTrackOrBranch.MatchString(track) { return nil, httpError{400, "track must be [A-Z0-9_]+, optionally .BRANCH"} }
+    rest := strings.Trim(strings.TrimPrefix(base, strings.ReplaceAll(track, ".", "_")), "_")
+    track = canonicalName(s.aliasMap(), track)
+    t := s.lookupTrack(track)
+
+    kind := strings.ToLower(req.Kind)
+    if kind == "" {
+        switch {
+        case ext != "wav": kind = kindSnapshot
+        case strings.Contains(base, "UNMASTERED"): kind = kindMix
+        case strings.Contains(base, "PRINT") || req.Chain != "": kind = kindPrint
+        case strings.Contains(base, "MASTER") || strings.Contains(base, "FINAL"): kind = kindMaster
+        case rxStemVocab.MatchString(rest) || req.Stem != "": kind = kindStem
+        default: kind = kindSnapshot
+        }
+        assume("%s, from the file name", kind)
+    }
+
+    // Fill T1/T2 from the newest artifact the new file would follow on from.
+    t1, t2 := strings.ToUpper(req.T1), strings.ToUpper(req.T2)
+    latest := func(what string) {
+        if t == nil { return }
+        var when time.Time
+        var a, b string
+        switch what {
+        case kindSnapshot:
+            for _, x := range t.Ableton { if x.Latest.After(when) { when, a = x.Latest, x.T1 } }
+        case kindStems:
+            for _, x := range t.Stems { if x.Latest.After(when) { when, a, b = x.Latest, x.T1, x.T2 } }
+        case kindMix:
+            for _, x := range t.Mixes { if x.Latest.After(when) { when, a, b = x.Latest, x.T1, x.T2 } }
+        }
+        if a == "" { return }
+        if t1 == "" { t1 = a; assume("t1 %s, from the latest %s", a, what) }
+        if t2 == "" && b != "" { t2 = b; assume("t2 %s, from the latest %s", b, what) }
+    }
+    useNow := func() string {
+        if req.Time == "" { assume("time is now, %s", now) }
+        return now
+    }
+    switch kind {
+    case kindSnapshot:
+        if t1 == "" { t1 = useNow() }
+    case kindStem:
+        latest(kindSnapshot)
+        if t2 == "" { t2 = useNow() }
+    case kindMix:
+        latest(kindStems)
+    case kindPrint:
+        latest(kindMix)
+        if t1 == "" || t2 == "" { latest(kindStems) }
+    case kindMaster:
+        latest(kindMix)
+        if t1 == "" || t2 == "" { latest(kindStems) }
+    default:
+        return nil, httpError{400, "kind must be snapshot, stem, mix, print or master"}
+    }
+    if t1 == "" || kind != kindSnapshot && t2 == "" { return nil, httpError{422, "no earlier artifact to take t1/t2 from; give them"} }
+    if !rxTimeToken.MatchString(t1) || t2 != "" && !rxTimeToken.MatchString(t2) { return nil, httpError{400, "t1 and t2 must be tokens like 0430P"} }
+
+    switch kind {
+    case kindSnapshot:
+        if ext == "" { return nil, httpError{422, name + " has no extension"} }
+        name = fmt.Sprintf("%s-%s.%s", track, t1, ext)
+    case kindStem:
+        stem := strings.ToUpper(rxSpaces.ReplaceAllString(strings.TrimSpace(req.Stem), "_"))
+        if stem == "" {
+            stem = strings.Trim(rxNoise.ReplaceAllString(rxNoise.ReplaceAllString(rest, "_"), "_"), "_")
+            if stem == "" { return nil, httpError{422, "cannot tell the stem from " + req.Filename + "; give stem"} }
+            assume("stem %s, from the file name", stem)
+        }
+        name = fmt.Sprintf("%s-%s-%s-%s.wav", track, t1, t2, stem)
+    case kindMix:
+        name = fmt.Sprintf("%s-%s-%s-[unmastered].wav", track, t1, t2)
+    case kindPrint:
+        chain := strings.ToUpper(rxSpaces.ReplaceAllString(strings.TrimSpace(req.Chain), "_"))
+        if chain == "" {
+            chain = strings.Trim(rxNoise.ReplaceAllString(rxNoise.ReplaceAllString(rest, "_"), "_"), "_")
+            if chain == "" { return nil, httpError{422, "cannot tell the chain from " + req.Filename + "; give chain"} }
+            assume("chain %s, from the file name", chain)
+        }
+        name = fmt.Sprintf("%s-%s-%s-PRINT_%s.wav", track, t1, t2, chain)
+    case kindMaster:
+        idx := strings.ToUpper(req.Idx)
+        if idx == "" {
+            n := 0
+            if t != nil {
+                for _, ms := range t.Masters {
+                    if ms.T1 != t1 || ms.T2 != t2 { continue }
+                    for _, c := range ms.Candidates {
+                        if i, err := strconv.Atoi(candidateIdx(c)); err == nil && i > n { n = i }
+                    }
+                }
+            }
+            idx = strconv.Itoa(n + 1)
+            assume("candidate %s, the next free index", idx)
+        }
+        name = fmt.Sprintf("%s-%s-%s-%s.wav", track, t1, t2, idx)
+    }
+    np, ok := classifyName(name)
+    if !ok || np.Kind != kind { return nil, httpError{422, "could not make a conventional name (got " + name + ")"} }
+    sg.Name, sg.Track, sg.Kind, sg.Path = name, track, kind, s.uploadPath(np, name)
+    return sg, nil
+}
+
+// GET  /api/suggest-name?filename=Energy bass v3.wav[&track=][&kind=][&time=][&tz=][&t1=][&t2=][&stem=][&chain=][&idx=]
+// POST /api/suggest-name {"filename":"...","path":"/Tracks/ENERGY/ableton/Energy bass v3.wav","apply":true,...}
+// With apply, the file at path is renamed to the suggestion, as /api/files/rename would.
+func (s *Server) handleSuggestName(w http.ResponseWriter, r *http.Request) {
+    var req suggestReq
+    switch r.Method {
+    case http.MethodGet:
+        q := r.URL.Query()
+        req = suggestReq{Filename: q.Get("filename"), Track: q.Get("track"), Kind: q.Get("kind"), Time: q.Get("time"), TZ: q.Get("tz"),
+            T1: q.Get("t1"), T2: q.Get("t2"), Stem: q.Get("stem"), Chain: q.Get("chain"), Idx: q.Get("idx")}
+    case http.MethodPost:
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        if req.Filename == "" { req.Filename = path.Base(req.Path) }
+    default:
+        http.Error(w, "GET or POST required", 405); return
+    }
+    sg, err := s.suggestName(req)
+    if err != nil { writeError(w, err); return }
+    if !req.Apply { writeJSON(w, sg); return }
+
+    if req.Path == "" { http.Error(w, "apply needs the path of the file to rename", 400); return }
+    s.writeMu.Lock()
+    op, err := s.planRename(r.Context(), req.Path, sg.Name)
+    if err == nil { err = s.applyRename(r.Context(), op) }
+    s.writeMu.Unlock()
+    if err != nil { writeError(w, err); return }
+    s.audit(r, "rename", op.Track, map[string]string{"path": op.From}, op)
+    if err := s.reindex(r.Context()); err != nil { http.Error(w, "renamed, but reindex failed: "+err.Error(), 500); return }
+    writeJSON(w, map[string]any{"suggestion": sg, "renamed": op})
+}
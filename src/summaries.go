@@ -0,0 +1,130 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ====== Summaries and Narrowed Detail ======
+//
+// A track's detail carries every file of every version it ever had, which a
+// phone or a dashboard rarely wants. Clients can ask for less:
+//
+//   GET /api/tracks/{name}/summary            the counts and status the
+//                                             catalog lists, one track's
+//   GET /api/tracks/{name}?fields=name,mixes,masters
+//                                             only those fields of the detail
+//   GET /api/tracks/{name}?since=2024-06-01   only the snapshots, stems sets,
+//                                             mixes and master sets changed
+//                                             since (a date or RFC 3339)
+//
+// Summaries are kept once made, for the track as indexed and the state as
+// stored: a reindex or change replaces the track, and every update of the
+// store moves its generation on, either of which makes a new one.
+
+// summaryCache holds the summaries made since the store last changed.
+type summaryCache struct {
+    mu  sync.Mutex
+    gen uint64                    // the store's generation they were made at
+    m   map[string]cachedSummary // key: track
+}
+
+type cachedSummary struct {
+    track *Track // as indexed when it was made
+    sum   trackSummary
+}
+
+// summarize is t's summary, from the cache when neither t nor the state has
+// changed since it was made. Locks expire by themselves, so whether t is
+// locked is looked up every time.
+func (s *Server) summarize(t *Track) trackSummary {
+    gen := s.store.generation()
+    c := &s.summaries
+    c.mu.Lock()
+    if c.gen != gen || c.m == nil { c.gen, c.m = gen, map[string]cachedSummary{} }
+    e, ok := c.m[t.Name]
+    c.mu.Unlock()
+    if !ok || e.track != t {
+        e = cachedSummary{track: t, sum: s.makeSummary(t)}
+        c.mu.Lock()
+        if c.gen == gen { c.m[t.Name] = e }
+        c.mu.Unlock()
+    }
+    sum := e.sum
+    sum.Locked = len(s.locksFor(t.Name)) > 0
+    return sum
+}
+
+// makeSummary counts t's versions as the detail shows them.
+func (s *Server) makeSummary(t *Track) trackSummary {
+    deps := s.deprecationsFor(t.Name)
+    shown := *t
+    if !t.Archived { hideArchived(&shown) }
+    live := shown
+    hideDeprecated(&shown, deps)
+    return trackSummary{
+        Name: t.Name, Branch: t.Branch, AbletonCount: len(shown.Ableton), StemSets: len(shown.Stems), Mixes: len(shown.Mixes), Prints: len(shown.Prints), MasterSets: len(shown.Masters),
+        Deprecated: len(live.Stems) + len(live.Mixes) - len(shown.Stems) - len(shown.Mixes),
+        Branches: len(t.Branches), Aliases: t.Aliases, Conflicts: len(t.Conflicts), References: len(shown.References), Ideas: len(shown.Ideas), Lyrics: len(shown.Lyrics), Archived: t.Archived, Status: s.statusOf(t.Name).Status,
+        Restricted: s.restrictionFor(t.Name) != nil,
+    }
+}
+
+// GET /api/tracks/{name}/summary
+func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request, t *Track) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    s.mu.RLock(); sum := s.summarize(t); s.mu.RUnlock()
+    writeJSON(w, sum)
+}
+
+// trackFields are the fields of a track's detail, by their JSON names.
+var trackFields = func() []string {
+    var out []string
+    rt := reflect.TypeOf(Track{})
+    for i := 0; i < rt.NumField(); i++ {
+        if name, _, _ := strings.Cut(rt.Field(i).Tag.Get("json"), ","); name != "" && name != "-" { out = append(out, name) }
+    }
+    sort.Strings(out)
+    return out
+}()
+
+// parseFields reads ?fields=, comma-separated; none means all.
+func parseFields(v string) ([]string, error) {
+    if v == "" { return nil, nil }
+    fields := []string{"name"} // always, to tell what the reply is about
+    for _, f := range strings.Split(v, ",") {
+        f = strings.ToLower(strings.TrimSpace(f))
+        if f == "" { continue }
+        if !slices.Contains(trackFields, f) { return nil, httpError{400, "unknown field " + f + "; fields are " + strings.Join(trackFields, ", ")} }
+        fields = append(fields, f)
+    }
+    return fields, nil
+}
+
+// selectFields is t as JSON with only fields in it.
+func selectFields(t *Track, fields []string) (map[string]json.RawMessage, error) {
+    b, err := json.Marshal(t)
+    if err != nil { return nil, err }
+    var all map[string]json.RawMessage
+    if err := json.Unmarshal(b, &all); err != nil { return nil, err }
+    out := map[string]json.RawMessage{}
+    for _, f := range fields {
+        if v, ok := all[f]; ok { out[f] = v }
+    }
+    return out, nil
+}
+
+// changedSince leaves only the versions of t changed after since: its
+// snapshots, stems sets, mixes and master sets.
+func changedSince(t *Track, since time.Time) {
+    t.Ableton = slices.DeleteFunc(slices.Clone(t.Ableton), func(a AbletonSnap) bool { return !a.Latest.After(since) })
+    t.Stems = slices.DeleteFunc(slices.Clone(t.Stems), func(st StemsSet) bool { return !st.Latest.After(since) })
+    t.Mixes = slices.DeleteFunc(slices.Clone(t.Mixes), func(m Mix) bool { return !m.Latest.After(since) })
+    t.Masters = slices.DeleteFunc(slices.Clone(t.Masters), func(m MasterSet) bool { return !m.Latest.After(since) })
+}
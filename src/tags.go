@@ -0,0 +1,122 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Tags ======
+
+type ArtifactTag = client.ArtifactTag
+
+func normTag(s string) string { return strings.ToLower(strings.Join(strings.Fields(s), " ")) }
+
+// GET    /api/tracks/{name}/tags[?tag=]
+// POST   /api/tracks/{name}/tags {"artifact":{...},"tag":"..."}
+// DELETE /api/tracks/{name}/tags?kind=&t1=&t2=&idx=&tag=
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request, t *Track) {
+    q := r.URL.Query()
+    switch r.Method {
+    case http.MethodGet:
+        want := normTag(q.Get("tag"))
+        out := []ArtifactTag{}
+        for _, at := range s.tagsFor(t.Name) {
+            if want == "" || at.Tag == want { out = append(out, at) }
+        }
+        writeJSON(w, out)
+
+    case http.MethodPost:
+        var req struct {
+            Artifact ArtifactRef `json:"artifact"`
+            Tag      string      `json:"tag"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        tag := normTag(req.Tag)
+        if tag == "" { http.Error(w, "tag required", 400); return }
+        if err := req.Artifact.Resolve(t); err != nil { http.Error(w, err.Error(), 404); return }
+        at := ArtifactTag{Artifact: req.Artifact, Tag: tag, Author: actorOf(r), Created: time.Now().UTC()}
+        added := false
+        err := s.store.update(func(d *storeData) error {
+            for _, x := range d.Tags[t.Name] {
+                if x.Tag == tag && x.Artifact == req.Artifact { at = x; return nil } // already tagged
+            }
+            added = true
+            if d.Tags == nil { d.Tags = map[string][]ArtifactTag{} }
+            d.Tags[t.Name] = append(d.Tags[t.Name], at)
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        if added { s.audit(r, "tag", t.Name, nil, at) }
+        writeJSON(w, at)
+
+    case http.MethodDelete:
+        ref := ArtifactRef{Kind: q.Get("kind"), T1: q.Get("t1"), T2: q.Get("t2"), Idx: q.Get("idx")}
+        tag := normTag(q.Get("tag"))
+        if ref.Kind == "" || tag == "" { http.Error(w, "kind and tag required", 400); return }
+        removed := 0
+        err := s.store.update(func(d *storeData) error {
+            list := d.Tags[t.Name][:0]
+            for _, x := range d.Tags[t.Name] {
+                if x.Tag == tag && x.Artifact == ref { removed++; continue }
+                list = append(list, x)
+            }
+            d.Tags[t.Name] = list
+            return nil
+        })
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        if removed == 0 { http.Error(w, "tag not found", 404); return }
+        s.audit(r, "untag", t.Name, ArtifactTag{Artifact: ref, Tag: tag}, nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "GET, POST or DELETE required", 405)
+    }
+}
+
+// handleAllTags lists every tag in use with how many artifacts carry it.
+func (s *Server) handleAllTags(w http.ResponseWriter, r *http.Request) {
+    type count struct {
+        Tag       string `json:"tag"`
+        Artifacts int    `json:"artifacts"`
+        Tracks    int    `json:"tracks"`
+    }
+    counts := map[string]*count{}
+    hidden := s.hiddenFrom(r)
+    s.store.view(func(d *storeData) {
+        for track, list := range d.Tags {
+            if hidden[track] { continue }
+            seen := map[string]bool{}
+            for _, at := range list {
+                c := counts[at.Tag]
+                if c == nil { c = &count{Tag: at.Tag}; counts[at.Tag] = c }
+                c.Artifacts++
+                if !seen[at.Tag] { seen[at.Tag] = true; c.Tracks++ }
+            }
+        }
+    })
+    out := []count{}
+    for _, c := range counts { out = append(out, *c) }
+    sort.Slice(out, func(i, j int) bool { return out[i].Tag < out[j].Tag })
+    writeJSON(w, out)
+}
+
+func (s *Server) tagsFor(track string) []ArtifactTag {
+    var out []ArtifactTag
+    s.store.view(func(d *storeData) { out = append(out, d.Tags[track]...) })
+    return out
+}
+
+// trackHasTags reports whether the track has at least one artifact carrying every tag.
+func (s *Server) trackHasTags(track string, tags []string) bool {
+    have := map[string]bool{}
+    for _, at := range s.tagsFor(track) { have[at.Tag] = true }
+    for _, tag := range tags {
+        if !have[normTag(tag)] { return false }
+    }
+    return true
+}
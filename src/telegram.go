@@ -0,0 +1,380 @@
+package main
+
+import (
+    "bytes"
+    "cmp"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "html"
+    "io"
+    "log/slog"
+    "mime/multipart"
+    "net/http"
+    "os"
+    "path"
+    "regexp"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ====== Telegram ======
+//
+// For band members who will never open the browser, a Telegram bot
+// (TELEGRAM_BOT_TOKEN, a secret, from @BotFather) posts new FINALs and
+// deadline reminders to the chats in TELEGRAM_CHATS (their IDs,
+// comma-separated; TELEGRAM_EVENTS picks other kinds too) and answers them:
+//
+//   latest master of MIDNIGHT?   /master MIDNIGHT   the latest FINAL, or candidate
+//   latest mix of MIDNIGHT       /mix MIDNIGHT      also session, stems
+//   status of MIDNIGHT           /status MIDNIGHT
+//   play MIDNIGHT                /play MIDNIGHT     sends the audio: the FINAL,
+//   play the mix of MIDNIGHT                        else the latest mix, else the
+//                                                  latest bounce (MP3 first)
+//   /tracks, /help
+//
+// Track names may be typed loosely ("midnight", "neon rain") if they match
+// one track. The bot acts with TELEGRAM_ROLE (default producer), so a viewer
+// bot keeps unreleased masters to itself; files over Telegram's 50 MB get a
+// share link instead (with PUBLIC_URL). Chats that are not listed are told
+// their ID, to add them. Only the leader polls for messages.
+
+var telegramAPI = "https://api.telegram.org"
+
+// telegramMaxUpload is the largest file a bot may send.
+const telegramMaxUpload = 50 << 20
+
+type telegramBot struct {
+    token  string
+    chats  []int64
+    events []string
+    role   string
+    client *http.Client
+    upload *http.Client // for sending audio, which takes longer
+}
+
+// loadTelegram reads the Telegram settings; nil if there are none.
+func loadTelegram() (*telegramBot, error) {
+    token, err := readSecret("TELEGRAM_BOT_TOKEN")
+    if err != nil || token == "" { return nil, err }
+    tg := &telegramBot{token: token, role: strings.ToLower(cmp.Or(os.Getenv("TELEGRAM_ROLE"), roleProducer)), client: &http.Client{Timeout: 90 * time.Second}, upload: &http.Client{Timeout: 10 * time.Minute}}
+    if !slices.Contains(roleNames, tg.role) { return nil, fmt.Errorf("TELEGRAM_ROLE must be one of %s", strings.Join(roleNames, ", ")) }
+    for _, c := range strings.Split(os.Getenv("TELEGRAM_CHATS"), ",") {
+        if c = strings.TrimSpace(c); c == "" { continue }
+        id, err := strconv.ParseInt(c, 10, 64)
+        if err != nil { return nil, fmt.Errorf("TELEGRAM_CHATS: %q is not a chat ID", c) }
+        tg.chats = append(tg.chats, id)
+    }
+    tg.events, err = eventKindList("TELEGRAM_EVENTS", os.Getenv("TELEGRAM_EVENTS"), []string{eventFinal, eventDeadline})
+    return tg, err
+}
+
+func (tg *telegramBot) name() string { return "telegram" }
+
+func (tg *telegramBot) wants(kind string) bool { return len(tg.chats) > 0 && slices.Contains(tg.events, kind) }
+
+// notify posts e to every chat.
+func (tg *telegramBot) notify(ctx context.Context, e catalogEvent) error {
+    icon := map[string]string{eventCandidate: "🎚", eventFinal: "🏆", eventStems: "🎛", eventMix: "🎧", eventDeadline: "⏰"}[e.kind]
+    var b strings.Builder
+    fmt.Fprintf(&b, "%s <b>%s</b>", icon, html.EscapeString(e.title()))
+    var f FileRef
+    if len(e.files) > 0 { f = e.files[0] }
+    if e.kind != eventStems { fmt.Fprintf(&b, "\n<code>%s</code>", html.EscapeString(f.Name)) }
+    if e.lufs != nil { fmt.Fprintf(&b, " · %.1f LUFS", *e.lufs) }
+    if f.ContributedBy != "" { fmt.Fprintf(&b, " · by %s", html.EscapeString(f.ContributedBy)) }
+    if e.link != "" { fmt.Fprintf(&b, "\n<a href=\"%s\">▶ Listen</a>", html.EscapeString(e.link)) }
+    var errs []error
+    for _, chat := range tg.chats {
+        if err := tg.send(ctx, chat, 0, b.String()); err != nil { errs = append(errs, fmt.Errorf("chat %d: %w", chat, err)) }
+    }
+    return errors.Join(errs...)
+}
+
+// call makes a Bot API call with a JSON body and decodes its result.
+func (tg *telegramBot) call(ctx context.Context, method string, params, out any) error {
+    b, _ := json.Marshal(params)
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, telegramAPI+"/bot"+tg.token+"/"+method, bytes.NewReader(b))
+    req.Header.Set("Content-Type", "application/json")
+    return tg.do(tg.client, req, out)
+}
+
+// do sends req and unwraps the Bot API's {ok, result} answer.
+func (tg *telegramBot) do(client *http.Client, req *http.Request, out any) error {
+    res, err := client.Do(req)
+    if err != nil { return errors.New(strings.ReplaceAll(err.Error(), tg.token, "…")) } // the token is in the URL
+    defer res.Body.Close()
+    var reply struct {
+        OK          bool            `json:"ok"`
+        Description string          `json:"description"`
+        Result      json.RawMessage `json:"result"`
+    }
+    if err := json.NewDecoder(io.LimitReader(res.Body, 8<<20)).Decode(&reply); err != nil { return fmt.Errorf("telegram -> %s", res.Status) }
+    if !reply.OK { return errors.New("telegram: " + reply.Description) }
+    if out == nil { return nil }
+    return json.Unmarshal(reply.Result, out)
+}
+
+// send posts HTML text to chat, as a reply if replyTo is set.
+func (tg *telegramBot) send(ctx context.Context, chat, replyTo int64, text string) error {
+    msg := map[string]any{"chat_id": chat, "text": text, "parse_mode": "HTML", "disable_web_page_preview": true}
+    if replyTo != 0 { msg["reply_parameters"] = map[string]any{"message_id": replyTo, "allow_sending_without_reply": true} }
+    return tg.call(ctx, "sendMessage", msg, nil)
+}
+
+// sendFile uploads body to chat: MP3s as audio, anything else as a document.
+func (tg *telegramBot) sendFile(ctx context.Context, chat, replyTo int64, name, title, caption string, body io.Reader) error {
+    method, field := "sendDocument", "document"
+    if strings.EqualFold(path.Ext(name), ".mp3") { method, field = "sendAudio", "audio" }
+    pr, pw := io.Pipe()
+    mw := multipart.NewWriter(pw)
+    go func() {
+        fields := [][2]string{{"chat_id", fmt.Sprint(chat)}, {"caption", caption}, {"parse_mode", "HTML"}}
+        if field == "audio" { fields = append(fields, [2]string{"title", title}) }
+        if replyTo != 0 { fields = append(fields, [2]string{"reply_parameters", fmt.Sprintf(`{"message_id":%d,"allow_sending_without_reply":true}`, replyTo)}) }
+        for _, f := range fields { mw.WriteField(f[0], f[1]) }
+        part, err := mw.CreateFormFile(field, name)
+        if err == nil { _, err = io.Copy(part, body) }
+        if err == nil { err = mw.Close() }
+        pw.CloseWithError(err)
+    }()
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, telegramAPI+"/bot"+tg.token+"/"+method, pr)
+    req.Header.Set("Content-Type", mw.FormDataContentType())
+    err := tg.do(tg.upload, req, nil)
+    pr.CloseWithError(errors.New("upload ended"))
+    return err
+}
+
+// telegramUpdate is the part of a Bot API update that is read.
+type telegramUpdate struct {
+    UpdateID int64 `json:"update_id"`
+    Message  *struct {
+        MessageID int64  `json:"message_id"`
+        Text      string `json:"text"`
+        Chat      struct {
+            ID int64 `json:"id"`
+        } `json:"chat"`
+        From *struct {
+            Username string `json:"username"`
+        } `json:"from"`
+    } `json:"message"`
+}
+
+// pollTelegram answers messages to the bot, on the leader.
+func (s *Server) pollTelegram() {
+    tg := s.telegram
+    var offset int64
+    for {
+        if !s.leading() { time.Sleep(30 * time.Second); continue }
+        var updates []telegramUpdate
+        ctx, cancel := context.WithTimeout(context.Background(), 80*time.Second)
+        err := tg.call(ctx, "getUpdates", map[string]any{"offset": offset, "timeout": 50, "allowed_updates": []string{"message"}}, &updates)
+        cancel()
+        if err != nil {
+            slog.Warn("telegram polling failed", "error", err)
+            time.Sleep(15 * time.Second)
+            continue
+        }
+        for _, u := range updates {
+            offset = u.UpdateID + 1
+            if m := u.Message; m != nil && m.Text != "" {
+                go runJob(context.Background(), "telegram", func(ctx context.Context) {
+                    if err := s.answerTelegram(ctx, m.Chat.ID, m.MessageID, m.Text); err != nil {
+                        slog.ErrorContext(ctx, "answering on telegram failed", "chat", m.Chat.ID, "error", err)
+                    }
+                })
+            }
+        }
+    }
+}
+
+var (
+    rxTgCommand = regexp.MustCompile(`^/(\w+)(?:@\w+)?\s*(.*)$`)
+    rxTgLatest  = regexp.MustCompile(`(?i)^(?:what(?:'s| is)\s+)?(?:the\s+)?(?:latest|last|newest|current)\s+(master|final|mix|session|snapshot|stems)\s+(?:of|for|on)\s+(.+?)\s*\??$`)
+    rxTgStatus  = regexp.MustCompile(`(?i)^(?:what(?:'s| is)\s+)?(?:the\s+)?status\s+(?:of|for|on)\s+(.+?)\s*\??$`)
+    rxTgPlay    = regexp.MustCompile(`(?i)^(?:play|send|preview)\s+(?:me\s+)?(?:the\s+)?(?:(master|final|mix|bounce)\s+(?:of|for)\s+)?(.+?)\s*\??$`)
+)
+
+// parseTelegram reads a message as a verb (latest, status, play, tracks or
+// help), the kind of artifact asked for and the track name.
+func parseTelegram(text string) (verb, kind, name string) {
+    text = strings.TrimSpace(text)
+    if m := rxTgCommand.FindStringSubmatch(text); m != nil {
+        cmd, arg := strings.ToLower(m[1]), strings.TrimSpace(m[2])
+        switch cmd {
+        case "master", "final", "mix", "session", "stems": return "latest", cmd, arg
+        case "status", "tracks": return cmd, "", arg
+        case "play", "preview": return "play", "", arg
+        }
+        return "help", "", ""
+    }
+    if m := rxTgLatest.FindStringSubmatch(text); m != nil { return "latest", strings.ToLower(m[1]), m[2] }
+    if m := rxTgStatus.FindStringSubmatch(text); m != nil { return "status", "", m[1] }
+    if m := rxTgPlay.FindStringSubmatch(text); m != nil { return "play", strings.ToLower(m[1]), m[2] }
+    return "help", "", ""
+}
+
+const telegramHelp = `Ask me about a track:
+latest master of MIDNIGHT?  (/master MIDNIGHT)
+latest mix of MIDNIGHT  (/mix, /session, /stems)
+status of MIDNIGHT  (/status MIDNIGHT)
+play MIDNIGHT, play the mix of MIDNIGHT  (/play MIDNIGHT)
+/tracks lists them all.`
+
+// telegramTrack finds the track a chat means: its name or alias, typed in
+// any case with spaces for underscores, or the one track it starts or is
+// part of.
+func (s *Server) telegramTrack(name string) *Track {
+    want := strings.ToUpper(strings.Join(strings.Fields(strings.Trim(name, `"'`)), "_"))
+    if want == "" { return nil }
+    if t := s.lookupTrack(want); t != nil { return t }
+    fold := strings.NewReplacer(".", "_", "-", "_").Replace // GLASS_HOUSE.RADIO_EDIT is "glass house radio"
+    want = fold(want)
+    s.mu.RLock(); defer s.mu.RUnlock()
+    for _, match := range []func(string) bool{
+        func(n string) bool { return strings.HasPrefix(n, want) },
+        func(n string) bool { return strings.Contains(n, want) },
+    } {
+        var found []*Track
+        for n, t := range s.tracks {
+            if !t.Archived && match(fold(n)) { found = append(found, t) }
+        }
+        if len(found) == 1 { return found[0] }
+    }
+    return nil
+}
+
+// answerTelegram answers one message from chat.
+func (s *Server) answerTelegram(ctx context.Context, chat, msgID int64, text string) error {
+    tg := s.telegram
+    if !slices.Contains(tg.chats, chat) {
+        return tg.send(ctx, chat, msgID, fmt.Sprintf("This chat may not ask me anything. Its ID is <code>%d</code>; add it to TELEGRAM_CHATS.", chat))
+    }
+    verb, kind, name := parseTelegram(text)
+    reply := func(text string) error { return tg.send(ctx, chat, msgID, text) }
+    switch verb {
+    case "help": return reply(html.EscapeString(telegramHelp))
+    case "tracks": return reply(s.telegramTracks())
+    }
+    t := s.telegramTrack(name)
+    if t == nil { return reply(fmt.Sprintf("I don't know a track called %s. /tracks lists them.", html.EscapeString(cmp.Or(name, "that")))) }
+    shown := *t
+    if !t.Archived { hideArchived(&shown) }
+    t = &shown
+    title := "<b>" + html.EscapeString(t.Name) + "</b>"
+    if verb == "status" {
+        st := s.statusOf(t.Name)
+        out := fmt.Sprintf("%s is <b>%s</b>", title, html.EscapeString(st.Status))
+        if !st.Since.IsZero() { out += " since " + st.Since.Local().Format("Jan 2") }
+        if st.By != "" { out += " (" + html.EscapeString(st.By) + ")" }
+        return reply(out)
+    }
+
+    a, f, err := s.telegramPick(t, verb, kind)
+    if err != nil { return reply(title + ": " + html.EscapeString(err.Error())) }
+    if a.Kind == kindMaster && !roleCan(tg.role, permMasters) && !s.released(t) {
+        return reply(title + ": the " + tg.role + " role may not " + permWhat[permMasters] + ".")
+    }
+    caption := fmt.Sprintf("%s · %s\n<code>%s</code> · %s", title, html.EscapeString(a.String()), html.EscapeString(f.Name), f.ServerModified.Local().Format("Jan 2 15:04"))
+    s.alsMu.Lock(); lufs, ok := s.loudCache[f.Path+"@"+f.ServerModified.String()]; s.alsMu.Unlock()
+    if ok { caption += fmt.Sprintf(" · %.1f LUFS", lufs) }
+    if f.ContributedBy != "" { caption += " · by " + html.EscapeString(f.ContributedBy) }
+    if a.Kind == kindStems || (kind == "session" || kind == "snapshot") && !roleCan(tg.role, permStems) {
+        return reply(caption) // no link to fetch them with
+    }
+    if verb == "play" && f.Size <= telegramMaxUpload {
+        body, err := s.dbxDownload(ctx, f.Path)
+        if err != nil { return err }
+        defer body.Close()
+        return tg.sendFile(ctx, chat, msgID, f.Name, releaseTitle(t.Name)+" ("+a.String()+")", caption, body)
+    }
+    if link := s.botShare(ctx, t.Name, a); link != "" { caption += fmt.Sprintf("\n<a href=\"%s\">▶ Listen</a>", html.EscapeString(link)) }
+    if verb == "play" { caption += "\n(too big to send here)" }
+    return reply(caption)
+}
+
+// telegramPick is the artifact a question is about, and its file.
+func (s *Server) telegramPick(t *Track, verb, kind string) (ArtifactRef, *FileRef, error) {
+    var master, candidate, mix, bounce, mp3 *FileRef
+    var masterA, candidateA, mixA, bounceA ArtifactRef
+    for i := range t.Masters {
+        m := &t.Masters[i]
+        if m.Final != nil && (master == nil || m.Final.ServerModified.After(master.ServerModified)) {
+            master, masterA = m.Final, ArtifactRef{Kind: kindMaster, T1: m.T1, T2: m.T2, Idx: "FINAL"}
+        }
+        for j := range m.Candidates {
+            c := &m.Candidates[j]
+            if candidate == nil || c.ServerModified.After(candidate.ServerModified) {
+                candidate, candidateA = c, ArtifactRef{Kind: kindMaster, T1: m.T1, T2: m.T2, Idx: candidateIdx(*c)}
+            }
+        }
+    }
+    for i := range t.Mixes {
+        m := &t.Mixes[i]
+        if mix == nil || m.File.ServerModified.After(mix.ServerModified) { mix, mixA = &m.File, ArtifactRef{Kind: kindMix, T1: m.T1, T2: m.T2} }
+    }
+    snaps := slices.Clone(t.Ableton)
+    sort.Slice(snaps, func(i, j int) bool { return snaps[i].Latest.After(snaps[j].Latest) })
+    for _, snap := range snaps {
+        if snap.WAV == nil && snap.MP3 == nil { continue }
+        bounce, mp3, bounceA = snap.WAV, snap.MP3, ArtifactRef{Kind: kindSnapshot, T1: snap.T1}
+        break
+    }
+    if mp3 != nil { bounce = mp3 } // smaller, and plays in the chat
+    if master == nil { master, masterA = candidate, candidateA }
+
+    switch kind {
+    case "master", "final":
+        if master == nil { return ArtifactRef{}, nil, errors.New("no masters yet") }
+        return masterA, master, nil
+    case "mix":
+        if mix == nil { return ArtifactRef{}, nil, errors.New("no mixes yet") }
+        return mixA, mix, nil
+    case "bounce":
+        if bounce == nil { return ArtifactRef{}, nil, errors.New("no bounces yet") }
+        return bounceA, bounce, nil
+    case "session", "snapshot":
+        if len(snaps) == 0 { return ArtifactRef{}, nil, errors.New("no sessions yet") }
+        snap := snaps[0]
+        f := cmp.Or(snap.ALS, snap.Session, snap.WAV, snap.MP3)
+        return ArtifactRef{Kind: kindSnapshot, T1: snap.T1}, f, nil
+    case "stems":
+        var latest *StemsSet
+        for i := range t.Stems {
+            if len(t.Stems[i].Stems) > 0 && (latest == nil || t.Stems[i].Latest.After(latest.Latest)) { latest = &t.Stems[i] }
+        }
+        if latest == nil { return ArtifactRef{}, nil, errors.New("no stems yet") }
+        f := latest.Stems[0]
+        f.Name = fmt.Sprintf("%d stems", len(latest.Stems))
+        return ArtifactRef{Kind: kindStems, T1: latest.T1, T2: latest.T2}, &f, nil
+    }
+    // play, with nothing named: the FINAL, else the latest mix, else a bounce
+    switch {
+    case master != nil && masterA.Idx == "FINAL" && (roleCan(s.telegram.role, permMasters) || s.released(t)): return masterA, master, nil
+    case mix != nil: return mixA, mix, nil
+    case bounce != nil: return bounceA, bounce, nil
+    }
+    return ArtifactRef{}, nil, errors.New("nothing to play yet")
+}
+
+// telegramTracks lists the tracks and their status.
+func (s *Server) telegramTracks() string {
+    s.mu.RLock()
+    var names []string
+    for n, t := range s.tracks {
+        if !t.Archived { names = append(names, n) }
+    }
+    s.mu.RUnlock()
+    sort.Strings(names)
+    var b strings.Builder
+    for i, n := range names {
+        if i == 60 { fmt.Fprintf(&b, "… and %d more", len(names)-i); break }
+        fmt.Fprintf(&b, "<b>%s</b> · %s\n", html.EscapeString(n), html.EscapeString(s.statusOf(n).Status))
+    }
+    if len(names) == 0 { return "No tracks yet." }
+    return b.String()
+}
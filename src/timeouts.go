@@ -0,0 +1,106 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// ====== Timeouts ======
+//
+// Every request gets a deadline, so a Dropbox call that never answers
+// cannot hold a connection and its goroutine forever:
+//
+//   REQUEST_TIMEOUT       most requests (default 1m)
+//   LONG_REQUEST_TIMEOUT  transfers and bulk work: uploads, bundles, A/B
+//                         streams, shared files, release packages and DDP
+//                         images, reindex, verify, migrate, archive,
+//                         retention apply, backup, restore, SoundCloud
+//                         pushes, catalog syncs and CPU profiles
+//                         (default 30m)
+//
+// The deadline cancels the request's context, which the Dropbox calls it
+// makes are bound to, and is set on the connection too, for reading the body
+// and writing the response. A request that runs out answers 504 if it had
+// not answered yet, and is logged. Headers must arrive within
+// headerTimeout and idle keep-alive connections are closed after
+// idleTimeout. Dropbox calls outside requests (background jobs) fail when
+// Dropbox does not start answering within dbxHeaderTimeout.
+
+const (
+    headerTimeout    = 10 * time.Second
+    idleTimeout      = 2 * time.Minute
+    dbxHeaderTimeout = time.Minute
+    answerGrace      = 5 * time.Second
+)
+
+// timeouts are the request deadlines.
+type timeouts struct {
+    normal, long time.Duration
+}
+
+// loadTimeouts reads REQUEST_TIMEOUT and LONG_REQUEST_TIMEOUT.
+func loadTimeouts() (timeouts, error) {
+    t := timeouts{normal: time.Minute, long: 30 * time.Minute}
+    for _, v := range []struct {
+        name string
+        d    *time.Duration
+    }{{"REQUEST_TIMEOUT", &t.normal}, {"LONG_REQUEST_TIMEOUT", &t.long}} {
+        s := os.Getenv(v.name)
+        if s == "" { continue }
+        d, err := time.ParseDuration(s)
+        if err != nil || d <= 0 { return t, errors.New(v.name + " must be a duration like 1m, not " + s) }
+        *v.d = d
+    }
+    if t.long < t.normal { t.long = t.normal }
+    return t, nil
+}
+
+// longRequest reports whether r moves file data or does bulk work.
+func longRequest(r *http.Request) bool {
+    p := strings.TrimSuffix(r.URL.Path, "/")
+    switch {
+    case fetchPath(p) && p != "/api/link",
+        p == "/api/upload", strings.HasPrefix(p, "/api/uploads"),
+        p == "/api/reindex", p == "/api/verify", p == "/api/migrate", p == "/api/retention/apply",
+        strings.HasPrefix(p, "/api/tracks/") && (strings.HasSuffix(p, "/archive") || strings.HasSuffix(p, "/soundcloud")),
+        p == "/api/admin/backup", p == "/api/admin/restore", p == "/api/admin/sync",
+        strings.HasPrefix(p, "/api/releases/") && (strings.HasSuffix(p, "/package") || strings.HasSuffix(p, "/ddp")),
+        p == "/api/debug/pprof/profile", p == "/api/debug/pprof/trace":
+        return true
+    }
+    return false
+}
+
+// withTimeouts gives each request its deadline.
+func (s *Server) withTimeouts(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        d := s.timeouts.normal
+        if longRequest(r) { d = s.timeouts.long }
+        deadline := time.Now().Add(d)
+        rc := http.NewResponseController(w)
+        rc.SetReadDeadline(deadline)
+        rc.SetWriteDeadline(deadline.Add(answerGrace)) // time left to say it timed out
+        ctx, cancel := context.WithDeadline(r.Context(), deadline)
+        defer cancel()
+        tw := &timeoutWriter{ResponseWriter: w}
+        next.ServeHTTP(tw, r.WithContext(ctx))
+        if ctx.Err() != context.DeadlineExceeded { return }
+        slog.WarnContext(ctx, "request timed out", "method", r.Method, "path", r.URL.Path, "timeout", d.String())
+        if !tw.wrote { http.Error(w, "the request took longer than "+d.String(), http.StatusGatewayTimeout) }
+    })
+}
+
+// timeoutWriter notes whether the response was started.
+type timeoutWriter struct {
+    http.ResponseWriter
+    wrote bool
+}
+
+func (w *timeoutWriter) WriteHeader(code int) { w.wrote = true; w.ResponseWriter.WriteHeader(code) }
+func (w *timeoutWriter) Write(b []byte) (int, error) { w.wrote = true; return w.ResponseWriter.Write(b) }
+func (w *timeoutWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,419 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "log/slog"
+    "math/big"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ====== TLS ======
+//
+// The server can speak HTTPS itself, for a bare VPS without a reverse proxy:
+// with TLS_CERT_FILE and TLS_KEY_FILE, which are read again when they change
+// (say, after certbot renews them), or with certificates it gets itself from
+// Let's Encrypt for ACME_DOMAINS. ACME proves control of a name with the
+// http-01 challenge, so ACME_HTTP_ADDR (:80) must be reachable from the
+// internet under every name; it redirects everything else there to HTTPS.
+// Certificates and the ACME account key are kept in DATA_DIR/acme, and
+// renewed 30 days before they expire. ACME_EMAIL is given to the CA for
+// expiry notices; ACME_DIRECTORY picks another CA, such as Let's Encrypt's
+// staging one. With either, BIND_ADDR defaults to :443.
+
+const (
+    letsEncryptURL  = "https://acme-v02.api.letsencrypt.org/directory"
+    acmeRenewBefore = 30 * 24 * time.Hour
+    acmeWait        = 2 * time.Minute // for the CA to validate or issue
+)
+
+// tlsSource is where the server's certificates come from.
+type tlsSource struct {
+    files    *certFiles
+    acme     *acmeManager
+    httpAddr string // ACME challenges and the redirect to HTTPS
+}
+
+// loadTLS reads the TLS settings; nil if the server is to speak plain HTTP.
+func loadTLS(dataDir string) (*tlsSource, error) {
+    certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+    domains := splitList(os.Getenv("ACME_DOMAINS"))
+    switch {
+    case certFile != "" && len(domains) > 0:
+        return nil, errors.New("set TLS_CERT_FILE and TLS_KEY_FILE, or ACME_DOMAINS, not both")
+    case certFile != "" || keyFile != "":
+        if certFile == "" || keyFile == "" { return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE go together") }
+        f := &certFiles{certFile: certFile, keyFile: keyFile}
+        if _, err := f.getCertificate(nil); err != nil { return nil, err }
+        return &tlsSource{files: f}, nil
+    case len(domains) > 0:
+        m := &acmeManager{
+            directory: os.Getenv("ACME_DIRECTORY"),
+            email:     os.Getenv("ACME_EMAIL"),
+            domains:   domains,
+            dir:       filepath.Join(dataDir, "acme"),
+            client:    &http.Client{Timeout: 30 * time.Second},
+            certs:     map[string]*tls.Certificate{},
+            tokens:    map[string]string{},
+        }
+        if m.directory == "" { m.directory = letsEncryptURL }
+        httpAddr := os.Getenv("ACME_HTTP_ADDR")
+        if httpAddr == "" { httpAddr = ":80" }
+        return &tlsSource{acme: m, httpAddr: httpAddr}, nil
+    }
+    return nil, nil
+}
+
+func (t *tlsSource) config() *tls.Config {
+    cfg := &tls.Config{MinVersion: tls.VersionTLS12, NextProtos: []string{"h2", "http/1.1"}}
+    if t.files != nil { cfg.GetCertificate = t.files.getCertificate } else { cfg.GetCertificate = t.acme.getCertificate }
+    return cfg
+}
+
+// serve runs srv over TLS, with the ACME side listener if there is one.
+func (t *tlsSource) serve(srv *http.Server) error {
+    srv.TLSConfig = t.config()
+    if t.acme != nil {
+        go func() {
+            slog.Info("serving ACME challenges and HTTPS redirects", "addr", t.httpAddr)
+            log.Fatal(http.ListenAndServe(t.httpAddr, t.acme.httpHandler(srv.Addr)))
+        }()
+        go t.acme.renewLoop()
+    }
+    return srv.ListenAndServeTLS("", "")
+}
+
+// certFiles is a certificate and key on disk, reloaded when the certificate
+// file changes.
+type certFiles struct {
+    certFile, keyFile string
+
+    mu      sync.Mutex
+    cert    *tls.Certificate
+    mod     time.Time
+    checked time.Time
+}
+
+func (f *certFiles) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    f.mu.Lock(); defer f.mu.Unlock()
+    if f.cert != nil && time.Since(f.checked) < time.Minute { return f.cert, nil }
+    f.checked = time.Now()
+    st, err := os.Stat(f.certFile)
+    if err == nil && f.cert != nil && !st.ModTime().After(f.mod) { return f.cert, nil }
+    var c tls.Certificate
+    if err == nil { c, err = tls.LoadX509KeyPair(f.certFile, f.keyFile) }
+    if err != nil {
+        if f.cert != nil { slog.Warn("reloading TLS certificate failed; keeping the loaded one", "error", err); return f.cert, nil }
+        return nil, err
+    }
+    f.cert, f.mod = &c, st.ModTime()
+    return f.cert, nil
+}
+
+// acmeManager gets and renews a certificate per domain from an ACME CA
+// (RFC 8555).
+type acmeManager struct {
+    directory string
+    email     string
+    domains   []string
+    dir       string
+    client    *http.Client
+
+    orderMu sync.Mutex // one order at a time; guards the account fields below
+    key     *ecdsa.PrivateKey
+    kid     string
+    urls    struct{ NewNonce, NewAccount, NewOrder string }
+    nonce   string
+
+    mu     sync.RWMutex
+    certs  map[string]*tls.Certificate // key: domain
+    tokens map[string]string           // http-01 token -> key authorization
+}
+
+// acmeProblem is an ACME error document (RFC 7807).
+type acmeProblem struct {
+    Type   string `json:"type"`
+    Detail string `json:"detail"`
+}
+
+func (p acmeProblem) Error() string { return strings.TrimPrefix(p.Type, "urn:ietf:params:acme:error:") + ": " + p.Detail }
+
+type acmeChallenge struct {
+    Type   string       `json:"type"`
+    URL    string       `json:"url"`
+    Token  string       `json:"token"`
+    Status string       `json:"status"`
+    Error  *acmeProblem `json:"error"`
+}
+
+type acmeAuthz struct {
+    Status     string          `json:"status"`
+    Challenges []acmeChallenge `json:"challenges"`
+}
+
+type acmeOrder struct {
+    Status         string       `json:"status"`
+    Authorizations []string     `json:"authorizations"`
+    Finalize       string       `json:"finalize"`
+    Certificate    string       `json:"certificate"`
+    Error          *acmeProblem `json:"error"`
+}
+
+// getCertificate serves a domain's certificate, getting one first if need be.
+func (m *acmeManager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+    name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+    if !slices.Contains(m.domains, name) { return nil, fmt.Errorf("no certificate for %q", hello.ServerName) }
+    m.mu.RLock()
+    c := m.certs[name]
+    m.mu.RUnlock()
+    if c != nil && time.Now().Before(c.Leaf.NotAfter) { return c, nil }
+    ctx, cancel := context.WithTimeout(hello.Context(), 2*acmeWait)
+    defer cancel()
+    return m.certificate(ctx, name)
+}
+
+// certificate is name's certificate from memory or disk, or else from the CA.
+func (m *acmeManager) certificate(ctx context.Context, name string) (*tls.Certificate, error) {
+    m.orderMu.Lock(); defer m.orderMu.Unlock()
+    m.mu.RLock()
+    c := m.certs[name]
+    m.mu.RUnlock()
+    if c == nil {
+        if b, err := os.ReadFile(filepath.Join(m.dir, name+".pem")); err == nil {
+            if c, err = certFromPEM(b); err != nil { slog.Warn("ACME: reading stored certificate failed", "domain", name, "error", err) }
+        }
+    }
+    if c == nil || time.Until(c.Leaf.NotAfter) < acmeRenewBefore {
+        fresh, err := m.obtain(ctx, name)
+        if err != nil {
+            if c != nil && time.Now().Before(c.Leaf.NotAfter) { slog.Warn("ACME: renewal failed", "domain", name, "error", err) } else { return nil, err }
+        } else {
+            c = fresh
+        }
+    }
+    m.mu.Lock()
+    m.certs[name] = c
+    m.mu.Unlock()
+    return c, nil
+}
+
+// renewLoop renews certificates as they near expiry.
+func (m *acmeManager) renewLoop() {
+    for {
+        for _, d := range m.domains {
+            ctx, cancel := context.WithTimeout(context.Background(), 2*acmeWait)
+            if _, err := m.certificate(ctx, d); err != nil { slog.Warn("ACME: certificate failed", "domain", d, "error", err) }
+            cancel()
+        }
+        time.Sleep(12 * time.Hour)
+    }
+}
+
+// httpHandler answers http-01 challenges and sends everything else to HTTPS.
+func (m *acmeManager) httpHandler(tlsAddr string) http.Handler {
+    _, port, _ := net.SplitHostPort(tlsAddr)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if token, ok := strings.CutPrefix(r.URL.Path, "/.well-known/acme-challenge/"); ok {
+            m.mu.RLock()
+            auth := m.tokens[token]
+            m.mu.RUnlock()
+            if auth == "" { http.NotFound(w, r); return }
+            w.Header().Set("Content-Type", "text/plain")
+            io.WriteString(w, auth)
+            return
+        }
+        host := r.Host
+        if h, _, err := net.SplitHostPort(host); err == nil { host = h }
+        if port != "" && port != "443" { host = net.JoinHostPort(host, port) }
+        http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+    })
+}
+
+func certFromPEM(b []byte) (*tls.Certificate, error) {
+    c, err := tls.X509KeyPair(b, b)
+    if err != nil { return nil, err }
+    if c.Leaf == nil {
+        if c.Leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil { return nil, err }
+    }
+    return &c, nil
+}
+
+// obtain orders a certificate for name and stores it.
+func (m *acmeManager) obtain(ctx context.Context, name string) (*tls.Certificate, error) {
+    slog.Info("ACME: requesting a certificate", "domain", name, "directory", m.directory)
+    if err := m.register(ctx); err != nil { return nil, fmt.Errorf("account: %w", err) }
+    var order acmeOrder
+    orderURL, err := m.post(ctx, m.urls.NewOrder, map[string]any{"identifiers": []map[string]string{{"type": "dns", "value": name}}}, &order)
+    if err != nil { return nil, fmt.Errorf("new order: %w", err) }
+    for _, az := range order.Authorizations {
+        if err := m.authorize(ctx, az); err != nil { return nil, err }
+    }
+
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil { return nil, err }
+    csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: name}, DNSNames: []string{name}}, key)
+    if err != nil { return nil, err }
+    if _, err := m.post(ctx, order.Finalize, map[string]string{"csr": b64url(csr)}, &order); err != nil { return nil, fmt.Errorf("finalize: %w", err) }
+    for deadline := time.Now().Add(acmeWait); order.Status != "valid"; {
+        if order.Status == "invalid" { return nil, fmt.Errorf("order failed: %v", order.Error) }
+        if time.Now().After(deadline) { return nil, errors.New("timed out waiting for the certificate") }
+        time.Sleep(2 * time.Second)
+        if _, err := m.post(ctx, orderURL, nil, &order); err != nil { return nil, err }
+    }
+    var chain []byte
+    if _, err := m.post(ctx, order.Certificate, nil, &chain); err != nil { return nil, fmt.Errorf("certificate: %w", err) }
+
+    der, err := x509.MarshalECPrivateKey(key)
+    if err != nil { return nil, err }
+    b := append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), chain...)
+    c, err := certFromPEM(b)
+    if err != nil { return nil, err }
+    if err := os.WriteFile(filepath.Join(m.dir, name+".pem"), b, 0o600); err != nil { return nil, err }
+    slog.Info("ACME: got a certificate", "domain", name, "not_after", c.Leaf.NotAfter.Format(time.DateOnly))
+    return c, nil
+}
+
+// authorize completes the http-01 challenge of one authorization.
+func (m *acmeManager) authorize(ctx context.Context, url string) error {
+    var az acmeAuthz
+    if _, err := m.post(ctx, url, nil, &az); err != nil { return err }
+    if az.Status == "valid" { return nil }
+    i := slices.IndexFunc(az.Challenges, func(c acmeChallenge) bool { return c.Type == "http-01" })
+    if i < 0 { return errors.New("the CA offers no http-01 challenge") }
+    ch := az.Challenges[i]
+    m.mu.Lock()
+    m.tokens[ch.Token] = ch.Token + "." + m.thumbprint()
+    m.mu.Unlock()
+    defer func() { m.mu.Lock(); delete(m.tokens, ch.Token); m.mu.Unlock() }()
+
+    if _, err := m.post(ctx, ch.URL, struct{}{}, nil); err != nil { return fmt.Errorf("challenge: %w", err) }
+    for deadline := time.Now().Add(acmeWait); ; {
+        if _, err := m.post(ctx, url, nil, &az); err != nil { return err }
+        switch az.Status {
+        case "valid":
+            return nil
+        case "invalid":
+            for _, c := range az.Challenges {
+                if c.Error != nil { return fmt.Errorf("challenge failed: %v", c.Error) }
+            }
+            return errors.New("challenge failed")
+        }
+        if time.Now().After(deadline) { return errors.New("timed out waiting for the challenge") }
+        time.Sleep(2 * time.Second)
+    }
+}
+
+// register loads or creates the account key and makes sure the CA knows it.
+func (m *acmeManager) register(ctx context.Context) error {
+    if m.kid != "" { return nil }
+    if err := os.MkdirAll(m.dir, 0o700); err != nil { return err }
+    keyFile := filepath.Join(m.dir, "account.key")
+    if b, err := os.ReadFile(keyFile); err == nil {
+        blk, _ := pem.Decode(b)
+        if blk == nil { return errors.New(keyFile + ": not PEM") }
+        if m.key, err = x509.ParseECPrivateKey(blk.Bytes); err != nil { return err }
+    } else {
+        if m.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil { return err }
+        der, _ := x509.MarshalECPrivateKey(m.key)
+        if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil { return err }
+    }
+
+    res, err := m.client.Get(m.directory)
+    if err != nil { return err }
+    defer res.Body.Close()
+    if err := json.NewDecoder(res.Body).Decode(&m.urls); err != nil { return fmt.Errorf("directory: %w", err) }
+
+    acct := map[string]any{"termsOfServiceAgreed": true}
+    if m.email != "" { acct["contact"] = []string{"mailto:" + m.email} }
+    kid, err := m.post(ctx, m.urls.NewAccount, acct, nil)
+    if err != nil { return err }
+    m.kid = kid
+    return nil
+}
+
+func b64url(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func (m *acmeManager) jwk() map[string]string {
+    pad := func(n *big.Int) string { return b64url(n.FillBytes(make([]byte, 32))) }
+    return map[string]string{"crv": "P-256", "kty": "EC", "x": pad(m.key.X), "y": pad(m.key.Y)}
+}
+
+// thumbprint is the account key's JWK thumbprint (RFC 7638).
+func (m *acmeManager) thumbprint() string {
+    k := m.jwk()
+    sum := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, k["x"], k["y"])))
+    return b64url(sum[:])
+}
+
+// post sends payload to url as a JWS signed with the account key (nil
+// payload: POST-as-GET) and decodes the answer into out: JSON, or the raw
+// bytes for a *[]byte. It returns the Location header.
+func (m *acmeManager) post(ctx context.Context, url string, payload any, out any) (string, error) {
+    for attempt := 0; ; attempt++ {
+        if m.nonce == "" {
+            res, err := m.client.Head(m.urls.NewNonce)
+            if err != nil { return "", err }
+            res.Body.Close()
+            m.nonce = res.Header.Get("Replay-Nonce")
+        }
+        body := ""
+        if payload != nil {
+            b, _ := json.Marshal(payload)
+            body = b64url(b)
+        }
+        protected := map[string]any{"alg": "ES256", "nonce": m.nonce, "url": url}
+        if m.kid != "" { protected["kid"] = m.kid } else { protected["jwk"] = m.jwk() }
+        ph, _ := json.Marshal(protected)
+        signed := b64url(ph) + "." + body
+        sum := sha256.Sum256([]byte(signed))
+        r, s, err := ecdsa.Sign(rand.Reader, m.key, sum[:])
+        if err != nil { return "", err }
+        sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+        jws, _ := json.Marshal(map[string]string{"protected": b64url(ph), "payload": body, "signature": b64url(sig)})
+
+        req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jws))
+        req.Header.Set("Content-Type", "application/jose+json")
+        res, err := m.client.Do(req)
+        if err != nil { return "", err }
+        data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+        res.Body.Close()
+        if err != nil { return "", err }
+        m.nonce = res.Header.Get("Replay-Nonce")
+        if res.StatusCode >= 400 {
+            var p acmeProblem
+            json.Unmarshal(data, &p)
+            if p.Type == "urn:ietf:params:acme:error:badNonce" && attempt < 2 { m.nonce = ""; continue }
+            if p.Type == "" { return "", fmt.Errorf("%s: %s", res.Status, truncate(string(data), 200)) }
+            return "", p
+        }
+        switch o := out.(type) {
+        case nil:
+        case *[]byte:
+            *o = data
+        default:
+            if err := json.Unmarshal(data, out); err != nil { return "", err }
+        }
+        return res.Header.Get("Location"), nil
+    }
+}
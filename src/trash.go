@@ -0,0 +1,192 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "path"
+    "sort"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Trash ======
+//
+// Deleting moves files into <root>/.avcs-trash/<id>/, keeping their full
+// Dropbox path below that, where they can be brought back until the grace
+// period (TRASH_DAYS, default 30) runs out and they are deleted for good.
+
+const trashDir = ".avcs-trash"
+
+type (
+    TrashItem   = client.TrashItem
+    TrashedFile = client.TrashedFile
+)
+
+// trashFolder is the folder holding item id's files.
+func (s *Server) trashFolder(id string) string { return path.Join(s.dropboxRoot, trashDir, id) }
+
+func (s *Server) inTrash(p string) bool {
+    return strings.HasPrefix(strings.ToLower(p), strings.ToLower(path.Join(s.dropboxRoot, trashDir))+"/")
+}
+
+func (s *Server) trashItem(id string) *TrashItem {
+    var out *TrashItem
+    s.store.view(func(d *storeData) {
+        if x := d.Trash[id]; x != nil { c := *x; out = &c }
+    })
+    return out
+}
+
+// purgeTrash deletes items whose grace period is over. It runs after each
+// reindex, in the background since callers may hold writeMu.
+func (s *Server) purgeTrash(ctx context.Context) {
+    var expired []TrashItem
+    now := time.Now()
+    s.store.view(func(d *storeData) {
+        for _, x := range d.Trash {
+            if now.After(x.Expires) { expired = append(expired, *x) }
+        }
+    })
+    if len(expired) == 0 { return }
+    s.writeMu.Lock(); defer s.writeMu.Unlock()
+    purged := 0
+    for _, x := range expired {
+        if s.trashItem(x.ID) == nil { continue } // undeleted, or purged by an earlier run
+        dir := s.trashFolder(x.ID)
+        if _, err := s.dbxRPC(ctx, "/2/files/delete_v2", map[string]string{"path": dir}); err != nil && !isNotFound(err) {
+            slog.ErrorContext(ctx, "purging trash failed", "id", x.ID, "error", err); continue
+        }
+        err := s.store.update(func(d *storeData) error {
+            delete(d.Trash, x.ID)
+            for k := range d.Baselines {
+                if strings.HasPrefix(k, strings.ToLower(dir)+"/") { delete(d.Baselines, k) }
+            }
+            return nil
+        })
+        if err != nil { slog.ErrorContext(ctx, "purging trash failed", "id", x.ID, "error", err); continue }
+        s.audit(nil, "purge", x.Track, x, nil)
+        purged++
+    }
+    if purged > 0 { slog.InfoContext(ctx, "purged trash", "items", purged) }
+}
+
+// trashPaths moves paths into the trash as item, which it records. The
+// caller holds writeMu.
+func (s *Server) trashPaths(ctx context.Context, item *TrashItem, paths []string) error {
+    var moves [][2]string
+    for _, p := range paths {
+        if !s.underRoot(p) && !s.inArchive(p) && !s.inInbox(p) || s.inTrash(p) { return httpError{400, p + " is not a file under " + s.dropboxRoot + " or " + s.archiveRoot} }
+        e, err := s.dbxMetadata(ctx, p)
+        if isNotFound(err) { return httpError{404, p + " not found"} }
+        if err != nil { return httpError{502, err.Error()} }
+        if item.Track == "" {
+            if np, ok := classifyName(e.Name); ok { item.Track = canonicalName(s.aliasMap(), np.Track) }
+        }
+        tp := path.Join(s.trashFolder(item.ID), e.PathDisplay)
+        moves = append(moves, [2]string{e.PathDisplay, tp})
+        item.Files = append(item.Files, TrashedFile{Path: e.PathDisplay, TrashPath: tp})
+    }
+    if err := s.moveAll(ctx, moves); err != nil { return err }
+    return s.store.update(func(d *storeData) error {
+        if d.Trash == nil { d.Trash = map[string]*TrashItem{} }
+        c := *item
+        d.Trash[item.ID] = &c
+        for _, m := range moves { moveBaselines(d, m[0], m[1]) }
+        return nil
+    })
+}
+
+// POST /api/files/delete {"paths":["/Tracks/ENERGY/mixes/..."]}
+// POST /api/files/delete {"track":"ENERGY","artifact":{"kind":"mix","t1":"0430A","t2":"0720P"}}
+// Moves the files to the trash; POST /api/trash/{id}/undelete brings them back.
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    var req struct {
+        Paths    []string     `json:"paths"`
+        Track    string       `json:"track"`
+        Artifact *ArtifactRef `json:"artifact"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+    item := TrashItem{ID: newID(), Artifact: req.Artifact, By: actorOf(r), Deleted: time.Now().UTC()}
+    item.Expires = item.Deleted.Add(s.trashGrace)
+    paths := req.Paths
+    switch {
+    case req.Artifact != nil:
+        t := s.lookupTrack(req.Track)
+        if t == nil { http.Error(w, "track not found", 404); return }
+        if err := req.Artifact.Resolve(t); err != nil { http.Error(w, err.Error(), 404); return }
+        item.Track, paths = t.Name, nil
+        for _, f := range trackFiles(t) {
+            if req.Artifact.Matches(f.Artifact()) { paths = append(paths, f.Path) }
+        }
+    case len(paths) == 0:
+        http.Error(w, "paths or track and artifact required", 400); return
+    }
+
+    s.writeMu.Lock()
+    err := s.trashPaths(r.Context(), &item, paths)
+    s.writeMu.Unlock()
+    if err != nil { writeError(w, err); return }
+    s.audit(r, "delete", item.Track, nil, item)
+    if err := s.reindex(r.Context()); err != nil { http.Error(w, "deleted, but reindex failed: "+err.Error(), 500); return }
+    writeJSONStatus(w, http.StatusCreated, item)
+}
+
+// GET /api/trash
+func (s *Server) handleTrash(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet { http.Error(w, "GET required", 405); return }
+    out := []TrashItem{}
+    s.store.view(func(d *storeData) {
+        for _, x := range d.Trash { out = append(out, *x) }
+    })
+    sort.Slice(out, func(i, j int) bool { return out[i].Deleted.After(out[j].Deleted) })
+    writeJSON(w, out)
+}
+
+// GET  /api/trash/{id}
+// POST /api/trash/{id}/undelete   moves the files back where they were
+func (s *Server) handleTrashItem(w http.ResponseWriter, r *http.Request) {
+    id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/trash/"), "/"), "/")
+    switch {
+    case action == "" && r.Method == http.MethodGet:
+        x := s.trashItem(id)
+        if x == nil { http.Error(w, "not in the trash", 404); return }
+        writeJSON(w, x)
+
+    case action == "undelete" && r.Method == http.MethodPost:
+        s.writeMu.Lock()
+        x := s.trashItem(id) // under writeMu, so a purge or another undelete cannot interleave
+        var err error
+        if x == nil { err = httpError{404, "not in the trash"} }
+        var moves [][2]string
+        if x != nil {
+            for _, f := range x.Files {
+                if err = s.checkUploadTarget(r.Context(), f.Path, false); err != nil { break }
+                moves = append(moves, [2]string{f.TrashPath, f.Path})
+            }
+        }
+        if err == nil { err = s.moveAll(r.Context(), moves) }
+        if err == nil {
+            err = s.store.update(func(d *storeData) error {
+                delete(d.Trash, id)
+                for _, m := range moves { moveBaselines(d, m[0], m[1]) }
+                return nil
+            })
+        }
+        if err == nil {
+            if _, derr := s.dbxRPC(r.Context(), "/2/files/delete_v2", map[string]string{"path": s.trashFolder(id)}); derr != nil && !isNotFound(derr) { slog.WarnContext(r.Context(), "removing trash folder failed", "id", id, "error", derr) }
+        }
+        s.writeMu.Unlock()
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "undelete", x.Track, x, nil)
+        if err := s.reindex(r.Context()); err != nil { http.Error(w, "restored, but reindex failed: "+err.Error(), 500); return }
+        writeJSON(w, x)
+
+    default:
+        http.NotFound(w, r)
+    }
+}
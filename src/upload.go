@@ -0,0 +1,173 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "mime"
+    "net/http"
+    "path"
+    "regexp"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Upload ======
+
+const (
+    maxSimpleUpload = 150 << 20     // Dropbox's limit for a single upload request
+    uploadLinkTTL   = 4 * time.Hour // the longest Dropbox gives an upload link
+)
+
+var (
+    rxSpaces     = regexp.MustCompile(`[\s_]+`)
+    rxTimeBefore = regexp.MustCompile(`[-_]+([0-9]{4}[AP])`)
+    rxTimeAfter  = regexp.MustCompile(`([0-9]{4}[AP])[-_]+`)
+    rxUnmastered = regexp.MustCompile(`-?\[?UNMASTERED\]?$`)
+)
+
+// correctName repairs the usual slips in a file name (case, spaces, "_"
+// where the convention wants "-", missing brackets on [unmastered]); the
+// result still has to pass classifyName.
+func correctName(name string) string {
+    name = strings.TrimSpace(name)
+    ext := strings.ToLower(path.Ext(name))
+    base := strings.ToUpper(strings.TrimSpace(strings.TrimSuffix(name, path.Ext(name))))
+    base = rxSpaces.ReplaceAllString(base, "_")
+    base = rxTimeBefore.ReplaceAllString(base, "-$1")
+    base = rxTimeAfter.ReplaceAllString(base, "$1-")
+    base = strings.TrimRight(base, "-_")
+    base = rxUnmastered.ReplaceAllString(base, "-[unmastered]")
+    return base + ext
+}
+
+// uploadPath is where the canonical folder layout puts a file named name:
+// <track dir>/{ableton|stems/<T1>-<T2>|mixes|masters}/name.
+func (s *Server) uploadPath(np nameParts, name string) string {
+    parent, _, _ := strings.Cut(canonicalName(s.aliasMap(), np.Track), ".")
+    dir := path.Join(s.dropboxRoot, parent)
+    if t := s.lookupTrack(parent); t != nil && t.Dir != "" { dir = t.Dir }
+    return path.Join(dir, layoutDir(np), name)
+}
+
+// layoutDir is the folder under the track folder that the canonical layout
+// files np in.
+func layoutDir(np nameParts) string {
+    switch np.Kind {
+    case kindStem: return path.Join("stems", np.T1+"-"+np.T2)
+    case kindMix: return "mixes"
+    case kindPrint: return "prints"
+    case kindMaster: return "masters"
+    }
+    return "ableton"
+}
+
+// POST /api/upload?name=ENERGY-0430A.wav[&fix=1][&overwrite=1]
+// The body is the file itself, or multipart/form-data with a "file" part whose
+// file name is used when name is not given. Names that break the convention
+// are rejected, or corrected with fix=1; existing files are kept unless overwrite=1.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    q := r.URL.Query()
+    name := q.Get("name")
+    r.Body = http.MaxBytesReader(w, r.Body, maxSimpleUpload)
+    var body io.Reader = r.Body
+    if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+        mr, err := r.MultipartReader()
+        if err != nil { http.Error(w, err.Error(), 400); return }
+        for {
+            part, err := mr.NextPart()
+            if err != nil { http.Error(w, "no file part", 400); return }
+            if part.FormName() != "file" { continue }
+            if name == "" { name = part.FileName() }
+            body = part
+            break
+        }
+    }
+    ut, err := s.uploadTarget(name, q.Get("fix") != "")
+    if err != nil { writeError(w, err); return }
+
+    s.writeMu.Lock()
+    err = s.checkUploadTarget(r.Context(), ut.Path, q.Get("overwrite") != "")
+    var e *dbxEntry
+    if err == nil {
+        var tooBig *http.MaxBytesError
+        e, err = s.dbxUpload(r.Context(), ut.Path, body)
+        switch {
+        case errors.As(err, &tooBig): err = httpError{413, "files over 150 MB go through /api/uploads"}
+        case err != nil: err = httpError{502, "upload: " + err.Error()}
+        }
+    }
+    s.writeMu.Unlock()
+    if err != nil { writeError(w, err); return }
+
+    ref := fileRefOf(e)
+    ref.ContributedBy = actorOf(r)
+    out := map[string]any{"track": ut.Track, "kind": ut.Kind, "file": ref}
+    if ut.CorrectedFrom != "" { out["corrected_from"] = ut.CorrectedFrom }
+    s.audit(r, "upload", ut.Track, nil, out)
+    if err := s.reindex(r.Context()); err != nil { http.Error(w, "uploaded, but reindex failed: "+err.Error(), 500); return }
+    writeJSONStatus(w, http.StatusCreated, out)
+}
+
+type uploadTarget = client.UploadTarget
+
+// uploadTarget validates name against the convention, correcting it if fix.
+func (s *Server) uploadTarget(name string, fix bool) (*uploadTarget, error) {
+    name = path.Base(strings.TrimSpace(name))
+    if name == "" || name == "." || name == "/" { return nil, httpError{400, "name required"} }
+    given := name
+    np, ok := classifyName(name)
+    if !ok && fix {
+        name = correctName(name)
+        np, ok = classifyName(name)
+    }
+    if !ok { return nil, httpError{422, given + " does not follow the naming convention"} }
+    if np.Kind == kindBackup { return nil, httpError{422, "backups are written by Live, not uploaded"} }
+    if np.Ext == "logicx" { return nil, httpError{422, "Logic projects are folder bundles; sync them with Dropbox instead"} }
+    ut := &uploadTarget{Name: name, Track: canonicalName(s.aliasMap(), np.Track), Kind: np.Kind, Path: s.uploadPath(np, name)}
+    if name != given { ut.CorrectedFrom = given }
+    return ut, nil
+}
+
+// checkUploadTarget refuses to replace an existing file unless overwrite.
+func (s *Server) checkUploadTarget(ctx context.Context, p string, overwrite bool) error {
+    if overwrite { return nil }
+    _, err := s.dbxMetadata(ctx, p)
+    switch {
+    case err == nil: return httpError{409, p + " already exists"}
+    case !isNotFound(err): return httpError{502, err.Error()}
+    }
+    return nil
+}
+
+type uploadLink = client.UploadLink
+
+// POST /api/upload-link?name=ENERGY-0430A.wav[&fix=1][&overwrite=1]
+// Checks the name and the destination as /api/upload does, then returns a
+// single-use Dropbox link the file itself is POSTed to (as
+// application/octet-stream, up to 150 MB), so its bytes do not pass through
+// the server. Nothing is reindexed: POST /api/reindex once the files are in.
+func (s *Server) handleUploadLink(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    q := r.URL.Query()
+    ut, err := s.uploadTarget(q.Get("name"), q.Get("fix") != "")
+    if err != nil { writeError(w, err); return }
+    overwrite := q.Get("overwrite") != ""
+    if err := s.checkUploadTarget(r.Context(), ut.Path, overwrite); err != nil { writeError(w, err); return }
+    mode := "add"
+    if overwrite { mode = "overwrite" }
+    resp, err := s.dbxRPC(r.Context(), "/2/files/get_temporary_upload_link", map[string]any{
+        "commit_info": map[string]any{"path": ut.Path, "mode": mode, "autorename": false, "mute": true},
+        "duration":    int(uploadLinkTTL.Seconds()),
+    })
+    if err != nil { http.Error(w, err.Error(), 502); return }
+    var link struct{ Link string `json:"link"` }
+    if err := json.Unmarshal(resp, &link); err != nil || link.Link == "" { http.Error(w, "no upload link from Dropbox", 502); return }
+    out := uploadLink{UploadTarget: *ut, Link: link.Link, Expires: time.Now().Add(uploadLinkTTL).UTC()}
+    s.audit(r, "upload-link", ut.Track, nil, ut)
+    writeJSONStatus(w, http.StatusCreated, out)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Resumable Uploads ======
+//
+// A TUS-style protocol (core + creation) over Dropbox upload sessions, for
+// multi-GB stem bundles and studio Wi-Fi that drops:
+//
+//   POST  /api/uploads?name=...   Upload-Length: N      -> 201, Location: /api/uploads/{id}
+//   HEAD  /api/uploads/{id}                             -> Upload-Offset, Upload-Length
+//   PATCH /api/uploads/{id}       Upload-Offset: n      body: the bytes from n on
+//
+// Received bytes are passed on to Dropbox in chunks as they arrive, so a
+// PATCH cut off halfway keeps what got through; the client asks HEAD where
+// to resume. The last byte commits the file and reindexes.
+
+const (
+    uploadChunk     = 8 << 20
+    maxUploadLength = 350 << 30          // Dropbox's limit for an upload session
+    uploadKeep      = 7 * 24 * time.Hour // Dropbox drops unfinished sessions after a week
+)
+
+type Upload = client.Upload
+
+// advanceUpload counts n more bytes of u as uploaded.
+func advanceUpload(u *Upload, n int64) {
+    u.Offset += n
+    u.Percent = float64(u.Offset*1000/u.Length) / 10
+    u.Updated = time.Now().UTC()
+}
+
+// upload returns a copy of the upload, with live progress if a PATCH is running.
+func (s *Server) upload(id string) *Upload {
+    s.uploadMu.Lock()
+    if live := s.uploading[id]; live != nil { c := *live; s.uploadMu.Unlock(); return &c }
+    s.uploadMu.Unlock()
+    var out *Upload
+    s.store.view(func(d *storeData) {
+        if u := d.Uploads[id]; u != nil { c := *u; out = &c }
+    })
+    return out
+}
+
+func (s *Server) saveUpload(u Upload) error {
+    return s.store.update(func(d *storeData) error {
+        if d.Uploads == nil { d.Uploads = map[string]*Upload{} }
+        for id, x := range d.Uploads { // forget uploads Dropbox has forgotten too
+            if time.Since(x.Updated) > uploadKeep { delete(d.Uploads, id) }
+        }
+        d.Uploads[u.ID] = &u
+        return nil
+    })
+}
+
+// tusMetadata reads one key of an Upload-Metadata header ("filename d29ya3MuYWxz,...").
+func tusMetadata(h, key string) string {
+    for _, kv := range strings.Split(h, ",") {
+        k, v, _ := strings.Cut(strings.TrimSpace(kv), " ")
+        if k != key { continue }
+        b, err := base64.StdEncoding.DecodeString(v)
+        if err == nil { return string(b) }
+    }
+    return ""
+}
+
+// GET  /api/uploads
+// POST /api/uploads?name=ENERGY-0430A-0720P-BASS.wav[&fix=1][&overwrite=1]  Upload-Length: <bytes>
+// The name may come from a TUS Upload-Metadata "filename" instead.
+func (s *Server) handleUploads(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Tus-Resumable", "1.0.0")
+    switch r.Method {
+    case http.MethodGet:
+        out := []Upload{}
+        s.store.view(func(d *storeData) {
+            for _, u := range d.Uploads { out = append(out, *u) }
+        })
+        for i := range out {
+            if live := s.upload(out[i].ID); live != nil { out[i] = *live }
+        }
+        sort.Slice(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+        writeJSON(w, out)
+
+    case http.MethodPost:
+        q := r.URL.Query()
+        length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+        if err != nil || length <= 0 { http.Error(w, "Upload-Length header required", 400); return }
+        if length > maxUploadLength { http.Error(w, "upload too large", 413); return }
+        name := q.Get("name")
+        if name == "" { name = tusMetadata(r.Header.Get("Upload-Metadata"), "filename") }
+        ut, err := s.uploadTarget(name, q.Get("fix") != "")
+        if err != nil { writeError(w, err); return }
+        overwrite := q.Get("overwrite") != ""
+        if err := s.checkUploadTarget(r.Context(), ut.Path, overwrite); err != nil { writeError(w, err); return }
+
+        resp, err := s.dbxContentRPC(r.Context(), "/2/files/upload_session/start", map[string]any{"close": false}, nil)
+        if err != nil { http.Error(w, "start upload session: "+err.Error(), 502); return }
+        var start struct{ SessionID string `json:"session_id"` }
+        if err := json.Unmarshal(resp, &start); err != nil || start.SessionID == "" { http.Error(w, "start upload session: no session id", 502); return }
+
+        now := time.Now().UTC()
+        u := Upload{ID: newID(), UploadTarget: *ut, Overwrite: overwrite, Length: length, Status: "uploading", SessionID: start.SessionID, By: actorOf(r), Created: now, Updated: now}
+        if err := s.saveUpload(u); err != nil { http.Error(w, err.Error(), 500); return }
+        w.Header().Set("Location", "/api/uploads/"+u.ID)
+        w.Header().Set("Upload-Offset", "0")
+        writeJSONStatus(w, http.StatusCreated, u)
+
+    default:
+        http.Error(w, "GET or POST required", 405)
+    }
+}
+
+// GET    /api/uploads/{id}   progress
+// HEAD   /api/uploads/{id}   Upload-Offset / Upload-Length, for resuming
+// PATCH  /api/uploads/{id}   Upload-Offset: <n>, Content-Type: application/offset+octet-stream
+// DELETE /api/uploads/{id}   abandon
+func (s *Server) handleUploadItem(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Tus-Resumable", "1.0.0")
+    id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/uploads/"), "/")
+    u := s.upload(id)
+    if u == nil { http.Error(w, "upload not found", 404); return }
+
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, u)
+
+    case http.MethodHead:
+        w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+        w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+        w.Header().Set("Cache-Control", "no-store")
+        w.WriteHeader(http.StatusOK)
+
+    case http.MethodPatch:
+        off, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+        if err != nil { http.Error(w, "Upload-Offset header required", 400); return }
+        s.uploadMu.Lock()
+        if s.uploading[id] != nil { s.uploadMu.Unlock(); http.Error(w, "another PATCH is in progress", 423); return }
+        var live *Upload // re-read now that no other PATCH can move it
+        s.store.view(func(d *storeData) {
+            if x := d.Uploads[id]; x != nil { c := *x; live = &c }
+        })
+        if live == nil { s.uploadMu.Unlock(); http.Error(w, "upload not found", 404); return }
+        s.uploading[id] = live
+        s.uploadMu.Unlock()
+        defer func() {
+            s.uploadMu.Lock(); delete(s.uploading, id); s.uploadMu.Unlock()
+        }()
+        if live.Status != "uploading" { http.Error(w, "upload is "+live.Status, 409); return }
+        if off != live.Offset {
+            w.Header().Set("Upload-Offset", strconv.FormatInt(live.Offset, 10))
+            http.Error(w, fmt.Sprintf("offset is %d", live.Offset), 409); return
+        }
+
+        // Keep passing on what arrived even if the client goes away mid-chunk.
+        ctx := context.WithoutCancel(r.Context())
+        buf := make([]byte, uploadChunk)
+        var appendErr error
+        for live.Offset < live.Length {
+            n, rerr := io.ReadFull(r.Body, buf[:min(int64(len(buf)), live.Length-live.Offset)])
+            if n > 0 {
+                arg := map[string]any{"cursor": map[string]any{"session_id": live.SessionID, "offset": live.Offset}, "close": false}
+                if _, appendErr = s.dbxContentRPC(ctx, "/2/files/upload_session/append_v2", arg, bytes.NewReader(buf[:n])); appendErr != nil { break }
+                s.uploadMu.Lock(); advanceUpload(live, int64(n)); s.uploadMu.Unlock()
+            }
+            if rerr != nil { break } // client stopped or disconnected: it resumes from HEAD
+        }
+        s.uploadMu.Lock(); done := *live; s.uploadMu.Unlock()
+        if done.Offset == done.Length { s.finishUpload(ctx, r, &done) }
+        if err := s.saveUpload(done); err != nil { http.Error(w, err.Error(), 500); return }
+        w.Header().Set("Upload-Offset", strconv.FormatInt(done.Offset, 10))
+        switch {
+        case appendErr != nil:
+            http.Error(w, "dropbox append: "+appendErr.Error(), 502)
+        case done.Status == "failed":
+            http.Error(w, done.Error, 502)
+        default:
+            w.WriteHeader(http.StatusNoContent)
+        }
+
+    case http.MethodDelete:
+        s.uploadMu.Lock(); busy := s.uploading[id] != nil; s.uploadMu.Unlock()
+        if busy { http.Error(w, "a PATCH is in progress", 423); return }
+        if err := s.store.update(func(d *storeData) error { delete(d.Uploads, id); return nil }); err != nil { http.Error(w, err.Error(), 500); return }
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "GET, HEAD, PATCH or DELETE required", 405)
+    }
+}
+
+// finishUpload commits the session to the upload's path and reindexes.
+func (s *Server) finishUpload(ctx context.Context, r *http.Request, u *Upload) {
+    mode := "add"
+    if u.Overwrite { mode = "overwrite" }
+    arg := map[string]any{
+        "cursor": map[string]any{"session_id": u.SessionID, "offset": u.Offset},
+        "commit": map[string]any{"path": u.Path, "mode": mode, "autorename": false, "mute": true},
+    }
+    s.writeMu.Lock()
+    resp, err := s.dbxContentRPC(ctx, "/2/files/upload_session/finish", arg, nil)
+    s.writeMu.Unlock()
+    var e dbxEntry
+    if err == nil { err = json.Unmarshal(resp, &e) }
+    u.Updated = time.Now().UTC()
+    if err != nil { u.Status, u.Error = "failed", "commit: "+err.Error(); return }
+    ref := fileRefOf(&e)
+    ref.ContributedBy = u.By
+    u.Status, u.File = "done", &ref
+    s.audit(r, "upload", u.Track, nil, u)
+    if err := s.reindex(ctx); err != nil { u.Error = "uploaded, but reindex failed: " + err.Error() }
+}
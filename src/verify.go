@@ -0,0 +1,195 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "io"
+    "log/slog"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Integrity Verification ======
+//
+// A baseline is the content hash a file is expected to keep. FINAL masters get
+// one as soon as they are indexed (promotion replaces it); any other artifact
+// can be pinned by hand. Verification compares Dropbox's current hash with the
+// baseline and, with rehash, downloads the bytes to check Dropbox's hash too.
+
+type (
+    Baseline     = client.Baseline
+    VerifyResult = client.VerifyResult
+)
+
+func (s *Server) baselines(track string) []Baseline {
+    out := []Baseline{}
+    s.store.view(func(d *storeData) {
+        for _, b := range d.Baselines {
+            if track == "" || b.Track == track { out = append(out, *b) }
+        }
+    })
+    sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+    return out
+}
+
+// setBaselines records bs, replacing earlier baselines of the same paths.
+func (s *Server) setBaselines(bs []Baseline) error {
+    return s.store.update(func(d *storeData) error {
+        if d.Baselines == nil { d.Baselines = map[string]*Baseline{} }
+        for i := range bs { d.Baselines[strings.ToLower(bs[i].Path)] = &bs[i] }
+        return nil
+    })
+}
+
+// moveBaselines re-keys the baseline of a file moved from from to to, or of
+// every file under from when a folder moved.
+func moveBaselines(d *storeData, from, to string) {
+    lf := strings.ToLower(from)
+    for k, b := range d.Baselines {
+        if k != lf && !strings.HasPrefix(k, lf+"/") { continue }
+        c := *b
+        c.Path = to + b.Path[len(from):]
+        delete(d.Baselines, k)
+        d.Baselines[strings.ToLower(c.Path)] = &c
+    }
+}
+
+// baselineFinals records every FINAL in tracks that has no baseline yet.
+func (s *Server) baselineFinals(ctx context.Context, tracks map[string]*Track) {
+    have := map[string]bool{}
+    s.store.view(func(d *storeData) {
+        for k := range d.Baselines { have[k] = true }
+    })
+    var add []Baseline
+    now := time.Now().UTC()
+    for _, t := range tracks {
+        for _, ms := range t.Masters {
+            f := ms.Final
+            if f == nil || f.ContentHash == "" || have[strings.ToLower(f.Path)] { continue }
+            add = append(add, Baseline{Path: f.Path, Track: t.Name, Artifact: ArtifactRef{Kind: kindMaster, T1: ms.T1, T2: ms.T2, Idx: "FINAL"},
+                ContentHash: f.ContentHash, Size: f.Size, Recorded: now, By: "indexer"})
+        }
+    }
+    if len(add) == 0 { return }
+    if err := s.setBaselines(add); err != nil { slog.ErrorContext(ctx, "recording baselines failed", "error", err); return }
+    slog.InfoContext(ctx, "recorded FINAL baselines", "count", len(add))
+}
+
+// verifyOne checks a single baseline against Dropbox.
+func (s *Server) verifyOne(ctx context.Context, b Baseline, rehash bool) VerifyResult {
+    res := VerifyResult{Baseline: b, Status: "ok"}
+    e, err := s.dbxMetadata(ctx, b.Path)
+    switch {
+    case isNotFound(err):
+        res.Status = "missing"
+        return res
+    case err != nil:
+        res.Status, res.Detail = "error", err.Error()
+        return res
+    }
+    res.Actual = e.ContentHash
+    if e.ContentHash != b.ContentHash {
+        res.Status, res.Detail = "modified", "changed "+e.ServerModified.Format(time.RFC3339)
+        return res
+    }
+    if !rehash { return res }
+    body, err := s.analysisDownload(ctx, b.Path)
+    if err != nil { res.Status, res.Detail = "error", err.Error(); return res }
+    defer body.Close()
+    h := newContentHasher()
+    n, err := io.Copy(h, body)
+    if err != nil { res.Status, res.Detail = "error", err.Error(); return res }
+    if sum := h.Sum(); sum != b.ContentHash || n != b.Size {
+        res.Status, res.Actual, res.Detail = "corrupt", sum, "downloaded bytes do not match the recorded hash"
+    }
+    return res
+}
+
+// GET    /api/baselines[?track=]
+// POST   /api/baselines {"track":"ENERGY","artifact":{...}}   pins the artifact's files as they are now
+// DELETE /api/baselines?path=
+func (s *Server) handleBaselines(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, s.baselines(r.URL.Query().Get("track")))
+
+    case http.MethodPost:
+        var req struct {
+            Track    string      `json:"track"`
+            Artifact ArtifactRef `json:"artifact"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        t := s.lookupTrack(req.Track)
+        if t == nil { http.Error(w, "track not found", 404); return }
+        if err := req.Artifact.Resolve(t); err != nil { http.Error(w, err.Error(), 404); return }
+        now := time.Now().UTC()
+        var add []Baseline
+        for _, f := range trackFiles(t) {
+            if !req.Artifact.Matches(f.Artifact()) || f.ContentHash == "" { continue }
+            add = append(add, Baseline{Path: f.Path, Track: t.Name, Artifact: f.Artifact(), ContentHash: f.ContentHash, Size: f.Size, Recorded: now, By: actorOf(r)})
+        }
+        if len(add) == 0 { http.Error(w, "no hashed files in "+req.Artifact.String(), 404); return }
+        if err := s.setBaselines(add); err != nil { http.Error(w, err.Error(), 500); return }
+        s.audit(r, "baseline", t.Name, nil, add)
+        writeJSONStatus(w, http.StatusCreated, add)
+
+    case http.MethodDelete:
+        p := strings.ToLower(r.URL.Query().Get("path"))
+        var removed *Baseline
+        err := s.store.update(func(d *storeData) error {
+            if removed = d.Baselines[p]; removed == nil { return httpError{404, "no baseline for that path"} }
+            delete(d.Baselines, p)
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "unbaseline", removed.Track, removed, nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "GET, POST or DELETE required", 405)
+    }
+}
+
+// POST /api/verify {"track":"ENERGY","rehash":true}
+// Both fields are optional: all tracks, metadata hashes only. Rehashing waits
+// for a maintenance window unless ?force=1 is given.
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { http.Error(w, "POST required", 405); return }
+    var req struct {
+        Track  string `json:"track"`
+        Rehash bool   `json:"rehash"`
+    }
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+    }
+    if req.Track != "" {
+        t := s.lookupTrack(req.Track)
+        if t == nil { http.Error(w, "track not found", 404); return }
+        req.Track = t.Name
+    }
+    if req.Rehash && s.refuseHeavy(w, r) { return }
+    list := s.baselines(req.Track)
+    results := make([]VerifyResult, len(list))
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, 4)
+    for i, b := range list {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            sem <- struct{}{}; defer func() { <-sem }()
+            results[i] = s.verifyOne(r.Context(), b, req.Rehash)
+        }()
+    }
+    wg.Wait()
+    problems := []VerifyResult{}
+    for _, res := range results {
+        if res.Status != "ok" { problems = append(problems, res) }
+    }
+    if len(problems) > 0 { slog.WarnContext(r.Context(), "verify found problems", "failed", len(problems), "checked", len(results)) }
+    writeJSON(w, map[string]any{"checked": len(results), "ok": len(results) - len(problems), "rehashed": req.Rehash, "problems": problems})
+}
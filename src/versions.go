@@ -0,0 +1,105 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Version Tags ======
+
+type VersionTag = client.VersionTag
+
+var rxVersionName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,31}$`)
+
+func (s *Server) versionsFor(track string) []VersionTag {
+    var out []VersionTag
+    s.store.view(func(d *storeData) { out = append(out, d.Versions[track]...) })
+    sort.SliceStable(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+    return out
+}
+
+// GET    /api/tracks/{name}/versions
+// POST   /api/tracks/{name}/versions {"name":"v2","artifact":{"kind":"mix","t1":"0430A","t2":"0720P"}}
+// GET    /api/tracks/{name}/versions/{tag}   the artifact and its files
+// DELETE /api/tracks/{name}/versions/{tag}
+func (s *Server) handleVersions(w http.ResponseWriter, r *http.Request, t *Track, parts []string) {
+    if len(parts) > 0 && parts[0] != "" {
+        s.handleVersion(w, r, t, parts[0])
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, append([]VersionTag{}, s.versionsFor(t.Name)...))
+
+    case http.MethodPost:
+        var req struct {
+            Name     string      `json:"name"`
+            Artifact ArtifactRef `json:"artifact"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        req.Name = strings.TrimSpace(req.Name)
+        if !rxVersionName.MatchString(req.Name) { http.Error(w, "name must be 1-32 letters, digits, '.', '_' or '-'", 400); return }
+        switch req.Artifact.Kind {
+        case kindSnapshot, kindMix, kindPrint, kindMaster:
+        default:
+            http.Error(w, "version tags go on a snapshot, mix, print or master", 400); return
+        }
+        if err := req.Artifact.Resolve(t); err != nil { http.Error(w, err.Error(), 404); return }
+        v := VersionTag{Name: req.Name, Artifact: req.Artifact, By: actorOf(r), Created: time.Now().UTC()}
+        err := s.store.update(func(d *storeData) error {
+            for _, x := range d.Versions[t.Name] {
+                if strings.EqualFold(x.Name, v.Name) { return httpError{409, x.Name + " already names " + x.Artifact.String()} }
+            }
+            if d.Versions == nil { d.Versions = map[string][]VersionTag{} }
+            d.Versions[t.Name] = append(d.Versions[t.Name], v)
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "version-tag", t.Name, nil, v)
+        writeJSONStatus(w, http.StatusCreated, v)
+
+    default:
+        http.Error(w, "GET or POST required", 405)
+    }
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request, t *Track, name string) {
+    switch r.Method {
+    case http.MethodGet:
+        for _, v := range s.versionsFor(t.Name) {
+            if !strings.EqualFold(v.Name, name) { continue }
+            files := []ManifestFile{}
+            for _, f := range trackFiles(t) {
+                if v.Artifact.Matches(f.Artifact()) { files = append(files, f) }
+            }
+            writeJSON(w, map[string]any{"version": v, "files": files})
+            return
+        }
+        http.Error(w, "version tag not found", 404)
+
+    case http.MethodDelete:
+        var removed VersionTag
+        err := s.store.update(func(d *storeData) error {
+            list := d.Versions[t.Name]
+            for i, x := range list {
+                if !strings.EqualFold(x.Name, name) { continue }
+                removed = x
+                d.Versions[t.Name] = append(list[:i:i], list[i+1:]...)
+                return nil
+            }
+            return httpError{404, "version tag not found"}
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "version-untag", t.Name, removed, nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "GET or DELETE required", 405)
+    }
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Watch Folder Agent ======
+//
+// `avcs-browser watch -server URL -track ENERGY ~/Music/Bounces` runs on the
+// studio machine: each audio file exported into the folder is named by the
+// server's /api/suggest-name (with the machine's own clock), uploaded into the
+// track's folder, which reindexes, and moved locally into uploaded/ under its
+// new name. A file is picked up once its size has stopped changing between
+// two polls.
+
+type watcher struct {
+    *client.Client
+    track    string
+    kind     string
+    dir      string
+    done     string
+    interval time.Duration
+    sizes    map[string]int64 // size seen at the last poll, key: file name
+    refused  map[string]int64 // size the server turned down, key: file name
+}
+
+func runWatch(args []string) int {
+    fs := flag.NewFlagSet("watch", flag.ExitOnError)
+    w := &watcher{Client: &client.Client{HTTP: &http.Client{}}, sizes: map[string]int64{}, refused: map[string]int64{}}
+    fs.StringVar(&w.Server, "server", envOr("AVCS_SERVER", "http://localhost:8080"), "A-VCS server URL")
+    fs.StringVar(&w.track, "track", "", "track the exports belong to (default: guessed from each file name)")
+    fs.StringVar(&w.kind, "kind", "", "snapshot, stem, mix or master (default: guessed)")
+    fs.StringVar(&w.User, "user", envOr("USER", ""), "name to attribute uploads to")
+    fs.StringVar(&w.Key, "key", os.Getenv("AVCS_API_KEY"), "API key with the write scope, if the server requires one")
+    fs.DurationVar(&w.interval, "interval", 5*time.Second, "how often to look for new files")
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "usage: avcs-browser watch [flags] <export folder>")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+    if fs.NArg() != 1 { fs.Usage(); return 2 }
+    w.dir = fs.Arg(0)
+    w.Server = strings.TrimSuffix(w.Server, "/")
+    w.done = filepath.Join(w.dir, "uploaded")
+    if err := os.MkdirAll(w.done, 0o755); err != nil { log.Print(err); return 1 }
+
+    log.Printf("Watching %s for exports, uploading to %s", w.dir, w.Server)
+    for {
+        w.poll()
+        time.Sleep(w.interval)
+    }
+}
+
+func envOr(key, def string) string {
+    if v := os.Getenv(key); v != "" { return v }
+    return def
+}
+
+// poll uploads every file whose size has settled since the last poll.
+func (w *watcher) poll() {
+    list, err := os.ReadDir(w.dir)
+    if err != nil { log.Printf("watch: %v", err); return }
+    seen := map[string]int64{}
+    for _, de := range list {
+        name := de.Name()
+        ext := strings.ToLower(filepath.Ext(name))
+        if de.IsDir() || strings.HasPrefix(name, ".") || ext != ".wav" && ext != ".mp3" { continue }
+        fi, err := de.Info()
+        if err != nil { continue }
+        seen[name] = fi.Size()
+        if prev, ok := w.sizes[name]; !ok || prev != fi.Size() || fi.Size() == 0 { continue } // still being written
+        if size, ok := w.refused[name]; ok && size == fi.Size() { continue } // until it is exported again
+        if err := w.ingest(name, fi.Size()); err != nil {
+            log.Printf("watch: %s: %v", name, err)
+            var ae client.Error
+            if errors.As(err, &ae) && ae.Code < 500 { w.refused[name] = fi.Size() }
+            continue
+        }
+        delete(w.refused, name)
+        delete(seen, name)
+    }
+    w.sizes = seen
+}
+
+// ingest names, uploads and sets aside one export.
+func (w *watcher) ingest(name string, size int64) error {
+    ctx := context.Background()
+    sg, err := w.SuggestName(ctx, client.NameRequest{Filename: name, Track: w.track, Kind: w.kind, Time: timeToken(time.Now())})
+    if err != nil { return fmt.Errorf("suggest name: %w", err) }
+
+    p := filepath.Join(w.dir, name)
+    f, err := os.Open(p)
+    if err != nil { return err }
+    defer f.Close()
+    if size <= maxSimpleUpload {
+        _, err = w.Upload(ctx, sg.Name, f, false)
+    } else {
+        _, err = w.UploadResumable(ctx, sg.Name, f, size, false)
+    }
+    if err != nil { return fmt.Errorf("upload as %s: %w", sg.Name, err) }
+    f.Close()
+    log.Printf("Uploaded %s as %s", name, sg.Path)
+    return os.Rename(p, filepath.Join(w.done, sg.Name))
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "strings"
+    "time"
+    "unicode/utf16"
+)
+
+// ====== WAV PCM ======
+
+const (
+    wavPCM        = 1
+    wavFloat      = 3
+    wavExtensible = 0xFFFE
+)
+
+type wavFormat struct {
+    Format     int // wavPCM or wavFloat (extensible resolved to its subformat)
+    Channels   int
+    Rate       int
+    Bits       int
+    BlockAlign int
+}
+
+// wavStream is a WAV file positioned at the start of its sample data.
+type wavStream struct {
+    wavFormat
+    Header  []byte // every byte before the samples, to pass through unchanged
+    DataLen int64
+    r       *bufio.Reader
+}
+
+// readWAV parses the RIFF header up to the data chunk.
+func readWAV(r io.Reader) (*wavStream, error) {
+    ws := &wavStream{r: bufio.NewReaderSize(r, 64<<10)}
+    var riff [12]byte
+    if _, err := io.ReadFull(ws.r, riff[:]); err != nil { return nil, err }
+    if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" { return nil, errors.New("not a RIFF/WAVE file") }
+    ws.Header = append(ws.Header, riff[:]...)
+    haveFmt := false
+    for {
+        var ch [8]byte
+        if _, err := io.ReadFull(ws.r, ch[:]); err != nil { return nil, fmt.Errorf("no data chunk: %w", err) }
+        ws.Header = append(ws.Header, ch[:]...)
+        size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+        if string(ch[0:4]) == "data" {
+            if !haveFmt { return nil, errors.New("data chunk before fmt chunk") }
+            ws.DataLen = size
+            return ws, nil
+        }
+        if size > 1<<20 { return nil, fmt.Errorf("%q chunk too large before data", ch[0:4]) }
+        body := make([]byte, size+size%2)
+        if _, err := io.ReadFull(ws.r, body); err != nil { return nil, err }
+        ws.Header = append(ws.Header, body...)
+        if string(ch[0:4]) != "fmt " { continue }
+        if size < 16 { return nil, errors.New("short fmt chunk") }
+        ws.Format = int(binary.LittleEndian.Uint16(body[0:2]))
+        ws.Channels = int(binary.LittleEndian.Uint16(body[2:4]))
+        ws.Rate = int(binary.LittleEndian.Uint32(body[4:8]))
+        ws.BlockAlign = int(binary.LittleEndian.Uint16(body[12:14]))
+        ws.Bits = int(binary.LittleEndian.Uint16(body[14:16]))
+        if ws.Format == wavExtensible && size >= 26 { ws.Format = int(binary.LittleEndian.Uint16(body[24:26])) }
+        switch {
+        case ws.Format == wavPCM && (ws.Bits == 8 || ws.Bits == 16 || ws.Bits == 24 || ws.Bits == 32):
+        case ws.Format == wavFloat && (ws.Bits == 32 || ws.Bits == 64):
+        default:
+            return nil, fmt.Errorf("unsupported WAV encoding (format %d, %d bit)", ws.Format, ws.Bits)
+        }
+        if ws.Channels == 0 || ws.Rate == 0 || ws.BlockAlign != ws.Channels*ws.Bits/8 { return nil, errors.New("inconsistent fmt chunk") }
+        haveFmt = true
+    }
+}
+
+// sample decodes one sample to [-1, 1).
+func (f wavFormat) sample(b []byte) float64 {
+    switch {
+    case f.Format == wavFloat && f.Bits == 32: return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+    case f.Format == wavFloat: return math.Float64frombits(binary.LittleEndian.Uint64(b))
+    case f.Bits == 8: return (float64(b[0]) - 128) / 128
+    case f.Bits == 16: return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+    case f.Bits == 24: return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+    }
+    return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+}
+
+// putSample encodes v, clipping integer formats.
+func (f wavFormat) putSample(b []byte, v float64) {
+    if f.Format == wavFloat {
+        if f.Bits == 32 { binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v))) } else { binary.LittleEndian.PutUint64(b, math.Float64bits(v)) }
+        return
+    }
+    full := float64(int64(1) << (f.Bits - 1))
+    n := int64(math.Round(v * full))
+    n = max(min(n, int64(full)-1), -int64(full))
+    switch f.Bits {
+    case 8: b[0] = byte(n + 128)
+    case 16: binary.LittleEndian.PutUint16(b, uint16(n))
+    case 24: b[0], b[1], b[2] = byte(n), byte(n>>8), byte(n>>16)
+    default: binary.LittleEndian.PutUint32(b, uint32(n))
+    }
+}
+
+// frames calls fn with each whole buffer of frames of the data chunk.
+func (ws *wavStream) frames(fn func(buf []byte) error) error {
+    buf := make([]byte, ws.BlockAlign*4096)
+    left := ws.DataLen
+    for left > 0 {
+        n := int(min(int64(len(buf)), left))
+        n -= n % ws.BlockAlign
+        if n == 0 { break }
+        if _, err := io.ReadFull(ws.r, buf[:n]); err != nil { return err }
+        if err := fn(buf[:n]); err != nil { return err }
+        left -= int64(n)
+    }
+    return nil
+}
+
+// biquad is one direct-form-I filter section.
+type biquad struct {
+    b0, b1, b2, a1, a2 float64
+    x1, x2, y1, y2     float64
+}
+
+func (q *biquad) step(x float64) float64 {
+    y := q.b0*x + q.b1*q.x1 + q.b2*q.x2 - q.a1*q.y1 - q.a2*q.y2
+    q.x2, q.x1, q.y2, q.y1 = q.x1, x, q.y1, y
+    return y
+}
+
+// kWeighting returns the ITU-R BS.1770 pre-filter (high shelf, then high pass) for rate.
+func kWeighting(rate int) (shelf, hp biquad) {
+    fs := float64(rate)
+    k := math.Tan(math.Pi * 1681.974450955533 / fs)
+    q := 0.7071752369554196
+    vh := math.Pow(10, 3.999843853973347/20)
+    vb := math.Pow(vh, 0.4996667741545416)
+    a0 := 1 + k/q + k*k
+    shelf = biquad{b0: (vh + vb*k/q + k*k) / a0, b1: 2 * (k*k - vh) / a0, b2: (vh - vb*k/q + k*k) / a0, a1: 2 * (k*k - 1) / a0, a2: (1 - k/q + k*k) / a0}
+    k = math.Tan(math.Pi * 38.13547087602444 / fs)
+    q = 0.5003270373238773
+    a0 = 1 + k/q + k*k
+    hp = biquad{b0: 1, b1: -2, b2: 1, a1: 2 * (k*k - 1) / a0, a2: (1 - k/q + k*k) / a0}
+    return
+}
+
+// integratedLoudness measures gated integrated loudness (LUFS) per BS.1770-4:
+// 400 ms blocks every 100 ms, absolute gate -70 LUFS, relative gate -10 LU.
+// All channels are weighted 1.
+func (ws *wavStream) integratedLoudness() (float64, error) {
+    filters := make([][2]biquad, ws.Channels)
+    for c := range filters { filters[c][0], filters[c][1] = kWeighting(ws.Rate) }
+    step := max(ws.Rate/10, 1)
+    var sub []float64 // mean square per 100 ms, summed over channels
+    var acc float64
+    n := 0
+    size := ws.Bits / 8
+    err := ws.frames(func(buf []byte) error {
+        for off := 0; off < len(buf); off += ws.BlockAlign {
+            for c := 0; c < ws.Channels; c++ {
+                x := ws.sample(buf[off+c*size:])
+                y := filters[c][1].step(filters[c][0].step(x))
+                acc += y * y
+            }
+            if n++; n == step { sub = append(sub, acc/float64(step)); acc, n = 0, 0 }
+        }
+        return nil
+    })
+    if err != nil { return 0, err }
+    lufs := func(ms float64) float64 { return -0.691 + 10*math.Log10(ms) }
+    var blocks []float64
+    for i := 0; i+4 <= len(sub); i++ { blocks = append(blocks, (sub[i]+sub[i+1]+sub[i+2]+sub[i+3])/4) }
+    if len(blocks) == 0 { // shorter than one block: plain mean square
+        total := acc
+        for _, v := range sub { total += v * float64(step) }
+        frames := len(sub)*step + n
+        if frames == 0 || total == 0 { return math.Inf(-1), nil }
+        return lufs(total / float64(frames)), nil
+    }
+    gated := func(floor float64) (float64, int) {
+        var sum float64
+        cnt := 0
+        for _, b := range blocks {
+            if b > 0 && lufs(b) > floor { sum += b; cnt++ }
+        }
+        return sum, cnt
+    }
+    sum, cnt := gated(-70)
+    if cnt == 0 { return math.Inf(-1), nil }
+    sum, cnt = gated(lufs(sum/float64(cnt)) - 10)
+    if cnt == 0 { return math.Inf(-1), nil }
+    return lufs(sum / float64(cnt)), nil
+}
+
+// copyWithGain writes the WAV to w with every sample scaled by gain, header
+// and trailing chunks unchanged.
+func (ws *wavStream) copyWithGain(w io.Writer, gain float64) error {
+    if _, err := w.Write(ws.Header); err != nil { return err }
+    size := ws.Bits / 8
+    err := ws.frames(func(buf []byte) error {
+        if gain != 1 {
+            for off := 0; off+size <= len(buf); off += size { ws.putSample(buf[off:], ws.sample(buf[off:])*gain) }
+        }
+        _, err := w.Write(buf)
+        return err
+    })
+    if err != nil { return err }
+    _, err = io.Copy(w, ws.r)
+    return err
+}
+
+// ====== WAV Tags ======
+
+// wavInfo builds a LIST/INFO chunk from (ID, text) pairs such as
+// {"INAM", "title"}; empty texts are left out.
+func wavInfo(fields [][2]string) []byte {
+    body := []byte("INFO")
+    for _, f := range fields {
+        if f[1] == "" { continue }
+        v := append([]byte(f[1]), 0)
+        body = append(body, f[0]...)
+        body = binary.LittleEndian.AppendUint32(body, uint32(len(v)))
+        body = append(body, v...)
+        if len(v)%2 == 1 { body = append(body, 0) }
+    }
+    out := append([]byte("LIST"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+    return append(out, body...)
+}
+
+// wavChunk is a RIFF chunk holding body, padded to an even length.
+func wavChunk(id string, body []byte) []byte {
+    out := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+    out = append(out, body...)
+    if len(body)%2 == 1 { out = append(out, 0) }
+    return out
+}
+
+// wavBext builds a Broadcast Wave bext chunk (version 1): a description of
+// up to 256 characters, who made the file and when.
+func wavBext(description, originator string, when time.Time) []byte {
+    b := make([]byte, 602) // time reference, UMID and the reserved bytes stay zero
+    copy(b[0:256], description)
+    copy(b[256:288], originator)
+    copy(b[320:330], when.Format("2006-01-02"))
+    copy(b[330:338], when.Format("15:04:05"))
+    binary.LittleEndian.PutUint16(b[346:348], 1)
+    return wavChunk("bext", b)
+}
+
+// withTags copies the WAV in r to w with tags (whole chunks, such as a
+// LIST/INFO and a bext chunk) put before the data. Chunks of the same kinds
+// it had become JUNK chunks, so every size stays right while streaming. It
+// returns the audio's duration.
+func withTags(w io.Writer, r io.Reader, tags []byte) (time.Duration, error) {
+    replace := map[string]bool{}
+    for b := tags; len(b) >= 8; {
+        n := int(binary.LittleEndian.Uint32(b[4:8]))
+        replace[strings.ToLower(string(b[0:4]))] = true
+        b = b[min(len(b), 8+n+n%2):]
+    }
+    br := bufio.NewReaderSize(r, 64<<10)
+    var riff [12]byte
+    if _, err := io.ReadFull(br, riff[:]); err != nil { return 0, err }
+    if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" { return 0, errors.New("not a RIFF/WAVE file") }
+    binary.LittleEndian.PutUint32(riff[4:8], binary.LittleEndian.Uint32(riff[4:8])+uint32(len(tags)))
+    if _, err := w.Write(riff[:]); err != nil { return 0, err }
+    var rate, blockAlign, dataLen int64
+    for {
+        var ch [8]byte
+        if _, err := io.ReadFull(br, ch[:]); err == io.EOF {
+            break
+        } else if err != nil {
+            return 0, err
+        }
+        size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+        switch string(ch[0:4]) {
+        case "data":
+            if _, err := w.Write(tags); err != nil { return 0, err }
+            tags, dataLen = nil, size
+        case "LIST":
+            if kind, _ := br.Peek(4); string(kind) == "INFO" && replace["list"] { copy(ch[0:4], "JUNK") }
+        case "fmt ":
+            if b, _ := br.Peek(16); len(b) == 16 {
+                rate, blockAlign = int64(binary.LittleEndian.Uint32(b[4:8])), int64(binary.LittleEndian.Uint16(b[12:14]))
+            }
+        default:
+            if replace[strings.ToLower(string(ch[0:4]))] { copy(ch[0:4], "JUNK") }
+        }
+        if _, err := w.Write(ch[:]); err != nil { return 0, err }
+        n, err := io.CopyN(w, br, size+size%2)
+        if err == io.EOF && n >= size { break } // a missing pad byte at the very end
+        if err != nil { return 0, err }
+    }
+    if tags != nil { return 0, errors.New("no data chunk") }
+    if rate == 0 || blockAlign == 0 { return 0, nil }
+    return time.Duration(dataLen * int64(time.Second) / (rate * blockAlign)), nil
+}
+
+// ====== ID3 Tags ======
+
+// id3Tag builds an ID3v2.3 tag from (frame ID, text) pairs such as
+// {"TIT2", "title"}; empty texts are left out.
+func id3Tag(frames [][2]string) []byte {
+    var body []byte
+    for _, f := range frames {
+        if f[1] == "" { continue }
+        text := id3Text(f[1])
+        body = append(body, f[0]...)
+        body = binary.BigEndian.AppendUint32(body, uint32(len(text)))
+        body = append(body, 0, 0)
+        body = append(body, text...)
+    }
+    n := len(body)
+    return append([]byte{'I', 'D', '3', 3, 0, 0, byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}, body...)
+}
+
+// id3Text encodes s as ISO-8859-1 if it can be, else as UTF-16 with a BOM.
+func id3Text(s string) []byte {
+    latin := []byte{0}
+    for _, r := range s {
+        if r > 0xff {
+            out := []byte{1, 0xff, 0xfe}
+            for _, u := range utf16.Encode([]rune(s)) { out = binary.LittleEndian.AppendUint16(out, u) }
+            return out
+        }
+        latin = append(latin, byte(r))
+    }
+    return latin
+}
+
+// withID3 is the MP3 in r with tag in place of the ID3v2 tag it started
+// with, if any, and how many bytes longer that makes it.
+func withID3(r io.Reader, tag []byte) (io.Reader, int64, error) {
+    br := bufio.NewReaderSize(r, 64<<10)
+    old := 0
+    if head, _ := br.Peek(10); len(head) == 10 && string(head[:3]) == "ID3" {
+        old = 10 + int(head[6]&0x7f)<<21 + int(head[7]&0x7f)<<14 + int(head[8]&0x7f)<<7 + int(head[9]&0x7f)
+        if head[5]&0x10 != 0 { old += 10 } // a footer
+        if _, err := br.Discard(old); err != nil { return nil, 0, err }
+    }
+    return io.MultiReader(bytes.NewReader(tag), br), int64(len(tag) - old), nil
+}
@@ -1,9 +1,20 @@
-// --- tiny fetch helper
-async function j(url, opts={}) { const r = await fetch(url, opts); if (!r.ok) throw new Error(await r.text()); return r.json(); }
+// --- tiny fetch helper; signs in, or asks for an API key, once the server wants one
+async function j(url, opts={}) {
+  const key = localStorage.getItem('avcsKey');
+  const r = await fetch(url, key ? {...opts, headers: {...(opts.headers||{}), Authorization: `Bearer ${key}`}} : opts);
+  if (r.status === 401) {
+    if ((await fetch('/auth/me')).status !== 404) { location.href = '/auth/login?next=' + encodeURIComponent(location.pathname); return new Promise(()=>{}); }
+    const k = prompt('API key');
+    if (k) { localStorage.setItem('avcsKey', k.trim()); return j(url, opts); }
+  }
+  if (!r.ok) throw new Error(await r.text());
+  return r.json();
+}
 
 // --- caches
 const linkCache = new Map(); // path -> temp link
 const audioPool = new Map(); // path -> HTMLAudioElement
+const locatorCache = new Map(); // `${track}/${t1}` -> Promise<[locator]>
 
 // --- utilities
 function $(s, el=document){ return el.querySelector(s); }
@@ -40,9 +51,35 @@ function drawWaveform(canvas, key){
   ctx.stroke();
 }
 
+// Arrangement locators from the T1 Live set, positioned by seconds
+function getLocators(track, t1){
+  const key = `${track}/${t1}`;
+  if (!locatorCache.has(key)) locatorCache.set(key, j(`/api/tracks/${encodeURIComponent(track)}/ableton/${encodeURIComponent(t1)}/locators`).then(r=> r.locators||[]).catch(()=> []));
+  return locatorCache.get(key);
+}
+
+function drawMarkers(canvas, locators, duration){
+  if (!duration || !isFinite(duration) || !locators.length) return;
+  const ctx = canvas.getContext('2d'); const w = canvas.width, h = canvas.height;
+  const color = getComputedStyle(document.documentElement).getPropertyValue('--marker');
+  ctx.strokeStyle = color; ctx.fillStyle = color; ctx.lineWidth = 1; ctx.font = '11px system-ui';
+  for (const l of locators){
+    if (l.seconds > duration) continue;
+    const x = Math.round(l.seconds / duration * w) + 0.5;
+    ctx.beginPath(); ctx.moveTo(x, 0); ctx.lineTo(x, h); ctx.stroke();
+    ctx.fillText(l.name, x+3, 12);
+  }
+}
+
+function markWhenLoaded(canvas, audio, track, t1){
+  const mark = ()=> getLocators(track, t1).then(l=> drawMarkers(canvas, l, audio.duration));
+  if (audio.readyState >= 1) mark(); else audio.addEventListener('loadedmetadata', mark, {once:true});
+}
+
 async function getTempLink(path){
   if (linkCache.has(path)) return linkCache.get(path);
-  const { url } = await j(`/api/link?path=${encodeURIComponent(path)}`);
+  const signed = await j(`/api/sign?url=${encodeURIComponent(`/api/link?path=${encodeURIComponent(path)}`)}`);
+  const { url } = await j(signed.url);
   linkCache.set(path, url); return url;
 }
 
@@ -75,9 +112,10 @@ async function loadTracks(){
   let tracks = await j('/api/tracks'); if (!Array.isArray(tracks)) tracks = [];
   const filter = $('#filter').value?.trim().toLowerCase();
   for (const t of tracks){
-    if (filter && !t.name.toLowerCase().includes(filter)) continue;
+    if (filter && ![t.name, ...(t.aliases||[])].some(n=> n.toLowerCase().includes(filter))) continue;
     const li = h('li', {class:'item'});
-    li.appendChild(h('button', {class:'link'}, document.createTextNode(t.name)));
+    li.appendChild(h('button', {class:'link'}, document.createTextNode(t.name), t.locked? h('span', {class:'lock', title:'checked out', html:' &#128274;'}): null,
+      t.restricted? h('span', {class:'lock', title:'restricted', html:' &#128683;'}): null));
     li.querySelector('button').onclick = () => showTrack(t.name);
     listEl.appendChild(li);
   }
@@ -92,9 +130,30 @@ async function showTrack(name){
   const t = await j(`/api/tracks/${encodeURIComponent(name)}`);
   const versions = buildVersions(t);
 
-  const head = h('div', {class:'pane-head'}, h('div', {class:'title'}, document.createTextNode(t.name)));
+  const cover = t.cover ? h('img', {class:'cover', alt:'', src:`/api/tracks/${encodeURIComponent(t.name)}/artwork/image?size=64`}) : null;
+  const head = h('div', {class:'pane-head'}, h('div', {class:'title'}, cover, document.createTextNode(t.name)));
+  const locks = h('div', {class:'locks'});
+  for (const l of (t.locks||[])){
+    const what = l.t1 ? `session ${l.t1}` : 'whole track';
+    locks.appendChild(h('span', {class:'badge lock', title: l.note||''},
+      document.createTextNode(`\u{1F512} ${what} — ${l.owner} until ${new Date(l.expires).toLocaleString()}`)));
+  }
+  head.appendChild(locks);
   pane.appendChild(head);
 
+  // branch switcher: main line plus alternate versions (TRACK.BRANCH)
+  const root = t.parent || t.name;
+  const branches = t.parent ? (await j(`/api/tracks/${encodeURIComponent(root)}`)).branches || [] : (t.branches||[]);
+  if (branches.length){
+    const bar = h('div', {class:'tabs branches'});
+    for (const [label, target] of [['main', root], ...branches.map(b=> [b.toLowerCase().replace(/_/g,' '), `${root}.${b}`])]){
+      const btn = h('button', {class:'tab'+(target===t.name?' active':'')}, document.createTextNode(label));
+      btn.onclick = () => showTrack(target);
+      bar.appendChild(btn);
+    }
+    pane.appendChild(bar);
+  }
+
   if (!versions.length){
     pane.appendChild(h('div', {class:'empty-state'}, h('p', {html:'No versions (stems/mixes/masters) found for this track.'}))); return;
   }
@@ -102,7 +161,8 @@ async function showTrack(name){
   // version selector tabs
   const tabs = h('div', {class:'tabs'});
   versions.forEach((v,i)=>{
-    const btn = h('button', {class:'tab'+(i===0?' active':'')}, document.createTextNode(`${v.t1} — ${v.t2}`));
+    const named = (t.versions||[]).filter(x=> x.artifact.t1===v.t1 && (x.artifact.t2||'')===v.t2).map(x=> x.name);
+    const btn = h('button', {class:'tab'+(i===0?' active':'')}, document.createTextNode(`${v.t1} — ${v.t2}`+(named.length? ` (${named.join(', ')})`: '')));
     btn.onclick = () => { $all('.tab', tabs).forEach(b=>b.classList.remove('active')); btn.classList.add('active'); renderVersion(pane, t, v); };
     tabs.appendChild(btn);
   });
@@ -144,7 +204,7 @@ async function renderVersion(pane, track, ver){
   drawWaveform(masterCanvas, masterRef? masterRef.path : `${track.name}-${ver.t1}-${ver.t2}-master`);
   const masterBtn = h('button', {class:'btn'}, document.createTextNode('Play'));
   const masterAudio = new Audio(); masterAudio.preload = 'metadata';
-  if (masterRef){ getTempLink(masterRef.path).then(u=> masterAudio.src = u); }
+  if (masterRef){ getTempLink(masterRef.path).then(u=> masterAudio.src = u); markWhenLoaded(masterCanvas, masterAudio, track.name, ver.t1); }
   masterBtn.onclick = async ()=>{ try { if (masterAudio.paused) { await masterAudio.play(); masterBtn.textContent = 'Pause'; } else { masterAudio.pause(); masterBtn.textContent = 'Play'; } } catch(e){ alert('Playback failed: '+e); } };
   masterControls.appendChild(masterBtn); masterControls.appendChild(masterCanvas);
   masterCard.appendChild(masterControls);
@@ -181,6 +241,8 @@ async function renderVersion(pane, track, ver){
       audio.addEventListener('loadedmetadata', ()=> setStemMeta(ref, audio.duration));
     }
 
+    markWhenLoaded(canvas, audio, track.name, ver.t1);
+
     row.onclick = (ev)=>{ if (ev.target.tagName === 'BUTTON') return; setStemMeta(ref, audio.duration||0); };
 
     btnPlay.onclick = async ()=>{
@@ -215,6 +277,16 @@ async function renderVersion(pane, track, ver){
   $('#stopAll').onclick = ()=>{ stems.forEach(ref=>{ const a = audioPool.get(ref.path); if (a) { a.pause(); a.currentTime = 0; } }); };
 }
 
+// signed-in user, when the server has web login
+async function showUser(){
+  const r = await fetch('/auth/me'); if (!r.ok) return;
+  const me = await r.json();
+  const out = h('button', {class:'btn'}, document.createTextNode('Log out'));
+  out.onclick = async () => { await fetch('/auth/logout', {method:'POST'}); location.reload(); };
+  $('header .actions').prepend(h('span', {class:'muted user', title: [me.email, me.role].filter(Boolean).join(' · ')}, document.createTextNode(me.name || me.user)), out);
+}
+
 // boot
+showUser().catch(e=> console.error(e));
 loadTracks().catch(e=> console.error(e));
 
@@ -0,0 +1,349 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "path"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ====== Outbound Webhooks ======
+//
+// Subscriptions, made by admins through /api/webhooks, send events to other
+// systems as JSON POSTs:
+//
+//   new-candidate, new-final, new-stems, new-mix   what a reindex found (as
+//                                                  announced on Slack, see
+//                                                  notify.go)
+//   deadline-reminder                              a deadline coming up or
+//                                                  overdue (deadlines.go)
+//   status, promote, comment, reindex, ...         every action in the audit
+//                                                  log, by its name there
+//   ping                                           POST /api/webhooks/{id}/test
+//
+// A subscription's events are patterns as in path.Match ("status", "new-*",
+// "*"). Each delivery is signed with the subscription's secret, which is shown
+// once, when it is made:
+//
+//   X-AVCS-Event       the event
+//   X-AVCS-Delivery    the delivery ID, the same on every attempt
+//   X-AVCS-Timestamp   Unix seconds
+//   X-AVCS-Signature   sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+//
+// Anything but a 2xx answer is retried after webhookBackoff, then given up.
+// The last webhookLogMax deliveries of each subscription are kept, with how
+// they went; retries are not resumed after a restart.
+
+// webhookBackoff are the waits before each retry.
+var webhookBackoff = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour}
+
+const webhookLogMax = 100
+
+var webhookClient = &http.Client{Timeout: 15 * time.Second}
+
+// Webhook is a subscription.
+type Webhook struct {
+    ID          string    `json:"id"`
+    URL         string    `json:"url"`
+    Events      []string  `json:"events"` // patterns
+    Description string    `json:"description,omitempty"`
+    Active      bool      `json:"active"`
+    Secret      string    `json:"secret,omitempty"` // signs deliveries
+    By          string    `json:"by"`
+    Created     time.Time `json:"created"`
+}
+
+// public is h as shown to clients.
+func (h Webhook) public() Webhook { h.Secret = ""; return h }
+
+func (h *Webhook) wants(event string) bool {
+    if !h.Active { return false }
+    for _, p := range h.Events {
+        if ok, _ := path.Match(p, event); ok { return true }
+    }
+    return false
+}
+
+// Delivery is one event sent to a webhook, over its attempts.
+type Delivery struct {
+    ID       string     `json:"id"`
+    Event    string     `json:"event"`
+    Track    string     `json:"track,omitempty"`
+    Created  time.Time  `json:"created"`
+    Status   string     `json:"status"` // pending, delivered, failed
+    Attempts int        `json:"attempts"`
+    Code     int        `json:"code,omitempty"`  // of the last answer
+    Error    string     `json:"error,omitempty"` // of the last attempt
+    Next     *time.Time `json:"next,omitempty"`  // the next attempt, while pending
+}
+
+// webhookEvent is the body of a delivery.
+type webhookEvent struct {
+    ID    string    `json:"id"` // the delivery
+    Event string    `json:"event"`
+    Time  time.Time `json:"time"`
+    Actor string    `json:"actor,omitempty"`
+    Track string    `json:"track,omitempty"`
+    Data  any       `json:"data,omitempty"`
+}
+
+type webhookInput struct {
+    URL         *string   `json:"url"`
+    Events      *[]string `json:"events"`
+    Description *string   `json:"description"`
+    Active      *bool     `json:"active"`
+}
+
+func (in webhookInput) apply(h *Webhook) error {
+    if in.URL != nil { h.URL = strings.TrimSpace(*in.URL) }
+    if in.Events != nil { h.Events = append([]string{}, *in.Events...) }
+    if in.Description != nil { h.Description = strings.TrimSpace(*in.Description) }
+    if in.Active != nil { h.Active = *in.Active }
+    u, err := url.Parse(h.URL)
+    if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" { return httpError{400, "url must be an http or https URL"} }
+    if len(h.Events) == 0 { return httpError{400, `events required, e.g. ["status","new-*"] or ["*"]`} }
+    for i, p := range h.Events {
+        h.Events[i] = strings.ToLower(strings.TrimSpace(p))
+        if _, err := path.Match(h.Events[i], ""); err != nil || h.Events[i] == "" { return httpError{400, fmt.Sprintf("bad event pattern %q", p)} }
+    }
+    slices.Sort(h.Events)
+    h.Events = slices.Compact(h.Events)
+    return nil
+}
+
+// fireWebhooks sends e to every subscription that wants it, in the
+// background.
+func (s *Server) fireWebhooks(ctx context.Context, e webhookEvent) {
+    var to []Webhook
+    s.store.view(func(d *storeData) {
+        for _, h := range d.Webhooks {
+            if h.wants(e.Event) { to = append(to, *h) }
+        }
+    })
+    for _, h := range to {
+        e := e
+        e.ID, e.Time = newID(), time.Now().UTC()
+        go runJob(context.WithoutCancel(ctx), "webhook", func(ctx context.Context) { s.deliver(ctx, h, e) })
+    }
+}
+
+// deliver sends e to h until it is taken or the retries run out.
+func (s *Server) deliver(ctx context.Context, h Webhook, e webhookEvent) {
+    body, err := json.Marshal(e)
+    if err != nil { slog.ErrorContext(ctx, "webhook event does not encode", "event", e.Event, "error", err); return }
+    d := Delivery{ID: e.ID, Event: e.Event, Track: e.Track, Created: e.Time, Status: "pending"}
+    for {
+        d.Attempts++
+        d.Code, d.Error = 0, ""
+        code, err := postWebhook(ctx, h, e, body)
+        d.Code = code
+        if err == nil { d.Status, d.Next = "delivered", nil; s.logDelivery(h.ID, d); return }
+        d.Error = err.Error()
+        if d.Attempts > len(webhookBackoff) {
+            d.Status, d.Next = "failed", nil
+            s.logDelivery(h.ID, d)
+            slog.WarnContext(ctx, "webhook delivery failed", "webhook", h.ID, "event", e.Event, "attempts", d.Attempts, "error", err)
+            return
+        }
+        next := time.Now().UTC().Add(webhookBackoff[d.Attempts-1])
+        d.Next = &next
+        s.logDelivery(h.ID, d)
+        time.Sleep(time.Until(next))
+        // A subscription deleted or paused meanwhile gets no more attempts.
+        var cur *Webhook
+        s.store.view(func(sd *storeData) {
+            if c := sd.Webhooks[h.ID]; c != nil { cp := *c; cur = &cp }
+        })
+        if cur == nil || !cur.Active {
+            d.Status, d.Next, d.Error = "failed", nil, "the subscription was removed or paused"
+            s.logDelivery(h.ID, d)
+            return
+        }
+        h = *cur
+    }
+}
+
+// postWebhook makes one attempt, signed now.
+func postWebhook(ctx context.Context, h Webhook, e webhookEvent, body []byte) (int, error) {
+    ts := strconv.FormatInt(time.Now().Unix(), 10)
+    mac := hmac.New(sha256.New, []byte(h.Secret))
+    mac.Write([]byte(ts + "."))
+    mac.Write(body)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+    if err != nil { return 0, err }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "avcs-browser")
+    req.Header.Set("X-AVCS-Event", e.Event)
+    req.Header.Set("X-AVCS-Delivery", e.ID)
+    req.Header.Set("X-AVCS-Timestamp", ts)
+    req.Header.Set("X-AVCS-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+    res, err := webhookClient.Do(req)
+    if err != nil { return 0, err }
+    defer res.Body.Close()
+    msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<12))
+    if res.StatusCode/100 != 2 { return res.StatusCode, fmt.Errorf("%s: %s", res.Status, truncate(strings.TrimSpace(string(msg)), 200)) }
+    return res.StatusCode, nil
+}
+
+// logDelivery records d in its subscription's log, replacing an earlier
+// record of it.
+func (s *Server) logDelivery(hookID string, d Delivery) {
+    err := s.store.update(func(sd *storeData) error {
+        if sd.Webhooks[hookID] == nil { return nil }
+        if sd.WebhookLog == nil { sd.WebhookLog = map[string][]Delivery{} }
+        log := sd.WebhookLog[hookID]
+        if i := slices.IndexFunc(log, func(o Delivery) bool { return o.ID == d.ID }); i >= 0 {
+            log[i] = d
+        } else {
+            log = append(log, d)
+        }
+        if n := len(log) - webhookLogMax; n > 0 { log = append([]Delivery(nil), log[n:]...) }
+        sd.WebhookLog[hookID] = log
+        return nil
+    })
+    if err != nil { slog.Error("recording a webhook delivery failed", "webhook", hookID, "error", err) }
+}
+
+// hookNotifier passes catalog events to the webhooks, as new-<kind>, and
+// deadline reminders as deadline-reminder.
+type hookNotifier struct{ s *Server }
+
+// hookEvent is the webhook event for kind.
+func hookEvent(kind string) string {
+    if kind == eventDeadline { return "deadline-reminder" }
+    return "new-" + kind
+}
+
+func (n hookNotifier) name() string { return "webhooks" }
+
+func (n hookNotifier) wants(kind string) bool {
+    found := false
+    n.s.store.view(func(d *storeData) {
+        for _, h := range d.Webhooks {
+            if h.wants(hookEvent(kind)) { found = true }
+        }
+    })
+    return found
+}
+
+func (n hookNotifier) notify(ctx context.Context, e catalogEvent) error {
+    if e.kind == eventDeadline {
+        n.s.fireWebhooks(ctx, webhookEvent{Event: hookEvent(e.kind), Actor: "deadlines", Track: e.track, Data: map[string]any{"deadline": e.deadline, "text": e.title()}})
+        return nil
+    }
+    data := map[string]any{"artifact": e.artifact, "files": e.files}
+    if e.lufs != nil { data["lufs"] = *e.lufs }
+    if e.link != "" { data["link"] = e.link }
+    n.s.fireWebhooks(ctx, webhookEvent{Event: hookEvent(e.kind), Actor: e.files[0].ContributedBy, Track: e.track, Data: data})
+    return nil
+}
+
+// GET    /api/webhooks
+// POST   /api/webhooks {"url":"https://ci.example.com/avcs","events":["status","new-*"][,"description":"..."]}
+// GET    /api/webhooks/{id}
+// PATCH  /api/webhooks/{id} {"active":false}
+// DELETE /api/webhooks/{id}
+// GET    /api/webhooks/{id}/deliveries   newest first
+// POST   /api/webhooks/{id}/test         sends a ping
+func (s *Server) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+    rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/webhooks"), "/")
+    id, sub, _ := strings.Cut(rest, "/")
+    if id == "" {
+        switch r.Method {
+        case http.MethodGet:
+            out := []Webhook{}
+            s.store.view(func(d *storeData) {
+                for _, h := range d.Webhooks { out = append(out, h.public()) }
+            })
+            sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+            writeJSON(w, out)
+
+        case http.MethodPost:
+            var in webhookInput
+            if err := json.NewDecoder(r.Body).Decode(&in); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+            h := Webhook{ID: newID(), Active: true, By: actorOf(r), Created: time.Now().UTC()}
+            if err := in.apply(&h); err != nil { writeError(w, err); return }
+            b := make([]byte, 24)
+            rand.Read(b)
+            h.Secret = "whsec_" + hex.EncodeToString(b)
+            err := s.store.update(func(d *storeData) error {
+                if d.Webhooks == nil { d.Webhooks = map[string]*Webhook{} }
+                if d.Webhooks[h.ID] != nil { return httpError{409, "webhook ID collision; try again"} }
+                d.Webhooks[h.ID] = &h
+                return nil
+            })
+            if err != nil { writeError(w, err); return }
+            s.audit(r, "webhook-create", "", nil, h.public())
+            writeJSONStatus(w, http.StatusCreated, map[string]any{"secret": h.Secret, "info": h.public()})
+
+        default:
+            http.Error(w, "GET or POST required", 405)
+        }
+        return
+    }
+
+    var h *Webhook
+    s.store.view(func(d *storeData) {
+        if cur := d.Webhooks[id]; cur != nil { c := *cur; h = &c }
+    })
+    if h == nil { http.Error(w, "webhook not found", 404); return }
+    switch {
+    case sub == "deliveries" && r.Method == http.MethodGet:
+        out := []Delivery{}
+        s.store.view(func(d *storeData) { out = append(out, d.WebhookLog[id]...) })
+        slices.Reverse(out)
+        writeJSON(w, out)
+
+    case sub == "test" && r.Method == http.MethodPost:
+        e := webhookEvent{ID: newID(), Event: "ping", Time: time.Now().UTC(), Actor: actorOf(r), Data: map[string]any{"webhook": h.public()}}
+        go runJob(context.WithoutCancel(r.Context()), "webhook", func(ctx context.Context) { s.deliver(ctx, *h, e) })
+        writeJSONStatus(w, http.StatusAccepted, map[string]any{"delivery": e.ID})
+
+    case sub != "":
+        http.Error(w, "not found", 404)
+
+    case r.Method == http.MethodGet:
+        writeJSON(w, h.public())
+
+    case r.Method == http.MethodPatch:
+        var in webhookInput
+        if err := json.NewDecoder(r.Body).Decode(&in); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        before := h.public()
+        if err := in.apply(h); err != nil { writeError(w, err); return }
+        err := s.store.update(func(d *storeData) error {
+            if d.Webhooks[id] == nil { return httpError{404, "webhook not found"} }
+            d.Webhooks[id] = h
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "webhook-update", "", before, h.public())
+        writeJSON(w, h.public())
+
+    case r.Method == http.MethodDelete:
+        err := s.store.update(func(d *storeData) error {
+            delete(d.Webhooks, id)
+            delete(d.WebhookLog, id)
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "webhook-delete", "", h.public(), nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        http.Error(w, "GET, PATCH or DELETE required", 405)
+    }
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+
+    "avcs-browser/client"
+)
+
+// ====== Status Workflow ======
+
+const defaultStages = "writing,production,mixdown,mastering,released"
+
+// workflow is the ordered set of track stages and the moves allowed between them.
+type workflow struct {
+    Stages      []string            `json:"stages"`
+    Transitions map[string][]string `json:"transitions"`
+}
+
+// parseWorkflow reads STATUS_FLOW ("a,b,c") and STATUS_TRANSITIONS ("a>b,b>a,...").
+// Without explicit transitions a track may move one stage forward or back.
+func parseWorkflow(stages, transitions string) (*workflow, error) {
+    if strings.TrimSpace(stages) == "" { stages = defaultStages }
+    wf := &workflow{Transitions: map[string][]string{}}
+    for _, st := range strings.Split(stages, ",") {
+        st = strings.ToLower(strings.TrimSpace(st))
+        if st == "" { continue }
+        if slices.Contains(wf.Stages, st) { return nil, fmt.Errorf("duplicate stage %q", st) }
+        wf.Stages = append(wf.Stages, st)
+    }
+    if strings.TrimSpace(transitions) == "" {
+        for i, st := range wf.Stages {
+            if i > 0 { wf.Transitions[st] = append(wf.Transitions[st], wf.Stages[i-1]) }
+            if i < len(wf.Stages)-1 { wf.Transitions[st] = append(wf.Transitions[st], wf.Stages[i+1]) }
+        }
+        return wf, nil
+    }
+    for _, tr := range strings.Split(transitions, ",") {
+        from, to, ok := strings.Cut(strings.ToLower(strings.TrimSpace(tr)), ">")
+        from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+        if !ok || !slices.Contains(wf.Stages, from) || !slices.Contains(wf.Stages, to) {
+            return nil, fmt.Errorf("bad transition %q", tr)
+        }
+        wf.Transitions[from] = append(wf.Transitions[from], to)
+    }
+    return wf, nil
+}
+
+func (wf *workflow) allowed(from, to string) bool { return slices.Contains(wf.Transitions[from], to) }
+
+type (
+    StatusChange = client.StatusChange
+    TrackStatus  = client.TrackStatus
+)
+
+// statusOf returns the stored status, or the first stage for tracks never moved.
+func (s *Server) statusOf(track string) TrackStatus {
+    st := TrackStatus{Status: s.flow.Stages[0]}
+    s.store.view(func(d *storeData) {
+        if cur := d.Status[track]; cur != nil {
+            st = *cur
+            st.History = append([]StatusChange(nil), cur.History...)
+        }
+    })
+    return st
+}
+
+// GET  /api/tracks/{name}/status
+// POST /api/tracks/{name}/status {"status":"mixdown","note":"..."}
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request, t *Track) {
+    switch r.Method {
+    case http.MethodGet:
+        st := s.statusOf(t.Name)
+        writeJSON(w, map[string]any{"status": st, "allowed": s.flow.Transitions[st.Status]})
+
+    case http.MethodPost:
+        var req struct {
+            Status string `json:"status"`
+            Note   string `json:"note"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "bad json: "+err.Error(), 400); return }
+        to := strings.ToLower(strings.TrimSpace(req.Status))
+        if !slices.Contains(s.flow.Stages, to) { http.Error(w, "unknown status "+req.Status, 400); return }
+        var out TrackStatus
+        var from string
+        err := s.store.update(func(d *storeData) error {
+            cur := d.Status[t.Name]
+            if cur == nil { cur = &TrackStatus{Status: s.flow.Stages[0]} }
+            if !s.flow.allowed(cur.Status, to) {
+                return httpError{409, fmt.Sprintf("transition %s -> %s not allowed", cur.Status, to)}
+            }
+            from = cur.Status
+            ch := StatusChange{From: cur.Status, To: to, At: time.Now().UTC(), By: actorOf(r), Note: strings.TrimSpace(req.Note)}
+            next := &TrackStatus{Status: to, Since: ch.At, By: ch.By, History: append(cur.History, ch)}
+            if d.Status == nil { d.Status = map[string]*TrackStatus{} }
+            d.Status[t.Name] = next
+            out = *next
+            return nil
+        })
+        if err != nil { writeError(w, err); return }
+        s.audit(r, "status", t.Name, from, out.History[len(out.History)-1])
+        writeJSON(w, out)
+
+    default:
+        http.Error(w, "GET or POST required", 405)
+    }
+}
+
+// handleWorkflow serves the stage configuration with tracks grouped per stage.
+func (s *Server) handleWorkflow(w http.ResponseWriter, r *http.Request) {
+    board := map[string][]string{}
+    for _, st := range s.flow.Stages { board[st] = []string{} }
+    s.mu.RLock()
+    names := make([]string, 0, len(s.tracks))
+    for name := range s.tracks { names = append(names, name) }
+    s.mu.RUnlock()
+    names = slices.DeleteFunc(names, func(name string) bool { return !s.mayAccess(r, name) })
+    sort.Strings(names)
+    for _, name := range names {
+        st := s.statusOf(name).Status
+        board[st] = append(board[st], name)
+    }
+    writeJSON(w, map[string]any{"stages": s.flow.Stages, "transitions": s.flow.Transitions, "board": board})
+}